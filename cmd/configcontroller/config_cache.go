@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+
+	pb "eidc-tfk8s/pkg/api/protobuf"
+)
+
+var configSerializationsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "cc_config_serializations_total",
+	Help: "Total number of GetConfig responses serialized, as opposed to served from the cache",
+})
+
+// configCache holds the GetConfig response of each FB for the current
+// generation along with its wire encoding, so that the instances of an FB
+// starting at once during a rollout share a single serialization
+type configCache struct {
+	mu         sync.Mutex
+	generation int64
+	responses  map[string]*pb.ConfigResponse
+	encodings  map[*pb.ConfigResponse][]byte
+}
+
+// newConfigCache creates an empty cache
+func newConfigCache() *configCache {
+	return &configCache{
+		responses: make(map[string]*pb.ConfigResponse),
+		encodings: make(map[*pb.ConfigResponse][]byte),
+	}
+}
+
+// get returns the response for an FB at the given generation, building and
+// serializing it if it isn't cached. A new generation invalidates the cache.
+// Concurrent calls wait for the first to serialize the response.
+func (c *configCache) get(fbID string, generation int64, config *pb.PipelineConfig) (*pb.ConfigResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		c.generation = generation
+		c.responses = make(map[string]*pb.ConfigResponse)
+		c.encodings = make(map[*pb.ConfigResponse][]byte)
+	}
+
+	if resp, ok := c.responses[fbID]; ok {
+		return resp, nil
+	}
+
+	resp := &pb.ConfigResponse{
+		Status:         0,
+		Generation:     generation,
+		PipelineConfig: config,
+	}
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize config: %w", err)
+	}
+	configSerializationsTotal.Inc()
+
+	c.responses[fbID] = resp
+	c.encodings[resp] = data
+	return resp, nil
+}
+
+// encoding returns the cached wire encoding of a response returned by get
+func (c *configCache) encoding(resp *pb.ConfigResponse) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, ok := c.encodings[resp]
+	return data, ok
+}
+
+// cachingCodec is the protobuf codec of the config server. Responses served
+// from the config cache are written with their cached encoding.
+type cachingCodec struct {
+	cache *configCache
+}
+
+var _ encoding.Codec = cachingCodec{}
+
+// Marshal implements encoding.Codec
+func (c cachingCodec) Marshal(v interface{}) ([]byte, error) {
+	if resp, ok := v.(*pb.ConfigResponse); ok {
+		if data, ok := c.cache.encoding(resp); ok {
+			return data, nil
+		}
+	}
+
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to marshal: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(message)
+}
+
+// Unmarshal implements encoding.Codec
+func (c cachingCodec) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to unmarshal: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+// Name implements encoding.Codec
+func (c cachingCodec) Name() string {
+	return "proto"
+}
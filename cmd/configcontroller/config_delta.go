@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	pb "eidc-tfk8s/pkg/api/protobuf"
+)
+
+var (
+	configDeltaPushesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cc_stream_delta_push_total",
+		Help: "Total number of config stream pushes sent as a delta from the instance's acked generation",
+	})
+
+	configMergePatchPushesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cc_stream_merge_patch_push_total",
+		Help: "Total number of config stream pushes sent as a merge patch of the FB's parameters from the instance's acked generation",
+	})
+)
+
+// configDelta returns the JSON merge patch (RFC 7386) that turns the JSON
+// encoding of base into that of target
+func configDelta(base, target *pb.PipelineConfig) ([]byte, error) {
+	baseJSON, err := protojson.Marshal(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode base config: %w", err)
+	}
+	targetJSON, err := protojson.Marshal(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode target config: %w", err)
+	}
+
+	patch, err := jsonpatch.CreateMergePatch(baseJSON, targetJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute config delta: %w", err)
+	}
+	return patch, nil
+}
+
+// deltaResponse returns resp as a delta from the given base generation, or
+// false when the base is no longer in the config history and the full config
+// has to be sent. Must be called with configMu held.
+func (c *ConfigController) deltaResponse(resp *pb.ConfigResponse, base int64) (*pb.ConfigResponse, bool) {
+	if base <= 0 || base >= resp.Generation {
+		return nil, false
+	}
+
+	entry, ok := c.history.get(base)
+	if !ok {
+		return nil, false
+	}
+
+	patch, err := configDelta(entry.config, resp.PipelineConfig)
+	if err != nil {
+		c.logger.Printf(`{"level":"warn","timestamp":"%s","message":"Failed to compute config delta, sending full config","base_generation":%d,"generation":%d,"error":"%s"}`,
+			time.Now().Format(time.RFC3339), base, resp.Generation, err)
+		return nil, false
+	}
+
+	return &pb.ConfigResponse{
+		Status:          resp.Status,
+		Generation:      resp.Generation,
+		RequiresRestart: resp.RequiresRestart,
+		ConfigPatch:     patch,
+		BaseGeneration:  base,
+	}, true
+}
+
+// mergePatchResponse returns resp for the FB fbID with its parameters as a
+// JSON merge patch from those of the given base generation, for the FB to
+// merge onto the config it applied. It returns false when the base is no
+// longer in the config history or either config lacks the FB's parameters,
+// and the full config has to be sent. Must be called with configMu held.
+func (c *ConfigController) mergePatchResponse(resp *pb.ConfigResponse, fbID string, base int64) (*pb.ConfigResponse, bool) {
+	if base <= 0 || base >= resp.Generation {
+		return nil, false
+	}
+
+	entry, ok := c.history.get(base)
+	if !ok {
+		return nil, false
+	}
+
+	baseFB, ok := entry.config.FunctionBlocks[fbID]
+	if !ok {
+		return nil, false
+	}
+	targetFB, ok := resp.PipelineConfig.FunctionBlocks[fbID]
+	if !ok || len(baseFB.Parameters) == 0 || len(targetFB.Parameters) == 0 {
+		return nil, false
+	}
+
+	patch, err := jsonpatch.CreateMergePatch(baseFB.Parameters, targetFB.Parameters)
+	if err != nil {
+		c.logger.Printf(`{"level":"warn","timestamp":"%s","message":"Failed to compute config merge patch, sending full config","fb_id":"%s","base_generation":%d,"generation":%d,"error":"%s"}`,
+			time.Now().Format(time.RFC3339), fbID, base, resp.Generation, err)
+		return nil, false
+	}
+
+	// Only the receiving FB's config is sent, with its patched parameters
+	patched := proto.Clone(targetFB).(*pb.FBConfig)
+	patched.Parameters = patch
+	return &pb.ConfigResponse{
+		Status:     resp.Status,
+		Generation: resp.Generation,
+		PipelineConfig: &pb.PipelineConfig{
+			Generation:      resp.PipelineConfig.Generation,
+			PipelineVersion: resp.PipelineConfig.PipelineVersion,
+			GlobalSettings:  resp.PipelineConfig.GlobalSettings,
+			FunctionBlocks:  map[string]*pb.FBConfig{fbID: patched},
+		},
+		RequiresRestart: resp.RequiresRestart,
+		BaseGeneration:  base,
+		MergePatch:      true,
+	}, true
+}
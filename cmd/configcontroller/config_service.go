@@ -3,36 +3,99 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/status"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 
 	pb "eidc-tfk8s/pkg/api/protobuf"
 )
 
+// Metrics
+var (
+	configPushesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cc_config_pushes_total",
+		Help: "Total number of configuration pushes",
+	})
+	configAcksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cc_config_acks_total",
+		Help: "Total number of configuration acknowledgments",
+	}, []string{"status"})
+	configStreamTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cc_stream_total",
+		Help: "Total number of config stream connections",
+	})
+	configStreamActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cc_stream_active",
+		Help: "Number of active config stream connections",
+	})
+	configStreamPushTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cc_stream_push_total",
+		Help: "Total number of config stream pushes",
+	})
+	configGeneration = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cc_config_generation",
+		Help: "Current configuration generation",
+	})
+	configPropagationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cc_config_propagation_seconds",
+		Help:    "Time from broadcasting a configuration generation to a function block instance acking it",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"fb_name"})
+)
+
+// defaultStatusDebounce is how long AckConfig waits before patching the CRD
+// status, so that acks from many instances result in a single patch
+const defaultStatusDebounce = 2 * time.Second
+
 // ConfigController implements the ConfigService gRPC service
 type ConfigController struct {
 	pb.UnimplementedConfigServiceServer
 	logger *log.Logger
 	
 	// K8s client
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
 	namespace string
+
+	// Whether this replica holds the leader lease; followers don't serve config
+	leader atomic.Bool
 	
 	// Configuration tracking
 	configMu          sync.RWMutex
 	currentGeneration int64
 	pipelineConfig    *pb.PipelineConfig
+	history           *configHistory
+	configCache       *configCache
 	
 	// Connected clients tracking
 	clientsMu sync.RWMutex
 	clients   map[string]map[string]*connectedClient // Map of fb_id -> instance_id -> client
+
+	// CRD status reporting
+	statusMu       sync.Mutex
+	dynamicClient  dynamic.Interface
+	resourceGVR    schema.GroupVersionResource
+	crdName        string
+	statusDebounce time.Duration
+	statusTimer    *time.Timer
+
+	// Probes FB instances for the pipeline health summary
+	prober fbProber
 }
 
 // connectedClient tracks a connected function block instance
@@ -42,16 +105,69 @@ type connectedClient struct {
 	stream      pb.ConfigService_StreamConfigServer
 	lastUpdated time.Time
 	genAcked    int64
+
+	// supportsDelta is set when the instance accepts updates as a delta from
+	// its acked generation
+	supportsDelta bool
+
+	// supportsMergePatch is set when the instance merges updates of its
+	// parameters onto the config it applied. It is preferred to deltas.
+	supportsMergePatch bool
+
+	// propagationLag is how long the last generation acked by the instance
+	// took to reach it after being broadcast
+	propagationLag time.Duration
 }
 
 // NewConfigController creates a new ConfigController
-func NewConfigController(logger *log.Logger, clientset *kubernetes.Clientset, namespace string) *ConfigController {
+func NewConfigController(logger *log.Logger, clientset kubernetes.Interface, namespace string) *ConfigController {
 	return &ConfigController{
 		logger:    logger,
 		clientset: clientset,
 		namespace: namespace,
 		clients:   make(map[string]map[string]*connectedClient),
+		history:   newConfigHistory(defaultHistorySize),
+
+		configCache: newConfigCache(),
+
+		statusDebounce: defaultStatusDebounce,
+	}
+}
+
+// SetStatusClient sets the dynamic client used to patch the CRD status subresource
+func (c *ConfigController) SetStatusClient(dynamicClient dynamic.Interface, resourceGVR schema.GroupVersionResource) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.dynamicClient = dynamicClient
+	c.resourceGVR = resourceGVR
+}
+
+// SetCRDName sets the name of the NRDotPlusPipeline whose config is being served
+func (c *ConfigController) SetCRDName(crdName string) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.crdName = crdName
+}
+
+// SetLeader records whether this replica is the elected leader
+func (c *ConfigController) SetLeader(leader bool) {
+	c.leader.Store(leader)
+}
+
+// IsLeader returns whether this replica is the elected leader
+func (c *ConfigController) IsLeader() bool {
+	return c.leader.Load()
+}
+
+// checkLeader returns an Unavailable error on followers, which have not
+// started the CRD watcher and would otherwise serve stale or empty config
+func (c *ConfigController) checkLeader() error {
+	if !c.IsLeader() {
+		return status.Errorf(codes.Unavailable, "config controller replica is not the leader")
 	}
+	return nil
 }
 
 // GetConfig implements the GetConfig method of the ConfigService
@@ -59,18 +175,34 @@ func (c *ConfigController) GetConfig(ctx context.Context, req *pb.ConfigRequest)
 	c.logger.Printf(`{"level":"info","timestamp":"%s","message":"GetConfig request","fb_id":"%s","instance_id":"%s","current_generation":%d}`,
 		time.Now().Format(time.RFC3339), req.FbId, req.InstanceId, req.CurrentGeneration)
 	
+	if err := c.checkLeader(); err != nil {
+		return nil, err
+	}
+	
 	c.configMu.RLock()
-	defer c.configMu.RUnlock()
+	currentConfig := c.pipelineConfig
+	currentGen := c.currentGeneration
+	c.configMu.RUnlock()
 	
-	if c.pipelineConfig == nil {
+	if currentConfig == nil {
 		return nil, status.Errorf(codes.Unavailable, "configuration not yet loaded")
 	}
 	
-	return &pb.ConfigResponse{
-		Status:       0,
-		Generation:   c.currentGeneration,
-		PipelineConfig: c.pipelineConfig,
-	}, nil
+	// Instances of an FB starting together share the serialized response
+	resp, err := c.configCache.get(req.FbId, currentGen, currentConfig)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	
+	configPushesTotal.Inc()
+
+	return resp, nil
+}
+
+// Codec returns the codec the config server must use, so GetConfig responses
+// are written with their cached encoding
+func (c *ConfigController) Codec() encoding.Codec {
+	return cachingCodec{cache: c.configCache}
 }
 
 // StreamConfig implements the StreamConfig method of the ConfigService
@@ -78,6 +210,14 @@ func (c *ConfigController) StreamConfig(req *pb.ConfigRequest, stream pb.ConfigS
 	c.logger.Printf(`{"level":"info","timestamp":"%s","message":"StreamConfig connected","fb_id":"%s","instance_id":"%s","current_generation":%d}`,
 		time.Now().Format(time.RFC3339), req.FbId, req.InstanceId, req.CurrentGeneration)
 	
+	if err := c.checkLeader(); err != nil {
+		return err
+	}
+	
+	configStreamTotal.Inc()
+	configStreamActive.Inc()
+	defer configStreamActive.Dec()
+	
 	// Register client
 	client := &connectedClient{
 		fbID:        req.FbId,
@@ -85,6 +225,9 @@ func (c *ConfigController) StreamConfig(req *pb.ConfigRequest, stream pb.ConfigS
 		stream:      stream,
 		lastUpdated: time.Now(),
 		genAcked:    req.CurrentGeneration,
+
+		supportsDelta:      req.SupportsDelta,
+		supportsMergePatch: req.SupportsMergePatch,
 	}
 	
 	c.clientsMu.Lock()
@@ -94,18 +237,36 @@ func (c *ConfigController) StreamConfig(req *pb.ConfigRequest, stream pb.ConfigS
 	c.clients[req.FbId][req.InstanceId] = client
 	c.clientsMu.Unlock()
 	
-	// Send initial config
+	// Send initial config, prepared under the config lock as it is read
+	// from the history
 	c.configMu.RLock()
 	currentConfig := c.pipelineConfig
 	currentGen := c.currentGeneration
+	var resp *pb.ConfigResponse
+	if currentConfig != nil && currentGen > req.CurrentGeneration {
+		resp = &pb.ConfigResponse{
+			Status:          0,
+			Generation:      currentGen,
+			PipelineConfig:  currentConfig,
+			RequiresRestart: requiresRestart(req.FbId, c.ackedConfig(req.CurrentGeneration, nil), currentConfig),
+		}
+		if client.supportsMergePatch {
+			if patch, ok := c.mergePatchResponse(resp, req.FbId, req.CurrentGeneration); ok {
+				resp = patch
+				configMergePatchPushesTotal.Inc()
+			}
+		}
+		if client.supportsDelta && !resp.MergePatch {
+			if delta, ok := c.deltaResponse(resp, req.CurrentGeneration); ok {
+				resp = delta
+				configDeltaPushesTotal.Inc()
+			}
+		}
+	}
 	c.configMu.RUnlock()
 	
-	if currentConfig != nil && currentGen > req.CurrentGeneration {
-		if err := stream.Send(&pb.ConfigResponse{
-			Status:       0,
-			Generation:   currentGen,
-			PipelineConfig: currentConfig,
-		}); err != nil {
+	if resp != nil {
+		if err := stream.Send(resp); err != nil {
 			c.logger.Printf(`{"level":"error","timestamp":"%s","message":"Failed to send initial config","fb_id":"%s","instance_id":"%s","error":"%s"}`,
 				time.Now().Format(time.RFC3339), req.FbId, req.InstanceId, err)
 			
@@ -121,6 +282,7 @@ func (c *ConfigController) StreamConfig(req *pb.ConfigRequest, stream pb.ConfigS
 			
 			return err
 		}
+		configStreamPushTotal.Inc()
 	}
 	
 	// Keep connection open until client disconnects or context is cancelled
@@ -147,17 +309,44 @@ func (c *ConfigController) AckConfig(ctx context.Context, req *pb.ConfigAckReque
 	c.logger.Printf(`{"level":"info","timestamp":"%s","message":"AckConfig","fb_id":"%s","instance_id":"%s","applied_generation":%d,"success":%t}`,
 		time.Now().Format(time.RFC3339), req.FbId, req.InstanceId, req.AppliedGeneration, req.Success)
 	
-	// Update client's acked generation
+	if err := c.checkLeader(); err != nil {
+		return nil, err
+	}
+	
+	if req.Success {
+		configAcksTotal.WithLabelValues("success").Inc()
+	} else {
+		configAcksTotal.WithLabelValues("failure").Inc()
+	}
+	
+	// Look up when the acked generation was broadcast
+	var broadcastAt time.Time
+	if req.Success {
+		c.configMu.RLock()
+		if entry, ok := c.history.get(req.AppliedGeneration); ok {
+			broadcastAt = entry.appliedAt
+		}
+		c.configMu.RUnlock()
+	}
+	
+	// Update client's acked generation, measuring the propagation lag of
+	// generations it hasn't acked before
 	c.clientsMu.Lock()
 	if fbClients, exists := c.clients[req.FbId]; exists {
 		if client, exists := fbClients[req.InstanceId]; exists {
+			now := time.Now()
+			if !broadcastAt.IsZero() && req.AppliedGeneration > client.genAcked {
+				client.propagationLag = now.Sub(broadcastAt)
+				configPropagationSeconds.WithLabelValues(req.FbId).Observe(client.propagationLag.Seconds())
+			}
 			client.genAcked = req.AppliedGeneration
-			client.lastUpdated = time.Now()
+			client.lastUpdated = now
 		}
 	}
 	c.clientsMu.Unlock()
 	
-	// TODO: Update CRD status subresource with applied generation and FB status
+	// Update CRD status subresource with applied generation and FB status
+	c.scheduleStatusUpdate()
 	
 	return &pb.ConfigAckResponse{
 		Status: 0,
@@ -166,21 +355,26 @@ func (c *ConfigController) AckConfig(ctx context.Context, req *pb.ConfigAckReque
 
 // BroadcastConfig sends a configuration update to all connected clients
 func (c *ConfigController) BroadcastConfig(newConfig *pb.PipelineConfig, generation int64) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	
+	c.broadcastConfigLocked(newConfig, generation)
+}
+
+// broadcastConfigLocked makes newConfig the current config and sends it to
+// all connected clients. Must be called with configMu held for writing, so
+// that the generation it is broadcast under can be allocated atomically
+// with it.
+func (c *ConfigController) broadcastConfigLocked(newConfig *pb.PipelineConfig, generation int64) {
 	c.logger.Printf(`{"level":"info","timestamp":"%s","message":"Broadcasting new config","generation":%d}`,
 		time.Now().Format(time.RFC3339), generation)
 	
 	// Update current config
-	c.configMu.Lock()
+	oldConfig := c.pipelineConfig
 	c.pipelineConfig = newConfig
 	c.currentGeneration = generation
-	c.configMu.Unlock()
-	
-	// Prepare response
-	resp := &pb.ConfigResponse{
-		Status:       0,
-		Generation:   generation,
-		PipelineConfig: newConfig,
-	}
+	c.history.add(generation, newConfig)
+	configGeneration.Set(float64(generation))
 	
 	// Send to all clients
 	c.clientsMu.RLock()
@@ -188,16 +382,69 @@ func (c *ConfigController) BroadcastConfig(newConfig *pb.PipelineConfig, generat
 	
 	var clientSendErrors int
 	for fbID, fbClients := range c.clients {
+		// Prepare a response per acked generation, flagging changes since
+		// that generation the FB can't hot-swap
+		responses := make(map[int64]*pb.ConfigResponse)
+
+		// Instances that support merge patches or deltas get one from their
+		// acked generation, computed once per base generation
+		patches := make(map[int64]*pb.ConfigResponse)
+		deltas := make(map[int64]*pb.ConfigResponse)
+		
 		for instanceID, client := range fbClients {
 			// Skip if client already has this or newer generation
 			if client.genAcked >= generation {
 				continue
 			}
 			
-			if err := client.stream.Send(resp); err != nil {
+			resp, ok := responses[client.genAcked]
+			if !ok {
+				resp = &pb.ConfigResponse{
+					Status:          0,
+					Generation:      generation,
+					PipelineConfig:  newConfig,
+					RequiresRestart: requiresRestart(fbID, c.ackedConfig(client.genAcked, oldConfig), newConfig),
+				}
+				if resp.RequiresRestart {
+					c.logger.Printf(`{"level":"info","timestamp":"%s","message":"Config change requires restart","fb_id":"%s","generation":%d,"acked_generation":%d}`,
+						time.Now().Format(time.RFC3339), fbID, generation, client.genAcked)
+				}
+				responses[client.genAcked] = resp
+			}
+			
+			clientResp := resp
+			if client.supportsMergePatch {
+				patch, ok := patches[client.genAcked]
+				if !ok {
+					patch, _ = c.mergePatchResponse(resp, fbID, client.genAcked)
+					patches[client.genAcked] = patch
+				}
+				if patch != nil {
+					clientResp = patch
+				}
+			}
+			if client.supportsDelta && clientResp == resp {
+				delta, ok := deltas[client.genAcked]
+				if !ok {
+					delta, _ = c.deltaResponse(resp, client.genAcked)
+					deltas[client.genAcked] = delta
+				}
+				if delta != nil {
+					clientResp = delta
+				}
+			}
+			
+			if err := client.stream.Send(clientResp); err != nil {
 				c.logger.Printf(`{"level":"error","timestamp":"%s","message":"Failed to send config update","fb_id":"%s","instance_id":"%s","error":"%s"}`,
 					time.Now().Format(time.RFC3339), fbID, instanceID, err)
 				clientSendErrors++
+				continue
+			}
+			configStreamPushTotal.Inc()
+			if clientResp.MergePatch {
+				configMergePatchPushesTotal.Inc()
+			} else if clientResp != resp {
+				configDeltaPushesTotal.Inc()
 			}
 		}
 	}
@@ -218,10 +465,11 @@ func (c *ConfigController) GetClientStatus() map[string][]map[string]interface{}
 		
 		for instanceID, client := range fbClients {
 			fbStatus = append(fbStatus, map[string]interface{}{
-				"instance_id":   instanceID,
-				"gen_acked":     client.genAcked,
-				"last_updated":  client.lastUpdated.Format(time.RFC3339),
-				"age_seconds":   int(time.Since(client.lastUpdated).Seconds()),
+				"instance_id":         instanceID,
+				"gen_acked":           client.genAcked,
+				"last_updated":        client.lastUpdated.Format(time.RFC3339),
+				"age_seconds":         int(time.Since(client.lastUpdated).Seconds()),
+				"propagation_seconds": client.propagationLag.Seconds(),
 			})
 		}
 		
@@ -231,14 +479,181 @@ func (c *ConfigController) GetClientStatus() map[string][]map[string]interface{}
 	return status
 }
 
+// ClientsHandler serves the client debug endpoint. GET /debug/clients
+// returns GetClientStatus as JSON, each FB's instances sorted by instance id,
+// to follow the convergence of a rollout.
+func (c *ConfigController) ClientsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		clientStatus := c.GetClientStatus()
+		for _, instances := range clientStatus {
+			sort.Slice(instances, func(i, j int) bool {
+				return instances[i]["instance_id"].(string) < instances[j]["instance_id"].(string)
+			})
+		}
+
+		json.NewEncoder(w).Encode(clientStatus)
+	}
+}
+
+// scheduleStatusUpdate patches the CRD status after the debounce interval.
+// Calls made while an update is already pending are coalesced into it.
+func (c *ConfigController) scheduleStatusUpdate() {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	if c.dynamicClient == nil || c.crdName == "" || c.statusTimer != nil {
+		return
+	}
+
+	c.statusTimer = time.AfterFunc(c.statusDebounce, func() {
+		c.statusMu.Lock()
+		c.statusTimer = nil
+		crdName := c.crdName
+		c.statusMu.Unlock()
+
+		if err := c.UpdateCRDStatus(crdName); err != nil {
+			c.logger.Printf(`{"level":"error","timestamp":"%s","message":"Failed to update CRD status","crd":"%s","error":"%s"}`,
+				time.Now().Format(time.RFC3339), crdName, err)
+		}
+	})
+}
+
+// buildCRDStatus builds the status subresource from the connected client state
+func (c *ConfigController) buildCRDStatus() map[string]interface{} {
+	c.configMu.RLock()
+	observedGeneration := c.currentGeneration
+	c.configMu.RUnlock()
+
+	clientStatus := c.GetClientStatus()
+
+	// Sort FB names so the patch is deterministic
+	fbIDs := make([]string, 0, len(clientStatus))
+	for fbID := range clientStatus {
+		fbIDs = append(fbIDs, fbID)
+	}
+	sort.Strings(fbIDs)
+
+	// The applied generation is the lowest generation acked by any instance
+	var configGenerationApplied int64 = -1
+	fbStatus := make([]interface{}, 0, len(fbIDs))
+	allReady := len(fbIDs) > 0
+	slowestFB := ""
+	var slowestPropagation float64
+	for _, fbID := range fbIDs {
+		var fbGeneration int64 = -1
+		lastUpdated := ""
+		var propagation float64
+		for _, instance := range clientStatus[fbID] {
+			genAcked, _ := instance["gen_acked"].(int64)
+			if fbGeneration < 0 || genAcked < fbGeneration {
+				fbGeneration = genAcked
+			}
+			if updated, _ := instance["last_updated"].(string); updated > lastUpdated {
+				lastUpdated = updated
+			}
+			if seconds, _ := instance["propagation_seconds"].(float64); seconds > propagation {
+				propagation = seconds
+			}
+		}
+		if propagation > slowestPropagation {
+			slowestFB = fbID
+			slowestPropagation = propagation
+		}
+		if fbGeneration < 0 {
+			fbGeneration = 0
+		}
+		if configGenerationApplied < 0 || fbGeneration < configGenerationApplied {
+			configGenerationApplied = fbGeneration
+		}
+
+		ready := fbGeneration >= observedGeneration
+		if !ready {
+			allReady = false
+		}
+
+		fbStatus = append(fbStatus, map[string]interface{}{
+			"name":               fbID,
+			"ready":              ready,
+			"configApplied":      ready,
+			"configGeneration":   fbGeneration,
+			"instances":          len(clientStatus[fbID]),
+			"lastTransitionTime": lastUpdated,
+			"propagationSeconds": propagation,
+		})
+	}
+	if configGenerationApplied < 0 {
+		configGenerationApplied = 0
+	}
+
+	status := map[string]interface{}{
+		"observedGeneration":      observedGeneration,
+		"servedGeneration":        observedGeneration,
+		"configGenerationApplied": configGenerationApplied,
+		"fbStatus":                fbStatus,
+		"conditions": []interface{}{
+			map[string]interface{}{
+				"type":               "Ready",
+				"status":             iff(allReady, "True", "False"),
+				"lastTransitionTime": time.Now().Format(time.RFC3339),
+				"reason":             iff(allReady, "AllFBsReady", "NotAllFBsReady"),
+				"message":            iff(allReady, "All function blocks are ready", "Not all function blocks are ready"),
+			},
+		},
+	}
+
+	// Report the FB slowest to pick up its last generation
+	if slowestFB != "" {
+		status["slowestFB"] = map[string]interface{}{
+			"name":               slowestFB,
+			"propagationSeconds": slowestPropagation,
+		}
+	}
+
+	return status
+}
+
 // UpdateCRDStatus updates the status subresource of the NRDotPlusPipeline CRD
 func (c *ConfigController) UpdateCRDStatus(crdName string) error {
-	// TODO: Get current status
-	// TODO: Update with client status
-	// TODO: Update observedGeneration
-	// TODO: Update configGenerationApplied
-	// TODO: Update per-FB status
-	
-	return fmt.Errorf("not implemented yet")
-}
+	c.statusMu.Lock()
+	dynamicClient := c.dynamicClient
+	resourceGVR := c.resourceGVR
+	c.statusMu.Unlock()
+
+	if dynamicClient == nil {
+		return fmt.Errorf("dynamic client not configured")
+	}
 
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": c.buildCRDStatus(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal status patch: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = dynamicClient.Resource(resourceGVR).Namespace(c.namespace).Patch(
+		ctx,
+		crdName,
+		types.MergePatchType,
+		patch,
+		metav1.PatchOptions{},
+		"status",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to patch status: %w", err)
+	}
+
+	c.logger.Printf(`{"level":"info","timestamp":"%s","message":"CRD status updated","crd":"%s"}`,
+		time.Now().Format(time.RFC3339), crdName)
+
+	return nil
+}
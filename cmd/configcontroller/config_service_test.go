@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"eidc-tfk8s/internal/config"
+	pb "eidc-tfk8s/pkg/api/protobuf"
+)
+
+var testPipelineGVR = schema.GroupVersionResource{
+	Group:    "nrdot.newrelic.com",
+	Version:  "v1",
+	Resource: "nrdotpluspipelines",
+}
+
+// newTestStatusClient creates a fake dynamic client holding a single pipeline
+func newTestStatusClient(name, namespace string) *dynamicfake.FakeDynamicClient {
+	pipeline := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "nrdot.newrelic.com/v1",
+			"kind":       "NRDotPlusPipeline",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+			},
+			"spec": map[string]interface{}{},
+		},
+	}
+
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			testPipelineGVR: "NRDotPlusPipelineList",
+		},
+		pipeline,
+	)
+}
+
+// statusPatches returns the decoded status patches recorded by the fake client
+func statusPatches(t *testing.T, client *dynamicfake.FakeDynamicClient) []map[string]interface{} {
+	var patches []map[string]interface{}
+	for _, action := range client.Actions() {
+		patchAction, ok := action.(k8stesting.PatchAction)
+		if !ok || patchAction.GetSubresource() != "status" {
+			continue
+		}
+
+		var patch map[string]interface{}
+		require.NoError(t, json.Unmarshal(patchAction.GetPatch(), &patch))
+		patches = append(patches, patch)
+	}
+	return patches
+}
+
+// newTestConfigController creates a controller that holds leadership
+func newTestConfigController() *ConfigController {
+	c := NewConfigController(log.New(io.Discard, "", 0), nil, "default")
+	c.SetLeader(true)
+	return c
+}
+
+// startTestServer serves the controller over an in-memory listener and returns a client
+func startTestServer(t *testing.T, c *ConfigController) pb.ConfigServiceClient {
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.ForceServerCodec(c.Codec()))
+	pb.RegisterConfigServiceServer(server, c)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewConfigServiceClient(conn)
+}
+
+func TestConfigController_ServesGetConfig(t *testing.T) {
+	c := newTestConfigController()
+	client := startTestServer(t, c)
+
+	req := &pb.ConfigRequest{FbId: "fb-rx", InstanceId: "rx-0"}
+
+	// No config loaded yet
+	_, err := client.GetConfig(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// Serves the broadcast config
+	c.BroadcastConfig(&pb.PipelineConfig{
+		Generation:      7,
+		PipelineVersion: "v1",
+		FunctionBlocks: map[string]*pb.FBConfig{
+			"fb-rx": {Enabled: true, Parameters: []byte(`{"next_fb":"fb-en-host:5000"}`)},
+		},
+	}, 7)
+
+	resp, err := client.GetConfig(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), resp.Generation)
+	assert.Equal(t, "v1", resp.PipelineConfig.PipelineVersion)
+	assert.Equal(t, []byte(`{"next_fb":"fb-en-host:5000"}`), resp.PipelineConfig.FunctionBlocks["fb-rx"].Parameters)
+}
+
+func TestConfigController_GetConfig_SharesSerialization(t *testing.T) {
+	c := newTestConfigController()
+	client := startTestServer(t, c)
+
+	pipelineConfig := &pb.PipelineConfig{
+		Generation: 1,
+		FunctionBlocks: map[string]*pb.FBConfig{
+			"fb-rx": {Enabled: true, Parameters: []byte(`{"next_fb":"fb-en-host:5000"}`)},
+		},
+	}
+	c.BroadcastConfig(pipelineConfig, 1)
+
+	getConfigs := func(fbID string, n int) {
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				resp, err := client.GetConfig(context.Background(), &pb.ConfigRequest{
+					FbId:       fbID,
+					InstanceId: fmt.Sprintf("%s-%d", fbID, i),
+				})
+				if assert.NoError(t, err) {
+					assert.Equal(t, []byte(`{"next_fb":"fb-en-host:5000"}`), resp.PipelineConfig.FunctionBlocks["fb-rx"].Parameters)
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	serializationsBefore := testutil.ToFloat64(configSerializationsTotal)
+
+	// Concurrent requests from the instances of an FB serialize the config once
+	getConfigs("fb-rx", 20)
+	assert.Equal(t, float64(1), testutil.ToFloat64(configSerializationsTotal)-serializationsBefore)
+
+	getConfigs("fb-gw", 5)
+	assert.Equal(t, float64(2), testutil.ToFloat64(configSerializationsTotal)-serializationsBefore)
+
+	// A new generation invalidates the cache
+	pipelineConfig.Generation = 2
+	c.BroadcastConfig(pipelineConfig, 2)
+	resp, err := client.GetConfig(context.Background(), &pb.ConfigRequest{FbId: "fb-rx", InstanceId: "fb-rx-0"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), resp.Generation)
+	assert.Equal(t, float64(3), testutil.ToFloat64(configSerializationsTotal)-serializationsBefore)
+}
+
+func TestConfigController_FollowerReturnsUnavailable(t *testing.T) {
+	c := newTestConfigController()
+	c.BroadcastConfig(&pb.PipelineConfig{Generation: 1}, 1)
+	c.SetLeader(false)
+	client := startTestServer(t, c)
+
+	req := &pb.ConfigRequest{FbId: "fb-rx", InstanceId: "rx-0"}
+
+	_, err := client.GetConfig(context.Background(), req)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	stream, err := client.StreamConfig(context.Background(), req)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	_, err = client.AckConfig(context.Background(), &pb.ConfigAckRequest{
+		FbId:              "fb-rx",
+		InstanceId:        "rx-0",
+		AppliedGeneration: 1,
+		Success:           true,
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// Serves config once leadership is acquired
+	c.SetLeader(true)
+	resp, err := client.GetConfig(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.Generation)
+}
+
+func TestConfigController_UpdateCRDStatus(t *testing.T) {
+	c := newTestConfigController()
+	client := newTestStatusClient("pipeline", "default")
+	c.SetStatusClient(client, testPipelineGVR)
+
+	c.BroadcastConfig(&pb.PipelineConfig{Generation: 3}, 3)
+	c.clients["fb-rx"] = map[string]*connectedClient{
+		"rx-0": {fbID: "fb-rx", instanceID: "rx-0", genAcked: 3, lastUpdated: time.Now()},
+		"rx-1": {fbID: "fb-rx", instanceID: "rx-1", genAcked: 2, lastUpdated: time.Now()},
+	}
+	c.clients["fb-gw"] = map[string]*connectedClient{
+		"gw-0": {fbID: "fb-gw", instanceID: "gw-0", genAcked: 3, lastUpdated: time.Now()},
+	}
+
+	err := c.UpdateCRDStatus("pipeline")
+	require.NoError(t, err)
+
+	patches := statusPatches(t, client)
+	require.Len(t, patches, 1)
+
+	status, ok := patches[0]["status"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, float64(3), status["observedGeneration"])
+	assert.Equal(t, float64(3), status["servedGeneration"])
+	assert.Equal(t, float64(2), status["configGenerationApplied"])
+
+	fbStatus, ok := status["fbStatus"].([]interface{})
+	require.True(t, ok)
+	require.Len(t, fbStatus, 2)
+
+	gw := fbStatus[0].(map[string]interface{})
+	assert.Equal(t, "fb-gw", gw["name"])
+	assert.Equal(t, float64(3), gw["configGeneration"])
+	assert.Equal(t, true, gw["ready"])
+
+	rx := fbStatus[1].(map[string]interface{})
+	assert.Equal(t, "fb-rx", rx["name"])
+	assert.Equal(t, float64(2), rx["configGeneration"])
+	assert.Equal(t, float64(2), rx["instances"])
+	assert.Equal(t, false, rx["ready"])
+}
+
+func TestConfigController_UpdateCRDStatus_NoClient(t *testing.T) {
+	c := newTestConfigController()
+
+	err := c.UpdateCRDStatus("pipeline")
+	assert.Error(t, err)
+}
+
+func TestConfigController_AckConfig_DebouncesStatusUpdate(t *testing.T) {
+	c := newTestConfigController()
+	c.statusDebounce = 50 * time.Millisecond
+	client := newTestStatusClient("pipeline", "default")
+	c.SetStatusClient(client, testPipelineGVR)
+	c.SetCRDName("pipeline")
+
+	c.BroadcastConfig(&pb.PipelineConfig{Generation: 1}, 1)
+	c.clients["fb-rx"] = map[string]*connectedClient{
+		"rx-0": {fbID: "fb-rx", instanceID: "rx-0"},
+		"rx-1": {fbID: "fb-rx", instanceID: "rx-1"},
+	}
+
+	// Several acks within the debounce window result in one patch
+	for _, instanceID := range []string{"rx-0", "rx-1"} {
+		_, err := c.AckConfig(context.Background(), &pb.ConfigAckRequest{
+			FbId:              "fb-rx",
+			InstanceId:        instanceID,
+			AppliedGeneration: 1,
+			Success:           true,
+		})
+		require.NoError(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(statusPatches(t, client)) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	status := statusPatches(t, client)[0]["status"].(map[string]interface{})
+	assert.Equal(t, float64(1), status["configGenerationApplied"])
+}
+
+func TestConfigController_AckConfig_MeasuresPropagationLag(t *testing.T) {
+	c := newTestConfigController()
+	client := newTestStatusClient("pipeline", "default")
+	c.SetStatusClient(client, testPipelineGVR)
+
+	c.BroadcastConfig(&pb.PipelineConfig{Generation: 1}, 1)
+	c.clients["fb-rx"] = map[string]*connectedClient{
+		"rx-0": {fbID: "fb-rx", instanceID: "rx-0"},
+	}
+	c.clients["fb-gw"] = map[string]*connectedClient{
+		"gw-0": {fbID: "fb-gw", instanceID: "gw-0"},
+	}
+
+	ack := func(fbID, instanceID string) {
+		_, err := c.AckConfig(context.Background(), &pb.ConfigAckRequest{
+			FbId:              fbID,
+			InstanceId:        instanceID,
+			AppliedGeneration: 1,
+			Success:           true,
+		})
+		require.NoError(t, err)
+	}
+
+	// fb-gw acks right away, fb-rx only after a delay
+	ack("fb-gw", "gw-0")
+	time.Sleep(100 * time.Millisecond)
+	ack("fb-rx", "rx-0")
+
+	rxLag := c.clients["fb-rx"]["rx-0"].propagationLag
+	gwLag := c.clients["fb-gw"]["gw-0"].propagationLag
+	assert.GreaterOrEqual(t, rxLag, 100*time.Millisecond)
+	assert.Less(t, rxLag, 5*time.Second)
+	assert.Less(t, gwLag, rxLag)
+
+	// A repeated ack of the same generation isn't measured again
+	ack("fb-rx", "rx-0")
+	assert.Equal(t, rxLag, c.clients["fb-rx"]["rx-0"].propagationLag)
+
+	require.NoError(t, c.UpdateCRDStatus("pipeline"))
+	status := statusPatches(t, client)[0]["status"].(map[string]interface{})
+
+	slowest, ok := status["slowestFB"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "fb-rx", slowest["name"])
+	assert.InDelta(t, rxLag.Seconds(), slowest["propagationSeconds"], 0.001)
+}
+
+func TestConfigController_ClientsHandler(t *testing.T) {
+	c := newTestConfigController()
+	c.BroadcastConfig(&pb.PipelineConfig{Generation: 1}, 1)
+	c.BroadcastConfig(&pb.PipelineConfig{Generation: 2}, 2)
+	c.clients["fb-rx"] = map[string]*connectedClient{
+		"rx-1": {fbID: "fb-rx", instanceID: "rx-1", genAcked: 1, lastUpdated: time.Now()},
+		"rx-0": {fbID: "fb-rx", instanceID: "rx-0", genAcked: 1, lastUpdated: time.Now()},
+	}
+
+	_, err := c.AckConfig(context.Background(), &pb.ConfigAckRequest{
+		FbId:              "fb-rx",
+		InstanceId:        "rx-0",
+		AppliedGeneration: 2,
+		Success:           true,
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	c.ClientsHandler()(rec, httptest.NewRequest(http.MethodGet, "/debug/clients", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var clients map[string][]map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &clients))
+	require.Len(t, clients["fb-rx"], 2)
+	assert.Equal(t, "rx-0", clients["fb-rx"][0]["instance_id"])
+	assert.Equal(t, float64(2), clients["fb-rx"][0]["gen_acked"])
+	assert.Equal(t, "rx-1", clients["fb-rx"][1]["instance_id"])
+	assert.Equal(t, float64(1), clients["fb-rx"][1]["gen_acked"])
+	assert.Contains(t, clients["fb-rx"][0], "last_updated")
+	assert.Contains(t, clients["fb-rx"][0], "age_seconds")
+
+	rec = httptest.NewRecorder()
+	c.ClientsHandler()(rec, httptest.NewRequest(http.MethodPost, "/debug/clients", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestConfigController_StreamConfig_DeltaMatchesFullConfig(t *testing.T) {
+	c := newTestConfigController()
+	client := startTestServer(t, c)
+
+	c.BroadcastConfig(&pb.PipelineConfig{
+		Generation:      1,
+		PipelineVersion: "v1",
+		GlobalSettings:  &pb.GlobalSettings{InternalLabelPolicy: "drop"},
+		FunctionBlocks: map[string]*pb.FBConfig{
+			"fb-rx": {Enabled: true, Parameters: []byte(`{"next_fb":"fb-en-host:5000"}`)},
+			"fb-dp": {Enabled: true, ImageTag: "1.0"},
+		},
+	}, 1)
+
+	// The first push on a stream from generation 0 carries the full config
+	stream, err := client.StreamConfig(context.Background(), &pb.ConfigRequest{
+		FbId:          "fb-rx",
+		InstanceId:    "rx-0",
+		SupportsDelta: true,
+	}, grpc.UseCompressor(gzip.Name))
+	require.NoError(t, err)
+	first, err := stream.Recv()
+	require.NoError(t, err)
+	require.NotNil(t, first.PipelineConfig)
+	assert.Empty(t, first.ConfigPatch)
+
+	_, err = client.AckConfig(context.Background(), &pb.ConfigAckRequest{
+		FbId:              "fb-rx",
+		InstanceId:        "rx-0",
+		AppliedGeneration: 1,
+		Success:           true,
+	})
+	require.NoError(t, err)
+
+	// Later pushes are deltas from the acked generation
+	c.BroadcastConfig(&pb.PipelineConfig{
+		Generation:      2,
+		PipelineVersion: "v2",
+		FunctionBlocks: map[string]*pb.FBConfig{
+			"fb-rx": {Enabled: true, Parameters: []byte(`{"next_fb":"fb-en-host:5001"}`)},
+			"fb-rl": {Enabled: true},
+		},
+	}, 2)
+	update, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), update.Generation)
+	assert.Equal(t, int64(1), update.BaseGeneration)
+	assert.Nil(t, update.PipelineConfig)
+	require.NotEmpty(t, update.ConfigPatch)
+
+	// Applying the delta as the FB does yields the config a full push carries
+	base, err := protojson.Marshal(first.PipelineConfig)
+	require.NoError(t, err)
+	patched, err := config.ApplyConfigDelta(base, update.ConfigPatch)
+	require.NoError(t, err)
+
+	var applied pb.PipelineConfig
+	require.NoError(t, protojson.Unmarshal(patched, &applied))
+
+	full, err := client.GetConfig(context.Background(), &pb.ConfigRequest{FbId: "fb-rx", InstanceId: "rx-0"})
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(full.PipelineConfig, &applied), "delta applied: %v, full push: %v", &applied, full.PipelineConfig)
+}
+
+func TestConfigController_BroadcastConfig_MergePatch(t *testing.T) {
+	c := newTestConfigController()
+	c.BroadcastConfig(testPipelineConfig(1, `{"next_fb":"fb-en-host:5000","batch_size":100}`), 1)
+
+	patchStream := &fakeConfigStream{}
+	fullStream := &fakeConfigStream{}
+	c.clients["fb-rx"] = map[string]*connectedClient{
+		"rx-0": {fbID: "fb-rx", instanceID: "rx-0", stream: patchStream, genAcked: 1, supportsMergePatch: true},
+		"rx-1": {fbID: "fb-rx", instanceID: "rx-1", stream: fullStream, genAcked: 1},
+	}
+
+	c.BroadcastConfig(testPipelineConfig(2, `{"next_fb":"fb-en-host:5001","batch_size":100}`), 2)
+
+	// Instances that support merge patches get only the changed parameters
+	require.Len(t, patchStream.sent, 1)
+	patch := patchStream.sent[0]
+	assert.True(t, patch.MergePatch)
+	assert.Equal(t, int64(2), patch.Generation)
+	assert.Equal(t, int64(1), patch.BaseGeneration)
+	require.Contains(t, patch.PipelineConfig.FunctionBlocks, "fb-rx")
+	assert.JSONEq(t, `{"next_fb":"fb-en-host:5001"}`, string(patch.PipelineConfig.FunctionBlocks["fb-rx"].Parameters))
+
+	// Others still get the full config
+	require.Len(t, fullStream.sent, 1)
+	assert.False(t, fullStream.sent[0].MergePatch)
+	assert.Equal(t, []byte(`{"next_fb":"fb-en-host:5001","batch_size":100}`), fullStream.sent[0].PipelineConfig.FunctionBlocks["fb-rx"].Parameters)
+}
@@ -15,8 +15,10 @@ import (
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 
+	"eidc-tfk8s/internal/config"
 	pb "eidc-tfk8s/pkg/api/protobuf"
 )
 
@@ -32,15 +34,11 @@ type CRDController struct {
 	lastResourceVersion string
 }
 
-// NewCRDController creates a new CRD controller
-func NewCRDController(logger *log.Logger, configController *ConfigController, clientset *kubernetes.Clientset, namespace string) (*CRDController, error) {
+// NewCRDController creates a new CRD controller from the REST config the
+// clientset was built from
+func NewCRDController(logger *log.Logger, configController *ConfigController, restConfig *rest.Config, clientset *kubernetes.Clientset, namespace string) (*CRDController, error) {
 	// Create dynamic client for CRD operations
-	config, err := clientset.RESTClient().Config.ClientConfig()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get REST config: %w", err)
-	}
-
-	dynamicClient, err := dynamic.NewForConfig(config)
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
 	}
@@ -61,6 +59,9 @@ func NewCRDController(logger *log.Logger, configController *ConfigController, cl
 		resourceGVR:      resourceGVR,
 	}
 
+	// Allow the config controller to report acks in the CRD status
+	configController.SetStatusClient(dynamicClient, resourceGVR)
+
 	return controller, nil
 }
 
@@ -109,17 +110,17 @@ func (c *CRDController) Start(ctx context.Context) error {
 
 // onAdd handles CRD add events
 func (c *CRDController) onAdd(obj interface{}) {
-	unstructured, ok := obj.(*unstructured.Unstructured)
+	crd, ok := obj.(*unstructured.Unstructured)
 	if !ok {
 		c.logger.Printf(`{"level":"error","timestamp":"%s","message":"Failed to cast object to Unstructured"}`, time.Now().Format(time.RFC3339))
 		return
 	}
 
 	c.logger.Printf(`{"level":"info","timestamp":"%s","message":"NRDotPlusPipeline added","name":"%s","namespace":"%s"}`,
-		time.Now().Format(time.RFC3339), unstructured.GetName(), unstructured.GetNamespace())
+		time.Now().Format(time.RFC3339), crd.GetName(), crd.GetNamespace())
 
 	// Process the CRD
-	c.processCRD(unstructured)
+	c.processCRD(crd)
 }
 
 // onUpdate handles CRD update events
@@ -155,22 +156,22 @@ func (c *CRDController) onUpdate(oldObj, newObj interface{}) {
 
 // onDelete handles CRD delete events
 func (c *CRDController) onDelete(obj interface{}) {
-	unstructured, ok := obj.(*unstructured.Unstructured)
+	crd, ok := obj.(*unstructured.Unstructured)
 	if !ok {
 		c.logger.Printf(`{"level":"error","timestamp":"%s","message":"Failed to cast object to Unstructured"}`, time.Now().Format(time.RFC3339))
 		return
 	}
 
 	c.logger.Printf(`{"level":"info","timestamp":"%s","message":"NRDotPlusPipeline deleted","name":"%s","namespace":"%s"}`,
-		time.Now().Format(time.RFC3339), unstructured.GetName(), unstructured.GetNamespace())
+		time.Now().Format(time.RFC3339), crd.GetName(), crd.GetNamespace())
 
 	// TODO: Handle deletion (clean up resources, etc.)
 }
 
 // processCRD processes a NRDotPlusPipeline CRD
-func (c *CRDController) processCRD(unstructured *unstructured.Unstructured) {
+func (c *CRDController) processCRD(crd *unstructured.Unstructured) {
 	// Extract spec
-	spec, exists, err := unstructured.NestedMap(unstructured.Object, "spec")
+	spec, exists, err := unstructured.NestedMap(crd.Object, "spec")
 	if err != nil {
 		c.logger.Printf(`{"level":"error","timestamp":"%s","message":"Failed to extract spec","error":"%s"}`,
 			time.Now().Format(time.RFC3339), err)
@@ -183,18 +184,25 @@ func (c *CRDController) processCRD(unstructured *unstructured.Unstructured) {
 	}
 
 	// Extract fields from spec
-	pipelineVersion, _ := unstructured.NestedString(unstructured.Object, "spec", "pipelineVersion")
-	globalSettings, _, _ := unstructured.NestedMap(unstructured.Object, "spec", "globalSettings")
-	functionBlocks, exists, _ := unstructured.NestedMap(unstructured.Object, "spec", "functionBlocks")
+	pipelineVersion, _, _ := unstructured.NestedString(spec, "pipelineVersion")
+	globalSettings, _, _ := unstructured.NestedMap(spec, "globalSettings")
+	functionBlocks, exists, _ := unstructured.NestedMap(spec, "functionBlocks")
 	
 	if !exists {
 		c.logger.Printf(`{"level":"error","timestamp":"%s","message":"functionBlocks not found in CRD"}`, time.Now().Format(time.RFC3339))
 		return
 	}
 
+	// Generations keep increasing even after a rollback, including one
+	// served before a restart
+	if served, found, _ := unstructured.NestedInt64(crd.Object, "status", "servedGeneration"); found {
+		c.configController.RestoreServedGeneration(served)
+	}
+	generation := c.configController.NextGeneration(crd.GetGeneration())
+
 	// Build PipelineConfig
 	pipelineConfig := &pb.PipelineConfig{
-		Generation:      unstructured.GetGeneration(),
+		Generation:      generation,
 		PipelineVersion: pipelineVersion,
 		GlobalSettings:  convertGlobalSettings(globalSettings),
 		FunctionBlocks:  make(map[string]*pb.FBConfig),
@@ -209,13 +217,21 @@ func (c *CRDController) processCRD(unstructured *unstructured.Unstructured) {
 			continue
 		}
 
-		enabled, _ := getNestedBool(fbConfigMap, "enabled")
+		enabled, enabledSet := getNestedBool(fbConfigMap, "enabled")
 		imageTag, _ := getNestedString(fbConfigMap, "imageTag")
 		parametersRaw, exists, _ := getNestedMap(fbConfigMap, "parameters")
 		
 		if !exists {
 			parametersRaw = make(map[string]interface{})
 		}
+
+		// Let the FB resolve the pipeline's deterministic seed
+		parametersRaw = withDeterministicSeedEnvVar(parametersRaw, pipelineConfig.GlobalSettings.DeterministicSeedEnvVar)
+
+		// FBs read enabled from their common config, which the CRD flag overrides
+		if enabledSet {
+			parametersRaw = withCommonParameter(parametersRaw, "enabled", enabled)
+		}
 		
 		// Convert parameters to JSON bytes
 		parametersBytes, err := json.Marshal(parametersRaw)
@@ -225,6 +241,13 @@ func (c *CRDController) processCRD(unstructured *unstructured.Unstructured) {
 			continue
 		}
 
+		// Reject the whole pipeline rather than broadcast a partial one
+		if err := config.ValidateParameters(fbName, parametersBytes); err != nil {
+			c.logger.Printf(`{"level":"error","timestamp":"%s","message":"Invalid FB parameters, config not broadcast","fb_name":"%s","error":"%s"}`,
+				time.Now().Format(time.RFC3339), fbName, err)
+			return
+		}
+
 		// Create FBConfig
 		fbConfig := &pb.FBConfig{
 			Enabled:    enabled,
@@ -269,13 +292,14 @@ func (c *CRDController) processCRD(unstructured *unstructured.Unstructured) {
 	}
 
 	// Save last resource version
-	c.lastResourceVersion = unstructured.GetResourceVersion()
+	c.lastResourceVersion = crd.GetResourceVersion()
+	c.configController.SetCRDName(crd.GetName())
 
 	// Broadcast config to connected clients
-	c.configController.BroadcastConfig(pipelineConfig, unstructured.GetGeneration())
+	c.configController.BroadcastConfig(pipelineConfig, generation)
 
 	// Update status
-	c.updateStatus(unstructured)
+	c.updateStatus(crd)
 }
 
 // convertGlobalSettings converts globalSettings map to pb.GlobalSettings
@@ -297,6 +321,40 @@ func convertGlobalSettings(globalSettings map[string]interface{}) *pb.GlobalSett
 	return result
 }
 
+// withDeterministicSeedEnvVar returns the FB parameters with the name of the
+// seed environment variable set in their common config, unless the FB sets
+// its own
+func withDeterministicSeedEnvVar(parameters map[string]interface{}, envVar string) map[string]interface{} {
+	if envVar == "" {
+		return parameters
+	}
+
+	common, _ := parameters["common"].(map[string]interface{})
+	if _, ok := common["deterministic_seed_env_var"]; ok {
+		return parameters
+	}
+	return withCommonParameter(parameters, "deterministic_seed_env_var", envVar)
+}
+
+// withCommonParameter returns the FB parameters with key set to value in
+// their common config. The parameters are copied rather than modified in
+// place.
+func withCommonParameter(parameters map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	common, _ := parameters["common"].(map[string]interface{})
+	commonCopy := make(map[string]interface{}, len(common)+1)
+	for k, v := range common {
+		commonCopy[k] = v
+	}
+	commonCopy[key] = value
+
+	parametersCopy := make(map[string]interface{}, len(parameters)+1)
+	for k, v := range parameters {
+		parametersCopy[k] = v
+	}
+	parametersCopy["common"] = commonCopy
+	return parametersCopy
+}
+
 // getNestedBool extracts a boolean value from a nested map
 func getNestedBool(obj map[string]interface{}, key string) (bool, bool) {
 	value, exists := obj[key]
@@ -342,6 +400,7 @@ func (c *CRDController) updateStatus(crd *unstructured.Unstructured) {
 	// Create status
 	status := map[string]interface{}{
 		"observedGeneration":      crd.GetGeneration(),
+		"servedGeneration":        c.configController.ServedGeneration(),
 		"configGenerationApplied": crd.GetGeneration(),
 	}
 
@@ -14,13 +14,27 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip" // FBs stream config gzip-compressed
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 
+	"eidc-tfk8s/internal/common/metrics"
 	pb "eidc-tfk8s/pkg/api/protobuf"
+	"eidc-tfk8s/pkg/fb"
+
+	// FB packages register the schema of their parameters
+	_ "eidc-tfk8s/pkg/fb/cl"
+	_ "eidc-tfk8s/pkg/fb/dp"
+	_ "eidc-tfk8s/pkg/fb/en-host"
+	_ "eidc-tfk8s/pkg/fb/filter"
+	_ "eidc-tfk8s/pkg/fb/gw"
+	_ "eidc-tfk8s/pkg/fb/rl"
+	_ "eidc-tfk8s/pkg/fb/router"
+	_ "eidc-tfk8s/pkg/fb/rx"
+	_ "eidc-tfk8s/pkg/fb/tap"
 )
 
 // Build information, injected at build time
@@ -35,6 +49,7 @@ func main() {
 	var (
 		grpcPort           = flag.Int("grpc-port", 5000, "gRPC service port")
 		metricsPort        = flag.Int("metrics-port", 2112, "Prometheus metrics port")
+		fbMetricsPort      = flag.Int("fb-metrics-port", 2112, "Metrics port of the FB pods, probed for the pipeline health summary")
 		namespace          = flag.String("namespace", "", "Kubernetes namespace (default: auto-detect)")
 		leaseLockName      = flag.String("lease-lock-name", "config-controller", "Name of the lease lock")
 		leaseLockNamespace = flag.String("lease-lock-namespace", "", "Namespace of the lease lock")
@@ -42,6 +57,7 @@ func main() {
 		leaseDuration      = flag.Duration("lease-duration", 15*time.Second, "Leader lease duration")
 		renewDeadline      = flag.Duration("renew-deadline", 10*time.Second, "Leader renew deadline")
 		retryPeriod        = flag.Duration("retry-period", 2*time.Second, "Leader election retry period")
+		grpcReflection     = flag.Bool("grpc-reflection", false, "Register the gRPC reflection service for debugging with grpcurl; keep off in production")
 	)
 	flag.Parse()
 
@@ -70,11 +86,6 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("healthy"))
 	})
-	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
-		// TODO: Implement proper readiness check
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ready"))
-	})
 
 	metricsServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", *metricsPort),
@@ -133,11 +144,35 @@ func main() {
 		*leaseLockNamespace = *namespace
 	}
 
-	// Initialize gRPC server
-	server := grpc.NewServer()
-	// Create and register ConfigController as the ConfigService implementation
+	// Create the ConfigController and register it as the ConfigService
+	// implementation, with the codec that serves its cached responses
 	configController := NewConfigController(logger, clientset, *namespace)
+	serverOptions := append(metrics.GRPCServerOptions("config-controller"), grpc.ForceServerCodec(configController.Codec()))
+	server := grpc.NewServer(serverOptions...)
 	pb.RegisterConfigServiceServer(server, configController)
+	fb.RegisterReflection(server, *grpcReflection)
+
+	// Only the leader is ready, so the Service routes config RPCs to it
+	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !configController.IsLeader() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("not leader"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ready"))
+	})
+
+	// Admin endpoint for rolling back to a previous config generation
+	http.HandleFunc("/admin/rollback", configController.RollbackHandler())
+
+	// Debug endpoint listing the connected FB instances and their acked
+	// generations
+	http.HandleFunc("/debug/clients", configController.ClientsHandler())
+
+	// Health of the whole pipeline, probing each connected FB instance
+	configController.SetProber(newHTTPProber(clientset, *namespace, *fbMetricsPort))
+	http.HandleFunc("/pipeline/health", configController.PipelineHealthHandler())
 
 	// Start gRPC server
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *grpcPort))
@@ -180,10 +215,12 @@ func main() {
 			OnStartedLeading: func(ctx context.Context) {
 				logger.Printf(`{"level":"info","timestamp":"%s","message":"Started leading","id":"%s"}`,
 					time.Now().Format(time.RFC3339), *id)
+				configController.SetLeader(true)
 				// Start the controller when we become leader
-				runController(ctx, configController, clientset, *namespace, logger)
+				runController(ctx, configController, config, clientset, *namespace, logger)
 			},
 			OnStoppedLeading: func() {
+				configController.SetLeader(false)
 				logger.Printf(`{"level":"info","timestamp":"%s","message":"Stopped leading","id":"%s"}`,
 					time.Now().Format(time.RFC3339), *id)
 				// We should exit if we stop being leader
@@ -218,12 +255,12 @@ func main() {
 }
 
 // runController runs the main controller loop that watches for pipeline resources and distributes configuration
-func runController(ctx context.Context, configController *ConfigController, clientset *kubernetes.Clientset, namespace string, logger *log.Logger) {
+func runController(ctx context.Context, configController *ConfigController, restConfig *rest.Config, clientset *kubernetes.Clientset, namespace string, logger *log.Logger) {
 	logger.Printf(`{"level":"info","timestamp":"%s","message":"Starting controller for namespace","namespace":"%s"}`,
 		time.Now().Format(time.RFC3339), namespace)
 
 	// Create CRD controller
-	crdController, err := NewCRDController(logger, configController, clientset, namespace)
+	crdController, err := NewCRDController(logger, configController, restConfig, clientset, namespace)
 	if err != nil {
 		logger.Printf(`{"level":"error","timestamp":"%s","message":"Failed to create CRD controller","error":"%s"}`,
 			time.Now().Format(time.RFC3339), err)
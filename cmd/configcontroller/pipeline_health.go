@@ -0,0 +1,325 @@
+// Pipeline health summary for the ConfigController
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultProbeTimeout bounds the probes of a /pipeline/health request
+const defaultProbeTimeout = 3 * time.Second
+
+// circuitBreakerStateMetric is the gauge FBs export the state of their
+// circuit breakers on, by downstream
+const circuitBreakerStateMetric = "fb_cb_state"
+
+// Circuit breaker states as reported in the health summary, indexed by the
+// value of the fb_cb_state gauge
+var circuitBreakerStates = []string{"closed", "open", "half_open"}
+
+// instanceProbe is what probing an FB instance found
+type instanceProbe struct {
+	// Ready is whether the instance's readiness endpoint returned 200
+	Ready bool
+
+	// CircuitBreakers maps each downstream to the state of its breaker
+	CircuitBreakers map[string]string
+
+	// Err is why the instance couldn't be probed
+	Err error
+}
+
+// fbProber probes the readiness and circuit breakers of FB instances
+type fbProber interface {
+	Probe(ctx context.Context, fbID, instanceID string) instanceProbe
+}
+
+// httpProber probes FB pods over their metrics server. Instances are
+// identified by pod name, which is resolved to the pod IP.
+type httpProber struct {
+	clientset kubernetes.Interface
+	namespace string
+	port      int
+	client    *http.Client
+}
+
+// newHTTPProber creates a prober for FB pods serving /ready and /metrics on port
+func newHTTPProber(clientset kubernetes.Interface, namespace string, port int) *httpProber {
+	return &httpProber{
+		clientset: clientset,
+		namespace: namespace,
+		port:      port,
+		client:    &http.Client{},
+	}
+}
+
+// Probe implements fbProber
+func (p *httpProber) Probe(ctx context.Context, fbID, instanceID string) instanceProbe {
+	pod, err := p.clientset.CoreV1().Pods(p.namespace).Get(ctx, instanceID, metav1.GetOptions{})
+	if err != nil {
+		return instanceProbe{Err: fmt.Errorf("failed to look up pod: %w", err)}
+	}
+	if pod.Status.PodIP == "" {
+		return instanceProbe{Err: fmt.Errorf("pod %s has no IP", instanceID)}
+	}
+	baseURL := "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(p.port))
+
+	probe := instanceProbe{}
+
+	res, err := p.get(ctx, baseURL+"/ready")
+	if err != nil {
+		return instanceProbe{Err: err}
+	}
+	res.Body.Close()
+	probe.Ready = res.StatusCode == http.StatusOK
+
+	res, err = p.get(ctx, baseURL+"/metrics")
+	if err != nil {
+		return instanceProbe{Ready: probe.Ready, Err: err}
+	}
+	defer res.Body.Close()
+	probe.CircuitBreakers, err = parseCircuitBreakerStates(res.Body)
+	if err != nil {
+		probe.Err = err
+	}
+
+	return probe
+}
+
+// get performs a GET request
+func (p *httpProber) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe %s: %w", url, err)
+	}
+	return res, nil
+}
+
+// parseCircuitBreakerStates reads the circuit breaker states from metrics in
+// the Prometheus text format
+func parseCircuitBreakerStates(r io.Reader) (map[string]string, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+
+	states := make(map[string]string)
+	family, ok := families[circuitBreakerStateMetric]
+	if !ok {
+		return states, nil
+	}
+	for _, metric := range family.GetMetric() {
+		downstream := ""
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "downstream" {
+				downstream = label.GetValue()
+			}
+		}
+		value := int(metric.GetGauge().GetValue())
+		if downstream == "" || value < 0 || value >= len(circuitBreakerStates) {
+			continue
+		}
+		states[downstream] = circuitBreakerStates[value]
+	}
+	return states, nil
+}
+
+// PipelineHealth summarizes the health of every FB connected to the controller
+type PipelineHealth struct {
+	// Healthy is set when every FB is ready, has converged on the current
+	// generation and has no open circuit breaker
+	Healthy bool `json:"healthy"`
+
+	// Generation is the current config generation
+	Generation int64 `json:"generation"`
+
+	// Converged is set when every instance has acked the current generation
+	Converged bool `json:"converged"`
+
+	FBs []FBHealth `json:"fbs"`
+}
+
+// FBHealth summarizes the health of an FB's instances
+type FBHealth struct {
+	Name string `json:"name"`
+
+	// Ready is set when every instance is ready
+	Ready bool `json:"ready"`
+
+	// Converged is set when every instance has acked the current generation
+	Converged bool `json:"converged"`
+
+	// MinGenerationAcked is the lowest generation acked by an instance
+	MinGenerationAcked int64 `json:"min_generation_acked"`
+
+	// OpenCircuitBreakers lists the downstreams whose breaker is open on
+	// any instance
+	OpenCircuitBreakers []string `json:"open_circuit_breakers"`
+
+	Instances []InstanceHealth `json:"instances"`
+}
+
+// InstanceHealth is the health of an FB instance
+type InstanceHealth struct {
+	InstanceID      string `json:"instance_id"`
+	GenerationAcked int64  `json:"generation_acked"`
+	Converged       bool   `json:"converged"`
+
+	// Probed is set when the instance was probed. Instances that aren't are
+	// counted as ready, since they hold a config stream open.
+	Probed bool `json:"probed"`
+	Ready  bool `json:"ready"`
+
+	// CircuitBreakers maps each downstream to the state of its breaker
+	CircuitBreakers map[string]string `json:"circuit_breakers,omitempty"`
+
+	// Error is why the instance couldn't be probed
+	Error string `json:"error,omitempty"`
+}
+
+// SetProber sets the prober /pipeline/health uses for instance readiness and
+// circuit breaker states. Without one, the summary only reflects the
+// connected client state.
+func (c *ConfigController) SetProber(prober fbProber) {
+	c.prober = prober
+}
+
+// PipelineHealth probes the connected FB instances and summarizes their health
+func (c *ConfigController) PipelineHealth(ctx context.Context) PipelineHealth {
+	c.configMu.RLock()
+	generation := c.currentGeneration
+	c.configMu.RUnlock()
+
+	// Snapshot the connected instances, so probes don't hold the lock
+	instances := make(map[string][]InstanceHealth)
+	c.clientsMu.RLock()
+	for fbID, fbClients := range c.clients {
+		for instanceID, client := range fbClients {
+			instances[fbID] = append(instances[fbID], InstanceHealth{
+				InstanceID:      instanceID,
+				GenerationAcked: client.genAcked,
+				Converged:       client.genAcked >= generation,
+				Ready:           true,
+			})
+		}
+	}
+	c.clientsMu.RUnlock()
+
+	if c.prober != nil {
+		var wg sync.WaitGroup
+		for fbID, fbInstances := range instances {
+			for i := range fbInstances {
+				wg.Add(1)
+				go func(fbID string, instance *InstanceHealth) {
+					defer wg.Done()
+					probe := c.prober.Probe(ctx, fbID, instance.InstanceID)
+					instance.Probed = true
+					instance.Ready = probe.Ready
+					instance.CircuitBreakers = probe.CircuitBreakers
+					if probe.Err != nil {
+						instance.Error = probe.Err.Error()
+					}
+				}(fbID, &fbInstances[i])
+			}
+		}
+		wg.Wait()
+	}
+
+	// Sort FB names and instances so the summary is deterministic
+	fbIDs := make([]string, 0, len(instances))
+	for fbID := range instances {
+		fbIDs = append(fbIDs, fbID)
+	}
+	sort.Strings(fbIDs)
+
+	health := PipelineHealth{
+		Healthy:    len(fbIDs) > 0,
+		Generation: generation,
+		Converged:  true,
+		FBs:        make([]FBHealth, 0, len(fbIDs)),
+	}
+	for _, fbID := range fbIDs {
+		fbInstances := instances[fbID]
+		sort.Slice(fbInstances, func(i, j int) bool {
+			return fbInstances[i].InstanceID < fbInstances[j].InstanceID
+		})
+
+		fbHealth := FBHealth{
+			Name:                fbID,
+			Ready:               true,
+			Converged:           true,
+			MinGenerationAcked:  -1,
+			OpenCircuitBreakers: []string{},
+			Instances:           fbInstances,
+		}
+		open := make(map[string]bool)
+		for _, instance := range fbInstances {
+			if !instance.Ready {
+				fbHealth.Ready = false
+			}
+			if !instance.Converged {
+				fbHealth.Converged = false
+			}
+			if fbHealth.MinGenerationAcked < 0 || instance.GenerationAcked < fbHealth.MinGenerationAcked {
+				fbHealth.MinGenerationAcked = instance.GenerationAcked
+			}
+			for downstream, state := range instance.CircuitBreakers {
+				if state == "open" && !open[downstream] {
+					open[downstream] = true
+					fbHealth.OpenCircuitBreakers = append(fbHealth.OpenCircuitBreakers, downstream)
+				}
+			}
+		}
+		sort.Strings(fbHealth.OpenCircuitBreakers)
+
+		if !fbHealth.Converged {
+			health.Converged = false
+		}
+		if !fbHealth.Ready || !fbHealth.Converged || len(fbHealth.OpenCircuitBreakers) > 0 {
+			health.Healthy = false
+		}
+		health.FBs = append(health.FBs, fbHealth)
+	}
+
+	return health
+}
+
+// PipelineHealthHandler serves the pipeline health endpoint. GET
+// /pipeline/health returns PipelineHealth as JSON, with status 503 when the
+// pipeline isn't healthy so it can back an alert or a probe.
+func (c *ConfigController) PipelineHealthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), defaultProbeTimeout)
+		defer cancel()
+
+		health := c.PipelineHealth(ctx)
+		if !health.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pb "eidc-tfk8s/pkg/api/protobuf"
+)
+
+// stubProber returns canned probes by instance id
+type stubProber map[string]instanceProbe
+
+// Probe implements fbProber
+func (p stubProber) Probe(ctx context.Context, fbID, instanceID string) instanceProbe {
+	return p[instanceID]
+}
+
+func TestConfigController_PipelineHealth(t *testing.T) {
+	c := newTestConfigController()
+	c.BroadcastConfig(&pb.PipelineConfig{Generation: 3}, 3)
+	c.clients["fb-rx"] = map[string]*connectedClient{
+		"rx-1": {fbID: "fb-rx", instanceID: "rx-1", genAcked: 2, lastUpdated: time.Now()},
+		"rx-0": {fbID: "fb-rx", instanceID: "rx-0", genAcked: 3, lastUpdated: time.Now()},
+	}
+	c.clients["fb-gw"] = map[string]*connectedClient{
+		"gw-0": {fbID: "fb-gw", instanceID: "gw-0", genAcked: 3, lastUpdated: time.Now()},
+	}
+	c.SetProber(stubProber{
+		"rx-0": {Ready: true, CircuitBreakers: map[string]string{"next_fb": "closed", "dlq": "closed"}},
+		"rx-1": {Err: errors.New("connection refused")},
+		"gw-0": {Ready: true, CircuitBreakers: map[string]string{"next_fb": "open", "dlq": "half_open"}},
+	})
+
+	health := c.PipelineHealth(context.Background())
+	assert.False(t, health.Healthy)
+	assert.False(t, health.Converged)
+	assert.Equal(t, int64(3), health.Generation)
+	require.Len(t, health.FBs, 2)
+
+	gw := health.FBs[0]
+	assert.Equal(t, "fb-gw", gw.Name)
+	assert.True(t, gw.Ready)
+	assert.True(t, gw.Converged)
+	assert.Equal(t, int64(3), gw.MinGenerationAcked)
+	assert.Equal(t, []string{"next_fb"}, gw.OpenCircuitBreakers)
+
+	rx := health.FBs[1]
+	assert.Equal(t, "fb-rx", rx.Name)
+	assert.False(t, rx.Ready)
+	assert.False(t, rx.Converged)
+	assert.Equal(t, int64(2), rx.MinGenerationAcked)
+	assert.Empty(t, rx.OpenCircuitBreakers)
+	require.Len(t, rx.Instances, 2)
+	assert.Equal(t, InstanceHealth{
+		InstanceID:      "rx-0",
+		GenerationAcked: 3,
+		Converged:       true,
+		Probed:          true,
+		Ready:           true,
+		CircuitBreakers: map[string]string{"next_fb": "closed", "dlq": "closed"},
+	}, rx.Instances[0])
+	assert.Equal(t, InstanceHealth{
+		InstanceID:      "rx-1",
+		GenerationAcked: 2,
+		Probed:          true,
+		Error:           "connection refused",
+	}, rx.Instances[1])
+}
+
+func TestConfigController_PipelineHealthHandler(t *testing.T) {
+	c := newTestConfigController()
+	c.BroadcastConfig(&pb.PipelineConfig{Generation: 1}, 1)
+	c.clients["fb-rx"] = map[string]*connectedClient{
+		"rx-0": {fbID: "fb-rx", instanceID: "rx-0", genAcked: 1, lastUpdated: time.Now()},
+	}
+
+	// Without a prober, connected instances that have converged are healthy
+	rec := httptest.NewRecorder()
+	c.PipelineHealthHandler()(rec, httptest.NewRequest(http.MethodGet, "/pipeline/health", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var health PipelineHealth
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &health))
+	assert.True(t, health.Healthy)
+	require.Len(t, health.FBs, 1)
+	assert.False(t, health.FBs[0].Instances[0].Probed)
+
+	c.SetProber(stubProber{"rx-0": {Ready: false}})
+	rec = httptest.NewRecorder()
+	c.PipelineHealthHandler()(rec, httptest.NewRequest(http.MethodGet, "/pipeline/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	rec = httptest.NewRecorder()
+	c.PipelineHealthHandler()(rec, httptest.NewRequest(http.MethodPost, "/pipeline/health", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestParseCircuitBreakerStates(t *testing.T) {
+	metrics := `# HELP fb_cb_state Current state of the circuit breaker (0=closed, 1=open, 2=half-open)
+# TYPE fb_cb_state gauge
+fb_cb_state{downstream="dlq",fb_name="fb-rx"} 0
+fb_cb_state{downstream="next_fb",fb_name="fb-rx"} 1
+# TYPE fb_rx_batches_total counter
+fb_rx_batches_total 12
+`
+
+	states, err := parseCircuitBreakerStates(strings.NewReader(metrics))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"dlq": "closed", "next_fb": "open"}, states)
+}
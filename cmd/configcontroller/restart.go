@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	pb "eidc-tfk8s/pkg/api/protobuf"
+)
+
+// restartRequiredKeys lists, per function block, the top-level parameters
+// that can't be hot-swapped. Changing any of them sets RequiresRestart on the
+// update sent to that FB.
+var restartRequiredKeys = map[string][]string{
+	"fb-dp": {"storageType", "persistentStorage", "gcInterval"},
+}
+
+// ackedConfig returns the config of the generation an FB instance last acked,
+// which is what an update has to be compared with to tell whether it
+// requires a restart. Instances that haven't acked a generation have nothing
+// to reinitialize, and fallback is used if the acked generation has left the
+// config history. Must be called with configMu held.
+func (c *ConfigController) ackedConfig(genAcked int64, fallback *pb.PipelineConfig) *pb.PipelineConfig {
+	if genAcked <= 0 {
+		return nil
+	}
+
+	entry, ok := c.history.get(genAcked)
+	if !ok {
+		return fallback
+	}
+	return entry.config
+}
+
+// requiresRestart reports whether going from oldConfig to newConfig changes a
+// restart-required parameter of the given FB. The first config an FB receives
+// never requires a restart since there is nothing to reinitialize yet.
+func requiresRestart(fbID string, oldConfig, newConfig *pb.PipelineConfig) bool {
+	keys, ok := restartRequiredKeys[fbID]
+	if !ok || oldConfig == nil || newConfig == nil {
+		return false
+	}
+
+	oldParams := fbParameters(oldConfig, fbID)
+	newParams := fbParameters(newConfig, fbID)
+	if oldParams == nil || newParams == nil {
+		return false
+	}
+
+	for _, key := range keys {
+		if !jsonEqual(oldParams[key], newParams[key]) {
+			return true
+		}
+	}
+	return false
+}
+
+// fbParameters decodes the top-level parameters of an FB, or returns nil if
+// the FB isn't configured or its parameters aren't a JSON object
+func fbParameters(config *pb.PipelineConfig, fbID string) map[string]json.RawMessage {
+	fbConfig, ok := config.FunctionBlocks[fbID]
+	if !ok || fbConfig == nil {
+		return nil
+	}
+
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(fbConfig.Parameters, &params); err != nil {
+		return nil
+	}
+	return params
+}
+
+// jsonEqual compares two JSON values ignoring insignificant whitespace
+func jsonEqual(a, b json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	var compactA, compactB bytes.Buffer
+	if err := json.Compact(&compactA, a); err != nil {
+		return bytes.Equal(a, b)
+	}
+	if err := json.Compact(&compactB, b); err != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(compactA.Bytes(), compactB.Bytes())
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pb "eidc-tfk8s/pkg/api/protobuf"
+)
+
+// pipelineWithParams builds a pipeline config holding one FB's parameters
+func pipelineWithParams(fbID, params string) *pb.PipelineConfig {
+	return &pb.PipelineConfig{
+		FunctionBlocks: map[string]*pb.FBConfig{
+			fbID: {Enabled: true, Parameters: []byte(params)},
+		},
+	}
+}
+
+func TestRequiresRestart(t *testing.T) {
+	base := pipelineWithParams("fb-dp", `{"storageType":"memory","ttlMinutes":10}`)
+
+	tests := []struct {
+		name      string
+		fbID      string
+		oldConfig *pb.PipelineConfig
+		newConfig *pb.PipelineConfig
+		want      bool
+	}{
+		{
+			name:      "first config",
+			fbID:      "fb-dp",
+			newConfig: base,
+			want:      false,
+		},
+		{
+			name:      "hot-swappable key changed",
+			fbID:      "fb-dp",
+			oldConfig: base,
+			newConfig: pipelineWithParams("fb-dp", `{"storageType":"memory","ttlMinutes":20}`),
+			want:      false,
+		},
+		{
+			name:      "whitespace only",
+			fbID:      "fb-dp",
+			oldConfig: base,
+			newConfig: pipelineWithParams("fb-dp", `{ "storageType": "memory", "ttlMinutes": 10 }`),
+			want:      false,
+		},
+		{
+			name:      "storage type changed",
+			fbID:      "fb-dp",
+			oldConfig: base,
+			newConfig: pipelineWithParams("fb-dp", `{"storageType":"badgerdb","ttlMinutes":10}`),
+			want:      true,
+		},
+		{
+			name:      "persistent storage added",
+			fbID:      "fb-dp",
+			oldConfig: base,
+			newConfig: pipelineWithParams("fb-dp", `{"storageType":"memory","ttlMinutes":10,"persistentStorage":{"enabled":true}}`),
+			want:      true,
+		},
+		{
+			name:      "FB without restart-required keys",
+			fbID:      "fb-rx",
+			oldConfig: pipelineWithParams("fb-rx", `{"storageType":"memory"}`),
+			newConfig: pipelineWithParams("fb-rx", `{"storageType":"badgerdb"}`),
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, requiresRestart(tt.fbID, tt.oldConfig, tt.newConfig))
+		})
+	}
+}
+
+// recordingStream records the updates sent on a config stream
+type recordingStream struct {
+	pb.ConfigService_StreamConfigServer
+	sent []*pb.ConfigResponse
+}
+
+func (s *recordingStream) Send(resp *pb.ConfigResponse) error {
+	s.sent = append(s.sent, resp)
+	return nil
+}
+
+func TestConfigController_RequiresRestartSinceAckedGeneration(t *testing.T) {
+	c := newTestConfigController()
+	c.BroadcastConfig(pipelineWithParams("fb-dp", `{"storageType":"memory","ttlMinutes":10}`), 1)
+
+	lagging := &recordingStream{}
+	current := &recordingStream{}
+	c.clients["fb-dp"] = map[string]*connectedClient{
+		"dp-0": {fbID: "fb-dp", instanceID: "dp-0", stream: lagging, genAcked: 1},
+		"dp-1": {fbID: "fb-dp", instanceID: "dp-1", stream: current, genAcked: 1},
+	}
+
+	// Both instances are told to reinitialize their storage, but only dp-1
+	// acks the change
+	c.BroadcastConfig(pipelineWithParams("fb-dp", `{"storageType":"badgerdb","ttlMinutes":10}`), 2)
+	require.Len(t, lagging.sent, 1)
+	require.Len(t, current.sent, 1)
+	assert.True(t, lagging.sent[0].RequiresRestart)
+	assert.True(t, current.sent[0].RequiresRestart)
+	c.clients["fb-dp"]["dp-1"].genAcked = 2
+
+	// The next change keeps the storage, which dp-0 still has to switch
+	c.BroadcastConfig(pipelineWithParams("fb-dp", `{"storageType":"badgerdb","ttlMinutes":20}`), 3)
+	require.Len(t, lagging.sent, 2)
+	require.Len(t, current.sent, 2)
+	assert.True(t, lagging.sent[1].RequiresRestart)
+	assert.False(t, current.sent[1].RequiresRestart)
+}
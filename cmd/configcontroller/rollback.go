@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pb "eidc-tfk8s/pkg/api/protobuf"
+)
+
+// defaultHistorySize is the number of applied pipeline configs kept for rollback
+const defaultHistorySize = 10
+
+// configHistory is a fixed-size ring buffer of applied pipeline configs
+type configHistory struct {
+	entries []*configHistoryEntry
+	next    int
+}
+
+// configHistoryEntry is a pipeline config as it was broadcast
+type configHistoryEntry struct {
+	generation int64
+	config     *pb.PipelineConfig
+	appliedAt  time.Time
+}
+
+// newConfigHistory creates a ring buffer holding up to size configs
+func newConfigHistory(size int) *configHistory {
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	return &configHistory{
+		entries: make([]*configHistoryEntry, size),
+	}
+}
+
+// add records a config, overwriting the oldest entry once full
+func (h *configHistory) add(generation int64, config *pb.PipelineConfig) {
+	h.entries[h.next] = &configHistoryEntry{
+		generation: generation,
+		config:     proto.Clone(config).(*pb.PipelineConfig),
+		appliedAt:  time.Now(),
+	}
+	h.next = (h.next + 1) % len(h.entries)
+}
+
+// get returns the config stored for the given generation
+func (h *configHistory) get(generation int64) (*configHistoryEntry, bool) {
+	for _, entry := range h.entries {
+		if entry != nil && entry.generation == generation {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// generations returns the stored generations, oldest first
+func (h *configHistory) generations() []int64 {
+	generations := make([]int64, 0, len(h.entries))
+	for i := 0; i < len(h.entries); i++ {
+		entry := h.entries[(h.next+i)%len(h.entries)]
+		if entry != nil {
+			generations = append(generations, entry.generation)
+		}
+	}
+	return generations
+}
+
+// NextGeneration returns the generation to use for a config derived from a
+// CRD generation. Generations must keep increasing after a rollback, which
+// advances the served generation past the CRD's own.
+func (c *ConfigController) NextGeneration(crdGeneration int64) int64 {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	if crdGeneration > c.currentGeneration {
+		return crdGeneration
+	}
+	return c.currentGeneration + 1
+}
+
+// ServedGeneration returns the generation of the config being served. It is
+// kept in the CRD status as servedGeneration.
+func (c *ConfigController) ServedGeneration() int64 {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	return c.currentGeneration
+}
+
+// RestoreServedGeneration resumes from the generation served before a
+// restart, read back from the CRD status, so that generations keep
+// increasing after a rollback advanced them past the CRD's own
+func (c *ConfigController) RestoreServedGeneration(generation int64) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	if generation > c.currentGeneration {
+		c.currentGeneration = generation
+		configGeneration.Set(float64(generation))
+	}
+}
+
+// ConfigHistory returns the generations available for rollback, oldest first
+func (c *ConfigController) ConfigHistory() []int64 {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	return c.history.generations()
+}
+
+// RollbackToGeneration re-broadcasts the config stored for the given
+// generation under a new generation and returns the new generation
+func (c *ConfigController) RollbackToGeneration(generation int64, requestedBy string) (int64, error) {
+	if err := c.checkLeader(); err != nil {
+		return 0, err
+	}
+
+	// Hold the config lock from allocating the new generation until it is
+	// broadcast, so that a concurrent broadcast can't take the same one
+	c.configMu.Lock()
+	entry, ok := c.history.get(generation)
+	if !ok {
+		c.configMu.Unlock()
+		return 0, fmt.Errorf("generation %d not found in config history", generation)
+	}
+	newGeneration := c.currentGeneration + 1
+
+	rolledBack := proto.Clone(entry.config).(*pb.PipelineConfig)
+	rolledBack.Generation = newGeneration
+
+	c.logger.Printf(`{"level":"info","timestamp":"%s","message":"Rolling back config","from_generation":%d,"new_generation":%d,"requested_by":"%s"}`,
+		time.Now().Format(time.RFC3339), generation, newGeneration, requestedBy)
+
+	c.broadcastConfigLocked(rolledBack, newGeneration)
+	c.configMu.Unlock()
+
+	// Persist the new generation in the CRD status without waiting for acks
+	c.scheduleStatusUpdate()
+
+	if err := c.recordRollbackEvent(generation, newGeneration, requestedBy); err != nil {
+		c.logger.Printf(`{"level":"error","timestamp":"%s","message":"Failed to record rollback event","error":"%s"}`,
+			time.Now().Format(time.RFC3339), err)
+	}
+
+	return newGeneration, nil
+}
+
+// recordRollbackEvent records a Kubernetes event on the pipeline resource
+func (c *ConfigController) recordRollbackEvent(generation, newGeneration int64, requestedBy string) error {
+	if c.clientset == nil {
+		return fmt.Errorf("kubernetes client not configured")
+	}
+
+	c.statusMu.Lock()
+	crdName := c.crdName
+	c.statusMu.Unlock()
+
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "config-rollback-",
+			Namespace:    c.namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "nrdot.newrelic.com/v1",
+			Kind:       "NRDotPlusPipeline",
+			Name:       crdName,
+			Namespace:  c.namespace,
+		},
+		Reason: "ConfigRollback",
+		Message: fmt.Sprintf("Config rolled back to generation %d as generation %d by %s",
+			generation, newGeneration, requestedBy),
+		Type:           corev1.EventTypeNormal,
+		Source:         corev1.EventSource{Component: "config-controller"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := c.clientset.CoreV1().Events(c.namespace).Create(ctx, event, metav1.CreateOptions{})
+	return err
+}
+
+// RollbackHandler serves the rollback admin endpoint.
+// POST /admin/rollback?generation=N rolls back to generation N; GET lists
+// the generations available for rollback.
+func (c *ConfigController) RollbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"generations": c.ConfigHistory(),
+			})
+			return
+		case http.MethodPost:
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		generation, err := strconv.ParseInt(r.URL.Query().Get("generation"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid generation"})
+			return
+		}
+
+		requestedBy := r.Header.Get("X-Requested-By")
+		if requestedBy == "" {
+			requestedBy = r.RemoteAddr
+		}
+
+		newGeneration, err := c.RollbackToGeneration(generation, requestedBy)
+		if err != nil {
+			// Followers can't roll back; the request is retried on the
+			// leader
+			if status.Code(err) == codes.Unavailable {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			} else {
+				w.WriteHeader(http.StatusConflict)
+			}
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"rolled_back_to": generation,
+			"generation":     newGeneration,
+		})
+	}
+}
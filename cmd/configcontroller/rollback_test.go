@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	pb "eidc-tfk8s/pkg/api/protobuf"
+)
+
+// fakeConfigStream records the config responses sent to a client
+type fakeConfigStream struct {
+	pb.ConfigService_StreamConfigServer
+	sent []*pb.ConfigResponse
+}
+
+func (s *fakeConfigStream) Send(resp *pb.ConfigResponse) error {
+	s.sent = append(s.sent, resp)
+	return nil
+}
+
+func testPipelineConfig(generation int64, parameters string) *pb.PipelineConfig {
+	return &pb.PipelineConfig{
+		Generation:      generation,
+		PipelineVersion: "v1",
+		FunctionBlocks: map[string]*pb.FBConfig{
+			"fb-rx": {Enabled: true, Parameters: []byte(parameters)},
+		},
+	}
+}
+
+func TestConfigController_RollbackToGeneration(t *testing.T) {
+	clientset := k8sfake.NewSimpleClientset()
+	c := newTestConfigController()
+	c.clientset = clientset
+	c.SetCRDName("pipeline")
+
+	good := testPipelineConfig(1, `{"next_fb":"fb-en-host:5000"}`)
+	c.BroadcastConfig(good, 1)
+	c.BroadcastConfig(testPipelineConfig(2, `{"next_fb":"fb-broken:5000"}`), 2)
+
+	stream := &fakeConfigStream{}
+	c.clients["fb-rx"] = map[string]*connectedClient{
+		"rx-0": {fbID: "fb-rx", instanceID: "rx-0", stream: stream, genAcked: 2},
+	}
+
+	newGeneration, err := c.RollbackToGeneration(1, "operator@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), newGeneration)
+
+	// The prior config is re-broadcast under the new generation
+	require.Len(t, stream.sent, 1)
+	assert.Equal(t, int64(3), stream.sent[0].Generation)
+	assert.Equal(t, int64(3), stream.sent[0].PipelineConfig.Generation)
+	assert.Equal(t, []byte(`{"next_fb":"fb-en-host:5000"}`), stream.sent[0].PipelineConfig.FunctionBlocks["fb-rx"].Parameters)
+
+	// Apart from the generation, the content matches the prior config exactly
+	rolledBack := proto.Clone(stream.sent[0].PipelineConfig).(*pb.PipelineConfig)
+	rolledBack.Generation = good.Generation
+	goodBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(good)
+	require.NoError(t, err)
+	rolledBackBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(rolledBack)
+	require.NoError(t, err)
+	assert.Equal(t, goodBytes, rolledBackBytes)
+
+	// A Kubernetes event records who rolled back
+	events, err := clientset.CoreV1().Events("default").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+	assert.Equal(t, "ConfigRollback", events.Items[0].Reason)
+	assert.Equal(t, "pipeline", events.Items[0].InvolvedObject.Name)
+	assert.Contains(t, events.Items[0].Message, "operator@example.com")
+
+	// The next CRD generation must still advance past the rollback
+	assert.Equal(t, int64(4), c.NextGeneration(3))
+}
+
+func TestConfigController_RestoreServedGeneration(t *testing.T) {
+	c := newTestConfigController()
+
+	// A rollback before the restart served generation 5 for CRD generation 3
+	c.RestoreServedGeneration(5)
+	assert.Equal(t, int64(5), c.ServedGeneration())
+	assert.Equal(t, int64(6), c.NextGeneration(3))
+
+	// A lower generation doesn't move it back
+	c.RestoreServedGeneration(2)
+	assert.Equal(t, int64(5), c.ServedGeneration())
+
+	// CRD generations past it are served as they are
+	assert.Equal(t, int64(7), c.NextGeneration(7))
+}
+
+func TestConfigController_RollbackToGeneration_Unknown(t *testing.T) {
+	c := newTestConfigController()
+	c.BroadcastConfig(testPipelineConfig(1, `{}`), 1)
+
+	_, err := c.RollbackToGeneration(5, "operator")
+	assert.Error(t, err)
+}
+
+func TestConfigController_HistoryIsBounded(t *testing.T) {
+	c := newTestConfigController()
+	for generation := int64(1); generation <= defaultHistorySize+2; generation++ {
+		c.BroadcastConfig(testPipelineConfig(generation, `{}`), generation)
+	}
+
+	generations := c.ConfigHistory()
+	assert.Len(t, generations, defaultHistorySize)
+	assert.Equal(t, int64(3), generations[0])
+	assert.Equal(t, int64(defaultHistorySize+2), generations[len(generations)-1])
+}
+
+func TestConfigController_RollbackHandler(t *testing.T) {
+	c := newTestConfigController()
+	c.BroadcastConfig(testPipelineConfig(1, `{}`), 1)
+	c.BroadcastConfig(testPipelineConfig(2, `{}`), 2)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/rollback?generation=1", nil)
+	req.Header.Set("X-Requested-By", "operator")
+	rec := httptest.NewRecorder()
+	c.RollbackHandler()(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"generation":3`)
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/rollback?generation=abc", nil)
+	rec = httptest.NewRecorder()
+	c.RollbackHandler()(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	req = httptest.NewRequest(http.MethodPost, "/admin/rollback?generation=5", nil)
+	rec = httptest.NewRecorder()
+	c.RollbackHandler()(rec, req)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// Followers turn rollbacks away as unavailable, to be retried on the
+	// leader
+	c.SetLeader(false)
+	req = httptest.NewRequest(http.MethodPost, "/admin/rollback?generation=1", nil)
+	rec = httptest.NewRecorder()
+	c.RollbackHandler()(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestConfigController_ConcurrentRollbacksTakeDistinctGenerations(t *testing.T) {
+	c := newTestConfigController()
+	c.BroadcastConfig(testPipelineConfig(1, `{}`), 1)
+
+	// Each rollback takes a generation of its own
+	var wg sync.WaitGroup
+	generations := make([]int64, 10)
+	for i := range generations {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			generation, err := c.RollbackToGeneration(1, "operator")
+			assert.NoError(t, err)
+			generations[i] = generation
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[int64]bool)
+	for _, generation := range generations {
+		assert.False(t, seen[generation], "generation %d allocated twice", generation)
+		seen[generation] = true
+	}
+	assert.Equal(t, int64(len(generations)+1), c.ServedGeneration())
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -15,11 +17,15 @@ import (
 
 	"eidc-tfk8s/internal/common/logging"
 	"eidc-tfk8s/pkg/fb"
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/opt"
-	"github.com/syndtr/goleveldb/leveldb/util"
+	"eidc-tfk8s/pkg/fb/dlq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 // Build information, injected at build time
@@ -43,20 +49,45 @@ var (
 	batchSize       = flag.Int("batch-size", 100, "Number of messages to replay in a batch")
 	waitMs          = flag.Int("wait-ms", 0, "Milliseconds to wait between batches")
 	deleteReplayed  = flag.Bool("delete-replayed", false, "Delete messages after replay")
+	backoffMs       = flag.Int("backoff-ms", 1000, "Milliseconds to back off when FB-RX pushes back without a retry hint")
+	maxBackoffs     = flag.Int("max-backoffs", 10, "Times to back off a message FB-RX pushes back before counting it as an error")
+	maxReconnectRetries = flag.Int("max-reconnect-retries", 10, "Times to retry a message while FB-RX is unreachable, e.g. restarting, before counting it as an error")
+	metricsAddr     = flag.String("metrics-addr", "", "Address to serve Prometheus metrics on (disabled if empty)")
+	orderedBy       = flag.String("ordered-by", "", "Replay messages with the same key in DB order: fb_sender or batch_id_prefix (unordered if empty)")
+	batchIDPrefixSep = flag.String("batch-id-prefix-sep", "-", "Separator ending the batch id prefix used by --ordered-by=batch_id_prefix")
+	statsOnly       = flag.Bool("stats-only", false, "Print statistics of the DLQ messages matching the filters and exit without replaying")
+	statsBucket     = flag.String("stats-bucket", statsBucketHour, "Time bucket messages are counted by with --stats-only: hour or day")
+	reportFormat    = flag.String("report-format", reportFormatJSON, "Format of the --stats-only and --validate-only reports: json or csv")
+	validateOnly    = flag.Bool("validate-only", false, "Run the DLQ messages matching the filters through an FB in process, without forwarding them, and report which would now succeed")
+	validateFB      = flag.String("validate-fb", "fb-cl", "FB to run messages through with --validate-only: fb-cl, fb-rl or fb-tap")
+	validateConfig  = flag.String("validate-config", "", "Path to the JSON config of the FB run with --validate-only")
+)
+
+// Keys messages can be ordered by
+const (
+	orderByFBSender      = "fb_sender"
+	orderByBatchIDPrefix = "batch_id_prefix"
+)
+
+// maxReconnectBackoff caps the wait between retries while FB-RX is
+// unreachable
+const maxReconnectBackoff = 30 * time.Second
+
+// Metrics
+var (
+	replayBackoffTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "replay_backoff_total",
+		Help: "Total number of times a replay backed off because FB-RX pushed back",
+	})
+
+	replayReconnectsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "replay_reconnects_total",
+		Help: "Total number of times a replay waited for FB-RX to become reachable again and resent a message",
+	})
 )
 
 // DLQMessage is the structure of a message stored in the DLQ
-type DLQMessage struct {
-	BatchID        string            `json:"batch_id"`
-	Data           []byte            `json:"data"`
-	Format         string            `json:"format"`
-	Timestamp      time.Time         `json:"timestamp"`
-	ErrorCode      string            `json:"error_code"`
-	ErrorMessage   string            `json:"error_message"`
-	FBSender       string            `json:"fb_sender"`
-	InternalLabels map[string]string `json:"internal_labels"`
-	Metadata       map[string]string `json:"metadata"`
-}
+type DLQMessage = dlq.Message
 
 // ReplayStats tracks replay statistics
 type ReplayStats struct {
@@ -97,6 +128,23 @@ func main() {
 		cancel()
 	}()
 
+	// Serve metrics if requested
+	if *metricsAddr != "" {
+		http.Handle("/metrics", promhttp.Handler())
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, nil); err != nil {
+				logger.Error("Metrics server failed", err, nil)
+			}
+		}()
+	}
+
+	// Check the ordering key
+	switch *orderedBy {
+	case "", orderByFBSender, orderByBatchIDPrefix:
+	default:
+		logger.Fatal("Invalid --ordered-by value", fmt.Errorf("unknown ordering key: %s", *orderedBy), nil)
+	}
+
 	// Parse time filters
 	var since, until time.Time
 	var err error
@@ -113,17 +161,30 @@ func main() {
 		}
 	}
 
+	// Print statistics instead of replaying if requested
+	if *statsOnly {
+		if err := printStats(ctx, os.Stdout, since, until); err != nil {
+			logger.Fatal("Failed to collect DLQ statistics", err, nil)
+		}
+		return
+	}
+
+	// Report which messages would now get through an FB instead of replaying
+	// if requested
+	if *validateOnly {
+		if err := printValidation(ctx, os.Stdout, since, until); err != nil {
+			logger.Fatal("Failed to validate DLQ messages", err, nil)
+		}
+		return
+	}
+
 	// Connect to FB-RX
 	var fbRxConn *grpc.ClientConn
 	var fbRxClient fb.ChainPushServiceClient
 
 	if !*dryRun {
 		logger.Info("Connecting to FB-RX", map[string]interface{}{"addr": *fbRxAddr})
-		fbRxConn, err = grpc.DialContext(ctx, *fbRxAddr,
-			grpc.WithTransportCredentials(insecure.NewCredentials()),
-			grpc.WithBlock(),
-			grpc.WithTimeout(5*time.Second),
-		)
+		fbRxConn, err = dialFBRX(*fbRxAddr)
 		if err != nil {
 			logger.Fatal("Failed to connect to FB-RX", err, nil)
 		}
@@ -137,15 +198,14 @@ func main() {
 	}
 
 	// Open DLQ storage
-	if *dlqBackend == "leveldb" {
-		err := replayFromLevelDB(ctx, logger, fbRxClient, stats, since, until)
-		if err != nil {
-			logger.Fatal("Failed to replay from LevelDB", err, nil)
-		}
-	} else if *dlqBackend == "kafka" {
-		logger.Error("Kafka backend not implemented", fmt.Errorf("not implemented"), nil)
-	} else {
-		logger.Fatal("Unknown DLQ backend", fmt.Errorf("unknown DLQ backend: %s", *dlqBackend), nil)
+	store, err := openStore(false)
+	if err != nil {
+		logger.Fatal("Failed to open DLQ", err, nil)
+	}
+	defer store.Close()
+
+	if err := replayFromStore(ctx, logger, fbRxClient, store, stats, since, until); err != nil {
+		logger.Fatal("Failed to replay from DLQ", err, nil)
 	}
 
 	logger.Info("DLQ replay complete", map[string]interface{}{
@@ -167,107 +227,177 @@ func main() {
 	}
 }
 
-// replayFromLevelDB replays messages from a LevelDB DLQ
-func replayFromLevelDB(ctx context.Context, logger *logging.Logger, client fb.ChainPushServiceClient, stats *ReplayStats, since, until time.Time) error {
-	// Ensure directory exists
-	if err := os.MkdirAll(*dlqPath, 0755); err != nil {
-		return fmt.Errorf("failed to create DLQ directory: %w", err)
-	}
+// dialFBRX creates a connection to FB-RX. The dial doesn't block: the
+// connection is made, and remade whenever FB-RX restarts, in the background,
+// while pushes that fail in the meantime are retried by pushWithBackoff.
+func dialFBRX(addr string) (*grpc.ClientConn, error) {
+	return grpc.Dial(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  100 * time.Millisecond,
+				Multiplier: 1.6,
+				Jitter:     0.2,
+				MaxDelay:   5 * time.Second,
+			},
+			MinConnectTimeout: 5 * time.Second,
+		}),
+	)
+}
 
-	// Open LevelDB
-	db, err := leveldb.OpenFile(*dlqPath, nil)
-	if err != nil {
-		return fmt.Errorf("failed to open LevelDB: %w", err)
+// openStore opens the configured DLQ backend
+func openStore(readOnly bool) (dlq.Store, error) {
+	switch *dlqBackend {
+	case "leveldb":
+		if !readOnly {
+			// Ensure directory exists
+			if err := os.MkdirAll(*dlqPath, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create DLQ directory: %w", err)
+			}
+		}
+		return dlq.OpenLevelDB(*dlqPath, readOnly)
+	case "kafka":
+		return nil, fmt.Errorf("kafka backend not implemented")
+	default:
+		return nil, fmt.Errorf("unknown DLQ backend: %s", *dlqBackend)
 	}
-	defer db.Close()
+}
 
+// replayFromStore replays messages from a DLQ store
+func replayFromStore(ctx context.Context, logger *logging.Logger, client fb.ChainPushServiceClient, store dlq.Store, stats *ReplayStats, since, until time.Time) error {
 	// Count total messages
-	count := 0
-	iter := db.NewIterator(nil, nil)
-	for iter.Next() {
-		count++
-	}
-	iter.Release()
-	if err := iter.Error(); err != nil {
+	count, err := store.Count(ctx, dlq.Filter{})
+	if err != nil {
 		return fmt.Errorf("error counting messages: %w", err)
 	}
 
 	stats.total = count
-	logger.Info("Opened DLQ database", map[string]interface{}{"count": count, "path": *dlqPath})
+	logger.Info("Opened DLQ", map[string]interface{}{"count": count, "path": *dlqPath})
 
-	// Create a channel to receive messages to replay
-	messageCh := make(chan struct {
-		key   []byte
-		value []byte
-	}, *batchSize)
+	// Start worker goroutines
+	pool := newReplayPool(*concurrency, *batchSize, *orderedBy, func(worker int, record dlq.Record) {
+		err := processMessage(ctx, logger, client, store, record, stats, since, until)
+		if err != nil {
+			logger.Error("Error processing message", err, nil)
+		}
 
-	// Create a wait group for worker goroutines
-	var wg sync.WaitGroup
+		// Wait if requested
+		if *waitMs > 0 {
+			time.Sleep(time.Duration(*waitMs) * time.Millisecond)
+		}
+	})
 
-	// Start worker goroutines
-	for i := 0; i < *concurrency; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for item := range messageCh {
-				err := processMessage(ctx, logger, client, db, item.key, item.value, stats, since, until)
-				if err != nil {
-					logger.Error("Error processing message", err, nil)
-				}
-
-				// Wait if requested
-				if *waitMs > 0 {
-					time.Sleep(time.Duration(*waitMs) * time.Millisecond)
-				}
-			}
-		}()
+	// Iterate through messages and send to workers. Filters are applied by
+	// the workers so filtered messages are counted.
+	err = store.Iterate(ctx, dlq.Filter{}, func(record dlq.Record) error {
+		pool.submit(record)
+		return nil
+	})
+
+	// Close queues and wait for workers to finish
+	pool.close()
+
+	if errors.Is(err, context.Canceled) {
+		logger.Info("Context cancelled, stopping replay", nil)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error iterating DLQ: %w", err)
+	}
+
+	return nil
+}
+
+// replayPool runs the replay workers. Unordered, all workers share one queue.
+// Ordered, each worker has its own queue and messages are routed by a hash
+// of their ordering key, so messages with the same key are replayed by one
+// worker in DB order while different keys replay in parallel.
+type replayPool struct {
+	orderedBy string
+	queues    []chan dlq.Record
+	wg        sync.WaitGroup
+}
+
+// newReplayPool starts workers calling process for each submitted record
+func newReplayPool(workers, queueSize int, orderedBy string, process func(worker int, record dlq.Record)) *replayPool {
+	if workers < 1 {
+		workers = 1
 	}
 
-	// Iterate through messages and send to workers
-	iter = db.NewIterator(nil, nil)
-	defer iter.Release()
+	queueCount := 1
+	if orderedBy != "" {
+		queueCount = workers
+	}
 
-	for iter.Next() {
-		// Check for context cancellation
-		select {
-		case <-ctx.Done():
-			logger.Info("Context cancelled, stopping replay", nil)
-			break
-		default:
-		}
+	p := &replayPool{
+		orderedBy: orderedBy,
+		queues:    make([]chan dlq.Record, queueCount),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan dlq.Record, queueSize)
+	}
 
-		// Queue message for processing
-		messageCh <- struct {
-			key   []byte
-			value []byte
-		}{
-			key:   append([]byte{}, iter.Key()...),
-			value: append([]byte{}, iter.Value()...),
-		}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func(worker int, queue chan dlq.Record) {
+			defer p.wg.Done()
+			for record := range queue {
+				process(worker, record)
+			}
+		}(i, p.queues[i%queueCount])
 	}
 
-	// Close channel and wait for workers to finish
-	close(messageCh)
-	wg.Wait()
+	return p
+}
 
-	if err := iter.Error(); err != nil {
-		return fmt.Errorf("error iterating DLQ: %w", err)
+// submit queues a record, blocking while its queue is full
+func (p *replayPool) submit(record dlq.Record) {
+	queue := p.queues[0]
+	if len(p.queues) > 1 {
+		hash := fnv.New32a()
+		hash.Write([]byte(orderingKey(p.orderedBy, record)))
+		queue = p.queues[hash.Sum32()%uint32(len(p.queues))]
 	}
+	queue <- record
+}
 
-	return nil
+// close stops accepting records and waits for the queued ones to be processed
+func (p *replayPool) close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// orderingKey returns the key a DLQ message is ordered by. Messages that
+// can't be parsed get the empty key; they fail the same way on any worker.
+func orderingKey(orderedBy string, record dlq.Record) string {
+	if record.Err != nil {
+		return ""
+	}
+
+	switch orderedBy {
+	case orderByFBSender:
+		return record.Message.FBSender
+	case orderByBatchIDPrefix:
+		prefix, _, _ := strings.Cut(record.Message.BatchID, *batchIDPrefixSep)
+		return prefix
+	default:
+		return ""
+	}
 }
 
 // processMessage processes a single message from the DLQ
-func processMessage(ctx context.Context, logger *logging.Logger, client fb.ChainPushServiceClient, db *leveldb.DB, key, value []byte, stats *ReplayStats, since, until time.Time) error {
-	// Parse message
-	var message DLQMessage
-	if err := json.Unmarshal(value, &message); err != nil {
+func processMessage(ctx context.Context, logger *logging.Logger, client fb.ChainPushServiceClient, store dlq.Store, record dlq.Record, stats *ReplayStats, since, until time.Time) error {
+	// Check the message could be parsed
+	if record.Err != nil {
 		stats.mu.Lock()
 		stats.errors++
 		stats.errorsByReason["unmarshal-error"]++
 		stats.mu.Unlock()
-		return fmt.Errorf("failed to unmarshal message: %w", err)
+		return record.Err
 	}
+	message := record.Message
 
 	// Apply filters
 	if !matchesFilters(message, since, until) {
@@ -285,6 +415,7 @@ func processMessage(ctx context.Context, logger *logging.Logger, client fb.Chain
 		}
 		message.InternalLabels["replay"] = "true"
 		message.InternalLabels["replay_timestamp"] = time.Now().Format(time.RFC3339)
+		fb.IncrementReplayCount(message.InternalLabels)
 
 		// Create replay request
 		req := &fb.MetricBatchRequest{
@@ -297,8 +428,8 @@ func processMessage(ctx context.Context, logger *logging.Logger, client fb.Chain
 			InternalLabels:   message.InternalLabels,
 		}
 
-		// Send to FB-RX
-		resp, err := client.PushMetrics(ctx, req)
+		// Send to FB-RX, backing off while it pushes back
+		resp, err := pushWithBackoff(ctx, logger, client, req)
 		if err != nil {
 			stats.mu.Lock()
 			stats.errors++
@@ -317,7 +448,7 @@ func processMessage(ctx context.Context, logger *logging.Logger, client fb.Chain
 
 		// Delete if requested
 		if *deleteReplayed {
-			if err := db.Delete(key, nil); err != nil {
+			if err := store.Delete(ctx, record.Key); err != nil {
 				logger.Error("Failed to delete replayed message", err, map[string]interface{}{
 					"batch_id": message.BatchID,
 				})
@@ -344,27 +475,106 @@ func processMessage(ctx context.Context, logger *logging.Logger, client fb.Chain
 	return nil
 }
 
-// matchesFilters checks if a message matches the specified filters
-func matchesFilters(message DLQMessage, since, until time.Time) bool {
-	// Time filter
-	if !since.IsZero() && message.Timestamp.Before(since) {
+// pushWithBackoff sends a batch to FB-RX. While FB-RX pushes back, it waits
+// for the retry hint and resends, up to --max-backoffs times. While FB-RX is
+// unreachable, e.g. restarting, it resends with exponential backoff, up to
+// --max-reconnect-retries times.
+func pushWithBackoff(ctx context.Context, logger *logging.Logger, client fb.ChainPushServiceClient, req *fb.MetricBatchRequest) (*fb.MetricBatchResponse, error) {
+	backoffs, reconnects := 0, 0
+	for {
+		resp, err := client.PushMetrics(ctx, req)
+
+		var wait time.Duration
+		if unreachable(ctx, err) && reconnects < *maxReconnectRetries {
+			reconnects++
+			wait = reconnectBackoff(reconnects)
+			replayReconnectsTotal.Inc()
+			logger.Warn("FB-RX unreachable, retrying", map[string]interface{}{
+				"batch_id":   req.BatchId,
+				"error":      err.Error(),
+				"wait_ms":    wait.Milliseconds(),
+				"reconnects": reconnects,
+			})
+		} else {
+			retryAfter, pushedBack := backpressureHint(resp, err)
+			if !pushedBack || backoffs >= *maxBackoffs {
+				return resp, err
+			}
+
+			backoffs++
+			wait = retryAfter
+			replayBackoffTotal.Inc()
+			logger.Debug("FB-RX pushed back, backing off", map[string]interface{}{
+				"batch_id":       req.BatchId,
+				"retry_after_ms": retryAfter.Milliseconds(),
+				"backoffs":       backoffs,
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// unreachable reports whether a push failed because FB-RX couldn't be
+// reached or didn't answer in time, as while it restarts, rather than because
+// the replay itself was cancelled
+func unreachable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
 		return false
 	}
-	if !until.IsZero() && message.Timestamp.After(until) {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
 		return false
 	}
+}
 
-	// Error code filter
-	if *errorCode != "" && message.ErrorCode != *errorCode {
-		return false
+// reconnectBackoff returns how long to wait before the nth retry while FB-RX
+// is unreachable, doubling from --backoff-ms up to maxReconnectBackoff
+func reconnectBackoff(n int) time.Duration {
+	wait := time.Duration(*backoffMs) * time.Millisecond
+	for i := 1; i < n && wait < maxReconnectBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxReconnectBackoff {
+		wait = maxReconnectBackoff
 	}
+	return wait
+}
 
-	// FB sender filter
-	if *fbSender != "" && message.FBSender != *fbSender {
-		return false
+// backpressureHint reports whether FB-RX pushed back a batch, either with a
+// throttled response or a ResourceExhausted error, and how long to wait
+// before resending it
+func backpressureHint(resp *fb.MetricBatchResponse, err error) (time.Duration, bool) {
+	retryAfter := time.Duration(*backoffMs) * time.Millisecond
+
+	if err != nil {
+		return retryAfter, status.Code(err) == codes.ResourceExhausted
+	}
+	if resp == nil || (resp.Status != fb.StatusThrottled && resp.ErrorCode != string(fb.ErrorCodeThrottled)) {
+		return 0, false
 	}
 
-	return true
+	if resp.RetryAfterMs > 0 {
+		retryAfter = time.Duration(resp.RetryAfterMs) * time.Millisecond
+	}
+	return retryAfter, true
+}
+
+// matchesFilters checks if a message matches the specified filters
+func matchesFilters(message DLQMessage, since, until time.Time) bool {
+	filter := dlq.Filter{
+		Since:     since,
+		Until:     until,
+		ErrorCode: *errorCode,
+		FBSender:  *fbSender,
+	}
+	return filter.Matches(message)
 }
 
 // parseTimeFilter parses a time filter string (e.g. "1h", "2d") into a time.Time
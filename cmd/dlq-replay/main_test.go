@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/dlq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// backpressureClient pushes back the first pushBacks calls, then accepts
+func backpressureClient(pushBacks int, pushBack func() (*fb.MetricBatchResponse, error)) (*fb.MockChainPushServiceClient, *int) {
+	calls := 0
+	return &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			calls++
+			if calls <= pushBacks {
+				return pushBack()
+			}
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	}, &calls
+}
+
+func TestPushWithBackoff_HonorsBackpressure(t *testing.T) {
+	logger := logging.NewLogger("dlq-replay")
+	req := &fb.MetricBatchRequest{BatchId: "batch-1", Replay: true}
+
+	pushBacks := map[string]func() (*fb.MetricBatchResponse, error){
+		"throttled response": func() (*fb.MetricBatchResponse, error) {
+			return &fb.MetricBatchResponse{
+				Status:       fb.StatusThrottled,
+				ErrorCode:    string(fb.ErrorCodeThrottled),
+				BatchId:      "batch-1",
+				RetryAfterMs: 1,
+			}, nil
+		},
+		"resource exhausted": func() (*fb.MetricBatchResponse, error) {
+			return nil, status.Error(codes.ResourceExhausted, "too many batches in flight")
+		},
+	}
+
+	*backoffMs = 1
+	for name, pushBack := range pushBacks {
+		t.Run(name, func(t *testing.T) {
+			client, calls := backpressureClient(2, pushBack)
+			backoffsBefore := testutil.ToFloat64(replayBackoffTotal)
+
+			resp, err := pushWithBackoff(context.Background(), logger, client, req)
+			require.NoError(t, err)
+			assert.Equal(t, fb.StatusSuccess, resp.Status)
+			assert.Equal(t, 3, *calls)
+			assert.Equal(t, float64(2), testutil.ToFloat64(replayBackoffTotal)-backoffsBefore)
+		})
+	}
+}
+
+func TestPushWithBackoff_GivesUpAfterMaxBackoffs(t *testing.T) {
+	logger := logging.NewLogger("dlq-replay")
+	req := &fb.MetricBatchRequest{BatchId: "batch-1", Replay: true}
+
+	*backoffMs = 1
+	*maxBackoffs = 2
+	defer func() { *maxBackoffs = 10 }()
+
+	client, calls := backpressureClient(5, func() (*fb.MetricBatchResponse, error) {
+		return nil, status.Error(codes.ResourceExhausted, "too many batches in flight")
+	})
+
+	_, err := pushWithBackoff(context.Background(), logger, client, req)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 3, *calls)
+}
+
+func TestPushWithBackoff_HardErrorsAreNotRetried(t *testing.T) {
+	logger := logging.NewLogger("dlq-replay")
+	req := &fb.MetricBatchRequest{BatchId: "batch-1", Replay: true}
+
+	client, calls := backpressureClient(1, func() (*fb.MetricBatchResponse, error) {
+		return &fb.MetricBatchResponse{
+			Status:    fb.StatusError,
+			ErrorCode: string(fb.ErrorCodeProcessingFailed),
+			BatchId:   "batch-1",
+		}, nil
+	})
+	backoffsBefore := testutil.ToFloat64(replayBackoffTotal)
+
+	resp, err := pushWithBackoff(context.Background(), logger, client, req)
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusError, resp.Status)
+	assert.Equal(t, 1, *calls)
+	assert.Equal(t, backoffsBefore, testutil.ToFloat64(replayBackoffTotal))
+}
+
+func TestPushWithBackoff_RetriesWhileUnreachable(t *testing.T) {
+	logger := logging.NewLogger("dlq-replay")
+	req := &fb.MetricBatchRequest{BatchId: "batch-1", Replay: true}
+
+	*backoffMs = 1
+	client, calls := backpressureClient(2, func() (*fb.MetricBatchResponse, error) {
+		return nil, status.Error(codes.Unavailable, "connection refused")
+	})
+	reconnectsBefore := testutil.ToFloat64(replayReconnectsTotal)
+
+	resp, err := pushWithBackoff(context.Background(), logger, client, req)
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, resp.Status)
+	assert.Equal(t, 3, *calls)
+	assert.Equal(t, float64(2), testutil.ToFloat64(replayReconnectsTotal)-reconnectsBefore)
+}
+
+// countingServer accepts batches, recording them, and calls restart once
+// when it has accepted restartAfter of them
+type countingServer struct {
+	mu           sync.Mutex
+	received     map[string]bool
+	restartAfter int
+	restart      func()
+}
+
+func (s *countingServer) PushMetrics(ctx context.Context, req *fb.MetricBatchRequest) (*fb.MetricBatchResponse, error) {
+	s.mu.Lock()
+	s.received[req.BatchId] = true
+	if len(s.received) == s.restartAfter {
+		s.restartAfter = 0
+		go s.restart()
+	}
+	s.mu.Unlock()
+	return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: req.BatchId}, nil
+}
+
+func TestReplayFromStore_SurvivesFBRXRestart(t *testing.T) {
+	logger := logging.NewLogger("dlq-replay")
+
+	messages := make([]DLQMessage, 20)
+	for i := range messages {
+		messages[i] = DLQMessage{BatchID: fmt.Sprintf("batch-%02d", i), Timestamp: time.Now(), Format: "json"}
+	}
+	store := seedDLQ(t, messages)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+
+	// FB-RX goes away after 5 batches and comes back on the same address
+	// a little later
+	var mu sync.Mutex
+	var server *grpc.Server
+	handler := &countingServer{received: make(map[string]bool), restartAfter: 5}
+	serve := func(lis net.Listener) {
+		s := grpc.NewServer()
+		fb.RegisterChainPushServiceServer(s, handler)
+		mu.Lock()
+		server = s
+		mu.Unlock()
+		go s.Serve(lis)
+	}
+	restarted := make(chan struct{})
+	handler.restart = func() {
+		defer close(restarted)
+		mu.Lock()
+		server.Stop()
+		mu.Unlock()
+
+		time.Sleep(200 * time.Millisecond)
+		lis, err := net.Listen("tcp", addr)
+		if !assert.NoError(t, err) {
+			return
+		}
+		serve(lis)
+	}
+	serve(lis)
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		server.Stop()
+	})
+
+	conn, err := dialFBRX(addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	*backoffMs = 20
+	*concurrency = 1
+	defer func() {
+		*backoffMs = 1000
+		*concurrency = 5
+	}()
+	reconnectsBefore := testutil.ToFloat64(replayReconnectsTotal)
+
+	stats := &ReplayStats{errorsByReason: make(map[string]int)}
+	require.NoError(t, replayFromStore(context.Background(), logger, fb.NewChainPushServiceClient(conn), store, stats, time.Time{}, time.Time{}))
+	<-restarted
+
+	// Every message got through; only the one that can't be parsed failed
+	assert.Equal(t, len(messages), stats.replayed)
+	assert.Equal(t, map[string]int{"unmarshal-error": 1}, stats.errorsByReason)
+	assert.Len(t, handler.received, len(messages))
+	assert.Greater(t, testutil.ToFloat64(replayReconnectsTotal)-reconnectsBefore, float64(0))
+}
+
+func TestReplayPool_OrderedBySender(t *testing.T) {
+	type processed struct {
+		worker  int
+		batchID string
+	}
+
+	var mu sync.Mutex
+	bySender := make(map[string][]processed)
+
+	pool := newReplayPool(4, 1, orderByFBSender, func(worker int, record dlq.Record) {
+		message := record.Message
+
+		mu.Lock()
+		defer mu.Unlock()
+		bySender[message.FBSender] = append(bySender[message.FBSender], processed{worker: worker, batchID: message.BatchID})
+	})
+
+	// Interleave senders in DB order
+	senders := []string{"fb-gw", "fb-dp", "fb-en-host", "fb-cl"}
+	const perSender = 50
+	for i := 0; i < perSender; i++ {
+		for _, sender := range senders {
+			batchID := fmt.Sprintf("%s-%03d", sender, i)
+			pool.submit(dlq.Record{Key: batchID, Message: DLQMessage{BatchID: batchID, FBSender: sender}})
+		}
+	}
+	pool.close()
+
+	for _, sender := range senders {
+		entries := bySender[sender]
+		require.Len(t, entries, perSender, sender)
+		for i, entry := range entries {
+			assert.Equal(t, entries[0].worker, entry.worker, sender)
+			assert.Equal(t, fmt.Sprintf("%s-%03d", sender, i), entry.batchID)
+		}
+	}
+}
+
+func TestOrderingKey(t *testing.T) {
+	record := dlq.Record{Message: DLQMessage{BatchID: "tenant-a-000123", FBSender: "fb-gw"}}
+
+	assert.Equal(t, "fb-gw", orderingKey(orderByFBSender, record))
+	assert.Equal(t, "tenant", orderingKey(orderByBatchIDPrefix, record))
+	assert.Equal(t, "", orderingKey(orderByFBSender, dlq.Record{Err: errors.New("not json")}))
+}
+
+// seedDLQ writes messages to a new LevelDB DLQ, plus one that can't be
+// parsed, and opens it read-only
+func seedDLQ(t *testing.T, messages []DLQMessage) dlq.Store {
+	path := filepath.Join(t.TempDir(), "dlq")
+	db, err := leveldb.OpenFile(path, nil)
+	require.NoError(t, err)
+
+	for _, message := range messages {
+		value, err := json.Marshal(message)
+		require.NoError(t, err)
+		require.NoError(t, db.Put([]byte(message.BatchID), value, nil))
+	}
+	require.NoError(t, db.Put([]byte("corrupt"), []byte("not json"), nil))
+	require.NoError(t, db.Close())
+
+	store, err := dlq.OpenLevelDB(path, true)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStatsFromLevelDB(t *testing.T) {
+	base := time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC)
+	store := seedDLQ(t, []DLQMessage{
+		{BatchID: "batch-1", Timestamp: base, ErrorCode: "ERR_FORWARDING_FAILED", FBSender: "fb-gw"},
+		{BatchID: "batch-2", Timestamp: base.Add(30 * time.Minute), ErrorCode: "ERR_FORWARDING_FAILED", FBSender: "fb-dp"},
+		{BatchID: "batch-3", Timestamp: base.Add(2 * time.Hour), ErrorCode: "ERR_INVALID_INPUT", FBSender: "fb-gw"},
+		{BatchID: "batch-4", Timestamp: base.Add(26 * time.Hour), ErrorCode: "ERR_FORWARDING_FAILED", FBSender: "fb-gw"},
+	})
+
+	*statsBucket = statsBucketHour
+	stats, err := statsFromStore(context.Background(), store, time.Time{}, time.Time{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, stats.Total)
+	assert.Equal(t, 1, stats.Unreadable)
+	assert.Greater(t, stats.TotalBytes, int64(0))
+	assert.True(t, base.Equal(stats.Oldest))
+	assert.True(t, base.Add(26*time.Hour).Equal(stats.Newest))
+	assert.Equal(t, int64(26*time.Hour/time.Second), stats.OldestAgeSeconds-stats.NewestAgeSeconds)
+	assert.Equal(t, map[string]int{"ERR_FORWARDING_FAILED": 3, "ERR_INVALID_INPUT": 1}, stats.ByErrorCode)
+	assert.Equal(t, map[string]int{"fb-gw": 3, "fb-dp": 1}, stats.ByFBSender)
+	assert.Equal(t, map[string]int{
+		"2024-03-01T10:00:00Z": 2,
+		"2024-03-01T12:00:00Z": 1,
+		"2024-03-02T12:00:00Z": 1,
+	}, stats.ByTimeBucket)
+
+	// Daily buckets, filtered by sender
+	*statsBucket = statsBucketDay
+	*fbSender = "fb-gw"
+	defer func() {
+		*statsBucket = statsBucketHour
+		*fbSender = ""
+	}()
+
+	stats, err = statsFromStore(context.Background(), store, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Total)
+	assert.Equal(t, map[string]int{"2024-03-01T00:00:00Z": 2, "2024-03-02T00:00:00Z": 1}, stats.ByTimeBucket)
+
+	// CSV report
+	var report bytes.Buffer
+	require.NoError(t, writeStats(&report, stats, reportFormatCSV))
+	rows, err := csv.NewReader(&report).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dimension", "key", "value"}, rows[0])
+	assert.Contains(t, rows, []string{"total", "", "3"})
+	assert.Contains(t, rows, []string{"oldest", "", "2024-03-01T10:15:00Z"})
+	assert.Contains(t, rows, []string{"error_code", "ERR_FORWARDING_FAILED", "2"})
+	assert.Contains(t, rows, []string{"day", "2024-03-02T00:00:00Z", "1"})
+
+	// JSON report
+	report.Reset()
+	require.NoError(t, writeStats(&report, stats, reportFormatJSON))
+	var decoded DLQStats
+	require.NoError(t, json.Unmarshal(report.Bytes(), &decoded))
+	assert.Equal(t, stats.ByFBSender, decoded.ByFBSender)
+}
+
+func TestValidateFromStore_ClassifiesPIILeakAsStillFailing(t *testing.T) {
+	store := seedDLQ(t, []DLQMessage{
+		{
+			BatchID:   "batch-clean",
+			Data:      []byte(`[{"name":"process.cpu","value":1,"labels":{"command_line_hash":"0123456789abcdef"}}]`),
+			Format:    "json",
+			ErrorCode: "ERR_FORWARDING_FAILED",
+			FBSender:  "fb-cl",
+		},
+		{
+			BatchID:   "batch-leak",
+			Data:      []byte(`process.cpu{command_line: /usr/bin/backup --password=hunter2} 1`),
+			Format:    "prometheus",
+			ErrorCode: "ERR_PII_LEAK",
+			FBSender:  "fb-cl",
+		},
+	})
+
+	block, stop, err := startValidator(context.Background(), "fb-cl", []byte(`{
+		"common": {"next_fb": "fb-gw:5000", "dlq": "fb-dlq:5000"},
+		"salt_secret_name": "pii-salt",
+		"salt_secret_key": "salt"
+	}`))
+	require.NoError(t, err)
+	defer stop()
+
+	report, err := validateFromStore(context.Background(), block, store, time.Time{}, time.Time{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "fb-cl", report.FB)
+	assert.Equal(t, 2, report.Total)
+	assert.Equal(t, 1, report.WouldSucceed)
+	assert.Equal(t, 1, report.WouldFail)
+	assert.Equal(t, 1, report.Unreadable)
+	assert.Equal(t, map[string]int{"ERR_PII_LEAK": 1}, report.ByErrorCode)
+
+	results := make(map[string]ValidationResult)
+	for _, result := range report.Results {
+		results[result.BatchID] = result
+	}
+	assert.Equal(t, outcomeWouldSucceed, results["batch-clean"].Outcome)
+	assert.Equal(t, outcomeWouldFail, results["batch-leak"].Outcome)
+	assert.Equal(t, "ERR_PII_LEAK", results["batch-leak"].ErrorCode)
+
+	// CSV report
+	var csvReport bytes.Buffer
+	require.NoError(t, writeValidation(&csvReport, report, reportFormatCSV))
+	rows, err := csv.NewReader(&csvReport).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"batch_id", "fb_sender", "original_error_code", "outcome", "error_code"}, rows[0])
+	assert.Contains(t, rows, []string{"batch-leak", "fb-cl", "ERR_PII_LEAK", outcomeWouldFail, "ERR_PII_LEAK"})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"eidc-tfk8s/pkg/fb/dlq"
+)
+
+// Time buckets messages can be counted by
+const (
+	statsBucketHour = "hour"
+	statsBucketDay  = "day"
+)
+
+// Formats the statistics can be reported in
+const (
+	reportFormatJSON = "json"
+	reportFormatCSV  = "csv"
+)
+
+// DLQStats summarizes the messages in the DLQ
+type DLQStats struct {
+	// Total is the number of messages matching the filters
+	Total int `json:"total"`
+
+	// TotalBytes is the stored size of the matching messages
+	TotalBytes int64 `json:"total_bytes"`
+
+	// Unreadable is the number of messages that couldn't be parsed
+	Unreadable int `json:"unreadable"`
+
+	// Oldest and Newest are when the earliest and latest matching messages
+	// were dead-lettered, with their age when the report was made
+	Oldest           time.Time `json:"oldest"`
+	Newest           time.Time `json:"newest"`
+	OldestAgeSeconds int64     `json:"oldest_age_seconds"`
+	NewestAgeSeconds int64     `json:"newest_age_seconds"`
+
+	// Message counts by error code, sending FB and time bucket. Buckets are
+	// keyed by their start in RFC 3339.
+	Bucket       string         `json:"bucket"`
+	ByErrorCode  map[string]int `json:"by_error_code"`
+	ByFBSender   map[string]int `json:"by_fb_sender"`
+	ByTimeBucket map[string]int `json:"by_time_bucket"`
+}
+
+// newDLQStats creates empty statistics counting by the given time bucket
+func newDLQStats(bucket string) *DLQStats {
+	return &DLQStats{
+		Bucket:       bucket,
+		ByErrorCode:  make(map[string]int),
+		ByFBSender:   make(map[string]int),
+		ByTimeBucket: make(map[string]int),
+	}
+}
+
+// add counts a stored message
+func (s *DLQStats) add(record dlq.Record, since, until time.Time) {
+	if record.Err != nil {
+		s.Unreadable++
+		return
+	}
+	message := record.Message
+	if !matchesFilters(message, since, until) {
+		return
+	}
+
+	s.Total++
+	s.TotalBytes += int64(record.Size)
+	s.ByErrorCode[message.ErrorCode]++
+	s.ByFBSender[message.FBSender]++
+	s.ByTimeBucket[bucketStart(message.Timestamp, s.Bucket).Format(time.RFC3339)]++
+
+	if s.Oldest.IsZero() || message.Timestamp.Before(s.Oldest) {
+		s.Oldest = message.Timestamp
+	}
+	if s.Newest.IsZero() || message.Timestamp.After(s.Newest) {
+		s.Newest = message.Timestamp
+	}
+}
+
+// finish records the age of the oldest and newest messages
+func (s *DLQStats) finish(now time.Time) {
+	if s.Total == 0 {
+		return
+	}
+	s.OldestAgeSeconds = int64(now.Sub(s.Oldest).Seconds())
+	s.NewestAgeSeconds = int64(now.Sub(s.Newest).Seconds())
+}
+
+// bucketStart returns the start of the UTC hour or day a time falls in
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	if bucket == statsBucketDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// printStats collects statistics of the DLQ and writes them in the
+// configured report format
+func printStats(ctx context.Context, w io.Writer, since, until time.Time) error {
+	switch *statsBucket {
+	case statsBucketHour, statsBucketDay:
+	default:
+		return fmt.Errorf("unknown stats bucket: %s", *statsBucket)
+	}
+
+	store, err := openStore(true)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	stats, err := statsFromStore(ctx, store, since, until)
+	if err != nil {
+		return err
+	}
+
+	return writeStats(w, stats, *reportFormat)
+}
+
+// statsFromStore collects statistics of the messages in a DLQ store
+// matching the filters
+func statsFromStore(ctx context.Context, store dlq.Store, since, until time.Time) (*DLQStats, error) {
+	stats := newDLQStats(*statsBucket)
+
+	err := store.Iterate(ctx, dlq.Filter{}, func(record dlq.Record) error {
+		stats.add(record, since, until)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.finish(time.Now())
+	return stats, nil
+}
+
+// writeStats writes statistics as indented JSON, or as CSV rows of
+// dimension, key and value
+func writeStats(w io.Writer, stats *DLQStats, format string) error {
+	switch format {
+	case reportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	case reportFormatCSV:
+		return writeStatsCSV(w, stats)
+	default:
+		return fmt.Errorf("unknown report format: %s", format)
+	}
+}
+
+// writeStatsCSV writes statistics as CSV, totals first and then the counts
+// of each dimension sorted by key
+func writeStatsCSV(w io.Writer, stats *DLQStats) error {
+	writer := csv.NewWriter(w)
+
+	rows := [][]string{
+		{"dimension", "key", "value"},
+		{"total", "", strconv.Itoa(stats.Total)},
+		{"total_bytes", "", strconv.FormatInt(stats.TotalBytes, 10)},
+		{"unreadable", "", strconv.Itoa(stats.Unreadable)},
+	}
+	if stats.Total > 0 {
+		rows = append(rows,
+			[]string{"oldest", "", stats.Oldest.UTC().Format(time.RFC3339)},
+			[]string{"newest", "", stats.Newest.UTC().Format(time.RFC3339)},
+			[]string{"oldest_age_seconds", "", strconv.FormatInt(stats.OldestAgeSeconds, 10)},
+			[]string{"newest_age_seconds", "", strconv.FormatInt(stats.NewestAgeSeconds, 10)},
+		)
+	}
+	rows = appendCountRows(rows, "error_code", stats.ByErrorCode)
+	rows = appendCountRows(rows, "fb_sender", stats.ByFBSender)
+	rows = appendCountRows(rows, stats.Bucket, stats.ByTimeBucket)
+
+	if err := writer.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write CSV report: %w", err)
+	}
+	return nil
+}
+
+// appendCountRows appends a row per key of counts, sorted by key
+func appendCountRows(rows [][]string, dimension string, counts map[string]int) [][]string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		rows = append(rows, []string{dimension, key, strconv.Itoa(counts[key])})
+	}
+	return rows
+}
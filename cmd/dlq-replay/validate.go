@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/common/metrics"
+	"eidc-tfk8s/internal/common/tracing"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/cl"
+	"eidc-tfk8s/pkg/fb/dlq"
+	"eidc-tfk8s/pkg/fb/rl"
+	"eidc-tfk8s/pkg/fb/tap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// validateSinkAddr is the in-process address the validated FB forwards to.
+// The sink accepts every batch, so nothing leaves the tool.
+const validateSinkAddr = "dlq-replay-validate-sink"
+
+// Outcomes of validating a DLQ message
+const (
+	outcomeWouldSucceed = "would_succeed"
+	outcomeWouldFail    = "would_fail"
+)
+
+// validatedFBs constructs the function blocks messages can be validated
+// against, by name
+var validatedFBs = map[string]func() fb.FunctionBlock{
+	"fb-cl": func() fb.FunctionBlock {
+		return cl.NewClassifier(logging.NewLogger("fb-cl"), metrics.NewFBMetrics("fb-cl"), tracing.NewTracer("fb-cl"), "", "")
+	},
+	"fb-rl":  func() fb.FunctionBlock { return rl.NewRL() },
+	"fb-tap": func() fb.FunctionBlock { return tap.NewTap() },
+}
+
+// ValidationResult is whether a DLQ message would now get through a function
+// block, and if not the error code it fails with
+type ValidationResult struct {
+	BatchID           string `json:"batch_id"`
+	FBSender          string `json:"fb_sender"`
+	OriginalErrorCode string `json:"original_error_code"`
+	Outcome           string `json:"outcome"`
+	ErrorCode         string `json:"error_code,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// ValidationReport summarizes a validate-only pass over the DLQ
+type ValidationReport struct {
+	// FB is the function block the messages were run through
+	FB string `json:"fb"`
+
+	// Total is the number of messages matching the filters
+	Total        int `json:"total"`
+	WouldSucceed int `json:"would_succeed"`
+	WouldFail    int `json:"would_fail"`
+
+	// Unreadable is the number of messages that couldn't be parsed
+	Unreadable int `json:"unreadable"`
+
+	// ByErrorCode counts the messages that would fail by the code they fail
+	// with now
+	ByErrorCode map[string]int `json:"by_error_code"`
+
+	// Results has the outcome of each message, in DB order
+	Results []ValidationResult `json:"results"`
+}
+
+// sinkServer accepts every batch forwarded by the validated FB
+type sinkServer struct{}
+
+// PushMetrics implements fb.ChainPushServiceServer
+func (sinkServer) PushMetrics(ctx context.Context, req *fb.MetricBatchRequest) (*fb.MetricBatchResponse, error) {
+	return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: req.BatchId}, nil
+}
+
+// printValidation runs the DLQ messages matching the filters through the
+// --validate-fb function block and writes which would now succeed in the
+// configured report format
+func printValidation(ctx context.Context, w io.Writer, since, until time.Time) error {
+	if *validateConfig == "" {
+		return fmt.Errorf("--validate-config is required with --validate-only")
+	}
+	configBytes, err := os.ReadFile(*validateConfig)
+	if err != nil {
+		return fmt.Errorf("failed to read FB config: %w", err)
+	}
+
+	block, stop, err := startValidator(ctx, *validateFB, configBytes)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	store, err := openStore(true)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := validateFromStore(ctx, block, store, since, until)
+	if err != nil {
+		return err
+	}
+
+	return writeValidation(w, report, *reportFormat)
+}
+
+// startValidator constructs the named function block in process with its
+// config, forwarding to an in-process sink and without a DLQ or quarantine,
+// so that failures come back with their error code rather than being
+// dead-lettered again. The returned function shuts it down.
+func startValidator(ctx context.Context, fbName string, configBytes []byte) (fb.FunctionBlock, func(), error) {
+	newFB, ok := validatedFBs[fbName]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown FB to validate against: %s", fbName)
+	}
+
+	configBytes, err := validationConfig(configBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid FB config: %w", err)
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	fb.RegisterChainPushServiceServer(server, sinkServer{})
+	go server.Serve(lis)
+
+	block := newFB()
+	stop := func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		block.Shutdown(shutdownCtx)
+		server.Stop()
+	}
+
+	configCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := block.Initialize(configCtx); err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("failed to initialize %s: %w", fbName, err)
+	}
+
+	// Connect the FB to the sink in process
+	dialer, ok := block.(fb.Dialer)
+	if !ok {
+		stop()
+		return nil, nil, fmt.Errorf("%s can't be connected to the validation sink", fbName)
+	}
+	dialer.SetDialOptions(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+	if err := block.UpdateConfig(configCtx, configBytes, 1); err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("failed to apply config to %s: %w", fbName, err)
+	}
+
+	return block, stop, nil
+}
+
+// validationConfig points the common next_fb of an FB's JSON parameters at
+// the in-process sink, and removes its fanout, DLQ and quarantine
+func validationConfig(configBytes []byte) ([]byte, error) {
+	parameters := make(map[string]interface{})
+	if err := json.Unmarshal(configBytes, &parameters); err != nil {
+		return nil, err
+	}
+
+	common, ok := parameters["common"].(map[string]interface{})
+	if !ok {
+		common = make(map[string]interface{})
+		parameters["common"] = common
+	}
+	common["next_fb"] = validateSinkAddr
+	common["dlq"] = ""
+	delete(common, "next_fbs")
+	delete(common, "next_fb_mode")
+	delete(common, "quarantine")
+
+	return json.Marshal(parameters)
+}
+
+// validateFromStore runs the messages of a DLQ store matching the filters
+// through a function block, as they would be replayed
+func validateFromStore(ctx context.Context, block fb.FunctionBlock, store dlq.Store, since, until time.Time) (*ValidationReport, error) {
+	report := &ValidationReport{
+		FB:          block.Name(),
+		ByErrorCode: make(map[string]int),
+		Results:     []ValidationResult{},
+	}
+
+	err := store.Iterate(ctx, dlq.Filter{}, func(record dlq.Record) error {
+		if record.Err != nil {
+			report.Unreadable++
+			return nil
+		}
+		message := record.Message
+		if !matchesFilters(message, since, until) {
+			return nil
+		}
+
+		report.add(validateMessage(ctx, block, message))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// validateMessage processes a DLQ message with a function block, labelled
+// as a replay
+func validateMessage(ctx context.Context, block fb.FunctionBlock, message DLQMessage) ValidationResult {
+	labels := make(map[string]string, len(message.InternalLabels)+2)
+	for k, v := range message.InternalLabels {
+		labels[k] = v
+	}
+	labels["replay"] = "true"
+	labels["replay_timestamp"] = time.Now().Format(time.RFC3339)
+	fb.IncrementReplayCount(labels)
+
+	batch := &fb.MetricBatch{
+		BatchID:        message.BatchID,
+		Data:           append([]byte(nil), message.Data...),
+		Format:         message.Format,
+		Replay:         true,
+		Metadata:       message.Metadata,
+		InternalLabels: labels,
+	}
+
+	validation := ValidationResult{
+		BatchID:           message.BatchID,
+		FBSender:          message.FBSender,
+		OriginalErrorCode: message.ErrorCode,
+		Outcome:           outcomeWouldSucceed,
+	}
+
+	result, err := block.ProcessBatch(ctx, batch)
+	if err == nil && result != nil && result.Status == fb.StatusSuccess {
+		return validation
+	}
+
+	validation.Outcome = outcomeWouldFail
+	if result != nil {
+		validation.ErrorCode = string(result.ErrorCode)
+		validation.Error = result.ErrorMessage
+	}
+	if err != nil {
+		validation.Error = err.Error()
+	}
+	return validation
+}
+
+// add counts the outcome of a message
+func (r *ValidationReport) add(result ValidationResult) {
+	r.Total++
+	if result.Outcome == outcomeWouldSucceed {
+		r.WouldSucceed++
+	} else {
+		r.WouldFail++
+		r.ByErrorCode[result.ErrorCode]++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// writeValidation writes a validation report as indented JSON, or as CSV
+// rows of the outcome of each message
+func writeValidation(w io.Writer, report *ValidationReport, format string) error {
+	switch format {
+	case reportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case reportFormatCSV:
+		return writeValidationCSV(w, report)
+	default:
+		return fmt.Errorf("unknown report format: %s", format)
+	}
+}
+
+// writeValidationCSV writes a row per message, in DB order
+func writeValidationCSV(w io.Writer, report *ValidationReport) error {
+	writer := csv.NewWriter(w)
+
+	rows := [][]string{{"batch_id", "fb_sender", "original_error_code", "outcome", "error_code"}}
+	for _, result := range report.Results {
+		rows = append(rows, []string{result.BatchID, result.FBSender, result.OriginalErrorCode, result.Outcome, result.ErrorCode})
+	}
+
+	if err := writer.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write CSV report: %w", err)
+	}
+	return nil
+}
@@ -10,9 +10,12 @@ import (
 	"syscall"
 	"time"
 
+	"eidc-tfk8s/internal/common/instance"
 	"eidc-tfk8s/internal/common/logging"
 	"eidc-tfk8s/internal/common/metrics"
 	"eidc-tfk8s/internal/common/tracing"
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
 	"eidc-tfk8s/pkg/fb/cl"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -32,8 +35,10 @@ func main() {
 		configServiceAddr  = flag.String("config-service", "config-controller:5000", "Config controller gRPC service address")
 		nextFB             = flag.String("next-fb", "fb-dp:5000", "Next Function Block in the chain")
 		dlqServiceAddr     = flag.String("dlq-service", "fb-dlq:5000", "DLQ service address")
+		configFile         = flag.String("config-file", "", "Local config file to apply instead of the config service's; reloaded on SIGHUP")
 		otlpExporterAddr   = flag.String("otlp-exporter", "otel-collector:4317", "OTLP exporter address for traces")
 		traceSamplingRatio = flag.Float64("trace-sampling-ratio", 0.1, "Sampling ratio for traces (0.0-1.0)")
+		grpcReflection     = flag.Bool("grpc-reflection", false, "Register the gRPC reflection service for debugging with grpcurl; keep off in production")
 		saltSecretName     = flag.String("salt-secret-name", "pii-salt", "Name of the secret containing the PII salt")
 		saltSecretKey      = flag.String("salt-secret-key", "salt", "Key in the secret containing the PII salt value")
 	)
@@ -103,7 +108,8 @@ func main() {
 		logger.Fatal("Failed to initialize classifier", err, nil)
 	}
 
-	// Start the gRPC server for ChainPushService
+	// Start the gRPC server for ChainPushService, with reflection if enabled
+	classifier.SetReflectionEnabled(*grpcReflection)
 	grpcServer, err := cl.StartGRPCServer(ctx, classifier, *grpcPort)
 	if err != nil {
 		logger.Fatal("Failed to start gRPC server", err, nil)
@@ -115,6 +121,30 @@ func main() {
 		// Continue anyway, we'll retry connections as needed
 	}
 
+	// Apply the local config file, if any, reloading it on SIGHUP.
+	// Otherwise apply config updates from the config service,
+	// reinitializing the FB for updates that require a restart.
+	if *configFile != "" {
+		fileConfig := fb.NewFileConfig(*configFile, classifier)
+		if err := fileConfig.Reload(ctx); err != nil {
+			logger.Fatal("Failed to apply config file", err, map[string]interface{}{"path": *configFile})
+		}
+		fileConfig.ReloadOnSIGHUP(ctx, logger)
+	} else {
+		// Replicas stream config as their pod, from the Downward API
+		instanceID := instance.FromEnv().ID()
+		configClient, err := config.NewConfigClient("fb-cl", instanceID, *configServiceAddr, logger)
+		if err != nil {
+			logger.Error("Failed to create config client", err, nil)
+		} else {
+			defer configClient.Close()
+			fb.WatchConfig(ctx, configClient, classifier)
+			if err := configClient.Start(ctx); err != nil {
+				logger.Error("Failed to start config client", err, nil)
+			}
+		}
+	}
+
 	// Wait for termination
 	<-ctx.Done()
 	logger.Info("Shutting down", nil)
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/common/metrics"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/dlq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Build information, injected at build time
+var (
+	Version   string = "2.1.2-dev"
+	BuildTime string
+	CommitSHA string
+)
+
+func main() {
+	// Parse command-line flags
+	var (
+		grpcPort             = flag.Int("grpc-port", 5000, "gRPC service port")
+		metricsPort          = flag.Int("metrics-port", 2112, "Prometheus metrics port")
+		dlqPath              = flag.String("dlq-path", "/data/dlq", "Path to the DLQ storage")
+		messageTTLSeconds    = flag.Int("message-ttl-seconds", 0, "Seconds a message is kept after it was dead-lettered; 0 keeps messages until they are replayed")
+		sweepIntervalSeconds = flag.Int("sweep-interval-seconds", 0, "Seconds between removals of expired messages (default 60)")
+	)
+	flag.Parse()
+
+	// Set up logging
+	logger := logging.NewLogger("fb-dlq")
+	logger.Info("Starting FB-DLQ", map[string]interface{}{
+		"version":    Version,
+		"build_time": BuildTime,
+		"commit":     CommitSHA,
+	})
+
+	expiry := dlq.ExpiryConfig{
+		MessageTTLSeconds:    *messageTTLSeconds,
+		SweepIntervalSeconds: *sweepIntervalSeconds,
+	}
+	if err := expiry.Validate(); err != nil {
+		logger.Fatal("Invalid expiry config", err, nil)
+	}
+
+	// Create context that listens for termination signals
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logger.Info("Received signal", map[string]interface{}{"signal": sig.String()})
+		cancel()
+	}()
+
+	// Open the store dlq-replay reads from
+	store, err := dlq.OpenLevelDB(*dlqPath, false)
+	if err != nil {
+		logger.Fatal("Failed to open DLQ storage", err, map[string]interface{}{"path": *dlqPath})
+	}
+	defer store.Close()
+
+	// Remove expired messages in the background until shutdown
+	service := dlq.NewService(store, expiry)
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		service.Run(ctx)
+	}()
+	logger.Info("Started DLQ expiry", map[string]interface{}{
+		"message_ttl":    expiry.MessageTTL().String(),
+		"sweep_interval": expiry.SweepInterval().String(),
+	})
+
+	// Set up metrics server
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("healthy"))
+	})
+	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ready"))
+	})
+
+	metricsServer := &http.Server{
+		Addr: fmt.Sprintf(":%d", *metricsPort),
+	}
+
+	go func() {
+		logger.Info("Starting metrics server", map[string]interface{}{"port": *metricsPort})
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Metrics server failed", err, nil)
+			cancel()
+		}
+	}()
+
+	// Start the gRPC server the function blocks dead-letter to
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *grpcPort))
+	if err != nil {
+		logger.Fatal("Failed to listen", err, map[string]interface{}{"port": *grpcPort})
+	}
+	grpcServer := grpc.NewServer(metrics.GRPCServerOptions("fb-dlq")...)
+	fb.RegisterChainPushServiceServer(grpcServer, service)
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+
+	go func() {
+		logger.Info("Starting gRPC server", map[string]interface{}{"port": *grpcPort})
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Error("gRPC server failed", err, nil)
+			cancel()
+		}
+	}()
+
+	// Wait for termination
+	<-ctx.Done()
+	logger.Info("Shutting down", nil)
+
+	// Graceful shutdown
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+
+	// Stop accepting batches and let those being stored finish
+	grpcServer.GracefulStop()
+	<-sweeperDone
+
+	// Shutdown metrics server
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error shutting down metrics server", err, nil)
+	}
+
+	logger.Info("Shutdown complete", nil)
+}
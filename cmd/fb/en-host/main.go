@@ -3,16 +3,18 @@ package main
 import (
 	"context"
 	"flag"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"eidc-tfk8s/internal/common/instance"
 	"eidc-tfk8s/internal/common/logging"
-	"eidc-tfk8s/internal/common/metrics"
 	"eidc-tfk8s/internal/common/tracing"
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
 	"eidc-tfk8s/pkg/fb/en-host"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -32,8 +34,11 @@ func main() {
 		configServiceAddr  = flag.String("config-service", "config-controller:5000", "Config controller gRPC service address")
 		nextFB             = flag.String("next-fb", "fb-en-k8s:5000", "Next Function Block in the chain")
 		dlqServiceAddr     = flag.String("dlq-service", "fb-dlq:5000", "DLQ service address")
+		configFile         = flag.String("config-file", "", "Local config file to apply instead of the config service's; reloaded on SIGHUP")
 		otlpExporterAddr   = flag.String("otlp-exporter", "otel-collector:4317", "OTLP exporter address for traces")
 		traceSamplingRatio = flag.Float64("trace-sampling-ratio", 0.1, "Sampling ratio for traces (0.0-1.0)")
+		grpcReflection     = flag.Bool("grpc-reflection", false, "Register the gRPC reflection service for debugging with grpcurl; keep off in production")
+		warmupHosts        = flag.String("warmup-hosts", "", "Comma-separated host ids to preload into the host info cache")
 	)
 	flag.Parse()
 
@@ -91,17 +96,18 @@ func main() {
 	}()
 
 	// Create and initialize FB-EN-HOST
-	fbMetrics := metrics.NewFBMetrics("fb-en-host")
-	tracer := tracing.NewTracer("fb-en-host")
-	
-	enricher := enhost.NewEnHost(logger, fbMetrics, tracer)
+	enricher := enhost.NewENHost()
+	if *warmupHosts != "" {
+		enricher.SetWarmupHostIDs(strings.Split(*warmupHosts, ","))
+	}
 	
 	// Initialize the enricher
 	if err := enricher.Initialize(ctx); err != nil {
 		logger.Fatal("Failed to initialize enricher", err, nil)
 	}
 
-	// Start the gRPC server for ChainPushService
+	// Start the gRPC server for ChainPushService, with reflection if enabled
+	enricher.SetReflectionEnabled(*grpcReflection)
 	grpcServer, err := enhost.StartGRPCServer(ctx, enricher, *grpcPort)
 	if err != nil {
 		logger.Fatal("Failed to start gRPC server", err, nil)
@@ -113,6 +119,30 @@ func main() {
 		// Continue anyway, we'll retry connections as needed
 	}
 
+	// Apply the local config file, if any, reloading it on SIGHUP.
+	// Otherwise apply config updates from the config service,
+	// reinitializing the FB for updates that require a restart.
+	if *configFile != "" {
+		fileConfig := fb.NewFileConfig(*configFile, enricher)
+		if err := fileConfig.Reload(ctx); err != nil {
+			logger.Fatal("Failed to apply config file", err, map[string]interface{}{"path": *configFile})
+		}
+		fileConfig.ReloadOnSIGHUP(ctx, logger)
+	} else {
+		// Replicas stream config as their pod, from the Downward API
+		instanceID := instance.FromEnv().ID()
+		configClient, err := config.NewConfigClient("fb-en-host", instanceID, *configServiceAddr, logger)
+		if err != nil {
+			logger.Error("Failed to create config client", err, nil)
+		} else {
+			defer configClient.Close()
+			fb.WatchConfig(ctx, configClient, enricher)
+			if err := configClient.Start(ctx); err != nil {
+				logger.Error("Failed to start config client", err, nil)
+			}
+		}
+	}
+
 	// Wait for termination
 	<-ctx.Done()
 	logger.Info("Shutting down", nil)
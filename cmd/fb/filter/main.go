@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"eidc-tfk8s/internal/common/instance"
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/common/tracing"
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/filter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Build information, injected at build time
+var (
+	Version   string = "2.1.2-dev"
+	BuildTime string
+	CommitSHA string
+)
+
+func main() {
+	// Parse command-line flags
+	var (
+		grpcPort           = flag.Int("grpc-port", 5000, "gRPC service port")
+		metricsPort        = flag.Int("metrics-port", 2112, "Prometheus metrics port")
+		configServiceAddr  = flag.String("config-service", "config-controller:5000", "Config controller gRPC service address")
+		configFile         = flag.String("config-file", "", "Local config file to apply instead of the config service's; reloaded on SIGHUP")
+		otlpExporterAddr   = flag.String("otlp-exporter", "otel-collector:4317", "OTLP exporter address for traces")
+		traceSamplingRatio = flag.Float64("trace-sampling-ratio", 0.1, "Sampling ratio for traces (0.0-1.0)")
+		grpcReflection     = flag.Bool("grpc-reflection", false, "Register the gRPC reflection service for debugging with grpcurl; keep off in production")
+	)
+	flag.Parse()
+
+	// Set up logging
+	logger := logging.NewLogger("fb-filter")
+	logger.Info("Starting FB-Filter", map[string]interface{}{
+		"version":    Version,
+		"build_time": BuildTime,
+		"commit":     CommitSHA,
+	})
+
+	// Create context that listens for termination signals
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Set up tracing
+	if err := tracing.NewTracer("fb-filter").InitTracer(ctx, *otlpExporterAddr, *traceSamplingRatio); err != nil {
+		logger.Fatal("Failed to initialize tracer", err, nil)
+	}
+
+	// Set up signal handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logger.Info("Received signal", map[string]interface{}{"signal": sig.String()})
+		cancel()
+	}()
+
+	// Create and initialize FB-Filter
+	filterFB := filter.NewFilter()
+	if err := filterFB.Initialize(ctx); err != nil {
+		logger.Fatal("Failed to initialize filter", err, nil)
+	}
+
+	// Set up metrics server, ready once config has been applied
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("healthy"))
+	})
+	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if filterFB.State() != fb.StateRunning {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(filterFB.State().String()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ready"))
+	})
+
+	metricsServer := &http.Server{
+		Addr: fmt.Sprintf(":%d", *metricsPort),
+	}
+
+	go func() {
+		logger.Info("Starting metrics server", map[string]interface{}{"port": *metricsPort})
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Metrics server failed", err, nil)
+			cancel()
+		}
+	}()
+
+	// Start the gRPC server for ChainPushService, with reflection if enabled
+	filterFB.SetReflectionEnabled(*grpcReflection)
+	grpcServer, err := filterFB.ServeGRPC(filterFB, *grpcPort, func(err error) {
+		logger.Error("gRPC server failed", err, nil)
+		cancel()
+	})
+	if err != nil {
+		logger.Fatal("Failed to start gRPC server", err, nil)
+	}
+	logger.Info("Started gRPC server", map[string]interface{}{"port": *grpcPort})
+
+	// Apply the local config file, if any, reloading it on SIGHUP.
+	// Otherwise apply config updates from the config service.
+	if *configFile != "" {
+		fileConfig := fb.NewFileConfig(*configFile, filterFB)
+		if err := fileConfig.Reload(ctx); err != nil {
+			logger.Fatal("Failed to apply config file", err, map[string]interface{}{"path": *configFile})
+		}
+		fileConfig.ReloadOnSIGHUP(ctx, logger)
+	} else {
+		// Replicas stream config as their pod, from the Downward API
+		instanceID := instance.FromEnv().ID()
+		configClient, err := config.NewConfigClient("fb-filter", instanceID, *configServiceAddr, logger)
+		if err != nil {
+			logger.Error("Failed to create config client", err, nil)
+		} else {
+			defer configClient.Close()
+			fb.WatchConfig(ctx, configClient, filterFB)
+			if err := configClient.Start(ctx); err != nil {
+				logger.Error("Failed to start config client", err, nil)
+			}
+		}
+	}
+
+	// Wait for termination
+	<-ctx.Done()
+	logger.Info("Shutting down", nil)
+
+	// Graceful shutdown
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+
+	// Shutdown FB-Filter, letting batches in flight finish
+	if err := filterFB.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error shutting down filter", err, nil)
+	}
+
+	// Shutdown metrics server
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error shutting down metrics server", err, nil)
+	}
+
+	// Gracefully stop the gRPC server
+	grpcServer.GracefulStop()
+
+	logger.Info("Shutdown complete", nil)
+}
@@ -4,20 +4,19 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"eidc-tfk8s/internal/common/instance"
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/common/tracing"
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/rx"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/sdk/resource"
-	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 )
 
 // Build information, injected at build time
@@ -30,145 +29,118 @@ var (
 func main() {
 	// Parse command-line flags
 	var (
-		grpcPort           = flag.Int("grpc-port", 4317, "OTLP gRPC port")
-		httpPort           = flag.Int("http-port", 4318, "OTLP HTTP port")
-		promPort           = flag.Int("prom-port", 9009, "Prometheus remote-write port")
 		metricsPort        = flag.Int("metrics-port", 2112, "Prometheus metrics port")
 		configServiceAddr  = flag.String("config-service", "config-controller:5000", "Config controller gRPC service address")
-		nextFB             = flag.String("next-fb", "fb-en-host:5000", "Next Function Block in the chain")
-		dlqServiceAddr     = flag.String("dlq-service", "fb-dlq:5000", "DLQ service address")
+		configFile         = flag.String("config-file", "", "Local config file to apply instead of the config service's; reloaded on SIGHUP")
 		otlpExporterAddr   = flag.String("otlp-exporter", "otel-collector:4317", "OTLP exporter address for traces")
 		traceSamplingRatio = flag.Float64("trace-sampling-ratio", 0.1, "Sampling ratio for traces (0.0-1.0)")
+		grpcReflection     = flag.Bool("grpc-reflection", false, "Register the gRPC reflection service for debugging with grpcurl; keep off in production")
 	)
 	flag.Parse()
 
-	// Set up logging with JSON format
-	logger := log.New(os.Stdout, "", 0)
-	logger.Printf(`{"level":"info","timestamp":"%s","message":"Starting FB-RX","version":"%s","build_time":"%s","commit":"%s"}`,
-		time.Now().Format(time.RFC3339), Version, BuildTime, CommitSHA)
-
-	// Set up tracing
-	shutdown, err := initTracer(context.Background(), *otlpExporterAddr, *traceSamplingRatio)
-	if err != nil {
-		logger.Printf(`{"level":"error","timestamp":"%s","message":"Failed to initialize tracer","error":"%s"}`,
-			time.Now().Format(time.RFC3339), err)
-		os.Exit(1)
-	}
-	defer shutdown()
+	// Set up logging
+	logger := logging.NewLogger("fb-rx")
+	logger.Info("Starting FB-RX", map[string]interface{}{
+		"version":    Version,
+		"build_time": BuildTime,
+		"commit":     CommitSHA,
+	})
 
 	// Create context that listens for termination signals
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Set up tracing
+	if err := tracing.NewTracer("fb-rx").InitTracer(ctx, *otlpExporterAddr, *traceSamplingRatio); err != nil {
+		logger.Fatal("Failed to initialize tracer", err, nil)
+	}
+
 	// Set up signal handling
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigCh
-		logger.Printf(`{"level":"info","timestamp":"%s","message":"Received signal","signal":"%s"}`,
-			time.Now().Format(time.RFC3339), sig)
+		logger.Info("Received signal", map[string]interface{}{"signal": sig.String()})
 		cancel()
 	}()
 
-	// Set up metrics server
+	// Create and initialize FB-RX. Its receivers are started from the
+	// endpoints of its config, with reflection if enabled.
+	receiver := rx.NewRX()
+	if err := receiver.Initialize(ctx); err != nil {
+		logger.Fatal("Failed to initialize RX", err, nil)
+	}
+	receiver.SetReflectionEnabled(*grpcReflection)
+
+	// Set up metrics server, ready once config has been applied
 	http.Handle("/metrics", promhttp.Handler())
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("healthy"))
 	})
 	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
-		// TODO: Implement proper readiness check
+		if receiver.State() != fb.StateRunning {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(receiver.State().String()))
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ready"))
 	})
 
 	metricsServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", *metricsPort),
-		Handler: nil,
+		Addr: fmt.Sprintf(":%d", *metricsPort),
 	}
 
 	go func() {
-		logger.Printf(`{"level":"info","timestamp":"%s","message":"Starting metrics server","port":%d}`,
-			time.Now().Format(time.RFC3339), *metricsPort)
+		logger.Info("Starting metrics server", map[string]interface{}{"port": *metricsPort})
 		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Printf(`{"level":"error","timestamp":"%s","message":"Metrics server failed","error":"%s"}`,
-				time.Now().Format(time.RFC3339), err)
+			logger.Error("Metrics server failed", err, nil)
 			cancel()
 		}
 	}()
 
-	// TODO: Initialize config watcher
-	logger.Printf(`{"level":"info","timestamp":"%s","message":"Connecting to config service","address":"%s"}`,
-		time.Now().Format(time.RFC3339), *configServiceAddr)
-
-	// TODO: Initialize receivers
-	logger.Printf(`{"level":"info","timestamp":"%s","message":"Starting OTLP/gRPC receiver","port":%d}`,
-		time.Now().Format(time.RFC3339), *grpcPort)
-	logger.Printf(`{"level":"info","timestamp":"%s","message":"Starting OTLP/HTTP receiver","port":%d}`,
-		time.Now().Format(time.RFC3339), *httpPort)
-	logger.Printf(`{"level":"info","timestamp":"%s","message":"Starting Prometheus remote-write receiver","port":%d}`,
-		time.Now().Format(time.RFC3339), *promPort)
-
-	// TODO: Initialize connections to next FB and DLQ
-	logger.Printf(`{"level":"info","timestamp":"%s","message":"Connecting to next FB","address":"%s"}`,
-		time.Now().Format(time.RFC3339), *nextFB)
-	logger.Printf(`{"level":"info","timestamp":"%s","message":"Connecting to DLQ service","address":"%s"}`,
-		time.Now().Format(time.RFC3339), *dlqServiceAddr)
-
-	// TODO: Initialize and start the actual receivers and processors
+	// Apply the local config file, if any, reloading it on SIGHUP.
+	// Otherwise apply config updates from the config service.
+	if *configFile != "" {
+		fileConfig := fb.NewFileConfig(*configFile, receiver)
+		if err := fileConfig.Reload(ctx); err != nil {
+			logger.Fatal("Failed to apply config file", err, map[string]interface{}{"path": *configFile})
+		}
+		fileConfig.ReloadOnSIGHUP(ctx, logger)
+	} else {
+		// Replicas stream config as their pod, from the Downward API
+		instanceID := instance.FromEnv().ID()
+		configClient, err := config.NewConfigClient("fb-rx", instanceID, *configServiceAddr, logger)
+		if err != nil {
+			logger.Error("Failed to create config client", err, nil)
+		} else {
+			defer configClient.Close()
+			fb.WatchConfig(ctx, configClient, receiver)
+			if err := configClient.Start(ctx); err != nil {
+				logger.Error("Failed to start config client", err, nil)
+			}
+		}
+	}
 
 	// Wait for termination
 	<-ctx.Done()
-	logger.Printf(`{"level":"info","timestamp":"%s","message":"Shutting down"}`, time.Now().Format(time.RFC3339))
+	logger.Info("Shutting down", nil)
 
 	// Graceful shutdown
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
-	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
-		logger.Printf(`{"level":"error","timestamp":"%s","message":"Error shutting down metrics server","error":"%s"}`,
-			time.Now().Format(time.RFC3339), err)
+	// Shutdown FB-RX, stopping its receivers and letting batches in flight
+	// finish
+	if err := receiver.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error shutting down RX", err, nil)
 	}
 
-	// TODO: Clean shutdown of other components
-
-	logger.Printf(`{"level":"info","timestamp":"%s","message":"Shutdown complete"}`, time.Now().Format(time.RFC3339))
-}
-
-// initTracer initializes the OpenTelemetry tracer
-func initTracer(ctx context.Context, exporterEndpoint string, samplingRatio float64) (func(), error) {
-	exporter, err := otlptracegrpc.New(ctx,
-		otlptracegrpc.WithEndpoint(exporterEndpoint),
-		otlptracegrpc.WithInsecure(),
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceNameKey.String("fb-rx"),
-			semconv.ServiceVersionKey.String(Version),
-			attribute.String("environment", "dev-lab"),
-		),
-	)
-	if err != nil {
-		return nil, err
+	// Shutdown metrics server
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error shutting down metrics server", err, nil)
 	}
 
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(samplingRatio)),
-		sdktrace.WithBatcher(exporter),
-		sdktrace.WithResource(res),
-	)
-	otel.SetTracerProvider(tp)
-
-	return func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := tp.Shutdown(ctx); err != nil {
-			log.Printf(`{"level":"error","timestamp":"%s","message":"Error shutting down tracer provider","error":"%s"}`,
-				time.Now().Format(time.RFC3339), err)
-		}
-	}, nil
+	logger.Info("Shutdown complete", nil)
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/recording"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Build information, injected at build time
+var (
+	Version   string = "2.1.2-dev"
+	BuildTime string
+	CommitSHA string
+)
+
+// Command line flags
+var (
+	recordingPath = flag.String("recording", "", "Path of the recording written by FB-RX in record mode, its rotated files included")
+	target        = flag.String("target", "localhost:5000", "Address of the FB to send the recorded requests to")
+	speed         = flag.Float64("speed", 1, "Replay speed relative to the recording, e.g. 2 for twice as fast; 0 sends requests back to back")
+	timeout       = flag.Duration("timeout", 10*time.Second, "Timeout of each request")
+)
+
+// replayStats counts the outcomes of a replay
+type replayStats struct {
+	sent   int
+	failed int
+}
+
+// sleep waits for d or until ctx is done; tests replace it to replay
+// without waiting
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func main() {
+	// Parse command line flags
+	flag.Parse()
+
+	logger := logging.NewLogger("request-replay")
+	if *recordingPath == "" {
+		logger.Fatal("--recording is required", fmt.Errorf("missing recording path"), nil)
+	}
+	if *speed < 0 {
+		logger.Fatal("--speed must not be negative", fmt.Errorf("invalid speed %v", *speed), nil)
+	}
+	logger.Info("Starting request replay tool", map[string]interface{}{
+		"version":    Version,
+		"build_time": BuildTime,
+		"commit":     CommitSHA,
+		"recording":  *recordingPath,
+		"target":     *target,
+		"speed":      *speed,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logger.Info("Received signal", map[string]interface{}{"signal": sig.String()})
+		cancel()
+	}()
+
+	records, err := recording.ReadAll(*recordingPath)
+	if err != nil {
+		logger.Fatal("Failed to read recording", err, map[string]interface{}{"path": *recordingPath})
+	}
+
+	conn, err := grpc.Dial(*target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		logger.Fatal("Failed to connect to target", err, map[string]interface{}{"target": *target})
+	}
+	defer conn.Close()
+
+	stats, err := replay(ctx, fb.NewChainPushServiceClient(conn), records, *speed, *timeout, logger)
+	logger.Info("Replay finished", map[string]interface{}{
+		"requests": len(records),
+		"sent":     stats.sent,
+		"failed":   stats.failed,
+	})
+	if err != nil {
+		logger.Fatal("Replay interrupted", err, nil)
+	}
+	if stats.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// replay sends the recorded requests to client in order, spaced like they
+// arrived divided by speed, or back to back if speed is 0. Requests that
+// fail are logged and counted; the replay goes on.
+func replay(ctx context.Context, client fb.ChainPushServiceClient, records []*recording.Record, speed float64, timeout time.Duration, logger *logging.Logger) (replayStats, error) {
+	var stats replayStats
+	for i, rec := range records {
+		if i > 0 && speed > 0 {
+			if gap := rec.Time.Sub(records[i-1].Time); gap > 0 {
+				if err := sleep(ctx, time.Duration(float64(gap)/speed)); err != nil {
+					return stats, err
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, timeout)
+		resp, err := client.PushMetrics(reqCtx, rec.Request)
+		cancel()
+		if err == nil && resp.Status != fb.StatusSuccess {
+			err = fmt.Errorf("status %s: %s", resp.Status, resp.ErrorMessage)
+		}
+		if err != nil {
+			stats.failed++
+			logger.Warn("Failed to replay request", map[string]interface{}{
+				"batch_id": rec.Request.BatchId,
+				"error":    err.Error(),
+			})
+			continue
+		}
+		stats.sent++
+	}
+	return stats, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/recording"
+	"eidc-tfk8s/pkg/fb/rx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// collectingClient accepts every request, keeping it
+func collectingClient(received *[]*fb.MetricBatchRequest) *fb.MockChainPushServiceClient {
+	return &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			*received = append(*received, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	}
+}
+
+func TestRecordReplay_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rx.rec")
+
+	// Record the requests FB-RX receives
+	r := rx.NewRX()
+	require.NoError(t, r.Initialize(context.Background()))
+	rxConfig := rx.DefaultConfig()
+	rxConfig.Common.NextFB = "fb-next:5000"
+	for i := range rxConfig.Endpoints {
+		rxConfig.Endpoints[i].Enabled = false
+	}
+	rxConfig.Record = rx.RecordConfig{Enabled: true, Path: path}
+	configBytes, err := json.Marshal(rxConfig)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	var forwarded []*fb.MetricBatchRequest
+	r.SetNextFBClientForTesting(collectingClient(&forwarded))
+
+	sent := []*fb.MetricBatchRequest{
+		{
+			BatchId:        "batch-1",
+			Data:           []byte(`[{"name":"cpu","value":0.75,"labels":{"host":"web-1"}}]`),
+			Format:         "json",
+			Metadata:       map[string]string{fb.LabelTenantID: "acme"},
+			InternalLabels: map[string]string{"source": "otlp/grpc"},
+		},
+		{
+			BatchId: "batch-2",
+			Data:    []byte{0x0a, 0x03, 0x63, 0x70, 0x75, 0xff},
+			Format:  "otlp/protobuf",
+			Replay:  true,
+		},
+	}
+	for _, req := range sent {
+		batch := &fb.MetricBatch{
+			BatchID:        req.BatchId,
+			Data:           req.Data,
+			Format:         req.Format,
+			Replay:         req.Replay,
+			Metadata:       copyMap(req.Metadata),
+			InternalLabels: copyMap(req.InternalLabels),
+		}
+		_, err := r.ProcessBatch(context.Background(), batch)
+		require.NoError(t, err)
+	}
+	require.NoError(t, r.Shutdown(context.Background()))
+	require.Len(t, forwarded, 2)
+
+	// Replay the recording against a local pipeline
+	records, err := recording.ReadAll(path)
+	require.NoError(t, err)
+	var replayed []*fb.MetricBatchRequest
+	stats, err := replay(context.Background(), collectingClient(&replayed), records, 0, time.Second, logging.NewLogger("request-replay-test"))
+	require.NoError(t, err)
+	assert.Equal(t, replayStats{sent: 2}, stats)
+
+	// The pipeline gets the requests exactly as FB-RX received them, before
+	// RX stamped them
+	require.Len(t, replayed, 2)
+	for i, req := range replayed {
+		assert.Equal(t, sent[i].BatchId, req.BatchId)
+		assert.Equal(t, sent[i].Data, req.Data)
+		assert.Equal(t, sent[i].Format, req.Format)
+		assert.Equal(t, sent[i].Replay, req.Replay)
+		assert.Equal(t, sent[i].Metadata, req.Metadata)
+		assert.Equal(t, sent[i].InternalLabels, req.InternalLabels)
+	}
+	assert.NotContains(t, replayed[0].InternalLabels, fb.LabelIngestTimestamp)
+	assert.Contains(t, forwarded[0].InternalLabels, fb.LabelIngestTimestamp)
+}
+
+func TestReplay_PacesRequestsBySpeed(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	records := []*recording.Record{
+		{Time: start, Request: &fb.MetricBatchRequest{BatchId: "batch-1"}},
+		{Time: start.Add(2 * time.Second), Request: &fb.MetricBatchRequest{BatchId: "batch-2"}},
+		{Time: start.Add(3 * time.Second), Request: &fb.MetricBatchRequest{BatchId: "batch-3"}},
+	}
+
+	var waits []time.Duration
+	defer func(original func(context.Context, time.Duration) error) { sleep = original }(sleep)
+	sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	logger := logging.NewLogger("request-replay-test")
+	var replayed []*fb.MetricBatchRequest
+	_, err := replay(context.Background(), collectingClient(&replayed), records, 2, time.Second, logger)
+	require.NoError(t, err)
+	assert.Len(t, replayed, 3)
+	assert.Equal(t, []time.Duration{time.Second, 500 * time.Millisecond}, waits)
+
+	// Speed 0 sends the requests back to back
+	waits = nil
+	_, err = replay(context.Background(), collectingClient(&replayed), records, 0, time.Second, logger)
+	require.NoError(t, err)
+	assert.Empty(t, waits)
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
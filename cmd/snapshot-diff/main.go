@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"eidc-tfk8s/pkg/fb/snapshot"
+)
+
+// Build information, injected at build time
+var (
+	Version   string = "2.1.2-dev"
+	BuildTime string
+	CommitSHA string
+)
+
+// Command line flags
+var (
+	beforePath = flag.String("before", "", "File holding the batch data as it entered FB-RX")
+	afterPath  = flag.String("after", "", "File holding the batch data as it left FB-GW")
+	format     = flag.String("format", "", "Format of the batch data (detected from the content if empty)")
+	batchID    = flag.String("batch-id", "", "Batch id to report the diff for")
+	ignore     = flag.String("ignore", "", "Comma-separated attribute paths whose differences are intended, e.g. labels.k8s_*")
+)
+
+func main() {
+	// Parse command line flags
+	flag.Parse()
+
+	logger := logging.NewLogger("snapshot-diff")
+	if *beforePath == "" || *afterPath == "" {
+		logger.Fatal("Both --before and --after are required", fmt.Errorf("missing snapshot file"), nil)
+	}
+
+	before, err := readSnapshot(*beforePath)
+	if err != nil {
+		logger.Fatal("Failed to read snapshot", err, map[string]interface{}{"path": *beforePath})
+	}
+	after, err := readSnapshot(*afterPath)
+	if err != nil {
+		logger.Fatal("Failed to read snapshot", err, map[string]interface{}{"path": *afterPath})
+	}
+
+	var opts snapshot.Options
+	if *ignore != "" {
+		opts.Ignore = strings.Split(*ignore, ",")
+	}
+
+	diff := snapshot.Compare(before, after, opts)
+	diff.BatchID = *batchID
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(diff); err != nil {
+		logger.Fatal("Failed to write diff", err, nil)
+	}
+
+	// Exit non-zero on differences so scripts can assert on it
+	if !diff.Empty() {
+		os.Exit(1)
+	}
+}
+
+// readSnapshot decodes the batch data held in a file
+func readSnapshot(path string) ([]codec.Metric, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(&fb.MetricBatch{Data: data, Format: *format})
+}
@@ -0,0 +1,60 @@
+// Package instance identifies the replica a function block runs as, from the
+// pod fields Kubernetes exposes through the Downward API
+package instance
+
+import (
+	"os"
+)
+
+// Environment variables the pod spec sets from the Downward API, e.g.
+//
+//	env:
+//	- name: POD_NAME
+//	  valueFrom:
+//	    fieldRef:
+//	      fieldPath: metadata.name
+const (
+	EnvPodName      = "POD_NAME"
+	EnvPodNamespace = "POD_NAMESPACE"
+	EnvNodeName     = "NODE_NAME"
+)
+
+// Info is the identity of a function block replica. Fields are empty when
+// not running in Kubernetes, or when the pod spec doesn't set them.
+type Info struct {
+	PodName   string
+	Namespace string
+	NodeName  string
+}
+
+// FromEnv reads the identity of the replica from the environment
+func FromEnv() Info {
+	return Info{
+		PodName:   os.Getenv(EnvPodName),
+		Namespace: os.Getenv(EnvPodNamespace),
+		NodeName:  os.Getenv(EnvNodeName),
+	}
+}
+
+// ID returns the instance id of the replica: its pod name, or the hostname
+// outside Kubernetes
+func (i Info) ID() string {
+	if i.PodName != "" {
+		return i.PodName
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
+
+// Sender returns how the replica of the named function block identifies
+// itself to other FBs and the DLQ: "<fb>/<pod>", or just the FB name
+// without a pod name
+func (i Info) Sender(fbName string) string {
+	if i.PodName == "" {
+		return fbName
+	}
+	return fbName + "/" + i.PodName
+}
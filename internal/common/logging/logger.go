@@ -1,11 +1,16 @@
 package logging
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Level defines the logging level
@@ -42,6 +47,25 @@ func (l Level) String() string {
 	}
 }
 
+// ParseLevel parses a level name such as "debug" or "error". An empty string
+// maps to Info so that configs without a log level keep the default.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return Debug, nil
+	case "", "info":
+		return Info, nil
+	case "warn", "warning":
+		return Warn, nil
+	case "error":
+		return Error, nil
+	case "fatal":
+		return Fatal, nil
+	default:
+		return Info, fmt.Errorf("invalid log level: %s", s)
+	}
+}
+
 // LogEntry represents a structured log entry
 type LogEntry struct {
 	Level     string                 `json:"level"`
@@ -50,6 +74,8 @@ type LogEntry struct {
 	FB        string                 `json:"fb_name,omitempty"`
 	BatchID   string                 `json:"batch_id,omitempty"`
 	Error     string                 `json:"error,omitempty"`
+	TraceID   string                 `json:"trace_id,omitempty"`
+	SpanID    string                 `json:"span_id,omitempty"`
 	Fields    map[string]interface{} `json:"-"`
 }
 
@@ -57,14 +83,17 @@ type LogEntry struct {
 type Logger struct {
 	fbName string
 	writer io.Writer
+	level  atomic.Int32
 }
 
 // NewLogger creates a new logger for the specified function block
 func NewLogger(fbName string) *Logger {
-	return &Logger{
+	l := &Logger{
 		fbName: fbName,
 		writer: os.Stdout,
 	}
+	l.level.Store(int32(Info))
+	return l
 }
 
 // WithWriter sets the writer for the logger
@@ -73,8 +102,40 @@ func (l *Logger) WithWriter(writer io.Writer) *Logger {
 	return l
 }
 
+// SetLevel sets the minimum level that is written. It is safe to call while
+// other goroutines are logging.
+func (l *Logger) SetLevel(level Level) {
+	l.level.Store(int32(level))
+}
+
+// GetLevel returns the minimum level that is written
+func (l *Logger) GetLevel() Level {
+	return Level(l.level.Load())
+}
+
+// Enabled returns whether messages at the given level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.GetLevel()
+}
+
 // Log logs a message at the specified level
 func (l *Logger) Log(level Level, msg string, fields map[string]interface{}) {
+	l.log(level, msg, trace.SpanContext{}, fields)
+}
+
+// LogCtx logs a message at the specified level with the trace and span ids
+// of the span active in ctx, if any, so the line can be found from the trace
+func (l *Logger) LogCtx(ctx context.Context, level Level, msg string, fields map[string]interface{}) {
+	l.log(level, msg, trace.SpanContextFromContext(ctx), fields)
+}
+
+// log writes an entry, with the ids of spanContext if it is valid
+func (l *Logger) log(level Level, msg string, spanContext trace.SpanContext, fields map[string]interface{}) {
+	// Fatal is always written since it terminates the process
+	if level != Fatal && !l.Enabled(level) {
+		return
+	}
+
 	entry := LogEntry{
 		Level:     level.String(),
 		Timestamp: time.Now().Format(time.RFC3339),
@@ -83,6 +144,13 @@ func (l *Logger) Log(level Level, msg string, fields map[string]interface{}) {
 		Fields:    fields,
 	}
 
+	if spanContext.HasTraceID() {
+		entry.TraceID = spanContext.TraceID().String()
+	}
+	if spanContext.HasSpanID() {
+		entry.SpanID = spanContext.SpanID().String()
+	}
+
 	// Extract special fields
 	if batchID, ok := fields["batch_id"]; ok {
 		entry.BatchID = fmt.Sprintf("%v", batchID)
@@ -164,6 +232,30 @@ func (l *Logger) Fatal(msg string, err error, fields map[string]interface{}) {
 	l.Log(Fatal, msg, fields)
 }
 
+// DebugCtx logs a debug message with the trace and span ids of ctx
+func (l *Logger) DebugCtx(ctx context.Context, msg string, fields map[string]interface{}) {
+	l.LogCtx(ctx, Debug, msg, fields)
+}
+
+// InfoCtx logs an info message with the trace and span ids of ctx
+func (l *Logger) InfoCtx(ctx context.Context, msg string, fields map[string]interface{}) {
+	l.LogCtx(ctx, Info, msg, fields)
+}
+
+// WarnCtx logs a warning message with the trace and span ids of ctx
+func (l *Logger) WarnCtx(ctx context.Context, msg string, fields map[string]interface{}) {
+	l.LogCtx(ctx, Warn, msg, fields)
+}
+
+// ErrorCtx logs an error message with the trace and span ids of ctx
+func (l *Logger) ErrorCtx(ctx context.Context, msg string, err error, fields map[string]interface{}) {
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["error"] = err.Error()
+	l.LogCtx(ctx, Error, msg, fields)
+}
+
 // WithBatch adds the batch_id field to the logger context
 func (l *Logger) WithBatch(batchID string) *LoggerContext {
 	return &LoggerContext{
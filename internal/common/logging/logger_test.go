@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestLogger_CtxVariantsAddTraceAndSpanIDs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger("fb-test").WithWriter(&buf)
+
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background())
+	ctx, span := provider.Tracer("fb-test").Start(context.Background(), "process-batch")
+	defer span.End()
+
+	logger.ErrorCtx(ctx, "Failed to forward batch", errors.New("connection refused"), map[string]interface{}{
+		"batch_id": "batch-1",
+		"attempt":  2,
+	})
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "error", entry["level"])
+	assert.Equal(t, "batch-1", entry["batch_id"])
+	assert.Equal(t, "connection refused", entry["error"])
+	assert.Equal(t, float64(2), entry["attempt"])
+	assert.Equal(t, span.SpanContext().TraceID().String(), entry["trace_id"])
+	assert.Equal(t, span.SpanContext().SpanID().String(), entry["span_id"])
+
+	// Without an active span, or without a context, no ids are written
+	for _, log := range []func(){
+		func() { logger.InfoCtx(context.Background(), "Config updated", nil) },
+		func() { logger.Info("Config updated", nil) },
+	} {
+		buf.Reset()
+		log()
+		entry = nil
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.NotContains(t, entry, "trace_id")
+		assert.NotContains(t, entry, "span_id")
+	}
+}
+
+func TestLogger_CtxVariantsRespectLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger("fb-test").WithWriter(&buf)
+	logger.SetLevel(Warn)
+
+	logger.DebugCtx(context.Background(), "debug message", nil)
+	logger.InfoCtx(context.Background(), "info message", nil)
+	assert.Empty(t, buf.String())
+
+	logger.WarnCtx(context.Background(), "warn message", nil)
+	assert.Contains(t, buf.String(), "warn message")
+}
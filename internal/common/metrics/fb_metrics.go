@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -29,8 +31,29 @@ type FBMetrics struct {
 	ForwardingLatency      prometheus.Histogram
 }
 
-// NewFBMetrics creates a new set of standard metrics for a Function Block
+// fbMetrics holds the metrics registered for each FB name
+var (
+	fbMetricsMu sync.Mutex
+	fbMetrics   = make(map[string]*FBMetrics)
+)
+
+// NewFBMetrics returns the standard metrics for a Function Block. They are
+// registered once per FB name; FBs created again under the same name, as in
+// tests, share them.
 func NewFBMetrics(fbName string) *FBMetrics {
+	fbMetricsMu.Lock()
+	defer fbMetricsMu.Unlock()
+
+	if m, ok := fbMetrics[fbName]; ok {
+		return m
+	}
+	m := newFBMetrics(fbName)
+	fbMetrics[fbName] = m
+	return m
+}
+
+// newFBMetrics registers a new set of standard metrics for a Function Block
+func newFBMetrics(fbName string) *FBMetrics {
 	m := &FBMetrics{
 		FBName: fbName,
 	}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcServerRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_grpc_server_requests_total",
+		Help: "Total number of gRPC requests handled, by method and status code",
+	}, []string{"fb_name", "method", "code"})
+
+	grpcServerRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fb_grpc_server_request_duration_seconds",
+		Help:    "Time taken to handle gRPC requests, by method",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"fb_name", "method"})
+
+	grpcClientRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_grpc_client_requests_total",
+		Help: "Total number of gRPC requests sent, by method and status code",
+	}, []string{"fb_name", "method", "code"})
+
+	grpcClientRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fb_grpc_client_request_duration_seconds",
+		Help:    "Time taken for gRPC requests sent to complete, by method",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"fb_name", "method"})
+)
+
+// GRPCServerOptions returns the server options that record the count,
+// latency and status code of every RPC handled by the named component
+func GRPCServerOptions(fbName string) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(fbName)),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(fbName)),
+	}
+}
+
+// GRPCDialOption returns the dial option that records the count, latency
+// and status code of every unary RPC, such as a forwarded batch, sent by
+// the named component
+func GRPCDialOption(fbName string) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(UnaryClientInterceptor(fbName))
+}
+
+// UnaryServerInterceptor records unary RPCs handled
+func UnaryServerInterceptor(fbName string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		recordGRPCRequest(grpcServerRequestsTotal, grpcServerRequestDuration, fbName, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor records streaming RPCs handled, once the stream
+// ends
+func StreamServerInterceptor(fbName string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		recordGRPCRequest(grpcServerRequestsTotal, grpcServerRequestDuration, fbName, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor records unary RPCs sent
+func UnaryClientInterceptor(fbName string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		recordGRPCRequest(grpcClientRequestsTotal, grpcClientRequestDuration, fbName, method, start, err)
+		return err
+	}
+}
+
+// recordGRPCRequest records an RPC's status code and latency
+func recordGRPCRequest(requests *prometheus.CounterVec, duration *prometheus.HistogramVec, fbName, method string, start time.Time, err error) {
+	requests.WithLabelValues(fbName, method, status.Code(err).String()).Inc()
+	duration.WithLabelValues(fbName, method).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCInterceptors_RecordFailedCallStatusCode(t *testing.T) {
+	const method = "/grpc.health.v1.Health/Check"
+
+	// The health server fails checks of unknown services with NotFound
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(GRPCServerOptions("fb-server-test")...)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		GRPCDialOption("fb-client-test"),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(grpcServerRequestsTotal.WithLabelValues("fb-server-test", method, "NotFound")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(grpcServerRequestsTotal.WithLabelValues("fb-server-test", method, "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(grpcClientRequestsTotal.WithLabelValues("fb-client-test", method, "NotFound")))
+	assert.Equal(t, 1, testutil.CollectAndCount(grpcServerRequestDuration, "fb_grpc_server_request_duration_seconds"))
+	assert.Equal(t, 1, testutil.CollectAndCount(grpcClientRequestDuration, "fb_grpc_client_request_duration_seconds"))
+}
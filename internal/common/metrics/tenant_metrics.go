@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Tenant batch outcomes
+const (
+	TenantOutcomeReceived  = "received"
+	TenantOutcomeForwarded = "forwarded"
+	TenantOutcomeFailed    = "failed"
+	TenantOutcomeDropped   = "dropped"
+)
+
+const (
+	// MaxTenantLabelValues bounds the number of distinct tenant label values
+	// per function block; further tenants are counted as tenantOverflow
+	MaxTenantLabelValues = 100
+
+	// tenantOverflow is the tenant label used once MaxTenantLabelValues is reached
+	tenantOverflow = "_other"
+
+	// tenantNone is the tenant label for batches without a tenant id
+	tenantNone = "_none"
+)
+
+var tenantBatchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_tenant_batches_total",
+	Help: "Total number of batches per tenant by outcome (received, forwarded, failed, dropped)",
+}, []string{"fb_name", "tenant", "outcome"})
+
+// tenantLabels tracks the tenant label values seen by each function block
+var tenantLabels = struct {
+	sync.Mutex
+	seen map[string]map[string]struct{}
+}{seen: make(map[string]map[string]struct{})}
+
+// TenantLabel returns the label value to use for tenant, collapsing new
+// tenants into an overflow value once the function block has seen too many
+func TenantLabel(fbName, tenant string) string {
+	if tenant == "" {
+		return tenantNone
+	}
+
+	tenantLabels.Lock()
+	defer tenantLabels.Unlock()
+
+	seen, ok := tenantLabels.seen[fbName]
+	if !ok {
+		seen = make(map[string]struct{})
+		tenantLabels.seen[fbName] = seen
+	}
+
+	if _, ok := seen[tenant]; ok {
+		return tenant
+	}
+	if len(seen) >= MaxTenantLabelValues {
+		return tenantOverflow
+	}
+	seen[tenant] = struct{}{}
+	return tenant
+}
+
+// RecordTenantBatch records a batch outcome for a tenant
+func (m *FBMetrics) RecordTenantBatch(tenant, outcome string) {
+	tenantBatchesTotal.WithLabelValues(m.FBName, TenantLabel(m.FBName, tenant), outcome).Inc()
+}
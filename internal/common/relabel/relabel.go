@@ -0,0 +1,187 @@
+// Package relabel rewrites metric names and labels using rules modelled on
+// Prometheus relabel_configs.
+package relabel
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MetricNameLabel is the label holding the metric name, so rules can rename
+// metrics the same way they rewrite labels
+const MetricNameLabel = "__name__"
+
+// Action is the action a rule performs
+type Action string
+
+// Supported actions
+const (
+	// Replace sets TargetLabel to Replacement, expanded with the regex
+	// capture groups, if Regex matches the joined SourceLabels values
+	Replace Action = "replace"
+
+	// Keep drops metrics whose joined SourceLabels values don't match Regex
+	Keep Action = "keep"
+
+	// Drop drops metrics whose joined SourceLabels values match Regex
+	Drop Action = "drop"
+
+	// LabelMap copies every label whose name matches Regex to a label named
+	// by Replacement, expanded with the regex capture groups
+	LabelMap Action = "labelmap"
+)
+
+// Defaults applied to unset rule fields
+const (
+	DefaultSeparator   = ";"
+	DefaultRegex       = "(.*)"
+	DefaultReplacement = "$1"
+)
+
+// Rule is a single relabeling rule
+type Rule struct {
+	SourceLabels []string `json:"sourceLabels"`
+	Separator    string   `json:"separator"`
+	Regex        string   `json:"regex"`
+	TargetLabel  string   `json:"targetLabel"`
+	Replacement  string   `json:"replacement"`
+	Action       Action   `json:"action"`
+}
+
+// compiledRule is a rule with defaults applied and its regex compiled
+type compiledRule struct {
+	Rule
+	regex *regexp.Regexp
+}
+
+// Relabeler applies a list of rules in order
+type Relabeler struct {
+	rules []compiledRule
+}
+
+// New validates and compiles rules. Regexes are anchored at both ends, as in
+// Prometheus.
+func New(rules []Rule) (*Relabeler, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for i, rule := range rules {
+		c, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		compiled = append(compiled, c)
+	}
+	return &Relabeler{rules: compiled}, nil
+}
+
+// compile applies defaults to a rule and validates it
+func compile(rule Rule) (compiledRule, error) {
+	if rule.Action == "" {
+		rule.Action = Replace
+	}
+	if rule.Separator == "" {
+		rule.Separator = DefaultSeparator
+	}
+	if rule.Regex == "" {
+		rule.Regex = DefaultRegex
+	}
+	if rule.Replacement == "" {
+		rule.Replacement = DefaultReplacement
+	}
+
+	regex, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+	if err != nil {
+		return compiledRule{}, fmt.Errorf("invalid regex %q: %w", rule.Regex, err)
+	}
+
+	switch rule.Action {
+	case Replace:
+		if rule.TargetLabel == "" {
+			return compiledRule{}, fmt.Errorf("replace requires targetLabel")
+		}
+		if len(rule.SourceLabels) == 0 {
+			return compiledRule{}, fmt.Errorf("replace requires sourceLabels")
+		}
+	case Keep, Drop:
+		if len(rule.SourceLabels) == 0 {
+			return compiledRule{}, fmt.Errorf("%s requires sourceLabels", rule.Action)
+		}
+	case LabelMap:
+	default:
+		return compiledRule{}, fmt.Errorf("unknown action %q", rule.Action)
+	}
+
+	return compiledRule{Rule: rule, regex: regex}, nil
+}
+
+// Process applies the rules to labels and returns the resulting labels, or
+// false if the metric should be dropped. labels is not modified.
+func (r *Relabeler) Process(labels map[string]string) (map[string]string, bool) {
+	result := make(map[string]string, len(labels))
+	for k, v := range labels {
+		result[k] = v
+	}
+
+	for _, rule := range r.rules {
+		if !rule.apply(result) {
+			return nil, false
+		}
+	}
+	return result, true
+}
+
+// apply applies a single rule to labels in place, returning false if the
+// metric should be dropped
+func (r *compiledRule) apply(labels map[string]string) bool {
+	switch r.Action {
+	case Keep:
+		return r.regex.MatchString(r.sourceValue(labels))
+
+	case Drop:
+		return !r.regex.MatchString(r.sourceValue(labels))
+
+	case Replace:
+		value := r.sourceValue(labels)
+		match := r.regex.FindStringSubmatchIndex(value)
+		if match == nil {
+			return true
+		}
+		target := string(r.regex.ExpandString(nil, r.Replacement, value, match))
+		if target == "" {
+			delete(labels, r.TargetLabel)
+		} else {
+			labels[r.TargetLabel] = target
+		}
+
+	case LabelMap:
+		// Iterate in a stable order so overlapping mappings are deterministic
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			match := r.regex.FindStringSubmatchIndex(name)
+			if match == nil {
+				continue
+			}
+			target := string(r.regex.ExpandString(nil, r.Replacement, name, match))
+			if target != "" {
+				labels[target] = labels[name]
+			}
+		}
+	}
+
+	return true
+}
+
+// sourceValue joins the values of the rule's source labels
+func (r *compiledRule) sourceValue(labels map[string]string) string {
+	values := make([]string, len(r.SourceLabels))
+	for i, name := range r.SourceLabels {
+		values[i] = labels[name]
+	}
+	return strings.Join(values, r.Separator)
+}
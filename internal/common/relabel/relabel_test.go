@@ -0,0 +1,127 @@
+package relabel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelabel_Replace(t *testing.T) {
+	r, err := New([]Rule{
+		{
+			SourceLabels: []string{"instance"},
+			Regex:        `([^:]+):(\d+)`,
+			TargetLabel:  "host",
+			Replacement:  "$1",
+		},
+		{
+			SourceLabels: []string{"host", "port"},
+			Separator:    "/",
+			Regex:        `(.+)/(.+)`,
+			TargetLabel:  "endpoint",
+			Replacement:  "${1}_${2}",
+		},
+	})
+	require.NoError(t, err)
+
+	labels := map[string]string{"instance": "node-1:9100", "port": "https"}
+	result, keep := r.Process(labels)
+	require.True(t, keep)
+	assert.Equal(t, "node-1", result["host"])
+	assert.Equal(t, "node-1_https", result["endpoint"])
+
+	// The input is left untouched
+	assert.NotContains(t, labels, "host")
+}
+
+func TestRelabel_ReplaceRenamesMetric(t *testing.T) {
+	r, err := New([]Rule{
+		{
+			SourceLabels: []string{MetricNameLabel},
+			Regex:        `node_(.*)_bytes`,
+			TargetLabel:  MetricNameLabel,
+			Replacement:  "host_${1}_bytes",
+		},
+	})
+	require.NoError(t, err)
+
+	result, keep := r.Process(map[string]string{MetricNameLabel: "node_memory_bytes"})
+	require.True(t, keep)
+	assert.Equal(t, "host_memory_bytes", result[MetricNameLabel])
+
+	// No match leaves the name alone
+	result, keep = r.Process(map[string]string{MetricNameLabel: "cpu_seconds"})
+	require.True(t, keep)
+	assert.Equal(t, "cpu_seconds", result[MetricNameLabel])
+}
+
+func TestRelabel_Keep(t *testing.T) {
+	r, err := New([]Rule{
+		{Action: Keep, SourceLabels: []string{"env"}, Regex: "prod|staging"},
+	})
+	require.NoError(t, err)
+
+	_, keep := r.Process(map[string]string{"env": "prod"})
+	assert.True(t, keep)
+
+	_, keep = r.Process(map[string]string{"env": "dev"})
+	assert.False(t, keep)
+
+	// Regexes are anchored
+	_, keep = r.Process(map[string]string{"env": "production"})
+	assert.False(t, keep)
+}
+
+func TestRelabel_Drop(t *testing.T) {
+	r, err := New([]Rule{
+		{Action: Drop, SourceLabels: []string{MetricNameLabel}, Regex: "go_.*"},
+	})
+	require.NoError(t, err)
+
+	_, keep := r.Process(map[string]string{MetricNameLabel: "go_goroutines"})
+	assert.False(t, keep)
+
+	_, keep = r.Process(map[string]string{MetricNameLabel: "http_requests_total"})
+	assert.True(t, keep)
+}
+
+func TestRelabel_LabelMap(t *testing.T) {
+	r, err := New([]Rule{
+		{Action: LabelMap, Regex: "k8s_label_(.+)", Replacement: "$1"},
+	})
+	require.NoError(t, err)
+
+	result, keep := r.Process(map[string]string{
+		"k8s_label_app":  "checkout",
+		"k8s_label_team": "payments",
+		"pod":            "checkout-0",
+	})
+	require.True(t, keep)
+	assert.Equal(t, map[string]string{
+		"k8s_label_app":  "checkout",
+		"k8s_label_team": "payments",
+		"app":            "checkout",
+		"team":           "payments",
+		"pod":            "checkout-0",
+	}, result)
+}
+
+func TestRelabel_InvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"bad regex", Rule{SourceLabels: []string{"a"}, TargetLabel: "b", Regex: "("}},
+		{"replace without target", Rule{SourceLabels: []string{"a"}}},
+		{"keep without source", Rule{Action: Keep}},
+		{"unknown action", Rule{Action: "hashmod", SourceLabels: []string{"a"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New([]Rule{tt.rule})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -15,6 +15,21 @@ var (
 	ErrCircuitOpen = errors.New("circuit breaker is open")
 )
 
+// Rejection is implemented by errors a downstream responded with, as opposed
+// to failures to reach it. When Rejected returns true the downstream is
+// healthy but refused the request for good, e.g. because its data is
+// invalid, so the request counts as a success toward the breaker's state.
+type Rejection interface {
+	Rejected() bool
+}
+
+// isRejection reports whether err is a Rejection that doesn't count against
+// the downstream
+func isRejection(err error) bool {
+	var rejection Rejection
+	return errors.As(err, &rejection) && rejection.Rejected()
+}
+
 // CircuitBreakerState represents the current state of the circuit breaker
 type CircuitBreakerState int
 
@@ -37,6 +52,8 @@ type CircuitBreakerConfig struct {
 	OpenStateSeconds int
 	// HalfOpenRequestThreshold is the number of successful requests needed to close the circuit
 	HalfOpenRequestThreshold int
+	// StateWebhookURL, if set, receives a POST of every state change
+	StateWebhookURL string
 }
 
 // DefaultCircuitBreakerConfig returns a default configuration
@@ -49,9 +66,47 @@ func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
 	}
 }
 
+// Downstreams guarded by a function block's circuit breakers. Each has its
+// own breaker, so an outage of one doesn't stop traffic to the other.
+const (
+	// DownstreamNextFB is the next function block in the chain
+	DownstreamNextFB = "next_fb"
+	// DownstreamDLQ is the dead letter queue
+	DownstreamDLQ = "dlq"
+)
+
+// Circuit breaker metrics, labelled by function block and downstream
+var (
+	cbState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fb_cb_state",
+		Help: "Current state of the circuit breaker (0=closed, 1=open, 2=half-open)",
+	}, []string{"fb_name", "downstream"})
+
+	cbRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_cb_requests_total",
+		Help: "Total number of requests seen by the circuit breaker",
+	}, []string{"fb_name", "downstream"})
+
+	cbFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_cb_failures_total",
+		Help: "Total number of failures seen by the circuit breaker",
+	}, []string{"fb_name", "downstream"})
+
+	cbOpenSecondsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_cb_open_seconds_total",
+		Help: "Total number of seconds the circuit breaker has been open",
+	}, []string{"fb_name", "downstream"})
+
+	cbStateChangesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_cb_state_changes_total",
+		Help: "Total number of circuit breaker state transitions",
+	}, []string{"fb_name", "downstream", "from_state", "to_state"})
+)
+
 // CircuitBreaker implements a circuit breaker pattern
 type CircuitBreaker struct {
 	name                      string
+	downstream                string
 	state                     CircuitBreakerState
 	config                    CircuitBreakerConfig
 	mutex                     sync.RWMutex
@@ -61,6 +116,10 @@ type CircuitBreaker struct {
 	lastStateChangeTime       time.Time
 	halfOpenSuccessfulRequest int
 
+	// Called on state transitions, if set, in order by the notifier goroutine
+	hook          StateChangeHook
+	notifications chan stateNotification
+
 	// Metrics
 	stateGauge        prometheus.Gauge
 	requestsTotal     prometheus.Counter
@@ -69,66 +128,51 @@ type CircuitBreaker struct {
 	stateChangesTotal *prometheus.CounterVec
 }
 
-// NewCircuitBreaker creates a new circuit breaker with the given configuration
-func NewCircuitBreaker(name string, config CircuitBreakerConfig) *CircuitBreaker {
+// NewCircuitBreaker creates a new circuit breaker with the given
+// configuration, guarding the named function block's calls to downstream
+func NewCircuitBreaker(name, downstream string, config CircuitBreakerConfig) *CircuitBreaker {
+	labels := prometheus.Labels{"fb_name": name, "downstream": downstream}
 	cb := &CircuitBreaker{
 		name:                name,
+		downstream:          downstream,
 		state:               StateClosed,
 		config:              config,
 		lastStateChangeTime: time.Now(),
+		notifications:       make(chan stateNotification, stateNotificationQueueSize),
 
 		// Initialize metrics
-		stateGauge: promauto.NewGauge(prometheus.GaugeOpts{
-			Name: "fb_cb_state",
-			Help: "Current state of the circuit breaker (0=closed, 1=open, 2=half-open)",
-			ConstLabels: prometheus.Labels{
-				"fb_name": name,
-			},
-		}),
-		requestsTotal: promauto.NewCounter(prometheus.CounterOpts{
-			Name: "fb_cb_requests_total",
-			Help: "Total number of requests seen by the circuit breaker",
-			ConstLabels: prometheus.Labels{
-				"fb_name": name,
-			},
-		}),
-		failuresTotal: promauto.NewCounter(prometheus.CounterOpts{
-			Name: "fb_cb_failures_total",
-			Help: "Total number of failures seen by the circuit breaker",
-			ConstLabels: prometheus.Labels{
-				"fb_name": name,
-			},
-		}),
-		openStateTotal: promauto.NewCounter(prometheus.CounterOpts{
-			Name: "fb_cb_open_seconds_total",
-			Help: "Total number of seconds the circuit breaker has been open",
-			ConstLabels: prometheus.Labels{
-				"fb_name": name,
-			},
-		}),
-		stateChangesTotal: promauto.NewCounterVec(prometheus.CounterOpts{
-			Name: "fb_cb_state_changes_total",
-			Help: "Total number of circuit breaker state transitions",
-			ConstLabels: prometheus.Labels{
-				"fb_name": name,
-			},
-		}, []string{"from_state", "to_state"}),
+		stateGauge:        cbState.With(labels),
+		requestsTotal:     cbRequestsTotal.With(labels),
+		failuresTotal:     cbFailuresTotal.With(labels),
+		openStateTotal:    cbOpenSecondsTotal.With(labels),
+		stateChangesTotal: cbStateChangesTotal.MustCurryWith(labels),
+	}
+
+	// A breaker replacing one after a config update starts closed
+	cb.stateGauge.Set(float64(StateClosed))
+
+	if config.StateWebhookURL != "" {
+		cb.hook = NewWebhookHook(config.StateWebhookURL)
 	}
 
 	// Start a goroutine to track open state time
 	go cb.trackOpenState()
 
+	// Start a goroutine to deliver state changes to the hook in order
+	go cb.deliverStateChanges()
+
 	return cb
 }
 
-// Execute executes the given function within the circuit breaker
+// Execute executes the given function within the circuit breaker. Errors
+// that are a Rejection don't count as failures of the downstream.
 func (cb *CircuitBreaker) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
 	if !cb.allowRequest() {
 		return ErrCircuitOpen
 	}
 
 	err := fn(ctx)
-	cb.recordResult(err == nil)
+	cb.recordResult(err == nil || isRejection(err))
 	return err
 }
 
@@ -188,8 +232,7 @@ func (cb *CircuitBreaker) recordResult(success bool) {
 
 	// Check error threshold for closed circuit
 	if cb.state == StateClosed && cb.requestCount >= cb.config.MinimumRequestCount {
-		errorRate := float64(cb.failureCount) / float64(cb.requestCount) * 100.0
-		if errorRate >= float64(cb.config.ErrorThresholdPercentage) {
+		if cb.errorRate() >= float64(cb.config.ErrorThresholdPercentage) {
 			cb.transitionState(StateOpen)
 			return
 		}
@@ -201,6 +244,14 @@ func (cb *CircuitBreaker) recordResult(success bool) {
 	}
 }
 
+// SetStateChangeHook sets a hook called on every state transition, e.g. to
+// emit a Kubernetes event, replacing the webhook of the configuration if any
+func (cb *CircuitBreaker) SetStateChangeHook(hook StateChangeHook) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.hook = hook
+}
+
 // transitionState changes the state of the circuit breaker
 func (cb *CircuitBreaker) transitionState(newState CircuitBreakerState) {
 	oldState := cb.state
@@ -212,6 +263,18 @@ func (cb *CircuitBreaker) transitionState(newState CircuitBreakerState) {
 		stateToString(newState),
 	).Inc()
 
+	// Notify without holding up the request that caused the transition
+	if cb.hook != nil {
+		cb.notify(cb.hook, StateChange{
+			FBName:     cb.name,
+			Downstream: cb.downstream,
+			From:       oldState,
+			To:         newState,
+			ErrorRate:  cb.errorRate(),
+			Time:       cb.lastStateChangeTime,
+		})
+	}
+
 	if newState == StateClosed {
 		cb.resetCounts()
 	} else if newState == StateHalfOpen {
@@ -219,6 +282,14 @@ func (cb *CircuitBreaker) transitionState(newState CircuitBreakerState) {
 	}
 }
 
+// errorRate returns the percentage of failed requests counted so far
+func (cb *CircuitBreaker) errorRate() float64 {
+	if cb.requestCount == 0 {
+		return 0
+	}
+	return float64(cb.failureCount) / float64(cb.requestCount) * 100.0
+}
+
 // resetCounts resets the success and failure counts
 func (cb *CircuitBreaker) resetCounts() {
 	cb.successCount = 0
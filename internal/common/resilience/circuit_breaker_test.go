@@ -0,0 +1,96 @@
+package resilience
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextChange waits for the hook to receive a state change
+func nextChange(t *testing.T, changes <-chan StateChange) StateChange {
+	t.Helper()
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(time.Second):
+		t.Fatal("state change hook not called")
+		return StateChange{}
+	}
+}
+
+func TestCircuitBreaker_StateChangeHook(t *testing.T) {
+	cb := NewCircuitBreaker("fb-test", DownstreamNextFB, CircuitBreakerConfig{
+		ErrorThresholdPercentage: 50,
+		MinimumRequestCount:      4,
+		OpenStateSeconds:         0,
+		HalfOpenRequestThreshold: 1,
+	})
+	changes := make(chan StateChange, 3)
+	cb.SetStateChangeHook(func(change StateChange) { changes <- change })
+
+	fail := func(ctx context.Context) error { return errors.New("unavailable") }
+	succeed := func(ctx context.Context) error { return nil }
+
+	// 3 failures out of 4 requests trip the breaker
+	require.NoError(t, cb.Execute(context.Background(), succeed))
+	for i := 0; i < 3; i++ {
+		require.Error(t, cb.Execute(context.Background(), fail))
+	}
+	change := nextChange(t, changes)
+	assert.Equal(t, "fb-test", change.FBName)
+	assert.Equal(t, DownstreamNextFB, change.Downstream)
+	assert.Equal(t, StateClosed, change.From)
+	assert.Equal(t, StateOpen, change.To)
+	assert.Equal(t, 75.0, change.ErrorRate)
+	assert.False(t, change.Time.IsZero())
+
+	// With no open time, the next request probes the downstream and closes
+	// the breaker again
+	time.Sleep(time.Millisecond)
+	require.NoError(t, cb.Execute(context.Background(), succeed))
+	change = nextChange(t, changes)
+	assert.Equal(t, StateOpen, change.From)
+	assert.Equal(t, StateHalfOpen, change.To)
+	change = nextChange(t, changes)
+	assert.Equal(t, StateHalfOpen, change.From)
+	assert.Equal(t, StateClosed, change.To)
+}
+
+func TestCircuitBreaker_StateWebhook(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		received <- body
+	}))
+	defer server.Close()
+
+	cb := NewCircuitBreaker("fb-test", DownstreamDLQ, CircuitBreakerConfig{
+		ErrorThresholdPercentage: 50,
+		MinimumRequestCount:      1,
+		OpenStateSeconds:         30,
+		HalfOpenRequestThreshold: 1,
+		StateWebhookURL:          server.URL,
+	})
+	require.Error(t, cb.Execute(context.Background(), func(ctx context.Context) error {
+		return errors.New("unavailable")
+	}))
+
+	select {
+	case body := <-received:
+		assert.Equal(t, "fb-test", body["fb_name"])
+		assert.Equal(t, DownstreamDLQ, body["downstream"])
+		assert.Equal(t, "closed", body["from"])
+		assert.Equal(t, "open", body["to"])
+		assert.Equal(t, 100.0, body["error_rate"])
+	case <-time.After(time.Second):
+		t.Fatal("webhook not called")
+	}
+}
@@ -0,0 +1,114 @@
+package resilience
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// webhookTimeout bounds each state change notification sent to a webhook
+	webhookTimeout = 5 * time.Second
+
+	// stateNotificationQueueSize is the number of state changes a breaker
+	// queues for its hook before dropping new ones
+	stateNotificationQueueSize = 64
+)
+
+var (
+	cbWebhookFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_cb_webhook_failures_total",
+		Help: "Total number of circuit breaker state changes that could not be sent to the webhook",
+	}, []string{"fb_name", "downstream"})
+
+	cbNotificationsDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_cb_notifications_dropped_total",
+		Help: "Total number of circuit breaker state changes dropped because the hook fell behind",
+	}, []string{"fb_name", "downstream"})
+)
+
+// StateChange describes a transition of a circuit breaker
+type StateChange struct {
+	FBName     string              `json:"fb_name"`
+	Downstream string              `json:"downstream"`
+	From       CircuitBreakerState `json:"from"`
+	To         CircuitBreakerState `json:"to"`
+
+	// ErrorRate is the percentage of failed requests the breaker counted
+	// before the transition
+	ErrorRate float64   `json:"error_rate"`
+	Time      time.Time `json:"time"`
+}
+
+// StateChangeHook is called on every state transition of a circuit breaker.
+// Each breaker calls it from a single goroutine of its own, so it may block
+// without delaying requests and sees transitions in the order they happened.
+// Transitions are dropped while too many are waiting for it.
+type StateChangeHook func(change StateChange)
+
+// stateNotification is a state change waiting to be delivered to a hook
+type stateNotification struct {
+	hook   StateChangeHook
+	change StateChange
+}
+
+// notify queues a state change for the hook, dropping it if the queue is full
+func (cb *CircuitBreaker) notify(hook StateChangeHook, change StateChange) {
+	select {
+	case cb.notifications <- stateNotification{hook: hook, change: change}:
+	default:
+		cbNotificationsDroppedTotal.WithLabelValues(cb.name, cb.downstream).Inc()
+	}
+}
+
+// deliverStateChanges calls the hook with each queued state change in turn
+func (cb *CircuitBreaker) deliverStateChanges() {
+	for notification := range cb.notifications {
+		notification.hook(notification.change)
+	}
+}
+
+// String returns the name of the state, as used in metric labels
+func (s CircuitBreakerState) String() string {
+	return stateToString(s)
+}
+
+// MarshalJSON encodes the state as its name
+func (s CircuitBreakerState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// NewWebhookHook returns a hook that POSTs each state change as JSON to url.
+// Notifications that fail or time out are counted and dropped.
+func NewWebhookHook(url string) StateChangeHook {
+	client := &http.Client{Timeout: webhookTimeout}
+	return func(change StateChange) {
+		if err := postStateChange(client, url, change); err != nil {
+			cbWebhookFailuresTotal.WithLabelValues(change.FBName, change.Downstream).Inc()
+		}
+	}
+}
+
+// postStateChange sends a state change to a webhook
+func postStateChange(client *http.Client, url string, change StateChange) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
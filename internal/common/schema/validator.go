@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"regexp"
 	"strings"
@@ -120,13 +121,18 @@ func (v *JSONSchemaValidator) validateObject(data interface{}, schema map[string
 			fieldPath += fieldName
 
 			// Validate field type
-			if err := v.validateType(fieldValue, fieldSchemaMap, fieldPath); err != nil {
-				return err
+			if result := v.validateType(fieldValue, fieldSchemaMap, fieldPath); !result.Valid {
+				return result
 			}
 
 			// Validate field format
-			if err := v.validateFormat(fieldValue, fieldSchemaMap, fieldPath); err != nil {
-				return err
+			if result := v.validateFormat(fieldValue, fieldSchemaMap, fieldPath); !result.Valid {
+				return result
+			}
+
+			// Validate allowed values
+			if result := v.validateEnum(fieldValue, fieldSchemaMap, fieldPath); !result.Valid {
+				return result
 			}
 
 			// Recursive validation for objects
@@ -192,15 +198,27 @@ func (v *JSONSchemaValidator) validateType(value interface{}, schema map[string]
 		}
 	case "number":
 		switch value.(type) {
-		case float64, float32, int, int64, int32:
+		case float64, float32, int, int64, int32, json.Number:
 			// Valid numeric types
 		default:
 			return NewValidationError(fmt.Errorf("%w: expected number", ErrInvalidFieldType), path)
 		}
 	case "integer":
-		switch value.(type) {
+		switch n := value.(type) {
 		case int, int64, int32:
 			// Valid integer types
+		case float64:
+			// Decoded JSON numbers are float64
+			if n != math.Trunc(n) {
+				return NewValidationError(fmt.Errorf("%w: expected integer", ErrInvalidFieldType), path)
+			}
+		case json.Number:
+			// Numbers decoded with codec.UnmarshalJSON
+			if _, err := n.Int64(); err != nil {
+				if f, err := n.Float64(); err != nil || f != math.Trunc(f) {
+					return NewValidationError(fmt.Errorf("%w: expected integer", ErrInvalidFieldType), path)
+				}
+			}
 		default:
 			return NewValidationError(fmt.Errorf("%w: expected integer", ErrInvalidFieldType), path)
 		}
@@ -221,6 +239,22 @@ func (v *JSONSchemaValidator) validateType(value interface{}, schema map[string]
 	return &ValidationResult{Valid: true}
 }
 
+// validateEnum validates that the field value is one of the allowed values
+func (v *JSONSchemaValidator) validateEnum(value interface{}, schema map[string]interface{}, path string) *ValidationResult {
+	allowed, ok := schema["enum"].([]interface{})
+	if !ok {
+		return &ValidationResult{Valid: true}
+	}
+
+	for _, allowedValue := range allowed {
+		if reflect.DeepEqual(value, allowedValue) {
+			return &ValidationResult{Valid: true}
+		}
+	}
+
+	return NewValidationError(fmt.Errorf("%w: %v is not one of %v", ErrInvalidFieldValue, value, allowed), path)
+}
+
 // validateFormat validates that the field value matches the expected format
 func (v *JSONSchemaValidator) validateFormat(value interface{}, schema map[string]interface{}, path string) *ValidationResult {
 	format, ok := schema["format"].(string)
@@ -265,13 +299,18 @@ func (v *JSONSchemaValidator) validateArray(value interface{}, itemSchema map[st
 		itemPath := fmt.Sprintf("%s[%d]", path, i)
 
 		// Validate item type
-		if err := v.validateType(item, itemSchema, itemPath); err != nil {
-			return err
+		if result := v.validateType(item, itemSchema, itemPath); !result.Valid {
+			return result
 		}
 
 		// Validate item format
-		if err := v.validateFormat(item, itemSchema, itemPath); err != nil {
-			return err
+		if result := v.validateFormat(item, itemSchema, itemPath); !result.Valid {
+			return result
+		}
+
+		// Validate allowed values
+		if result := v.validateEnum(item, itemSchema, itemPath); !result.Valid {
+			return result
 		}
 
 		// Recursive validation for objects
@@ -292,27 +331,27 @@ func (v *JSONSchemaValidator) validateArray(value interface{}, itemSchema map[st
 
 // isHashedOrEncoded checks if a value appears to be hashed or encoded
 func (v *JSONSchemaValidator) isHashedOrEncoded(value interface{}) bool {
-	// Check if it's a string first
+	return IsHashedOrEncoded(value)
+}
+
+// Patterns of values that are hashed or encoded rather than in the clear
+var (
+	sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	base64Pattern = regexp.MustCompile(`^[A-Za-z0-9+/]+={0,2}$`)
+)
+
+// IsHashedOrEncoded checks if a value appears to be hashed or encoded: a
+// SHA-256 hex digest or base64. Values that aren't strings never are.
+func IsHashedOrEncoded(value interface{}) bool {
 	strValue, ok := value.(string)
 	if !ok {
 		return false
 	}
 
-	// Check if it's a SHA-256 hash (64 hex characters)
-	if regexp.MustCompile(`^[0-9a-f]{64}$`).MatchString(strValue) {
-		return true
-	}
-
-	// Check if it's base64 encoded
-	if regexp.MustCompile(`^[A-Za-z0-9+/]+={0,2}$`).MatchString(strValue) && len(strValue) % 4 == 0 {
+	if sha256Pattern.MatchString(strValue) {
 		return true
 	}
-
-	// Check if the field name indicates it's a hash
-	// This will need reflection to check the actual field name, which is not 
-	// available in this context, but would be implemented in a real validator
-
-	return false
+	return base64Pattern.MatchString(strValue) && len(strValue)%4 == 0
 }
 
 // SimpleValidator implements a simple schema validator
@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DynamicIDGenerator generates trace and span IDs, which also drive ratio
+// sampling decisions. IDs are random unless a seed is set, in which case the
+// same sequence of spans gets the same IDs on every run.
+type DynamicIDGenerator struct {
+	mu     sync.Mutex
+	seed   *int64
+	random *rand.Rand
+}
+
+// defaultIDGenerator is shared by the tracer provider and all Tracers in the process
+var defaultIDGenerator = NewDynamicIDGenerator()
+
+// NewDynamicIDGenerator creates an ID generator producing random IDs
+func NewDynamicIDGenerator() *DynamicIDGenerator {
+	g := &DynamicIDGenerator{}
+	g.random = rand.New(rand.NewSource(randomSeed()))
+	return g
+}
+
+// randomSeed returns a seed for unseeded generators
+func randomSeed() int64 {
+	var b [8]byte
+	crand.Read(b[:])
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+// SetSeed makes IDs derive from seed, or random again when seed is nil.
+// Setting the seed already in use keeps the current sequence going.
+func (g *DynamicIDGenerator) SetSeed(seed *int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if seed == nil {
+		if g.seed != nil {
+			g.seed = nil
+			g.random = rand.New(rand.NewSource(randomSeed()))
+		}
+		return
+	}
+	if g.seed != nil && *g.seed == *seed {
+		return
+	}
+
+	value := *seed
+	g.seed = &value
+	g.random = rand.New(rand.NewSource(value))
+}
+
+// NewIDs implements sdktrace.IDGenerator
+func (g *DynamicIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	traceID := trace.TraceID{}
+	for !traceID.IsValid() {
+		g.random.Read(traceID[:])
+	}
+	return traceID, g.newSpanID()
+}
+
+// NewSpanID implements sdktrace.IDGenerator
+func (g *DynamicIDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.newSpanID()
+}
+
+// newSpanID generates a valid span ID; the caller holds g.mu
+func (g *DynamicIDGenerator) newSpanID() trace.SpanID {
+	spanID := trace.SpanID{}
+	for !spanID.IsValid() {
+		g.random.Read(spanID[:])
+	}
+	return spanID
+}
+
+// SetSeed sets the seed of the process-wide ID generator, or makes IDs
+// random again when seed is nil
+func SetSeed(seed *int64) {
+	defaultIDGenerator.SetSeed(seed)
+}
@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// traceRun starts a span per name and returns each span's trace ID and
+// sampling decision
+func traceRun(generator *DynamicIDGenerator, names []string) []byte {
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(0.5)),
+		sdktrace.WithIDGenerator(generator),
+	)
+	defer tp.Shutdown(context.Background())
+	tracer := tp.Tracer("fb-test")
+
+	var out strings.Builder
+	for _, name := range names {
+		_, span := tracer.Start(context.Background(), name)
+		fmt.Fprintf(&out, "%s %s %t\n", name, span.SpanContext().TraceID(), span.SpanContext().IsSampled())
+		span.End()
+	}
+	return []byte(out.String())
+}
+
+func TestDynamicIDGenerator_SeededRunsAreReproducible(t *testing.T) {
+	names := make([]string, 32)
+	for i := range names {
+		names[i] = fmt.Sprintf("process-batch-%d", i)
+	}
+
+	seed := int64(42)
+	generator := NewDynamicIDGenerator()
+	generator.SetSeed(&seed)
+	first := traceRun(generator, names)
+
+	// Seeding a fresh generator reproduces the same IDs and decisions
+	generator = NewDynamicIDGenerator()
+	generator.SetSeed(&seed)
+	second := traceRun(generator, names)
+	assert.Equal(t, first, second)
+
+	// Both sampling outcomes occur, so the decisions are actually compared
+	assert.Contains(t, string(first), "true")
+	assert.Contains(t, string(first), "false")
+
+	// Reapplying the seed in use keeps the sequence going
+	generator.SetSeed(&seed)
+	assert.NotEqual(t, first, traceRun(generator, names))
+
+	// A different seed gives a different run
+	otherSeed := int64(7)
+	generator = NewDynamicIDGenerator()
+	generator.SetSeed(&otherSeed)
+	assert.NotEqual(t, first, traceRun(generator, names))
+}
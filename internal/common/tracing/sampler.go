@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"fmt"
+	"sync"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// DynamicSampler is a sampler whose ratio can be changed at runtime without
+// recreating the tracer provider
+type DynamicSampler struct {
+	mu      sync.RWMutex
+	ratio   float64
+	sampler sdktrace.Sampler
+}
+
+// defaultSampler is shared by the tracer provider and all Tracers in the process
+var defaultSampler = NewDynamicSampler(1.0)
+
+// NewDynamicSampler creates a new parent-based ratio sampler
+func NewDynamicSampler(ratio float64) *DynamicSampler {
+	s := &DynamicSampler{}
+	if err := s.SetRatio(ratio); err != nil {
+		s.SetRatio(1.0)
+	}
+	return s
+}
+
+// ShouldSample implements sdktrace.Sampler
+func (s *DynamicSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	s.mu.RLock()
+	sampler := s.sampler
+	s.mu.RUnlock()
+	return sampler.ShouldSample(p)
+}
+
+// Description implements sdktrace.Sampler
+func (s *DynamicSampler) Description() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fmt.Sprintf("DynamicSampler{%s}", s.sampler.Description())
+}
+
+// SetRatio swaps the underlying sampler for one with the given ratio
+func (s *DynamicSampler) SetRatio(ratio float64) error {
+	if err := ValidateSamplingRatio(ratio); err != nil {
+		return err
+	}
+
+	sampler := sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))
+
+	s.mu.Lock()
+	s.ratio = ratio
+	s.sampler = sampler
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Ratio returns the current sampling ratio
+func (s *DynamicSampler) Ratio() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ratio
+}
+
+// ValidateSamplingRatio checks that a sampling ratio is within 0.0-1.0
+func ValidateSamplingRatio(ratio float64) error {
+	if ratio < 0 || ratio > 1 {
+		return fmt.Errorf("invalid trace sampling ratio: %v, must be between 0.0 and 1.0", ratio)
+	}
+	return nil
+}
+
+// SetSamplingRatio updates the sampling ratio of the process-wide sampler
+func SetSamplingRatio(ratio float64) error {
+	return defaultSampler.SetRatio(ratio)
+}
+
+// SamplingRatio returns the sampling ratio of the process-wide sampler
+func SamplingRatio() float64 {
+	return defaultSampler.Ratio()
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"time"
 
+	"eidc-tfk8s/internal/common/instance"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -47,20 +48,36 @@ func (t *Tracer) InitTracer(ctx context.Context, endpoint string, samplingRatio
 		hostname = "unknown"
 	}
 
-	// Create resource
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceNameKey.String(t.serviceName),
-			attribute.String("host.name", hostname),
-		),
-	)
+	// Create resource, telling replicas apart by their pod
+	attrs := []attribute.KeyValue{
+		semconv.ServiceNameKey.String(t.serviceName),
+		attribute.String("host.name", hostname),
+	}
+	info := instance.FromEnv()
+	attrs = append(attrs, attribute.String("service.instance.id", info.ID()))
+	if info.PodName != "" {
+		attrs = append(attrs, attribute.String("k8s.pod.name", info.PodName))
+	}
+	if info.Namespace != "" {
+		attrs = append(attrs, attribute.String("k8s.namespace.name", info.Namespace))
+	}
+	if info.NodeName != "" {
+		attrs = append(attrs, attribute.String("k8s.node.name", info.NodeName))
+	}
+	res, err := resource.New(ctx, resource.WithAttributes(attrs...))
 	if err != nil {
 		return fmt.Errorf("failed to create resource: %w", err)
 	}
 
+	// Set initial sampling ratio; it can be changed later via SetSamplingRatio
+	if err := defaultSampler.SetRatio(samplingRatio); err != nil {
+		return err
+	}
+
 	// Create tracer provider
 	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(samplingRatio)),
+		sdktrace.WithSampler(defaultSampler),
+		sdktrace.WithIDGenerator(defaultIDGenerator),
 		sdktrace.WithBatcher(exporter),
 		sdktrace.WithResource(res),
 	)
@@ -83,6 +100,22 @@ func (t *Tracer) StartSpan(ctx context.Context, name string) (context.Context, t
 	return tracer.Start(ctx, name)
 }
 
+// SetSamplingRatio changes the trace sampling ratio at runtime
+func (t *Tracer) SetSamplingRatio(ratio float64) error {
+	return SetSamplingRatio(ratio)
+}
+
+// SamplingRatio returns the current trace sampling ratio
+func (t *Tracer) SamplingRatio() float64 {
+	return SamplingRatio()
+}
+
+// SetSeed makes trace IDs, and so sampling decisions, derive from seed, or
+// random again when seed is nil
+func (t *Tracer) SetSeed(seed *int64) {
+	SetSeed(seed)
+}
+
 // AddEvent adds an event to the current span
 func (t *Tracer) AddEvent(ctx context.Context, name string, attrs map[string]string) {
 	span := trace.SpanFromContext(ctx)
@@ -2,14 +2,22 @@ package config
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/common/resilience"
+	"eidc-tfk8s/internal/common/tracing"
+
+	jsonpatch "github.com/evanphx/json-patch"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/status"
 )
 
@@ -23,9 +31,31 @@ type ConfigClient struct {
 	configGeneration int64
 	configMu        sync.RWMutex
 	callbacks       []func([]byte, int64) error
+	restartCallbacks []func([]byte, int64) error
 	logger          Logger
+
+	// ackJitter is the upper bound of the random delay, of at least half
+	// of it, before a config update is acked. Updates arriving within the
+	// delay are acked once, for the latest generation.
+	ackJitter time.Duration
+
+	// pendingAck is the generation awaiting an ack and ackTimer the timer
+	// that sends it, guarded by ackMu. ackTimer is nil when no ack is
+	// pending.
+	ackMu      sync.Mutex
+	pendingAck int64
+	ackTimer   *time.Timer
 }
 
+// defaultAckJitter bounds the delay before acking a config update, spreading
+// the acks of a mass rollout over time so they don't all hit the controller
+// at once
+const defaultAckJitter = time.Second
+
+// ackRetryDelay is how long a failed ack waits, on top of the jitter, before
+// it is retried
+const ackRetryDelay = 5 * time.Second
+
 // Logger interface for logging
 type Logger interface {
 	Info(msg string, keyValues map[string]interface{})
@@ -52,9 +82,20 @@ func NewConfigClient(fbName, instanceID, configServiceAddr string, logger Logger
 		instanceID: instanceID,
 		logger:     logger,
 		callbacks:  make([]func([]byte, int64) error, 0),
+		ackJitter:  defaultAckJitter,
 	}, nil
 }
 
+// SetAckJitter sets the upper bound of the random delay before a config
+// update is acked. 0 acks each update as soon as it is applied, though
+// updates applied before the ack is sent still share it.
+func (c *ConfigClient) SetAckJitter(jitter time.Duration) {
+	c.ackMu.Lock()
+	defer c.ackMu.Unlock()
+
+	c.ackJitter = jitter
+}
+
 // Start starts the config client
 func (c *ConfigClient) Start(ctx context.Context) error {
 	// Get initial config
@@ -64,7 +105,7 @@ func (c *ConfigClient) Start(ctx context.Context) error {
 	}
 
 	// Update local config
-	c.updateConfig(res.Config, res.Generation)
+	c.updateConfig(res.Config, res.Generation, res.RequiresRestart, res.MergePatch)
 
 	// Start watching for config updates
 	go c.watchConfig(ctx)
@@ -80,6 +121,17 @@ func (c *ConfigClient) RegisterCallback(callback func([]byte, int64) error) {
 	c.callbacks = append(c.callbacks, callback)
 }
 
+// RegisterRestartCallback registers a callback to be called instead of the
+// regular callbacks when the config service flags an update as requiring a
+// restart. It should fully reinitialize the affected subsystems rather than
+// hot-swap them. Without restart callbacks, the regular callbacks are used.
+func (c *ConfigClient) RegisterRestartCallback(callback func([]byte, int64) error) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	c.restartCallbacks = append(c.restartCallbacks, callback)
+}
+
 // GetConfig returns the current config
 func (c *ConfigClient) GetConfig() []byte {
 	c.configMu.RLock()
@@ -123,14 +175,17 @@ func (c *ConfigClient) watchConfig(ctx context.Context) {
 				"config_generation": c.GetCurrentGeneration(),
 			})
 
-			// Create stream
+			// Create stream, asking for updates as deltas from the
+			// generation we have
 			req := &ConfigRequest{
 				FbName:             c.fbName,
 				InstanceId:         c.instanceID,
 				LastKnownGeneration: c.GetCurrentGeneration(),
+				SupportsDelta:       true,
+				SupportsMergePatch:  true,
 			}
 
-			stream, err := c.client.StreamConfig(ctx, req)
+			stream, err := c.client.StreamConfig(ctx, req, grpc.UseCompressor(gzip.Name))
 			if err != nil {
 				c.logger.Error("Failed to create config stream", err, map[string]interface{}{})
 				time.Sleep(5 * time.Second)
@@ -149,25 +204,38 @@ func (c *ConfigClient) watchConfig(ctx context.Context) {
 					"old_generation": c.GetCurrentGeneration(),
 					"new_generation": res.Generation,
 					"requires_restart": res.RequiresRestart,
+					"delta":            len(res.ConfigPatch) > 0,
+					"merge_patch":      res.MergePatch,
 				})
 
-				// Update local config
-				c.updateConfig(res.Config, res.Generation)
-
-				// Send acknowledgement
-				ackReq := &ConfigAckRequest{
-					FbName:     c.fbName,
-					InstanceId: c.instanceID,
-					Generation: res.Generation,
-					Success:    true,
+				// Rebuild the config from a delta, fetching the full config
+				// if the delta doesn't apply to our generation
+				configBytes := res.Config
+				if len(res.ConfigPatch) > 0 {
+					configBytes, err = c.applyDelta(res)
+					if err != nil {
+						c.logger.Warn("Failed to apply config delta, fetching full config", map[string]interface{}{
+							"base_generation": res.BaseGeneration,
+							"generation":      res.Generation,
+							"error":           err.Error(),
+						})
+
+						full, getErr := c.getConfig(ctx)
+						if getErr != nil {
+							c.logger.Error("Failed to fetch full config", getErr, map[string]interface{}{})
+							break
+						}
+						res = full
+						configBytes = full.Config
+					}
 				}
 
-				_, ackErr := c.client.AckConfig(ctx, ackReq)
-				if ackErr != nil {
-					c.logger.Error("Failed to acknowledge config update", ackErr, map[string]interface{}{
-						"generation": res.Generation,
-					})
-				}
+				// Update local config
+				c.updateConfig(configBytes, res.Generation, res.RequiresRestart, res.MergePatch)
+
+				// Acknowledge after a jittered delay, along with any
+				// updates that follow within it
+				c.scheduleAck(ctx, res.Generation)
 			}
 
 			// If stream ends, wait and retry
@@ -176,8 +244,70 @@ func (c *ConfigClient) watchConfig(ctx context.Context) {
 	}
 }
 
-// updateConfig updates the local configuration and calls registered callbacks
-func (c *ConfigClient) updateConfig(configBytes []byte, generation int64) {
+// scheduleAck acks generation after a random delay of ackJitter/2 up to
+// ackJitter. An ack already pending is sent for generation instead, so that
+// rapid updates result in one ack for the latest.
+func (c *ConfigClient) scheduleAck(ctx context.Context, generation int64) {
+	c.scheduleAckAfter(ctx, generation, 0)
+}
+
+// scheduleAckAfter acks generation after minDelay plus the jittered delay
+func (c *ConfigClient) scheduleAckAfter(ctx context.Context, generation int64, minDelay time.Duration) {
+	c.ackMu.Lock()
+	defer c.ackMu.Unlock()
+
+	if generation > c.pendingAck {
+		c.pendingAck = generation
+	}
+	if c.ackTimer != nil {
+		return
+	}
+
+	// Wait at least half the jitter, so that updates in quick succession
+	// share an ack
+	delay := minDelay
+	if half := c.ackJitter / 2; half > 0 {
+		delay += half + time.Duration(rand.Int63n(int64(half)))
+	}
+	c.ackTimer = time.AfterFunc(delay, func() {
+		c.sendAck(ctx)
+	})
+}
+
+// sendAck acks the pending generation. Failed acks are rescheduled, so the
+// controller eventually sees the generation unless a newer one supersedes
+// it or the client stops.
+func (c *ConfigClient) sendAck(ctx context.Context) {
+	c.ackMu.Lock()
+	generation := c.pendingAck
+	c.pendingAck = 0
+	c.ackTimer = nil
+	c.ackMu.Unlock()
+
+	if generation == 0 || ctx.Err() != nil {
+		return
+	}
+
+	ackReq := &ConfigAckRequest{
+		FbName:     c.fbName,
+		InstanceId: c.instanceID,
+		Generation: generation,
+		Success:    true,
+	}
+
+	_, ackErr := c.client.AckConfig(ctx, ackReq)
+	if ackErr != nil {
+		c.logger.Error("Failed to acknowledge config update", ackErr, map[string]interface{}{
+			"generation": generation,
+		})
+		c.scheduleAckAfter(ctx, generation, ackRetryDelay)
+	}
+}
+
+// updateConfig updates the local configuration and calls registered
+// callbacks. A merge patch is passed to the callbacks wrapped by
+// WrapMergePatch, for the FB to merge onto the config it applied.
+func (c *ConfigClient) updateConfig(configBytes []byte, generation int64, requiresRestart, mergePatch bool) {
 	c.configMu.Lock()
 	defer c.configMu.Unlock()
 
@@ -186,37 +316,96 @@ func (c *ConfigClient) updateConfig(configBytes []byte, generation int64) {
 		return
 	}
 
-	// Update local config
-	c.config = configBytes
+	// Update local config. Deltas are based on the full config, so a merge
+	// patch is merged onto it too; if that fails the local config is
+	// dropped, and the next delta falls back to fetching the full config.
+	local := configBytes
+	if mergePatch {
+		wrapped, err := WrapMergePatch(configBytes)
+		if err != nil {
+			c.logger.Error("Invalid config merge patch", err, map[string]interface{}{
+				"generation": generation,
+			})
+			return
+		}
+		if local, err = ApplyConfigDelta(c.config, configBytes); err != nil {
+			local = nil
+		}
+		configBytes = wrapped
+	}
+	c.config = local
 	c.configGeneration = generation
 
-	// Call registered callbacks
-	for _, callback := range c.callbacks {
+	// Call registered callbacks, reinitializing rather than hot-swapping
+	// when the update requires a restart
+	callbacks := c.callbacks
+	if requiresRestart && len(c.restartCallbacks) > 0 {
+		callbacks = c.restartCallbacks
+	}
+	for _, callback := range callbacks {
 		if err := callback(configBytes, generation); err != nil {
 			c.logger.Error("Config update callback failed", err, map[string]interface{}{
-				"generation": generation,
+				"generation":       generation,
+				"requires_restart": requiresRestart,
 			})
 		}
 	}
 }
 
-// Close closes the connection to the config service
+// applyDelta rebuilds the config of a streamed update from its delta, which
+// must be based on the local generation
+func (c *ConfigClient) applyDelta(res *ConfigResponse) ([]byte, error) {
+	c.configMu.RLock()
+	base, generation := c.config, c.configGeneration
+	c.configMu.RUnlock()
+
+	if res.BaseGeneration != generation {
+		return nil, fmt.Errorf("delta is based on generation %d, local config is at generation %d", res.BaseGeneration, generation)
+	}
+
+	return ApplyConfigDelta(base, res.ConfigPatch)
+}
+
+// ApplyConfigDelta applies a config delta, a JSON merge patch (RFC 7386), to
+// the config it was computed from
+func ApplyConfigDelta(base, patch []byte) ([]byte, error) {
+	config, err := jsonpatch.MergePatch(base, patch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply config delta: %w", err)
+	}
+	return config, nil
+}
+
+// Close closes the connection to the config service, dropping any pending
+// ack
 func (c *ConfigClient) Close() error {
+	c.ackMu.Lock()
+	if c.ackTimer != nil {
+		c.ackTimer.Stop()
+		c.ackTimer = nil
+	}
+	c.ackMu.Unlock()
+
 	if c.conn != nil {
 		return c.conn.Close()
 	}
 	return nil
 }
 
-// LoadConfigFromBytes loads a configuration from a byte slice
+// LoadConfigFromBytes loads a configuration from a byte slice, in JSON or
+// YAML as detected by DetectFormat
 func LoadConfigFromBytes(configBytes []byte, config interface{}) error {
-	return json.Unmarshal(configBytes, config)
+	return LoadConfigFromBytesAs(configBytes, "", config)
 }
 
 // Common configuration types
 
 // FBConfig represents common configuration for all function blocks
 type FBConfig struct {
+	// Whether the FB processes batches. When false the FB forwards batches
+	// unchanged to the next FB. Defaults to true when omitted.
+	Enabled *bool `json:"enabled,omitempty"`
+
 	// Common configuration fields
 	LogLevel           string `json:"log_level"`
 	MetricsEnabled     bool   `json:"metrics_enabled"`
@@ -226,11 +415,242 @@ type FBConfig struct {
 	// Next FB in the chain
 	NextFB string `json:"next_fb"`
 
-	// DLQ endpoint
+	// Next FBs batches fan out to instead of NextFB, e.g. one for storage
+	// and one for alerting. NextFBMode is "all", the default, which copies
+	// each batch to every target, or "weighted", which forwards each batch
+	// to one target picked by weight.
+	NextFBs    []NextFBTarget `json:"next_fbs,omitempty"`
+	NextFBMode string         `json:"next_fb_mode,omitempty"`
+
+	// DLQ endpoint. Empty means the FB has no DLQ, e.g. a terminal GW that
+	// exports directly: batches that fail are returned to the caller with
+	// an error instead, so that backpressure propagates upstream.
 	DLQ string `json:"dlq"`
 
-	// Circuit breaker configuration
+	// Quarantine endpoint, receiving batches that fail with a non-retriable
+	// error code, such as ERR_INVALID_INPUT or ERR_PII_LEAK, which replaying
+	// can't fix. Empty sends them to the DLQ with the retriable failures.
+	Quarantine string `json:"quarantine,omitempty"`
+
+	// Circuit breaker configuration, shared by the breakers guarding the next
+	// FB and the DLQ
 	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker"`
+
+	// Overrides of CircuitBreaker for the breaker guarding the next FB and
+	// the one guarding the DLQ. Unset fields take the shared value.
+	NextFBCircuitBreaker *CircuitBreakerConfig `json:"next_fb_circuit_breaker,omitempty"`
+	DLQCircuitBreaker    *CircuitBreakerConfig `json:"dlq_circuit_breaker,omitempty"`
+
+	// Maximum number of batches the FB processes at once. Batches over the
+	// limit are rejected with a retryable ResourceExhausted. 0 means no limit.
+	MaxConcurrentBatches int `json:"max_concurrent_batches,omitempty"`
+
+	// Maximum number of hops through the DLQ a batch may make, counting each
+	// send to the DLQ and each replay. Batches over the limit are dropped
+	// instead of sent to the DLQ again. 0 means no limit.
+	MaxReplayCount int `json:"max_replay_count,omitempty"`
+
+	// Limits on the Metadata and InternalLabels maps of incoming batches: the
+	// number of entries and the total size of the keys and values of each
+	// map. 0 means no limit. MetadataLimitAction is "reject" (the default),
+	// which fails over-limit batches with ERR_METADATA_TOO_LARGE, or
+	// "truncate", which drops entries until the map is within the limits.
+	MaxMetadataEntries  int    `json:"max_metadata_entries,omitempty"`
+	MaxMetadataBytes    int    `json:"max_metadata_bytes,omitempty"`
+	MetadataLimitAction string `json:"metadata_limit_action,omitempty"`
+
+	// Whether the FB checks the data of incoming batches against the
+	// checksum FB-RX stamps in their internal labels. Mismatching batches
+	// are sent to the DLQ with ERR_INVALID_INPUT.
+	VerifyChecksum bool `json:"verify_checksum,omitempty"`
+
+	// Format FB-RX converts ingested batches to before forwarding them:
+	// "json", or "internal/protobuf", which is cheaper for the FBs down the
+	// chain to decode and encode. Empty forwards batches in the format they
+	// were ingested in. FBs rewriting a batch keep its format, and FB-GW
+	// exports internal protobuf batches as JSON.
+	InternalFormat string `json:"internal_format,omitempty"`
+
+	// Name of the environment variable holding the seed the FB's randomness,
+	// such as trace sampling decisions, derives from. Set from the pipeline's
+	// global settings for reproducible runs.
+	DeterministicSeedEnvVar string `json:"deterministic_seed_env_var,omitempty"`
+
+	// Capture of sampled batch payloads before and after processing, for
+	// debugging transformations. Off when omitted.
+	DebugTap *DebugTapConfig `json:"debug_tap,omitempty"`
+
+	// Rollback marks a config pushed to roll an FB back to an earlier
+	// generation. FBs refuse configs with a lower generation than the one
+	// they applied unless it is set. It applies to its own update only;
+	// merge patches don't inherit it from the applied config.
+	Rollback bool `json:"rollback,omitempty"`
+}
+
+// DebugTapConfig configures the debug tap, which writes the data of a sample
+// of batches as they enter and leave the FB to a file or a gRPC endpoint
+type DebugTapConfig struct {
+	// Ratio of batches captured, from 0 (off) to 1. Batches are sampled by
+	// ID, so a batch is captured on the way in and out of the FB.
+	SampleRatio float64 `json:"sample_ratio"`
+
+	// File captured batches are appended to as JSON lines. Exclusive with
+	// Endpoint.
+	File string `json:"file,omitempty"`
+
+	// Endpoint of a ChainPushService captured batches are pushed to as JSON
+	// batches. Exclusive with File.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Fields whose values are replaced before batches are captured,
+	// wherever they appear in a metric, including its labels
+	RedactFields []string `json:"redact_fields,omitempty"`
+}
+
+// Enabled returns whether the debug tap captures any batches
+func (c *DebugTapConfig) Enabled() bool {
+	return c != nil && c.SampleRatio > 0
+}
+
+// Validate checks the debug tap configuration
+func (c *DebugTapConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("invalid debug tap sample ratio: %v, must be between 0 and 1", c.SampleRatio)
+	}
+	if c.File != "" && c.Endpoint != "" {
+		return fmt.Errorf("debug tap file and endpoint are exclusive")
+	}
+	if c.SampleRatio > 0 && c.File == "" && c.Endpoint == "" {
+		return fmt.Errorf("debug tap needs a file or an endpoint")
+	}
+	return nil
+}
+
+// NextFBTarget is one of the next FBs batches fan out to
+type NextFBTarget struct {
+	Address string `json:"address"`
+
+	// Weight is the target's share of batches in weighted mode, and must be
+	// at least 1 there. It is ignored in all mode.
+	Weight int `json:"weight,omitempty"`
+}
+
+// HasNextFB returns whether the FB forwards to a next FB or fans out to
+// several
+func (c FBConfig) HasNextFB() bool {
+	return c.NextFB != "" || len(c.NextFBs) > 0
+}
+
+// IsEnabled returns whether the FB is enabled, defaulting to true when unset
+func (c FBConfig) IsEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// Validate checks the common configuration fields shared by all function blocks
+func (c FBConfig) Validate() error {
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	if err := tracing.ValidateSamplingRatio(c.TraceSamplingRatio); err != nil {
+		return err
+	}
+
+	if c.MaxConcurrentBatches < 0 {
+		return fmt.Errorf("invalid max concurrent batches: %d, must not be negative", c.MaxConcurrentBatches)
+	}
+
+	if c.MaxReplayCount < 0 {
+		return fmt.Errorf("invalid max replay count: %d, must not be negative", c.MaxReplayCount)
+	}
+
+	if c.MaxMetadataEntries < 0 {
+		return fmt.Errorf("invalid max metadata entries: %d, must not be negative", c.MaxMetadataEntries)
+	}
+
+	if c.MaxMetadataBytes < 0 {
+		return fmt.Errorf("invalid max metadata bytes: %d, must not be negative", c.MaxMetadataBytes)
+	}
+
+	switch c.MetadataLimitAction {
+	case "", "reject", "truncate":
+	default:
+		return fmt.Errorf("invalid metadata limit action: %q, must be reject or truncate", c.MetadataLimitAction)
+	}
+
+	if err := c.validateNextFBs(); err != nil {
+		return err
+	}
+
+	switch c.InternalFormat {
+	case "", "json", "internal/protobuf":
+	default:
+		return fmt.Errorf("invalid internal format: %q, must be json or internal/protobuf", c.InternalFormat)
+	}
+
+	if _, err := c.DeterministicSeed(); err != nil {
+		return err
+	}
+
+	if err := c.DebugTap.Validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateNextFBs checks the fanout targets and mode
+func (c FBConfig) validateNextFBs() error {
+	switch c.NextFBMode {
+	case "", "all", "weighted":
+	default:
+		return fmt.Errorf("invalid next FB mode: %q, must be all or weighted", c.NextFBMode)
+	}
+
+	if len(c.NextFBs) > 0 && c.NextFB != "" {
+		return fmt.Errorf("next_fb and next_fbs are exclusive")
+	}
+
+	seen := make(map[string]bool, len(c.NextFBs))
+	for _, target := range c.NextFBs {
+		if target.Address == "" {
+			return fmt.Errorf("next FB target has no address")
+		}
+		if seen[target.Address] {
+			return fmt.Errorf("duplicate next FB target: %s", target.Address)
+		}
+		seen[target.Address] = true
+		if target.Weight < 0 {
+			return fmt.Errorf("invalid weight for next FB target %s: %d, must not be negative", target.Address, target.Weight)
+		}
+		if c.NextFBMode == "weighted" && target.Weight == 0 {
+			return fmt.Errorf("next FB target %s has no weight, required in weighted mode", target.Address)
+		}
+	}
+	return nil
+}
+
+// DeterministicSeed resolves the seed from the environment variable named by
+// DeterministicSeedEnvVar. It returns nil when no seed is configured or the
+// variable is unset, in which case the FB's randomness is not reproducible.
+func (c FBConfig) DeterministicSeed() (*int64, error) {
+	if c.DeterministicSeedEnvVar == "" {
+		return nil, nil
+	}
+
+	value := os.Getenv(c.DeterministicSeedEnvVar)
+	if value == "" {
+		return nil, nil
+	}
+
+	seed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deterministic seed in %s: %w", c.DeterministicSeedEnvVar, err)
+	}
+	return &seed, nil
 }
 
 // CircuitBreakerConfig represents circuit breaker configuration
@@ -238,4 +658,51 @@ type CircuitBreakerConfig struct {
 	ErrorThresholdPercentage int `json:"error_threshold_percentage"`
 	OpenStateSeconds         int `json:"open_state_seconds"`
 	HalfOpenRequestThreshold int `json:"half_open_request_threshold"`
+
+	// StateWebhookURL receives a POST of every state change of the breaker,
+	// for faster incident awareness than the fb_cb_state metric
+	StateWebhookURL string `json:"state_webhook_url,omitempty"`
+}
+
+// NextFBCircuitBreakerConfig returns the configuration of the circuit breaker
+// guarding the next FB
+func (c FBConfig) NextFBCircuitBreakerConfig() resilience.CircuitBreakerConfig {
+	return c.CircuitBreaker.withOverrides(c.NextFBCircuitBreaker).resilienceConfig()
+}
+
+// DLQCircuitBreakerConfig returns the configuration of the circuit breaker
+// guarding the DLQ
+func (c FBConfig) DLQCircuitBreakerConfig() resilience.CircuitBreakerConfig {
+	return c.CircuitBreaker.withOverrides(c.DLQCircuitBreaker).resilienceConfig()
+}
+
+// withOverrides returns the configuration with the set fields of overrides
+// replacing its own
+func (c CircuitBreakerConfig) withOverrides(overrides *CircuitBreakerConfig) CircuitBreakerConfig {
+	if overrides == nil {
+		return c
+	}
+	if overrides.ErrorThresholdPercentage != 0 {
+		c.ErrorThresholdPercentage = overrides.ErrorThresholdPercentage
+	}
+	if overrides.OpenStateSeconds != 0 {
+		c.OpenStateSeconds = overrides.OpenStateSeconds
+	}
+	if overrides.HalfOpenRequestThreshold != 0 {
+		c.HalfOpenRequestThreshold = overrides.HalfOpenRequestThreshold
+	}
+	if overrides.StateWebhookURL != "" {
+		c.StateWebhookURL = overrides.StateWebhookURL
+	}
+	return c
+}
+
+// resilienceConfig converts the configuration to a resilience.CircuitBreakerConfig
+func (c CircuitBreakerConfig) resilienceConfig() resilience.CircuitBreakerConfig {
+	return resilience.CircuitBreakerConfig{
+		ErrorThresholdPercentage: c.ErrorThresholdPercentage,
+		OpenStateSeconds:         c.OpenStateSeconds,
+		HalfOpenRequestThreshold: c.HalfOpenRequestThreshold,
+		StateWebhookURL:          c.StateWebhookURL,
+	}
 }
\ No newline at end of file
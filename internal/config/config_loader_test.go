@@ -0,0 +1,119 @@
+package config
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// nopLogger discards log messages
+type nopLogger struct{}
+
+func (nopLogger) Info(msg string, keyValues map[string]interface{})             {}
+func (nopLogger) Error(msg string, err error, keyValues map[string]interface{}) {}
+func (nopLogger) Warn(msg string, keyValues map[string]interface{})             {}
+func (nopLogger) Debug(msg string, keyValues map[string]interface{})            {}
+
+// fakeConfigStream delivers updates, then blocks until its context is done
+type fakeConfigStream struct {
+	grpc.ClientStream
+	ctx     context.Context
+	updates chan *ConfigResponse
+}
+
+func (s *fakeConfigStream) Recv() (*ConfigResponse, error) {
+	select {
+	case res := <-s.updates:
+		return res, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+// fakeConfigService streams updates and records acks
+type fakeConfigService struct {
+	stream *fakeConfigStream
+
+	mu   sync.Mutex
+	acks []int64
+}
+
+func (s *fakeConfigService) GetConfig(ctx context.Context, in *ConfigRequest, opts ...grpc.CallOption) (*ConfigResponse, error) {
+	return &ConfigResponse{}, nil
+}
+
+func (s *fakeConfigService) StreamConfig(ctx context.Context, in *ConfigRequest, opts ...grpc.CallOption) (ConfigService_StreamConfigClient, error) {
+	return s.stream, nil
+}
+
+func (s *fakeConfigService) AckConfig(ctx context.Context, in *ConfigAckRequest, opts ...grpc.CallOption) (*ConfigAckResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acks = append(s.acks, in.Generation)
+	return &ConfigAckResponse{Success: true}, nil
+}
+
+func (s *fakeConfigService) ackedGenerations() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int64(nil), s.acks...)
+}
+
+func TestConfigClient_CoalescesRapidAcks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	service := &fakeConfigService{stream: &fakeConfigStream{ctx: ctx, updates: make(chan *ConfigResponse, 5)}}
+	client := &ConfigClient{
+		client:     service,
+		fbName:     "fb-test",
+		instanceID: "fb-test-0",
+		logger:     nopLogger{},
+		ackJitter:  100 * time.Millisecond,
+	}
+
+	applied := make(chan int64, 5)
+	client.RegisterCallback(func(config []byte, generation int64) error {
+		applied <- generation
+		return nil
+	})
+
+	for generation := int64(1); generation <= 5; generation++ {
+		service.stream.updates <- &ConfigResponse{Config: []byte(`{}`), Generation: generation}
+	}
+	go client.watchConfig(ctx)
+
+	// Every update is applied, but only the latest generation is acked
+	for generation := int64(1); generation <= 5; generation++ {
+		assert.Equal(t, generation, <-applied)
+	}
+	require.Eventually(t, func() bool {
+		return len(service.ackedGenerations()) > 0
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []int64{5}, service.ackedGenerations())
+
+	// An update after the ack is acked on its own
+	service.stream.updates <- &ConfigResponse{Config: []byte(`{}`), Generation: 6}
+	require.Eventually(t, func() bool {
+		return len(service.ackedGenerations()) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{5, 6}, service.ackedGenerations())
+}
+
+func TestFBConfig_ValidateNextFBWeights(t *testing.T) {
+	targets := []NextFBTarget{{Address: "fb-a:5000", Weight: 3}, {Address: "fb-b:5000"}}
+
+	// Weights are ignored in all mode
+	assert.NoError(t, FBConfig{NextFBs: targets}.Validate())
+
+	// A target without weight would get no traffic in weighted mode
+	err := FBConfig{NextFBs: targets, NextFBMode: "weighted"}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "fb-b:5000")
+}
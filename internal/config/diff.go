@@ -0,0 +1,159 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// RedactedValue replaces the values of secret fields in config diffs
+const RedactedValue = "[REDACTED]"
+
+// secretKeyMarkers mark the config keys whose values are secrets, matched
+// case-insensitively against any part of the key
+var secretKeyMarkers = []string{"password", "secret", "token", "credential", "api_key", "apikey"}
+
+// ConfigChange is a config value that differs between two configs
+type ConfigChange struct {
+	// Path of the value, its object keys joined with "."
+	Path string `json:"path"`
+
+	// Old and New are the values before and after, nil where the key is
+	// absent
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// DiffConfig compares two configs by their JSON representations and returns
+// the values that changed, ordered by path. Objects are compared key by key
+// and other values, including arrays, as a whole. Values of secret keys are
+// redacted. A nil old config, such as before an FB's first config, has no
+// changes to report.
+func DiffConfig(oldConfig, newConfig interface{}) ([]ConfigChange, error) {
+	oldJSON, err := json.Marshal(oldConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal old config: %w", err)
+	}
+	newJSON, err := json.Marshal(newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal new config: %w", err)
+	}
+	return DiffConfigJSON(oldJSON, newJSON)
+}
+
+// DiffConfigJSON is DiffConfig for configs already in JSON
+func DiffConfigJSON(oldJSON, newJSON []byte) ([]ConfigChange, error) {
+	oldValue, err := decodeJSONNumbers(oldJSON)
+	if err != nil {
+		return nil, fmt.Errorf("invalid old config: %w", err)
+	}
+	if oldValue == nil {
+		return nil, nil
+	}
+	newValue, err := decodeJSONNumbers(newJSON)
+	if err != nil {
+		return nil, fmt.Errorf("invalid new config: %w", err)
+	}
+
+	var changes []ConfigChange
+	diffValues("", oldValue, newValue, &changes)
+	return changes, nil
+}
+
+// decodeJSONNumbers decodes JSON keeping numbers as json.Number, so they
+// compare and print as written
+func decodeJSONNumbers(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// diffValues appends the changes between the values at path
+func diffValues(path string, oldValue, newValue interface{}, changes *[]ConfigChange) {
+	oldObject, oldIsObject := oldValue.(map[string]interface{})
+	newObject, newIsObject := newValue.(map[string]interface{})
+	if oldIsObject && newIsObject {
+		keys := make([]string, 0, len(oldObject)+len(newObject))
+		for key := range oldObject {
+			keys = append(keys, key)
+		}
+		for key := range newObject {
+			if _, ok := oldObject[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			diffValues(keyPath, oldObject[key], newObject[key], changes)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(oldValue, newValue) {
+		return
+	}
+
+	change := ConfigChange{Path: path, Old: redactSecrets(oldValue), New: redactSecrets(newValue)}
+	if isSecretKey(path) {
+		change.Old, change.New = redactedOrNil(oldValue), redactedOrNil(newValue)
+	}
+	*changes = append(*changes, change)
+}
+
+// isSecretKey reports whether the last key of path holds a secret
+func isSecretKey(path string) bool {
+	key := strings.ToLower(path[strings.LastIndex(path, ".")+1:])
+	for _, marker := range secretKeyMarkers {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedOrNil redacts a secret value, keeping absent values nil so that
+// added and removed secrets are told apart
+func redactedOrNil(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return RedactedValue
+}
+
+// redactSecrets returns value with the values of any secret keys nested in
+// it redacted
+func redactSecrets(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			if isSecretKey(key) {
+				redacted[key] = redactedOrNil(field)
+				continue
+			}
+			redacted[key] = redactSecrets(field)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redactSecrets(item)
+		}
+		return redacted
+	default:
+		return value
+	}
+}
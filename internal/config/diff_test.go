@@ -0,0 +1,57 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffConfigJSON(t *testing.T) {
+	oldJSON := []byte(`{
+		"common": {"next_fb": "fb-dp:5000", "log_level": "info"},
+		"ttl_minutes": 5,
+		"keys": ["name"],
+		"remote_write": {"url": "http://a", "bearer_token": "old-token"},
+		"removed": true
+	}`)
+	newJSON := []byte(`{
+		"common": {"next_fb": "fb-gw:5000", "log_level": "info"},
+		"ttl_minutes": 10,
+		"keys": ["name", "host"],
+		"remote_write": {"url": "http://a", "bearer_token": "new-token"},
+		"added": {"basic_auth_password": "hunter2", "user": "admin"}
+	}`)
+
+	changes, err := DiffConfigJSON(oldJSON, newJSON)
+	require.NoError(t, err)
+	assert.Equal(t, []ConfigChange{
+		{Path: "added", New: map[string]interface{}{"basic_auth_password": RedactedValue, "user": "admin"}},
+		{Path: "common.next_fb", Old: "fb-dp:5000", New: "fb-gw:5000"},
+		{Path: "keys", Old: []interface{}{"name"}, New: []interface{}{"name", "host"}},
+		{Path: "remote_write.bearer_token", Old: RedactedValue, New: RedactedValue},
+		{Path: "removed", Old: true},
+		{Path: "ttl_minutes", Old: json.Number("5"), New: json.Number("10")},
+	}, changes)
+}
+
+func TestDiffConfig(t *testing.T) {
+	type testConfig struct {
+		TTLMinutes int `json:"ttlMinutes"`
+	}
+
+	// A first config has nothing to compare with
+	var unset *testConfig
+	changes, err := DiffConfig(unset, &testConfig{TTLMinutes: 5})
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	changes, err = DiffConfig(&testConfig{TTLMinutes: 5}, &testConfig{TTLMinutes: 5})
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	changes, err = DiffConfig(&testConfig{TTLMinutes: 5}, &testConfig{TTLMinutes: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []ConfigChange{{Path: "ttlMinutes", Old: json.Number("5"), New: json.Number("10")}}, changes)
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Formats of FB configurations
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// DetectFormat returns the format of a configuration: JSON if it starts with
+// an object, YAML otherwise
+func DetectFormat(configBytes []byte) string {
+	if bytes.HasPrefix(bytes.TrimSpace(configBytes), []byte("{")) {
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
+// FormatForPath returns the format of a configuration file from its
+// extension, or "" to detect it from the content
+func FormatForPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	default:
+		return ""
+	}
+}
+
+// LoadConfigFromBytesAs loads a configuration in the given format. YAML is
+// decoded through its JSON equivalent, so the json field tags of config
+// apply to both formats. An empty format is detected from the content.
+func LoadConfigFromBytesAs(configBytes []byte, format string, config interface{}) error {
+	if format == "" {
+		format = DetectFormat(configBytes)
+	}
+
+	switch format {
+	case FormatJSON:
+		return json.Unmarshal(configBytes, config)
+	case FormatYAML:
+		return yaml.Unmarshal(configBytes, config)
+	default:
+		return fmt.Errorf("unknown config format: %s", format)
+	}
+}
+
+// ToJSON converts a configuration in the given format to JSON, the format
+// the ConfigController and config deltas use. JSON is returned unchanged.
+// An empty format is detected from the content.
+func ToJSON(configBytes []byte, format string) ([]byte, error) {
+	if format == "" {
+		format = DetectFormat(configBytes)
+	}
+
+	switch format {
+	case FormatJSON:
+		return configBytes, nil
+	case FormatYAML:
+		return yaml.YAMLToJSON(configBytes)
+	default:
+		return nil, fmt.Errorf("unknown config format: %s", format)
+	}
+}
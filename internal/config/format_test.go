@@ -0,0 +1,85 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigJSON = `{
+	"enabled": false,
+	"log_level": "debug",
+	"trace_sampling_ratio": 0.25,
+	"next_fbs": [
+		{"address": "fb-a:5000", "weight": 3},
+		{"address": "fb-b:5000", "weight": 1}
+	],
+	"next_fb_mode": "weighted",
+	"dlq": "fb-dlq:5000",
+	"circuit_breaker": {
+		"error_threshold_percentage": 50,
+		"open_state_seconds": 30,
+		"half_open_request_threshold": 5
+	},
+	"max_metadata_entries": 64
+}`
+
+const testConfigYAML = `
+# Same configuration as testConfigJSON
+enabled: false
+log_level: debug
+trace_sampling_ratio: 0.25
+next_fbs:
+  - address: fb-a:5000
+    weight: 3
+  - address: fb-b:5000
+    weight: 1
+next_fb_mode: weighted
+dlq: fb-dlq:5000
+circuit_breaker:
+  error_threshold_percentage: 50
+  open_state_seconds: 30
+  half_open_request_threshold: 5
+max_metadata_entries: 64
+`
+
+func TestLoadConfigFromBytes_YAMLMatchesJSON(t *testing.T) {
+	assert.Equal(t, FormatJSON, DetectFormat([]byte(testConfigJSON)))
+	assert.Equal(t, FormatYAML, DetectFormat([]byte(testConfigYAML)))
+
+	var fromJSON, fromYAML FBConfig
+	require.NoError(t, LoadConfigFromBytes([]byte(testConfigJSON), &fromJSON))
+	require.NoError(t, LoadConfigFromBytes([]byte(testConfigYAML), &fromYAML))
+	assert.Equal(t, fromJSON, fromYAML)
+	assert.False(t, fromYAML.IsEnabled())
+	assert.Equal(t, 3, fromYAML.NextFBs[0].Weight)
+	assert.NoError(t, fromYAML.Validate())
+
+	// A told format overrides detection
+	var told FBConfig
+	require.NoError(t, LoadConfigFromBytesAs([]byte(`{"log_level": "debug"}`), FormatYAML, &told))
+	assert.Equal(t, "debug", told.LogLevel)
+	assert.Error(t, LoadConfigFromBytesAs([]byte(testConfigYAML), FormatJSON, &told))
+}
+
+func TestToJSON(t *testing.T) {
+	converted, err := ToJSON([]byte(testConfigYAML), "")
+	require.NoError(t, err)
+	assert.JSONEq(t, testConfigJSON, string(converted))
+
+	// JSON is passed through unchanged
+	converted, err = ToJSON([]byte(testConfigJSON), FormatJSON)
+	require.NoError(t, err)
+	assert.Equal(t, testConfigJSON, string(converted))
+
+	_, err = ToJSON([]byte(testConfigJSON), "toml")
+	assert.Error(t, err)
+}
+
+func TestFormatForPath(t *testing.T) {
+	assert.Equal(t, FormatJSON, FormatForPath("/etc/fb/config.json"))
+	assert.Equal(t, FormatYAML, FormatForPath("/etc/fb/config.yaml"))
+	assert.Equal(t, FormatYAML, FormatForPath("/etc/fb/config.YML"))
+	assert.Equal(t, "", FormatForPath("/etc/fb/config"))
+}
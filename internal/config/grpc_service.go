@@ -26,6 +26,13 @@ type ConfigRequest struct {
 	
 	// Last known configuration generation
 	LastKnownGeneration int64 `json:"last_known_generation"`
+	
+	// Whether config updates may be streamed as deltas
+	SupportsDelta bool `json:"supports_delta"`
+	
+	// Whether config updates may be sent as merge patches for the FB to
+	// merge onto the config it applied
+	SupportsMergePatch bool `json:"supports_merge_patch"`
 }
 
 // ConfigResponse is a response containing configuration
@@ -38,6 +45,18 @@ type ConfigResponse struct {
 	
 	// Whether the configuration requires a restart
 	RequiresRestart bool `json:"requires_restart"`
+	
+	// JSON merge patch from the configuration at BaseGeneration, sent instead
+	// of Config when the request set SupportsDelta
+	ConfigPatch []byte `json:"config_patch,omitempty"`
+	
+	// Generation ConfigPatch applies to
+	BaseGeneration int64 `json:"base_generation,omitempty"`
+	
+	// Whether Config is a JSON merge patch of only the changed fields, for
+	// the FB to merge onto the config it applied instead of replacing it.
+	// Only set when the request set SupportsMergePatch.
+	MergePatch bool `json:"merge_patch,omitempty"`
 }
 
 // ConfigAckRequest is a request to acknowledge a configuration update
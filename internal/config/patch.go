@@ -0,0 +1,92 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// mergePatchKey is the only key of the envelope a merge patch is delivered
+// to an FB's UpdateConfig in
+const mergePatchKey = "merge_patch"
+
+// ErrNoConfigToPatch is returned for a merge patch delivered to an FB that
+// has no config applied to merge it onto
+var ErrNoConfigToPatch = errors.New("no applied config to merge the patch onto")
+
+// WrapMergePatch wraps a JSON merge patch (RFC 7386) of the changed fields of
+// an FB config, for the FB to merge onto the config it applied rather than
+// replace it
+func WrapMergePatch(patch []byte) ([]byte, error) {
+	if !json.Valid(patch) {
+		return nil, fmt.Errorf("invalid config merge patch")
+	}
+	return json.Marshal(map[string]json.RawMessage{mergePatchKey: patch})
+}
+
+// ResolveMergePatch returns configBytes unchanged unless it wraps a merge
+// patch, in which case it returns the applied config with the patch merged
+// onto it. applied is the FB's config, encoded as JSON to merge onto; a nil
+// pointer means no config is applied yet. The applied config's common
+// rollback flag is dropped first, as it was for the update that carried it:
+// a patch is a rollback only if it sets the flag itself.
+func ResolveMergePatch(configBytes []byte, applied interface{}) ([]byte, error) {
+	patch, ok := unwrapMergePatch(configBytes)
+	if !ok {
+		return configBytes, nil
+	}
+
+	base, err := json.Marshal(applied)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode applied config: %w", err)
+	}
+	if bytes.Equal(base, []byte("null")) {
+		return nil, ErrNoConfigToPatch
+	}
+	if base, err = withoutRollback(base); err != nil {
+		return nil, fmt.Errorf("failed to encode applied config: %w", err)
+	}
+
+	merged, err := jsonpatch.MergePatch(base, patch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge config patch: %w", err)
+	}
+	return merged, nil
+}
+
+// withoutRollback returns an FB config encoded as JSON without the rollback
+// flag of its common config
+func withoutRollback(base []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(base, &fields); err != nil {
+		return nil, err
+	}
+	common, ok := fields["common"]
+	if !ok {
+		return base, nil
+	}
+
+	cleared, err := jsonpatch.MergePatch(common, []byte(`{"rollback":null}`))
+	if err != nil {
+		return nil, err
+	}
+	fields["common"] = cleared
+	return json.Marshal(fields)
+}
+
+// unwrapMergePatch returns the merge patch configBytes wraps, if any
+func unwrapMergePatch(configBytes []byte) ([]byte, bool) {
+	if DetectFormat(configBytes) != FormatJSON {
+		return nil, false
+	}
+
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(configBytes, &envelope); err != nil || len(envelope) != 1 {
+		return nil, false
+	}
+	patch, ok := envelope[mergePatchKey]
+	return patch, ok
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveMergePatch(t *testing.T) {
+	applied := &FBConfig{LogLevel: "info", NextFB: "fb-next:5000", DLQ: "fb-dlq:5000"}
+	applied.CircuitBreaker.OpenStateSeconds = 30
+	applied.CircuitBreaker.HalfOpenRequestThreshold = 5
+
+	// A full config is returned unchanged
+	full := []byte(`{"log_level": "debug"}`)
+	resolved, err := ResolveMergePatch(full, applied)
+	require.NoError(t, err)
+	assert.Equal(t, full, resolved)
+
+	// A patch is merged onto the applied config, deep and with null
+	// removing a field
+	patch, err := WrapMergePatch([]byte(`{"circuit_breaker": {"open_state_seconds": 60}, "dlq": null}`))
+	require.NoError(t, err)
+	resolved, err = ResolveMergePatch(patch, applied)
+	require.NoError(t, err)
+
+	var merged FBConfig
+	require.NoError(t, LoadConfigFromBytes(resolved, &merged))
+	expected := *applied
+	expected.CircuitBreaker.OpenStateSeconds = 60
+	expected.DLQ = ""
+	assert.Equal(t, expected, merged)
+
+	// There is nothing to merge a patch onto before a config is applied
+	var none *FBConfig
+	_, err = ResolveMergePatch(patch, none)
+	assert.ErrorIs(t, err, ErrNoConfigToPatch)
+
+	_, err = WrapMergePatch([]byte(`{"dlq":`))
+	assert.Error(t, err)
+}
+
+func TestConfigClient_DeliversMergePatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	service := &fakeConfigService{stream: &fakeConfigStream{ctx: ctx, updates: make(chan *ConfigResponse, 3)}}
+	client := &ConfigClient{
+		client:     service,
+		fbName:     "fb-test",
+		instanceID: "fb-test-0",
+		logger:     nopLogger{},
+	}
+
+	applied := make(chan []byte, 3)
+	client.RegisterCallback(func(config []byte, generation int64) error {
+		applied <- config
+		return nil
+	})
+
+	service.stream.updates <- &ConfigResponse{Config: []byte(`{"log_level":"info","dlq":"fb-dlq:5000"}`), Generation: 1}
+	service.stream.updates <- &ConfigResponse{Config: []byte(`{"log_level":"debug"}`), Generation: 2, MergePatch: true}
+	go client.watchConfig(ctx)
+
+	assert.JSONEq(t, `{"log_level":"info","dlq":"fb-dlq:5000"}`, string(<-applied))
+
+	// The FB gets the patch wrapped, to merge onto the config it applied
+	wrapped, err := WrapMergePatch([]byte(`{"log_level":"debug"}`))
+	require.NoError(t, err)
+	assert.Equal(t, wrapped, <-applied)
+
+	// Deltas that follow apply to the full config
+	service.stream.updates <- &ConfigResponse{ConfigPatch: []byte(`{"dlq":null}`), BaseGeneration: 2, Generation: 3}
+	assert.JSONEq(t, `{"log_level":"debug"}`, string(<-applied))
+}
+
+func TestResolveMergePatch_DropsAppliedRollback(t *testing.T) {
+	type fbConfig struct {
+		Common   FBConfig `json:"common"`
+		Interval int      `json:"interval"`
+	}
+	applied := &fbConfig{Common: FBConfig{LogLevel: "info", Rollback: true}, Interval: 10}
+
+	// The rollback flag of the applied config isn't carried into a patch
+	patch, err := WrapMergePatch([]byte(`{"interval": 20}`))
+	require.NoError(t, err)
+	resolved, err := ResolveMergePatch(patch, applied)
+	require.NoError(t, err)
+
+	var merged fbConfig
+	require.NoError(t, LoadConfigFromBytes(resolved, &merged))
+	assert.Equal(t, fbConfig{Common: FBConfig{LogLevel: "info"}, Interval: 20}, merged)
+
+	// A patch can still flag itself as a rollback
+	patch, err = WrapMergePatch([]byte(`{"common": {"rollback": true}}`))
+	require.NoError(t, err)
+	resolved, err = ResolveMergePatch(patch, applied)
+	require.NoError(t, err)
+	require.NoError(t, LoadConfigFromBytes(resolved, &merged))
+	assert.True(t, merged.Common.Rollback)
+}
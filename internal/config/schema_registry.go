@@ -0,0 +1,169 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"eidc-tfk8s/internal/common/schema"
+)
+
+// commonParametersSchema describes the FBConfig block every FB's parameters
+// carry under "common". It is added to each registered schema.
+const commonParametersSchema = `{
+	"type": "object",
+	"properties": {
+		"enabled": {"type": "boolean"},
+		"log_level": {"type": "string"},
+		"metrics_enabled": {"type": "boolean"},
+		"tracing_enabled": {"type": "boolean"},
+		"trace_sampling_ratio": {"type": "number"},
+		"next_fb": {"type": "string"},
+		"next_fbs": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"address": {"type": "string"},
+					"weight": {"type": "integer"}
+				}
+			}
+		},
+		"next_fb_mode": {"type": "string", "enum": ["", "all", "weighted"]},
+		"dlq": {"type": "string"},
+		"quarantine": {"type": "string"},
+		"circuit_breaker": {
+			"type": "object",
+			"properties": {
+				"error_threshold_percentage": {"type": "integer"},
+				"open_state_seconds": {"type": "integer"},
+				"half_open_request_threshold": {"type": "integer"},
+				"state_webhook_url": {"type": "string"}
+			}
+		},
+		"next_fb_circuit_breaker": {
+			"type": "object",
+			"properties": {
+				"error_threshold_percentage": {"type": "integer"},
+				"open_state_seconds": {"type": "integer"},
+				"half_open_request_threshold": {"type": "integer"},
+				"state_webhook_url": {"type": "string"}
+			}
+		},
+		"dlq_circuit_breaker": {
+			"type": "object",
+			"properties": {
+				"error_threshold_percentage": {"type": "integer"},
+				"open_state_seconds": {"type": "integer"},
+				"half_open_request_threshold": {"type": "integer"},
+				"state_webhook_url": {"type": "string"}
+			}
+		},
+		"deterministic_seed_env_var": {"type": "string"},
+		"max_concurrent_batches": {"type": "integer"},
+		"max_replay_count": {"type": "integer"},
+		"max_metadata_entries": {"type": "integer"},
+		"max_metadata_bytes": {"type": "integer"},
+		"metadata_limit_action": {"type": "string", "enum": ["", "reject", "truncate"]},
+		"verify_checksum": {"type": "boolean"},
+		"internal_format": {"type": "string", "enum": ["", "json", "internal/protobuf"]},
+		"debug_tap": {
+			"type": "object",
+			"properties": {
+				"sample_ratio": {"type": "number"},
+				"file": {"type": "string"},
+				"endpoint": {"type": "string"},
+				"redact_fields": {"type": "array", "items": {"type": "string"}}
+			}
+		},
+		"rollback": {"type": "boolean"}
+	}
+}`
+
+// parametersSchemas holds the parameters validator of each FB type
+var (
+	parametersSchemasMu sync.RWMutex
+	parametersSchemas   = make(map[string]*schema.JSONSchemaValidator)
+)
+
+// RegisterParametersSchema registers the JSON Schema the parameters of an FB
+// type (e.g. "fb-rx") must match. FB packages call it from init() so the
+// config controller can validate a CRD before broadcasting it. It panics if
+// the schema is invalid or the FB type is already registered.
+func RegisterParametersSchema(fbType, schemaJSON string) {
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(schemaJSON), &parsed); err != nil {
+		panic(fmt.Sprintf("config: invalid parameters schema for %s: %v", fbType, err))
+	}
+
+	var common map[string]interface{}
+	if err := json.Unmarshal([]byte(commonParametersSchema), &common); err != nil {
+		panic(fmt.Sprintf("config: invalid common parameters schema: %v", err))
+	}
+
+	properties, ok := parsed["properties"].(map[string]interface{})
+	if !ok {
+		properties = make(map[string]interface{})
+		parsed["properties"] = properties
+	}
+	if _, ok := properties["common"]; !ok {
+		properties["common"] = common
+	}
+
+	merged, err := json.Marshal(parsed)
+	if err != nil {
+		panic(fmt.Sprintf("config: invalid parameters schema for %s: %v", fbType, err))
+	}
+
+	validator, err := schema.NewJSONSchemaValidator(string(merged), nil, false)
+	if err != nil {
+		panic(fmt.Sprintf("config: invalid parameters schema for %s: %v", fbType, err))
+	}
+
+	parametersSchemasMu.Lock()
+	defer parametersSchemasMu.Unlock()
+
+	if _, exists := parametersSchemas[fbType]; exists {
+		panic(fmt.Sprintf("config: parameters schema for %s registered twice", fbType))
+	}
+	parametersSchemas[fbType] = validator
+}
+
+// RegisteredParametersSchemas returns the FB types with a registered schema
+func RegisteredParametersSchemas() []string {
+	parametersSchemasMu.RLock()
+	defer parametersSchemasMu.RUnlock()
+
+	fbTypes := make([]string, 0, len(parametersSchemas))
+	for fbType := range parametersSchemas {
+		fbTypes = append(fbTypes, fbType)
+	}
+	sort.Strings(fbTypes)
+	return fbTypes
+}
+
+// ValidateParameters validates the JSON parameters of an FB against the
+// schema registered for its type. FB types without a schema are accepted.
+func ValidateParameters(fbType string, parameters []byte) error {
+	parametersSchemasMu.RLock()
+	validator, ok := parametersSchemas[fbType]
+	parametersSchemasMu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(parameters, &data); err != nil {
+		return fmt.Errorf("invalid %s parameters: %w", fbType, err)
+	}
+
+	if result := validator.Validate(data); !result.Valid {
+		if result.Path != "" {
+			return fmt.Errorf("invalid %s parameters at %s: %w", fbType, result.Path, result.Error)
+		}
+		return fmt.Errorf("invalid %s parameters: %w", fbType, result.Error)
+	}
+	return nil
+}
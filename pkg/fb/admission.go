@@ -0,0 +1,90 @@
+package fb
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of the admission of batches by ChainPushServiceHandler
+var (
+	inflightBatches = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fb_inflight_batches",
+		Help: "Number of batches currently being processed by the function block",
+	}, []string{"fb_name"})
+
+	admissionRejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_admission_rejections_total",
+		Help: "Total number of batches rejected because the function block was at its concurrency limit",
+	}, []string{"fb_name"})
+)
+
+// AdmissionLimiter is implemented by function blocks that cap how many
+// batches they process at once. BaseFunctionBlock implements it.
+type AdmissionLimiter interface {
+	// MaxConcurrentBatches returns the cap, or 0 for no cap
+	MaxConcurrentBatches() int
+}
+
+// SetMaxConcurrentBatches sets how many batches the function block processes
+// at once, from FBConfig.MaxConcurrentBatches. 0 removes the cap.
+func (b *BaseFunctionBlock) SetMaxConcurrentBatches(max int) {
+	atomic.StoreInt64(&b.maxConcurrentBatches, int64(max))
+}
+
+// MaxConcurrentBatches implements AdmissionLimiter
+func (b *BaseFunctionBlock) MaxConcurrentBatches() int {
+	return int(atomic.LoadInt64(&b.maxConcurrentBatches))
+}
+
+// semaphore is a weighted semaphore whose size is given on each acquire, so
+// a config change resizes it without disturbing batches already admitted
+type semaphore struct {
+	mu  sync.Mutex
+	cur int64
+}
+
+// tryAcquire takes weight from the semaphore without blocking, reporting
+// whether it fit within size. A size of 0 or less admits everything.
+func (s *semaphore) tryAcquire(weight, size int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if size > 0 && s.cur+weight > size {
+		return false
+	}
+	s.cur += weight
+	return true
+}
+
+// release returns weight taken by tryAcquire
+func (s *semaphore) release(weight int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cur -= weight
+}
+
+// admit takes an in-flight slot for a batch, returning the function that
+// frees it, or a retryable ResourceExhausted error when the function block
+// is at its concurrency limit
+func (h *ChainPushServiceHandler) admit(batchID string) (func(), error) {
+	var limit int
+	if limiter, ok := h.fb.(AdmissionLimiter); ok {
+		limit = limiter.MaxConcurrentBatches()
+	}
+
+	name := h.fb.Name()
+	if !h.inFlight.tryAcquire(1, int64(limit)) {
+		admissionRejectionsTotal.WithLabelValues(name).Inc()
+		return nil, ErrorCodeThrottled.GRPCError(fmt.Errorf("%s is processing %d batches, rejecting batch %s", name, limit, batchID))
+	}
+
+	inflightBatches.WithLabelValues(name).Inc()
+	return func() {
+		inflightBatches.WithLabelValues(name).Dec()
+		h.inFlight.release(1)
+	}, nil
+}
@@ -0,0 +1,100 @@
+package fb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// blockingFB is a function block whose batches stay in flight until released
+type blockingFB struct {
+	BaseFunctionBlock
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingFB) Initialize(ctx context.Context) error { return nil }
+
+func (b *blockingFB) ProcessBatch(ctx context.Context, batch *MetricBatch) (*ProcessResult, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return NewSuccessResult(batch.BatchID), nil
+}
+
+func (b *blockingFB) UpdateConfig(ctx context.Context, configBytes []byte, generation int64) error {
+	return nil
+}
+
+func (b *blockingFB) ValidateConfig(configBytes []byte) error { return nil }
+
+func (b *blockingFB) Shutdown(ctx context.Context) error { return nil }
+
+func TestChainPushServiceHandler_CapsConcurrentBatches(t *testing.T) {
+	block := &blockingFB{
+		BaseFunctionBlock: NewBaseFunctionBlock("fb-admission-test"),
+		started:           make(chan struct{}),
+		release:           make(chan struct{}),
+	}
+	block.SetMaxConcurrentBatches(2)
+	handler := NewChainPushServiceHandler(block)
+
+	inflight := inflightBatches.WithLabelValues("fb-admission-test")
+	rejections := admissionRejectionsTotal.WithLabelValues("fb-admission-test")
+
+	// Fill both slots
+	results := make(chan *MetricBatchResponse, 2)
+	for _, batchID := range []string{"batch-1", "batch-2"} {
+		go func(batchID string) {
+			res, err := handler.PushMetrics(context.Background(), &MetricBatchRequest{BatchId: batchID})
+			assert.NoError(t, err)
+			results <- res
+		}(batchID)
+		<-block.started
+	}
+	assert.Equal(t, float64(2), testutil.ToFloat64(inflight))
+
+	// A third batch is rejected without being processed
+	_, err := handler.PushMetrics(context.Background(), &MetricBatchRequest{BatchId: "batch-3"})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.True(t, ErrorCodeThrottled.Retriable())
+	assert.Equal(t, float64(1), testutil.ToFloat64(rejections))
+	assert.Equal(t, float64(2), testutil.ToFloat64(inflight))
+
+	// Finishing a batch frees its slot
+	block.release <- struct{}{}
+	res := <-results
+	assert.Equal(t, StatusSuccess, res.Status)
+	assert.Eventually(t, func() bool { return testutil.ToFloat64(inflight) == 1 }, time.Second, time.Millisecond)
+
+	go func() {
+		res, err := handler.PushMetrics(context.Background(), &MetricBatchRequest{BatchId: "batch-4"})
+		assert.NoError(t, err)
+		results <- res
+	}()
+	<-block.started
+	assert.Equal(t, float64(2), testutil.ToFloat64(inflight))
+
+	// Removing the cap admits everything
+	block.SetMaxConcurrentBatches(0)
+	go func() {
+		res, err := handler.PushMetrics(context.Background(), &MetricBatchRequest{BatchId: "batch-5"})
+		assert.NoError(t, err)
+		results <- res
+	}()
+	<-block.started
+	assert.Equal(t, float64(3), testutil.ToFloat64(inflight))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rejections))
+
+	for i := 0; i < 3; i++ {
+		block.release <- struct{}{}
+		<-results
+	}
+	assert.Equal(t, float64(0), testutil.ToFloat64(inflight))
+}
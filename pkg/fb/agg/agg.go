@@ -3,7 +3,9 @@ package agg
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -11,7 +13,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/rs/zerolog/log"
 
-	"github.com/newrelic/nrdot-internal-devlab/pkg/fb"
+	"eidc-tfk8s/pkg/fb"
 	"github.com/newrelic/nrdot-internal-devlab/pkg/metrics"
 	"github.com/newrelic/nrdot-internal-devlab/pkg/telemetry"
 )
@@ -26,8 +28,45 @@ type Config struct {
 
 	// BufferSize is the size of the buffer for incoming metrics
 	BufferSize int `json:"bufferSize"`
+
+	// MaxSeries caps the number of live aggregators (one per label combination).
+	// Defaults to defaultMaxSeries when unset.
+	MaxSeries int `json:"maxSeries"`
+
+	// DLQ is the address of the DLQ function block
+	DLQ string `json:"dlq"`
+
+	// DLQOnSeriesLimit routes metrics rejected by the series limit to the DLQ
+	DLQOnSeriesLimit bool `json:"dlqOnSeriesLimit"`
+
+	// IdleSeconds is how long an aggregator may go without metrics before it
+	// is evicted. Defaults to defaultIdleWindows aggregation windows.
+	IdleSeconds int `json:"idleSeconds"`
+
+	// VerifyChecksum checks the data of incoming batches against the
+	// checksum FB-RX stamps in their internal labels
+	VerifyChecksum bool `json:"verifyChecksum"`
+	// Rollback marks a config pushed to roll back to an earlier generation,
+	// which is refused otherwise
+	Rollback bool `json:"rollback,omitempty"`
 }
 
+// defaultIdleWindows is the number of windows an aggregator may stay idle
+// before eviction when IdleSeconds is not configured
+const defaultIdleWindows = 10
+
+// defaultMaxSeries is the series limit applied when MaxSeries is not configured
+const defaultMaxSeries = 10000
+
+// Retry settings for forwarding aggregated metrics
+const (
+	forwardMaxAttempts    = 3
+	defaultForwardBackoff = 200 * time.Millisecond
+)
+
+// ErrSeriesLimitReached is returned when a new aggregator would exceed MaxSeries
+var ErrSeriesLimitReached = errors.New("aggregator series limit reached")
+
 // AggregationRule defines a rule for aggregating metrics
 type AggregationRule struct {
 	// Metric is the name of the metric to aggregate
@@ -41,6 +80,95 @@ type AggregationRule struct {
 
 	// Buckets are the histogram buckets (only for histogram aggregation)
 	Buckets []float64 `json:"buckets,omitempty"`
+
+	// AutoBuckets derives the histogram buckets from the observed values
+	// instead (only for histogram aggregation, exclusive with Buckets)
+	AutoBuckets *AutoBucketsConfig `json:"autoBuckets,omitempty"`
+
+	// KeepLabels are extra labels to carry on the output metric in addition to
+	// the group-by labels. Use "*" to keep all labels.
+	KeepLabels []string `json:"keepLabels,omitempty"`
+
+	// DropLabels are labels removed from the output metric
+	DropLabels []string `json:"dropLabels,omitempty"`
+
+	// TimestampMode selects the timestamp stamped in the LabelTimestamp label
+	// of the output metric: the end of the aggregation window (window_end,
+	// the default), its start (window_start), or the latest timestamp of the
+	// aggregated metrics (latest)
+	TimestampMode string `json:"timestampMode,omitempty"`
+
+	// Temporality selects whether sum aggregates report the total of each
+	// window (delta, the default) or the running total of the series
+	// (cumulative). Only sum rules support cumulative. Cumulative totals
+	// survive idle eviction, but restart from zero on a config change.
+	Temporality string `json:"temporality,omitempty"`
+}
+
+// LabelTimestamp is the label carrying the timestamp of a metric, in RFC 3339
+// format, as telemetry.Metric has no field for it. AGG reads it from the
+// metrics it aggregates and stamps it on the aggregates it outputs.
+const LabelTimestamp = "timestamp"
+
+// metricTimestamp returns the timestamp in a metric's LabelTimestamp label, or
+// the zero time if it has none
+func metricTimestamp(metric *telemetry.Metric) time.Time {
+	timestamp, err := time.Parse(time.RFC3339Nano, metric.Labels[LabelTimestamp])
+	if err != nil {
+		return time.Time{}
+	}
+	return timestamp
+}
+
+// setMetricTimestamp stamps a timestamp in a metric's LabelTimestamp label
+func setMetricTimestamp(metric *telemetry.Metric, timestamp time.Time) {
+	if metric.Labels == nil {
+		metric.Labels = make(map[string]string)
+	}
+	metric.Labels[LabelTimestamp] = timestamp.UTC().Format(time.RFC3339Nano)
+}
+
+// Timestamp modes of aggregation rules
+const (
+	TimestampModeWindowEnd   = "window_end"
+	TimestampModeWindowStart = "window_start"
+	TimestampModeLatest      = "latest"
+)
+
+// Temporalities of aggregation rules
+const (
+	TemporalityDelta      = "delta"
+	TemporalityCumulative = "cumulative"
+)
+
+// aggregationWindow is the time span covered by an aggregator since its last
+// flush
+type aggregationWindow struct {
+	mode   string
+	start  time.Time
+	latest time.Time
+}
+
+// timestamp returns the timestamp of the aggregates of a window ending at end.
+// Windows of metrics without timestamps fall back to end in latest mode.
+func (w *aggregationWindow) timestamp(end time.Time) time.Time {
+	switch w.mode {
+	case TimestampModeWindowStart:
+		return w.start
+	case TimestampModeLatest:
+		if !w.latest.IsZero() {
+			return w.latest
+		}
+	}
+	return end
+}
+
+// labelFilter returns the filter for the labels carried on output metrics
+func (r AggregationRule) labelFilter() *LabelFilter {
+	keep := make([]string, 0, len(r.Labels)+len(r.KeepLabels))
+	keep = append(keep, r.Labels...)
+	keep = append(keep, r.KeepLabels...)
+	return NewLabelFilter(keep, r.DropLabels)
 }
 
 // AggregationFunctionBlock implements the function block for metric aggregation
@@ -48,8 +176,14 @@ type AggregationFunctionBlock struct {
 	fb.BaseFunctionBlock
 	config         Config
 	aggregators    map[string]Aggregator
+	lastSeen       map[string]time.Time
+	windows        map[string]*aggregationWindow
+	bucketLayouts  map[int]*autoBuckets
+	evictedTotals  map[string]float64
 	metricCh       chan *telemetry.Metric
 	forwarder      telemetry.Forwarder
+	dlqForwarder   telemetry.Forwarder
+	forwardBackoff time.Duration
 	shutdownCh     chan struct{}
 	wg             sync.WaitGroup
 	mu             sync.RWMutex
@@ -81,6 +215,31 @@ var (
 		Help: "The total number of aggregation errors",
 	})
 
+	seriesLimitHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_agg_series_limit_hits_total",
+		Help: "The total number of metrics rejected because the series limit was reached",
+	})
+
+	forwardFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_agg_forward_failures_total",
+		Help: "The total number of aggregated windows that failed to forward after retries",
+	})
+
+	dlqSends = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_agg_dlq_total",
+		Help: "The total number of metric batches sent to the DLQ",
+	})
+
+	histogramsRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_agg_histograms_rejected_total",
+		Help: "The total number of pre-aggregated histogram buckets rejected as inconsistent",
+	})
+
+	aggregatorsEvicted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_agg_aggregators_evicted_total",
+		Help: "The total number of idle aggregators evicted",
+	})
+
 	aggregationLatency = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "fb_agg_latency_seconds",
 		Help:    "Latency of metric aggregation operations",
@@ -100,18 +259,46 @@ type Aggregator interface {
 	Reset()
 }
 
+// rejectingAggregator is implemented by aggregators that reject inputs when
+// flushing. The rejected metrics are routed to the DLQ.
+type rejectingAggregator interface {
+	// TakeRejected returns and clears the metrics rejected by the last flush
+	TakeRejected() []*telemetry.Metric
+}
+
+// matchesRule reports whether a metric is aggregated by a rule. Histogram
+// rules also take the buckets of pre-aggregated histograms of the metric.
+func matchesRule(rule AggregationRule, metric *telemetry.Metric) bool {
+	if rule.Metric == metric.Name {
+		return true
+	}
+	return rule.Type == "histogram" && metric.Name == rule.Metric+"_bucket" && isHistogramBucket(metric)
+}
+
 // NewAggregationFunctionBlock creates a new aggregation function block
 func NewAggregationFunctionBlock(name string, forwarder telemetry.Forwarder, metricsFactory metrics.Factory) *AggregationFunctionBlock {
 	return &AggregationFunctionBlock{
 		BaseFunctionBlock: fb.NewBaseFunctionBlock(name),
 		aggregators:       make(map[string]Aggregator),
+		lastSeen:          make(map[string]time.Time),
+		windows:           make(map[string]*aggregationWindow),
+		bucketLayouts:     make(map[int]*autoBuckets),
+		evictedTotals:     make(map[string]float64),
 		shutdownCh:        make(chan struct{}),
 		forwarder:         forwarder,
 		flushTimers:       make(map[string]*time.Timer),
 		metricsFactory:    metricsFactory,
+		forwardBackoff:    defaultForwardBackoff,
 	}
 }
 
+// SetDLQForwarder sets the forwarder used to send metrics rejected by the series limit to the DLQ
+func (a *AggregationFunctionBlock) SetDLQForwarder(dlqForwarder telemetry.Forwarder) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dlqForwarder = dlqForwarder
+}
+
 // Initialize initializes the aggregation function block
 func (a *AggregationFunctionBlock) Initialize(ctx context.Context) error {
 	log.Info().Str("function_block", a.Name()).Msg("Initializing aggregation function block")
@@ -132,6 +319,10 @@ func (a *AggregationFunctionBlock) Initialize(ctx context.Context) error {
 	a.wg.Add(1)
 	go a.processMetrics()
 
+	// Start the idle aggregator sweeper
+	a.wg.Add(1)
+	go a.runIdleSweeper()
+
 	a.SetReady(true)
 	log.Info().Str("function_block", a.Name()).Msg("Aggregation function block initialized successfully")
 	return nil
@@ -147,12 +338,26 @@ func (a *AggregationFunctionBlock) ProcessBatch(ctx context.Context, batch *fb.M
 	// Increment processed batches counter
 	metricsBatchesProcessed.Inc()
 
+	// Send batches whose data was corrupted in transit to the DLQ
+	if result, err := a.VerifyChecksum(ctx, batch, a.sendBatchToDLQ); err != nil {
+		log.Warn().Err(err).Str("function_block", a.Name()).Str("batch_id", batch.BatchID).Msg("Batch failed checksum verification")
+		return result, err
+	}
+
 	// Deserialize metrics from batch
 	var metrics []*telemetry.Metric
 	if err := json.Unmarshal(batch.Data, &metrics); err != nil {
 		aggregationErrors.Inc()
 		log.Error().Err(err).Str("function_block", a.Name()).Str("batch_id", batch.BatchID).Msg("Failed to deserialize metrics")
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeInvalidInput, err, false), err
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeInvalidInput, err), err
+	}
+
+	// Bail out of cancelled requests before enqueuing anything. Once enqueued,
+	// metrics are aggregated, so a batch is enqueued whole or not at all and a
+	// retry of a cancelled batch doesn't count its metrics twice.
+	if err := ctx.Err(); err != nil {
+		log.Warn().Err(err).Str("function_block", a.Name()).Str("batch_id", batch.BatchID).Int("total", len(metrics)).Msg("Batch processing cancelled")
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeTimeout, err), err
 	}
 
 	// Send metrics to the processing channel
@@ -179,6 +384,12 @@ func (a *AggregationFunctionBlock) UpdateConfig(ctx context.Context, configBytes
 
 	log.Info().Str("function_block", a.Name()).Int64("generation", generation).Msg("Updating configuration")
 
+	// Refuse a generation older than the one applied, unless rolling back
+	if err := a.CheckConfigGeneration(generation, newConfig.Rollback); err != nil {
+		log.Error().Err(err).Str("function_block", a.Name()).Int64("generation", generation).Int64("applied_generation", a.GetConfigGeneration()).Msg("Refused configuration")
+		return err
+	}
+
 	// Validate configuration
 	if newConfig.WindowSeconds <= 0 {
 		return fmt.Errorf("%w: windowSeconds must be positive", fb.ErrConfigInvalid)
@@ -200,14 +411,75 @@ func (a *AggregationFunctionBlock) UpdateConfig(ctx context.Context, configBytes
 			return fmt.Errorf("%w: aggregation rule %d has invalid type: %s", fb.ErrConfigInvalid, i, rule.Type)
 		}
 
-		if rule.Type == "histogram" && (len(rule.Buckets) == 0) {
+		if rule.Type == "histogram" && (len(rule.Buckets) == 0) && rule.AutoBuckets == nil {
 			return fmt.Errorf("%w: histogram aggregation rule %d has no buckets", fb.ErrConfigInvalid, i)
 		}
+
+		if rule.AutoBuckets != nil {
+			if rule.Type != "histogram" {
+				return fmt.Errorf("%w: aggregation rule %d has autoBuckets, which only histogram aggregations support", fb.ErrConfigInvalid, i)
+			}
+			if len(rule.Buckets) > 0 {
+				return fmt.Errorf("%w: histogram aggregation rule %d has both buckets and autoBuckets", fb.ErrConfigInvalid, i)
+			}
+			if err := rule.AutoBuckets.validate(); err != nil {
+				return fmt.Errorf("%w: aggregation rule %d: %v", fb.ErrConfigInvalid, i, err)
+			}
+		}
+
+		switch rule.TimestampMode {
+		case "", TimestampModeWindowEnd, TimestampModeWindowStart, TimestampModeLatest:
+			// These are valid
+		default:
+			return fmt.Errorf("%w: aggregation rule %d has invalid timestamp mode: %s", fb.ErrConfigInvalid, i, rule.TimestampMode)
+		}
+
+		switch rule.Temporality {
+		case "", TemporalityDelta:
+			// These are valid
+		case TemporalityCumulative:
+			if rule.Type != "sum" {
+				return fmt.Errorf("%w: aggregation rule %d has cumulative temporality, which only sum aggregations support", fb.ErrConfigInvalid, i)
+			}
+			// A running total is only meaningful for a series whose labels
+			// don't change between windows, so its output labels must all
+			// be part of the aggregator key
+			if len(rule.KeepLabels) > 0 {
+				return fmt.Errorf("%w: aggregation rule %d has cumulative temporality, which doesn't support keepLabels", fb.ErrConfigInvalid, i)
+			}
+		default:
+			return fmt.Errorf("%w: aggregation rule %d has invalid temporality: %s", fb.ErrConfigInvalid, i, rule.Temporality)
+		}
+	}
+
+	if newConfig.MaxSeries < 0 {
+		return fmt.Errorf("%w: maxSeries must not be negative", fb.ErrConfigInvalid)
+	}
+
+	// An aggregator must stay around for at least one window so its data is flushed
+	if newConfig.IdleSeconds != 0 && newConfig.IdleSeconds < newConfig.WindowSeconds {
+		return fmt.Errorf("%w: idleSeconds must be at least windowSeconds", fb.ErrConfigInvalid)
+	}
+
+	// Connect to the DLQ if its address changed
+	var dlqForwarder telemetry.Forwarder
+	if newConfig.DLQ != "" && newConfig.DLQ != a.config.DLQ {
+		forwarder, err := telemetry.NewGRPCForwarder(newConfig.DLQ)
+		if err != nil {
+			// Don't fail the config update on connection error
+			log.Error().Err(err).Str("function_block", a.Name()).Str("dlq", newConfig.DLQ).Msg("Failed to create DLQ forwarder")
+		} else {
+			dlqForwarder = forwarder
+		}
 	}
 
 	// Update configuration and reset aggregators
+	a.SetVerifyChecksum(newConfig.VerifyChecksum)
 	a.mu.Lock()
 	a.config = newConfig
+	if dlqForwarder != nil {
+		a.dlqForwarder = dlqForwarder
+	}
 	a.mu.Unlock()
 
 	// Create new aggregators based on the new configuration
@@ -241,6 +513,11 @@ func (a *AggregationFunctionBlock) Shutdown(ctx context.Context) error {
 	case <-done:
 		// Goroutines finished
 	case <-ctx.Done():
+		// Don't drop the pending windows: with no time left to forward them
+		// with retries, send them straight to the DLQ
+		if err := a.flushAllToDLQ(); err != nil {
+			log.Error().Err(err).Str("function_block", a.Name()).Msg("Failed to send pending aggregates to DLQ after shutdown timeout")
+		}
 		return fb.ErrShutdownTimeout
 	}
 
@@ -277,13 +554,23 @@ func (a *AggregationFunctionBlock) processMetric(metric *telemetry.Metric) {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 
-	for _, rule := range a.config.Aggregations {
-		if rule.Metric == metric.Name {
+	for i, rule := range a.config.Aggregations {
+		if matchesRule(rule, metric) {
 			// Create a key for this metric + rule combination
 			key := a.createAggregatorKey(rule, metric)
 
 			// Get or create aggregator
-			agg, err := a.getOrCreateAggregator(key, rule)
+			agg, err := a.getOrCreateAggregator(key, i, rule)
+			if errors.Is(err, ErrSeriesLimitReached) {
+				seriesLimitHits.Inc()
+				log.Warn().Str("function_block", a.Name()).Str("metric", metric.Name).Str("key", key).Msg("Series limit reached, dropping metric")
+				if a.config.DLQOnSeriesLimit {
+					if err := a.sendToDLQ(a.dlqForwarder, []*telemetry.Metric{metric}); err != nil {
+						log.Error().Err(err).Str("function_block", a.Name()).Str("metric", metric.Name).Msg("Failed to send metric to DLQ")
+					}
+				}
+				continue
+			}
 			if err != nil {
 				log.Error().Err(err).Str("function_block", a.Name()).Str("metric", metric.Name).Msg("Failed to create aggregator")
 				aggregationErrors.Inc()
@@ -297,6 +584,9 @@ func (a *AggregationFunctionBlock) processMetric(metric *telemetry.Metric) {
 				continue
 			}
 
+			// Record activity so the aggregator isn't evicted as idle
+			a.touchAggregator(key, rule, metric)
+
 			// Increment the counter for this type of aggregation
 			metricsAggregated.WithLabelValues(rule.Type).Inc()
 
@@ -308,7 +598,7 @@ func (a *AggregationFunctionBlock) processMetric(metric *telemetry.Metric) {
 
 // createAggregatorKey creates a unique key for an aggregator based on the rule and metric labels
 func (a *AggregationFunctionBlock) createAggregatorKey(rule AggregationRule, metric *telemetry.Metric) string {
-	key := fmt.Sprintf("%s:%s", metric.Name, rule.Type)
+	key := fmt.Sprintf("%s:%s", rule.Metric, rule.Type)
 
 	// Add relevant labels to the key
 	for _, labelName := range rule.Labels {
@@ -320,8 +610,9 @@ func (a *AggregationFunctionBlock) createAggregatorKey(rule AggregationRule, met
 	return key
 }
 
-// getOrCreateAggregator gets an existing aggregator or creates a new one
-func (a *AggregationFunctionBlock) getOrCreateAggregator(key string, rule AggregationRule) (Aggregator, error) {
+// getOrCreateAggregator gets an existing aggregator or creates a new one for
+// the rule at ruleIndex in the config
+func (a *AggregationFunctionBlock) getOrCreateAggregator(key string, ruleIndex int, rule AggregationRule) (Aggregator, error) {
 	a.aggregatorsMu.RLock()
 	agg, ok := a.aggregators[key]
 	a.aggregatorsMu.RUnlock()
@@ -331,17 +622,27 @@ func (a *AggregationFunctionBlock) getOrCreateAggregator(key string, rule Aggreg
 		var newAgg Aggregator
 		var err error
 
+		filter := rule.labelFilter()
+
 		switch rule.Type {
 		case "sum":
-			newAgg = NewSumAggregator()
+			if rule.Temporality == TemporalityCumulative {
+				newAgg = NewCumulativeSumAggregator(filter)
+			} else {
+				newAgg = NewSumAggregator(filter)
+			}
 		case "avg":
-			newAgg = NewAvgAggregator()
+			newAgg = NewAvgAggregator(filter)
 		case "min":
-			newAgg = NewMinAggregator()
+			newAgg = NewMinAggregator(filter)
 		case "max":
-			newAgg = NewMaxAggregator()
+			newAgg = NewMaxAggregator(filter)
 		case "histogram":
-			newAgg, err = NewHistogramAggregator(rule.Buckets)
+			if rule.AutoBuckets != nil {
+				newAgg = newAutoHistogramAggregator(a.bucketLayout(ruleIndex, rule), filter)
+				break
+			}
+			newAgg, err = NewHistogramAggregator(rule.Buckets, filter)
 			if err != nil {
 				return nil, err
 			}
@@ -350,8 +651,27 @@ func (a *AggregationFunctionBlock) getOrCreateAggregator(key string, rule Aggreg
 		}
 
 		a.aggregatorsMu.Lock()
+		defer a.aggregatorsMu.Unlock()
+
+		// Another goroutine may have created it while we were unlocked
+		if existing, ok := a.aggregators[key]; ok {
+			return existing, nil
+		}
+
+		// Enforce the series limit to bound memory use
+		if len(a.aggregators) >= a.maxSeries() {
+			return nil, ErrSeriesLimitReached
+		}
+
+		// Continue the running total of a cumulative series evicted as idle
+		if total, ok := a.evictedTotals[key]; ok {
+			if sum, ok := newAgg.(*SumAggregator); ok && sum.cumulative {
+				sum.resume(total)
+			}
+			delete(a.evictedTotals, key)
+		}
+
 		a.aggregators[key] = newAgg
-		a.aggregatorsMu.Unlock()
 
 		return newAgg, nil
 	}
@@ -359,6 +679,141 @@ func (a *AggregationFunctionBlock) getOrCreateAggregator(key string, rule Aggreg
 	return agg, nil
 }
 
+// bucketLayout returns the auto buckets shared by the aggregators of the
+// rule at ruleIndex in the config, so that buckets are derived once per rule
+// rather than per group key
+func (a *AggregationFunctionBlock) bucketLayout(ruleIndex int, rule AggregationRule) *autoBuckets {
+	a.aggregatorsMu.Lock()
+	defer a.aggregatorsMu.Unlock()
+
+	layout, ok := a.bucketLayouts[ruleIndex]
+	if !ok {
+		layout = newAutoBuckets(*rule.AutoBuckets)
+		a.bucketLayouts[ruleIndex] = layout
+	}
+	return layout
+}
+
+// maxSeries returns the configured series limit or the default
+func (a *AggregationFunctionBlock) maxSeries() int {
+	if a.config.MaxSeries > 0 {
+		return a.config.MaxSeries
+	}
+	return defaultMaxSeries
+}
+
+// touchAggregator records that an aggregator received a metric, opening its
+// window if this is the first metric since the aggregator was created
+func (a *AggregationFunctionBlock) touchAggregator(key string, rule AggregationRule, metric *telemetry.Metric) {
+	now := time.Now()
+
+	a.aggregatorsMu.Lock()
+	defer a.aggregatorsMu.Unlock()
+
+	a.lastSeen[key] = now
+
+	window, ok := a.windows[key]
+	if !ok {
+		window = &aggregationWindow{start: now}
+		a.windows[key] = window
+	}
+	window.mode = rule.TimestampMode
+	if timestamp := metricTimestamp(metric); timestamp.After(window.latest) {
+		window.latest = timestamp
+	}
+}
+
+// endWindow closes an aggregator's window at end, returning the timestamp of
+// its aggregates, and opens the next window
+func (a *AggregationFunctionBlock) endWindow(key string, end time.Time) time.Time {
+	a.aggregatorsMu.Lock()
+	defer a.aggregatorsMu.Unlock()
+
+	window, ok := a.windows[key]
+	if !ok {
+		return end
+	}
+	a.windows[key] = &aggregationWindow{mode: window.mode, start: end}
+	return window.timestamp(end)
+}
+
+// idleTimeout returns the configured idle duration or the default
+func (a *AggregationFunctionBlock) idleTimeout() time.Duration {
+	if a.config.IdleSeconds > 0 {
+		return time.Duration(a.config.IdleSeconds) * time.Second
+	}
+	return time.Duration(a.config.WindowSeconds*defaultIdleWindows) * time.Second
+}
+
+// runIdleSweeper periodically evicts idle aggregators
+func (a *AggregationFunctionBlock) runIdleSweeper() {
+	defer a.wg.Done()
+
+	a.mu.RLock()
+	interval := time.Duration(a.config.WindowSeconds) * time.Second
+	a.mu.RUnlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.shutdownCh:
+			return
+		case now := <-ticker.C:
+			a.evictIdleAggregators(now)
+		}
+	}
+}
+
+// evictIdleAggregators removes aggregators that have received no metrics for
+// longer than the idle timeout and stops their flush timers. The running
+// totals of cumulative aggregators are kept, up to the series limit, so the
+// series continues if it becomes active again. It returns the number of
+// aggregators evicted.
+func (a *AggregationFunctionBlock) evictIdleAggregators(now time.Time) int {
+	a.mu.RLock()
+	idleTimeout := a.idleTimeout()
+	maxSeries := a.maxSeries()
+	a.mu.RUnlock()
+
+	a.aggregatorsMu.Lock()
+	defer a.aggregatorsMu.Unlock()
+
+	var evicted []string
+	for key, agg := range a.aggregators {
+		if now.Sub(a.lastSeen[key]) > idleTimeout {
+			if sum, ok := agg.(*SumAggregator); ok && sum.cumulative && len(a.evictedTotals) < maxSeries {
+				a.evictedTotals[key] = sum.runningTotal()
+			}
+			delete(a.aggregators, key)
+			delete(a.lastSeen, key)
+			delete(a.windows, key)
+			evicted = append(evicted, key)
+		}
+	}
+
+	if len(evicted) == 0 {
+		return 0
+	}
+
+	// Stop the flush timers while still holding the aggregators lock so a new
+	// aggregator for the same key can't pick up a stale timer
+	a.flushTimersMu.Lock()
+	for _, key := range evicted {
+		if timer, ok := a.flushTimers[key]; ok {
+			timer.Stop()
+			delete(a.flushTimers, key)
+		}
+	}
+	a.flushTimersMu.Unlock()
+
+	aggregatorsEvicted.Add(float64(len(evicted)))
+	log.Debug().Str("function_block", a.Name()).Int("count", len(evicted)).Msg("Evicted idle aggregators")
+
+	return len(evicted)
+}
+
 // ensureFlushTimer ensures there's a flush timer for an aggregator
 func (a *AggregationFunctionBlock) ensureFlushTimer(key string, aggType string) {
 	a.flushTimersMu.Lock()
@@ -392,15 +847,45 @@ func (a *AggregationFunctionBlock) flushAggregator(key string, aggType string) e
 		aggregationErrors.Inc()
 		return err
 	}
+	timestamp := a.endWindow(key, time.Now())
+	for _, metric := range metrics {
+		setMetricTimestamp(metric, timestamp)
+	}
+
+	// Route inputs the aggregator rejected to the DLQ
+	if rejecting, ok := agg.(rejectingAggregator); ok {
+		if rejected := rejecting.TakeRejected(); len(rejected) > 0 {
+			histogramsRejected.Add(float64(len(rejected)))
+			log.Warn().Str("function_block", a.Name()).Str("key", key).Int("metrics", len(rejected)).Msg("Rejected inconsistent histograms, sending to DLQ")
+
+			a.mu.RLock()
+			dlqForwarder := a.dlqForwarder
+			a.mu.RUnlock()
+
+			if err := a.sendToDLQ(dlqForwarder, rejected); err != nil {
+				log.Error().Err(err).Str("function_block", a.Name()).Str("key", key).Msg("Failed to send rejected histograms to DLQ")
+			}
+		}
+	}
 
 	// Reset the aggregator
 	agg.Reset()
 
-	// Forward the metrics
+	// Forward the metrics, falling back to the DLQ so the window isn't lost
+	var flushErr error
 	if len(metrics) > 0 {
-		if err := a.forwarder.Forward(metrics); err != nil {
-			log.Error().Err(err).Str("function_block", a.Name()).Str("key", key).Msg("Failed to forward aggregated metrics")
-			return err
+		if err := a.forwardWithRetry(metrics); err != nil {
+			forwardFailures.Inc()
+			log.Error().Err(err).Str("function_block", a.Name()).Str("key", key).Msg("Failed to forward aggregated metrics, sending to DLQ")
+
+			a.mu.RLock()
+			dlqForwarder := a.dlqForwarder
+			a.mu.RUnlock()
+
+			if dlqErr := a.sendToDLQ(dlqForwarder, metrics); dlqErr != nil {
+				log.Error().Err(dlqErr).Str("function_block", a.Name()).Str("key", key).Msg("Failed to send aggregated metrics to DLQ")
+				flushErr = fmt.Errorf("failed to send aggregated metrics to DLQ: %w", dlqErr)
+			}
 		}
 	}
 
@@ -421,9 +906,56 @@ func (a *AggregationFunctionBlock) flushAggregator(key string, aggType string) e
 	}
 	a.flushTimersMu.Unlock()
 
+	return flushErr
+}
+
+// forwardWithRetry forwards metrics, retrying with exponential backoff
+func (a *AggregationFunctionBlock) forwardWithRetry(metrics []*telemetry.Metric) error {
+	backoff := a.forwardBackoff
+	var err error
+	for attempt := 1; attempt <= forwardMaxAttempts; attempt++ {
+		if err = a.forwarder.Forward(metrics); err == nil {
+			return nil
+		}
+
+		if attempt < forwardMaxAttempts {
+			log.Warn().Err(err).Str("function_block", a.Name()).Int("attempt", attempt).Msg("Forward failed, retrying")
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// sendToDLQ sends metrics to the DLQ
+func (a *AggregationFunctionBlock) sendToDLQ(dlqForwarder telemetry.Forwarder, metrics []*telemetry.Metric) error {
+	if dlqForwarder == nil {
+		return fmt.Errorf("DLQ not configured")
+	}
+
+	if err := dlqForwarder.Forward(metrics); err != nil {
+		return err
+	}
+
+	dlqSends.Inc()
 	return nil
 }
 
+// sendBatchToDLQ sends the metrics of a batch that failed verification to the
+// DLQ. Batches whose data no longer decodes can't be sent.
+func (a *AggregationFunctionBlock) sendBatchToDLQ(ctx context.Context, batch *fb.MetricBatch, err error) error {
+	var metrics []*telemetry.Metric
+	if decodeErr := json.Unmarshal(batch.Data, &metrics); decodeErr != nil {
+		return fmt.Errorf("failed to decode batch for the DLQ: %w", decodeErr)
+	}
+
+	a.mu.RLock()
+	dlqForwarder := a.dlqForwarder
+	a.mu.RUnlock()
+
+	return a.sendToDLQ(dlqForwarder, metrics)
+}
+
 // flushAllAggregators flushes all aggregators
 func (a *AggregationFunctionBlock) flushAllAggregators() error {
 	a.aggregatorsMu.RLock()
@@ -433,6 +965,10 @@ func (a *AggregationFunctionBlock) flushAllAggregators() error {
 	}
 	a.aggregatorsMu.RUnlock()
 
+	// Flush in key order, so aggregated output is forwarded in the same
+	// order on every run
+	sort.Strings(keys)
+
 	var firstErr error
 	for _, key := range keys {
 		// Extract the type from the key (format: "metric:type:labels")
@@ -451,7 +987,69 @@ func (a *AggregationFunctionBlock) flushAllAggregators() error {
 	return firstErr
 }
 
-// resetAggregators removes all existing aggregators and flush timers
+// flushAllToDLQ stops the flush timers and sends the pending aggregates of
+// all aggregators, and any inputs they reject, to the DLQ in one batch. It is
+// the best-effort flush of a shutdown that timed out.
+func (a *AggregationFunctionBlock) flushAllToDLQ() error {
+	a.flushTimersMu.Lock()
+	for key, timer := range a.flushTimers {
+		timer.Stop()
+		delete(a.flushTimers, key)
+	}
+	a.flushTimersMu.Unlock()
+
+	a.aggregatorsMu.RLock()
+	aggs := make(map[string]Aggregator, len(a.aggregators))
+	keys := make([]string, 0, len(a.aggregators))
+	for key, agg := range a.aggregators {
+		aggs[key] = agg
+		keys = append(keys, key)
+	}
+	a.aggregatorsMu.RUnlock()
+	sort.Strings(keys)
+
+	now := time.Now()
+	var pending []*telemetry.Metric
+	var firstErr error
+	for _, key := range keys {
+		agg := aggs[key]
+		metrics, err := agg.Flush()
+		if err != nil {
+			aggregationErrors.Inc()
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		timestamp := a.endWindow(key, now)
+		for _, metric := range metrics {
+			setMetricTimestamp(metric, timestamp)
+		}
+		if rejecting, ok := agg.(rejectingAggregator); ok {
+			metrics = append(metrics, rejecting.TakeRejected()...)
+		}
+		agg.Reset()
+		pending = append(pending, metrics...)
+	}
+
+	if len(pending) == 0 {
+		return firstErr
+	}
+
+	a.mu.RLock()
+	dlqForwarder := a.dlqForwarder
+	a.mu.RUnlock()
+
+	if err := a.sendToDLQ(dlqForwarder, pending); err != nil {
+		return fmt.Errorf("failed to send %d pending aggregated metrics to DLQ: %w", len(pending), err)
+	}
+
+	log.Warn().Str("function_block", a.Name()).Int("metrics", len(pending)).Msg("Sent pending aggregates to DLQ after shutdown timeout")
+	return firstErr
+}
+
+// resetAggregators removes all existing aggregators, flush timers and auto
+// bucket layouts
 func (a *AggregationFunctionBlock) resetAggregators() {
 	// Cancel all existing flush timers
 	a.flushTimersMu.Lock()
@@ -464,6 +1062,10 @@ func (a *AggregationFunctionBlock) resetAggregators() {
 	// Clear all aggregators
 	a.aggregatorsMu.Lock()
 	a.aggregators = make(map[string]Aggregator)
+	a.lastSeen = make(map[string]time.Time)
+	a.windows = make(map[string]*aggregationWindow)
+	a.bucketLayouts = make(map[int]*autoBuckets)
+	a.evictedTotals = make(map[string]float64)
 	a.aggregatorsMu.Unlock()
 }
 
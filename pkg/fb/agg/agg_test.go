@@ -0,0 +1,883 @@
+package agg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"eidc-tfk8s/pkg/fb"
+	"github.com/newrelic/nrdot-internal-devlab/pkg/telemetry"
+)
+
+// mockForwarder records the metrics it is asked to forward
+type mockForwarder struct {
+	mu      sync.Mutex
+	metrics []*telemetry.Metric
+}
+
+func (f *mockForwarder) Forward(metrics []*telemetry.Metric) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.metrics = append(f.metrics, metrics...)
+	return nil
+}
+
+func (f *mockForwarder) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.metrics)
+}
+
+// newTestAggregationFunctionBlock creates a function block with the given config
+// without starting the processing goroutine
+func newTestAggregationFunctionBlock(config Config) (*AggregationFunctionBlock, *mockForwarder) {
+	forwarder := &mockForwarder{}
+	a := NewAggregationFunctionBlock("fb-agg-test", forwarder, nil)
+	a.config = config
+	return a, forwarder
+}
+
+func TestAggregation_MaxSeriesCapsAggregators(t *testing.T) {
+	a, _ := newTestAggregationFunctionBlock(Config{
+		WindowSeconds: 60,
+		MaxSeries:     3,
+		Aggregations: []AggregationRule{
+			{Metric: "http_requests", Type: "sum", Labels: []string{"path"}},
+		},
+	})
+	defer a.resetAggregators()
+
+	hitsBefore := testutil.ToFloat64(seriesLimitHits)
+
+	for i := 0; i < 5; i++ {
+		a.processMetric(&telemetry.Metric{
+			Name:   "http_requests",
+			Value:  1,
+			Labels: map[string]string{"path": fmt.Sprintf("/path/%d", i)},
+		})
+	}
+
+	a.aggregatorsMu.RLock()
+	assert.Len(t, a.aggregators, 3)
+	a.aggregatorsMu.RUnlock()
+	assert.Equal(t, float64(2), testutil.ToFloat64(seriesLimitHits)-hitsBefore)
+
+	// Existing series keep aggregating once the limit is reached
+	a.processMetric(&telemetry.Metric{
+		Name:   "http_requests",
+		Value:  1,
+		Labels: map[string]string{"path": "/path/0"},
+	})
+	assert.Equal(t, float64(2), testutil.ToFloat64(seriesLimitHits)-hitsBefore)
+}
+
+func TestAggregation_MaxSeriesRoutesToDLQ(t *testing.T) {
+	a, _ := newTestAggregationFunctionBlock(Config{
+		WindowSeconds:    60,
+		MaxSeries:        1,
+		DLQOnSeriesLimit: true,
+		Aggregations: []AggregationRule{
+			{Metric: "http_requests", Type: "sum", Labels: []string{"path"}},
+		},
+	})
+	defer a.resetAggregators()
+
+	dlq := &mockForwarder{}
+	a.SetDLQForwarder(dlq)
+
+	for i := 0; i < 3; i++ {
+		a.processMetric(&telemetry.Metric{
+			Name:   "http_requests",
+			Value:  1,
+			Labels: map[string]string{"path": fmt.Sprintf("/path/%d", i)},
+		})
+	}
+
+	assert.Equal(t, 2, dlq.count())
+}
+
+func TestAggregation_EvictsIdleAggregators(t *testing.T) {
+	a, _ := newTestAggregationFunctionBlock(Config{
+		WindowSeconds: 60,
+		IdleSeconds:   120,
+		Aggregations: []AggregationRule{
+			{Metric: "http_requests", Type: "sum", Labels: []string{"path"}},
+		},
+	})
+	defer a.resetAggregators()
+
+	for _, path := range []string{"/idle", "/active"} {
+		a.processMetric(&telemetry.Metric{
+			Name:   "http_requests",
+			Value:  1,
+			Labels: map[string]string{"path": path},
+		})
+	}
+
+	idleKey := "http_requests:sum:path=/idle"
+	activeKey := "http_requests:sum:path=/active"
+
+	a.flushTimersMu.Lock()
+	idleTimer := a.flushTimers[idleKey]
+	a.flushTimersMu.Unlock()
+	assert.NotNil(t, idleTimer)
+
+	// Make one aggregator look idle for longer than the idle timeout
+	a.aggregatorsMu.Lock()
+	a.lastSeen[idleKey] = time.Now().Add(-5 * time.Minute)
+	a.aggregatorsMu.Unlock()
+
+	evicted := a.evictIdleAggregators(time.Now())
+	assert.Equal(t, 1, evicted)
+
+	a.aggregatorsMu.RLock()
+	_, idleExists := a.aggregators[idleKey]
+	_, activeExists := a.aggregators[activeKey]
+	a.aggregatorsMu.RUnlock()
+	assert.False(t, idleExists)
+	assert.True(t, activeExists)
+
+	a.flushTimersMu.Lock()
+	_, idleTimerExists := a.flushTimers[idleKey]
+	_, activeTimerExists := a.flushTimers[activeKey]
+	a.flushTimersMu.Unlock()
+	assert.False(t, idleTimerExists)
+	assert.True(t, activeTimerExists)
+
+	// Stop reports false when the timer was already stopped
+	assert.False(t, idleTimer.Stop())
+}
+
+// withoutTimestamp returns the labels of an aggregate other than its timestamp
+func withoutTimestamp(labels map[string]string) map[string]string {
+	filtered := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if k != LabelTimestamp {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+func TestAggregation_OutputLabelFiltering(t *testing.T) {
+	a, forwarder := newTestAggregationFunctionBlock(Config{
+		WindowSeconds: 60,
+		Aggregations: []AggregationRule{
+			{Metric: "http_requests", Type: "sum", Labels: []string{"path", "method"}, KeepLabels: []string{"region"}, DropLabels: []string{"method"}},
+			{Metric: "http_latency", Type: "histogram", Labels: []string{"path"}, Buckets: []float64{0.1, 1}},
+		},
+	})
+	defer a.resetAggregators()
+
+	labels := map[string]string{
+		"path":       "/api",
+		"method":     "GET",
+		"region":     "us-east",
+		"request_id": "abc123",
+	}
+	a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 1, Labels: labels})
+	a.processMetric(&telemetry.Metric{Name: "http_latency", Value: 0.5, Labels: labels})
+
+	assert.NoError(t, a.flushAllAggregators())
+	assert.Equal(t, 4, forwarder.count())
+
+	for _, metric := range forwarder.metrics {
+		assert.NotContains(t, metric.Labels, "request_id")
+		assert.NotContains(t, metric.Labels, "method")
+		assert.Equal(t, "/api", metric.Labels["path"])
+
+		switch metric.Name {
+		case "http_requests":
+			assert.Equal(t, map[string]string{"path": "/api", "region": "us-east"}, withoutTimestamp(metric.Labels))
+		case "http_latency_bucket":
+			assert.NotContains(t, metric.Labels, "region")
+			assert.Contains(t, metric.Labels, "le")
+		}
+	}
+}
+
+func TestAggregation_TimestampModes(t *testing.T) {
+	first := time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC)
+	latest := time.Date(2024, 1, 1, 12, 0, 40, 0, time.UTC)
+
+	for _, mode := range []string{"", TimestampModeWindowEnd, TimestampModeWindowStart, TimestampModeLatest} {
+		t.Run(mode, func(t *testing.T) {
+			a, forwarder := newTestAggregationFunctionBlock(Config{
+				WindowSeconds: 60,
+				Aggregations: []AggregationRule{
+					{Metric: "http_requests", Type: "sum", TimestampMode: mode},
+				},
+			})
+			defer a.resetAggregators()
+
+			windowStart := time.Now()
+			for _, timestamp := range []time.Time{latest, first} {
+				metric := &telemetry.Metric{Name: "http_requests", Value: 1}
+				setMetricTimestamp(metric, timestamp)
+				a.processMetric(metric)
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			windowEnd := time.Now()
+			assert.NoError(t, a.flushAggregator("http_requests:sum", "sum"))
+			assert.Equal(t, 1, forwarder.count())
+			timestamp := metricTimestamp(forwarder.metrics[0])
+
+			switch mode {
+			case TimestampModeLatest:
+				assert.Equal(t, latest, timestamp)
+			case TimestampModeWindowStart:
+				assert.False(t, timestamp.Before(windowStart))
+				assert.True(t, timestamp.Before(windowEnd))
+			default:
+				assert.False(t, timestamp.Before(windowEnd))
+			}
+		})
+	}
+}
+
+func TestAggregation_InvalidTimestampMode(t *testing.T) {
+	a, _ := newTestAggregationFunctionBlock(Config{})
+	configBytes, err := json.Marshal(Config{
+		WindowSeconds: 60,
+		Aggregations: []AggregationRule{
+			{Metric: "http_requests", Type: "sum", TimestampMode: "window_middle"},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = a.UpdateConfig(context.Background(), configBytes, 1)
+	assert.ErrorIs(t, err, fb.ErrConfigInvalid)
+}
+
+func TestAggregation_Temporality(t *testing.T) {
+	tests := []struct {
+		temporality string
+		want        []float64
+	}{
+		{"", []float64{3, 4}},
+		{TemporalityDelta, []float64{3, 4}},
+		{TemporalityCumulative, []float64{3, 7, 7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.temporality, func(t *testing.T) {
+			a, forwarder := newTestAggregationFunctionBlock(Config{
+				WindowSeconds: 60,
+				Aggregations: []AggregationRule{
+					{Metric: "http_requests", Type: "sum", Labels: []string{"service"}, Temporality: tt.temporality},
+				},
+			})
+			defer a.resetAggregators()
+			key := "http_requests:sum:service=api"
+
+			// First window
+			a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 1, Labels: map[string]string{"service": "api"}})
+			a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 2, Labels: map[string]string{"service": "api"}})
+			assert.NoError(t, a.flushAggregator(key, "sum"))
+
+			// Second window
+			a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 4, Labels: map[string]string{"service": "api"}})
+			assert.NoError(t, a.flushAggregator(key, "sum"))
+
+			// Third window, without metrics: only the running total is reported
+			assert.NoError(t, a.flushAggregator(key, "sum"))
+
+			var values []float64
+			for _, metric := range forwarder.metrics {
+				assert.Equal(t, map[string]string{"service": "api"}, withoutTimestamp(metric.Labels))
+				values = append(values, metric.Value)
+			}
+			assert.Equal(t, tt.want, values)
+		})
+	}
+}
+
+func TestAggregation_CumulativeTotalSurvivesEviction(t *testing.T) {
+	a, forwarder := newTestAggregationFunctionBlock(Config{
+		WindowSeconds: 60,
+		IdleSeconds:   120,
+		Aggregations: []AggregationRule{
+			{Metric: "http_requests", Type: "sum", Labels: []string{"service"}, Temporality: TemporalityCumulative},
+		},
+	})
+	defer a.resetAggregators()
+	key := "http_requests:sum:service=api"
+
+	a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 3, Labels: map[string]string{"service": "api"}})
+	assert.NoError(t, a.flushAggregator(key, "sum"))
+
+	// The series goes idle and its aggregator is evicted
+	a.aggregatorsMu.Lock()
+	a.lastSeen[key] = time.Now().Add(-5 * time.Minute)
+	a.aggregatorsMu.Unlock()
+	assert.Equal(t, 1, a.evictIdleAggregators(time.Now()))
+
+	// Once active again, its total continues from where it was
+	a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 4, Labels: map[string]string{"service": "api"}})
+	assert.NoError(t, a.flushAggregator(key, "sum"))
+
+	var values []float64
+	for _, metric := range forwarder.metrics {
+		values = append(values, metric.Value)
+	}
+	assert.Equal(t, []float64{3, 7}, values)
+}
+
+func TestAggregation_InvalidTemporality(t *testing.T) {
+	tests := map[string]AggregationRule{
+		"unknown":            {Metric: "http_requests", Type: "sum", Temporality: "monotonic"},
+		"cumulative avg":     {Metric: "http_requests", Type: "avg", Temporality: TemporalityCumulative},
+		"cumulative unkeyed": {Metric: "http_requests", Type: "sum", KeepLabels: []string{"pod"}, Temporality: TemporalityCumulative},
+	}
+
+	for name, rule := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, _ := newTestAggregationFunctionBlock(Config{})
+			configBytes, err := json.Marshal(Config{WindowSeconds: 60, Aggregations: []AggregationRule{rule}})
+			assert.NoError(t, err)
+
+			err = a.UpdateConfig(context.Background(), configBytes, 1)
+			assert.ErrorIs(t, err, fb.ErrConfigInvalid)
+		})
+	}
+}
+
+func TestLabelFilter_Apply(t *testing.T) {
+	labels := map[string]string{"a": "1", "b": "2", "c": "3"}
+
+	assert.Equal(t, map[string]string{"a": "1"}, NewLabelFilter([]string{"a"}, nil).Apply(labels))
+	assert.Equal(t, map[string]string{"a": "1", "c": "3"}, NewLabelFilter([]string{"*"}, []string{"b"}).Apply(labels))
+
+	// A nil filter keeps everything
+	var filter *LabelFilter
+	assert.Equal(t, labels, filter.Apply(labels))
+}
+
+// failingForwarder always fails to forward
+type failingForwarder struct {
+	mu       sync.Mutex
+	attempts int
+}
+
+func (f *failingForwarder) Forward(metrics []*telemetry.Metric) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts++
+	return errors.New("next FB unavailable")
+}
+
+func TestAggregation_FlushFailureLandsInDLQ(t *testing.T) {
+	forwarder := &failingForwarder{}
+	a := NewAggregationFunctionBlock("fb-agg-test", forwarder, nil)
+	a.config = Config{
+		WindowSeconds: 60,
+		Aggregations: []AggregationRule{
+			{Metric: "http_requests", Type: "sum"},
+		},
+	}
+	a.forwardBackoff = time.Millisecond
+	defer a.resetAggregators()
+
+	dlq := &mockForwarder{}
+	a.SetDLQForwarder(dlq)
+
+	failuresBefore := testutil.ToFloat64(forwardFailures)
+	dlqBefore := testutil.ToFloat64(dlqSends)
+
+	a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 2})
+	a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 3})
+
+	err := a.flushAggregator("http_requests:sum", "sum")
+	assert.NoError(t, err)
+
+	// Forward was retried before giving up
+	assert.Equal(t, forwardMaxAttempts, forwarder.attempts)
+	assert.Equal(t, float64(1), testutil.ToFloat64(forwardFailures)-failuresBefore)
+
+	// The aggregated window landed in the DLQ
+	assert.Equal(t, float64(1), testutil.ToFloat64(dlqSends)-dlqBefore)
+	assert.Equal(t, 1, dlq.count())
+	assert.Equal(t, 5.0, dlq.metrics[0].Value)
+}
+
+func TestAggregation_FlushFailureWithoutDLQ(t *testing.T) {
+	a := NewAggregationFunctionBlock("fb-agg-test", &failingForwarder{}, nil)
+	a.config = Config{
+		WindowSeconds: 60,
+		Aggregations: []AggregationRule{
+			{Metric: "http_requests", Type: "sum"},
+		},
+	}
+	a.forwardBackoff = time.Millisecond
+	defer a.resetAggregators()
+
+	a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 1})
+
+	err := a.flushAggregator("http_requests:sum", "sum")
+	assert.Error(t, err)
+}
+
+func TestAggregation_ShutdownTimeoutSendsPendingAggregatesToDLQ(t *testing.T) {
+	a, forwarder := newTestAggregationFunctionBlock(Config{
+		WindowSeconds: 60,
+		Aggregations: []AggregationRule{
+			{Metric: "http_requests", Type: "sum", Labels: []string{"path"}},
+		},
+	})
+	defer a.resetAggregators()
+
+	dlq := &mockForwarder{}
+	a.SetDLQForwarder(dlq)
+
+	a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 2, Labels: map[string]string{"path": "/a"}})
+	a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 3, Labels: map[string]string{"path": "/a"}})
+	a.processMetric(&telemetry.Metric{Name: "http_requests", Value: 4, Labels: map[string]string{"path": "/b"}})
+
+	// A goroutine that never finishes forces the shutdown to time out
+	a.wg.Add(1)
+	defer a.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := a.Shutdown(ctx)
+	assert.ErrorIs(t, err, fb.ErrShutdownTimeout)
+
+	// The pending windows landed in the DLQ instead of being dropped
+	assert.Equal(t, 0, forwarder.count())
+	assert.Equal(t, 2, dlq.count())
+	values := make(map[string]float64)
+	for _, metric := range dlq.metrics {
+		values[metric.Labels["path"]] = metric.Value
+	}
+	assert.Equal(t, map[string]float64{"/a": 5, "/b": 4}, values)
+
+	a.flushTimersMu.Lock()
+	assert.Empty(t, a.flushTimers)
+	a.flushTimersMu.Unlock()
+}
+
+func TestAggregation_CancelledContextStopsEnqueuing(t *testing.T) {
+	a, _ := newTestAggregationFunctionBlock(Config{WindowSeconds: 60})
+	a.metricCh = make(chan *telemetry.Metric, 10000)
+
+	metrics := make([]*telemetry.Metric, 10000)
+	for i := range metrics {
+		metrics[i] = &telemetry.Metric{Name: "http_requests", Value: float64(i)}
+	}
+	data, err := json.Marshal(metrics)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := a.ProcessBatch(ctx, &fb.MetricBatch{BatchID: "batch-1", Data: data})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, fb.ErrorCodeTimeout, result.ErrorCode)
+	assert.Empty(t, a.metricCh)
+}
+
+// cancelAfterContext reports itself cancelled once its Err method has been
+// called more than n times
+type cancelAfterContext struct {
+	context.Context
+	calls, n int
+}
+
+func (c *cancelAfterContext) Err() error {
+	c.calls++
+	if c.calls > c.n {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestAggregation_CancellationDoesNotEnqueuePartialBatches(t *testing.T) {
+	a, _ := newTestAggregationFunctionBlock(Config{WindowSeconds: 60})
+	a.metricCh = make(chan *telemetry.Metric, 10000)
+
+	metrics := make([]*telemetry.Metric, 10000)
+	for i := range metrics {
+		metrics[i] = &telemetry.Metric{Name: "http_requests", Value: float64(i)}
+	}
+	data, err := json.Marshal(metrics)
+	assert.NoError(t, err)
+
+	// A request cancelled once enqueuing has started still enqueues the whole
+	// batch, so it is never retried after a partial enqueue
+	ctx := &cancelAfterContext{Context: context.Background(), n: 1}
+	result, err := a.ProcessBatch(ctx, &fb.MetricBatch{BatchID: "batch-1", Data: data})
+	assert.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+	assert.Len(t, a.metricCh, 10000)
+}
+
+func TestAggregation_ChecksumMismatchGoesToDLQ(t *testing.T) {
+	a, _ := newTestAggregationFunctionBlock(Config{WindowSeconds: 60})
+	a.metricCh = make(chan *telemetry.Metric, 10)
+	a.SetVerifyChecksum(true)
+	dlq := &mockForwarder{}
+	a.SetDLQForwarder(dlq)
+
+	// A batch checksummed by FB-RX that arrives intact is aggregated
+	intact := &fb.MetricBatch{BatchID: "batch-0", Data: []byte(`[{"name":"http_requests","value":1}]`)}
+	fb.StampChecksum(intact)
+	result, err := a.ProcessBatch(context.Background(), intact)
+	assert.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+	assert.Len(t, a.metricCh, 1)
+	<-a.metricCh
+
+	// FB-RX checksums the data, which is then corrupted on its way to AGG
+	batch := &fb.MetricBatch{BatchID: "batch-1", Data: []byte(`[{"name":"http_requests","value":1}]`)}
+	fb.StampChecksum(batch)
+	batch.Data = []byte(`[{"name":"http_requests","value":7}]`)
+
+	result, err = a.ProcessBatch(context.Background(), batch)
+	assert.Error(t, err)
+	assert.Equal(t, fb.ErrorCodeInvalidInput, result.ErrorCode)
+	assert.True(t, result.SentToDLQ)
+	assert.Equal(t, 1, dlq.count())
+	assert.Empty(t, a.metricCh)
+}
+
+// histogramBuckets builds the cumulative bucket metrics of a pre-aggregated histogram
+func histogramBuckets(name string, labels map[string]string, counts map[string]float64) []*telemetry.Metric {
+	var metrics []*telemetry.Metric
+	for le, count := range counts {
+		bucketLabels := map[string]string{"le": le}
+		for k, v := range labels {
+			bucketLabels[k] = v
+		}
+		metrics = append(metrics, &telemetry.Metric{Name: name + "_bucket", Value: count, Labels: bucketLabels})
+	}
+	return metrics
+}
+
+// bucketValues maps the le label of flushed histogram metrics to their value
+func bucketValues(metrics []*telemetry.Metric) map[string]float64 {
+	values := make(map[string]float64, len(metrics))
+	for _, metric := range metrics {
+		values[metric.Labels["le"]] = metric.Value
+	}
+	return values
+}
+
+func TestHistogramAggregator_MergesPreAggregatedHistograms(t *testing.T) {
+	agg, err := NewHistogramAggregator([]float64{1, 5}, nil)
+	assert.NoError(t, err)
+
+	var inputs []*telemetry.Metric
+	inputs = append(inputs, histogramBuckets("latency", map[string]string{"host": "a"}, map[string]float64{"1": 2, "5": 4, "+Inf": 5})...)
+	inputs = append(inputs, histogramBuckets("latency", map[string]string{"host": "b"}, map[string]float64{"1": 1, "5": 1, "+Inf": 3})...)
+	inputs = append(inputs, &telemetry.Metric{Name: "latency", Value: 0.5})
+	for _, metric := range inputs {
+		assert.NoError(t, agg.AddMetric(metric))
+	}
+
+	metrics, err := agg.Flush()
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 3)
+	for _, metric := range metrics {
+		assert.Equal(t, "latency_bucket", metric.Name)
+	}
+
+	// Bucket counts are added, not re-bucketed as values
+	assert.Equal(t, map[string]float64{"1": 4, "5": 6, "+Inf": 9}, bucketValues(metrics))
+	assert.Empty(t, agg.TakeRejected())
+}
+
+func TestHistogramAggregator_ReconcilesMismatchedBuckets(t *testing.T) {
+	agg, err := NewHistogramAggregator([]float64{1, 5}, nil)
+	assert.NoError(t, err)
+
+	// A source bucketed at 2 counts towards le=5 but not le=1
+	for _, metric := range histogramBuckets("latency", nil, map[string]float64{"2": 3, "+Inf": 4}) {
+		assert.NoError(t, agg.AddMetric(metric))
+	}
+
+	metrics, err := agg.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"1": 0, "5": 3, "+Inf": 4}, bucketValues(metrics))
+}
+
+func TestHistogramAggregator_AutoBucketsSpanObservedRange(t *testing.T) {
+	tests := map[string]struct {
+		config AutoBucketsConfig
+		value  func(rng *rand.Rand) float64
+	}{
+		// Latencies spread over three orders of magnitude
+		"exponential": {
+			config: AutoBucketsConfig{},
+			value:  func(rng *rand.Rand) float64 { return math.Pow(10, 3*rng.Float64()) },
+		},
+		"linear": {
+			config: AutoBucketsConfig{Scale: AutoBucketsLinear, Count: 5},
+			value:  func(rng *rand.Rand) float64 { return 40 + 20*rng.Float64() },
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			agg := NewAutoHistogramAggregator(tt.config, nil)
+
+			rng := rand.New(rand.NewSource(1))
+			min, max := math.Inf(1), math.Inf(-1)
+			for i := 0; i < 1000; i++ {
+				value := tt.value(rng)
+				min, max = math.Min(min, value), math.Max(max, value)
+				assert.NoError(t, agg.AddMetric(&telemetry.Metric{Name: "latency", Value: value}))
+			}
+
+			metrics, err := agg.Flush()
+			assert.NoError(t, err)
+
+			// The buckets end at the largest value, leaving +Inf empty
+			buckets := agg.Buckets()
+			assert.Len(t, buckets, tt.config.count())
+			assert.True(t, sort.Float64sAreSorted(buckets))
+			assert.Greater(t, buckets[0], min)
+			assert.GreaterOrEqual(t, buckets[len(buckets)-1], max)
+
+			// Every value is counted, and the values are spread over all the
+			// buckets rather than piled into a few
+			values := bucketValues(metrics)
+			assert.Equal(t, 1000.0, values["+Inf"])
+			assert.Equal(t, 1000.0, values[fmt.Sprintf("%g", buckets[len(buckets)-1])])
+			previous := 0.0
+			for _, bound := range buckets {
+				count := values[fmt.Sprintf("%g", bound)] - previous
+				assert.Greater(t, count, 0.0, "bucket le=%g is empty", bound)
+				assert.Less(t, count, 1000.0/3, "bucket le=%g holds most values", bound)
+				previous += count
+			}
+		})
+	}
+}
+
+func TestHistogramAggregator_AutoBucketsRederive(t *testing.T) {
+	agg := NewAutoHistogramAggregator(AutoBucketsConfig{Count: 4, RederiveWindows: 2}, nil)
+
+	window := func(values ...float64) {
+		for _, value := range values {
+			assert.NoError(t, agg.AddMetric(&telemetry.Metric{Name: "latency", Value: value}))
+		}
+		_, err := agg.Flush()
+		assert.NoError(t, err)
+		agg.Reset()
+	}
+
+	window(1, 2, 3, 4)
+	derived := agg.Buckets()
+	assert.Equal(t, []float64{1.75, 2.5, 3.25, 4}, derived)
+
+	// The buckets are kept until the re-derivation window
+	window(100)
+	assert.Equal(t, derived, agg.Buckets())
+
+	window(10, 20, 30, 40)
+	assert.Equal(t, []float64{17.5, 25, 32.5, 40}, agg.Buckets())
+}
+
+func TestAggregation_AutoBucketsSharedByRule(t *testing.T) {
+	a, forwarder := newTestAggregationFunctionBlock(Config{
+		WindowSeconds: 60,
+		Aggregations: []AggregationRule{
+			{Metric: "latency", Type: "histogram", Labels: []string{"path"}, AutoBuckets: &AutoBucketsConfig{Scale: AutoBucketsLinear, Count: 4}},
+		},
+	})
+	defer a.resetAggregators()
+
+	// The paths observe different ranges, which derive the buckets of the
+	// rule together
+	for _, value := range []float64{1, 2, 3, 4} {
+		a.processMetric(&telemetry.Metric{Name: "latency", Value: value, Labels: map[string]string{"path": "/a"}})
+		a.processMetric(&telemetry.Metric{Name: "latency", Value: value * 10, Labels: map[string]string{"path": "/b"}})
+	}
+	assert.NoError(t, a.flushAggregator("latency:histogram:path=/a", "histogram"))
+	assert.NoError(t, a.flushAggregator("latency:histogram:path=/b", "histogram"))
+
+	// Every path exports the same bounds
+	bounds := map[string][]string{}
+	for _, metric := range forwarder.metrics {
+		bounds[metric.Labels["path"]] = append(bounds[metric.Labels["path"]], metric.Labels["le"])
+	}
+	assert.Equal(t, []string{"10.8", "20.5", "30.3", "40", "+Inf"}, bounds["/a"])
+	assert.Equal(t, bounds["/a"], bounds["/b"])
+
+	// A path first seen after the derivation uses them too
+	a.processMetric(&telemetry.Metric{Name: "latency", Value: 15, Labels: map[string]string{"path": "/c"}})
+	a.aggregatorsMu.RLock()
+	agg := a.aggregators["latency:histogram:path=/c"].(*HistogramAggregator)
+	a.aggregatorsMu.RUnlock()
+	assert.Equal(t, []float64{10.8, 20.5, 30.3, 40}, agg.Buckets())
+}
+
+func TestAggregation_InvalidAutoBuckets(t *testing.T) {
+	tests := map[string]AggregationRule{
+		"with buckets":   {Metric: "latency", Type: "histogram", Buckets: []float64{1, 5}, AutoBuckets: &AutoBucketsConfig{}},
+		"not histogram":  {Metric: "latency", Type: "sum", AutoBuckets: &AutoBucketsConfig{}},
+		"unknown scale":  {Metric: "latency", Type: "histogram", AutoBuckets: &AutoBucketsConfig{Scale: "quadratic"}},
+		"negative count": {Metric: "latency", Type: "histogram", AutoBuckets: &AutoBucketsConfig{Count: -1}},
+	}
+
+	for name, rule := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, _ := newTestAggregationFunctionBlock(Config{})
+			configBytes, err := json.Marshal(Config{WindowSeconds: 60, Aggregations: []AggregationRule{rule}})
+			assert.NoError(t, err)
+
+			err = a.UpdateConfig(context.Background(), configBytes, 1)
+			assert.ErrorIs(t, err, fb.ErrConfigInvalid)
+		})
+	}
+}
+
+func TestAggregation_InconsistentHistogramLandsInDLQ(t *testing.T) {
+	a, forwarder := newTestAggregationFunctionBlock(Config{
+		WindowSeconds: 60,
+		Aggregations: []AggregationRule{
+			{Metric: "latency", Type: "histogram", Buckets: []float64{1, 5}, KeepLabels: []string{"*"}},
+		},
+	})
+	defer a.resetAggregators()
+
+	dlq := &mockForwarder{}
+	a.SetDLQForwarder(dlq)
+	rejectedBefore := testutil.ToFloat64(histogramsRejected)
+
+	// Host b's counts decrease as the bound grows
+	var inputs []*telemetry.Metric
+	inputs = append(inputs, histogramBuckets("latency", map[string]string{"host": "a"}, map[string]float64{"1": 2, "5": 4, "+Inf": 5})...)
+	inputs = append(inputs, histogramBuckets("latency", map[string]string{"host": "b"}, map[string]float64{"1": 5, "5": 2, "+Inf": 6})...)
+	for _, metric := range inputs {
+		a.processMetric(metric)
+	}
+
+	err := a.flushAggregator("latency:histogram", "histogram")
+	assert.NoError(t, err)
+
+	// Only the consistent histogram was merged and forwarded
+	assert.Equal(t, 3, forwarder.count())
+	assert.Equal(t, map[string]float64{"1": 2, "5": 4, "+Inf": 5}, bucketValues(forwarder.metrics))
+
+	// The inconsistent one landed in the DLQ as received
+	assert.Equal(t, 3, dlq.count())
+	for _, metric := range dlq.metrics {
+		assert.Equal(t, "b", metric.Labels["host"])
+	}
+	assert.Equal(t, float64(3), testutil.ToFloat64(histogramsRejected)-rejectedBefore)
+}
+
+// renderMetrics renders aggregated output one metric per line, with labels
+// serialized the way the forwarder serializes them
+func renderMetrics(t *testing.T, metrics []*telemetry.Metric) string {
+	var b strings.Builder
+	for _, metric := range metrics {
+		labels, err := json.Marshal(metric.Labels)
+		assert.NoError(t, err)
+		fmt.Fprintf(&b, "%s %s %g\n", metric.Name, labels, metric.Value)
+	}
+	return b.String()
+}
+
+func TestAggregation_OutputIsByteStable(t *testing.T) {
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	inputs := []*telemetry.Metric{
+		{Name: "http_requests", Value: 1, Labels: map[string]string{"path": "/b", "method": "GET", "region": "us-east"}},
+		{Name: "http_requests", Value: 2, Labels: map[string]string{"path": "/a", "method": "POST", "region": "us-east"}},
+		{Name: "http_requests", Value: 3, Labels: map[string]string{"path": "/a", "method": "GET", "region": "us-west"}},
+		{Name: "http_requests", Value: 4, Labels: map[string]string{"path": "/b", "method": "GET", "region": "us-west"}},
+		{Name: "http_latency", Value: 0.5, Labels: map[string]string{"path": "/b"}},
+		{Name: "http_latency", Value: 0.05, Labels: map[string]string{"path": "/a"}},
+	}
+
+	golden := `http_latency_bucket {"le":"0.1","path":"/a","timestamp":"2024-01-01T12:00:00Z"} 1
+http_latency_bucket {"le":"1","path":"/a","timestamp":"2024-01-01T12:00:00Z"} 1
+http_latency_bucket {"le":"+Inf","path":"/a","timestamp":"2024-01-01T12:00:00Z"} 1
+http_latency_bucket {"le":"0.1","path":"/b","timestamp":"2024-01-01T12:00:00Z"} 0
+http_latency_bucket {"le":"1","path":"/b","timestamp":"2024-01-01T12:00:00Z"} 1
+http_latency_bucket {"le":"+Inf","path":"/b","timestamp":"2024-01-01T12:00:00Z"} 1
+http_requests {"method":"GET","path":"/a","timestamp":"2024-01-01T12:00:00Z"} 3
+http_requests {"method":"POST","path":"/a","timestamp":"2024-01-01T12:00:00Z"} 2
+http_requests {"method":"GET","path":"/b","timestamp":"2024-01-01T12:00:00Z"} 5
+`
+
+	// Aggregate the same inputs in a different order on each run; the
+	// serialized output must not change
+	var first []byte
+	for run := 0; run < 10; run++ {
+		a, forwarder := newTestAggregationFunctionBlock(Config{
+			WindowSeconds: 60,
+			Aggregations: []AggregationRule{
+				{Metric: "http_requests", Type: "sum", Labels: []string{"path", "method"}, TimestampMode: TimestampModeLatest},
+				{Metric: "http_latency", Type: "histogram", Labels: []string{"path"}, Buckets: []float64{0.1, 1}, TimestampMode: TimestampModeLatest},
+			},
+		})
+
+		for _, i := range rand.Perm(len(inputs)) {
+			metric := &telemetry.Metric{Name: inputs[i].Name, Value: inputs[i].Value, Labels: make(map[string]string)}
+			for k, v := range inputs[i].Labels {
+				metric.Labels[k] = v
+			}
+			setMetricTimestamp(metric, timestamp)
+			a.processMetric(metric)
+		}
+		assert.NoError(t, a.flushAllAggregators())
+		a.resetAggregators()
+
+		assert.Equal(t, golden, renderMetrics(t, forwarder.metrics), "run %d", run)
+
+		output, err := json.Marshal(forwarder.metrics)
+		assert.NoError(t, err)
+		if run == 0 {
+			first = output
+			continue
+		}
+		assert.Equal(t, string(first), string(output), "run %d", run)
+	}
+}
+
+func BenchmarkAggregation_ProcessMetric(b *testing.B) {
+	hosts := []string{"web-1", "web-2", "web-3", "db-1"}
+	metrics := make([]*telemetry.Metric, 500)
+	for i := range metrics {
+		metrics[i] = &telemetry.Metric{
+			Name:   "http_requests",
+			Value:  float64(i%100) + 0.25,
+			Labels: map[string]string{"host": hosts[i%len(hosts)], "path": fmt.Sprintf("/path/%d", i%10)},
+		}
+	}
+
+	for _, rule := range []AggregationRule{
+		{Metric: "http_requests", Type: "sum", Labels: []string{"host"}},
+		{Metric: "http_requests", Type: "avg", Labels: []string{"host", "path"}},
+		{Metric: "http_requests", Type: "histogram", Labels: []string{"host"}, Buckets: []float64{1, 5, 10, 50, 100}},
+	} {
+		b.Run(rule.Type, func(b *testing.B) {
+			a, _ := newTestAggregationFunctionBlock(Config{
+				WindowSeconds: 60,
+				Aggregations:  []AggregationRule{rule},
+			})
+			defer a.resetAggregators()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				a.processMetric(metrics[i%len(metrics)])
+			}
+		})
+	}
+}
@@ -1,30 +1,88 @@
 package agg
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/newrelic/nrdot-internal-devlab/pkg/telemetry"
 )
 
+// LabelFilter selects which labels are copied onto aggregated output metrics
+type LabelFilter struct {
+	keepAll bool
+	keep    map[string]bool
+	drop    map[string]bool
+}
+
+// NewLabelFilter creates a filter that keeps only the given labels, minus any
+// dropped ones. A keep entry of "*" keeps all labels not explicitly dropped.
+func NewLabelFilter(keep, drop []string) *LabelFilter {
+	f := &LabelFilter{
+		keep: make(map[string]bool, len(keep)),
+		drop: make(map[string]bool, len(drop)),
+	}
+	for _, label := range keep {
+		if label == "*" {
+			f.keepAll = true
+		}
+		f.keep[label] = true
+	}
+	for _, label := range drop {
+		f.drop[label] = true
+	}
+	return f
+}
+
+// Apply returns a filtered copy of the labels. A nil filter keeps all labels.
+func (f *LabelFilter) Apply(labels map[string]string) map[string]string {
+	result := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if f != nil && (f.drop[k] || (!f.keepAll && !f.keep[k])) {
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
 // SumAggregator implements sum aggregation
 type SumAggregator struct {
-	mu    sync.Mutex
-	sum   float64
-	count int
-	name  string
-	attrs map[string]string
+	mu     sync.Mutex
+	filter *LabelFilter
+	sum    float64
+	count  int
+	name   string
+	attrs  map[string]string
+
+	// cumulative makes Flush report the running total of all windows, kept
+	// in total across resets
+	cumulative bool
+	total      float64
 }
 
-// NewSumAggregator creates a new sum aggregator
-func NewSumAggregator() *SumAggregator {
+// NewSumAggregator creates a new sum aggregator reporting the sum of each
+// window
+func NewSumAggregator(filter *LabelFilter) *SumAggregator {
 	return &SumAggregator{
-		attrs: make(map[string]string),
+		filter: filter,
+		attrs:  make(map[string]string),
 	}
 }
 
+// NewCumulativeSumAggregator creates a new sum aggregator reporting the
+// running total since it was created. Once it has received a metric it
+// reports its total every window, including windows without new metrics.
+func NewCumulativeSumAggregator(filter *LabelFilter) *SumAggregator {
+	a := NewSumAggregator(filter)
+	a.cumulative = true
+	return a
+}
+
 // AddMetric adds a metric to the aggregator
 func (a *SumAggregator) AddMetric(metric *telemetry.Metric) error {
 	if metric == nil {
@@ -55,48 +113,74 @@ func (a *SumAggregator) Flush() ([]*telemetry.Metric, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if a.count == 0 {
+	value := a.sum
+	if a.cumulative {
+		if a.name == "" {
+			return nil, nil
+		}
+		value += a.total
+	} else if a.count == 0 {
 		return nil, nil
 	}
 
 	// Create the result metric
 	metric := &telemetry.Metric{
 		Name:   a.name,
-		Value:  a.sum,
-		Labels: make(map[string]string),
-	}
-
-	// Copy attributes
-	for k, v := range a.attrs {
-		metric.Labels[k] = v
+		Value:  value,
+		Labels: a.filter.Apply(a.attrs),
 	}
 
 	return []*telemetry.Metric{metric}, nil
 }
 
-// Reset resets the aggregator state
+// Reset resets the aggregator state, carrying the window's sum over to the
+// running total of cumulative aggregators
 func (a *SumAggregator) Reset() {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	if a.cumulative {
+		a.total += a.sum
+	}
 	a.sum = 0
 	a.count = 0
 	// Keep the name and attributes for the next cycle
 }
 
+// runningTotal returns the running total of a cumulative aggregator,
+// including the window not yet reset
+func (a *SumAggregator) runningTotal() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.total + a.sum
+}
+
+// resume carries over the running total of an evicted cumulative aggregator
+// for the same series, so its total continues rather than restarting from
+// zero. Nothing is reported until the aggregator receives a metric.
+func (a *SumAggregator) resume(total float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.total += total
+}
+
 // AvgAggregator implements average aggregation
 type AvgAggregator struct {
-	mu    sync.Mutex
-	sum   float64
-	count int
-	name  string
-	attrs map[string]string
+	mu     sync.Mutex
+	filter *LabelFilter
+	sum    float64
+	count  int
+	name   string
+	attrs  map[string]string
 }
 
 // NewAvgAggregator creates a new average aggregator
-func NewAvgAggregator() *AvgAggregator {
+func NewAvgAggregator(filter *LabelFilter) *AvgAggregator {
 	return &AvgAggregator{
-		attrs: make(map[string]string),
+		filter: filter,
+		attrs:  make(map[string]string),
 	}
 }
 
@@ -138,12 +222,7 @@ func (a *AvgAggregator) Flush() ([]*telemetry.Metric, error) {
 	metric := &telemetry.Metric{
 		Name:   a.name,
 		Value:  a.sum / float64(a.count),
-		Labels: make(map[string]string),
-	}
-
-	// Copy attributes
-	for k, v := range a.attrs {
-		metric.Labels[k] = v
+		Labels: a.filter.Apply(a.attrs),
 	}
 
 	return []*telemetry.Metric{metric}, nil
@@ -161,18 +240,20 @@ func (a *AvgAggregator) Reset() {
 
 // MinAggregator implements minimum value aggregation
 type MinAggregator struct {
-	mu    sync.Mutex
-	min   float64
-	count int
-	name  string
-	attrs map[string]string
+	mu     sync.Mutex
+	filter *LabelFilter
+	min    float64
+	count  int
+	name   string
+	attrs  map[string]string
 }
 
 // NewMinAggregator creates a new minimum aggregator
-func NewMinAggregator() *MinAggregator {
+func NewMinAggregator(filter *LabelFilter) *MinAggregator {
 	return &MinAggregator{
-		min:   math.MaxFloat64,
-		attrs: make(map[string]string),
+		filter: filter,
+		min:    math.MaxFloat64,
+		attrs:  make(map[string]string),
 	}
 }
 
@@ -216,12 +297,7 @@ func (a *MinAggregator) Flush() ([]*telemetry.Metric, error) {
 	metric := &telemetry.Metric{
 		Name:   a.name,
 		Value:  a.min,
-		Labels: make(map[string]string),
-	}
-
-	// Copy attributes
-	for k, v := range a.attrs {
-		metric.Labels[k] = v
+		Labels: a.filter.Apply(a.attrs),
 	}
 
 	return []*telemetry.Metric{metric}, nil
@@ -239,18 +315,20 @@ func (a *MinAggregator) Reset() {
 
 // MaxAggregator implements maximum value aggregation
 type MaxAggregator struct {
-	mu    sync.Mutex
-	max   float64
-	count int
-	name  string
-	attrs map[string]string
+	mu     sync.Mutex
+	filter *LabelFilter
+	max    float64
+	count  int
+	name   string
+	attrs  map[string]string
 }
 
 // NewMaxAggregator creates a new maximum aggregator
-func NewMaxAggregator() *MaxAggregator {
+func NewMaxAggregator(filter *LabelFilter) *MaxAggregator {
 	return &MaxAggregator{
-		max:   -math.MaxFloat64,
-		attrs: make(map[string]string),
+		filter: filter,
+		max:    -math.MaxFloat64,
+		attrs:  make(map[string]string),
 	}
 }
 
@@ -294,12 +372,7 @@ func (a *MaxAggregator) Flush() ([]*telemetry.Metric, error) {
 	metric := &telemetry.Metric{
 		Name:   a.name,
 		Value:  a.max,
-		Labels: make(map[string]string),
-	}
-
-	// Copy attributes
-	for k, v := range a.attrs {
-		metric.Labels[k] = v
+		Labels: a.filter.Apply(a.attrs),
 	}
 
 	return []*telemetry.Metric{metric}, nil
@@ -315,18 +388,53 @@ func (a *MaxAggregator) Reset() {
 	// Keep the name and attributes for the next cycle
 }
 
-// HistogramAggregator implements histogram aggregation
+// HistogramAggregator implements histogram aggregation. Besides raw values,
+// it merges pre-aggregated histograms sent as cumulative "<name>_bucket"
+// metrics with an "le" label, adding their bucket counts instead of
+// re-bucketing them as values.
 type HistogramAggregator struct {
 	mu      sync.Mutex
+	filter  *LabelFilter
 	buckets []float64
 	counts  []int
 	count   int
 	name    string
 	attrs   map[string]string
+
+	// Pre-aggregated histograms received this window, by source series
+	sources  map[string]*histogramSource
+	rejected []*telemetry.Metric
+
+	// With auto buckets, the layout shared by the aggregators of the rule
+	// and the generation of its buckets in use, the raw values of a window
+	// collected until buckets are derived, and the windows flushed since
+	// the buckets in use were derived
+	auto       *autoBuckets
+	generation int
+	sampling   bool
+	samples    []float64
+	windows    int
 }
 
+// histogramSource is a pre-aggregated histogram from one upstream series,
+// summed over the window
+type histogramSource struct {
+	// Cumulative count per "le" bound
+	counts map[float64]float64
+
+	// The bucket metrics received, kept to route the source to the DLQ
+	metrics []*telemetry.Metric
+
+	// Set when a bucket had an unparseable or negative value
+	invalid bool
+}
+
+// ErrInconsistentHistogram is returned for pre-aggregated histograms whose
+// buckets don't form a valid cumulative histogram
+var ErrInconsistentHistogram = errors.New("inconsistent histogram buckets")
+
 // NewHistogramAggregator creates a new histogram aggregator
-func NewHistogramAggregator(buckets []float64) (*HistogramAggregator, error) {
+func NewHistogramAggregator(buckets []float64, filter *LabelFilter) (*HistogramAggregator, error) {
 	if len(buckets) == 0 {
 		return nil, fmt.Errorf("histogram buckets cannot be empty")
 	}
@@ -340,12 +448,40 @@ func NewHistogramAggregator(buckets []float64) (*HistogramAggregator, error) {
 	counts := make([]int, len(sortedBuckets)+1) // +1 for the "Inf" bucket
 
 	return &HistogramAggregator{
+		filter:  filter,
 		buckets: sortedBuckets,
 		counts:  counts,
 		attrs:   make(map[string]string),
+		sources: make(map[string]*histogramSource),
 	}, nil
 }
 
+// NewAutoHistogramAggregator creates a histogram aggregator that derives its
+// buckets from the values it observes
+func NewAutoHistogramAggregator(config AutoBucketsConfig, filter *LabelFilter) *HistogramAggregator {
+	return newAutoHistogramAggregator(newAutoBuckets(config), filter)
+}
+
+// newAutoHistogramAggregator creates a histogram aggregator using the
+// buckets of a layout shared with the other aggregators of its rule
+func newAutoHistogramAggregator(layout *autoBuckets, filter *LabelFilter) *HistogramAggregator {
+	a := &HistogramAggregator{
+		filter:  filter,
+		counts:  make([]int, 1), // Only the "Inf" bucket until derived
+		attrs:   make(map[string]string),
+		sources: make(map[string]*histogramSource),
+		auto:    layout,
+	}
+	a.adoptBuckets()
+	return a
+}
+
+// isHistogramBucket reports whether a metric is a bucket of a pre-aggregated histogram
+func isHistogramBucket(metric *telemetry.Metric) bool {
+	_, ok := metric.Labels["le"]
+	return ok && strings.HasSuffix(metric.Name, "_bucket")
+}
+
 // AddMetric adds a metric to the aggregator
 func (a *HistogramAggregator) AddMetric(metric *telemetry.Metric) error {
 	if metric == nil {
@@ -355,19 +491,47 @@ func (a *HistogramAggregator) AddMetric(metric *telemetry.Metric) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	bucket := isHistogramBucket(metric)
+
 	// On first metric, set the name and attributes
-	if a.count == 0 {
+	if a.count == 0 && len(a.sources) == 0 {
 		a.name = metric.Name
+		if bucket {
+			a.name = strings.TrimSuffix(metric.Name, "_bucket")
+		}
 		// Copy labels for the output metric
 		for k, v := range metric.Labels {
-			a.attrs[k] = v
+			if k != "le" {
+				a.attrs[k] = v
+			}
+		}
+	}
+
+	if bucket {
+		a.addBucket(metric)
+		return nil
+	}
+
+	a.count++
+	if a.sampling {
+		a.samples = append(a.samples, metric.Value)
+		a.auto.sample(metric.Value)
+		if len(a.samples) >= maxAutoBucketSamples {
+			a.deriveBuckets()
 		}
+		return nil
 	}
+	a.observe(metric.Value)
+
+	return nil
+}
 
+// observe counts a value in its bucket. Must be called with mu held.
+func (a *HistogramAggregator) observe(value float64) {
 	// Find the appropriate bucket
 	bucketIndex := len(a.buckets) // Default to the "Inf" bucket
 	for i, upperBound := range a.buckets {
-		if metric.Value <= upperBound {
+		if value <= upperBound {
 			bucketIndex = i
 			break
 		}
@@ -375,65 +539,211 @@ func (a *HistogramAggregator) AddMetric(metric *telemetry.Metric) error {
 
 	// Increment the bucket count
 	a.counts[bucketIndex]++
-	a.count++
+}
 
+// deriveBuckets switches to the buckets of the layout, derived from the
+// values sampled by the aggregators of the rule unless another aggregator
+// already derived them, and counts the values collected this window in them.
+// Must be called with mu held.
+func (a *HistogramAggregator) deriveBuckets() {
+	a.setBuckets(a.auto.derive(a.samples))
+	for _, value := range a.samples {
+		a.observe(value)
+	}
+	a.samples = nil
+	a.sampling = false
+}
+
+// adoptBuckets starts a window with the current buckets of the layout,
+// collecting its raw values if buckets are being derived. Must be called
+// with mu held.
+func (a *HistogramAggregator) adoptBuckets() {
+	buckets, generation, sampling := a.auto.current()
+	if buckets != nil {
+		a.setBuckets(buckets, generation)
+	}
+	a.sampling = sampling
+}
+
+// setBuckets replaces the buckets with those of a generation of the layout,
+// clearing the counts. Must be called with mu held.
+func (a *HistogramAggregator) setBuckets(buckets []float64, generation int) {
+	if generation != a.generation {
+		a.windows = 0
+	}
+	a.buckets = buckets
+	a.generation = generation
+	a.counts = make([]int, len(buckets)+1)
+}
+
+// Buckets returns the upper bounds of the histogram's finite buckets
+func (a *HistogramAggregator) Buckets() []float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]float64(nil), a.buckets...)
+}
+
+// addBucket adds a bucket of a pre-aggregated histogram to its source's
+// counts. Must be called with mu held.
+func (a *HistogramAggregator) addBucket(metric *telemetry.Metric) {
+	sourceKey := histogramSourceKey(metric.Labels)
+	source, ok := a.sources[sourceKey]
+	if !ok {
+		source = &histogramSource{counts: make(map[float64]float64)}
+		a.sources[sourceKey] = source
+	}
+	source.metrics = append(source.metrics, metric)
+
+	bound, err := strconv.ParseFloat(metric.Labels["le"], 64)
+	if err != nil || math.IsNaN(bound) || metric.Value < 0 {
+		source.invalid = true
+		return
+	}
+	source.counts[bound] += metric.Value
+}
+
+// histogramSourceKey identifies the series a histogram bucket belongs to by
+// its labels other than "le"
+func histogramSourceKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		if k != "le" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s,", k, labels[k])
+	}
+	return b.String()
+}
+
+// validate checks that the source's buckets form a cumulative histogram:
+// an +Inf bucket and counts that never decrease as the bound grows
+func (s *histogramSource) validate() error {
+	if s.invalid {
+		return fmt.Errorf("%w: unparseable bound or negative count", ErrInconsistentHistogram)
+	}
+	if _, ok := s.counts[math.Inf(1)]; !ok {
+		return fmt.Errorf("%w: missing +Inf bucket", ErrInconsistentHistogram)
+	}
+
+	bounds := s.bounds()
+	for i := 1; i < len(bounds); i++ {
+		if s.counts[bounds[i]] < s.counts[bounds[i-1]] {
+			return fmt.Errorf("%w: count at le=%g is below count at le=%g", ErrInconsistentHistogram, bounds[i], bounds[i-1])
+		}
+	}
 	return nil
 }
 
-// Flush returns the aggregated histogram metrics
+// bounds returns the source's bucket bounds in ascending order
+func (s *histogramSource) bounds() []float64 {
+	bounds := make([]float64, 0, len(s.counts))
+	for bound := range s.counts {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+	return bounds
+}
+
+// cumulativeAt returns the source's cumulative count at an upper bound. When
+// the source has no bucket at that bound, the count at its largest bound
+// below it is used, so a mismatched bucket schema undercounts rather than
+// double counts.
+func (s *histogramSource) cumulativeAt(upperBound float64) float64 {
+	var count float64
+	for _, bound := range s.bounds() {
+		if bound > upperBound {
+			break
+		}
+		count = s.counts[bound]
+	}
+	return count
+}
+
+// Flush returns the aggregated histogram metrics. Pre-aggregated histograms
+// with inconsistent buckets are left out of the merge and returned by
+// TakeRejected.
 func (a *HistogramAggregator) Flush() ([]*telemetry.Metric, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if a.count == 0 {
+	if a.count == 0 && len(a.sources) == 0 {
 		return nil, nil
 	}
 
-	// Create a metric for each bucket
-	metrics := make([]*telemetry.Metric, len(a.buckets)+1)
+	// Derive the buckets from the values collected this window, if any. A
+	// window of only pre-aggregated histograms keeps the current buckets.
+	if a.sampling && len(a.samples) > 0 {
+		a.deriveBuckets()
+	}
 
-	cumulativeCount := 0
-	for i, upperBound := range a.buckets {
-		cumulativeCount += a.counts[i]
-		
+	// Only merge sources that form valid histograms. Sources are visited in
+	// key order, so rejected inputs are returned in the same order every run.
+	keys := make([]string, 0, len(a.sources))
+	for key := range a.sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sources []*histogramSource
+	for _, key := range keys {
+		source := a.sources[key]
+		if err := source.validate(); err != nil {
+			a.rejected = append(a.rejected, source.metrics...)
+			continue
+		}
+		sources = append(sources, source)
+	}
+	if a.count == 0 && len(sources) == 0 {
+		return nil, nil
+	}
+
+	// Cumulative counts at each bucket bound, ending with +Inf
+	bounds := append(append([]float64{}, a.buckets...), math.Inf(1))
+	cumulative := make([]float64, len(bounds))
+	rawCount := 0
+	for i, upperBound := range bounds {
+		rawCount += a.counts[i]
+		cumulative[i] = float64(rawCount)
+		for _, source := range sources {
+			cumulative[i] += source.cumulativeAt(upperBound)
+		}
+	}
+
+	// Create a metric for each bucket
+	metrics := make([]*telemetry.Metric, len(bounds))
+	for i, upperBound := range bounds {
 		metric := &telemetry.Metric{
 			Name:   fmt.Sprintf("%s_bucket", a.name),
-			Value:  float64(cumulativeCount),
-			Labels: make(map[string]string),
+			Value:  cumulative[i],
+			Labels: a.filter.Apply(a.attrs),
 		}
-		
-		// Copy attributes
-		for k, v := range a.attrs {
-			metric.Labels[k] = v
-		}
-		
+
 		// Add le (less than or equal) label
 		metric.Labels["le"] = fmt.Sprintf("%g", upperBound)
-		
-		metrics[i] = metric
-	}
 
-	// Add the +Inf bucket
-	cumulativeCount += a.counts[len(a.buckets)]
-	infiniteMetric := &telemetry.Metric{
-		Name:   fmt.Sprintf("%s_bucket", a.name),
-		Value:  float64(cumulativeCount),
-		Labels: make(map[string]string),
-	}
-	
-	// Copy attributes
-	for k, v := range a.attrs {
-		infiniteMetric.Labels[k] = v
+		metrics[i] = metric
 	}
-	
-	// Add le label for Inf
-	infiniteMetric.Labels["le"] = "+Inf"
-	
-	metrics[len(a.buckets)] = infiniteMetric
 
 	return metrics, nil
 }
 
+// TakeRejected returns and clears the bucket metrics of pre-aggregated
+// histograms rejected by Flush
+func (a *HistogramAggregator) TakeRejected() []*telemetry.Metric {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rejected := a.rejected
+	a.rejected = nil
+	return rejected
+}
+
 // Reset resets the aggregator state
 func (a *HistogramAggregator) Reset() {
 	a.mu.Lock()
@@ -444,5 +754,18 @@ func (a *HistogramAggregator) Reset() {
 		a.counts[i] = 0
 	}
 	a.count = 0
+	a.sources = make(map[string]*histogramSource)
 	// Keep the name, attributes, and buckets for the next cycle
+
+	// Periodically collect the values of a window to re-derive auto
+	// buckets, then start the next window with the rule's current buckets
+	if a.auto != nil {
+		if !a.sampling {
+			a.windows++
+			if a.windows >= a.auto.config.rederiveWindows() {
+				a.auto.rederive(a.generation)
+			}
+		}
+		a.adoptBuckets()
+	}
 }
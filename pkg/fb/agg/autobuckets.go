@@ -0,0 +1,221 @@
+package agg
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Scales of automatically derived histogram buckets
+const (
+	AutoBucketsExponential = "exponential"
+	AutoBucketsLinear      = "linear"
+)
+
+// Defaults of automatically derived histogram buckets
+const (
+	defaultAutoBucketCount           = 10
+	defaultAutoBucketRederiveWindows = 60
+
+	// maxAutoBucketSamples bounds the raw values kept while deriving
+	// buckets; once reached, buckets are derived from the values kept so far
+	maxAutoBucketSamples = 10000
+
+	// autoBucketsExponentialSpread is the ratio of the largest to the
+	// smallest observed value from which buckets are spaced exponentially
+	// when no scale is configured
+	autoBucketsExponentialSpread = 100
+)
+
+// AutoBucketsConfig derives the buckets of a histogram rule from the values
+// it observes instead of configuring them. The first window collects raw
+// values and derives the buckets from their range and spread; later windows
+// use those buckets, and every RederiveWindows windows a window collects
+// values again to re-derive them. The buckets are derived from the values of
+// all the rule's group keys, and shared by them.
+type AutoBucketsConfig struct {
+	// Scale spaces the buckets exponentially or linearly between the
+	// smallest and largest observed values. Empty picks exponential for
+	// positive values spanning two orders of magnitude or more, and linear
+	// otherwise.
+	Scale string `json:"scale,omitempty"`
+
+	// Count is the number of buckets, not counting +Inf (default 10)
+	Count int `json:"count,omitempty"`
+
+	// RederiveWindows is the number of windows after which the buckets are
+	// derived again (default 60)
+	RederiveWindows int `json:"rederiveWindows,omitempty"`
+}
+
+// validate checks the auto buckets configuration
+func (c AutoBucketsConfig) validate() error {
+	switch c.Scale {
+	case "", AutoBucketsExponential, AutoBucketsLinear:
+		// These are valid
+	default:
+		return fmt.Errorf("invalid auto buckets scale: %s", c.Scale)
+	}
+	if c.Count < 0 {
+		return fmt.Errorf("auto buckets count must not be negative")
+	}
+	if c.RederiveWindows < 0 {
+		return fmt.Errorf("auto buckets rederiveWindows must not be negative")
+	}
+	return nil
+}
+
+// count returns the configured bucket count or the default
+func (c AutoBucketsConfig) count() int {
+	if c.Count > 0 {
+		return c.Count
+	}
+	return defaultAutoBucketCount
+}
+
+// rederiveWindows returns the configured re-derivation period or the default
+func (c AutoBucketsConfig) rederiveWindows() int {
+	if c.RederiveWindows > 0 {
+		return c.RederiveWindows
+	}
+	return defaultAutoBucketRederiveWindows
+}
+
+// autoBuckets is the bucket layout of a histogram rule with auto buckets,
+// shared by the aggregators of all its group keys so that they export the
+// same "le" bounds. While sampling, the aggregators add their raw values to
+// it, and the first of them to flush derives the buckets for all.
+type autoBuckets struct {
+	mu       sync.Mutex
+	config   AutoBucketsConfig
+	buckets  []float64
+	sampling bool
+	samples  []float64
+
+	// generation counts the derivations, so that a re-derivation is only
+	// started once per layout
+	generation int
+}
+
+// newAutoBuckets creates a layout that derives its buckets from the first
+// values sampled
+func newAutoBuckets(config AutoBucketsConfig) *autoBuckets {
+	return &autoBuckets{config: config, sampling: true}
+}
+
+// current returns the buckets and their generation, and whether values are
+// being sampled to derive new ones
+func (l *autoBuckets) current() ([]float64, int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.buckets, l.generation, l.sampling
+}
+
+// sample adds a raw value to those the buckets are derived from, if
+// sampling
+func (l *autoBuckets) sample(value float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sampling && len(l.samples) < maxAutoBucketSamples {
+		l.samples = append(l.samples, value)
+	}
+}
+
+// derive derives the buckets from the values sampled, if sampling, and
+// returns them with their generation. values, which must not be empty, are
+// those of the calling aggregator, used if a re-derivation started after
+// they were sampled.
+func (l *autoBuckets) derive(values []float64) ([]float64, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sampling {
+		if len(l.samples) > 0 {
+			values = l.samples
+		}
+		l.buckets = deriveBuckets(values, l.config)
+		l.samples = nil
+		l.sampling = false
+		l.generation++
+	}
+	return l.buckets, l.generation
+}
+
+// rederive starts sampling values to derive the buckets again, unless
+// buckets newer than generation were derived or sampling already started
+func (l *autoBuckets) rederive(generation int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if generation == l.generation && !l.sampling {
+		l.sampling = true
+	}
+}
+
+// deriveBuckets returns ascending bucket bounds spanning the observed values:
+// the last bound is the largest value, so only +Inf is left empty. Values
+// must not be empty.
+func deriveBuckets(values []float64, config AutoBucketsConfig) []float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	min, max := sorted[0], sorted[len(sorted)-1]
+	n := config.count()
+	if n == 1 || min == max {
+		return []float64{max}
+	}
+
+	// Exponential spacing needs a positive lower bound; values at or below
+	// it land in the first bucket
+	lower := 0.0
+	if i := sort.SearchFloat64s(sorted, math.SmallestNonzeroFloat64); i < len(sorted) {
+		lower = sorted[i]
+	}
+
+	scale := config.Scale
+	if scale == "" {
+		scale = AutoBucketsLinear
+		if min > 0 && max/min >= autoBucketsExponentialSpread {
+			scale = AutoBucketsExponential
+		}
+	}
+
+	bounds := make([]float64, 0, n)
+	if scale == AutoBucketsExponential && lower > 0 && lower < max {
+		ratio := math.Pow(max/lower, 1/float64(n))
+		for i := 1; i < n; i++ {
+			bounds = append(bounds, roundBound(lower*math.Pow(ratio, float64(i))))
+		}
+	} else {
+		width := (max - min) / float64(n)
+		for i := 1; i < n; i++ {
+			bounds = append(bounds, roundBound(min+width*float64(i)))
+		}
+	}
+
+	// The largest value must fall in a finite bucket, so it isn't rounded
+	bounds = append(bounds, max)
+
+	// Rounding may have merged neighbouring bounds
+	deduped := bounds[:1]
+	for _, bound := range bounds[1:] {
+		if bound > deduped[len(deduped)-1] {
+			deduped = append(deduped, bound)
+		}
+	}
+	return deduped
+}
+
+// roundBound rounds a bucket bound to 3 significant digits, keeping the "le"
+// labels of derived buckets readable
+func roundBound(v float64) float64 {
+	if v == 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+		return v
+	}
+	scale := math.Pow(10, 2-math.Floor(math.Log10(math.Abs(v))))
+	return math.Round(v*scale) / scale
+}
@@ -0,0 +1,117 @@
+// Package benchmark holds what the FB benchmarks share: representative
+// batches, a loop reporting ns and allocations per op, and allocation
+// budgets that fail a test when the processing path's allocations balloon.
+package benchmark
+
+import (
+	"fmt"
+	"testing"
+
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+)
+
+// Hosts, services and users the representative metrics are spread over
+var (
+	hosts    = []string{"web-1", "web-2", "web-3", "db-1"}
+	services = []string{"checkout", "cart", "search"}
+	users    = []string{"alice", "bob", "carol", "dave", "erin"}
+)
+
+// Metrics returns n representative metrics: gauges of a few series with host
+// and service labels, a user_name label for PII handling, and timestamps a
+// second apart
+func Metrics(n int) []codec.Metric {
+	metrics := make([]codec.Metric, n)
+	for i := range metrics {
+		metrics[i] = codec.Metric{
+			"name":      fmt.Sprintf("http_requests_%d", i%8),
+			"type":      "gauge",
+			"value":     float64(i%100) + 0.25,
+			"timestamp": int64(1700000000 + i),
+			"labels": map[string]interface{}{
+				"host":      hosts[i%len(hosts)],
+				"service":   services[i%len(services)],
+				"user_name": users[i%len(users)],
+			},
+		}
+	}
+	return metrics
+}
+
+// Batch returns a batch of n representative metrics in format
+func Batch(tb testing.TB, n int, format string) *fb.MetricBatch {
+	tb.Helper()
+	batch := &fb.MetricBatch{BatchID: "bench-batch"}
+	if err := codec.EncodeAs(batch, Metrics(n), format); err != nil {
+		tb.Fatalf("failed to encode benchmark batch: %v", err)
+	}
+	return batch
+}
+
+// Copy returns a copy of a batch an FB can modify, so each op of a benchmark
+// processes the same data
+func Copy(batch *fb.MetricBatch) *fb.MetricBatch {
+	c := *batch
+	c.Data = append([]byte(nil), batch.Data...)
+	c.Metadata = copyLabels(batch.Metadata)
+	c.InternalLabels = copyLabels(batch.InternalLabels)
+	return &c
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
+
+// Loop runs op b.N times, reporting ns and allocations per op. It fails the
+// benchmark on the first error.
+func Loop(b *testing.B, op func() error) {
+	b.Helper()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := op(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Result is what an op costs
+type Result struct {
+	NsPerOp     int64
+	AllocsPerOp int64
+	BytesPerOp  int64
+}
+
+// String formats the result like go test -bench -benchmem
+func (r Result) String() string {
+	return fmt.Sprintf("%d ns/op\t%d B/op\t%d allocs/op", r.NsPerOp, r.BytesPerOp, r.AllocsPerOp)
+}
+
+// Measure runs a benchmark function outside go test -bench and returns what
+// its op costs
+func Measure(fn func(b *testing.B)) Result {
+	r := testing.Benchmark(fn)
+	return Result{
+		NsPerOp:     r.NsPerOp(),
+		AllocsPerOp: r.AllocsPerOp(),
+		BytesPerOp:  r.AllocedBytesPerOp(),
+	}
+}
+
+// AssertMaxAllocs fails the test if op allocates more than max times on
+// average. Budgets should leave headroom over the measured allocations, so
+// only a regression fails them.
+func AssertMaxAllocs(t testing.TB, max float64, op func()) {
+	t.Helper()
+	if allocs := testing.AllocsPerRun(20, op); allocs > max {
+		t.Errorf("expected at most %.0f allocations per op, got %.0f", max, allocs)
+	}
+}
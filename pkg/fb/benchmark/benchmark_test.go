@@ -0,0 +1,45 @@
+package benchmark
+
+import (
+	"testing"
+
+	"eidc-tfk8s/pkg/fb/codec"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatch_DecodesInEveryFormat(t *testing.T) {
+	for _, format := range []string{codec.FormatJSON, codec.FormatInternalProtobuf} {
+		batch := Batch(t, 10, format)
+		assert.Equal(t, format, batch.Format)
+
+		metrics, err := codec.Decode(batch)
+		require.NoError(t, err)
+		assert.Len(t, metrics, 10)
+	}
+}
+
+func TestCopy_DoesNotShareData(t *testing.T) {
+	batch := Batch(t, 1, codec.FormatJSON)
+	batch.InternalLabels = map[string]string{"tenant_id": "acme"}
+
+	c := Copy(batch)
+	c.Data[0] = 'x'
+	c.InternalLabels["tenant_id"] = "other"
+	assert.NotEqual(t, c.Data[0], batch.Data[0])
+	assert.Equal(t, "acme", batch.InternalLabels["tenant_id"])
+}
+
+func TestMeasure_ReportsAllocations(t *testing.T) {
+	var sink []byte
+	r := Measure(func(b *testing.B) {
+		Loop(b, func() error {
+			sink = make([]byte, 64)
+			return nil
+		})
+	})
+	_ = sink
+	assert.Equal(t, int64(1), r.AllocsPerOp)
+	assert.Contains(t, r.String(), "1 allocs/op")
+}
@@ -0,0 +1,253 @@
+package fb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"eidc-tfk8s/internal/common/metrics"
+	"eidc-tfk8s/internal/common/resilience"
+	"eidc-tfk8s/internal/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// chainLink is a connection to the next FB or the DLQ
+type chainLink struct {
+	addr   string
+	conn   *grpc.ClientConn
+	client ChainPushServiceClient
+}
+
+// connectLink connects link to addr, replacing any previous connection.
+// An unchanged, connected addr is left as it is, as is a client set for
+// testing, and an empty addr disconnects it.
+func (b *BaseFunctionBlock) connectLink(ctx context.Context, link *atomic.Pointer[chainLink], addr string, opts ...grpc.DialOption) error {
+	if current := link.Load(); current != nil && current.client != nil && (current.addr == addr || current.conn == nil) {
+		return nil
+	}
+
+	var next *chainLink
+	if addr != "" {
+		opts = append([]grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithBlock(),
+		}, opts...)
+		conn, err := b.DialContext(ctx, addr, opts...)
+		if err != nil {
+			return err
+		}
+		next = &chainLink{addr: addr, conn: conn, client: NewChainPushServiceClient(conn)}
+	}
+
+	closeLink(link.Swap(next))
+	return nil
+}
+
+// closeLink closes the connection of a link, if any
+func closeLink(link *chainLink) {
+	if link != nil && link.conn != nil {
+		link.conn.Close()
+	}
+}
+
+// InitializeBlock moves the function block to Initialized with default
+// circuit breakers and marks it ready. Function blocks whose only
+// downstreams are the next FB and the DLQ call it from Initialize.
+func (b *BaseFunctionBlock) InitializeBlock() error {
+	if err := b.Transition(StateInitialized); err != nil {
+		return err
+	}
+
+	b.nextFBBreaker.Store(resilience.NewCircuitBreaker(b.name, resilience.DownstreamNextFB, resilience.DefaultCircuitBreakerConfig()))
+	b.dlqBreaker.Store(resilience.NewCircuitBreaker(b.name, resilience.DownstreamDLQ, resilience.DefaultCircuitBreakerConfig()))
+
+	b.SetReady(true)
+	return nil
+}
+
+// ApplyCommonConfig applies the settings of common handled by the
+// BaseFunctionBlock, for a config of the given generation
+func (b *BaseFunctionBlock) ApplyCommonConfig(common config.FBConfig, generation int64) {
+	b.SetConfigGeneration(generation)
+	b.SetEnabled(common.IsEnabled())
+	b.SetMaxConcurrentBatches(common.MaxConcurrentBatches)
+	b.SetMaxReplayCount(common.MaxReplayCount)
+	b.SetVerifyChecksum(common.VerifyChecksum)
+	b.SetHasDLQ(common.DLQ != "")
+	b.SetMetadataLimits(MetadataLimits{
+		MaxEntries: common.MaxMetadataEntries,
+		MaxBytes:   common.MaxMetadataBytes,
+		Action:     MetadataLimitAction(common.MetadataLimitAction),
+	})
+}
+
+// ConnectChain replaces the circuit breakers with those of common and
+// connects the function block to its next FB or next FBs, DLQ and
+// quarantine. opts are added to the dial options of the next FBs.
+// Addresses that fail to connect are reported in the returned error, and
+// batches sent to them fail until a later call connects them.
+func (b *BaseFunctionBlock) ConnectChain(ctx context.Context, common config.FBConfig, opts ...grpc.DialOption) error {
+	b.nextFBBreaker.Store(resilience.NewCircuitBreaker(b.name, resilience.DownstreamNextFB, common.NextFBCircuitBreakerConfig()))
+	b.dlqBreaker.Store(resilience.NewCircuitBreaker(b.name, resilience.DownstreamDLQ, common.DLQCircuitBreakerConfig()))
+
+	var errs []error
+	if err := b.connectLink(ctx, &b.nextFB, common.NextFB, opts...); err != nil {
+		errs = append(errs, fmt.Errorf("failed to connect to next FB: %w", err))
+	}
+	if err := b.ConnectNextFBs(ctx, common, opts...); err != nil {
+		errs = append(errs, err)
+	}
+	if err := b.connectLink(ctx, &b.dlq, common.DLQ); err != nil {
+		errs = append(errs, fmt.Errorf("failed to connect to DLQ: %w", err))
+	}
+	if err := b.ConnectQuarantine(ctx, common.Quarantine); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// CloseChain closes the connections to the next FBs, and then to the DLQ,
+// quarantine and debug tap sink
+func (b *BaseFunctionBlock) CloseChain() {
+	closeLink(b.nextFB.Swap(nil))
+	b.CloseNextFBs()
+
+	closeLink(b.dlq.Swap(nil))
+	b.CloseQuarantine()
+	b.CloseDebugTap()
+}
+
+// ShutdownBlock stops the function block accepting batches, waits for those
+// in flight like DrainBatches, closes its connections with CloseChain and
+// moves it to Stopped. The error of DrainBatches is returned once stopped.
+func (b *BaseFunctionBlock) ShutdownBlock(ctx context.Context) error {
+	if err := b.Transition(StateDraining); err != nil {
+		return err
+	}
+
+	// Let batches in flight finish, including their DLQ sends, before
+	// closing the connections they use
+	drainErr := b.DrainBatches(ctx)
+	b.CloseChain()
+
+	b.SetReady(false)
+	metrics.NewFBMetrics(b.name).SetReady(false)
+
+	if err := b.Transition(StateStopped); err != nil {
+		return err
+	}
+	return drainErr
+}
+
+// ForwardToNextFB forwards a batch to the next FB through its circuit
+// breaker, or fans it out with ForwardToNextFBs if the function block has
+// several next FBs
+func (b *BaseFunctionBlock) ForwardToNextFB(ctx context.Context, batch *MetricBatch, sendToDLQ DLQFunc) (*ProcessResult, error) {
+	if b.FansOut() {
+		return b.ForwardToNextFBs(ctx, batch, sendToDLQ)
+	}
+
+	m := metrics.NewFBMetrics(b.name)
+	startTime := time.Now()
+
+	next := b.nextFB.Load()
+	err := b.nextFBBreaker.Load().Execute(ctx, func(ctx context.Context) error {
+		if next == nil || next.client == nil {
+			return fmt.Errorf("no connection to next FB")
+		}
+
+		res, err := next.client.PushMetrics(ctx, newMetricBatchRequest(batch))
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to next FB: %w", err)
+		}
+		if res.Status != StatusSuccess {
+			return NewNextFBError("", res)
+		}
+		return nil
+	})
+
+	m.RecordBatchForwarded(time.Since(startTime).Seconds())
+
+	if err != nil {
+		m.RecordTenantBatch(TenantID(batch), metrics.TenantOutcomeFailed)
+		if err == resilience.ErrCircuitOpen {
+			return NewCodeErrorResult(batch.BatchID, ErrorCodeCircuitBreakerOpen, err), err
+		}
+		return NewCodeErrorResult(batch.BatchID, ErrorCodeForwardingFailed, err), err
+	}
+
+	m.RecordTenantBatch(TenantID(batch), metrics.TenantOutcomeForwarded)
+	return NewSuccessResult(batch.BatchID), nil
+}
+
+// SendToDLQ sends a batch to the DLQ through its circuit breaker, labelled
+// with originalErr and the sender. It returns ErrNoDLQ without a DLQ, and
+// ErrPoisonBatch, dropping the batch, once it has cycled through the DLQ
+// too often.
+func (b *BaseFunctionBlock) SendToDLQ(ctx context.Context, batch *MetricBatch, originalErr error) error {
+	if !b.HasDLQ() {
+		return ErrNoDLQ
+	}
+
+	dlq := b.dlq.Load()
+	if dlq == nil || dlq.client == nil {
+		return fmt.Errorf("no connection to DLQ")
+	}
+
+	if batch.InternalLabels == nil {
+		batch.InternalLabels = make(map[string]string)
+	}
+	batch.InternalLabels["error"] = originalErr.Error()
+	batch.InternalLabels[LabelSender] = b.Sender()
+
+	// Drop poison batches rather than cycle them through the DLQ forever
+	if b.RecordDLQHop(batch.InternalLabels) {
+		return fmt.Errorf("%w: replay count %s", ErrPoisonBatch, batch.InternalLabels[LabelReplayCount])
+	}
+
+	req := newMetricBatchRequest(batch)
+	err := b.dlqBreaker.Load().Execute(ctx, func(ctx context.Context) error {
+		res, err := dlq.client.PushMetrics(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to DLQ: %w", err)
+		}
+		if res.Status != StatusSuccess {
+			return fmt.Errorf("DLQ returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m := metrics.NewFBMetrics(b.name)
+	m.RecordBatchDLQ()
+	m.RecordTenantBatch(TenantID(batch), metrics.TenantOutcomeDropped)
+	return nil
+}
+
+// newMetricBatchRequest converts a batch to a ChainPushService request
+func newMetricBatchRequest(batch *MetricBatch) *MetricBatchRequest {
+	return &MetricBatchRequest{
+		BatchId:          batch.BatchID,
+		Data:             batch.Data,
+		Format:           batch.Format,
+		Replay:           batch.Replay,
+		ConfigGeneration: batch.ConfigGeneration,
+		Metadata:         batch.Metadata,
+		InternalLabels:   batch.InternalLabels,
+	}
+}
+
+// SetNextFBClientForTesting sets the next FB client for testing purposes
+func (b *BaseFunctionBlock) SetNextFBClientForTesting(client ChainPushServiceClient) {
+	b.nextFB.Store(&chainLink{addr: "testing", client: client})
+}
+
+// SetDLQClientForTesting sets the DLQ client for testing purposes
+func (b *BaseFunctionBlock) SetDLQClientForTesting(client ChainPushServiceClient) {
+	b.dlq.Store(&chainLink{addr: "testing", client: client})
+}
@@ -0,0 +1,63 @@
+package fb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestChain_ForwardAndSendToDLQ(t *testing.T) {
+	block := NewBaseFunctionBlock("fb-chain-test")
+	require.NoError(t, block.InitializeBlock())
+	require.NoError(t, block.ConfigApplied())
+
+	batch := &MetricBatch{BatchID: "batch-1", Data: []byte(`[]`), Format: "json"}
+
+	// Without a connection forwarding fails, retriably
+	result, err := block.ForwardToNextFB(context.Background(), batch, nil)
+	require.Error(t, err)
+	assert.Equal(t, ErrorCodeForwardingFailed, result.ErrorCode)
+	assert.True(t, result.Retriable)
+
+	var forwarded, dlq []*MetricBatchRequest
+	block.SetNextFBClientForTesting(&MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *MetricBatchRequest, opts ...grpc.CallOption) (*MetricBatchResponse, error) {
+			forwarded = append(forwarded, in)
+			return &MetricBatchResponse{Status: StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	block.SetDLQClientForTesting(&MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *MetricBatchRequest, opts ...grpc.CallOption) (*MetricBatchResponse, error) {
+			dlq = append(dlq, in)
+			return &MetricBatchResponse{Status: StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	result, err = block.ForwardToNextFB(context.Background(), batch, nil)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSuccess, result.Status)
+	require.Len(t, forwarded, 1)
+	assert.Equal(t, "batch-1", forwarded[0].BatchId)
+
+	// Batches sent to the DLQ are labelled with the error and sender
+	require.NoError(t, block.SendToDLQ(context.Background(), batch, errors.New("downstream failed")))
+	require.Len(t, dlq, 1)
+	assert.Equal(t, "downstream failed", dlq[0].InternalLabels["error"])
+	assert.Equal(t, "fb-chain-test", dlq[0].InternalLabels[LabelSender])
+
+	// Without a DLQ failed batches go back to the caller
+	block.SetHasDLQ(false)
+	assert.ErrorIs(t, block.SendToDLQ(context.Background(), batch, errors.New("downstream failed")), ErrNoDLQ)
+	assert.Len(t, dlq, 1)
+
+	// Shutting down closes the connections and stops the block
+	require.NoError(t, block.ShutdownBlock(context.Background()))
+	assert.Equal(t, StateStopped, block.State())
+	assert.False(t, block.Ready())
+	assert.Nil(t, block.nextFB.Load())
+	assert.Nil(t, block.dlq.Load())
+}
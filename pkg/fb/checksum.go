@@ -0,0 +1,89 @@
+package fb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// LabelChecksum is the internal label holding the CRC-32C checksum of a
+// batch's data, in hex. FB-RX stamps it and it is refreshed whenever an FB
+// rewrites the data, so each FB can detect data corrupted in transit.
+const LabelChecksum = "data_checksum"
+
+var checksumMismatchTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_checksum_mismatch_total",
+	Help: "Total number of batches received with data not matching their checksum",
+}, []string{"fb_name"})
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// DLQFunc sends a batch that failed with err to the DLQ
+type DLQFunc func(ctx context.Context, batch *MetricBatch, err error) error
+
+// Checksum returns the checksum of batch data, as carried in LabelChecksum
+func Checksum(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(data, castagnoli))
+}
+
+// StampChecksum records the checksum of the batch data in its internal
+// labels, replacing any it already carries
+func StampChecksum(batch *MetricBatch) {
+	if batch.InternalLabels == nil {
+		batch.InternalLabels = make(map[string]string)
+	}
+	batch.InternalLabels[LabelChecksum] = Checksum(batch.Data)
+}
+
+// RefreshChecksum updates the checksum of a batch whose data was rewritten,
+// if it carries one
+func RefreshChecksum(batch *MetricBatch) {
+	if _, ok := batch.InternalLabels[LabelChecksum]; ok {
+		batch.InternalLabels[LabelChecksum] = Checksum(batch.Data)
+	}
+}
+
+// SetVerifyChecksum sets whether the function block verifies the checksum of
+// incoming batches, from FBConfig.VerifyChecksum
+func (b *BaseFunctionBlock) SetVerifyChecksum(verify bool) {
+	var value int32
+	if verify {
+		value = 1
+	}
+	atomic.StoreInt32(&b.verifyChecksum, value)
+}
+
+// VerifyChecksum checks the data of a received batch against the checksum in
+// its internal labels, if verification is enabled and the batch carries one.
+// A mismatching batch is sent to the quarantine or the DLQ with
+// SendFailedBatch, unless the block has neither, and an ErrorCodeInvalidInput
+// result and error are returned, which the function block returns from
+// ProcessBatch.
+func (b *BaseFunctionBlock) VerifyChecksum(ctx context.Context, batch *MetricBatch, sendToDLQ DLQFunc) (*ProcessResult, error) {
+	if atomic.LoadInt32(&b.verifyChecksum) == 0 {
+		return nil, nil
+	}
+	want, ok := batch.InternalLabels[LabelChecksum]
+	if !ok {
+		return nil, nil
+	}
+	got := Checksum(batch.Data)
+	if got == want {
+		return nil, nil
+	}
+
+	checksumMismatchTotal.WithLabelValues(b.name).Inc()
+	err := fmt.Errorf("batch %s data checksum mismatch: got %s, want %s", batch.BatchID, got, want)
+	if dlqErr := b.SendFailedBatch(ctx, batch, ErrorCodeInvalidInput, err, sendToDLQ); dlqErr != nil {
+		if errors.Is(dlqErr, ErrNoDLQ) {
+			return NewCodeErrorResult(batch.BatchID, ErrorCodeInvalidInput, err), err
+		}
+		return NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
+	}
+	return NewErrorResult(batch.BatchID, ErrorCodeInvalidInput, err, true), err
+}
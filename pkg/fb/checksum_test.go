@@ -0,0 +1,77 @@
+package fb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyChecksum(t *testing.T) {
+	block := NewBaseFunctionBlock("fb-checksum-test")
+	mismatches := checksumMismatchTotal.WithLabelValues("fb-checksum-test")
+
+	var dlqErrs []error
+	sendToDLQ := func(ctx context.Context, batch *MetricBatch, err error) error {
+		dlqErrs = append(dlqErrs, err)
+		return nil
+	}
+
+	batch := &MetricBatch{BatchID: "batch-1", Data: []byte(`[{"name":"cpu","value":1}]`)}
+	StampChecksum(batch)
+	batch.Data[len(batch.Data)-3] = '2'
+
+	// Verification is opt-in
+	result, err := block.VerifyChecksum(context.Background(), batch, sendToDLQ)
+	require.NoError(t, err)
+	assert.Nil(t, result)
+
+	block.SetVerifyChecksum(true)
+	result, err = block.VerifyChecksum(context.Background(), batch, sendToDLQ)
+	require.Error(t, err)
+	assert.Equal(t, ErrorCodeInvalidInput, result.ErrorCode)
+	assert.True(t, result.SentToDLQ)
+	assert.False(t, result.Retriable)
+	require.Len(t, dlqErrs, 1)
+	assert.Contains(t, dlqErrs[0].Error(), "checksum mismatch")
+	assert.Equal(t, float64(1), testutil.ToFloat64(mismatches))
+
+	// Rewritten data gets a fresh checksum
+	RefreshChecksum(batch)
+	result, err = block.VerifyChecksum(context.Background(), batch, sendToDLQ)
+	require.NoError(t, err)
+	assert.Nil(t, result)
+
+	// Batches without a checksum pass
+	result, err = block.VerifyChecksum(context.Background(), &MetricBatch{BatchID: "batch-2", Data: []byte(`[]`)}, sendToDLQ)
+	require.NoError(t, err)
+	assert.Nil(t, result)
+
+	// A failed DLQ send is reported as such
+	result, err = block.VerifyChecksum(context.Background(), &MetricBatch{
+		BatchID:        "batch-3",
+		Data:           []byte(`[]`),
+		InternalLabels: map[string]string{LabelChecksum: "00000000"},
+	}, func(ctx context.Context, batch *MetricBatch, err error) error {
+		return fmt.Errorf("no connection to DLQ")
+	})
+	require.Error(t, err)
+	assert.Equal(t, ErrorCodeDLQSendFailed, result.ErrorCode)
+	assert.Equal(t, float64(2), testutil.ToFloat64(mismatches))
+
+	// Without a DLQ the mismatch is reported to the caller
+	result, err = block.VerifyChecksum(context.Background(), &MetricBatch{
+		BatchID:        "batch-4",
+		Data:           []byte(`[]`),
+		InternalLabels: map[string]string{LabelChecksum: "00000000"},
+	}, func(ctx context.Context, batch *MetricBatch, err error) error {
+		return ErrNoDLQ
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+	assert.Equal(t, ErrorCodeInvalidInput, result.ErrorCode)
+	assert.False(t, result.SentToDLQ)
+}
@@ -0,0 +1,35 @@
+package cl
+
+import "io"
+
+// SetAuditWriter sets where the PII audit records are written. Audit records
+// go to their own stream, stdout by default, so they can be collected and
+// retained apart from the operational logs.
+func (c *Classifier) SetAuditWriter(w io.Writer) {
+	c.auditLogger.WithWriter(w)
+}
+
+// countHashed adds the names of hashed fields to the per-field counts
+func countHashed(counts map[string]int, fields []string) {
+	for _, field := range fields {
+		counts[field]++
+	}
+}
+
+// auditPII writes the PII audit record of a batch: how many values of each
+// PII field were hashed, with which salt version, and whether a leak was
+// detected. The record never contains the values themselves.
+func (c *Classifier) auditPII(batchID string, hashed map[string]int, saltVersion string, leakDetected bool) {
+	total := 0
+	for _, count := range hashed {
+		total += count
+	}
+
+	c.auditLogger.Info("PII audit", map[string]interface{}{
+		"batch_id":      batchID,
+		"hashed_fields": hashed,
+		"hashed_total":  total,
+		"salt_version":  saltVersion,
+		"leak_detected": leakDetected,
+	})
+}
@@ -4,10 +4,11 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +19,7 @@ import (
 	"eidc-tfk8s/internal/common/tracing"
 	"eidc-tfk8s/internal/config"
 	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -32,12 +34,21 @@ type ClassifierConfig struct {
 	SaltSecretName   string   `json:"salt_secret_name"`
 	SaltSecretKey    string   `json:"salt_secret_key"`
 	HashAlgorithm    string   `json:"hash_algorithm"`
+
+	// SaltVersion is the version of the salt new hashes are produced with.
+	// Versioned salts are read from the "<salt_secret_key>-<version>" key.
+	SaltVersion string `json:"salt_version"`
+
+	// HistoricalSaltVersions are prior salt versions kept to verify hashes
+	// produced before a rotation
+	HistoricalSaltVersions []string `json:"historical_salt_versions,omitempty"`
 }
 
 // Classifier implements the FB-CL function block
 type Classifier struct {
 	fb.BaseFunctionBlock
 	logger          *logging.Logger
+	auditLogger     *logging.Logger
 	metrics         *metrics.FBMetrics
 	tracer          *tracing.Tracer
 	config          *ClassifierConfig
@@ -46,8 +57,12 @@ type Classifier struct {
 	nextFBConn      *grpc.ClientConn
 	dlqClient       fb.ChainPushServiceClient
 	dlqConn         *grpc.ClientConn
-	circuitBreaker  *resilience.CircuitBreaker
+	nextFBBreaker   *resilience.CircuitBreaker
+	dlqBreaker      *resilience.CircuitBreaker
 	salt            string
+	saltVersion     string
+	salts           map[string]string
+	saltLoader      SaltLoader
 	saltSecretName  string
 	saltSecretKey   string
 	saltMu          sync.RWMutex
@@ -61,6 +76,7 @@ func NewClassifier(logger *logging.Logger, metrics *metrics.FBMetrics, tracer *t
 			ready: false,
 		},
 		logger:         logger,
+		auditLogger:    logging.NewLogger("fb-cl-audit"),
 		metrics:        metrics,
 		tracer:         tracer,
 		saltSecretName: saltSecretName,
@@ -71,12 +87,12 @@ func NewClassifier(logger *logging.Logger, metrics *metrics.FBMetrics, tracer *t
 // Initialize initializes the CL function block
 func (c *Classifier) Initialize(ctx context.Context) error {
 	// Set the name and ready state
-	baseFB := fb.NewBaseFunctionBlock("fb-cl")
-	c.BaseFunctionBlock = baseFB
+	c.BaseFunctionBlock = fb.NewBaseFunctionBlock("fb-cl")
 	c.logger.Info("Initializing FB-CL", nil)
 
-	// Initialize circuit breaker
-	c.circuitBreaker = resilience.NewCircuitBreaker("fb-cl", resilience.DefaultCircuitBreakerConfig())
+	// Initialize circuit breakers
+	c.nextFBBreaker = resilience.NewCircuitBreaker("fb-cl", resilience.DownstreamNextFB, resilience.DefaultCircuitBreakerConfig())
+	c.dlqBreaker = resilience.NewCircuitBreaker("fb-cl", resilience.DownstreamDLQ, resilience.DefaultCircuitBreakerConfig())
 
 	// Load initial salt value
 	if err := c.loadSalt(ctx); err != nil {
@@ -85,32 +101,20 @@ func (c *Classifier) Initialize(ctx context.Context) error {
 		// We'll use a default salt and retry loading later
 		c.saltMu.Lock()
 		c.salt = "default-salt-value-replace-in-production"
+		c.salts = map[string]string{c.saltVersion: c.salt}
 		c.saltMu.Unlock()
 	}
 
+	if err := c.Transition(fb.StateInitialized); err != nil {
+		return err
+	}
+
 	// Mark as ready (full readiness will be set after config is loaded)
 	c.SetReady(true)
 
 	return nil
 }
 
-// loadSalt loads the salt value from a Kubernetes secret
-func (c *Classifier) loadSalt(ctx context.Context) error {
-	// In a real implementation, this would read from a Kubernetes secret
-	// For now, we'll just use a simulated value
-	c.saltMu.Lock()
-	defer c.saltMu.Unlock()
-	
-	c.salt = "simulated-salt-value-" + time.Now().Format(time.RFC3339)
-	c.logger.Info("Loaded salt value", map[string]interface{}{
-		"salt_secret_name": c.saltSecretName,
-		"salt_secret_key":  c.saltSecretKey,
-		// Don't log the actual salt value in production!
-	})
-	
-	return nil
-}
-
 // ProcessBatch processes a batch of metrics
 func (c *Classifier) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
 	// Create child span for the batch processing
@@ -119,76 +123,137 @@ func (c *Classifier) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*
 
 	// Record metric
 	c.metrics.RecordBatchReceived()
+	c.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeReceived)
+
+	// Reject batches until config has been applied, and while shutting down
+	done, result, err := c.BeginBatch(batch.BatchID)
+	if err != nil {
+		return result, err
+	}
+	defer done()
+
+	// Send batches whose data was corrupted in transit to the DLQ
+	if result, err := c.VerifyChecksum(ctx, batch, c.sendToDLQ); err != nil {
+		return result, err
+	}
+
+	// Forward unchanged if this FB is disabled in the pipeline config
+	if !c.Enabled() {
+		return c.BypassBatch(ctx, batch, c.forwardToNextFB)
+	}
+
+	// Capture the batch as received if the debug tap samples it
+	c.CaptureBatch(ctx, fb.DebugStageIn, batch)
 
 	startTime := time.Now()
 
-	// Process batch
-	processingErr := c.processBatch(ctx, batch)
+	// Process batch with the config as it is now, whatever UpdateConfig
+	// does in the meantime
+	processingErr := c.processBatch(ctx, batch, c.snapshotConfig())
 	if processingErr != nil {
 		// Check if it's a PII leak error, which is a special case
 		if strings.Contains(processingErr.Error(), "PII leak detected") {
 			c.metrics.RecordProcessingError()
-			// Send to DLQ immediately for PII leaks
-			dlqErr := c.sendToDLQ(ctx, batch, processingErr)
+			// Quarantine PII leaks immediately, as replaying them can't fix
+			// them, or send them to the DLQ without a quarantine
+			dlqErr := c.SendFailedBatch(ctx, batch, fb.ErrorCodePIILeak, processingErr, c.sendToDLQ)
 			if dlqErr != nil {
-				c.logger.Error("Failed to send to DLQ after PII leak detection", dlqErr, map[string]interface{}{
+				if errors.Is(dlqErr, fb.ErrNoDLQ) {
+					return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodePIILeak, processingErr), processingErr
+				}
+				c.logger.Error("Failed to quarantine batch after PII leak detection", dlqErr, map[string]interface{}{
 					"batch_id": batch.BatchID,
 				})
-				return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeDLQSendFailed, dlqErr, false), dlqErr
+				return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
 			}
 			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodePIILeak, processingErr, true), processingErr
 		}
 		
 		c.metrics.RecordProcessingError()
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeProcessingFailed, processingErr, false), processingErr
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeProcessingFailed, processingErr), processingErr
 	}
 
 	// Record processing metrics
 	c.metrics.RecordBatchProcessed(time.Since(startTime).Seconds())
 
+	// Capture the batch as forwarded if the debug tap samples it
+	c.CaptureBatch(ctx, fb.DebugStageOut, batch)
+
 	// Forward to next FB
 	forwardingResult, forwardingErr := c.forwardToNextFB(ctx, batch)
 	if forwardingErr != nil {
-		// If forwarding fails but processing succeeded, attempt to send to DLQ
-		dlqErr := c.sendToDLQ(ctx, batch, forwardingErr)
+		// Batches the next FB rejected for good go to the quarantine, if
+		// any, and are reported upstream with its code so they aren't retried
+		code := fb.ForwardingErrorCode(forwardingErr)
+		dlqErr := c.SendFailedBatch(ctx, batch, code, forwardingErr, c.sendToDLQ)
 		if dlqErr != nil {
-			c.logger.Error("Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
+			if errors.Is(dlqErr, fb.ErrNoDLQ) {
+				return fb.NewCodeErrorResult(batch.BatchID, code, forwardingErr), forwardingErr
+			}
+			c.logger.ErrorCtx(ctx, "Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
 				"batch_id": batch.BatchID,
 			})
-			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeDLQSendFailed, dlqErr, false), dlqErr
+			return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
 		}
 		
 		// Return error with DLQ status
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, forwardingErr, true), forwardingErr
+		return fb.NewErrorResult(batch.BatchID, code, forwardingErr, true), forwardingErr
 	}
 
 	return forwardingResult, nil
 }
 
-// processBatch performs classification and PII handling on the batch
-func (c *Classifier) processBatch(ctx context.Context, batch *fb.MetricBatch) error {
-	// Get the current config and salt
+// clConfigSnapshot is the config and salt a batch is classified with
+type clConfigSnapshot struct {
+	piiFields   []string
+	salt        string
+	saltVersion string
+}
+
+// snapshotConfig copies the config and salt a batch is classified with in
+// one go, so that an UpdateConfig or salt rotation while the batch is
+// processed can't mix old and new settings. UpdateConfig replaces the config
+// rather than modifying it, so the copy can share its slices.
+func (c *Classifier) snapshotConfig() clConfigSnapshot {
+	var cfg clConfigSnapshot
 	c.configMu.RLock()
-	piiFields := c.config.PIIFields
+	if c.config != nil {
+		cfg.piiFields = c.config.PIIFields
+	}
 	c.configMu.RUnlock()
-	
+
 	c.saltMu.RLock()
-	salt := c.salt
+	cfg.salt, cfg.saltVersion = c.salt, c.saltVersion
 	c.saltMu.RUnlock()
-	
-	// TODO: In a real implementation, this would:
-	// 1. Parse the batch data based on format (OTLP, Prometheus, etc.)
-	// 2. Scan for PII fields based on configuration
-	// 3. Hash PII fields with the salt value
-	// 4. Update the batch data with the hashed values
-	
-	// For now, we'll just simulate the process
-	// This would be replaced with actual classification logic in a real implementation
-	time.Sleep(5 * time.Millisecond) // Simulate processing time
+
+	return cfg
+}
+
+// processBatch performs classification and PII handling on the batch with
+// the config snapshot cfg, and writes the batch's PII audit record
+func (c *Classifier) processBatch(ctx context.Context, batch *fb.MetricBatch, cfg clConfigSnapshot) error {
+	// Hash the PII fields of batches in a known format. Batches without PII
+	// are forwarded unchanged.
+	hashed := make(map[string]int)
+	if decoded, err := codec.Decode(batch); err == nil {
+		for _, metric := range decoded {
+			countHashed(hashed, c.hashFields(metric, cfg.piiFields, cfg.salt, cfg.saltVersion))
+			if labels, ok := metric["labels"].(map[string]interface{}); ok {
+				countHashed(hashed, c.hashFields(labels, cfg.piiFields, cfg.salt, cfg.saltVersion))
+			}
+		}
+		if len(hashed) > 0 {
+			if err := codec.Encode(batch, decoded); err != nil {
+				return err
+			}
+		}
+	}
 	
 	// Check for PII leaks (simulated)
 	// In a real implementation, this would be a more sophisticated check
-	if strings.Contains(string(batch.Data), "command_line:") && !strings.Contains(string(batch.Data), "command_line_hash:") {
+	leakDetected := strings.Contains(string(batch.Data), "command_line:") && !strings.Contains(string(batch.Data), "command_line_hash:")
+	c.auditPII(batch.BatchID, hashed, cfg.saltVersion, leakDetected)
+	if leakDetected {
 		return fmt.Errorf("PII leak detected: unhashed command_line field found")
 	}
 	
@@ -205,10 +270,15 @@ func (c *Classifier) hashPIIValue(value, salt string) string {
 
 // forwardToNextFB forwards the batch to the next function block
 func (c *Classifier) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	// Fan out instead if several next FBs are configured
+	if c.FansOut() {
+		return c.ForwardToNextFBs(ctx, batch, c.sendToDLQ)
+	}
+
 	startTime := time.Now()
 
 	// Use circuit breaker to protect against downstream failures
-	err := c.circuitBreaker.Execute(ctx, func(ctx context.Context) error {
+	err := c.nextFBBreaker.Execute(ctx, func(ctx context.Context) error {
 		// Get the current config
 		c.configMu.RLock()
 		nextFB := c.config.Common.NextFB
@@ -242,7 +312,7 @@ func (c *Classifier) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch)
 
 		// Check response
 		if res.Status != fb.StatusSuccess {
-			return fmt.Errorf("next FB returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+			return fb.NewNextFBError("", res)
 		}
 
 		return nil
@@ -252,17 +322,24 @@ func (c *Classifier) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch)
 	c.metrics.RecordBatchForwarded(time.Since(startTime).Seconds())
 
 	if err != nil {
+		c.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeFailed)
 		if err == resilience.ErrCircuitOpen {
-			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err, false), err
+			return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err), err
 		}
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err, false), err
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err), err
 	}
 
+	c.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeForwarded)
 	return fb.NewSuccessResult(batch.BatchID), nil
 }
 
 // sendToDLQ sends a batch to the Dead Letter Queue
 func (c *Classifier) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr error) error {
+	// Without a DLQ the batch is failed back to the caller
+	if !c.HasDLQ() {
+		return fb.ErrNoDLQ
+	}
+
 	// Create child span for DLQ
 	ctx, span := c.tracer.StartSpan(ctx, "send-to-dlq", nil)
 	defer span.End()
@@ -277,7 +354,17 @@ func (c *Classifier) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, origi
 		batch.InternalLabels = make(map[string]string)
 	}
 	batch.InternalLabels["error"] = originalErr.Error()
-	batch.InternalLabels["fb_sender"] = c.Name()
+	batch.InternalLabels["fb_sender"] = c.Sender()
+
+	// Drop poison batches rather than cycle them through the DLQ forever
+	if c.RecordDLQHop(batch.InternalLabels) {
+		c.logger.Warn("Dropping poison batch", map[string]interface{}{
+			"batch_id":     batch.BatchID,
+			"replay_count": batch.InternalLabels[fb.LabelReplayCount],
+			"error":        originalErr.Error(),
+		})
+		return fb.ErrPoisonBatch
+	}
 	
 	// Add the error code for PII leaks
 	if strings.Contains(originalErr.Error(), "PII leak detected") {
@@ -296,18 +383,26 @@ func (c *Classifier) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, origi
 	}
 
 	// Send to DLQ
-	res, err := c.dlqClient.PushMetrics(ctx, req)
-	if err != nil {
-		return fmt.Errorf("failed to push metrics to DLQ: %w", err)
-	}
+	err := c.dlqBreaker.Execute(ctx, func(ctx context.Context) error {
+		res, err := c.dlqClient.PushMetrics(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to DLQ: %w", err)
+		}
 
-	// Check response
-	if res.Status != fb.StatusSuccess {
-		return fmt.Errorf("DLQ returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		// Check response
+		if res.Status != fb.StatusSuccess {
+			return fmt.Errorf("DLQ returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Record metric
 	c.metrics.RecordBatchDLQ()
+	c.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeDropped)
 
 	return nil
 }
@@ -318,79 +413,172 @@ func (c *Classifier) UpdateConfig(ctx context.Context, configBytes []byte, gener
 	ctx, span := c.tracer.StartSpan(ctx, "update-config", nil)
 	defer span.End()
 
-	// Parse configuration
-	var newConfig ClassifierConfig
-	if err := json.Unmarshal(configBytes, &newConfig); err != nil {
-		return fmt.Errorf("failed to parse config: %w", err)
+	// Parse and validate configuration
+	newConfig, err := c.parseConfig(configBytes)
+	if err != nil {
+		return err
 	}
 
-	// Validate configuration
-	if err := c.validateConfig(&newConfig); err != nil {
-		return fmt.Errorf("invalid config: %w", err)
+	// Refuse a generation older than the one applied, unless rolling back
+	if err := c.CheckConfigGeneration(generation, newConfig.Common.Rollback); err != nil {
+		c.logger.Error("Refused configuration", err, map[string]interface{}{
+			"generation":         generation,
+			"applied_generation": c.GetConfigGeneration(),
+		})
+		return err
 	}
 
 	// Get the current config to check for salt changes
 	c.configMu.RLock()
 	oldSaltSecretName := ""
 	oldSaltSecretKey := ""
+	oldSaltVersion := ""
+	var oldHistoricalSaltVersions []string
 	if c.config != nil {
 		oldSaltSecretName = c.config.SaltSecretName
 		oldSaltSecretKey = c.config.SaltSecretKey
+		oldSaltVersion = c.config.SaltVersion
+		oldHistoricalSaltVersions = c.config.HistoricalSaltVersions
 	}
 	c.configMu.RUnlock()
 	
 	// Apply configuration
 	c.configMu.Lock()
-	c.config = &newConfig
+	oldConfig := c.config
+	c.config = newConfig
 	c.SetConfigGeneration( generation
+	c.SetEnabled(newConfig.Common.IsEnabled())
+	c.SetMaxConcurrentBatches(newConfig.Common.MaxConcurrentBatches)
+	c.SetMaxReplayCount(newConfig.Common.MaxReplayCount)
+	c.SetVerifyChecksum(newConfig.Common.VerifyChecksum)
+	c.SetHasDLQ(newConfig.Common.DLQ != "")
+	c.SetMetadataLimits(fb.MetadataLimits{
+		MaxEntries: newConfig.Common.MaxMetadataEntries,
+		MaxBytes:   newConfig.Common.MaxMetadataBytes,
+		Action:     fb.MetadataLimitAction(newConfig.Common.MetadataLimitAction),
+	})
+	if level, err := logging.ParseLevel(newConfig.Common.LogLevel); err == nil {
+		c.logger.SetLevel(level)
+	}
+	if newConfig.Common.TracingEnabled {
+		c.tracer.SetSamplingRatio(newConfig.Common.TraceSamplingRatio)
+	}
+	seed, _ := newConfig.Common.DeterministicSeed()
+	c.tracer.SetSeed(seed)
 	c.configMu.Unlock()
 
 	// Update circuit breaker configuration
-	c.circuitBreaker = resilience.NewCircuitBreaker("fb-cl", resilience.CircuitBreakerConfig{
-		ErrorThresholdPercentage: newConfig.Common.CircuitBreaker.ErrorThresholdPercentage,
-		OpenStateSeconds:         newConfig.Common.CircuitBreaker.OpenStateSeconds,
-		HalfOpenRequestThreshold: newConfig.Common.CircuitBreaker.HalfOpenRequestThreshold,
-	})
+	c.nextFBBreaker = resilience.NewCircuitBreaker("fb-cl", resilience.DownstreamNextFB, newConfig.Common.NextFBCircuitBreakerConfig())
+	c.dlqBreaker = resilience.NewCircuitBreaker("fb-cl", resilience.DownstreamDLQ, newConfig.Common.DLQCircuitBreakerConfig())
 
-	// Update salt if the secret name or key changed
-	if newConfig.SaltSecretName != oldSaltSecretName || newConfig.SaltSecretKey != oldSaltSecretKey {
+	// Update salt if the secret name, key or salt versions changed
+	if newConfig.SaltSecretName != oldSaltSecretName || newConfig.SaltSecretKey != oldSaltSecretKey ||
+		newConfig.SaltVersion != oldSaltVersion || !reflect.DeepEqual(newConfig.HistoricalSaltVersions, oldHistoricalSaltVersions) {
 		c.saltSecretName = newConfig.SaltSecretName
 		c.saltSecretKey = newConfig.SaltSecretKey
 		if err := c.loadSalt(ctx); err != nil {
 			c.logger.Error("Failed to load salt after config update", err, map[string]interface{}{
 				"salt_secret_name": newConfig.SaltSecretName,
 				"salt_secret_key":  newConfig.SaltSecretKey,
+				"salt_version":     newConfig.SaltVersion,
 			})
 			// Don't fail config update on salt load failure
 		}
 	}
 
 	// Connect to next FB and DLQ
-	if err := c.connectToNextFB(ctx, newConfig.Common.NextFB); err != nil {
-		c.logger.Error("Failed to connect to next FB", err, map[string]interface{}{
-			"next_fb": newConfig.Common.NextFB,
+	if newConfig.Common.NextFB != "" {
+		if err := c.connectToNextFB(ctx, newConfig.Common.NextFB); err != nil {
+			c.logger.Error("Failed to connect to next FB", err, map[string]interface{}{
+				"next_fb": newConfig.Common.NextFB,
+			})
+			// Don't fail config update on connection error - we'll retry on next batch
+		}
+	}
+
+	if err := c.ConnectNextFBs(ctx, newConfig.Common, metrics.GRPCDialOption(c.Name())); err != nil {
+		c.logger.Error("Failed to connect to next FBs", err, map[string]interface{}{
+			"next_fbs": len(newConfig.Common.NextFBs),
+		})
+		// Don't fail config update on connection error - batches to the
+		// targets not connected fail until the next update
+	}
+
+	if err := c.ConnectQuarantine(ctx, newConfig.Common.Quarantine); err != nil {
+		c.logger.Error("Failed to connect to quarantine", err, map[string]interface{}{
+			"quarantine": newConfig.Common.Quarantine,
 		})
-		// Don't fail config update on connection error - we'll retry on next batch
+		// Don't fail config update on connection error - we'll retry on next update
+	}
+
+	if err := c.ConfigureDebugTap(ctx, newConfig.Common.DebugTap, codec.Decode); err != nil {
+		c.logger.Error("Failed to configure debug tap", err, nil)
+		// Don't fail config update on a debug tap error - batches just aren't captured
+	}
+
+	// Start processing now that config is applied
+	if err := c.ConfigApplied(); err != nil {
+		return err
 	}
 
 	// Update metrics
 	c.metrics.SetConfigGeneration(generation)
+	c.SetReady(true)
 	c.metrics.SetReady(true)
 
+	// Report what the update changed, for rollout audits
+	changes, _ := config.DiffConfig(oldConfig, newConfig)
+
 	c.logger.Info("Config updated", map[string]interface{}{
 		"generation":       generation,
 		"next_fb":          newConfig.Common.NextFB,
 		"pii_fields_count": len(newConfig.PIIFields),
 		"salt_secret_name": newConfig.SaltSecretName,
+		"enabled":          newConfig.Common.IsEnabled(),
+		"changes":          changes,
 	})
 
 	return nil
 }
 
+// ValidateConfig parses and validates a configuration without applying it
+func (c *Classifier) ValidateConfig(configBytes []byte) error {
+	_, err := c.parseConfig(configBytes)
+	return err
+}
+
+// parseConfig parses and validates a configuration
+func (c *Classifier) parseConfig(configBytes []byte) (*ClassifierConfig, error) {
+	// Merge a patch onto the applied config
+	c.configMu.RLock()
+	applied := c.config
+	c.configMu.RUnlock()
+	configBytes, err := config.ResolveMergePatch(configBytes, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	var newConfig ClassifierConfig
+	if err := config.LoadConfigFromBytes(configBytes, &newConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if err := c.validateConfig(&newConfig); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &newConfig, nil
+}
+
 // validateConfig validates the CL function block's configuration
 func (c *Classifier) validateConfig(config *ClassifierConfig) error {
+	// Validate common settings
+	if err := config.Common.Validate(); err != nil {
+		return err
+	}
+
 	// Check if next FB is configured
-	if config.Common.NextFB == "" {
+	if !config.Common.HasNextFB() {
 		return fmt.Errorf("next FB not configured")
 	}
 
@@ -416,29 +604,11 @@ func (c *Classifier) ConnectServices(ctx context.Context, configServiceAddr, nex
 	// 3. Apply initial configuration
 	
 	// For now, we'll just create a default config
-	c.config = &ClassifierConfig{
-		Common: config.FBConfig{
-			LogLevel:           "info",
-			MetricsEnabled:     true,
-			TracingEnabled:     true,
-			TraceSamplingRatio: 0.1,
-			NextFB:             nextFB,
-			CircuitBreaker: config.CircuitBreakerConfig{
-				ErrorThresholdPercentage: 50,
-				OpenStateSeconds:         30,
-				HalfOpenRequestThreshold: 5,
-			},
-		},
-		PIIFields: []string{
-			"command_line",
-			"user_name",
-			"email",
-			"ip_address",
-		},
-		SaltSecretName: c.saltSecretName,
-		SaltSecretKey:  c.saltSecretKey,
-		HashAlgorithm:  "sha256",
-	}
+	defaultConfig := DefaultConfig()
+	defaultConfig.Common.NextFB = nextFB
+	defaultConfig.SaltSecretName = c.saltSecretName
+	defaultConfig.SaltSecretKey = c.saltSecretKey
+	c.config = &defaultConfig
 	c.SetConfigGeneration( 1
 	
 	// Connect to next FB
@@ -446,9 +616,12 @@ func (c *Classifier) ConnectServices(ctx context.Context, configServiceAddr, nex
 		return fmt.Errorf("failed to connect to next FB: %w", err)
 	}
 	
-	// Connect to DLQ
-	if err := c.connectToDLQ(ctx, dlqAddr); err != nil {
-		return fmt.Errorf("failed to connect to DLQ: %w", err)
+	// Connect to DLQ, unless deployed without one
+	c.SetHasDLQ(dlqAddr != "")
+	if dlqAddr != "" {
+		if err := c.connectToDLQ(ctx, dlqAddr); err != nil {
+			return fmt.Errorf("failed to connect to DLQ: %w", err)
+		}
 	}
 	
 	c.logger.Info("Connected to services", map[string]interface{}{
@@ -470,9 +643,10 @@ func (c *Classifier) connectToNextFB(ctx context.Context, nextFB string) error {
 	}
 
 	// Create new connection
-	conn, err := grpc.DialContext(ctx, nextFB,
+	conn, err := c.DialContext(ctx, nextFB,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithBlock(),
+		metrics.GRPCDialOption(c.Name()),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to connect to next FB: %w", err)
@@ -494,7 +668,7 @@ func (c *Classifier) connectToDLQ(ctx context.Context, dlqAddr string) error {
 	}
 
 	// Create new connection
-	conn, err := grpc.DialContext(ctx, dlqAddr,
+	conn, err := c.DialContext(ctx, dlqAddr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithBlock(),
 	)
@@ -512,35 +686,60 @@ func (c *Classifier) connectToDLQ(ctx context.Context, dlqAddr string) error {
 func (c *Classifier) Shutdown(ctx context.Context) error {
 	c.logger.Info("Shutting down FB-CL", nil)
 
-	// Close connections
+	// Stop accepting batches
+	if err := c.Transition(fb.StateDraining); err != nil {
+		return err
+	}
+
+	// Let batches in flight finish, including their DLQ sends
+	drainErr := c.DrainBatches(ctx)
+	if drainErr != nil {
+		c.logger.Warn("Shutting down with batches still in flight", map[string]interface{}{
+			"error": drainErr.Error(),
+		})
+	}
+
+	// Close connections, the DLQ and quarantine last
 	if c.nextFBConn != nil {
 		c.nextFBConn.Close()
 		c.nextFBConn = nil
 		c.nextFBClient = nil
 	}
+	c.CloseNextFBs()
 
 	if c.dlqConn != nil {
 		c.dlqConn.Close()
 		c.dlqConn = nil
 		c.dlqClient = nil
 	}
+	c.CloseQuarantine()
+	c.CloseDebugTap()
 
 	// Mark as not ready
-	c.BaseFunctionBlock.ready = false
+	c.SetReady(false)
 
-	return nil
+	if err := c.Transition(fb.StateStopped); err != nil {
+		return err
+	}
+	return drainErr
 }
 
 // StartGRPCServer starts the gRPC server for the ChainPushService
 func StartGRPCServer(ctx context.Context, fb *Classifier, port int) (*grpc.Server, error) {
-	// Create gRPC server
-	server := grpc.NewServer()
+	// Create gRPC server, recording RPC metrics
+	server := grpc.NewServer(metrics.GRPCServerOptions(fb.Name())...)
 
 	// Register the ChainPushService
 	fb.logger.Info("Registering ChainPushService", map[string]interface{}{"port": port})
 	handler := fb.NewChainPushServiceHandler()
 	fb.RegisterChainPushServiceServer(server, handler)
 
+	// Register the standard gRPC health service
+	fb.RegisterHealthServer(server)
+
+	// Register the reflection service, if enabled for debugging
+	fb.RegisterReflectionServer(server)
+
 	// Start gRPC server
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
@@ -1,13 +1,23 @@
 package cl
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 
 	"eidc-tfk8s/internal/common/logging"
 	"eidc-tfk8s/internal/common/metrics"
 	"eidc-tfk8s/internal/common/tracing"
+	"eidc-tfk8s/internal/config"
 	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/benchmark"
+	"eidc-tfk8s/pkg/fb/codec"
+
+	"google.golang.org/grpc"
 )
 
 func TestClassifier_ProcessBatch(t *testing.T) {
@@ -42,13 +52,13 @@ func TestClassifier_ProcessBatch(t *testing.T) {
 	// Process the safe batch
 	// We need to set up mock clients for nextFB and DLQ for a complete test
 	// For now, we'll just test the processBatch method directly
-	err = classifier.processBatch(context.Background(), safeBatch)
+	err = classifier.processBatch(context.Background(), safeBatch, classifier.snapshotConfig())
 	if err != nil {
 		t.Errorf("Expected no error for safe batch, got: %v", err)
 	}
 	
 	// Process the PII leak batch
-	err = classifier.processBatch(context.Background(), piiLeakBatch)
+	err = classifier.processBatch(context.Background(), piiLeakBatch, classifier.snapshotConfig())
 	if err == nil {
 		t.Error("Expected error for PII leak batch, got nil")
 	} else if !containsString(err.Error(), "PII leak detected") {
@@ -94,3 +104,238 @@ func containsString(str, substr string) bool {
 	return str != "" && substr != "" && str != substr && len(str) > len(substr) && str[0:len(substr)] != substr && str[len(str)-len(substr):] != substr && str[0:len(str)/2] != substr && str[len(str)/2:] != substr
 }
 
+func TestDefaultConfig_MatchesParametersSchema(t *testing.T) {
+	configBytes, err := json.Marshal(DefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to marshal default config: %v", err)
+	}
+
+	if err := config.ValidateParameters("fb-cl", configBytes); err != nil {
+		t.Errorf("Expected default config to match its schema, got: %v", err)
+	}
+}
+
+func TestClassifier_SaltRotation(t *testing.T) {
+	logger := logging.NewLogger("fb-cl-test")
+	fbMetrics := metrics.NewFBMetrics("fb-cl-test")
+	tracer := tracing.NewTracer("fb-cl-test")
+	classifier := NewClassifier(logger, fbMetrics, tracer, "test-salt-secret", "salt")
+
+	secret := map[string]string{
+		"salt-v1": "first salt",
+		"salt-v2": "second salt",
+	}
+	classifier.SetSaltLoader(func(ctx context.Context, secretName, secretKey string) (string, error) {
+		salt, ok := secret[secretKey]
+		if !ok {
+			return "", fmt.Errorf("key %s not found in secret %s", secretKey, secretName)
+		}
+		return salt, nil
+	})
+
+	// Hash with the first salt version
+	classifier.config = &ClassifierConfig{SaltVersion: "v1"}
+	if err := classifier.loadSalt(context.Background()); err != nil {
+		t.Fatalf("Failed to load salt: %v", err)
+	}
+	oldLabels := map[string]interface{}{"user_name": "alice", "host": "a"}
+	classifier.hashPIIFields(oldLabels, []string{"user_name"})
+
+	if _, ok := oldLabels["user_name"]; ok {
+		t.Error("Expected user_name to be replaced by its hash")
+	}
+	if oldLabels["user_name_hash_salt_ver"] != "v1" {
+		t.Errorf("Expected salt version v1, got %v", oldLabels["user_name_hash_salt_ver"])
+	}
+
+	// Rotate to the second version, keeping the first for verification
+	classifier.config = &ClassifierConfig{SaltVersion: "v2", HistoricalSaltVersions: []string{"v1"}}
+	if err := classifier.loadSalt(context.Background()); err != nil {
+		t.Fatalf("Failed to load salt after rotation: %v", err)
+	}
+	newLabels := map[string]interface{}{"user_name": "alice"}
+	classifier.hashPIIFields(newLabels, []string{"user_name"})
+
+	if newLabels["user_name_hash_salt_ver"] != "v2" {
+		t.Errorf("Expected salt version v2, got %v", newLabels["user_name_hash_salt_ver"])
+	}
+
+	oldHash := oldLabels["user_name_hash"].(string)
+	newHash := newLabels["user_name_hash"].(string)
+	if oldHash == newHash {
+		t.Error("Expected hashes produced with different salts to differ")
+	}
+
+	// Old and new hashes coexist and each verifies against its own version
+	if !classifier.VerifyPIIHash("alice", oldHash, "v1") {
+		t.Error("Expected hash from before the rotation to verify with v1")
+	}
+	if !classifier.VerifyPIIHash("alice", newHash, "v2") {
+		t.Error("Expected hash from after the rotation to verify with v2")
+	}
+	if classifier.VerifyPIIHash("alice", oldHash, "v2") {
+		t.Error("Expected hash from before the rotation not to verify with v2")
+	}
+	if classifier.VerifyPIIHash("alice", oldHash, "v0") {
+		t.Error("Expected unknown salt version not to verify")
+	}
+
+	versions := classifier.SaltVersions()
+	if len(versions) != 2 || versions[0] != "v1" || versions[1] != "v2" {
+		t.Errorf("Expected salt versions [v1 v2], got %v", versions)
+	}
+}
+
+func TestClassifier_AuditRecordHasCountsButNoPII(t *testing.T) {
+	logger := logging.NewLogger("fb-cl-test")
+	fbMetrics := metrics.NewFBMetrics("fb-cl-test")
+	tracer := tracing.NewTracer("fb-cl-test")
+	classifier := NewClassifier(logger, fbMetrics, tracer, "test-salt-secret", "salt")
+
+	var audit bytes.Buffer
+	classifier.SetAuditWriter(&audit)
+	classifier.config = &ClassifierConfig{PIIFields: []string{"user_name"}, SaltVersion: "v1"}
+	classifier.salt = "test salt"
+	classifier.saltVersion = "v1"
+
+	batch := &fb.MetricBatch{
+		BatchID: "batch-audit",
+		Data:    []byte(`[{"name":"logins","value":1,"labels":{"user_name":"alice"}},{"name":"logins","value":2,"labels":{"user_name":"bob"}}]`),
+	}
+	if err := classifier.processBatch(context.Background(), batch, classifier.snapshotConfig()); err != nil {
+		t.Fatalf("Failed to process batch: %v", err)
+	}
+
+	if strings.Contains(string(batch.Data), "alice") || strings.Contains(string(batch.Data), "bob") {
+		t.Errorf("Expected PII to be hashed in the batch, got %s", batch.Data)
+	}
+
+	var record struct {
+		BatchID      string         `json:"batch_id"`
+		HashedFields map[string]int `json:"hashed_fields"`
+		HashedTotal  int            `json:"hashed_total"`
+		SaltVersion  string         `json:"salt_version"`
+		LeakDetected bool           `json:"leak_detected"`
+	}
+	if err := json.Unmarshal(audit.Bytes(), &record); err != nil {
+		t.Fatalf("Failed to parse audit record %q: %v", audit.String(), err)
+	}
+	if record.BatchID != "batch-audit" {
+		t.Errorf("Expected batch id batch-audit, got %s", record.BatchID)
+	}
+	if record.HashedFields["user_name"] != 2 || record.HashedTotal != 2 {
+		t.Errorf("Expected 2 hashed user_name values, got %v (total %d)", record.HashedFields, record.HashedTotal)
+	}
+	if record.SaltVersion != "v1" {
+		t.Errorf("Expected salt version v1, got %s", record.SaltVersion)
+	}
+	if record.LeakDetected {
+		t.Error("Expected no leak to be detected")
+	}
+	if strings.Contains(audit.String(), "alice") || strings.Contains(audit.String(), "bob") {
+		t.Errorf("Expected audit record not to contain raw PII, got %s", audit.String())
+	}
+}
+
+func TestClassifier_PIILeakGoesToQuarantine(t *testing.T) {
+	logger := logging.NewLogger("fb-cl-test")
+	fbMetrics := metrics.NewFBMetrics("fb-cl-test")
+	tracer := tracing.NewTracer("fb-cl-test")
+	classifier := NewClassifier(logger, fbMetrics, tracer, "test-salt-secret", "salt")
+	if err := classifier.Transition(fb.StateInitialized); err != nil {
+		t.Fatalf("Failed to initialize classifier: %v", err)
+	}
+	classifier.config = &ClassifierConfig{}
+	if err := classifier.ConfigApplied(); err != nil {
+		t.Fatalf("Failed to start classifier: %v", err)
+	}
+
+	var dlqBatches, quarantinedBatches []*fb.MetricBatchRequest
+	classifier.dlqClient = &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqBatches = append(dlqBatches, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	}
+	classifier.SetQuarantineClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			quarantinedBatches = append(quarantinedBatches, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	result, err := classifier.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID: "batch-leak",
+		Data:    []byte("test.metric command_line: sensitive command"),
+		Format:  "text",
+	})
+	if err == nil {
+		t.Fatal("Expected error for PII leak batch, got nil")
+	}
+	if result.ErrorCode != fb.ErrorCodePIILeak {
+		t.Errorf("Expected error code %s, got %s", fb.ErrorCodePIILeak, result.ErrorCode)
+	}
+	if !result.SentToDLQ || result.Retriable {
+		t.Errorf("Expected batch to be handed off and not retried, got sent %v, retriable %v", result.SentToDLQ, result.Retriable)
+	}
+
+	if len(dlqBatches) != 0 {
+		t.Errorf("Expected no batch sent to the DLQ, got %d", len(dlqBatches))
+	}
+	if len(quarantinedBatches) != 1 {
+		t.Fatalf("Expected 1 quarantined batch, got %d", len(quarantinedBatches))
+	}
+	if code := quarantinedBatches[0].InternalLabels["error_code"]; code != string(fb.ErrorCodePIILeak) {
+		t.Errorf("Expected error_code label %s, got %s", fb.ErrorCodePIILeak, code)
+	}
+}
+
+// newBenchmarkClassifier returns a classifier hashing the user_name label of
+// the representative benchmark metrics
+func newBenchmarkClassifier() *Classifier {
+	logger := logging.NewLogger("fb-cl-test")
+	fbMetrics := metrics.NewFBMetrics("fb-cl-test")
+	tracer := tracing.NewTracer("fb-cl-test")
+	classifier := NewClassifier(logger, fbMetrics, tracer, "test-salt-secret", "salt")
+	classifier.SetAuditWriter(io.Discard)
+	classifier.config = &ClassifierConfig{PIIFields: []string{"user_name"}, SaltVersion: "v1"}
+	classifier.salt = "test salt"
+	classifier.saltVersion = "v1"
+	return classifier
+}
+
+func BenchmarkClassifier_HashPIIValue(b *testing.B) {
+	classifier := newBenchmarkClassifier()
+	benchmark.Loop(b, func() error {
+		classifier.hashPIIValue("alice@example.com", "test salt")
+		return nil
+	})
+}
+
+func BenchmarkClassifier_ProcessBatch(b *testing.B) {
+	classifier := newBenchmarkClassifier()
+	cfg := classifier.snapshotConfig()
+	batch := benchmark.Batch(b, 500, codec.FormatJSON)
+	b.SetBytes(int64(len(batch.Data)))
+
+	benchmark.Loop(b, func() error {
+		return classifier.processBatch(context.Background(), benchmark.Copy(batch), cfg)
+	})
+}
+
+// The allocation budgets are about twice what hashing allocates (3 per value,
+// about 50 per metric), so they only fail when allocations balloon
+func TestClassifier_HashingAllocations(t *testing.T) {
+	classifier := newBenchmarkClassifier()
+	benchmark.AssertMaxAllocs(t, 6, func() {
+		classifier.hashPIIValue("alice@example.com", "test salt")
+	})
+
+	cfg := classifier.snapshotConfig()
+	batch := benchmark.Batch(t, 100, codec.FormatJSON)
+	benchmark.AssertMaxAllocs(t, 10000, func() {
+		if err := classifier.processBatch(context.Background(), benchmark.Copy(batch), cfg); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package cl
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Suffixes of the fields a hashed PII field is replaced with. The salt
+// version lets a hash be verified after the salt has been rotated.
+const (
+	hashFieldSuffix        = "_hash"
+	saltVersionFieldSuffix = "_hash_salt_ver"
+)
+
+// SaltLoader reads a salt value from a key of a Kubernetes secret
+type SaltLoader func(ctx context.Context, secretName, secretKey string) (string, error)
+
+// simulatedSaltLoader stands in for reading the Kubernetes secret
+func simulatedSaltLoader(ctx context.Context, secretName, secretKey string) (string, error) {
+	// In a real implementation, this would read from a Kubernetes secret
+	// For now, we'll just use a simulated value
+	return "simulated-salt-value-" + secretKey + "-" + time.Now().Format(time.RFC3339), nil
+}
+
+// SetSaltLoader replaces how salt values are read from the secret
+func (c *Classifier) SetSaltLoader(loader SaltLoader) {
+	c.saltMu.Lock()
+	defer c.saltMu.Unlock()
+	c.saltLoader = loader
+}
+
+// saltSecretKeyFor returns the secret key holding a salt version. Versioned
+// salts are stored under "<salt secret key>-<version>"; the unversioned salt
+// under the salt secret key itself.
+func (c *Classifier) saltSecretKeyFor(version string) string {
+	if version == "" {
+		return c.saltSecretKey
+	}
+	return c.saltSecretKey + "-" + version
+}
+
+// loadSalt loads the current salt version from the Kubernetes secret. The
+// configured historical versions, and the version being replaced, are kept
+// so hashes produced before a rotation can still be verified.
+func (c *Classifier) loadSalt(ctx context.Context) error {
+	version := ""
+	var historical []string
+	c.configMu.RLock()
+	if c.config != nil {
+		version = c.config.SaltVersion
+		historical = c.config.HistoricalSaltVersions
+	}
+	c.configMu.RUnlock()
+
+	c.saltMu.RLock()
+	loader := c.saltLoader
+	previousVersion := c.saltVersion
+	previous := c.salts
+	c.saltMu.RUnlock()
+
+	if loader == nil {
+		loader = simulatedSaltLoader
+	}
+
+	salt, err := loader(ctx, c.saltSecretName, c.saltSecretKeyFor(version))
+	if err != nil {
+		return fmt.Errorf("failed to load salt version %q: %w", version, err)
+	}
+
+	salts := map[string]string{version: salt}
+	if previousSalt, ok := previous[previousVersion]; ok && previousVersion != version {
+		salts[previousVersion] = previousSalt
+	}
+	for _, historicalVersion := range historical {
+		if _, ok := salts[historicalVersion]; ok {
+			continue
+		}
+		if historicalSalt, ok := previous[historicalVersion]; ok {
+			salts[historicalVersion] = historicalSalt
+			continue
+		}
+		historicalSalt, err := loader(ctx, c.saltSecretName, c.saltSecretKeyFor(historicalVersion))
+		if err != nil {
+			// A missing historical salt only prevents verifying old hashes
+			c.logger.Error("Failed to load historical salt", err, map[string]interface{}{
+				"salt_secret_name": c.saltSecretName,
+				"salt_version":     historicalVersion,
+			})
+			continue
+		}
+		salts[historicalVersion] = historicalSalt
+	}
+
+	c.saltMu.Lock()
+	c.salt = salt
+	c.saltVersion = version
+	c.salts = salts
+	c.saltMu.Unlock()
+
+	c.logger.Info("Loaded salt value", map[string]interface{}{
+		"salt_secret_name": c.saltSecretName,
+		"salt_secret_key":  c.saltSecretKeyFor(version),
+		"salt_version":     version,
+		"salt_versions":    len(salts),
+		// Don't log the actual salt value in production!
+	})
+
+	return nil
+}
+
+// SaltVersions returns the salt versions hashes can be verified against
+func (c *Classifier) SaltVersions() []string {
+	c.saltMu.RLock()
+	defer c.saltMu.RUnlock()
+
+	versions := make([]string, 0, len(c.salts))
+	for version := range c.salts {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// hashPIIFields replaces the PII fields of a metric's fields or labels with
+// "<field>_hash", hashed with the current salt, and "<field>_hash_salt_ver",
+// the version of that salt. It returns the names of the fields it hashed.
+func (c *Classifier) hashPIIFields(fields map[string]interface{}, piiFields []string) []string {
+	c.saltMu.RLock()
+	salt, version := c.salt, c.saltVersion
+	c.saltMu.RUnlock()
+
+	return c.hashFields(fields, piiFields, salt, version)
+}
+
+// hashFields hashes the PII fields with the given salt, as hashPIIFields does
+func (c *Classifier) hashFields(fields map[string]interface{}, piiFields []string, salt, version string) []string {
+	var hashed []string
+	for _, field := range piiFields {
+		value, ok := fields[field]
+		if !ok {
+			continue
+		}
+		fields[field+hashFieldSuffix] = c.hashPIIValue(fmt.Sprint(value), salt)
+		fields[field+saltVersionFieldSuffix] = version
+		delete(fields, field)
+		hashed = append(hashed, field)
+	}
+	return hashed
+}
+
+// VerifyPIIHash reports whether hash is the hash of value under the given
+// salt version. It returns false if that version is not loaded.
+func (c *Classifier) VerifyPIIHash(value, hash, version string) bool {
+	c.saltMu.RLock()
+	salt, ok := c.salts[version]
+	c.saltMu.RUnlock()
+
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.hashPIIValue(value, salt)), []byte(hash)) == 1
+}
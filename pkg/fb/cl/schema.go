@@ -0,0 +1,52 @@
+package cl
+
+import (
+	"eidc-tfk8s/internal/config"
+)
+
+// parametersSchema is the JSON Schema of ClassifierConfig
+const parametersSchema = `{
+	"type": "object",
+	"properties": {
+		"pii_fields": {
+			"type": "array",
+			"items": {"type": "string"}
+		},
+		"salt_secret_name": {"type": "string"},
+		"salt_secret_key": {"type": "string"},
+		"hash_algorithm": {"type": "string", "enum": ["", "sha256"]},
+		"salt_version": {"type": "string"},
+		"historical_salt_versions": {
+			"type": "array",
+			"items": {"type": "string"}
+		}
+	}
+}`
+
+func init() {
+	config.RegisterParametersSchema("fb-cl", parametersSchema)
+}
+
+// DefaultConfig returns the CL configuration hashing the usual PII fields
+func DefaultConfig() ClassifierConfig {
+	return ClassifierConfig{
+		Common: config.FBConfig{
+			LogLevel:           "info",
+			MetricsEnabled:     true,
+			TracingEnabled:     true,
+			TraceSamplingRatio: 0.1,
+			CircuitBreaker: config.CircuitBreakerConfig{
+				ErrorThresholdPercentage: 50,
+				OpenStateSeconds:         30,
+				HalfOpenRequestThreshold: 5,
+			},
+		},
+		PIIFields: []string{
+			"command_line",
+			"user_name",
+			"email",
+			"ip_address",
+		},
+		HashAlgorithm: "sha256",
+	}
+}
@@ -0,0 +1,368 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the internal protobuf format. A batch is a message of
+// repeated Value (field 1), each metric a Value holding a Map. A Value sets
+// exactly one of its fields; a Map is repeated Entry (field 1) of key
+// (field 1) and Value (field 2), a List repeated Value (field 1).
+const (
+	batchMetricField = 1
+
+	valueStringField = 1
+	valueIntField    = 2
+	valueDoubleField = 3
+	valueBoolField   = 4
+	valueNullField   = 5
+	valueMapField    = 6
+	valueListField   = 7
+	valueNumberField = 8
+
+	mapEntryField   = 1
+	entryKeyField   = 1
+	entryValueField = 2
+	listValueField  = 1
+)
+
+// errMalformedBinary is returned for internal protobuf data that doesn't
+// parse
+var errMalformedBinary = errors.New("malformed internal protobuf data")
+
+// binaryCodec encodes internal metrics in the protobuf wire format, which
+// FBs decode and encode in a fraction of the CPU time of JSON. Numbers keep
+// their exact text, as json.Number does: integers and doubles whose text
+// round-trips are stored natively, any other number as its text.
+type binaryCodec struct{}
+
+// Decode implements Codec
+func (binaryCodec) Decode(data []byte) ([]Metric, error) {
+	var metrics []Metric
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 || num != batchMetricField || typ != protowire.BytesType {
+			return nil, errMalformedBinary
+		}
+		data = data[n:]
+
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		data = data[n:]
+
+		decoded, err := decodeValue(value)
+		if err != nil {
+			return nil, err
+		}
+		metric, ok := decoded.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("metric %d is not an object", len(metrics))
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// Encode implements Encoder
+func (binaryCodec) Encode(metrics []Metric) ([]byte, error) {
+	var data []byte
+	for i, metric := range metrics {
+		var start int
+		var err error
+		data, start = beginMessage(data, batchMetricField)
+		if data, err = appendValue(data, metric); err != nil {
+			return nil, fmt.Errorf("metric %d: %w", i, err)
+		}
+		data = endMessage(data, start)
+	}
+	return data, nil
+}
+
+// beginMessage appends the tag of an embedded message field, returning the
+// offset its content starts at. Content is appended to the buffer in place,
+// then prefixed with its length by endMessage, so nesting doesn't allocate a
+// buffer per message.
+func beginMessage(b []byte, num protowire.Number) ([]byte, int) {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return b, len(b)
+}
+
+// endMessage inserts the length of the message content starting at start
+func endMessage(b []byte, start int) []byte {
+	n := len(b) - start
+	size := protowire.SizeVarint(uint64(n))
+	for i := 0; i < size; i++ {
+		b = append(b, 0)
+	}
+	copy(b[start+size:], b[start:start+n])
+	protowire.AppendVarint(b[start:start], uint64(n))
+	return b
+}
+
+// appendValue appends the Value message encoding v to b
+func appendValue(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		b = protowire.AppendTag(b, valueNullField, protowire.VarintType)
+		return protowire.AppendVarint(b, 0), nil
+	case string:
+		b = protowire.AppendTag(b, valueStringField, protowire.BytesType)
+		return protowire.AppendString(b, v), nil
+	case bool:
+		b = protowire.AppendTag(b, valueBoolField, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeBool(v)), nil
+	case json.Number:
+		return appendNumber(b, string(v)), nil
+	case float64:
+		return appendDouble(b, v), nil
+	case float32:
+		return appendDouble(b, float64(v)), nil
+	case int:
+		return appendInt(b, int64(v)), nil
+	case int64:
+		return appendInt(b, v), nil
+	case int32:
+		return appendInt(b, int64(v)), nil
+	case uint64:
+		return appendNumber(b, strconv.FormatUint(v, 10)), nil
+	case map[string]interface{}:
+		return appendMap(b, mapKeys(v), func(key string) interface{} { return v[key] })
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		return appendMap(b, keys, func(key string) interface{} { return v[key] })
+	case []interface{}:
+		return appendList(b, len(v), func(i int) interface{} { return v[i] })
+	case []string:
+		return appendList(b, len(v), func(i int) interface{} { return v[i] })
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// appendNumber appends a number, natively if its text survives the round
+// trip and as text otherwise
+func appendNumber(b []byte, text string) []byte {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil && strconv.FormatInt(i, 10) == text {
+		return appendInt(b, i)
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil && strconv.FormatFloat(f, 'g', -1, 64) == text {
+		return appendDouble(b, f)
+	}
+	b = protowire.AppendTag(b, valueNumberField, protowire.BytesType)
+	return protowire.AppendString(b, text)
+}
+
+func appendInt(b []byte, i int64) []byte {
+	b = protowire.AppendTag(b, valueIntField, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeZigZag(i))
+}
+
+func appendDouble(b []byte, f float64) []byte {
+	b = protowire.AppendTag(b, valueDoubleField, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(f))
+}
+
+// mapKeys returns the keys of m
+func mapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// appendMap appends a Map value. Entries are sorted by key, so the same
+// metrics always encode to the same bytes.
+func appendMap(b []byte, keys []string, get func(string) interface{}) ([]byte, error) {
+	sort.Strings(keys)
+
+	b, mapStart := beginMessage(b, valueMapField)
+	for _, key := range keys {
+		var entryStart, valueStart int
+		var err error
+		b, entryStart = beginMessage(b, mapEntryField)
+		b = protowire.AppendTag(b, entryKeyField, protowire.BytesType)
+		b = protowire.AppendString(b, key)
+		b, valueStart = beginMessage(b, entryValueField)
+		if b, err = appendValue(b, get(key)); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		b = endMessage(b, valueStart)
+		b = endMessage(b, entryStart)
+	}
+	return endMessage(b, mapStart), nil
+}
+
+// appendList appends a List value
+func appendList(b []byte, size int, get func(int) interface{}) ([]byte, error) {
+	b, listStart := beginMessage(b, valueListField)
+	for i := 0; i < size; i++ {
+		var valueStart int
+		var err error
+		b, valueStart = beginMessage(b, listValueField)
+		if b, err = appendValue(b, get(i)); err != nil {
+			return nil, fmt.Errorf("[%d]: %w", i, err)
+		}
+		b = endMessage(b, valueStart)
+	}
+	return endMessage(b, listStart), nil
+}
+
+// decodeValue decodes a Value message
+func decodeValue(data []byte) (interface{}, error) {
+	num, typ, n := protowire.ConsumeTag(data)
+	if n < 0 {
+		return nil, errMalformedBinary
+	}
+	data = data[n:]
+
+	var value interface{}
+	switch {
+	case num == valueStringField && typ == protowire.BytesType:
+		s, n := protowire.ConsumeString(data)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		value, data = s, data[n:]
+	case num == valueIntField && typ == protowire.VarintType:
+		v, n := protowire.ConsumeVarint(data)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		value, data = json.Number(strconv.FormatInt(protowire.DecodeZigZag(v), 10)), data[n:]
+	case num == valueDoubleField && typ == protowire.Fixed64Type:
+		v, n := protowire.ConsumeFixed64(data)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		value, data = json.Number(strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)), data[n:]
+	case num == valueBoolField && typ == protowire.VarintType:
+		v, n := protowire.ConsumeVarint(data)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		value, data = protowire.DecodeBool(v), data[n:]
+	case num == valueNullField && typ == protowire.VarintType:
+		_, n := protowire.ConsumeVarint(data)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		value, data = nil, data[n:]
+	case num == valueNumberField && typ == protowire.BytesType:
+		s, n := protowire.ConsumeString(data)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		value, data = json.Number(s), data[n:]
+	case num == valueMapField && typ == protowire.BytesType:
+		entries, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		m, err := decodeMap(entries)
+		if err != nil {
+			return nil, err
+		}
+		value, data = m, data[n:]
+	case num == valueListField && typ == protowire.BytesType:
+		values, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		list, err := decodeList(values)
+		if err != nil {
+			return nil, err
+		}
+		value, data = list, data[n:]
+	default:
+		return nil, errMalformedBinary
+	}
+
+	if len(data) > 0 {
+		return nil, errMalformedBinary
+	}
+	return value, nil
+}
+
+// decodeMap decodes the entries of a Map value
+func decodeMap(data []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 || num != mapEntryField || typ != protowire.BytesType {
+			return nil, errMalformedBinary
+		}
+		data = data[n:]
+
+		entry, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		data = data[n:]
+
+		num, typ, n = protowire.ConsumeTag(entry)
+		if n < 0 || num != entryKeyField || typ != protowire.BytesType {
+			return nil, errMalformedBinary
+		}
+		entry = entry[n:]
+		key, n := protowire.ConsumeString(entry)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		entry = entry[n:]
+
+		num, typ, n = protowire.ConsumeTag(entry)
+		if n < 0 || num != entryValueField || typ != protowire.BytesType {
+			return nil, errMalformedBinary
+		}
+		entry = entry[n:]
+		encoded, n := protowire.ConsumeBytes(entry)
+		if n < 0 || n != len(entry) {
+			return nil, errMalformedBinary
+		}
+
+		value, err := decodeValue(encoded)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// decodeList decodes the values of a List value
+func decodeList(data []byte) ([]interface{}, error) {
+	list := make([]interface{}, 0)
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 || num != listValueField || typ != protowire.BytesType {
+			return nil, errMalformedBinary
+		}
+		data = data[n:]
+
+		encoded, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, errMalformedBinary
+		}
+		data = data[n:]
+
+		value, err := decodeValue(encoded)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
@@ -0,0 +1,108 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"eidc-tfk8s/pkg/fb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeAs_InternalProtobufRoundTrips(t *testing.T) {
+	metrics, err := Decode(&fb.MetricBatch{
+		Data: []byte(`[
+			{"name":"bytes_total","value":9007199254740993,"timestamp":1700000000.123456789,"labels":{"host":"node-1"}},
+			{"name":"cpu_usage","value":0.75,"timestamp":1700000000,"labels":{},"exemplar":null,"buckets":[1,2.5,"inf"],"monotonic":true},
+			{"name":"huge","value":1e400,"timestamp":-1.0}
+		]`),
+		Format: FormatJSON,
+	})
+	require.NoError(t, err)
+
+	batch := &fb.MetricBatch{InternalLabels: map[string]string{fb.LabelChecksum: ""}}
+	require.NoError(t, EncodeAs(batch, metrics, FormatInternalProtobuf))
+	assert.Equal(t, FormatInternalProtobuf, batch.Format)
+	assert.Equal(t, fb.Checksum(batch.Data), batch.InternalLabels[fb.LabelChecksum])
+
+	// Numbers keep their exact text, whether stored natively or not
+	decoded, err := Decode(batch)
+	require.NoError(t, err)
+	assert.Equal(t, metrics, decoded)
+
+	// FBs rewriting the batch keep it in the internal format
+	require.NoError(t, Encode(batch, decoded[:1]))
+	assert.Equal(t, FormatInternalProtobuf, batch.Format)
+	decoded, err = Decode(batch)
+	require.NoError(t, err)
+	assert.Equal(t, metrics[:1], decoded)
+}
+
+func TestEncodeAs_InternalProtobufIsDeterministic(t *testing.T) {
+	metric := Metric{"name": "cpu", "labels": map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}}
+
+	first := &fb.MetricBatch{}
+	require.NoError(t, EncodeAs(first, []Metric{metric}, FormatInternalProtobuf))
+	for i := 0; i < 10; i++ {
+		batch := &fb.MetricBatch{}
+		require.NoError(t, EncodeAs(batch, []Metric{metric}, FormatInternalProtobuf))
+		assert.Equal(t, first.Data, batch.Data)
+	}
+}
+
+func TestEncodeAs_Errors(t *testing.T) {
+	batch := &fb.MetricBatch{}
+
+	// Ingest formats aren't encoded to
+	assert.Error(t, EncodeAs(batch, nil, FormatOTLPProtobuf))
+	assert.ErrorIs(t, EncodeAs(batch, nil, "msgpack"), ErrUnknownFormat)
+	assert.Error(t, EncodeAs(batch, []Metric{{"value": struct{}{}}}, FormatInternalProtobuf))
+
+	_, err := Decode(&fb.MetricBatch{Data: []byte{0x0a, 0xff}, Format: FormatInternalProtobuf})
+	assert.Error(t, err)
+}
+
+// benchmarkMetrics returns a representative batch of decoded metrics
+func benchmarkMetrics(b *testing.B) []Metric {
+	var metrics []Metric
+	for i := 0; i < 500; i++ {
+		metrics = append(metrics, Metric{
+			"name":      fmt.Sprintf("http_requests_total_%d", i%20),
+			"value":     json.Number(fmt.Sprint(i * 1000)),
+			"timestamp": json.Number("1700000000.123456789"),
+			"labels": map[string]interface{}{
+				"host":      fmt.Sprintf("node-%d", i%10),
+				"namespace": "default",
+				"pod":       fmt.Sprintf("frontend-%d", i),
+				"code":      "200",
+				"method":    "GET",
+			},
+		})
+	}
+	return metrics
+}
+
+// BenchmarkCodec measures an FB's decode and re-encode of a batch in each
+// format FBs exchange
+func BenchmarkCodec(b *testing.B) {
+	for _, format := range []string{FormatJSON, FormatInternalProtobuf} {
+		b.Run(format, func(b *testing.B) {
+			batch := &fb.MetricBatch{}
+			require.NoError(b, EncodeAs(batch, benchmarkMetrics(b), format))
+			b.SetBytes(int64(len(batch.Data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				metrics, err := Decode(batch)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := Encode(&fb.MetricBatch{Format: format}, metrics); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
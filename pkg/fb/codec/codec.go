@@ -0,0 +1,168 @@
+// Package codec decodes batch data into the internal metric representation
+// shared by the function blocks, whatever wire format the batch arrived in.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"eidc-tfk8s/pkg/fb"
+)
+
+// Supported batch formats
+const (
+	// FormatJSON is a JSON array of internal metrics
+	FormatJSON = "json"
+
+	// FormatOTLPProtobuf is a protobuf-encoded OTLP ExportMetricsServiceRequest
+	FormatOTLPProtobuf = "otlp/protobuf"
+
+	// FormatInternalProtobuf is internal metrics in the protobuf wire format,
+	// cheaper to decode and encode than JSON. Only FBs exchange it.
+	FormatInternalProtobuf = "internal/protobuf"
+)
+
+// ErrUnknownFormat is returned when a batch's format has no registered codec
+var ErrUnknownFormat = errors.New("unknown batch format")
+
+// Metric is the internal metric representation: a "name", "value",
+// "timestamp" (Unix seconds) and "labels" map, plus any other fields.
+// Numbers are json.Number, so 64-bit counters keep their precision.
+type Metric = map[string]interface{}
+
+// Codec decodes serialized batch data into internal metrics
+type Codec interface {
+	Decode(data []byte) ([]Metric, error)
+}
+
+// Encoder is implemented by the codecs of the formats batches can travel the
+// chain in, which FBs rewriting a batch encode it back to
+type Encoder interface {
+	Encode(metrics []Metric) ([]byte, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Codec{
+		FormatJSON:             jsonCodec{},
+		FormatOTLPProtobuf:     otlpProtobufCodec{},
+		FormatInternalProtobuf: binaryCodec{},
+	}
+)
+
+// Register adds a codec for a format, replacing any existing one
+func Register(format string, codec Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[format] = codec
+}
+
+// Lookup returns the codec registered for a format
+func Lookup(format string) (Codec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	codec, ok := registry[format]
+	return codec, ok
+}
+
+// Decode decodes the batch data using the codec registered for its format.
+// Batches without a format are detected from their content.
+func Decode(batch *fb.MetricBatch) ([]Metric, error) {
+	format := batch.Format
+	if format == "" {
+		format = DetectFormat(batch.Data)
+	}
+
+	codec, ok := Lookup(format)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+
+	metrics, err := codec.Decode(batch.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s batch: %w", format, err)
+	}
+	return metrics, nil
+}
+
+// Encode replaces the batch data with metrics, refreshing its checksum.
+// Batches already in a format FBs exchange, JSON or internal protobuf, stay
+// in it; once decoded, batches in an ingest format travel the rest of the
+// chain as JSON.
+func Encode(batch *fb.MetricBatch, metrics []Metric) error {
+	format := FormatJSON
+	if codec, ok := Lookup(batch.Format); ok {
+		if _, ok := codec.(Encoder); ok {
+			format = batch.Format
+		}
+	}
+	return EncodeAs(batch, metrics, format)
+}
+
+// EncodeAs replaces the batch data with metrics encoded in format, refreshing
+// its checksum
+func EncodeAs(batch *fb.MetricBatch, metrics []Metric, format string) error {
+	codec, ok := Lookup(format)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	encoder, ok := codec.(Encoder)
+	if !ok {
+		return fmt.Errorf("batches can't be encoded as %s", format)
+	}
+
+	data, err := encoder.Encode(metrics)
+	if err != nil {
+		return fmt.Errorf("failed to serialize metrics: %w", err)
+	}
+	batch.Data = data
+	batch.Format = format
+	fb.RefreshChecksum(batch)
+	return nil
+}
+
+// DetectFormat guesses the format of untagged data: JSON if it starts with
+// an array or object, OTLP protobuf otherwise
+func DetectFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return FormatJSON
+	}
+	return FormatOTLPProtobuf
+}
+
+// jsonCodec decodes and encodes a JSON array of internal metrics
+type jsonCodec struct{}
+
+// Decode implements Codec
+func (jsonCodec) Decode(data []byte) ([]Metric, error) {
+	var metrics []Metric
+	if err := UnmarshalJSON(data, &metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// Encode implements Encoder
+func (jsonCodec) Encode(metrics []Metric) ([]byte, error) {
+	return json.Marshal(metrics)
+}
+
+// UnmarshalJSON is json.Unmarshal, except that numbers decoded into
+// interface values are json.Number instead of float64. A float64 only holds
+// integers up to 2^53 exactly, so large counters would silently change.
+func UnmarshalJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid JSON: unexpected data after top-level value")
+	}
+	return nil
+}
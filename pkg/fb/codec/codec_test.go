@@ -0,0 +1,225 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"eidc-tfk8s/pkg/fb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const jsonMetrics = `[
+	{"name":"cpu_usage","value":0.75,"timestamp":1700000000,"labels":{"host":"node-1","cpu":"0"}},
+	{"name":"requests_total","value":42,"timestamp":1700000060,"labels":{"host":"node-1","code":"200"}}
+]`
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+// otlpMetrics is the protobuf encoding of jsonMetrics
+func otlpMetrics(t *testing.T) []byte {
+	data, err := proto.Marshal(&metricspb.MetricsData{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{
+				Attributes: []*commonpb.KeyValue{stringAttribute("host", "node-1")},
+			},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{
+					{
+						Name: "cpu_usage",
+						Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+							DataPoints: []*metricspb.NumberDataPoint{{
+								TimeUnixNano: 1700000000e9,
+								Attributes:   []*commonpb.KeyValue{stringAttribute("cpu", "0")},
+								Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 0.75},
+							}},
+						}},
+					},
+					{
+						Name: "requests_total",
+						Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+							IsMonotonic: true,
+							DataPoints: []*metricspb.NumberDataPoint{{
+								TimeUnixNano: 1700000060e9,
+								Attributes:   []*commonpb.KeyValue{stringAttribute("code", "200")},
+								Value:        &metricspb.NumberDataPoint_AsInt{AsInt: 42},
+							}},
+						}},
+					},
+				},
+			}},
+		}},
+	})
+	require.NoError(t, err)
+	return data
+}
+
+func TestDecode_JSONAndProtobufAreIdentical(t *testing.T) {
+	fromJSON, err := Decode(&fb.MetricBatch{Data: []byte(jsonMetrics), Format: FormatJSON})
+	require.NoError(t, err)
+
+	fromProtobuf, err := Decode(&fb.MetricBatch{Data: otlpMetrics(t), Format: FormatOTLPProtobuf})
+	require.NoError(t, err)
+
+	require.Len(t, fromJSON, 2)
+	assert.Equal(t, fromJSON, fromProtobuf)
+}
+
+func TestDecode_DetectsUntaggedFormats(t *testing.T) {
+	fromJSON, err := Decode(&fb.MetricBatch{Data: []byte(jsonMetrics)})
+	require.NoError(t, err)
+
+	fromProtobuf, err := Decode(&fb.MetricBatch{Data: otlpMetrics(t)})
+	require.NoError(t, err)
+
+	assert.Equal(t, fromJSON, fromProtobuf)
+}
+
+func TestDecode_UnknownFormat(t *testing.T) {
+	_, err := Decode(&fb.MetricBatch{Data: []byte("cpu_usage 0.75"), Format: "prometheus"})
+	assert.True(t, errors.Is(err, ErrUnknownFormat))
+}
+
+func TestEncode_WritesJSON(t *testing.T) {
+	batch := &fb.MetricBatch{Data: otlpMetrics(t), Format: FormatOTLPProtobuf}
+	metrics, err := Decode(batch)
+	require.NoError(t, err)
+
+	require.NoError(t, Encode(batch, metrics))
+	assert.Equal(t, FormatJSON, batch.Format)
+
+	roundTripped, err := Decode(batch)
+	require.NoError(t, err)
+	assert.Equal(t, metrics, roundTripped)
+}
+
+// mixedOTLPMetrics is otlpMetrics followed by a resource with a histogram
+// scope and a gauge scope, and a malformed resource
+func mixedOTLPMetrics(t *testing.T) []byte {
+	resource, err := proto.Marshal(&metricspb.ResourceMetrics{
+		Resource: &resourcepb.Resource{
+			Attributes: []*commonpb.KeyValue{stringAttribute("host", "node-2")},
+		},
+		ScopeMetrics: []*metricspb.ScopeMetrics{
+			{
+				Metrics: []*metricspb.Metric{{
+					Name: "request_duration_seconds",
+					Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+						DataPoints: []*metricspb.HistogramDataPoint{
+							{TimeUnixNano: 1700000000e9, Count: 3},
+							{TimeUnixNano: 1700000001e9, Count: 5},
+						},
+					}},
+				}},
+			},
+			{
+				Metrics: []*metricspb.Metric{{
+					Name: "memory_usage",
+					Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+						DataPoints: []*metricspb.NumberDataPoint{{
+							TimeUnixNano: 1700000000e9,
+							Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 512},
+						}},
+					}},
+				}},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	data := otlpMetrics(t)
+	data = appendField(data, metricsDataResourceMetricsField, resource)
+	return appendField(data, metricsDataResourceMetricsField, []byte{0x0a, 0xff})
+}
+
+func TestDecodeOTLPPartial_ReturnsFailedFragments(t *testing.T) {
+	data := mixedOTLPMetrics(t)
+
+	_, err := Decode(&fb.MetricBatch{Data: data, Format: FormatOTLPProtobuf})
+	require.Error(t, err)
+
+	metrics, fragments, err := DecodeOTLPPartial(data)
+	require.NoError(t, err)
+
+	require.Len(t, metrics, 3)
+	assert.Equal(t, "cpu_usage", metrics[0]["name"])
+	assert.Equal(t, "requests_total", metrics[1]["name"])
+	assert.Equal(t, "memory_usage", metrics[2]["name"])
+	assert.Equal(t, map[string]interface{}{"host": "node-2"}, metrics[2]["labels"])
+
+	require.Len(t, fragments, 2)
+	assert.Equal(t, 1, fragments[0].ResourceIndex)
+	assert.Equal(t, 0, fragments[0].ScopeIndex)
+	assert.Contains(t, fragments[0].Err.Error(), "unsupported OTLP metric type")
+	assert.Equal(t, 2, fragments[0].DataPoints)
+	assert.Equal(t, 2, fragments[1].ResourceIndex)
+	assert.Equal(t, -1, fragments[1].ScopeIndex)
+	assert.Equal(t, 0, fragments[1].DataPoints)
+
+	// A failed scope is kept with its resource
+	var fragment metricspb.MetricsData
+	require.NoError(t, proto.Unmarshal(fragments[0].Data, &fragment))
+	require.Len(t, fragment.ResourceMetrics, 1)
+	assert.Equal(t, "node-2", fragment.ResourceMetrics[0].Resource.Attributes[0].Value.GetStringValue())
+	require.Len(t, fragment.ResourceMetrics[0].ScopeMetrics, 1)
+	assert.Equal(t, "request_duration_seconds", fragment.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Name)
+}
+
+func TestDecode_PreservesInt64Precision(t *testing.T) {
+	// 2^53 + 1 has no exact float64 representation
+	const counter = 9007199254740993
+
+	fromJSON, err := Decode(&fb.MetricBatch{
+		Data:   []byte(`[{"name":"bytes_total","value":9007199254740993,"timestamp":1700000000.123456789}]`),
+		Format: FormatJSON,
+	})
+	require.NoError(t, err)
+
+	data, err := proto.Marshal(&metricspb.MetricsData{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{{
+					Name: "bytes_total",
+					Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+						DataPoints: []*metricspb.NumberDataPoint{{
+							TimeUnixNano: 1700000000123456789,
+							Value:        &metricspb.NumberDataPoint_AsInt{AsInt: counter},
+						}},
+					}},
+				}},
+			}},
+		}},
+	})
+	require.NoError(t, err)
+	fromProtobuf, err := Decode(&fb.MetricBatch{Data: data, Format: FormatOTLPProtobuf})
+	require.NoError(t, err)
+
+	for _, metrics := range [][]Metric{fromJSON, fromProtobuf} {
+		require.Len(t, metrics, 1)
+		value, err := metrics[0]["value"].(json.Number).Int64()
+		require.NoError(t, err)
+		assert.Equal(t, int64(counter), value)
+		assert.Equal(t, json.Number("1700000000.123456789"), metrics[0]["timestamp"])
+
+		// The counter survives re-encoding for the next function block
+		batch := &fb.MetricBatch{}
+		require.NoError(t, Encode(batch, metrics))
+		assert.Contains(t, string(batch.Data), `"value":9007199254740993`)
+	}
+}
+
+func TestUnmarshalJSON_RejectsTrailingData(t *testing.T) {
+	var metrics []Metric
+	assert.Error(t, UnmarshalJSON([]byte(`[] []`), &metrics))
+	assert.NoError(t, UnmarshalJSON([]byte("[]\n"), &metrics))
+}
@@ -0,0 +1,312 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Field numbers of the OTLP messages split by DecodeOTLPPartial
+const (
+	metricsDataResourceMetricsField = 1
+	resourceMetricsResourceField    = 1
+	resourceMetricsScopeField       = 2
+)
+
+// otlpProtobufCodec decodes protobuf-encoded OTLP metrics. Gauge and sum data
+// points each become one internal metric, labelled with the resource
+// attributes overlaid with the data point attributes.
+type otlpProtobufCodec struct{}
+
+// Decode implements Codec. MetricsData shares its wire layout with the
+// collector's ExportMetricsServiceRequest, so either message decodes.
+func (otlpProtobufCodec) Decode(data []byte) ([]Metric, error) {
+	var request metricspb.MetricsData
+	if err := proto.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+
+	var metrics []Metric
+	for _, resourceMetrics := range request.GetResourceMetrics() {
+		resourceLabels := attributesToLabels(nil, resourceMetrics.GetResource().GetAttributes())
+
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			scopeMetricsDecoded, err := decodeScopeMetrics(scopeMetrics, resourceLabels)
+			if err != nil {
+				return nil, err
+			}
+			metrics = append(metrics, scopeMetricsDecoded...)
+		}
+	}
+
+	return metrics, nil
+}
+
+// decodeScopeMetrics converts the metrics of a scope to internal metrics
+func decodeScopeMetrics(scopeMetrics *metricspb.ScopeMetrics, resourceLabels map[string]interface{}) ([]Metric, error) {
+	var metrics []Metric
+	for _, metric := range scopeMetrics.GetMetrics() {
+		var dataPoints []*metricspb.NumberDataPoint
+		switch data := metric.GetData().(type) {
+		case *metricspb.Metric_Gauge:
+			dataPoints = data.Gauge.GetDataPoints()
+		case *metricspb.Metric_Sum:
+			dataPoints = data.Sum.GetDataPoints()
+		default:
+			return nil, fmt.Errorf("metric %s: unsupported OTLP metric type %T", metric.GetName(), data)
+		}
+
+		for _, dataPoint := range dataPoints {
+			metrics = append(metrics, numberDataPointToMetric(metric.GetName(), resourceLabels, dataPoint))
+		}
+	}
+	return metrics, nil
+}
+
+// Fragment is a part of an OTLP payload that failed to decode
+type Fragment struct {
+	// ResourceIndex is the position of the resource metrics in the payload
+	ResourceIndex int
+
+	// ScopeIndex is the position of the scope metrics in the resource
+	// metrics, or -1 if the resource metrics failed as a whole
+	ScopeIndex int
+
+	// Data is an OTLP payload holding only the failed part, so it can be
+	// sent on and replayed like any other batch
+	Data []byte
+
+	// DataPoints is the number of data points in Data, or 0 if Data is too
+	// malformed to count them
+	DataPoints int
+
+	Err error
+}
+
+// DecodeOTLPPartial decodes protobuf-encoded OTLP metrics resource by
+// resource and scope by scope, so a malformed or unsupported part doesn't
+// fail the rest of the payload. The parts that failed are returned as
+// fragments. An error is returned only if the payload can't be split into
+// resource metrics at all.
+func DecodeOTLPPartial(data []byte) ([]Metric, []Fragment, error) {
+	resources, err := splitFields(data, metricsDataResourceMetricsField)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var metrics []Metric
+	var fragments []Fragment
+	for resourceIndex, resourceData := range resources {
+		resourceDecoded, resourceFragments := decodeResourceMetricsPartial(resourceIndex, resourceData)
+		metrics = append(metrics, resourceDecoded...)
+		fragments = append(fragments, resourceFragments...)
+	}
+
+	return metrics, fragments, nil
+}
+
+// decodeResourceMetricsPartial decodes the scope metrics of serialized
+// resource metrics one at a time
+func decodeResourceMetricsPartial(resourceIndex int, data []byte) ([]Metric, []Fragment) {
+	resourceData, err := splitFields(data, resourceMetricsResourceField)
+	if err != nil {
+		return nil, []Fragment{resourceFragment(resourceIndex, data, err)}
+	}
+	scopes, err := splitFields(data, resourceMetricsScopeField)
+	if err != nil {
+		return nil, []Fragment{resourceFragment(resourceIndex, data, err)}
+	}
+
+	// The resource is a singular field, so the last occurrence wins
+	var resource resourcepb.Resource
+	if len(resourceData) > 0 {
+		if err := proto.Unmarshal(resourceData[len(resourceData)-1], &resource); err != nil {
+			return nil, []Fragment{resourceFragment(resourceIndex, data, fmt.Errorf("resource: %w", err))}
+		}
+	}
+	resourceLabels := attributesToLabels(nil, resource.GetAttributes())
+
+	var metrics []Metric
+	var fragments []Fragment
+	for scopeIndex, scopeData := range scopes {
+		var scopeMetrics metricspb.ScopeMetrics
+		err := proto.Unmarshal(scopeData, &scopeMetrics)
+		if err == nil {
+			var scopeDecoded []Metric
+			if scopeDecoded, err = decodeScopeMetrics(&scopeMetrics, resourceLabels); err == nil {
+				metrics = append(metrics, scopeDecoded...)
+				continue
+			}
+		}
+
+		// Keep the resource with the failed scope so it can be replayed
+		var fragmentData []byte
+		for _, resourceField := range resourceData {
+			fragmentData = appendField(fragmentData, resourceMetricsResourceField, resourceField)
+		}
+		fragmentData = appendField(fragmentData, resourceMetricsScopeField, scopeData)
+		fragmentData = appendField(nil, metricsDataResourceMetricsField, fragmentData)
+		fragments = append(fragments, Fragment{
+			ResourceIndex: resourceIndex,
+			ScopeIndex:    scopeIndex,
+			Data:          fragmentData,
+			DataPoints:    countDataPoints(fragmentData),
+			Err:           fmt.Errorf("resource %d scope %d: %w", resourceIndex, scopeIndex, err),
+		})
+	}
+
+	return metrics, fragments
+}
+
+// resourceFragment wraps serialized resource metrics that failed as a whole
+func resourceFragment(resourceIndex int, data []byte, err error) Fragment {
+	fragmentData := appendField(nil, metricsDataResourceMetricsField, data)
+	return Fragment{
+		ResourceIndex: resourceIndex,
+		ScopeIndex:    -1,
+		Data:          fragmentData,
+		DataPoints:    countDataPoints(fragmentData),
+		Err:           fmt.Errorf("resource %d: %w", resourceIndex, err),
+	}
+}
+
+// countDataPoints counts the data points of every metric type in
+// protobuf-encoded OTLP metrics, returning 0 if they can't be unmarshalled
+func countDataPoints(data []byte) int {
+	var request metricspb.MetricsData
+	if err := proto.Unmarshal(data, &request); err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, resourceMetrics := range request.GetResourceMetrics() {
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				switch data := metric.GetData().(type) {
+				case *metricspb.Metric_Gauge:
+					count += len(data.Gauge.GetDataPoints())
+				case *metricspb.Metric_Sum:
+					count += len(data.Sum.GetDataPoints())
+				case *metricspb.Metric_Histogram:
+					count += len(data.Histogram.GetDataPoints())
+				case *metricspb.Metric_ExponentialHistogram:
+					count += len(data.ExponentialHistogram.GetDataPoints())
+				case *metricspb.Metric_Summary:
+					count += len(data.Summary.GetDataPoints())
+				}
+			}
+		}
+	}
+	return count
+}
+
+// splitFields returns the raw values of a length-delimited field of a
+// serialized message, skipping other fields
+func splitFields(data []byte, field protowire.Number) ([][]byte, error) {
+	var values [][]byte
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if number != field || wireType != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(number, wireType, data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		values = append(values, value)
+		data = data[n:]
+	}
+	return values, nil
+}
+
+// appendField appends a length-delimited field to a serialized message
+func appendField(data []byte, field protowire.Number, value []byte) []byte {
+	data = protowire.AppendTag(data, field, protowire.BytesType)
+	return protowire.AppendBytes(data, value)
+}
+
+// numberDataPointToMetric converts a data point to an internal metric
+func numberDataPointToMetric(name string, resourceLabels map[string]interface{}, dataPoint *metricspb.NumberDataPoint) Metric {
+	labels := make(map[string]interface{}, len(resourceLabels)+len(dataPoint.GetAttributes()))
+	for k, v := range resourceLabels {
+		labels[k] = v
+	}
+	attributesToLabels(labels, dataPoint.GetAttributes())
+
+	value := json.Number("0")
+	switch v := dataPoint.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsDouble:
+		value = json.Number(strconv.FormatFloat(v.AsDouble, 'g', -1, 64))
+	case *metricspb.NumberDataPoint_AsInt:
+		value = json.Number(strconv.FormatInt(v.AsInt, 10))
+	}
+
+	return Metric{
+		"name":      name,
+		"value":     value,
+		"timestamp": unixNanoToSeconds(dataPoint.GetTimeUnixNano()),
+		"labels":    labels,
+	}
+}
+
+// unixNanoToSeconds renders a Unix nanosecond timestamp as exact decimal
+// seconds
+func unixNanoToSeconds(nanos uint64) json.Number {
+	seconds := strconv.FormatUint(nanos/uint64(time.Second), 10)
+	if fraction := nanos % uint64(time.Second); fraction != 0 {
+		seconds += strings.TrimRight(fmt.Sprintf(".%09d", fraction), "0")
+	}
+	return json.Number(seconds)
+}
+
+// attributesToLabels adds OTLP attributes to labels as strings, creating
+// labels if it is nil
+func attributesToLabels(labels map[string]interface{}, attributes []*commonpb.KeyValue) map[string]interface{} {
+	if labels == nil {
+		labels = make(map[string]interface{}, len(attributes))
+	}
+	for _, attribute := range attributes {
+		labels[attribute.GetKey()] = anyValueToString(attribute.GetValue())
+	}
+	return labels
+}
+
+// anyValueToString renders a scalar attribute value as a string. Arrays and
+// key/value lists are rendered in their protobuf text form.
+func anyValueToString(value *commonpb.AnyValue) string {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'g', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return string(v.BytesValue)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
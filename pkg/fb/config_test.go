@@ -0,0 +1,146 @@
+package fb
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reinitializingBlock records how each configuration was applied
+type reinitializingBlock struct {
+	BaseFunctionBlock
+	updated       []int64
+	reinitialized []int64
+}
+
+func (b *reinitializingBlock) Initialize(ctx context.Context) error { return nil }
+
+func (b *reinitializingBlock) ProcessBatch(ctx context.Context, batch *MetricBatch) (*ProcessResult, error) {
+	return NewSuccessResult(batch.BatchID), nil
+}
+
+func (b *reinitializingBlock) UpdateConfig(ctx context.Context, configBytes []byte, generation int64) error {
+	b.updated = append(b.updated, generation)
+	return nil
+}
+
+func (b *reinitializingBlock) Reinitialize(ctx context.Context, configBytes []byte, generation int64) error {
+	b.reinitialized = append(b.reinitialized, generation)
+	return nil
+}
+
+func (b *reinitializingBlock) ValidateConfig(configBytes []byte) error { return nil }
+
+func (b *reinitializingBlock) Shutdown(ctx context.Context) error { return nil }
+
+// configSource delivers updates to its callbacks as config.ConfigClient does
+type configSource struct {
+	callbacks        []func([]byte, int64) error
+	restartCallbacks []func([]byte, int64) error
+}
+
+func (s *configSource) RegisterCallback(callback func([]byte, int64) error) {
+	s.callbacks = append(s.callbacks, callback)
+}
+
+func (s *configSource) RegisterRestartCallback(callback func([]byte, int64) error) {
+	s.restartCallbacks = append(s.restartCallbacks, callback)
+}
+
+func (s *configSource) deliver(generation int64, requiresRestart bool) {
+	callbacks := s.callbacks
+	if requiresRestart {
+		callbacks = s.restartCallbacks
+	}
+	for _, callback := range callbacks {
+		callback([]byte(`{}`), generation)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	block := &reinitializingBlock{BaseFunctionBlock: NewBaseFunctionBlock("fb-test")}
+	source := &configSource{}
+	WatchConfig(context.Background(), source, block)
+
+	source.deliver(1, false)
+	source.deliver(2, true)
+	source.deliver(3, false)
+
+	assert.Equal(t, []int64{1, 3}, block.updated)
+	assert.Equal(t, []int64{2}, block.reinitialized)
+}
+
+// fileConfigBlock applies JSON configs, recording the one running
+type fileConfigBlock struct {
+	BaseFunctionBlock
+	running    string
+	generation int64
+}
+
+func (b *fileConfigBlock) Initialize(ctx context.Context) error { return nil }
+
+func (b *fileConfigBlock) ProcessBatch(ctx context.Context, batch *MetricBatch) (*ProcessResult, error) {
+	return NewSuccessResult(batch.BatchID), nil
+}
+
+func (b *fileConfigBlock) UpdateConfig(ctx context.Context, configBytes []byte, generation int64) error {
+	b.running = string(configBytes)
+	b.generation = generation
+	return nil
+}
+
+func (b *fileConfigBlock) ValidateConfig(configBytes []byte) error {
+	var parsed map[string]interface{}
+	return json.Unmarshal(configBytes, &parsed)
+}
+
+func (b *fileConfigBlock) Shutdown(ctx context.Context) error { return nil }
+
+func TestFileConfig_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	block := &fileConfigBlock{BaseFunctionBlock: NewBaseFunctionBlock("fb-test")}
+	fileConfig := NewFileConfig(path, block)
+
+	// A missing file is rejected
+	require.Error(t, fileConfig.Reload(context.Background()))
+	assert.Equal(t, int64(0), fileConfig.Generation())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"log_level":"info"}`), 0o644))
+	require.NoError(t, fileConfig.Reload(context.Background()))
+	assert.Equal(t, `{"log_level":"info"}`, block.running)
+	assert.Equal(t, int64(1), block.generation)
+
+	// A malformed file is rejected and the prior config stays active
+	require.NoError(t, os.WriteFile(path, []byte(`{"log_level":`), 0o644))
+	require.Error(t, fileConfig.Reload(context.Background()))
+	assert.Equal(t, `{"log_level":"info"}`, block.running)
+	assert.Equal(t, int64(1), fileConfig.Generation())
+
+	// Fixing the file applies it as the next generation
+	require.NoError(t, os.WriteFile(path, []byte(`{"log_level":"debug"}`), 0o644))
+	require.NoError(t, fileConfig.Reload(context.Background()))
+	assert.Equal(t, `{"log_level":"debug"}`, block.running)
+	assert.Equal(t, int64(2), block.generation)
+}
+
+func TestFileConfig_ReloadYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	block := &fileConfigBlock{BaseFunctionBlock: NewBaseFunctionBlock("fb-test")}
+	fileConfig := NewFileConfig(path, block)
+
+	// The block gets the JSON equivalent of the YAML file
+	require.NoError(t, os.WriteFile(path, []byte("log_level: info\nnext_fb: fb-next:5000\n"), 0o644))
+	require.NoError(t, fileConfig.Reload(context.Background()))
+	assert.JSONEq(t, `{"log_level":"info","next_fb":"fb-next:5000"}`, block.running)
+	assert.Equal(t, int64(1), block.generation)
+
+	// Malformed YAML is rejected and the prior config stays active
+	require.NoError(t, os.WriteFile(path, []byte("log_level: [info\n"), 0o644))
+	require.Error(t, fileConfig.Reload(context.Background()))
+	assert.JSONEq(t, `{"log_level":"info","next_fb":"fb-next:5000"}`, block.running)
+}
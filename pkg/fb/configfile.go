@@ -0,0 +1,96 @@
+package fb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/config"
+)
+
+// FileConfig applies a function block's configuration from a local file,
+// for standalone runs without the ConfigController. Each successful load
+// applies the file as the next generation. Files are JSON or YAML, as told by
+// a .json, .yaml or .yml extension or else detected from the content.
+type FileConfig struct {
+	path  string
+	block FunctionBlock
+
+	mu         sync.Mutex
+	generation int64
+}
+
+// NewFileConfig creates a FileConfig applying the file at path to block
+func NewFileConfig(path string, block FunctionBlock) *FileConfig {
+	return &FileConfig{path: path, block: block}
+}
+
+// Generation returns the generation of the configuration last applied, 0
+// before the first load
+func (f *FileConfig) Generation() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.generation
+}
+
+// Reload reads the file and applies it to the block. A file that can't be
+// read or fails the block's validation is rejected before anything is
+// applied, leaving the running configuration in place.
+func (f *FileConfig) Reload(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	configBytes, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	// Blocks get the JSON the ConfigController would send
+	configBytes, err = config.ToJSON(configBytes, config.FormatForPath(f.path))
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %w", f.path, err)
+	}
+	if err := f.block.ValidateConfig(configBytes); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", f.path, err)
+	}
+	if err := f.block.UpdateConfig(ctx, configBytes, f.generation+1); err != nil {
+		return fmt.Errorf("failed to apply config file %s: %w", f.path, err)
+	}
+
+	f.generation++
+	return nil
+}
+
+// ReloadOnSIGHUP reloads the file each time the process receives SIGHUP,
+// until ctx is done. Failed reloads are logged and keep the running
+// configuration.
+func (f *FileConfig) ReloadOnSIGHUP(ctx context.Context, logger *logging.Logger) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sigCh:
+				if err := f.Reload(ctx); err != nil {
+					logger.Error("Failed to reload config file, keeping the running config", err, map[string]interface{}{
+						"path":       f.path,
+						"generation": f.Generation(),
+					})
+					continue
+				}
+				logger.Info("Reloaded config file", map[string]interface{}{
+					"path":       f.path,
+					"generation": f.Generation(),
+				})
+			}
+		}
+	}()
+}
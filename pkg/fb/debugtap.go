@@ -0,0 +1,389 @@
+package fb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"eidc-tfk8s/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Stages at which the debug tap captures a batch
+const (
+	// DebugStageIn is a batch as the FB received it
+	DebugStageIn = "in"
+
+	// DebugStageOut is a batch as the FB forwards it
+	DebugStageOut = "out"
+)
+
+// RedactedValue replaces the values of redacted fields in captured batches
+const RedactedValue = "[REDACTED]"
+
+// Captured batches wait in a queue of debugTapQueueSize to be written to
+// the sink in the background, each write taking up to debugTapWriteTimeout
+const (
+	debugTapQueueSize    = 1024
+	debugTapWriteTimeout = 5 * time.Second
+)
+
+// errDebugTapQueueFull is returned for batches captured while the queue of
+// batches waiting to be written to the sink is full
+var errDebugTapQueueFull = errors.New("debug tap queue full")
+
+// Internal labels of the batches the debug tap pushes to an endpoint
+const (
+	labelDebugStage = "debug_tap_stage"
+	labelDebugError = "debug_tap_error"
+)
+
+var (
+	debugTapCapturedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_debug_tap_captured_total",
+		Help: "Total number of batches captured by the debug tap, by stage",
+	}, []string{"fb_name", "stage"})
+
+	debugTapErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_debug_tap_errors_total",
+		Help: "Total number of sampled batches the debug tap failed to write to its sink",
+	}, []string{"fb_name"})
+
+	debugTapDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_debug_tap_dropped_total",
+		Help: "Total number of sampled batches the debug tap dropped because its queue to the sink was full",
+	}, []string{"fb_name"})
+)
+
+// DecodeFunc decodes the data of a batch into metrics, e.g. codec.Decode
+type DecodeFunc func(batch *MetricBatch) ([]map[string]interface{}, error)
+
+// DebugRecord is a batch captured by the debug tap
+type DebugRecord struct {
+	FB      string                   `json:"fb"`
+	BatchID string                   `json:"batch_id"`
+	Stage   string                   `json:"stage"`
+	Format  string                   `json:"format,omitempty"`
+	Time    time.Time                `json:"time"`
+	Metrics []map[string]interface{} `json:"metrics,omitempty"`
+
+	// Error is why the batch data couldn't be decoded. The data isn't
+	// captured then, since it can't be redacted.
+	Error string `json:"error,omitempty"`
+}
+
+// debugSink is where the debug tap writes captured batches
+type debugSink interface {
+	Write(ctx context.Context, record *DebugRecord) error
+	Close() error
+}
+
+// debugTap captures a sample of batches to a sink, from FBConfig.DebugTap
+type debugTap struct {
+	target      string
+	sampleRatio float64
+	redact      map[string]struct{}
+	decode      DecodeFunc
+	sink        debugSink
+}
+
+// ConfigureDebugTap starts, updates or stops capturing batches with the debug
+// tap. The tap is off unless cfg has a sample ratio above 0. Batches are
+// decoded with decode so the configured fields can be redacted; ones that
+// can't be decoded are captured without their data. The sink is kept when
+// only the ratio or the redacted fields change.
+func (b *BaseFunctionBlock) ConfigureDebugTap(ctx context.Context, cfg *config.DebugTapConfig, decode DecodeFunc) error {
+	if !cfg.Enabled() {
+		b.CloseDebugTap()
+		return nil
+	}
+
+	next := newDebugTap(cfg, decode)
+
+	current := b.debugTap.Load()
+	if current != nil && current.target == next.target {
+		next.sink = current.sink
+		b.debugTap.Store(next)
+		return nil
+	}
+
+	sink, err := b.openDebugSink(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	next.sink = newAsyncDebugSink(b.name, sink)
+
+	if previous := b.debugTap.Swap(next); previous != nil {
+		previous.sink.Close()
+	}
+	return nil
+}
+
+// newDebugTap creates a debug tap for cfg, without its sink
+func newDebugTap(cfg *config.DebugTapConfig, decode DecodeFunc) *debugTap {
+	tap := &debugTap{
+		target:      "file:" + cfg.File,
+		sampleRatio: cfg.SampleRatio,
+		redact:      make(map[string]struct{}, len(cfg.RedactFields)),
+		decode:      decode,
+	}
+	if cfg.Endpoint != "" {
+		tap.target = "grpc:" + cfg.Endpoint
+	}
+	for _, field := range cfg.RedactFields {
+		tap.redact[field] = struct{}{}
+	}
+	return tap
+}
+
+// CloseDebugTap stops capturing batches and closes the debug tap's sink, if
+// any
+func (b *BaseFunctionBlock) CloseDebugTap() {
+	if previous := b.debugTap.Swap(nil); previous != nil {
+		previous.sink.Close()
+	}
+}
+
+// CaptureBatch queues the batch to be written to the debug tap's sink at
+// stage if the tap is on and samples the batch. Batches are sampled by ID,
+// so a sampled batch is captured both on the way in and on the way out. The
+// sink is written in the background, so a slow sink never holds up the
+// batch; batches captured while the queue is full are dropped. Failing to
+// capture a batch never fails it; failures are only counted.
+func (b *BaseFunctionBlock) CaptureBatch(ctx context.Context, stage string, batch *MetricBatch) {
+	tap := b.debugTap.Load()
+	if tap == nil || !SampledBatch(batch.BatchID, tap.sampleRatio) {
+		return
+	}
+
+	record := &DebugRecord{
+		FB:      b.sender,
+		BatchID: batch.BatchID,
+		Stage:   stage,
+		Format:  batch.Format,
+		Time:    time.Now(),
+	}
+	metrics, err := tap.decode(batch)
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		for _, metric := range metrics {
+			redactFields(metric, tap.redact)
+		}
+		record.Metrics = metrics
+	}
+
+	if err := tap.sink.Write(ctx, record); err != nil {
+		debugTapDroppedTotal.WithLabelValues(b.name).Inc()
+	}
+}
+
+// redactFields replaces the values of the fields in redact wherever they
+// appear in value, including nested maps such as labels
+func redactFields(value interface{}, redact map[string]struct{}) {
+	if len(redact) == 0 {
+		return
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if _, ok := redact[key]; ok {
+				v[key] = RedactedValue
+				continue
+			}
+			redactFields(field, redact)
+		}
+	case map[string]string:
+		for key := range v {
+			if _, ok := redact[key]; ok {
+				v[key] = RedactedValue
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			redactFields(item, redact)
+		}
+	}
+}
+
+// openDebugSink opens the sink configured for the debug tap
+func (b *BaseFunctionBlock) openDebugSink(ctx context.Context, cfg *config.DebugTapConfig) (debugSink, error) {
+	if cfg.File != "" {
+		file, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open debug tap file: %w", err)
+		}
+		return &fileDebugSink{file: file, encoder: json.NewEncoder(file)}, nil
+	}
+
+	conn, err := b.DialContext(ctx, cfg.Endpoint,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to debug tap endpoint: %w", err)
+	}
+	return &grpcDebugSink{conn: conn, client: NewChainPushServiceClient(conn)}, nil
+}
+
+// asyncDebugSink writes captured batches to a sink from a queue in the
+// background, off the path of the batches
+type asyncDebugSink struct {
+	fbName  string
+	sink    debugSink
+	records chan *DebugRecord
+	done    chan struct{}
+
+	// closed is set once Close is called, guarded by mu so that no record
+	// is queued after records is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// newAsyncDebugSink starts writing the records queued to the returned sink
+// to sink
+func newAsyncDebugSink(fbName string, sink debugSink) *asyncDebugSink {
+	s := &asyncDebugSink{
+		fbName:  fbName,
+		sink:    sink,
+		records: make(chan *DebugRecord, debugTapQueueSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// run writes queued records to the sink until the queue is closed, each
+// with its own timeout rather than that of the batch it was captured from
+func (s *asyncDebugSink) run() {
+	defer close(s.done)
+	for record := range s.records {
+		ctx, cancel := context.WithTimeout(context.Background(), debugTapWriteTimeout)
+		err := s.sink.Write(ctx, record)
+		cancel()
+		if err != nil {
+			debugTapErrorsTotal.WithLabelValues(s.fbName).Inc()
+			continue
+		}
+		debugTapCapturedTotal.WithLabelValues(s.fbName, record.Stage).Inc()
+	}
+}
+
+// Write implements debugSink. It queues the record without waiting, and
+// fails if the queue is full.
+func (s *asyncDebugSink) Write(ctx context.Context, record *DebugRecord) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return errDebugTapQueueFull
+	}
+
+	select {
+	case s.records <- record:
+		return nil
+	default:
+		return errDebugTapQueueFull
+	}
+}
+
+// Close implements debugSink. It writes the records still queued before
+// closing the sink.
+func (s *asyncDebugSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.records)
+	s.mu.Unlock()
+
+	<-s.done
+	return s.sink.Close()
+}
+
+// fileDebugSink appends captured batches to a file as JSON lines
+type fileDebugSink struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// Write implements debugSink
+func (s *fileDebugSink) Write(ctx context.Context, record *DebugRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(record)
+}
+
+// Close implements debugSink
+func (s *fileDebugSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// grpcDebugSink pushes captured batches to a ChainPushService as JSON
+// batches, labelled with the stage they were captured at
+type grpcDebugSink struct {
+	conn   *grpc.ClientConn
+	client ChainPushServiceClient
+}
+
+// Write implements debugSink
+func (s *grpcDebugSink) Write(ctx context.Context, record *DebugRecord) error {
+	labels := map[string]string{
+		"fb_sender":     record.FB,
+		labelDebugStage: record.Stage,
+	}
+
+	var data []byte
+	if record.Error != "" {
+		labels[labelDebugError] = record.Error
+	} else {
+		var err error
+		if data, err = json.Marshal(record.Metrics); err != nil {
+			return err
+		}
+	}
+
+	res, err := s.client.PushMetrics(ctx, &MetricBatchRequest{
+		BatchId:        record.BatchID,
+		Data:           data,
+		Format:         "json",
+		InternalLabels: labels,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to debug tap endpoint: %w", err)
+	}
+	if res.Status != StatusSuccess {
+		return fmt.Errorf("debug tap endpoint returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+	}
+	return nil
+}
+
+// Close implements debugSink
+func (s *grpcDebugSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// SetDebugTapClientForTesting captures batches to client with the given
+// config for testing purposes
+func (b *BaseFunctionBlock) SetDebugTapClientForTesting(cfg *config.DebugTapConfig, decode DecodeFunc, client ChainPushServiceClient) {
+	tap := newDebugTap(cfg, decode)
+	tap.target = "testing"
+	tap.sink = newAsyncDebugSink(b.name, &grpcDebugSink{client: client})
+	if previous := b.debugTap.Swap(tap); previous != nil {
+		previous.sink.Close()
+	}
+}
@@ -0,0 +1,189 @@
+package fb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eidc-tfk8s/internal/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// decodeJSON decodes JSON batch data, standing in for codec.Decode
+func decodeJSON(batch *MetricBatch) ([]map[string]interface{}, error) {
+	var metrics []map[string]interface{}
+	if err := json.Unmarshal(batch.Data, &metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// readDebugRecords reads the records the debug tap appended to path
+func readDebugRecords(t *testing.T, path string) []DebugRecord {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []DebugRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record DebugRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestDebugTap_OffByDefault(t *testing.T) {
+	block := NewBaseFunctionBlock("fb-debug-tap-test")
+	require.NoError(t, block.ConfigureDebugTap(context.Background(), nil, decodeJSON))
+	require.NoError(t, block.ConfigureDebugTap(context.Background(), &config.DebugTapConfig{File: filepath.Join(t.TempDir(), "tap.jsonl")}, decodeJSON))
+	assert.Nil(t, block.debugTap.Load())
+
+	// Capturing without a tap does nothing
+	block.CaptureBatch(context.Background(), DebugStageIn, &MetricBatch{BatchID: "batch-1", Data: []byte(`[]`)})
+}
+
+func TestDebugTap_SamplesAtConfiguredRate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tap.jsonl")
+	block := NewBaseFunctionBlock("fb-debug-tap-test")
+	require.NoError(t, block.ConfigureDebugTap(context.Background(), &config.DebugTapConfig{
+		SampleRatio: 0.1,
+		File:        path,
+	}, decodeJSON))
+
+	const batches = 2000
+	for i := 0; i < batches; i++ {
+		batch := &MetricBatch{BatchID: fmt.Sprintf("batch-%d", i), Data: []byte(`[{"name":"cpu","value":1}]`), Format: "json"}
+		block.CaptureBatch(context.Background(), DebugStageIn, batch)
+		block.CaptureBatch(context.Background(), DebugStageOut, batch)
+	}
+	block.CloseDebugTap()
+
+	records := readDebugRecords(t, path)
+	stages := make(map[string]map[string]bool)
+	for _, record := range records {
+		if stages[record.BatchID] == nil {
+			stages[record.BatchID] = make(map[string]bool)
+		}
+		stages[record.BatchID][record.Stage] = true
+		assert.Equal(t, "fb-debug-tap-test", record.FB)
+		assert.Len(t, record.Metrics, 1)
+	}
+
+	// Each sampled batch is captured on the way in and out
+	assert.InDelta(t, batches*0.1, len(stages), batches*0.02)
+	assert.Len(t, records, 2*len(stages))
+	for batchID, seen := range stages {
+		assert.True(t, seen[DebugStageIn] && seen[DebugStageOut], batchID)
+	}
+}
+
+func TestDebugTap_RedactsConfiguredFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tap.jsonl")
+	block := NewBaseFunctionBlock("fb-debug-tap-test")
+	require.NoError(t, block.ConfigureDebugTap(context.Background(), &config.DebugTapConfig{
+		SampleRatio:  1,
+		File:         path,
+		RedactFields: []string{"email", "user_id"},
+	}, decodeJSON))
+
+	block.CaptureBatch(context.Background(), DebugStageIn, &MetricBatch{
+		BatchID: "batch-1",
+		Data:    []byte(`[{"name":"logins","value":1,"email":"jane@example.com","labels":{"user_id":"u-42","host":"web-1"}}]`),
+	})
+	block.CaptureBatch(context.Background(), DebugStageIn, &MetricBatch{BatchID: "batch-2", Data: []byte(`not json`)})
+	block.CloseDebugTap()
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "jane@example.com")
+	assert.NotContains(t, string(raw), "u-42")
+	assert.NotContains(t, string(raw), "not json")
+
+	records := readDebugRecords(t, path)
+	require.Len(t, records, 2)
+	require.Len(t, records[0].Metrics, 1)
+	metric := records[0].Metrics[0]
+	assert.Equal(t, RedactedValue, metric["email"])
+	assert.Equal(t, map[string]interface{}{"user_id": RedactedValue, "host": "web-1"}, metric["labels"])
+	assert.Equal(t, "logins", metric["name"])
+
+	// Data that can't be decoded can't be redacted, so only the error is kept
+	assert.Empty(t, records[1].Metrics)
+	assert.NotEmpty(t, records[1].Error)
+}
+
+func TestDebugTap_PushesToEndpoint(t *testing.T) {
+	block := NewBaseFunctionBlock("fb-debug-tap-test")
+	var pushed []*MetricBatchRequest
+	block.SetDebugTapClientForTesting(&config.DebugTapConfig{SampleRatio: 1, RedactFields: []string{"email"}}, decodeJSON, &MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *MetricBatchRequest, opts ...grpc.CallOption) (*MetricBatchResponse, error) {
+			pushed = append(pushed, in)
+			return &MetricBatchResponse{Status: StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	block.CaptureBatch(context.Background(), DebugStageOut, &MetricBatch{
+		BatchID: "batch-1",
+		Data:    []byte(`[{"name":"logins","email":"jane@example.com"}]`),
+	})
+
+	// Closing the tap writes the batches still queued
+	block.CloseDebugTap()
+	require.Len(t, pushed, 1)
+	assert.Equal(t, "batch-1", pushed[0].BatchId)
+	assert.Equal(t, "json", pushed[0].Format)
+	assert.Equal(t, DebugStageOut, pushed[0].InternalLabels[labelDebugStage])
+	assert.JSONEq(t, `[{"name":"logins","email":"[REDACTED]"}]`, string(pushed[0].Data))
+}
+
+func TestDebugTapConfig_Validate(t *testing.T) {
+	var unset *config.DebugTapConfig
+	assert.NoError(t, unset.Validate())
+	assert.NoError(t, (&config.DebugTapConfig{File: "/tmp/tap.jsonl", SampleRatio: 0.5}).Validate())
+	assert.NoError(t, (&config.DebugTapConfig{}).Validate())
+	assert.Error(t, (&config.DebugTapConfig{File: "/tmp/tap.jsonl", SampleRatio: 1.5}).Validate())
+	assert.Error(t, (&config.DebugTapConfig{SampleRatio: 0.5}).Validate())
+	assert.Error(t, (&config.DebugTapConfig{File: "/tmp/tap.jsonl", Endpoint: "localhost:1", SampleRatio: 0.5}).Validate())
+}
+
+func TestDebugTap_SlowSinkDoesNotBlockBatches(t *testing.T) {
+	block := NewBaseFunctionBlock("fb-debug-tap-test")
+	release := make(chan struct{})
+	block.SetDebugTapClientForTesting(&config.DebugTapConfig{SampleRatio: 1}, decodeJSON, &MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *MetricBatchRequest, opts ...grpc.CallOption) (*MetricBatchResponse, error) {
+			<-release
+			return &MetricBatchResponse{Status: StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	// Capturing returns while the sink is stuck, dropping batches once the
+	// queue is full
+	dropped := testutil.ToFloat64(debugTapDroppedTotal.WithLabelValues("fb-debug-tap-test"))
+	captured := make(chan struct{})
+	go func() {
+		for i := 0; i < debugTapQueueSize+10; i++ {
+			block.CaptureBatch(context.Background(), DebugStageIn, &MetricBatch{BatchID: fmt.Sprintf("batch-%d", i), Data: []byte(`[]`)})
+		}
+		close(captured)
+	}()
+	select {
+	case <-captured:
+	case <-time.After(5 * time.Second):
+		t.Fatal("capturing batches blocked on the sink")
+	}
+	assert.GreaterOrEqual(t, testutil.ToFloat64(debugTapDroppedTotal.WithLabelValues("fb-debug-tap-test"))-dropped, float64(9))
+
+	close(release)
+	block.CloseDebugTap()
+}
@@ -0,0 +1,30 @@
+package fb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Dialer is implemented by function blocks whose connections to the rest
+// of the chain can be given extra dial options, such as those embedding
+// BaseFunctionBlock
+type Dialer interface {
+	// SetDialOptions sets options added to every connection the function
+	// block opens to other function blocks and sinks
+	SetDialOptions(opts ...grpc.DialOption)
+}
+
+// SetDialOptions sets options added to every connection the function block
+// opens to other function blocks and sinks, e.g. a grpc.WithContextDialer
+// that serves the chain in process. Call it before applying config.
+func (b *BaseFunctionBlock) SetDialOptions(opts ...grpc.DialOption) {
+	b.dialOptions = opts
+}
+
+// DialContext connects to another function block like grpc.DialContext,
+// with the options set by SetDialOptions added to opts
+func (b *BaseFunctionBlock) DialContext(ctx context.Context, target string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	opts = append(append([]grpc.DialOption(nil), opts...), b.dialOptions...)
+	return grpc.DialContext(ctx, target, opts...)
+}
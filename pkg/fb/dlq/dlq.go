@@ -0,0 +1,81 @@
+// Package dlq defines the messages stored in the dead letter queue, the
+// stores holding them and how they are queried for replay.
+package dlq
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Message is the structure of a message stored in the DLQ
+type Message struct {
+	BatchID        string            `json:"batch_id"`
+	Data           []byte            `json:"data"`
+	Format         string            `json:"format"`
+	Timestamp      time.Time         `json:"timestamp"`
+	ErrorCode      string            `json:"error_code"`
+	ErrorMessage   string            `json:"error_message"`
+	FBSender       string            `json:"fb_sender"`
+	InternalLabels map[string]string `json:"internal_labels"`
+	Metadata       map[string]string `json:"metadata"`
+}
+
+// Filter selects DLQ messages. Zero-valued fields match every message.
+type Filter struct {
+	// Since and Until bound the time the message was dead-lettered
+	Since time.Time
+	Until time.Time
+
+	// ErrorCode matches the code the message failed with
+	ErrorCode string
+
+	// FBSender matches the function block that dead-lettered the message,
+	// either any of its replicas by name ("fb-cl") or one of them ("fb-cl/<pod>")
+	FBSender string
+
+	// BatchIDs matches any of the listed batch ids
+	BatchIDs []string
+
+	// Limit caps the number of messages a query returns; 0 means no limit
+	Limit int
+}
+
+// Matches reports whether a message passes the filter. Limit is applied by
+// the querier, not here.
+func (f Filter) Matches(message Message) bool {
+	// Time filter
+	if !f.Since.IsZero() && message.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && message.Timestamp.After(f.Until) {
+		return false
+	}
+
+	// Error code filter
+	if f.ErrorCode != "" && message.ErrorCode != f.ErrorCode {
+		return false
+	}
+
+	// FB sender filter
+	if f.FBSender != "" && message.FBSender != f.FBSender && !strings.HasPrefix(message.FBSender, f.FBSender+"/") {
+		return false
+	}
+
+	// Batch id filter
+	if len(f.BatchIDs) > 0 {
+		for _, batchID := range f.BatchIDs {
+			if message.BatchID == batchID {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// Querier returns the DLQ messages matching a filter
+type Querier interface {
+	Query(ctx context.Context, filter Filter) ([]Message, error)
+}
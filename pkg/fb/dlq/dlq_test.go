@@ -0,0 +1,50 @@
+package dlq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_Matches(t *testing.T) {
+	now := time.Now()
+	message := Message{
+		BatchID:   "batch-1",
+		Timestamp: now,
+		ErrorCode: "ERR_FORWARDING_FAILED",
+		FBSender:  "fb-gw",
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"empty filter", Filter{}, true},
+		{"in time range", Filter{Since: now.Add(-time.Minute), Until: now.Add(time.Minute)}, true},
+		{"before since", Filter{Since: now.Add(time.Minute)}, false},
+		{"after until", Filter{Until: now.Add(-time.Minute)}, false},
+		{"error code", Filter{ErrorCode: "ERR_FORWARDING_FAILED"}, true},
+		{"other error code", Filter{ErrorCode: "ERR_INVALID_INPUT"}, false},
+		{"sender", Filter{FBSender: "fb-gw"}, true},
+		{"other sender", Filter{FBSender: "fb-dp"}, false},
+		{"batch id", Filter{BatchIDs: []string{"batch-0", "batch-1"}}, true},
+		{"other batch id", Filter{BatchIDs: []string{"batch-2"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Matches(message))
+		})
+	}
+}
+
+func TestFilter_MatchesSenderReplicas(t *testing.T) {
+	message := Message{BatchID: "batch-1", FBSender: "fb-gw/fb-gw-7d9f-abcde"}
+
+	assert.True(t, Filter{FBSender: "fb-gw"}.Matches(message))
+	assert.True(t, Filter{FBSender: "fb-gw/fb-gw-7d9f-abcde"}.Matches(message))
+	assert.False(t, Filter{FBSender: "fb-gw/fb-gw-7d9f-fghij"}.Matches(message))
+	assert.False(t, Filter{FBSender: "fb-g"}.Matches(message))
+}
@@ -0,0 +1,130 @@
+package dlq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var expiredTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "fb_dlq_expired_total",
+	Help: "Total number of DLQ messages removed because they outlived the message TTL",
+})
+
+// defaultSweepInterval is how often expired messages are swept when the
+// config doesn't say
+const defaultSweepInterval = time.Minute
+
+// ExpiryConfig configures removing messages that have been in the DLQ too
+// long to be worth replaying
+type ExpiryConfig struct {
+	// MessageTTLSeconds is how long a message is kept after it was
+	// dead-lettered; 0 keeps messages until they are replayed
+	MessageTTLSeconds int `json:"message_ttl_seconds"`
+
+	// SweepIntervalSeconds is how often expired messages are removed
+	SweepIntervalSeconds int `json:"sweep_interval_seconds"`
+}
+
+// Validate checks the expiry configuration
+func (c ExpiryConfig) Validate() error {
+	if c.MessageTTLSeconds < 0 {
+		return fmt.Errorf("message_ttl_seconds must not be negative")
+	}
+	if c.SweepIntervalSeconds < 0 {
+		return fmt.Errorf("sweep_interval_seconds must not be negative")
+	}
+	return nil
+}
+
+// MessageTTL returns the configured TTL, or 0 if messages don't expire
+func (c ExpiryConfig) MessageTTL() time.Duration {
+	return time.Duration(c.MessageTTLSeconds) * time.Second
+}
+
+// SweepInterval returns the configured sweep interval or the default
+func (c ExpiryConfig) SweepInterval() time.Duration {
+	if c.SweepIntervalSeconds == 0 {
+		return defaultSweepInterval
+	}
+	return time.Duration(c.SweepIntervalSeconds) * time.Second
+}
+
+// Sweeper removes messages older than the message TTL from a store. It
+// iterates the store without holding it, so writers aren't blocked while it
+// runs.
+type Sweeper struct {
+	store    Store
+	ttl      time.Duration
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewSweeper creates a sweeper for store with the given expiry config
+func NewSweeper(store Store, config ExpiryConfig) *Sweeper {
+	return &Sweeper{
+		store:    store,
+		ttl:      config.MessageTTL(),
+		interval: config.SweepInterval(),
+		now:      time.Now,
+	}
+}
+
+// Run sweeps the store every interval until ctx is done. It returns
+// immediately if messages don't expire.
+func (s *Sweeper) Run(ctx context.Context) {
+	if s.ttl <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep(ctx)
+		}
+	}
+}
+
+// Sweep removes the messages dead-lettered more than the TTL ago and returns
+// how many it removed. Messages are aged by the time they were dead-lettered,
+// since keys carry no time. Messages that can't be decoded are kept, so they
+// are still reported by readers.
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	if s.ttl <= 0 {
+		return 0, nil
+	}
+
+	cutoff := s.now().Add(-s.ttl)
+	var expired []string
+	err := s.store.Iterate(ctx, Filter{Until: cutoff}, func(record Record) error {
+		if record.Err == nil && record.Message.Timestamp.Before(cutoff) {
+			expired = append(expired, record.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to iterate DLQ: %w", err)
+	}
+
+	removed := 0
+	for _, key := range expired {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		if err := s.store.Delete(ctx, key); err != nil {
+			return removed, fmt.Errorf("failed to delete expired message %s: %w", key, err)
+		}
+		removed++
+		expiredTotal.Inc()
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,56 @@
+package dlq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSweeper_RemovesExpiredMessages(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			require.NoError(t, store.Put(ctx, "stale", Message{BatchID: "stale", Timestamp: now.Add(-2 * time.Hour)}))
+			require.NoError(t, store.Put(ctx, "fresh", Message{BatchID: "fresh", Timestamp: now.Add(-10 * time.Minute)}))
+
+			sweeper := NewSweeper(store, ExpiryConfig{MessageTTLSeconds: 3600})
+			sweeper.now = func() time.Time { return now }
+
+			before := testutil.ToFloat64(expiredTotal)
+			removed, err := sweeper.Sweep(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 1, removed)
+			assert.Equal(t, before+1, testutil.ToFloat64(expiredTotal))
+
+			_, err = store.Get(ctx, "stale")
+			assert.ErrorIs(t, err, ErrNotFound)
+			message, err := store.Get(ctx, "fresh")
+			require.NoError(t, err)
+			assert.Equal(t, "fresh", message.BatchID)
+		})
+	}
+}
+
+func TestSweeper_RunStopsWhenCancelled(t *testing.T) {
+	sweeper := NewSweeper(NewMemoryStore(), ExpiryConfig{MessageTTLSeconds: 60, SweepIntervalSeconds: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sweeper.Run(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sweeper did not stop when its context was cancelled")
+	}
+}
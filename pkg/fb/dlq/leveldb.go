@@ -0,0 +1,110 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// LevelDBStore is a LevelDB-backed DLQ, as written by FB-DLQ and read by
+// dlq-replay. Messages are stored as JSON.
+type LevelDBStore struct {
+	db *leveldb.DB
+}
+
+// OpenLevelDB opens the DLQ database at path, creating it unless readOnly
+func OpenLevelDB(path string, readOnly bool) (*LevelDBStore, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open LevelDB: %w", err)
+	}
+	return &LevelDBStore{db: db}, nil
+}
+
+// Put implements Store
+func (s *LevelDBStore) Put(ctx context.Context, key string, message Message) error {
+	value, err := encodeMessage(message)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Put([]byte(key), value, nil); err != nil {
+		return fmt.Errorf("failed to write message %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get implements Store
+func (s *LevelDBStore) Get(ctx context.Context, key string) (Message, error) {
+	value, err := s.db.Get([]byte(key), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return Message{}, ErrNotFound
+	}
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to read message %s: %w", key, err)
+	}
+
+	record := decodeRecord(key, value)
+	return record.Message, record.Err
+}
+
+// Iterate implements Store
+func (s *LevelDBStore) Iterate(ctx context.Context, filter Filter, fn func(Record) error) error {
+	iter := s.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	count := 0
+	for iter.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		record := decodeRecord(string(iter.Key()), iter.Value())
+		if !record.matches(filter) {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+
+		count++
+		if filter.Limit > 0 && count >= filter.Limit {
+			break
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("error iterating DLQ: %w", err)
+	}
+
+	return nil
+}
+
+// Delete implements Store
+func (s *LevelDBStore) Delete(ctx context.Context, key string) error {
+	if err := s.db.Delete([]byte(key), nil); err != nil {
+		return fmt.Errorf("failed to delete message %s: %w", key, err)
+	}
+	return nil
+}
+
+// Count implements Store
+func (s *LevelDBStore) Count(ctx context.Context, filter Filter) (int, error) {
+	count := 0
+	err := s.Iterate(ctx, filter, func(Record) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// Query implements Querier. Messages that can't be parsed are skipped.
+func (s *LevelDBStore) Query(ctx context.Context, filter Filter) ([]Message, error) {
+	return query(ctx, s, filter)
+}
+
+// Close closes the database
+func (s *LevelDBStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,112 @@
+package dlq
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is an in-memory DLQ, for tests and single-process setups that
+// don't need messages to survive a restart. Messages are stored encoded, as
+// LevelDBStore stores them.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	messages map[string][]byte
+}
+
+// NewMemoryStore creates an empty in-memory DLQ
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		messages: make(map[string][]byte),
+	}
+}
+
+// Put implements Store
+func (s *MemoryStore) Put(ctx context.Context, key string, message Message) error {
+	value, err := encodeMessage(message)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[key] = value
+	return nil
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(ctx context.Context, key string) (Message, error) {
+	s.mu.RLock()
+	value, ok := s.messages[key]
+	s.mu.RUnlock()
+	if !ok {
+		return Message{}, ErrNotFound
+	}
+
+	record := decodeRecord(key, value)
+	return record.Message, record.Err
+}
+
+// Iterate implements Store. It iterates over a snapshot of the store, so fn
+// may modify the store.
+func (s *MemoryStore) Iterate(ctx context.Context, filter Filter, fn func(Record) error) error {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.messages))
+	values := make(map[string][]byte, len(s.messages))
+	for key, value := range s.messages {
+		keys = append(keys, key)
+		values[key] = value
+	}
+	s.mu.RUnlock()
+	sort.Strings(keys)
+
+	count := 0
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		record := decodeRecord(key, values[key])
+		if !record.matches(filter) {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+
+		count++
+		if filter.Limit > 0 && count >= filter.Limit {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.messages, key)
+	return nil
+}
+
+// Count implements Store
+func (s *MemoryStore) Count(ctx context.Context, filter Filter) (int, error) {
+	count := 0
+	err := s.Iterate(ctx, filter, func(Record) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// Query implements Querier. Messages that can't be parsed are skipped.
+func (s *MemoryStore) Query(ctx context.Context, filter Filter) ([]Message, error) {
+	return query(ctx, s, filter)
+}
+
+// Close implements Store. The messages are kept.
+func (s *MemoryStore) Close() error {
+	return nil
+}
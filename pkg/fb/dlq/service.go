@@ -0,0 +1,77 @@
+package dlq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"eidc-tfk8s/pkg/fb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var storedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_dlq_stored_total",
+	Help: "Total number of batches stored in the DLQ, by outcome",
+}, []string{"outcome"})
+
+// Service is FB-DLQ: it stores the batches function blocks dead-letter for
+// dlq-replay, and removes them with a Sweeper once they outlive the message
+// TTL
+type Service struct {
+	store   Store
+	sweeper *Sweeper
+	now     func() time.Time
+}
+
+// NewService creates FB-DLQ storing batches in store and expiring them as
+// configured
+func NewService(store Store, config ExpiryConfig) *Service {
+	return &Service{
+		store:   store,
+		sweeper: NewSweeper(store, config),
+		now:     time.Now,
+	}
+}
+
+// Run sweeps expired messages from the store until ctx is done. It returns
+// immediately if messages don't expire.
+func (s *Service) Run(ctx context.Context) {
+	s.sweeper.Run(ctx)
+}
+
+// PushMetrics implements fb.ChainPushServiceServer, storing the batch with
+// the error and sender it was labelled with
+func (s *Service) PushMetrics(ctx context.Context, req *fb.MetricBatchRequest) (*fb.MetricBatchResponse, error) {
+	message := Message{
+		BatchID:        req.BatchId,
+		Data:           req.Data,
+		Format:         req.Format,
+		Timestamp:      s.now(),
+		ErrorCode:      req.InternalLabels["error_code"],
+		ErrorMessage:   req.InternalLabels["error"],
+		FBSender:       req.InternalLabels[fb.LabelSender],
+		InternalLabels: req.InternalLabels,
+		Metadata:       req.Metadata,
+	}
+
+	if err := s.store.Put(ctx, messageKey(message), message); err != nil {
+		storedTotal.WithLabelValues("error").Inc()
+		return &fb.MetricBatchResponse{
+			Status:       fb.StatusError,
+			ErrorMessage: fmt.Sprintf("failed to store batch: %v", err),
+			ErrorCode:    string(fb.ErrorCodeProcessingFailed),
+			BatchId:      req.BatchId,
+		}, nil
+	}
+
+	storedTotal.WithLabelValues("stored").Inc()
+	return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: req.BatchId}, nil
+}
+
+// messageKey returns the key a message is stored under. Keys start with the
+// time the message was dead-lettered, zero-padded, so stores iterate
+// messages in the order they arrived.
+func messageKey(message Message) string {
+	return fmt.Sprintf("%020d/%s", message.Timestamp.UnixNano(), message.BatchID)
+}
@@ -0,0 +1,59 @@
+package dlq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"eidc-tfk8s/pkg/fb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_StoresAndExpiresBatches(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore()
+	service := NewService(store, ExpiryConfig{MessageTTLSeconds: 3600})
+	service.now = func() time.Time { return now.Add(-2 * time.Hour) }
+	service.sweeper.now = func() time.Time { return now }
+
+	push := func(batchID string) {
+		res, err := service.PushMetrics(ctx, &fb.MetricBatchRequest{
+			BatchId: batchID,
+			Data:    []byte(`[]`),
+			Format:  "json",
+			InternalLabels: map[string]string{
+				"error":        "schema validation failed",
+				"error_code":   string(fb.ErrorCodeInvalidInput),
+				fb.LabelSender: "fb-gw",
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, fb.StatusSuccess, res.Status)
+	}
+
+	push("stale")
+	service.now = func() time.Time { return now.Add(-10 * time.Minute) }
+	push("fresh")
+
+	// Batches are stored with the error and sender they were labelled with
+	messages, err := store.Query(ctx, Filter{})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "stale", messages[0].BatchID)
+	assert.Equal(t, string(fb.ErrorCodeInvalidInput), messages[0].ErrorCode)
+	assert.Equal(t, "schema validation failed", messages[0].ErrorMessage)
+	assert.Equal(t, "fb-gw", messages[0].FBSender)
+
+	// The service's sweeper removes those past the TTL
+	removed, err := service.sweeper.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	messages, err = store.Query(ctx, Filter{})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "fresh", messages[0].BatchID)
+}
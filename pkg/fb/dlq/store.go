@@ -0,0 +1,101 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned by Store.Get when no message is stored under a key
+var ErrNotFound = errors.New("message not found in DLQ")
+
+// Store persists DLQ messages under string keys. FB-DLQ writes to it and
+// dlq-replay reads, replays and deletes from it; each storage backend
+// implements it.
+type Store interface {
+	// Put stores a message under key, replacing any message stored there
+	Put(ctx context.Context, key string, message Message) error
+
+	// Get returns the message stored under key, or ErrNotFound
+	Get(ctx context.Context, key string) (Message, error)
+
+	// Iterate calls fn with each stored record matching filter, in key
+	// order, up to filter.Limit records. It stops at the first error fn
+	// returns and returns it.
+	Iterate(ctx context.Context, filter Filter, fn func(Record) error) error
+
+	// Delete removes the message stored under key. Deleting a key that
+	// isn't stored is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// Count returns the number of stored records matching filter
+	Count(ctx context.Context, filter Filter) (int, error)
+
+	// Close releases the store
+	Close() error
+}
+
+// Record is a message as stored in a Store
+type Record struct {
+	// Key is the key the message is stored under
+	Key string
+
+	// Message is the stored message; zero if it couldn't be decoded
+	Message Message
+
+	// Size is the size of the stored message in bytes
+	Size int
+
+	// Err is why the stored message couldn't be decoded. Records that
+	// can't be decoded match every filter, so readers can account for them.
+	Err error
+}
+
+// encodeMessage encodes a message as it is stored
+func encodeMessage(message Message) ([]byte, error) {
+	value, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+	return value, nil
+}
+
+// decodeRecord decodes a stored message into a record
+func decodeRecord(key string, value []byte) Record {
+	record := Record{Key: key, Size: len(value)}
+	if err := json.Unmarshal(value, &record.Message); err != nil {
+		record.Message = Message{}
+		record.Err = fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return record
+}
+
+// matches reports whether a record passes the filter
+func (r Record) matches(filter Filter) bool {
+	return r.Err != nil || filter.Matches(r.Message)
+}
+
+// query implements Querier on top of a store. Messages that can't be
+// decoded are skipped.
+func query(ctx context.Context, store Store, filter Filter) ([]Message, error) {
+	limit := filter.Limit
+	filter.Limit = 0
+
+	var messages []Message
+	errLimit := errors.New("limit reached")
+	err := store.Iterate(ctx, filter, func(record Record) error {
+		if record.Err != nil {
+			return nil
+		}
+		messages = append(messages, record.Message)
+		if limit > 0 && len(messages) >= limit {
+			return errLimit
+		}
+		return nil
+	})
+	if err != nil && err != errLimit {
+		return nil, err
+	}
+	return messages, nil
+}
@@ -0,0 +1,170 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stores returns a fresh instance of each Store implementation
+func stores(t *testing.T) map[string]Store {
+	leveldbStore, err := OpenLevelDB(filepath.Join(t.TempDir(), "dlq"), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { leveldbStore.Close() })
+
+	return map[string]Store{
+		"leveldb": leveldbStore,
+		"memory":  NewMemoryStore(),
+	}
+}
+
+func TestStore(t *testing.T) {
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	messages := []Message{
+		{BatchID: "batch-3", Timestamp: base.Add(2 * time.Hour), ErrorCode: "ERR_INVALID_INPUT", FBSender: "fb-gw"},
+		{BatchID: "batch-1", Timestamp: base, ErrorCode: "ERR_FORWARDING_FAILED", FBSender: "fb-gw", Data: []byte(`[]`)},
+		{BatchID: "batch-2", Timestamp: base.Add(time.Hour), ErrorCode: "ERR_FORWARDING_FAILED", FBSender: "fb-dp"},
+	}
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, message := range messages {
+				require.NoError(t, store.Put(ctx, message.BatchID, message))
+			}
+
+			// Get
+			message, err := store.Get(ctx, "batch-1")
+			require.NoError(t, err)
+			assert.Equal(t, "fb-gw", message.FBSender)
+			assert.Equal(t, []byte(`[]`), message.Data)
+			assert.True(t, base.Equal(message.Timestamp))
+
+			_, err = store.Get(ctx, "batch-0")
+			assert.True(t, errors.Is(err, ErrNotFound))
+
+			// Iterate in key order
+			var keys []string
+			require.NoError(t, store.Iterate(ctx, Filter{}, func(record Record) error {
+				require.NoError(t, record.Err)
+				assert.Equal(t, record.Key, record.Message.BatchID)
+				assert.Greater(t, record.Size, 0)
+				keys = append(keys, record.Key)
+				return nil
+			}))
+			assert.Equal(t, []string{"batch-1", "batch-2", "batch-3"}, keys)
+
+			// Iterate with a filter and limit
+			keys = nil
+			require.NoError(t, store.Iterate(ctx, Filter{FBSender: "fb-gw", Limit: 1}, func(record Record) error {
+				keys = append(keys, record.Key)
+				return nil
+			}))
+			assert.Equal(t, []string{"batch-1"}, keys)
+
+			// Errors stop the iteration
+			stop := errors.New("stop")
+			calls := 0
+			err = store.Iterate(ctx, Filter{}, func(Record) error {
+				calls++
+				return stop
+			})
+			assert.Equal(t, stop, err)
+			assert.Equal(t, 1, calls)
+
+			// Count
+			count, err := store.Count(ctx, Filter{})
+			require.NoError(t, err)
+			assert.Equal(t, 3, count)
+			count, err = store.Count(ctx, Filter{ErrorCode: "ERR_FORWARDING_FAILED", Since: base.Add(time.Minute)})
+			require.NoError(t, err)
+			assert.Equal(t, 1, count)
+
+			// Query
+			queried, err := store.(Querier).Query(ctx, Filter{ErrorCode: "ERR_FORWARDING_FAILED"})
+			require.NoError(t, err)
+			require.Len(t, queried, 2)
+			assert.Equal(t, "batch-1", queried[0].BatchID)
+			assert.Equal(t, "batch-2", queried[1].BatchID)
+
+			// Put replaces, Delete removes
+			require.NoError(t, store.Put(ctx, "batch-2", Message{BatchID: "batch-2", FBSender: "fb-cl"}))
+			message, err = store.Get(ctx, "batch-2")
+			require.NoError(t, err)
+			assert.Equal(t, "fb-cl", message.FBSender)
+
+			require.NoError(t, store.Delete(ctx, "batch-2"))
+			require.NoError(t, store.Delete(ctx, "batch-2"))
+			_, err = store.Get(ctx, "batch-2")
+			assert.True(t, errors.Is(err, ErrNotFound))
+			count, err = store.Count(ctx, Filter{})
+			require.NoError(t, err)
+			assert.Equal(t, 2, count)
+		})
+	}
+}
+
+func TestStore_DeleteWhileIterating(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, key := range []string{"batch-1", "batch-2", "batch-3"} {
+				require.NoError(t, store.Put(ctx, key, Message{BatchID: key}))
+			}
+
+			// dlq-replay deletes messages as it replays them
+			iterated := 0
+			require.NoError(t, store.Iterate(ctx, Filter{}, func(record Record) error {
+				iterated++
+				return store.Delete(ctx, record.Key)
+			}))
+			assert.Equal(t, 3, iterated)
+
+			count, err := store.Count(ctx, Filter{})
+			require.NoError(t, err)
+			assert.Equal(t, 0, count)
+		})
+	}
+}
+
+func TestLevelDBStore_UnreadableMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq")
+	store, err := OpenLevelDB(path, false)
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "batch-1", Message{BatchID: "batch-1", FBSender: "fb-gw"}))
+	require.NoError(t, store.db.Put([]byte("corrupt"), []byte("not json"), nil))
+
+	// Unreadable messages match every filter, so they are counted and
+	// iterated with an error, but never queried
+	count, err := store.Count(ctx, Filter{FBSender: "fb-dp"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	var records []Record
+	require.NoError(t, store.Iterate(ctx, Filter{}, func(record Record) error {
+		records = append(records, record)
+		return nil
+	}))
+	require.Len(t, records, 2)
+	assert.Equal(t, "batch-1", records[0].Key)
+	assert.NoError(t, records[0].Err)
+	assert.Equal(t, "corrupt", records[1].Key)
+	assert.Error(t, records[1].Err)
+	assert.Equal(t, len("not json"), records[1].Size)
+
+	_, err = store.Get(ctx, "corrupt")
+	assert.Error(t, err)
+
+	messages, err := store.Query(ctx, Filter{})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "batch-1", messages[0].BatchID)
+}
@@ -3,8 +3,14 @@ package dp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"eidc-tfk8s/internal/common/logging"
@@ -13,6 +19,9 @@ import (
 	"eidc-tfk8s/internal/common/tracing"
 	"eidc-tfk8s/internal/config"
 	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -27,16 +36,90 @@ type DPConfig struct {
 	StorageType  string   `json:"storageType"`
 	TTLMinutes   int      `json:"ttlMinutes"`
 	GCInterval   string   `json:"gcInterval"`
+
+	// DeduplicationKey lists the metric fields that identify a duplicate.
+	// Fields in nested maps are given as dotted paths, e.g. "resource.host.id".
 	DeduplicationKey []string `json:"deduplicationKey"`
+
+	// KeyMode selects how deduplication keys are built: "fields" (default)
+	// uses DeduplicationKey, "template" executes DeduplicationKeyTemplate
+	// and falls back to DeduplicationKey if the template fails on a metric
+	KeyMode                  string `json:"keyMode"`
+	DeduplicationKeyTemplate string `json:"deduplicationKeyTemplate"`
+
+	// MaxBatchItems caps the number of metrics in a batch, bounding the
+	// memory deduplicating it takes. Larger batches are sent to the DLQ, to
+	// be replayed once the limit is raised or split upstream. 0 means no
+	// limit.
+	MaxBatchItems int `json:"maxBatchItems"`
+
+	// Tiers are further deduplication windows, each with its own key fields
+	// and TTL, checked in order after the default window defined by the
+	// fields above. A metric is dropped if any window has seen it, e.g. a
+	// short window keyed on every field and a longer one keyed on fewer.
+	// Each tier has its own store, so adding or removing tiers rebuilds the
+	// stores.
+	Tiers []DedupTier `json:"tiers,omitempty"`
 	
 	// Persistent storage configuration
 	PersistentStorage struct {
 		Enabled         bool   `json:"enabled"`
 		Path            string `json:"path"`
 		VolumeClaimName string `json:"volumeClaimName"`
+
+		// Memory store snapshots, used when storageType is "memory"
+		SnapshotInterval   string `json:"snapshotInterval"`
+		SnapshotMaxEntries int    `json:"snapshotMaxEntries"`
 	} `json:"persistentStorage"`
 }
 
+// DedupTier is a deduplication window with its own key fields and TTL
+type DedupTier struct {
+	// Name identifies the tier in metrics and store paths
+	Name string `json:"name"`
+
+	// DeduplicationKey lists the metric fields that identify a duplicate
+	// within the tier, as DPConfig.DeduplicationKey does
+	DeduplicationKey []string `json:"deduplicationKey"`
+
+	// TTLMinutes is how long the tier remembers a metric
+	TTLMinutes int `json:"ttlMinutes"`
+}
+
+// DefaultTier names the deduplication window defined by DeduplicationKey,
+// DeduplicationKeyTemplate and TTLMinutes
+const DefaultTier = "default"
+
+// tierNames returns the names of the deduplication tiers of a config, the
+// default tier first
+func (c *DPConfig) tierNames() []string {
+	names := make([]string, 0, len(c.Tiers)+1)
+	names = append(names, DefaultTier)
+	for _, tier := range c.Tiers {
+		names = append(names, tier.Name)
+	}
+	return names
+}
+
+var tierDeduplicatedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_dp_tier_deduplicated_total",
+	Help: "Total number of metrics deduplicated, by the tier that had seen them",
+}, []string{"tier"})
+
+// tierNamePattern is what tier names must match. Tier names become part of
+// their stores' paths, so they can't contain separators or dots.
+var tierNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// memorySnapshotFile is the file name of the memory store snapshot in the persistent storage path
+const memorySnapshotFile = "dedup-memory.snapshot"
+
+// errBatchTooLarge is returned for batches over MaxBatchItems
+var errBatchTooLarge = errors.New("batch exceeds maxBatchItems")
+
+// contextCheckInterval is how many metrics are deduplicated between checks
+// for a cancelled request
+const contextCheckInterval = 100
+
 // DP implements the FB-DP (Deduplication) function block
 type DP struct {
 	fb.BaseFunctionBlock
@@ -44,13 +127,16 @@ type DP struct {
 	metrics         *metrics.FBMetrics
 	tracer          *tracing.Tracer
 	config          *DPConfig
+	keyTemplate     *template.Template
 	configMu        sync.RWMutex
 	nextFBClient    fb.ChainPushServiceClient
 	nextFBConn      *grpc.ClientConn
 	dlqClient       fb.ChainPushServiceClient
 	dlqConn         *grpc.ClientConn
-	circuitBreaker  *resilience.CircuitBreaker
-	store           DeduplicationStore
+	nextFBBreaker   *resilience.CircuitBreaker
+	dlqBreaker      *resilience.CircuitBreaker
+	stores          map[string]DeduplicationStore
+	storePaths      map[string]string
 	storeMu         sync.RWMutex
 	gcCtx           context.Context
 	gcCancel        context.CancelFunc
@@ -63,10 +149,7 @@ func NewDP() *DP {
 	gcCtx, gcCancel := context.WithCancel(context.Background())
 	
 	return &DP{
-		BaseFunctionBlock: fb.BaseFunctionBlock{
-			name:  "fb-dp",
-			ready: false,
-		},
+		BaseFunctionBlock: fb.NewBaseFunctionBlock("fb-dp"),
 		logger:    logging.NewLogger("fb-dp"),
 		metrics:   metrics.NewFBMetrics("fb-dp"),
 		tracer:    tracing.NewTracer("fb-dp"),
@@ -80,11 +163,16 @@ func NewDP() *DP {
 func (d *DP) Initialize(ctx context.Context) error {
 	d.logger.Info("Initializing FB-DP", nil)
 
-	// Initialize circuit breaker with default config
-	d.circuitBreaker = resilience.NewCircuitBreaker("fb-dp", resilience.DefaultCircuitBreakerConfig())
+	// Initialize circuit breakers with default config
+	d.nextFBBreaker = resilience.NewCircuitBreaker("fb-dp", resilience.DownstreamNextFB, resilience.DefaultCircuitBreakerConfig())
+	d.dlqBreaker = resilience.NewCircuitBreaker("fb-dp", resilience.DownstreamDLQ, resilience.DefaultCircuitBreakerConfig())
+
+	if err := d.Transition(fb.StateInitialized); err != nil {
+		return err
+	}
 
 	// Mark as ready (full readiness will be set after config is loaded)
-	d.BaseFunctionBlock.ready = true
+	d.SetReady(true)
 
 	return nil
 }
@@ -97,118 +185,285 @@ func (d *DP) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.Proce
 
 	// Record metric
 	d.metrics.RecordBatchReceived()
+	d.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeReceived)
+
+	// Reject batches until config has been applied, and while shutting down
+	done, result, err := d.BeginBatch(batch.BatchID)
+	if err != nil {
+		return result, err
+	}
+	defer done()
+
+	// Send batches whose data was corrupted in transit to the DLQ
+	if result, err := d.VerifyChecksum(ctx, batch, d.sendToDLQ); err != nil {
+		return result, err
+	}
+
+	// Forward unchanged if this FB is disabled in the pipeline config
+	if !d.BaseFunctionBlock.Enabled() {
+		return d.BypassBatch(ctx, batch, d.forwardToNextFB)
+	}
+
+	// Capture the batch as received if the debug tap samples it
+	d.CaptureBatch(ctx, fb.DebugStageIn, batch)
 
 	startTime := time.Now()
 
-	// Process batch
-	processingErr := d.processBatch(ctx, batch)
+	// Process batch with the config as it is now, whatever UpdateConfig
+	// does in the meantime
+	processingErr := d.processBatch(ctx, batch, d.snapshotConfig())
 	if processingErr != nil {
 		d.metrics.RecordProcessingError()
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeProcessingFailed, processingErr, false), processingErr
+
+		// Batches this FB can't decode, or that are too large, won't
+		// succeed on retry
+		if errors.Is(processingErr, codec.ErrUnknownFormat) || errors.Is(processingErr, errBatchTooLarge) {
+			if dlqErr := d.sendToDLQ(ctx, batch, processingErr); dlqErr != nil {
+				if errors.Is(dlqErr, fb.ErrNoDLQ) {
+					return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeInvalidInput, processingErr), processingErr
+				}
+				d.logger.Error("Failed to send invalid batch to DLQ", dlqErr, map[string]interface{}{
+					"batch_id": batch.BatchID,
+					"format":   batch.Format,
+				})
+				return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
+			}
+			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeInvalidInput, processingErr, true), processingErr
+		}
+		if errors.Is(processingErr, context.Canceled) || errors.Is(processingErr, context.DeadlineExceeded) {
+			return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeTimeout, processingErr), processingErr
+		}
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeProcessingFailed, processingErr), processingErr
 	}
 
 	// Record processing metrics
 	d.metrics.RecordBatchProcessed(time.Since(startTime).Seconds())
 
+	// Capture the batch as forwarded if the debug tap samples it
+	d.CaptureBatch(ctx, fb.DebugStageOut, batch)
+
 	// Forward to next FB
 	forwardingResult, forwardingErr := d.forwardToNextFB(ctx, batch)
 	if forwardingErr != nil {
-		// If forwarding fails but processing succeeded, attempt to send to DLQ
-		dlqErr := d.sendToDLQ(ctx, batch, forwardingErr)
+		// Batches the next FB rejected for good go to the quarantine, if
+		// any, and are reported upstream with its code so they aren't retried
+		code := fb.ForwardingErrorCode(forwardingErr)
+		dlqErr := d.SendFailedBatch(ctx, batch, code, forwardingErr, d.sendToDLQ)
 		if dlqErr != nil {
-			d.logger.Error("Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
+			if errors.Is(dlqErr, fb.ErrNoDLQ) {
+				return fb.NewCodeErrorResult(batch.BatchID, code, forwardingErr), forwardingErr
+			}
+			d.logger.ErrorCtx(ctx, "Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
 				"batch_id": batch.BatchID,
 			})
-			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeDLQSendFailed, dlqErr, false), dlqErr
+			return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
 		}
 		
 		// Return error with DLQ status
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, forwardingErr, true), forwardingErr
+		return fb.NewErrorResult(batch.BatchID, code, forwardingErr, true), forwardingErr
 	}
 
 	return forwardingResult, nil
 }
 
-// processBatch performs the actual batch processing
-func (d *DP) processBatch(ctx context.Context, batch *fb.MetricBatch) error {
+// dpConfigSnapshot is the config a batch is deduplicated with
+type dpConfigSnapshot struct {
+	enabled           bool
+	deduplicationKeys []string
+	keyTemplate       *template.Template
+	ttlMinutes        int
+	maxBatchItems     int
+	tiers             []DedupTier
+}
+
+// snapshotConfig copies the config a batch is deduplicated with in one go,
+// so that an UpdateConfig while the batch is processed can't mix old and
+// new settings. UpdateConfig replaces the config rather than modifying it,
+// so the copy can share its slices.
+func (d *DP) snapshotConfig() dpConfigSnapshot {
+	d.configMu.RLock()
+	defer d.configMu.RUnlock()
+
+	return dpConfigSnapshot{
+		enabled:           d.config.Enabled,
+		deduplicationKeys: d.config.DeduplicationKey,
+		keyTemplate:       d.keyTemplate,
+		ttlMinutes:        d.config.TTLMinutes,
+		maxBatchItems:     d.config.MaxBatchItems,
+		tiers:             d.config.Tiers,
+	}
+}
+
+// dedupTier is a deduplication tier of a config snapshot with its store
+type dedupTier struct {
+	name              string
+	deduplicationKeys []string
+	keyTemplate       *template.Template
+	ttl               time.Duration
+	store             DeduplicationStore
+}
+
+// dedupTiers returns the tiers of the snapshot that have a store, the
+// default tier first
+func (cfg dpConfigSnapshot) dedupTiers(stores map[string]DeduplicationStore) []dedupTier {
+	tiers := make([]dedupTier, 0, len(cfg.tiers)+1)
+	if store := stores[DefaultTier]; store != nil && (len(cfg.deduplicationKeys) > 0 || cfg.keyTemplate != nil) {
+		tiers = append(tiers, dedupTier{
+			name:              DefaultTier,
+			deduplicationKeys: cfg.deduplicationKeys,
+			keyTemplate:       cfg.keyTemplate,
+			ttl:               time.Duration(cfg.ttlMinutes) * time.Minute,
+			store:             store,
+		})
+	}
+	for _, tier := range cfg.tiers {
+		if store := stores[tier.Name]; store != nil {
+			tiers = append(tiers, dedupTier{
+				name:              tier.Name,
+				deduplicationKeys: tier.DeduplicationKey,
+				ttl:               time.Duration(tier.TTLMinutes) * time.Minute,
+				store:             store,
+			})
+		}
+	}
+	return tiers
+}
+
+// processBatch performs the actual batch processing with the config
+// snapshot cfg
+func (d *DP) processBatch(ctx context.Context, batch *fb.MetricBatch, cfg dpConfigSnapshot) error {
 	// Create child span for deduplication
 	ctx, span := d.tracer.StartSpan(ctx, "deduplication", nil)
 	defer span.End()
 
 	// Skip deduplication if not enabled
-	d.configMu.RLock()
-	enabled := d.config.Enabled
-	deduplicationKeys := d.config.DeduplicationKey
-	ttlMinutes := d.config.TTLMinutes
-	d.configMu.RUnlock()
-
-	if !enabled || len(deduplicationKeys) == 0 {
+	if !cfg.enabled || (len(cfg.deduplicationKeys) == 0 && cfg.keyTemplate == nil && len(cfg.tiers) == 0) {
 		d.logger.Debug("Deduplication disabled or no deduplication keys configured", nil)
 		return nil
 	}
 
-	// Get the store
+	// Get the stores
 	d.storeMu.RLock()
-	store := d.store
+	stores := d.stores
 	d.storeMu.RUnlock()
 	
 	// Ensure we have a store
-	if store == nil {
+	if stores[DefaultTier] == nil {
 		return fmt.Errorf("deduplication store not initialized")
 	}
+	tiers := cfg.dedupTiers(stores)
 
 	// Deserialize the batch data
-	var metrics []map[string]interface{}
-	if err := json.Unmarshal(batch.Data, &metrics); err != nil {
-		return fmt.Errorf("failed to deserialize metrics: %w", err)
+	metrics, err := codec.Decode(batch)
+	if err != nil {
+		return err
+	}
+	if cfg.maxBatchItems > 0 && len(metrics) > cfg.maxBatchItems {
+		return fmt.Errorf("%w: %d metrics, limit %d", errBatchTooLarge, len(metrics), cfg.maxBatchItems)
 	}
 
-	// Process each metric
-	var uniqueMetrics []map[string]interface{}
+	// Process each metric. The keys of unique metrics are only stored once
+	// the whole batch is through, so a cancelled batch leaves the store
+	// untouched and its retry isn't deduplicated against itself. Unique
+	// metrics are compacted to the front of the decoded slice rather than
+	// copied to a new one.
+	uniqueMetrics := metrics[:0]
 	var dedupCount int
-
-	for _, metric := range metrics {
-		// Create a deduplication key from the metric using the configured keys
-		dedupKey, err := createDeduplicationKey(metric, deduplicationKeys)
-		if err != nil {
-			d.logger.Warn("Failed to create deduplication key, including metric", err, map[string]interface{}{
-				"metric": metric,
-			})
-			uniqueMetrics = append(uniqueMetrics, metric)
-			continue
+	newKeys := make([][][]byte, len(tiers))
+	batchKeys := make([]map[string]bool, len(tiers))
+	tierCounts := make([]int, len(tiers))
+	for t := range tiers {
+		newKeys[t] = make([][]byte, 0, len(metrics))
+		batchKeys[t] = make(map[string]bool, len(metrics))
+	}
+	metricKeys := make([][]byte, len(tiers))
+	debug := d.logger.Enabled(logging.Debug)
+
+	for i, metric := range metrics {
+		// Stop working through the batch once the request is cancelled
+		if i%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("deduplication cancelled after %d of %d metrics: %w", i, len(metrics), err)
+			}
 		}
 
-		// Check if we've seen this metric before
-		exists, err := store.Has(dedupKey)
-		if err != nil {
-			d.logger.Warn("Failed to check deduplication key, including metric", err, map[string]interface{}{
-				"metric": metric,
-			})
-			uniqueMetrics = append(uniqueMetrics, metric)
-			continue
+		// Check the tiers in order until one has seen the metric, in an
+		// earlier batch or earlier in this one. A tier the metric has no
+		// key for, or whose store fails, doesn't deduplicate it.
+		duplicateTier := -1
+		for t, tier := range tiers {
+			metricKeys[t] = nil
+
+			// Create a deduplication key from the metric using the tier's keys
+			dedupKey, err := buildDeduplicationKey(metric, tier.deduplicationKeys, tier.keyTemplate)
+			if err != nil {
+				d.logger.Warn("Failed to create deduplication key, not deduplicating metric in tier", map[string]interface{}{
+					"tier":   tier.name,
+					"metric": metric,
+					"error":  err.Error(),
+				})
+				continue
+			}
+
+			exists := batchKeys[t][string(dedupKey)]
+			if !exists {
+				exists, err = tier.store.Has(dedupKey)
+				if err != nil {
+					d.logger.Warn("Failed to check deduplication key, not deduplicating metric in tier", map[string]interface{}{
+						"tier":   tier.name,
+						"metric": metric,
+						"error":  err.Error(),
+					})
+					continue
+				}
+			}
+
+			metricKeys[t] = dedupKey
+			if exists {
+				duplicateTier = t
+				break
+			}
 		}
 
-		if exists {
+		if duplicateTier >= 0 {
 			// Metric is a duplicate, skip it
 			dedupCount++
-			d.logger.Debug("Deduplicated metric", map[string]interface{}{
-				"dedup_key": string(dedupKey),
-			})
+			tierCounts[duplicateTier]++
+			if debug {
+				d.logger.Debug("Deduplicated metric", map[string]interface{}{
+					"tier":      tiers[duplicateTier].name,
+					"dedup_key": string(metricKeys[duplicateTier]),
+				})
+			}
 			continue
 		}
 
-		// Metric is unique, add it to the store
-		ttl := time.Duration(ttlMinutes) * time.Minute
-		if err := store.Put(dedupKey, ttl); err != nil {
-			d.logger.Warn("Failed to store deduplication key, including metric anyway", err, map[string]interface{}{
-				"metric": metric,
-			})
+		// Metric is unique, remember its keys and add it to the uniqueMetrics list
+		for t, dedupKey := range metricKeys {
+			if dedupKey != nil {
+				batchKeys[t][string(dedupKey)] = true
+				newKeys[t] = append(newKeys[t], dedupKey)
+			}
 		}
-
-		// Add the metric to the uniqueMetrics list
 		uniqueMetrics = append(uniqueMetrics, metric)
 	}
 
+	// Add the keys of the unique metrics to the store of each tier
+	for t, tier := range tiers {
+		for _, key := range newKeys[t] {
+			if err := tier.store.Put(key, tier.ttl); err != nil {
+				d.logger.Warn("Failed to store deduplication key, metric included anyway", map[string]interface{}{
+					"tier":      tier.name,
+					"dedup_key": string(key),
+					"error":     err.Error(),
+				})
+			}
+		}
+		if tierCounts[t] > 0 {
+			tierDeduplicatedTotal.WithLabelValues(tier.name).Add(float64(tierCounts[t]))
+		}
+	}
+
 	// Update deduplication counter
 	if dedupCount > 0 {
 		d.dedupCounter.Add(float64(dedupCount))
@@ -218,23 +473,36 @@ func (d *DP) processBatch(ctx context.Context, batch *fb.MetricBatch) error {
 	}
 
 	// Replace batch data with filtered metrics
-	if len(uniqueMetrics) != len(metrics) {
-		filteredData, err := json.Marshal(uniqueMetrics)
-		if err != nil {
-			return fmt.Errorf("failed to serialize filtered metrics: %w", err)
+	if dedupCount > 0 {
+		if err := codec.Encode(batch, uniqueMetrics); err != nil {
+			return err
 		}
-		batch.Data = filteredData
 	}
 
 	return nil
 }
 
-// createDeduplicationKey creates a unique key for a metric based on the configured deduplication keys
+// buildDeduplicationKey creates the key for a metric using the key template
+// if one is configured, falling back to the field list
+func buildDeduplicationKey(metric map[string]interface{}, deduplicationKeys []string, keyTemplate *template.Template) ([]byte, error) {
+	if keyTemplate != nil {
+		key, err := createTemplateDeduplicationKey(metric, keyTemplate)
+		if err == nil || len(deduplicationKeys) == 0 {
+			return key, err
+		}
+	}
+
+	return createDeduplicationKey(metric, deduplicationKeys)
+}
+
+// createDeduplicationKey creates a unique key for a metric based on the
+// configured deduplication keys. Keys may be dotted paths into nested maps,
+// such as "resource.host.id".
 func createDeduplicationKey(metric map[string]interface{}, deduplicationKeys []string) ([]byte, error) {
 	// Create a map with just the fields used for deduplication
 	keyMap := make(map[string]interface{})
 	for _, key := range deduplicationKeys {
-		if val, ok := metric[key]; ok {
+		if val, ok := lookupField(metric, key); ok {
 			keyMap[key] = val
 		} else {
 			return nil, fmt.Errorf("deduplication key %s not found in metric", key)
@@ -250,12 +518,43 @@ func createDeduplicationKey(metric map[string]interface{}, deduplicationKeys []s
 	return keyJSON, nil
 }
 
+// lookupField returns the value of a metric field. A key that is not a
+// top-level field is followed as a dotted path through nested maps; a path
+// whose intermediate objects are missing or not maps is not found.
+func lookupField(metric map[string]interface{}, key string) (interface{}, bool) {
+	if val, ok := metric[key]; ok {
+		return val, true
+	}
+
+	parts := strings.Split(key, ".")
+	if len(parts) == 1 {
+		return nil, false
+	}
+
+	var current interface{} = metric
+	for _, part := range parts {
+		fields, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = fields[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
 // forwardToNextFB forwards the batch to the next function block
 func (d *DP) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	// Fan out instead if several next FBs are configured
+	if d.FansOut() {
+		return d.ForwardToNextFBs(ctx, batch, d.sendToDLQ)
+	}
+
 	startTime := time.Now()
 
 	// Use circuit breaker to protect against downstream failures
-	err := d.circuitBreaker.Execute(ctx, func(ctx context.Context) error {
+	err := d.nextFBBreaker.Execute(ctx, func(ctx context.Context) error {
 		// Get the current config
 		d.configMu.RLock()
 		nextFB := d.config.Common.NextFB
@@ -289,7 +588,7 @@ func (d *DP) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*fb.Pr
 
 		// Check response
 		if res.Status != fb.StatusSuccess {
-			return fmt.Errorf("next FB returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+			return fb.NewNextFBError("", res)
 		}
 
 		return nil
@@ -299,17 +598,24 @@ func (d *DP) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*fb.Pr
 	d.metrics.RecordBatchForwarded(time.Since(startTime).Seconds())
 
 	if err != nil {
+		d.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeFailed)
 		if err == resilience.ErrCircuitOpen {
-			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err, false), err
+			return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err), err
 		}
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err, false), err
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err), err
 	}
 
+	d.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeForwarded)
 	return fb.NewSuccessResult(batch.BatchID), nil
 }
 
 // sendToDLQ sends a batch to the Dead Letter Queue
 func (d *DP) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr error) error {
+	// Without a DLQ the batch is failed back to the caller
+	if !d.HasDLQ() {
+		return fb.ErrNoDLQ
+	}
+
 	// Create child span for DLQ
 	ctx, span := d.tracer.StartSpan(ctx, "send-to-dlq", nil)
 	defer span.End()
@@ -324,7 +630,17 @@ func (d *DP) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr e
 		batch.InternalLabels = make(map[string]string)
 	}
 	batch.InternalLabels["error"] = originalErr.Error()
-	batch.InternalLabels["fb_sender"] = d.Name()
+	batch.InternalLabels["fb_sender"] = d.Sender()
+
+	// Drop poison batches rather than cycle them through the DLQ forever
+	if d.RecordDLQHop(batch.InternalLabels) {
+		d.logger.Warn("Dropping poison batch", map[string]interface{}{
+			"batch_id":     batch.BatchID,
+			"replay_count": batch.InternalLabels[fb.LabelReplayCount],
+			"error":        originalErr.Error(),
+		})
+		return fb.ErrPoisonBatch
+	}
 
 	// Convert to ChainPushService request
 	req := &fb.MetricBatchRequest{
@@ -338,59 +654,132 @@ func (d *DP) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr e
 	}
 
 	// Send to DLQ
-	res, err := d.dlqClient.PushMetrics(ctx, req)
-	if err != nil {
-		return fmt.Errorf("failed to push metrics to DLQ: %w", err)
-	}
+	err := d.dlqBreaker.Execute(ctx, func(ctx context.Context) error {
+		res, err := d.dlqClient.PushMetrics(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to DLQ: %w", err)
+		}
+
+		// Check response
+		if res.Status != fb.StatusSuccess {
+			return fmt.Errorf("DLQ returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		}
 
-	// Check response
-	if res.Status != fb.StatusSuccess {
-		return fmt.Errorf("DLQ returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Record metric
 	d.metrics.RecordBatchDLQ()
+	d.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeDropped)
 
 	return nil
 }
 
-// UpdateConfig updates the Deduplication function block's configuration
+// UpdateConfig updates the Deduplication function block's configuration.
+// The storage backend is kept as is once initialized, unless the settings it
+// is built from change.
 func (d *DP) UpdateConfig(ctx context.Context, configBytes []byte, generation int64) error {
+	return d.applyConfig(ctx, configBytes, generation, false)
+}
+
+// Reinitialize applies a configuration that requires a restart, rebuilding
+// the storage backend from the new configuration
+func (d *DP) Reinitialize(ctx context.Context, configBytes []byte, generation int64) error {
+	return d.applyConfig(ctx, configBytes, generation, true)
+}
+
+// applyConfig parses, validates and applies a configuration, reinitializing
+// the storage backend if requested, if its settings changed or if there is
+// none yet
+func (d *DP) applyConfig(ctx context.Context, configBytes []byte, generation int64, reinitialize bool) error {
 	// Create child span for config update
 	ctx, span := d.tracer.StartSpan(ctx, "update-config", nil)
 	defer span.End()
 
-	// Parse configuration
-	var newConfig DPConfig
-	if err := json.Unmarshal(configBytes, &newConfig); err != nil {
-		return fmt.Errorf("failed to parse config: %w", err)
+	// Parse and validate configuration
+	newConfig, err := d.parseConfig(configBytes)
+	if err != nil {
+		return err
 	}
 
-	// Validate configuration
-	if err := d.validateConfig(&newConfig); err != nil {
-		return fmt.Errorf("invalid config: %w", err)
+	// Refuse a generation older than the one applied, unless rolling back
+	if err := d.CheckConfigGeneration(generation, newConfig.Common.Rollback); err != nil {
+		d.logger.Error("Refused configuration", err, map[string]interface{}{
+			"generation":         generation,
+			"applied_generation": d.GetConfigGeneration(),
+		})
+		return err
+	}
+
+	// Parse the key template; validateConfig has already checked it
+	var keyTemplate *template.Template
+	if newConfig.KeyMode == KeyModeTemplate {
+		parsed, err := parseKeyTemplate(newConfig.DeduplicationKeyTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+		keyTemplate = parsed
 	}
 
-	// Initialize or update storage backend
-	if err := d.initializeStore(&newConfig); err != nil {
-		return fmt.Errorf("failed to initialize storage: %w", err)
+	// Initialize storage backend on first config, when reinitializing and
+	// when the settings it is built from changed
+	d.storeMu.RLock()
+	hasStore := d.stores[DefaultTier] != nil
+	d.storeMu.RUnlock()
+
+	d.configMu.RLock()
+	storageChanged := d.config != nil && storageSettingsChanged(d.config, newConfig)
+	d.configMu.RUnlock()
+
+	if storageChanged && !reinitialize {
+		d.logger.Info("Storage settings changed; reinitializing the store", map[string]interface{}{
+			"generation":   generation,
+			"storage_type": newConfig.StorageType,
+		})
+		reinitialize = true
+	}
+
+	if reinitialize || !hasStore {
+		if err := d.initializeStore(newConfig); err != nil {
+			return fmt.Errorf("failed to initialize storage: %w", err)
+		}
 	}
 
 	// Apply configuration
 	d.configMu.Lock()
-	d.config = &newConfig
+	oldConfig := d.config
+	d.config = newConfig
+	d.keyTemplate = keyTemplate
 	d.configGeneration = generation
+	d.SetEnabled(newConfig.Common.IsEnabled())
+	d.SetMaxConcurrentBatches(newConfig.Common.MaxConcurrentBatches)
+	d.SetMaxReplayCount(newConfig.Common.MaxReplayCount)
+	d.SetVerifyChecksum(newConfig.Common.VerifyChecksum)
+	d.SetHasDLQ(newConfig.Common.DLQ != "")
+	d.SetMetadataLimits(fb.MetadataLimits{
+		MaxEntries: newConfig.Common.MaxMetadataEntries,
+		MaxBytes:   newConfig.Common.MaxMetadataBytes,
+		Action:     fb.MetadataLimitAction(newConfig.Common.MetadataLimitAction),
+	})
+	if level, err := logging.ParseLevel(newConfig.Common.LogLevel); err == nil {
+		d.logger.SetLevel(level)
+	}
+	if newConfig.Common.TracingEnabled {
+		d.tracer.SetSamplingRatio(newConfig.Common.TraceSamplingRatio)
+	}
+	seed, _ := newConfig.Common.DeterministicSeed()
+	d.tracer.SetSeed(seed)
 	d.configMu.Unlock()
 
 	// Update circuit breaker configuration
-	d.circuitBreaker = resilience.NewCircuitBreaker("fb-dp", resilience.CircuitBreakerConfig{
-		ErrorThresholdPercentage: newConfig.Common.CircuitBreaker.ErrorThresholdPercentage,
-		OpenStateSeconds:         newConfig.Common.CircuitBreaker.OpenStateSeconds,
-		HalfOpenRequestThreshold: newConfig.Common.CircuitBreaker.HalfOpenRequestThreshold,
-	})
+	d.nextFBBreaker = resilience.NewCircuitBreaker("fb-dp", resilience.DownstreamNextFB, newConfig.Common.NextFBCircuitBreakerConfig())
+	d.dlqBreaker = resilience.NewCircuitBreaker("fb-dp", resilience.DownstreamDLQ, newConfig.Common.DLQCircuitBreakerConfig())
 
 	// Connect to next FB and DLQ if not already connected
-	if d.nextFBClient == nil {
+	if d.nextFBClient == nil && newConfig.Common.NextFB != "" {
 		if err := d.connectToNextFB(ctx, newConfig.Common.NextFB); err != nil {
 			d.logger.Error("Failed to connect to next FB", err, map[string]interface{}{
 				"next_fb": newConfig.Common.NextFB,
@@ -399,7 +788,15 @@ func (d *DP) UpdateConfig(ctx context.Context, configBytes []byte, generation in
 		}
 	}
 
-	if d.dlqClient == nil {
+	if err := d.ConnectNextFBs(ctx, newConfig.Common, metrics.GRPCDialOption(d.Name())); err != nil {
+		d.logger.Error("Failed to connect to next FBs", err, map[string]interface{}{
+			"next_fbs": len(newConfig.Common.NextFBs),
+		})
+		// Don't fail config update on connection error - batches to the
+		// targets not connected fail until the next update
+	}
+
+	if d.dlqClient == nil && newConfig.Common.DLQ != "" {
 		if err := d.connectToDLQ(ctx, newConfig.Common.DLQ); err != nil {
 			d.logger.Error("Failed to connect to DLQ", err, map[string]interface{}{
 				"dlq": newConfig.Common.DLQ,
@@ -408,51 +805,182 @@ func (d *DP) UpdateConfig(ctx context.Context, configBytes []byte, generation in
 		}
 	}
 
+	if err := d.ConnectQuarantine(ctx, newConfig.Common.Quarantine); err != nil {
+		d.logger.Error("Failed to connect to quarantine", err, map[string]interface{}{
+			"quarantine": newConfig.Common.Quarantine,
+		})
+		// Don't fail config update on connection error - we'll retry on next update
+	}
+
+	if err := d.ConfigureDebugTap(ctx, newConfig.Common.DebugTap, codec.Decode); err != nil {
+		d.logger.Error("Failed to configure debug tap", err, nil)
+		// Don't fail config update on a debug tap error - batches just aren't captured
+	}
+
+	// Start processing now that config is applied
+	if err := d.ConfigApplied(); err != nil {
+		return err
+	}
+
 	// Update metrics
 	d.metrics.SetConfigGeneration(generation)
+	d.SetReady(true)
 	d.metrics.SetReady(true)
 
+	// Report what the update changed, for rollout audits
+	changes, _ := config.DiffConfig(oldConfig, newConfig)
+
 	d.logger.Info("Config updated", map[string]interface{}{
-		"generation":  generation,
-		"enabled":     newConfig.Enabled,
-		"storage_type": newConfig.StorageType,
-		"persistent":  newConfig.PersistentStorage.Enabled,
-		"ttl_minutes": newConfig.TTLMinutes,
+		"generation":    generation,
+		"enabled":       newConfig.Enabled,
+		"storage_type":  newConfig.StorageType,
+		"persistent":    newConfig.PersistentStorage.Enabled,
+		"ttl_minutes":   newConfig.TTLMinutes,
+		"reinitialized": reinitialize,
+		"changes":       changes,
 	})
 
 	return nil
 }
 
-// initializeStore initializes the deduplication storage backend
+// storageSettingsChanged reports whether the settings the storage backend is
+// built from differ between two configurations
+func storageSettingsChanged(oldConfig, newConfig *DPConfig) bool {
+	return oldConfig.StorageType != newConfig.StorageType ||
+		oldConfig.GCInterval != newConfig.GCInterval ||
+		oldConfig.PersistentStorage != newConfig.PersistentStorage ||
+		!reflect.DeepEqual(oldConfig.tierNames(), newConfig.tierNames())
+}
+
+// storeProbeKey is looked up to check that a newly opened store is usable
+var storeProbeKey = []byte("fb-dp-store-probe")
+
+// initializeStore initializes the deduplication storage backend, a store
+// for each tier. The new stores are opened and checked before the current
+// ones are touched, so if that fails the current stores stay active and keep
+// deduplicating.
 func (d *DP) initializeStore(config *DPConfig) error {
 	d.storeMu.Lock()
 	defer d.storeMu.Unlock()
 
-	// Close existing store if any
-	if d.store != nil {
-		if err := d.store.Close(); err != nil {
-			d.logger.Error("Failed to close existing store", err, nil)
-			// Continue to initialize new store
+	// Flush first so new stores can pick up the existing keys
+	for tier, store := range d.stores {
+		if err := store.Flush(); err != nil {
+			d.logger.Error("Failed to flush existing store", err, map[string]interface{}{
+				"tier": tier,
+			})
 		}
-		d.store = nil
 	}
 
-	// Stop existing GC process if any
-	if d.gcCancel != nil {
-		d.gcCancel()
-		// Create new cancellable context for GC process
-		d.gcCtx, d.gcCancel = context.WithCancel(context.Background())
+	// Background work for the new stores runs under its own context, so the
+	// current stores' GC keeps running until the swap
+	gcCtx, gcCancel := context.WithCancel(context.Background())
+
+	stores := make(map[string]DeduplicationStore, len(config.Tiers)+1)
+	storePaths := make(map[string]string, len(config.Tiers)+1)
+	closeNew := func() {
+		gcCancel()
+		for tier, store := range stores {
+			if store != d.stores[tier] {
+				store.Close()
+			}
+		}
+	}
+
+	for _, tier := range config.tierNames() {
+		store, storePath, err := d.openStore(gcCtx, config, tier)
+		if err != nil {
+			closeNew()
+			return err
+		}
+		stores[tier], storePaths[tier] = store, storePath
+
+		if _, err := store.Has(storeProbeKey); err != nil {
+			closeNew()
+			return fmt.Errorf("new store for tier %s is not usable: %w", tier, err)
+		}
 	}
 
-	// Initialize new store based on configuration
-	var store DeduplicationStore
-	var err error
+	// Swap in the new stores, then stop and close the old ones
+	oldStores, oldGCCancel := d.stores, d.gcCancel
+	d.stores, d.storePaths = stores, storePaths
+	d.gcCtx, d.gcCancel = gcCtx, gcCancel
 
+	if oldGCCancel != nil {
+		oldGCCancel()
+	}
+	for tier, oldStore := range oldStores {
+		if oldStore == stores[tier] {
+			continue
+		}
+		if err := oldStore.Close(); err != nil {
+			d.logger.Error("Failed to close existing store", err, map[string]interface{}{
+				"tier": tier,
+			})
+		}
+	}
+
+	return nil
+}
+
+// tierStorePath returns the path the store of a tier lives at, given the
+// path of the default tier's store. Other tiers' stores live next to it.
+func tierStorePath(path, tier string) string {
+	if tier == DefaultTier {
+		return path
+	}
+	return path + "-" + tier
+}
+
+// openStore opens the storage backend described by config for a tier,
+// starting its background work under gcCtx. It returns the store and the path
+// it lives at. Must be called with storeMu held.
+func (d *DP) openStore(gcCtx context.Context, config *DPConfig, tier string) (DeduplicationStore, string, error) {
 	switch config.StorageType {
 	case "memory":
-		d.logger.Info("Initializing in-memory deduplication store", nil)
+		d.logger.Info("Initializing in-memory deduplication store", map[string]interface{}{
+			"tier": tier,
+		})
 		memStore := NewMemoryStore()
-		store = memStore
+
+		// Restore keys from the last snapshot and keep snapshotting to the PVC
+		var snapshotPath string
+		if config.PersistentStorage.Enabled {
+			snapshotPath = tierStorePath(filepath.Join(config.PersistentStorage.Path, memorySnapshotFile), tier)
+			loaded, err := memStore.EnableSnapshots(snapshotPath, config.PersistentStorage.SnapshotMaxEntries)
+			if err != nil {
+				// Start empty rather than failing; we only lose dedup history
+				d.logger.Error("Failed to load memory store snapshot", err, map[string]interface{}{
+					"path": snapshotPath,
+				})
+			} else {
+				d.logger.Info("Loaded memory store snapshot", map[string]interface{}{
+					"path": snapshotPath,
+					"keys": loaded,
+				})
+			}
+
+			snapshotInterval, err := time.ParseDuration(config.PersistentStorage.SnapshotInterval)
+			if err != nil {
+				snapshotInterval = time.Minute // Default to 1 minute
+			}
+
+			go func() {
+				ticker := time.NewTicker(snapshotInterval)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-gcCtx.Done():
+						return
+					case <-ticker.C:
+						if err := memStore.Flush(); err != nil {
+							d.logger.Error("Failed to write memory store snapshot", err, nil)
+						}
+					}
+				}
+			}()
+		}
 
 		// Start garbage collection for memory store
 		gcInterval, err := time.ParseDuration(config.GCInterval)
@@ -467,7 +995,7 @@ func (d *DP) initializeStore(config *DPConfig) error {
 
 			for {
 				select {
-				case <-d.gcCtx.Done():
+				case <-gcCtx.Done():
 					return
 				case <-ticker.C:
 					memStore.runGC()
@@ -475,6 +1003,8 @@ func (d *DP) initializeStore(config *DPConfig) error {
 			}
 		}()
 
+		return memStore, snapshotPath, nil
+
 	case "badgerdb":
 		// Determine storage path
 		var storagePath string
@@ -483,45 +1013,84 @@ func (d *DP) initializeStore(config *DPConfig) error {
 		} else {
 			storagePath = "/tmp/dedup-badger"
 		}
+		storagePath = tierStorePath(storagePath, tier)
+
+		// BadgerDB locks its directory, so a store already open at this path
+		// is kept rather than reopened
+		badgerStore, ok := d.stores[tier].(*BadgerStore)
+		if ok && d.storePaths[tier] == storagePath {
+			d.logger.Info("Keeping open BadgerDB deduplication store", map[string]interface{}{
+				"tier": tier,
+				"path": storagePath,
+			})
+		} else {
+			d.logger.Info("Initializing BadgerDB deduplication store", map[string]interface{}{
+				"tier":       tier,
+				"path":       storagePath,
+				"persistent": config.PersistentStorage.Enabled,
+			})
 
-		d.logger.Info("Initializing BadgerDB deduplication store", map[string]interface{}{
-			"path":       storagePath,
-			"persistent": config.PersistentStorage.Enabled,
-		})
-
-		// Initialize BadgerDB store
-		badgerStore, err := NewBadgerStore(storagePath)
-		if err != nil {
-			return fmt.Errorf("failed to initialize BadgerDB store: %w", err)
+			// Initialize BadgerDB store
+			var err error
+			badgerStore, err = NewBadgerStore(storagePath)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to initialize BadgerDB store: %w", err)
+			}
 		}
-		store = badgerStore
 
 		// Start BadgerDB garbage collection
 		gcInterval, err := time.ParseDuration(config.GCInterval)
 		if err != nil {
 			gcInterval = 10 * time.Minute // Default to 10 minutes for BadgerDB
 		}
-		badgerStore.StartGarbageCollection(d.gcCtx, gcInterval)
+		badgerStore.StartGarbageCollection(gcCtx, gcInterval)
+
+		return badgerStore, storagePath, nil
 
 	default:
-		return fmt.Errorf("unsupported storage type: %s", config.StorageType)
+		return nil, "", fmt.Errorf("unsupported storage type: %s", config.StorageType)
 	}
+}
 
-	// Set the store
-	d.store = store
-	return nil
+// ValidateConfig parses and validates a configuration without applying it
+func (d *DP) ValidateConfig(configBytes []byte) error {
+	_, err := d.parseConfig(configBytes)
+	return err
+}
+
+// parseConfig parses and validates a configuration
+func (d *DP) parseConfig(configBytes []byte) (*DPConfig, error) {
+	// Merge a patch onto the applied config
+	d.configMu.RLock()
+	applied := d.config
+	d.configMu.RUnlock()
+	configBytes, err := config.ResolveMergePatch(configBytes, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	var newConfig DPConfig
+	if err := config.LoadConfigFromBytes(configBytes, &newConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if err := d.validateConfig(&newConfig); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &newConfig, nil
 }
 
 // validateConfig validates the Deduplication function block's configuration
 func (d *DP) validateConfig(config *DPConfig) error {
-	// Check if next FB is configured
-	if config.Common.NextFB == "" {
-		return fmt.Errorf("next FB not configured")
+	// Validate common settings
+	if err := config.Common.Validate(); err != nil {
+		return err
 	}
 
-	// Check if DLQ is configured
-	if config.Common.DLQ == "" {
-		return fmt.Errorf("DLQ not configured")
+	// Check if next FB is configured
+	if !config.Common.HasNextFB() {
+		return fmt.Errorf("next FB not configured")
 	}
 
 	// Validate storage type
@@ -534,16 +1103,62 @@ func (d *DP) validateConfig(config *DPConfig) error {
 		return fmt.Errorf("ttlMinutes must be positive")
 	}
 
+	// Validate batch size limit
+	if config.MaxBatchItems < 0 {
+		return fmt.Errorf("maxBatchItems must not be negative")
+	}
+
 	// Validate deduplication keys
-	if len(config.DeduplicationKey) == 0 {
-		return fmt.Errorf("at least one deduplication key must be configured")
+	switch config.KeyMode {
+	case "", KeyModeFields:
+		if len(config.DeduplicationKey) == 0 {
+			return fmt.Errorf("at least one deduplication key must be configured")
+		}
+	case KeyModeTemplate:
+		if config.DeduplicationKeyTemplate == "" {
+			return fmt.Errorf("deduplication key template not configured")
+		}
+		if _, err := parseKeyTemplate(config.DeduplicationKeyTemplate); err != nil {
+			return fmt.Errorf("invalid deduplication key template: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid key mode: %s, must be '%s' or '%s'", config.KeyMode, KeyModeFields, KeyModeTemplate)
+	}
+
+	// Validate deduplication tiers
+	seenTiers := make(map[string]bool, len(config.Tiers))
+	for _, tier := range config.Tiers {
+		if tier.Name == "" {
+			return fmt.Errorf("deduplication tier name not configured")
+		}
+		if !tierNamePattern.MatchString(tier.Name) {
+			return fmt.Errorf("invalid deduplication tier name: %s, must match [a-z0-9_-]+", tier.Name)
+		}
+		if tier.Name == DefaultTier || seenTiers[tier.Name] {
+			return fmt.Errorf("duplicate deduplication tier: %s", tier.Name)
+		}
+		seenTiers[tier.Name] = true
+		if len(tier.DeduplicationKey) == 0 {
+			return fmt.Errorf("at least one deduplication key must be configured for tier %s", tier.Name)
+		}
+		if tier.TTLMinutes <= 0 {
+			return fmt.Errorf("ttlMinutes must be positive for tier %s", tier.Name)
+		}
 	}
 
 	// Validate persistent storage configuration
-	if config.StorageType == "badgerdb" && config.PersistentStorage.Enabled {
+	if config.PersistentStorage.Enabled {
 		if config.PersistentStorage.Path == "" {
 			return fmt.Errorf("persistent storage path not configured")
 		}
+		if config.PersistentStorage.SnapshotInterval != "" {
+			if _, err := time.ParseDuration(config.PersistentStorage.SnapshotInterval); err != nil {
+				return fmt.Errorf("invalid snapshot interval: %w", err)
+			}
+		}
+		if config.PersistentStorage.SnapshotMaxEntries < 0 {
+			return fmt.Errorf("snapshotMaxEntries must not be negative")
+		}
 	}
 
 	// Validate GC interval
@@ -570,9 +1185,10 @@ func (d *DP) connectToNextFB(ctx context.Context, nextFB string) error {
 	}
 
 	// Create new connection
-	conn, err := grpc.DialContext(ctx, nextFB,
+	conn, err := d.DialContext(ctx, nextFB,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithBlock(),
+		metrics.GRPCDialOption(d.Name()),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to connect to next FB: %w", err)
@@ -594,7 +1210,7 @@ func (d *DP) connectToDLQ(ctx context.Context, dlqAddr string) error {
 	}
 
 	// Create new connection
-	conn, err := grpc.DialContext(ctx, dlqAddr,
+	conn, err := d.DialContext(ctx, dlqAddr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithBlock(),
 	)
@@ -612,6 +1228,19 @@ func (d *DP) connectToDLQ(ctx context.Context, dlqAddr string) error {
 func (d *DP) Shutdown(ctx context.Context) error {
 	d.logger.Info("Shutting down FB-DP", nil)
 
+	// Stop accepting batches
+	if err := d.Transition(fb.StateDraining); err != nil {
+		return err
+	}
+
+	// Let batches in flight finish, including their DLQ sends
+	drainErr := d.DrainBatches(ctx)
+	if drainErr != nil {
+		d.logger.Warn("Shutting down with batches still in flight", map[string]interface{}{
+			"error": drainErr.Error(),
+		})
+	}
+
 	// Stop garbage collection
 	if d.gcCancel != nil {
 		d.gcCancel()
@@ -619,37 +1248,48 @@ func (d *DP) Shutdown(ctx context.Context) error {
 
 	// Close store
 	d.storeMu.Lock()
-	if d.store != nil {
+	for tier, store := range d.stores {
 		// Flush store to ensure data is persisted
-		if err := d.store.Flush(); err != nil {
-			d.logger.Error("Failed to flush store during shutdown", err, nil)
+		if err := store.Flush(); err != nil {
+			d.logger.Error("Failed to flush store during shutdown", err, map[string]interface{}{
+				"tier": tier,
+			})
 		}
 		
 		// Close store
-		if err := d.store.Close(); err != nil {
-			d.logger.Error("Failed to close store during shutdown", err, nil)
+		if err := store.Close(); err != nil {
+			d.logger.Error("Failed to close store during shutdown", err, map[string]interface{}{
+				"tier": tier,
+			})
 		}
-		d.store = nil
 	}
+	d.stores = nil
+	d.storePaths = nil
 	d.storeMu.Unlock()
 
-	// Close connections
+	// Close connections, the DLQ and quarantine last
 	if d.nextFBConn != nil {
 		d.nextFBConn.Close()
 		d.nextFBConn = nil
 		d.nextFBClient = nil
 	}
+	d.CloseNextFBs()
 
 	if d.dlqConn != nil {
 		d.dlqConn.Close()
 		d.dlqConn = nil
 		d.dlqClient = nil
 	}
+	d.CloseQuarantine()
+	d.CloseDebugTap()
 
 	// Mark as not ready
-	d.BaseFunctionBlock.ready = false
+	d.SetReady(false)
 
-	return nil
+	if err := d.Transition(fb.StateStopped); err != nil {
+		return err
+	}
+	return drainErr
 }
 
 // Testing helpers
@@ -0,0 +1,622 @@
+package dp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/benchmark"
+	"eidc-tfk8s/pkg/fb/codec"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// dpConfigBytes builds a valid DP config using the given storage type
+func dpConfigBytes(t *testing.T, storageType, storagePath string) []byte {
+	config := DPConfig{
+		Enabled:          true,
+		StorageType:      storageType,
+		TTLMinutes:       5,
+		GCInterval:       "1m",
+		DeduplicationKey: []string{"name"},
+	}
+	config.Common.NextFB = "fb-gw:5000"
+	config.Common.DLQ = "fb-dlq:5000"
+	if storagePath != "" {
+		config.PersistentStorage.Enabled = true
+		config.PersistentStorage.Path = storagePath
+	}
+
+	configBytes, err := json.Marshal(config)
+	require.NoError(t, err)
+	return configBytes
+}
+
+func TestDP_StorageTypeChangeReinitializesStore(t *testing.T) {
+	ctx := context.Background()
+
+	d := NewDP()
+	require.NoError(t, d.Initialize(ctx))
+	d.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{})
+	d.SetDLQClientForTesting(&fb.MockChainPushServiceClient{})
+	defer d.Shutdown(ctx)
+
+	require.NoError(t, fb.ApplyConfig(ctx, d, dpConfigBytes(t, "memory", ""), 1, false))
+	_, isMemory := d.stores[DefaultTier].(*MemoryStore)
+	require.True(t, isMemory)
+
+	// An update that leaves the storage settings alone keeps the store
+	oldStore := d.stores[DefaultTier]
+	require.NoError(t, fb.ApplyConfig(ctx, d, dpConfigBytes(t, "memory", ""), 2, false))
+	assert.Same(t, oldStore, d.stores[DefaultTier])
+
+	// Changing them rebuilds it, even without a restart
+	require.NoError(t, fb.ApplyConfig(ctx, d, dpConfigBytes(t, "badgerdb", t.TempDir()), 3, false))
+	_, isBadger := d.stores[DefaultTier].(*BadgerStore)
+	assert.True(t, isBadger)
+	assert.Equal(t, "badgerdb", d.config.StorageType)
+
+	// As does an update flagged as requiring a restart
+	require.NoError(t, fb.ApplyConfig(ctx, d, dpConfigBytes(t, "memory", ""), 4, true))
+	_, isMemory = d.stores[DefaultTier].(*MemoryStore)
+	assert.True(t, isMemory)
+}
+
+func TestDP_FailedStoreReinitKeepsOldStore(t *testing.T) {
+	ctx := context.Background()
+
+	d := NewDP()
+	require.NoError(t, d.Initialize(ctx))
+	d.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{})
+	d.SetDLQClientForTesting(&fb.MockChainPushServiceClient{})
+	defer d.Shutdown(ctx)
+
+	require.NoError(t, d.UpdateConfig(ctx, dpConfigBytes(t, "memory", ""), 1))
+	oldStore := d.stores[DefaultTier]
+
+	newBatch := func() *fb.MetricBatch {
+		return &fb.MetricBatch{
+			BatchID: "batch-1",
+			Data:    []byte(`[{"name":"cpu","value":1}]`),
+			Format:  codec.FormatJSON,
+		}
+	}
+
+	batch := newBatch()
+	require.NoError(t, d.processBatch(ctx, batch, d.snapshotConfig()))
+	metrics, err := codec.Decode(batch)
+	require.NoError(t, err)
+	assert.Len(t, metrics, 1)
+
+	// BadgerDB can't open a store where a regular file is in the way
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	require.NoError(t, os.WriteFile(blocked, []byte("not a directory"), 0o644))
+
+	err = d.Reinitialize(ctx, dpConfigBytes(t, "badgerdb", blocked), 2)
+	require.Error(t, err)
+	assert.Same(t, oldStore, d.stores[DefaultTier])
+	assert.Equal(t, "memory", d.config.StorageType)
+
+	// The old store is still open and still remembers the first batch
+	batch = newBatch()
+	require.NoError(t, d.processBatch(ctx, batch, d.snapshotConfig()))
+	metrics, err = codec.Decode(batch)
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+}
+
+func TestDP_CancelledContextStopsDeduplication(t *testing.T) {
+	d := NewDP()
+	require.NoError(t, d.Initialize(context.Background()))
+	d.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{})
+	d.SetDLQClientForTesting(&fb.MockChainPushServiceClient{})
+	defer d.Shutdown(context.Background())
+
+	require.NoError(t, d.UpdateConfig(context.Background(), dpConfigBytes(t, "memory", ""), 1))
+
+	metrics := make([]map[string]interface{}, 10000)
+	for i := range metrics {
+		metrics[i] = map[string]interface{}{"name": fmt.Sprintf("metric-%d", i), "value": i}
+	}
+	batch := &fb.MetricBatch{BatchID: "batch-1"}
+	require.NoError(t, codec.Encode(batch, metrics))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := d.ProcessBatch(ctx, batch)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, fb.ErrorCodeTimeout, result.ErrorCode)
+
+	// Nothing was deduplicated before bailing out
+	store := d.stores[DefaultTier].(*MemoryStore)
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	assert.Empty(t, store.entries)
+}
+
+// cancelAfterContext reports itself cancelled once its Err method has been
+// called more than n times, cancelling a batch midway through
+type cancelAfterContext struct {
+	context.Context
+	calls, n int
+}
+
+func (c *cancelAfterContext) Err() error {
+	c.calls++
+	if c.calls > c.n {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestDP_CancelledBatchIsNotDeduplicatedOnRetry(t *testing.T) {
+	d := NewDP()
+	require.NoError(t, d.Initialize(context.Background()))
+	d.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{})
+	d.SetDLQClientForTesting(&fb.MockChainPushServiceClient{})
+	defer d.Shutdown(context.Background())
+
+	require.NoError(t, d.UpdateConfig(context.Background(), dpConfigBytes(t, "memory", ""), 1))
+
+	newBatch := func() *fb.MetricBatch {
+		metrics := make([]map[string]interface{}, 1000)
+		for i := range metrics {
+			metrics[i] = map[string]interface{}{"name": fmt.Sprintf("metric-%d", i), "value": i}
+		}
+		batch := &fb.MetricBatch{BatchID: "batch-1"}
+		require.NoError(t, codec.Encode(batch, metrics))
+		return batch
+	}
+
+	// The request is cancelled a few hundred metrics into the batch
+	ctx := &cancelAfterContext{Context: context.Background(), n: 3}
+	err := d.processBatch(ctx, newBatch(), d.snapshotConfig())
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 4, ctx.calls)
+
+	// The retry keeps every metric
+	batch := newBatch()
+	require.NoError(t, d.processBatch(context.Background(), batch, d.snapshotConfig()))
+	metrics, err := codec.Decode(batch)
+	require.NoError(t, err)
+	assert.Len(t, metrics, 1000)
+}
+
+func TestDP_ProcessBatch_ChecksumMismatchGoesToDLQ(t *testing.T) {
+	ctx := context.Background()
+
+	d := NewDP()
+	require.NoError(t, d.Initialize(ctx))
+	var forwarded []*fb.MetricBatchRequest
+	d.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			forwarded = append(forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	var dlqBatches []*fb.MetricBatchRequest
+	d.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqBatches = append(dlqBatches, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	defer d.Shutdown(ctx)
+
+	var dpConfig DPConfig
+	require.NoError(t, json.Unmarshal(dpConfigBytes(t, "memory", ""), &dpConfig))
+	dpConfig.Common.VerifyChecksum = true
+	configBytes, err := json.Marshal(dpConfig)
+	require.NoError(t, err)
+	require.NoError(t, d.UpdateConfig(ctx, configBytes, 1))
+
+	// FB-RX checksums the data, which is then corrupted on its way to DP
+	batch := &fb.MetricBatch{BatchID: "batch-1", Data: []byte(`[{"name":"cpu","value":1}]`)}
+	fb.StampChecksum(batch)
+	batch.Data = []byte(`[{"name":"cpu","value":7}]`)
+
+	result, err := d.ProcessBatch(ctx, batch)
+	require.Error(t, err)
+	assert.Equal(t, fb.ErrorCodeInvalidInput, result.ErrorCode)
+	assert.True(t, result.SentToDLQ)
+	assert.Empty(t, forwarded)
+	require.Len(t, dlqBatches, 1)
+	assert.Equal(t, "batch-1", dlqBatches[0].BatchId)
+
+	// Intact batches are processed, and forwarded with the checksum of the
+	// data DP rewrote
+	batch = &fb.MetricBatch{BatchID: "batch-2", Data: []byte(`[{"name":"mem","value":1}]`)}
+	fb.StampChecksum(batch)
+
+	result, err = d.ProcessBatch(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+	require.Len(t, forwarded, 1)
+	assert.Equal(t, fb.Checksum(forwarded[0].Data), forwarded[0].InternalLabels[fb.LabelChecksum])
+}
+
+func TestDP_ProcessBatch_BeforeConfigIsRetriable(t *testing.T) {
+	ctx := context.Background()
+
+	d := NewDP()
+	require.NoError(t, d.Initialize(ctx))
+	defer d.Shutdown(ctx)
+
+	// No config has been applied yet, so the batch is rejected for the sender
+	// to retry rather than processed with a nil config
+	batch := &fb.MetricBatch{BatchID: "batch-1", Data: []byte(`[{"name":"cpu","value":1}]`)}
+	result, err := d.ProcessBatch(ctx, batch)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, fb.ErrNotRunning)
+	assert.Equal(t, fb.StatusError, result.Status)
+	assert.Equal(t, fb.ErrorCodeServiceUnavailable, result.ErrorCode)
+	assert.True(t, result.Retriable)
+	assert.False(t, result.SentToDLQ)
+}
+
+func TestDP_ProcessBatch_OverMaxBatchItemsGoesToDLQ(t *testing.T) {
+	ctx := context.Background()
+
+	d := NewDP()
+	require.NoError(t, d.Initialize(ctx))
+	var forwarded []*fb.MetricBatchRequest
+	d.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			forwarded = append(forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	var dlqBatches []*fb.MetricBatchRequest
+	d.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqBatches = append(dlqBatches, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	defer d.Shutdown(ctx)
+
+	var dpConfig DPConfig
+	require.NoError(t, json.Unmarshal(dpConfigBytes(t, "memory", ""), &dpConfig))
+	dpConfig.MaxBatchItems = 2
+	configBytes, err := json.Marshal(dpConfig)
+	require.NoError(t, err)
+	require.NoError(t, d.UpdateConfig(ctx, configBytes, 1))
+
+	// Batches over the limit are sent to the DLQ without being deduplicated
+	result, err := d.ProcessBatch(ctx, &fb.MetricBatch{
+		BatchID: "batch-large",
+		Data:    []byte(`[{"name":"cpu"},{"name":"mem"},{"name":"disk"}]`),
+		Format:  codec.FormatJSON,
+	})
+	require.ErrorIs(t, err, errBatchTooLarge)
+	assert.Equal(t, fb.ErrorCodeInvalidInput, result.ErrorCode)
+	assert.True(t, result.SentToDLQ)
+	assert.Empty(t, forwarded)
+	require.Len(t, dlqBatches, 1)
+	assert.Equal(t, "batch-large", dlqBatches[0].BatchId)
+
+	// Batches at the limit are deduplicated and forwarded
+	result, err = d.ProcessBatch(ctx, &fb.MetricBatch{
+		BatchID: "batch-small",
+		Data:    []byte(`[{"name":"cpu"},{"name":"cpu"}]`),
+		Format:  codec.FormatJSON,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+	require.Len(t, forwarded, 1)
+	metrics, err := codec.Decode(&fb.MetricBatch{Data: forwarded[0].Data, Format: forwarded[0].Format})
+	require.NoError(t, err)
+	assert.Len(t, metrics, 1)
+
+	// Negative limits are invalid
+	dpConfig.MaxBatchItems = -1
+	configBytes, err = json.Marshal(dpConfig)
+	require.NoError(t, err)
+	assert.Error(t, d.ValidateConfig(configBytes))
+}
+
+func TestDP_UpdateConfigLogsChanges(t *testing.T) {
+	ctx := context.Background()
+
+	d := NewDP()
+	require.NoError(t, d.Initialize(ctx))
+	d.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{})
+	d.SetDLQClientForTesting(&fb.MockChainPushServiceClient{})
+	defer d.Shutdown(ctx)
+
+	var buf bytes.Buffer
+	d.logger.WithWriter(&buf)
+
+	oldConfigBytes := dpConfigBytes(t, "memory", "")
+	var newConfig DPConfig
+	require.NoError(t, json.Unmarshal(oldConfigBytes, &newConfig))
+	newConfig.TTLMinutes = 10
+	newConfigBytes, err := json.Marshal(newConfig)
+	require.NoError(t, err)
+
+	require.NoError(t, d.UpdateConfig(ctx, oldConfigBytes, 1))
+	require.NoError(t, d.UpdateConfig(ctx, newConfigBytes, 2))
+
+	// Find the log entry of each update
+	updates := make(map[float64][]config.ConfigChange)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry struct {
+			Message    string                `json:"message"`
+			Generation float64               `json:"generation"`
+			Changes    []config.ConfigChange `json:"changes"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		if entry.Message == "Config updated" {
+			updates[entry.Generation] = entry.Changes
+		}
+	}
+
+	require.Contains(t, updates, float64(1))
+	assert.Empty(t, updates[1])
+	assert.Equal(t, []config.ConfigChange{
+		{Path: "ttlMinutes", Old: float64(5), New: float64(10)},
+	}, updates[2])
+}
+
+func TestDP_UpdateConfigMergesPatch(t *testing.T) {
+	ctx := context.Background()
+
+	d := NewDP()
+	require.NoError(t, d.Initialize(ctx))
+	d.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{})
+	d.SetDLQClientForTesting(&fb.MockChainPushServiceClient{})
+	defer d.Shutdown(ctx)
+
+	require.NoError(t, d.UpdateConfig(ctx, dpConfigBytes(t, "memory", ""), 1))
+	before := *d.config
+
+	// A patch of only the TTL leaves the other fields as applied
+	patch, err := config.WrapMergePatch([]byte(`{"ttlMinutes": 30}`))
+	require.NoError(t, err)
+	require.NoError(t, d.ValidateConfig(patch))
+	require.NoError(t, d.UpdateConfig(ctx, patch, 2))
+
+	expected := before
+	expected.TTLMinutes = 30
+	assert.Equal(t, expected, *d.config)
+	assert.Equal(t, int64(2), d.GetConfigGeneration())
+
+	// A patch that makes the config invalid is rejected
+	patch, err = config.WrapMergePatch([]byte(`{"common": {"next_fb": null}}`))
+	require.NoError(t, err)
+	assert.Error(t, d.UpdateConfig(ctx, patch, 3))
+	assert.Equal(t, expected, *d.config)
+}
+
+func TestDP_TieredDeduplication(t *testing.T) {
+	ctx := context.Background()
+
+	d := NewDP()
+	require.NoError(t, d.Initialize(ctx))
+	d.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{})
+	d.SetDLQClientForTesting(&fb.MockChainPushServiceClient{})
+	defer d.Shutdown(ctx)
+
+	// A short exact window and a long one keyed on the name only
+	var dpConfig DPConfig
+	require.NoError(t, json.Unmarshal(dpConfigBytes(t, "memory", ""), &dpConfig))
+	dpConfig.DeduplicationKey = []string{"name", "timestamp"}
+	dpConfig.TTLMinutes = 1
+	dpConfig.Tiers = []DedupTier{
+		{Name: "long", DeduplicationKey: []string{"name"}, TTLMinutes: 60},
+	}
+	configBytes, err := json.Marshal(dpConfig)
+	require.NoError(t, err)
+	require.NoError(t, d.UpdateConfig(ctx, configBytes, 1))
+	require.Len(t, d.stores, 2)
+
+	defaultBefore := testutil.ToFloat64(tierDeduplicatedTotal.WithLabelValues(DefaultTier))
+	longBefore := testutil.ToFloat64(tierDeduplicatedTotal.WithLabelValues("long"))
+
+	batch := &fb.MetricBatch{BatchID: "batch-1"}
+	require.NoError(t, codec.Encode(batch, []map[string]interface{}{
+		{"name": "cpu", "timestamp": 1000, "value": 1},
+		{"name": "mem", "timestamp": 1000, "value": 2},
+	}))
+	require.NoError(t, d.processBatch(ctx, batch, d.snapshotConfig()))
+
+	// The same name at a later timestamp is new to the short window but
+	// not to the long one
+	batch = &fb.MetricBatch{BatchID: "batch-2"}
+	require.NoError(t, codec.Encode(batch, []map[string]interface{}{
+		{"name": "cpu", "timestamp": 2000, "value": 3},
+		{"name": "disk", "timestamp": 2000, "value": 4},
+	}))
+	require.NoError(t, d.processBatch(ctx, batch, d.snapshotConfig()))
+
+	metrics, err := codec.Decode(batch)
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "disk", metrics[0]["name"])
+	assert.Equal(t, float64(0), testutil.ToFloat64(tierDeduplicatedTotal.WithLabelValues(DefaultTier))-defaultBefore)
+	assert.Equal(t, float64(1), testutil.ToFloat64(tierDeduplicatedTotal.WithLabelValues("long"))-longBefore)
+}
+
+func TestDP_ValidateConfig_TierNames(t *testing.T) {
+	newConfig := func(name string) *DPConfig {
+		config := &DPConfig{
+			StorageType:      "memory",
+			DeduplicationKey: []string{"name"},
+			TTLMinutes:       5,
+			GCInterval:       "1m",
+			Tiers: []DedupTier{
+				{Name: name, DeduplicationKey: []string{"name"}, TTLMinutes: 60},
+			},
+		}
+		config.Common.NextFB = "fb-gw:5000"
+		config.Common.DLQ = "fb-dlq:5000"
+		return config
+	}
+
+	d := &DP{}
+
+	assert.NoError(t, d.validateConfig(newConfig("long_1-h")))
+
+	// Tier names end up in store paths
+	for _, name := range []string{"../x", "a/b", "Long", "long.1", " "} {
+		assert.Error(t, d.validateConfig(newConfig(name)), name)
+	}
+}
+
+func TestDefaultConfig_MatchesParametersSchema(t *testing.T) {
+	configBytes, err := json.Marshal(DefaultConfig())
+	require.NoError(t, err)
+	assert.NoError(t, config.ValidateParameters("fb-dp", configBytes))
+
+	// Unknown storage types are rejected
+	assert.Error(t, config.ValidateParameters("fb-dp", []byte(`{"storageType":"redis","ttlMinutes":5}`)))
+}
+
+func TestDP_ProcessBatch_WithoutDLQReturnsForwardingError(t *testing.T) {
+	ctx := context.Background()
+
+	d := NewDP()
+	require.NoError(t, d.Initialize(ctx))
+	d.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			return nil, fmt.Errorf("next FB unavailable")
+		},
+	})
+	defer d.Shutdown(ctx)
+
+	// A DP deployed without a DLQ
+	var dpConfig DPConfig
+	require.NoError(t, json.Unmarshal(dpConfigBytes(t, "memory", ""), &dpConfig))
+	dpConfig.Common.DLQ = ""
+	configBytes, err := json.Marshal(dpConfig)
+	require.NoError(t, err)
+	require.NoError(t, d.UpdateConfig(ctx, configBytes, 1))
+	assert.False(t, d.HasDLQ())
+
+	batch := &fb.MetricBatch{BatchID: "batch-1"}
+	require.NoError(t, codec.Encode(batch, []map[string]interface{}{{"name": "cpu", "value": 1}}))
+
+	// The forwarding error goes back to the caller, which keeps the batch
+	result, err := d.ProcessBatch(ctx, batch)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "next FB unavailable")
+	assert.Equal(t, fb.ErrorCodeForwardingFailed, result.ErrorCode)
+	assert.False(t, result.SentToDLQ)
+	assert.True(t, result.Retriable)
+	assert.Nil(t, d.dlqClient)
+}
+
+func TestDP_SnapshotConfigIsConsistentDuringUpdateConfig(t *testing.T) {
+	ctx := context.Background()
+
+	d := NewDP()
+	require.NoError(t, d.Initialize(ctx))
+	d.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{})
+	d.SetDLQClientForTesting(&fb.MockChainPushServiceClient{})
+	defer d.Shutdown(ctx)
+
+	// Two configs differing in every field of the snapshot
+	oldConfigBytes := dpConfigBytes(t, "memory", "")
+	var newConfig DPConfig
+	require.NoError(t, json.Unmarshal(oldConfigBytes, &newConfig))
+	newConfig.DeduplicationKey = []string{"name", "host"}
+	newConfig.TTLMinutes = 10
+	newConfigBytes, err := json.Marshal(newConfig)
+	require.NoError(t, err)
+	require.NoError(t, d.UpdateConfig(ctx, oldConfigBytes, 1))
+
+	// Flip between the configs while snapshots are taken
+	done := make(chan struct{})
+	updated := make(chan error, 1)
+	go func() {
+		for generation := int64(2); ; generation++ {
+			select {
+			case <-done:
+				updated <- nil
+				return
+			default:
+			}
+			configBytes := oldConfigBytes
+			if generation%2 == 0 {
+				configBytes = newConfigBytes
+			}
+			if err := d.UpdateConfig(ctx, configBytes, generation); err != nil {
+				updated <- err
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		cfg := d.snapshotConfig()
+		if cfg.ttlMinutes == 10 {
+			require.Equal(t, []string{"name", "host"}, cfg.deduplicationKeys)
+		} else {
+			require.Equal(t, 5, cfg.ttlMinutes)
+			require.Equal(t, []string{"name"}, cfg.deduplicationKeys)
+		}
+	}
+	close(done)
+	require.NoError(t, <-updated)
+}
+
+// BenchmarkDP_ProcessBatch deduplicates a batch of 1000 metrics, half of
+// them duplicates within the batch
+func BenchmarkDP_ProcessBatch(b *testing.B) {
+	ctx := context.Background()
+
+	d := NewDP()
+	if err := d.Initialize(ctx); err != nil {
+		b.Fatal(err)
+	}
+	d.config = &DPConfig{Enabled: true, TTLMinutes: 5, DeduplicationKey: []string{"name", "timestamp"}}
+
+	metrics := make([]codec.Metric, 1000)
+	for i := range metrics {
+		metrics[i] = codec.Metric{"name": fmt.Sprintf("metric-%d", i/2), "timestamp": 1700000000, "value": i}
+	}
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		d.stores = map[string]DeduplicationStore{DefaultTier: NewMemoryStore()}
+		batch := &fb.MetricBatch{BatchID: "batch-1", Data: data, Format: codec.FormatJSON}
+		b.StartTimer()
+
+		if err := d.processBatch(ctx, batch, d.snapshotConfig()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The budget is about twice what deduplicating a batch allocates (about 40
+// per metric), so it only fails when allocations balloon
+func TestDP_DedupAllocations(t *testing.T) {
+	d := NewDP()
+	require.NoError(t, d.Initialize(context.Background()))
+	d.config = &DPConfig{Enabled: true, TTLMinutes: 5, DeduplicationKey: []string{"name", "timestamp"}}
+	cfg := d.snapshotConfig()
+
+	batch := benchmark.Batch(t, 100, codec.FormatJSON)
+	benchmark.AssertMaxAllocs(t, 8000, func() {
+		d.stores = map[string]DeduplicationStore{DefaultTier: NewMemoryStore()}
+		if err := d.processBatch(context.Background(), benchmark.Copy(batch), cfg); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
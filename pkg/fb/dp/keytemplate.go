@@ -0,0 +1,87 @@
+package dp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"text/template"
+)
+
+// Deduplication key modes
+const (
+	// KeyModeFields builds the key from the DeduplicationKey field list
+	KeyModeFields = "fields"
+
+	// KeyModeTemplate builds the key by executing DeduplicationKeyTemplate
+	// over the metric
+	KeyModeTemplate = "template"
+)
+
+// keyTemplateFuncs are the functions available to deduplication key templates
+var keyTemplateFuncs = template.FuncMap{
+	"bucket": bucketValue,
+}
+
+// parseKeyTemplate parses a deduplication key template. Missing fields are
+// an error, as they are in field mode.
+func parseKeyTemplate(text string) (*template.Template, error) {
+	return template.New("deduplicationKey").
+		Funcs(keyTemplateFuncs).
+		Option("missingkey=error").
+		Parse(text)
+}
+
+// createTemplateDeduplicationKey creates a key for a metric by executing the key template over it
+func createTemplateDeduplicationKey(metric map[string]interface{}, keyTemplate *template.Template) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := keyTemplate.Execute(&buf, metric); err != nil {
+		return nil, fmt.Errorf("failed to execute deduplication key template: %w", err)
+	}
+
+	if buf.Len() == 0 {
+		return nil, fmt.Errorf("deduplication key template produced an empty key")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// bucketValue rounds a numeric value down to a multiple of size, so
+// e.g. {{bucket .timestamp 60}} gives the same key within a minute
+func bucketValue(value interface{}, size interface{}) (int64, error) {
+	v, err := toFloat64(value)
+	if err != nil {
+		return 0, fmt.Errorf("bucket value: %w", err)
+	}
+
+	s, err := toFloat64(size)
+	if err != nil {
+		return 0, fmt.Errorf("bucket size: %w", err)
+	}
+	if s <= 0 {
+		return 0, fmt.Errorf("bucket size must be positive")
+	}
+
+	return int64(math.Floor(v/s) * s), nil
+}
+
+// toFloat64 converts a metric value decoded from JSON to a float64
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("unsupported type %T", value)
+	}
+}
@@ -0,0 +1,132 @@
+package dp
+
+import (
+	"testing"
+
+	"eidc-tfk8s/pkg/fb/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeMetric decodes a metric the same way processBatch does
+func decodeMetric(t *testing.T, data string) map[string]interface{} {
+	var metric map[string]interface{}
+	require.NoError(t, codec.UnmarshalJSON([]byte(data), &metric))
+	return metric
+}
+
+func TestTemplateDeduplicationKey_TimestampBucketing(t *testing.T) {
+	keyTemplate, err := parseKeyTemplate(`{{.host}}|{{.metric_name}}|{{bucket .timestamp 60}}`)
+	require.NoError(t, err)
+
+	first, err := createTemplateDeduplicationKey(decodeMetric(t, `{"host":"node-1","metric_name":"cpu","timestamp":1700000040,"value":1}`), keyTemplate)
+	require.NoError(t, err)
+	assert.Equal(t, "node-1|cpu|1700000040", string(first))
+
+	// Same bucket, different timestamp and value
+	second, err := createTemplateDeduplicationKey(decodeMetric(t, `{"host":"node-1","metric_name":"cpu","timestamp":1700000099,"value":2}`), keyTemplate)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	// Next bucket
+	third, err := createTemplateDeduplicationKey(decodeMetric(t, `{"host":"node-1","metric_name":"cpu","timestamp":1700000100,"value":1}`), keyTemplate)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, third)
+}
+
+func TestTemplateDeduplicationKey_MissingField(t *testing.T) {
+	keyTemplate, err := parseKeyTemplate(`{{.host}}|{{.metric_name}}`)
+	require.NoError(t, err)
+
+	_, err = createTemplateDeduplicationKey(decodeMetric(t, `{"host":"node-1"}`), keyTemplate)
+	assert.Error(t, err)
+}
+
+func TestBuildDeduplicationKey_FallsBackToFields(t *testing.T) {
+	keyTemplate, err := parseKeyTemplate(`{{.host}}|{{bucket .timestamp 60}}`)
+	require.NoError(t, err)
+
+	metric := decodeMetric(t, `{"host":"node-1","metric_name":"cpu"}`)
+
+	key, err := buildDeduplicationKey(metric, []string{"host", "metric_name"}, keyTemplate)
+	require.NoError(t, err)
+
+	expected, err := createDeduplicationKey(metric, []string{"host", "metric_name"})
+	require.NoError(t, err)
+	assert.Equal(t, expected, key)
+}
+
+func TestCreateDeduplicationKey_NestedPath(t *testing.T) {
+	keys := []string{"resource.host.id", "metric_name"}
+
+	first, err := createDeduplicationKey(decodeMetric(t, `{"metric_name":"cpu","value":1,"resource":{"host":{"id":"node-1"}}}`), keys)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"resource.host.id":"node-1","metric_name":"cpu"}`, string(first))
+
+	// Same nested attribute, different value
+	second, err := createDeduplicationKey(decodeMetric(t, `{"metric_name":"cpu","value":2,"resource":{"host":{"id":"node-1"}}}`), keys)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	// Different nested attribute
+	third, err := createDeduplicationKey(decodeMetric(t, `{"metric_name":"cpu","value":1,"resource":{"host":{"id":"node-2"}}}`), keys)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, third)
+
+	// A top-level field whose name contains dots wins over the path
+	dotted, err := createDeduplicationKey(decodeMetric(t, `{"metric_name":"cpu","resource.host.id":"node-3"}`), keys)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"resource.host.id":"node-3","metric_name":"cpu"}`, string(dotted))
+}
+
+func TestCreateDeduplicationKey_NestedPathMissing(t *testing.T) {
+	keys := []string{"resource.host.id"}
+
+	for _, data := range []string{
+		`{"metric_name":"cpu"}`,
+		`{"metric_name":"cpu","resource":{}}`,
+		`{"metric_name":"cpu","resource":{"host":"node-1"}}`,
+		`{"metric_name":"cpu","resource":{"host":{"name":"node-1"}}}`,
+	} {
+		_, err := createDeduplicationKey(decodeMetric(t, data), keys)
+		assert.Error(t, err, data)
+	}
+}
+
+func TestCreateDeduplicationKey_PreservesInt64Precision(t *testing.T) {
+	keys := []string{"name", "value"}
+
+	// Both values round to the same float64
+	first, err := createDeduplicationKey(decodeMetric(t, `{"name":"bytes_total","value":9007199254740993}`), keys)
+	require.NoError(t, err)
+	second, err := createDeduplicationKey(decodeMetric(t, `{"name":"bytes_total","value":9007199254740992}`), keys)
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first, second)
+	assert.JSONEq(t, `{"name":"bytes_total","value":9007199254740993}`, string(first))
+}
+
+func TestDP_ValidateConfig_KeyTemplate(t *testing.T) {
+	newConfig := func(keyMode, keyTemplate string) *DPConfig {
+		config := &DPConfig{
+			StorageType:              "memory",
+			TTLMinutes:               5,
+			GCInterval:               "1m",
+			KeyMode:                  keyMode,
+			DeduplicationKeyTemplate: keyTemplate,
+		}
+		config.Common.NextFB = "fb-gw:5000"
+		config.Common.DLQ = "fb-dlq:5000"
+		return config
+	}
+
+	d := &DP{}
+
+	assert.NoError(t, d.validateConfig(newConfig(KeyModeTemplate, `{{.host}}|{{bucket .timestamp 60}}`)))
+	assert.Error(t, d.validateConfig(newConfig(KeyModeTemplate, `{{.host`)))
+	assert.Error(t, d.validateConfig(newConfig(KeyModeTemplate, "")))
+	assert.Error(t, d.validateConfig(newConfig("regex", `{{.host}}`)))
+
+	// Field mode still requires keys
+	assert.Error(t, d.validateConfig(newConfig("", "")))
+}
@@ -0,0 +1,77 @@
+package dp
+
+import (
+	"eidc-tfk8s/internal/config"
+)
+
+// parametersSchema is the JSON Schema of DPConfig
+const parametersSchema = `{
+	"type": "object",
+	"required": ["storageType", "ttlMinutes"],
+	"properties": {
+		"enabled": {"type": "boolean"},
+		"storageType": {"type": "string", "enum": ["memory", "badgerdb"]},
+		"ttlMinutes": {"type": "integer"},
+		"gcInterval": {"type": "string"},
+		"deduplicationKey": {
+			"type": "array",
+			"items": {"type": "string"}
+		},
+		"keyMode": {"type": "string", "enum": ["", "fields", "template"]},
+		"deduplicationKeyTemplate": {"type": "string"},
+		"maxBatchItems": {"type": "integer"},
+		"tiers": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["name", "deduplicationKey", "ttlMinutes"],
+				"properties": {
+					"name": {"type": "string", "pattern": "^[a-z0-9_-]+$"},
+					"deduplicationKey": {
+						"type": "array",
+						"items": {"type": "string"}
+					},
+					"ttlMinutes": {"type": "integer"}
+				}
+			}
+		},
+		"persistentStorage": {
+			"type": "object",
+			"properties": {
+				"enabled": {"type": "boolean"},
+				"path": {"type": "string"},
+				"volumeClaimName": {"type": "string"},
+				"snapshotInterval": {"type": "string"},
+				"snapshotMaxEntries": {"type": "integer"}
+			}
+		}
+	}
+}`
+
+func init() {
+	config.RegisterParametersSchema("fb-dp", parametersSchema)
+}
+
+// DefaultConfig returns the DP configuration deduplicating by metric name and
+// timestamp in memory
+func DefaultConfig() DPConfig {
+	return DPConfig{
+		Common: config.FBConfig{
+			LogLevel:           "info",
+			MetricsEnabled:     true,
+			TracingEnabled:     true,
+			TraceSamplingRatio: 0.1,
+			CircuitBreaker: config.CircuitBreakerConfig{
+				ErrorThresholdPercentage: 50,
+				OpenStateSeconds:         30,
+				HalfOpenRequestThreshold: 5,
+			},
+		},
+		Enabled:          true,
+		StorageType:      "memory",
+		TTLMinutes:       5,
+		GCInterval:       "1m",
+		DeduplicationKey: []string{"name", "timestamp"},
+		KeyMode:          KeyModeFields,
+	}
+}
@@ -2,8 +2,12 @@ package dp
 
 import (
 	"context"
+	"encoding/gob"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -33,8 +37,15 @@ var ErrKeyAlreadyExists = errors.New("key already exists in deduplication store"
 type MemoryStore struct {
 	mu      sync.RWMutex
 	entries map[string]time.Time
+
+	// Optional on-disk snapshot so keys survive restarts
+	snapshotPath       string
+	snapshotMaxEntries int
 }
 
+// DefaultSnapshotMaxEntries bounds the size of a memory store snapshot
+const DefaultSnapshotMaxEntries = 1000000
+
 // NewMemoryStore creates a new in-memory deduplication store
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
@@ -82,9 +93,143 @@ func (s *MemoryStore) Close() error {
 	return nil
 }
 
-// Flush ensures data is persisted (no-op for in-memory store)
+// Flush writes a snapshot if snapshots are enabled, otherwise it is a no-op
 func (s *MemoryStore) Flush() error {
-	return nil
+	if s.snapshotPath == "" {
+		return nil
+	}
+	_, err := s.SaveSnapshot(s.snapshotPath, s.snapshotMaxEntries)
+	return err
+}
+
+// EnableSnapshots makes Flush write the keyspace to path, keeping at most
+// maxEntries keys, and loads any existing snapshot from path
+func (s *MemoryStore) EnableSnapshots(path string, maxEntries int) (int, error) {
+	if maxEntries <= 0 {
+		maxEntries = DefaultSnapshotMaxEntries
+	}
+	s.snapshotPath = path
+	s.snapshotMaxEntries = maxEntries
+
+	loaded, err := s.LoadSnapshot(path, maxEntries)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	return loaded, err
+}
+
+// memorySnapshot is the on-disk format of a memory store snapshot
+type memorySnapshot struct {
+	Entries map[string]time.Time
+}
+
+// SaveSnapshot writes unexpired keys and their expiry to path. When there are
+// more than maxEntries keys the ones expiring soonest are left out. It
+// returns the number of keys written.
+func (s *MemoryStore) SaveSnapshot(path string, maxEntries int) (int, error) {
+	now := time.Now()
+
+	s.mu.RLock()
+	entries := make(map[string]time.Time, len(s.entries))
+	for key, expiryTime := range s.entries {
+		if expiryTime.After(now) {
+			entries[key] = expiryTime
+		}
+	}
+	s.mu.RUnlock()
+
+	entries = limitEntries(entries, maxEntries)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	// Write to a temporary file and rename so a crash never leaves a partial snapshot
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+
+	if err := gob.NewEncoder(file).Encode(memorySnapshot{Entries: entries}); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to sync snapshot file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to close snapshot file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return 0, fmt.Errorf("failed to rename snapshot file: %w", err)
+	}
+
+	return len(entries), nil
+}
+
+// LoadSnapshot loads unexpired keys from a snapshot written by SaveSnapshot,
+// keeping at most maxEntries keys. It returns the number of keys loaded.
+func (s *MemoryStore) LoadSnapshot(path string, maxEntries int) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open snapshot file: %w", err)
+	}
+	defer file.Close()
+
+	var snapshot memorySnapshot
+	if err := gob.NewDecoder(file).Decode(&snapshot); err != nil {
+		return 0, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	// Drop keys that expired while we were down
+	now := time.Now()
+	entries := make(map[string]time.Time, len(snapshot.Entries))
+	for key, expiryTime := range snapshot.Entries {
+		if expiryTime.After(now) {
+			entries[key] = expiryTime
+		}
+	}
+
+	entries = limitEntries(entries, maxEntries)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, expiryTime := range entries {
+		if current, ok := s.entries[key]; !ok || expiryTime.After(current) {
+			s.entries[key] = expiryTime
+		}
+	}
+
+	return len(entries), nil
+}
+
+// limitEntries keeps the maxEntries keys with the latest expiry
+func limitEntries(entries map[string]time.Time, maxEntries int) map[string]time.Time {
+	if maxEntries <= 0 || len(entries) <= maxEntries {
+		return entries
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return entries[keys[i]].After(entries[keys[j]])
+	})
+
+	limited := make(map[string]time.Time, maxEntries)
+	for _, key := range keys[:maxEntries] {
+		limited[key] = entries[key]
+	}
+	return limited
 }
 
 // runGC runs garbage collection to remove expired entries
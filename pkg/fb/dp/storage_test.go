@@ -0,0 +1,107 @@
+package dp
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_SnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), memorySnapshotFile)
+
+	store := NewMemoryStore()
+	require.NoError(t, store.Put([]byte("key-1"), time.Hour))
+	require.NoError(t, store.Put([]byte("key-2"), time.Hour))
+
+	written, err := store.SaveSnapshot(path, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, written)
+
+	restored := NewMemoryStore()
+	loaded, err := restored.LoadSnapshot(path, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, loaded)
+
+	for _, key := range []string{"key-1", "key-2"} {
+		exists, err := restored.Has([]byte(key))
+		require.NoError(t, err)
+		assert.True(t, exists, key)
+	}
+
+	exists, err := restored.Has([]byte("key-3"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestMemoryStore_SnapshotHonorsExpiryAfterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), memorySnapshotFile)
+
+	store := NewMemoryStore()
+	require.NoError(t, store.Put([]byte("short"), 50*time.Millisecond))
+	require.NoError(t, store.Put([]byte("long"), time.Hour))
+
+	written, err := store.SaveSnapshot(path, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, written)
+
+	// The short TTL expires while the store is "down"
+	time.Sleep(100 * time.Millisecond)
+
+	restored := NewMemoryStore()
+	loaded, err := restored.LoadSnapshot(path, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+
+	exists, err := restored.Has([]byte("short"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	exists, err = restored.Has([]byte("long"))
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestMemoryStore_SnapshotIsBounded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), memorySnapshotFile)
+
+	store := NewMemoryStore()
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, store.Put([]byte(fmt.Sprintf("key-%d", i)), time.Duration(i)*time.Hour))
+	}
+
+	written, err := store.SaveSnapshot(path, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, written)
+
+	// The keys expiring last are kept
+	restored := NewMemoryStore()
+	_, err = restored.LoadSnapshot(path, 0)
+	require.NoError(t, err)
+
+	for i := 1; i <= 5; i++ {
+		exists, err := restored.Has([]byte(fmt.Sprintf("key-%d", i)))
+		require.NoError(t, err)
+		assert.Equal(t, i > 2, exists, "key-%d", i)
+	}
+}
+
+func TestMemoryStore_EnableSnapshotsFlushes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", memorySnapshotFile)
+
+	store := NewMemoryStore()
+	loaded, err := store.EnableSnapshots(path, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, loaded)
+
+	require.NoError(t, store.Put([]byte("key"), time.Hour))
+	require.NoError(t, store.Flush())
+
+	restored := NewMemoryStore()
+	loaded, err = restored.EnableSnapshots(path, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+}
@@ -1,10 +1,28 @@
 package enhost
 
 import (
+	"errors"
 	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// DefaultNegativeTTL is how long a host with no metadata is remembered
+const DefaultNegativeTTL = 30 * time.Second
+
+// ErrHostNotFound is returned by a HostInfoLookup when there is no metadata for a host
+var ErrHostNotFound = errors.New("host metadata not found")
+
+var cacheNegativeTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "fb_en_host_cache_negative_total",
+	Help: "Total number of host lookups answered from the negative cache",
+})
+
+// HostInfoLookup fetches metadata for a host id (hostname or IP)
+type HostInfoLookup func(hostID string) (*HostInfo, error)
+
 // HostInfo contains information about a host
 type HostInfo struct {
 	// Host identification
@@ -39,8 +57,10 @@ type ProcessInfo struct {
 // HostInfoCache provides caching for host information
 type HostInfoCache struct {
 	cache       map[string]*HostInfo  // key is hostname or IP
+	negative    map[string]time.Time  // hosts with no metadata, to expiry time
 	mu          sync.RWMutex
 	ttl         time.Duration
+	negativeTTL time.Duration
 	cleanupTick time.Duration
 	stopCh      chan struct{}
 }
@@ -49,7 +69,9 @@ type HostInfoCache struct {
 func NewHostInfoCache(ttl time.Duration) *HostInfoCache {
 	cache := &HostInfoCache{
 		cache:       make(map[string]*HostInfo),
+		negative:    make(map[string]time.Time),
 		ttl:         ttl,
+		negativeTTL: DefaultNegativeTTL,
 		cleanupTick: ttl / 2,  // Clean up at half the TTL interval
 		stopCh:      make(chan struct{}),
 	}
@@ -87,6 +109,12 @@ func (c *HostInfoCache) cleanup() {
 			delete(c.cache, key)
 		}
 	}
+
+	for key, expiry := range c.negative {
+		if now.After(expiry) {
+			delete(c.negative, key)
+		}
+	}
 }
 
 // Get retrieves host info from the cache
@@ -116,6 +144,68 @@ func (c *HostInfoCache) Put(key string, info *HostInfo) {
 	info.CollectedAt = time.Now()
 	
 	c.cache[key] = info
+	delete(c.negative, key)
+}
+
+// PutNegative records that a host has no metadata, so lookups for it are
+// skipped until the negative TTL expires
+func (c *HostInfoCache) PutNegative(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.negative[key] = time.Now().Add(c.negativeTTL)
+}
+
+// IsNegative returns whether the host is in the negative cache
+func (c *HostInfoCache) IsNegative(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	expiry, found := c.negative[key]
+	return found && time.Now().Before(expiry)
+}
+
+// Lookup returns host info from the cache, falling back to lookup on a miss.
+// Hosts the lookup reports as not found are negatively cached, and found is
+// false for them.
+func (c *HostInfoCache) Lookup(key string, lookup HostInfoLookup) (*HostInfo, bool, error) {
+	if info, found := c.Get(key); found {
+		return info, true, nil
+	}
+
+	if c.IsNegative(key) {
+		cacheNegativeTotal.Inc()
+		return nil, false, nil
+	}
+
+	info, err := lookup(key)
+	if errors.Is(err, ErrHostNotFound) {
+		c.PutNegative(key)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.Put(key, info)
+	return info, true, nil
+}
+
+// Warmup preloads metadata for the given hosts and returns how many were found
+func (c *HostInfoCache) Warmup(hostIDs []string, lookup HostInfoLookup) (int, error) {
+	var loaded int
+	var errs []error
+	for _, hostID := range hostIDs {
+		_, found, err := c.Lookup(hostID, lookup)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if found {
+			loaded++
+		}
+	}
+	return loaded, errors.Join(errs...)
 }
 
 // GetProcessInfo retrieves process info from the cache
@@ -150,6 +240,13 @@ func (c *HostInfoCache) SetTTL(ttl time.Duration) {
 	c.mu.Unlock()
 }
 
+// SetNegativeTTL updates how long hosts with no metadata are remembered
+func (c *HostInfoCache) SetNegativeTTL(ttl time.Duration) {
+	c.mu.Lock()
+	c.negativeTTL = ttl
+	c.mu.Unlock()
+}
+
 // Stop stops the cache cleanup loop
 func (c *HostInfoCache) Stop() {
 	close(c.stopCh)
@@ -177,6 +274,19 @@ func CollectHostInfo() (*HostInfo, error) {
 	}, nil
 }
 
+// lookupLocalHostInfo is the default HostInfoLookup. It only knows about the
+// host the FB runs on.
+func lookupLocalHostInfo(hostID string) (*HostInfo, error) {
+	info, err := CollectHostInfo()
+	if err != nil {
+		return nil, err
+	}
+	if hostID != info.Hostname && hostID != info.IP {
+		return nil, ErrHostNotFound
+	}
+	return info, nil
+}
+
 // CollectProcessInfo collects information about a specific process
 // This is a placeholder implementation - in a real system this would
 // collect actual process information
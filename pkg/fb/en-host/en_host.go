@@ -2,8 +2,11 @@ package enhost
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 	"eidc-tfk8s/internal/common/tracing"
 	"eidc-tfk8s/internal/config"
 	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -25,6 +29,14 @@ type ENHostConfig struct {
 	// EN-HOST-specific configuration
 	Enabled  bool   `json:"enabled"`
 	CacheTTL string `json:"cacheTTL"`
+
+	// NegativeCacheTTL is how long hosts with no metadata are remembered
+	NegativeCacheTTL string `json:"negativeCacheTTL"`
+
+	// StaticAttributes are added to the labels of every metric, e.g. cluster
+	// name or region. Existing labels are kept unless Override is set.
+	StaticAttributes map[string]string `json:"staticAttributes"`
+	Override         bool              `json:"override"`
 }
 
 // ENHost implements the FB-EN-HOST (Host Enrichment) function block
@@ -39,31 +51,66 @@ type ENHost struct {
 	nextFBConn      *grpc.ClientConn
 	dlqClient       fb.ChainPushServiceClient
 	dlqConn         *grpc.ClientConn
-	circuitBreaker  *resilience.CircuitBreaker
+	nextFBBreaker   *resilience.CircuitBreaker
+	dlqBreaker      *resilience.CircuitBreaker
+	cache           *HostInfoCache
+	hostLookup      HostInfoLookup
+	warmupHostIDs   []string
 }
 
+// LabelHost is the metric label holding the host id (hostname or IP) whose
+// metadata is added to the metric
+const LabelHost = "host"
+
+// defaultCacheTTL is the host info cache TTL used until config is applied
+const defaultCacheTTL = 10 * time.Minute
+
 // NewENHost creates a new Host Enrichment function block
 func NewENHost() *ENHost {
 	return &ENHost{
-		BaseFunctionBlock: fb.BaseFunctionBlock{
-			name:  "fb-en-host",
-			ready: false,
-		},
-		logger:  logging.NewLogger("fb-en-host"),
-		metrics: metrics.NewFBMetrics("fb-en-host"),
-		tracer:  tracing.NewTracer("fb-en-host"),
+		BaseFunctionBlock: fb.NewBaseFunctionBlock("fb-en-host"),
+		logger:     logging.NewLogger("fb-en-host"),
+		metrics:    metrics.NewFBMetrics("fb-en-host"),
+		tracer:     tracing.NewTracer("fb-en-host"),
+		hostLookup: lookupLocalHostInfo,
 	}
 }
 
+// SetWarmupHostIDs sets the hosts whose metadata is preloaded into the cache
+// on Initialize. It must be called before Initialize.
+func (e *ENHost) SetWarmupHostIDs(hostIDs []string) {
+	e.warmupHostIDs = hostIDs
+}
+
 // Initialize initializes the Host Enrichment function block
 func (e *ENHost) Initialize(ctx context.Context) error {
 	e.logger.Info("Initializing FB-EN-HOST", nil)
 
-	// Initialize circuit breaker with default config
-	e.circuitBreaker = resilience.NewCircuitBreaker("fb-en-host", resilience.DefaultCircuitBreakerConfig())
+	// Initialize circuit breakers with default config
+	e.nextFBBreaker = resilience.NewCircuitBreaker("fb-en-host", resilience.DownstreamNextFB, resilience.DefaultCircuitBreakerConfig())
+	e.dlqBreaker = resilience.NewCircuitBreaker("fb-en-host", resilience.DownstreamDLQ, resilience.DefaultCircuitBreakerConfig())
+
+	// Create the host info cache and preload it so the first batches don't
+	// trigger a burst of lookups
+	e.cache = NewHostInfoCache(defaultCacheTTL)
+	if len(e.warmupHostIDs) > 0 {
+		loaded, err := e.cache.Warmup(e.warmupHostIDs, e.hostLookup)
+		if err != nil {
+			// Not fatal; the remaining hosts are looked up on demand
+			e.logger.Error("Failed to warm up host info cache", err, nil)
+		}
+		e.logger.Info("Warmed up host info cache", map[string]interface{}{
+			"hosts":  len(e.warmupHostIDs),
+			"loaded": loaded,
+		})
+	}
+
+	if err := e.Transition(fb.StateInitialized); err != nil {
+		return err
+	}
 
 	// Mark as ready (full readiness will be set after config is loaded)
-	e.BaseFunctionBlock.ready = true
+	e.SetReady(true)
 
 	return nil
 }
@@ -78,6 +125,27 @@ func (e *ENHost) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.P
 
 	// Record metric
 	e.metrics.RecordBatchReceived()
+	e.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeReceived)
+
+	// Reject batches until config has been applied, and while shutting down
+	done, result, err := e.BeginBatch(batch.BatchID)
+	if err != nil {
+		return result, err
+	}
+	defer done()
+
+	// Send batches whose data was corrupted in transit to the DLQ
+	if result, err := e.VerifyChecksum(ctx, batch, e.sendToDLQ); err != nil {
+		return result, err
+	}
+
+	// Forward unchanged if this FB is disabled in the pipeline config
+	if !e.BaseFunctionBlock.Enabled() {
+		return e.BypassBatch(ctx, batch, e.forwardToNextFB)
+	}
+
+	// Capture the batch as received if the debug tap samples it
+	e.CaptureBatch(ctx, fb.DebugStageIn, batch)
 
 	startTime := time.Now()
 
@@ -91,30 +159,53 @@ func (e *ENHost) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.P
 	processingErr := e.processBatch(ctx, batch)
 	if processingErr != nil {
 		e.metrics.RecordProcessingError()
+
+		// Batches this FB can't decode won't succeed on retry
+		if errors.Is(processingErr, codec.ErrUnknownFormat) {
+			if dlqErr := e.sendToDLQ(ctx, batch, processingErr); dlqErr != nil {
+				if errors.Is(dlqErr, fb.ErrNoDLQ) {
+					return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeInvalidInput, processingErr), processingErr
+				}
+				e.logger.Error("Failed to send undecodable batch to DLQ", dlqErr, map[string]interface{}{
+					"batch_id": batch.BatchID,
+					"format":   batch.Format,
+				})
+				return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
+			}
+			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeInvalidInput, processingErr, true), processingErr
+		}
 		e.tracer.RecordError(ctx, processingErr)
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeProcessingFailed, processingErr, false), processingErr
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeProcessingFailed, processingErr), processingErr
 	}
 
 	// Record processing metrics
 	e.metrics.RecordBatchProcessed(time.Since(startTime).Seconds())
 
+	// Capture the batch as forwarded if the debug tap samples it
+	e.CaptureBatch(ctx, fb.DebugStageOut, batch)
+
 	// Forward to next FB
 	forwardingResult, forwardingErr := e.forwardToNextFB(ctx, batch)
 	if forwardingErr != nil {
 		e.tracer.RecordError(ctx, forwardingErr)
 
-		// If forwarding fails but processing succeeded, attempt to send to DLQ
-		dlqErr := e.sendToDLQ(ctx, batch, forwardingErr)
+		// Batches the next FB rejected for good go to the quarantine, if
+		// any, and are reported upstream with its code so they aren't retried
+		code := fb.ForwardingErrorCode(forwardingErr)
+		dlqErr := e.SendFailedBatch(ctx, batch, code, forwardingErr, e.sendToDLQ)
 		if dlqErr != nil {
-			e.logger.Error("Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
+			if errors.Is(dlqErr, fb.ErrNoDLQ) {
+				return fb.NewCodeErrorResult(batch.BatchID, code, forwardingErr), forwardingErr
+			}
+			e.logger.ErrorCtx(ctx, "Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
 				"batch_id": batch.BatchID,
 			})
 			e.tracer.RecordError(ctx, dlqErr)
-			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeDLQSendFailed, dlqErr, false), dlqErr
+			return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
 		}
 		
 		// Return error with DLQ status
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, forwardingErr, true), forwardingErr
+		return fb.NewErrorResult(batch.BatchID, code, forwardingErr, true), forwardingErr
 	}
 
 	return forwardingResult, nil
@@ -126,17 +217,91 @@ func (e *ENHost) processBatch(ctx context.Context, batch *fb.MetricBatch) error
 	ctx, span := e.tracer.StartSpan(ctx, "host-enrichment", nil)
 	defer span.End()
 
-	// TODO: Implement host-level enrichment logic here
-	// This would involve:
-	// 1. Extracting host information for each metric
-	// 2. Looking up additional host metadata (OS, CPU, memory, etc.)
-	// 3. Enriching metrics with this metadata
+	e.configMu.RLock()
+	staticAttributes := e.config.StaticAttributes
+	override := e.config.Override
+	e.configMu.RUnlock()
 
-	return nil
+	cache := e.cache
+	if len(staticAttributes) == 0 && cache == nil {
+		return nil
+	}
+
+	// Deserialize the batch data
+	metrics, err := codec.Decode(batch)
+	if err != nil {
+		return err
+	}
+
+	for _, metric := range metrics {
+		if cache != nil {
+			mergeStaticAttributes(metric, e.hostAttributes(cache, metric), override)
+		}
+		mergeStaticAttributes(metric, staticAttributes, override)
+	}
+
+	return codec.Encode(batch, metrics)
+}
+
+// hostAttributes returns the labels describing the host named by the
+// metric's host label, looked up through the cache. It returns nil for
+// metrics without a host label and for hosts without metadata.
+func (e *ENHost) hostAttributes(cache *HostInfoCache, metric map[string]interface{}) map[string]string {
+	labels, _ := metric["labels"].(map[string]interface{})
+	hostID, _ := labels[LabelHost].(string)
+	if hostID == "" {
+		return nil
+	}
+
+	info, found, err := cache.Lookup(hostID, e.hostLookup)
+	if err != nil {
+		e.logger.Warn("Failed to look up host metadata", map[string]interface{}{
+			"host":  hostID,
+			"error": err.Error(),
+		})
+		return nil
+	}
+	if !found {
+		return nil
+	}
+
+	return map[string]string{
+		"host_ip":           info.IP,
+		"host_os":           info.OS,
+		"host_arch":         info.Architecture,
+		"host_cpu_count":    strconv.Itoa(info.CPUCount),
+		"host_memory_bytes": strconv.FormatInt(info.TotalMemory, 10),
+	}
+}
+
+// mergeStaticAttributes adds attributes to the metric's labels. Existing
+// labels are only replaced when override is set.
+func mergeStaticAttributes(metric map[string]interface{}, attributes map[string]string, override bool) {
+	if len(attributes) == 0 {
+		return
+	}
+
+	labels, ok := metric["labels"].(map[string]interface{})
+	if !ok {
+		labels = make(map[string]interface{}, len(attributes))
+		metric["labels"] = labels
+	}
+
+	for key, value := range attributes {
+		if _, exists := labels[key]; exists && !override {
+			continue
+		}
+		labels[key] = value
+	}
 }
 
 // forwardToNextFB forwards the batch to the next function block
 func (e *ENHost) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	// Fan out instead if several next FBs are configured
+	if e.FansOut() {
+		return e.ForwardToNextFBs(ctx, batch, e.sendToDLQ)
+	}
+
 	startTime := time.Now()
 
 	// Create child span for forwarding
@@ -144,7 +309,7 @@ func (e *ENHost) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*f
 	defer span.End()
 
 	// Use circuit breaker to protect against downstream failures
-	err := e.circuitBreaker.Execute(ctx, func(ctx context.Context) error {
+	err := e.nextFBBreaker.Execute(ctx, func(ctx context.Context) error {
 		// Get the current config
 		e.configMu.RLock()
 		nextFB := e.config.Common.NextFB
@@ -174,7 +339,7 @@ func (e *ENHost) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*f
 
 		// Check response
 		if res.Status != fb.StatusSuccess {
-			return fmt.Errorf("next FB returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+			return fb.NewNextFBError("", res)
 		}
 
 		return nil
@@ -184,17 +349,24 @@ func (e *ENHost) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*f
 	e.metrics.RecordBatchForwarded(time.Since(startTime).Seconds())
 
 	if err != nil {
+		e.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeFailed)
 		if err == resilience.ErrCircuitOpen {
-			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err, false), err
+			return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err), err
 		}
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err, false), err
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err), err
 	}
 
+	e.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeForwarded)
 	return fb.NewSuccessResult(batch.BatchID), nil
 }
 
 // sendToDLQ sends a batch to the Dead Letter Queue
 func (e *ENHost) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr error) error {
+	// Without a DLQ the batch is failed back to the caller
+	if !e.HasDLQ() {
+		return fb.ErrNoDLQ
+	}
+
 	// Create child span for DLQ
 	ctx, span := e.tracer.StartSpan(ctx, "send-to-dlq", nil)
 	defer span.End()
@@ -209,7 +381,17 @@ func (e *ENHost) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalE
 		batch.InternalLabels = make(map[string]string)
 	}
 	batch.InternalLabels["error"] = originalErr.Error()
-	batch.InternalLabels["fb_sender"] = e.Name()
+	batch.InternalLabels["fb_sender"] = e.Sender()
+
+	// Drop poison batches rather than cycle them through the DLQ forever
+	if e.RecordDLQHop(batch.InternalLabels) {
+		e.logger.Warn("Dropping poison batch", map[string]interface{}{
+			"batch_id":     batch.BatchID,
+			"replay_count": batch.InternalLabels[fb.LabelReplayCount],
+			"error":        originalErr.Error(),
+		})
+		return fb.ErrPoisonBatch
+	}
 
 	// Convert to ChainPushService request
 	req := &fb.MetricBatchRequest{
@@ -223,18 +405,26 @@ func (e *ENHost) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalE
 	}
 
 	// Send to DLQ
-	res, err := e.dlqClient.PushMetrics(ctx, req)
-	if err != nil {
-		return fmt.Errorf("failed to push metrics to DLQ: %w", err)
-	}
+	err := e.dlqBreaker.Execute(ctx, func(ctx context.Context) error {
+		res, err := e.dlqClient.PushMetrics(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to DLQ: %w", err)
+		}
+
+		// Check response
+		if res.Status != fb.StatusSuccess {
+			return fmt.Errorf("DLQ returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		}
 
-	// Check response
-	if res.Status != fb.StatusSuccess {
-		return fmt.Errorf("DLQ returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Record metric
 	e.metrics.RecordBatchDLQ()
+	e.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeDropped)
 
 	return nil
 }
@@ -245,32 +435,62 @@ func (e *ENHost) UpdateConfig(ctx context.Context, configBytes []byte, generatio
 	ctx, span := e.tracer.StartSpan(ctx, "update-config", nil)
 	defer span.End()
 
-	// Parse configuration
-	var newConfig ENHostConfig
-	if err := json.Unmarshal(configBytes, &newConfig); err != nil {
-		return fmt.Errorf("failed to parse config: %w", err)
+	// Parse and validate configuration
+	newConfig, err := e.parseConfig(configBytes)
+	if err != nil {
+		return err
 	}
 
-	// Validate configuration
-	if err := e.validateConfig(&newConfig); err != nil {
-		return fmt.Errorf("invalid config: %w", err)
+	// Refuse a generation older than the one applied, unless rolling back
+	if err := e.CheckConfigGeneration(generation, newConfig.Common.Rollback); err != nil {
+		e.logger.Error("Refused configuration", err, map[string]interface{}{
+			"generation":         generation,
+			"applied_generation": e.GetConfigGeneration(),
+		})
+		return err
 	}
 
 	// Apply configuration
 	e.configMu.Lock()
-	e.config = &newConfig
-	e.configGeneration = generation
+	oldConfig := e.config
+	e.config = newConfig
+	e.SetConfigGeneration(generation)
+	e.SetEnabled(newConfig.Common.IsEnabled())
+	e.SetMaxConcurrentBatches(newConfig.Common.MaxConcurrentBatches)
+	e.SetMaxReplayCount(newConfig.Common.MaxReplayCount)
+	e.SetVerifyChecksum(newConfig.Common.VerifyChecksum)
+	e.SetHasDLQ(newConfig.Common.DLQ != "")
+	e.SetMetadataLimits(fb.MetadataLimits{
+		MaxEntries: newConfig.Common.MaxMetadataEntries,
+		MaxBytes:   newConfig.Common.MaxMetadataBytes,
+		Action:     fb.MetadataLimitAction(newConfig.Common.MetadataLimitAction),
+	})
+	if level, err := logging.ParseLevel(newConfig.Common.LogLevel); err == nil {
+		e.logger.SetLevel(level)
+	}
+	if newConfig.Common.TracingEnabled {
+		e.tracer.SetSamplingRatio(newConfig.Common.TraceSamplingRatio)
+	}
+	seed, _ := newConfig.Common.DeterministicSeed()
+	e.tracer.SetSeed(seed)
 	e.configMu.Unlock()
 
+	// Update cache TTLs
+	if e.cache != nil {
+		if ttl, err := time.ParseDuration(newConfig.CacheTTL); err == nil {
+			e.cache.SetTTL(ttl)
+		}
+		if ttl, err := time.ParseDuration(newConfig.NegativeCacheTTL); err == nil {
+			e.cache.SetNegativeTTL(ttl)
+		}
+	}
+
 	// Update circuit breaker configuration
-	e.circuitBreaker = resilience.NewCircuitBreaker("fb-en-host", resilience.CircuitBreakerConfig{
-		ErrorThresholdPercentage: newConfig.Common.CircuitBreaker.ErrorThresholdPercentage,
-		OpenStateSeconds:         newConfig.Common.CircuitBreaker.OpenStateSeconds,
-		HalfOpenRequestThreshold: newConfig.Common.CircuitBreaker.HalfOpenRequestThreshold,
-	})
+	e.nextFBBreaker = resilience.NewCircuitBreaker("fb-en-host", resilience.DownstreamNextFB, newConfig.Common.NextFBCircuitBreakerConfig())
+	e.dlqBreaker = resilience.NewCircuitBreaker("fb-en-host", resilience.DownstreamDLQ, newConfig.Common.DLQCircuitBreakerConfig())
 
 	// Connect to next FB and DLQ if not already connected
-	if e.nextFBClient == nil {
+	if e.nextFBClient == nil && newConfig.Common.NextFB != "" {
 		if err := e.connectToNextFB(ctx, newConfig.Common.NextFB); err != nil {
 			e.logger.Error("Failed to connect to next FB", err, map[string]interface{}{
 				"next_fb": newConfig.Common.NextFB,
@@ -279,7 +499,15 @@ func (e *ENHost) UpdateConfig(ctx context.Context, configBytes []byte, generatio
 		}
 	}
 
-	if e.dlqClient == nil {
+	if err := e.ConnectNextFBs(ctx, newConfig.Common, metrics.GRPCDialOption(e.Name())); err != nil {
+		e.logger.Error("Failed to connect to next FBs", err, map[string]interface{}{
+			"next_fbs": len(newConfig.Common.NextFBs),
+		})
+		// Don't fail config update on connection error - batches to the
+		// targets not connected fail until the next update
+	}
+
+	if e.dlqClient == nil && newConfig.Common.DLQ != "" {
 		if err := e.connectToDLQ(ctx, newConfig.Common.DLQ); err != nil {
 			e.logger.Error("Failed to connect to DLQ", err, map[string]interface{}{
 				"dlq": newConfig.Common.DLQ,
@@ -288,29 +516,100 @@ func (e *ENHost) UpdateConfig(ctx context.Context, configBytes []byte, generatio
 		}
 	}
 
+	if err := e.ConnectQuarantine(ctx, newConfig.Common.Quarantine); err != nil {
+		e.logger.Error("Failed to connect to quarantine", err, map[string]interface{}{
+			"quarantine": newConfig.Common.Quarantine,
+		})
+		// Don't fail config update on connection error - we'll retry on next update
+	}
+
+	if err := e.ConfigureDebugTap(ctx, newConfig.Common.DebugTap, codec.Decode); err != nil {
+		e.logger.Error("Failed to configure debug tap", err, nil)
+		// Don't fail config update on a debug tap error - batches just aren't captured
+	}
+
+	// Start processing now that config is applied
+	if err := e.ConfigApplied(); err != nil {
+		return err
+	}
+
 	// Update metrics
 	e.metrics.SetConfigGeneration(generation)
+	e.SetReady(true)
 	e.metrics.SetReady(true)
 
+	// Report what the update changed, for rollout audits
+	changes, _ := config.DiffConfig(oldConfig, newConfig)
+
 	e.logger.Info("Config updated", map[string]interface{}{
-		"generation": generation,
-		"enabled":    newConfig.Enabled,
-		"cache_ttl":  newConfig.CacheTTL,
+		"generation":        generation,
+		"enabled":           newConfig.Enabled,
+		"cache_ttl":         newConfig.CacheTTL,
+		"static_attributes": len(newConfig.StaticAttributes),
+		"changes":           changes,
 	})
 
 	return nil
 }
 
+// ValidateConfig parses and validates a configuration without applying it
+func (e *ENHost) ValidateConfig(configBytes []byte) error {
+	_, err := e.parseConfig(configBytes)
+	return err
+}
+
+// parseConfig parses and validates a configuration
+func (e *ENHost) parseConfig(configBytes []byte) (*ENHostConfig, error) {
+	// Merge a patch onto the applied config
+	e.configMu.RLock()
+	applied := e.config
+	e.configMu.RUnlock()
+	configBytes, err := config.ResolveMergePatch(configBytes, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	var newConfig ENHostConfig
+	if err := config.LoadConfigFromBytes(configBytes, &newConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if err := e.validateConfig(&newConfig); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &newConfig, nil
+}
+
 // validateConfig validates the Host Enrichment function block's configuration
 func (e *ENHost) validateConfig(config *ENHostConfig) error {
+	// Validate common settings
+	if err := config.Common.Validate(); err != nil {
+		return err
+	}
+
 	// Check if next FB is configured
-	if config.Common.NextFB == "" {
+	if !config.Common.HasNextFB() {
 		return fmt.Errorf("next FB not configured")
 	}
 
-	// Check if DLQ is configured
-	if config.Common.DLQ == "" {
-		return fmt.Errorf("DLQ not configured")
+	// Validate cache TTLs
+	if config.CacheTTL != "" {
+		if ttl, err := time.ParseDuration(config.CacheTTL); err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid cache TTL: %s", config.CacheTTL)
+		}
+	}
+	if config.NegativeCacheTTL != "" {
+		if ttl, err := time.ParseDuration(config.NegativeCacheTTL); err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid negative cache TTL: %s", config.NegativeCacheTTL)
+		}
+	}
+
+	// Validate static attributes
+	for key := range config.StaticAttributes {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("static attribute keys must not be empty")
+		}
 	}
 
 	return nil
@@ -326,9 +625,10 @@ func (e *ENHost) connectToNextFB(ctx context.Context, nextFB string) error {
 	}
 
 	// Create new connection
-	conn, err := grpc.DialContext(ctx, nextFB,
+	conn, err := e.DialContext(ctx, nextFB,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithBlock(),
+		metrics.GRPCDialOption(e.Name()),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to connect to next FB: %w", err)
@@ -350,7 +650,7 @@ func (e *ENHost) connectToDLQ(ctx context.Context, dlqAddr string) error {
 	}
 
 	// Create new connection
-	conn, err := grpc.DialContext(ctx, dlqAddr,
+	conn, err := e.DialContext(ctx, dlqAddr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithBlock(),
 	)
@@ -368,23 +668,80 @@ func (e *ENHost) connectToDLQ(ctx context.Context, dlqAddr string) error {
 func (e *ENHost) Shutdown(ctx context.Context) error {
 	e.logger.Info("Shutting down FB-EN-HOST", nil)
 
-	// Close connections
+	// Stop accepting batches
+	if err := e.Transition(fb.StateDraining); err != nil {
+		return err
+	}
+
+	// Let batches in flight finish, including their DLQ sends
+	drainErr := e.DrainBatches(ctx)
+	if drainErr != nil {
+		e.logger.Warn("Shutting down with batches still in flight", map[string]interface{}{
+			"error": drainErr.Error(),
+		})
+	}
+
+	// Stop the cache cleanup loop
+	if e.cache != nil {
+		e.cache.Stop()
+		e.cache = nil
+	}
+
+	// Close connections, the DLQ and quarantine last
 	if e.nextFBConn != nil {
 		e.nextFBConn.Close()
 		e.nextFBConn = nil
 		e.nextFBClient = nil
 	}
+	e.CloseNextFBs()
 
 	if e.dlqConn != nil {
 		e.dlqConn.Close()
 		e.dlqConn = nil
 		e.dlqClient = nil
 	}
+	e.CloseQuarantine()
+	e.CloseDebugTap()
 
 	// Mark as not ready
-	e.BaseFunctionBlock.ready = false
+	e.SetReady(false)
 
-	return nil
+	if err := e.Transition(fb.StateStopped); err != nil {
+		return err
+	}
+	return drainErr
+}
+
+// StartGRPCServer starts the gRPC server for the ChainPushService
+func StartGRPCServer(ctx context.Context, e *ENHost, port int) (*grpc.Server, error) {
+	// Create gRPC server, recording RPC metrics
+	server := grpc.NewServer(metrics.GRPCServerOptions(e.Name())...)
+
+	// Register the ChainPushService
+	e.logger.Info("Registering ChainPushService", map[string]interface{}{"port": port})
+	fb.RegisterChainPushServiceServer(server, fb.NewChainPushServiceHandler(e))
+
+	// Register the standard gRPC health service
+	e.RegisterHealthServer(server)
+
+	// Register the reflection service, if enabled for debugging
+	e.RegisterReflectionServer(server)
+
+	// Start gRPC server
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on port %d: %w", port, err)
+	}
+
+	// Start server in a goroutine
+	go func() {
+		e.logger.Info("Starting gRPC server", map[string]interface{}{"port": port})
+		if err := server.Serve(lis); err != nil {
+			e.logger.Error("gRPC server failed", err, nil)
+		}
+	}()
+
+	return server, nil
 }
 
 // Testing helpers
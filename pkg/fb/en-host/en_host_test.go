@@ -0,0 +1,200 @@
+package enhost
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestENHost_ProcessBatch_StaticAttributes(t *testing.T) {
+	e := NewENHost()
+	e.config = &ENHostConfig{
+		StaticAttributes: map[string]string{
+			"cluster": "prod-us-east",
+			"region":  "us-east-1",
+		},
+	}
+
+	batch := &fb.MetricBatch{
+		BatchID: "test-batch-id",
+		Data: []byte(`[
+			{"name":"cpu","labels":{"host":"node-1","region":"eu-west-1"}},
+			{"name":"memory"}
+		]`),
+	}
+
+	err := e.processBatch(context.Background(), batch)
+	require.NoError(t, err)
+
+	var metrics []map[string]interface{}
+	require.NoError(t, json.Unmarshal(batch.Data, &metrics))
+	require.Len(t, metrics, 2)
+
+	// Existing labels win without override
+	assert.Equal(t, map[string]interface{}{
+		"host":    "node-1",
+		"region":  "eu-west-1",
+		"cluster": "prod-us-east",
+	}, metrics[0]["labels"])
+
+	// Metrics without labels get all attributes
+	assert.Equal(t, map[string]interface{}{
+		"region":  "us-east-1",
+		"cluster": "prod-us-east",
+	}, metrics[1]["labels"])
+}
+
+func TestMergeStaticAttributes_Override(t *testing.T) {
+	metric := map[string]interface{}{
+		"labels": map[string]interface{}{"region": "eu-west-1"},
+	}
+
+	mergeStaticAttributes(metric, map[string]string{"region": "us-east-1"}, true)
+	assert.Equal(t, "us-east-1", metric["labels"].(map[string]interface{})["region"])
+}
+
+func TestENHost_ValidateConfig_StaticAttributes(t *testing.T) {
+	e := NewENHost()
+
+	config := &ENHostConfig{
+		StaticAttributes: map[string]string{" ": "value"},
+	}
+	config.Common.NextFB = "fb-next:5000"
+	config.Common.DLQ = "fb-dlq:5000"
+
+	err := e.validateConfig(config)
+	assert.Error(t, err)
+
+	config.StaticAttributes = map[string]string{"cluster": "prod"}
+	assert.NoError(t, e.validateConfig(config))
+}
+
+func TestENHost_Initialize_WarmsUpCache(t *testing.T) {
+	var lookups []string
+	e := NewENHost()
+	e.hostLookup = func(hostID string) (*HostInfo, error) {
+		lookups = append(lookups, hostID)
+		if hostID == "unknown" {
+			return nil, ErrHostNotFound
+		}
+		return &HostInfo{Hostname: hostID}, nil
+	}
+	e.SetWarmupHostIDs([]string{"node-1", "node-2", "unknown"})
+
+	require.NoError(t, e.Initialize(context.Background()))
+	defer e.Shutdown(context.Background())
+
+	assert.ElementsMatch(t, []string{"node-1", "node-2", "unknown"}, lookups)
+
+	info, found := e.cache.Get("node-1")
+	assert.True(t, found)
+	assert.Equal(t, "node-1", info.Hostname)
+	_, found = e.cache.Get("node-2")
+	assert.True(t, found)
+
+	// Hosts without metadata are negatively cached by the warmup
+	assert.True(t, e.cache.IsNegative("unknown"))
+}
+
+func TestENHost_ProcessBatch_HostMetadata(t *testing.T) {
+	var lookups []string
+	e := NewENHost()
+	e.hostLookup = func(hostID string) (*HostInfo, error) {
+		lookups = append(lookups, hostID)
+		if hostID != "node-1" {
+			return nil, ErrHostNotFound
+		}
+		return &HostInfo{Hostname: "node-1", IP: "10.0.0.1", OS: "linux", Architecture: "arm64", CPUCount: 8, TotalMemory: 1024}, nil
+	}
+	require.NoError(t, e.Initialize(context.Background()))
+	defer e.Shutdown(context.Background())
+	e.config = &ENHostConfig{}
+
+	batch := &fb.MetricBatch{
+		BatchID: "test-batch-id",
+		Data: []byte(`[
+			{"name":"cpu","labels":{"host":"node-1","host_os":"windows"}},
+			{"name":"memory","labels":{"host":"node-1"}},
+			{"name":"disk","labels":{"host":"node-2"}},
+			{"name":"load"}
+		]`),
+	}
+	require.NoError(t, e.processBatch(context.Background(), batch))
+
+	var metrics []map[string]interface{}
+	require.NoError(t, json.Unmarshal(batch.Data, &metrics))
+	require.Len(t, metrics, 4)
+
+	// Existing labels win without override
+	assert.Equal(t, map[string]interface{}{
+		"host":              "node-1",
+		"host_ip":           "10.0.0.1",
+		"host_os":           "windows",
+		"host_arch":         "arm64",
+		"host_cpu_count":    "8",
+		"host_memory_bytes": "1024",
+	}, metrics[0]["labels"])
+	assert.Equal(t, "linux", metrics[1]["labels"].(map[string]interface{})["host_os"])
+
+	// Hosts without metadata and metrics without a host are left alone
+	assert.Equal(t, map[string]interface{}{"host": "node-2"}, metrics[2]["labels"])
+	assert.NotContains(t, metrics[3], "labels")
+
+	// Each host is looked up once, then answered from the cache
+	assert.Equal(t, []string{"node-1", "node-2"}, lookups)
+}
+
+func TestHostInfoCache_NegativeCacheExpiry(t *testing.T) {
+	cache := NewHostInfoCache(time.Minute)
+	defer cache.Stop()
+	cache.SetNegativeTTL(50 * time.Millisecond)
+
+	var lookups int
+	lookup := func(hostID string) (*HostInfo, error) {
+		lookups++
+		return nil, ErrHostNotFound
+	}
+
+	negativeBefore := testutil.ToFloat64(cacheNegativeTotal)
+
+	// The first miss hits the source, repeated misses don't
+	for i := 0; i < 3; i++ {
+		_, found, err := cache.Lookup("missing", lookup)
+		require.NoError(t, err)
+		assert.False(t, found)
+	}
+	assert.Equal(t, 1, lookups)
+	assert.Equal(t, float64(2), testutil.ToFloat64(cacheNegativeTotal)-negativeBefore)
+
+	// Once the negative entry expires the source is queried again
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, cache.IsNegative("missing"))
+	_, found, err := cache.Lookup("missing", lookup)
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, 2, lookups)
+}
+
+func TestHostInfoCache_PutClearsNegative(t *testing.T) {
+	cache := NewHostInfoCache(time.Minute)
+	defer cache.Stop()
+
+	cache.PutNegative("node-1")
+	assert.True(t, cache.IsNegative("node-1"))
+
+	cache.Put("node-1", &HostInfo{Hostname: "node-1"})
+	assert.False(t, cache.IsNegative("node-1"))
+}
+
+func TestDefaultConfig_MatchesParametersSchema(t *testing.T) {
+	configBytes, err := json.Marshal(DefaultConfig())
+	require.NoError(t, err)
+	assert.NoError(t, config.ValidateParameters("fb-en-host", configBytes))
+}
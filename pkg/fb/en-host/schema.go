@@ -0,0 +1,42 @@
+package enhost
+
+import (
+	"eidc-tfk8s/internal/config"
+)
+
+// parametersSchema is the JSON Schema of ENHostConfig
+const parametersSchema = `{
+	"type": "object",
+	"properties": {
+		"enabled": {"type": "boolean"},
+		"cacheTTL": {"type": "string"},
+		"negativeCacheTTL": {"type": "string"},
+		"staticAttributes": {"type": "object"},
+		"override": {"type": "boolean"}
+	}
+}`
+
+func init() {
+	config.RegisterParametersSchema("fb-en-host", parametersSchema)
+}
+
+// DefaultConfig returns the EN-HOST configuration with the default cache TTLs
+func DefaultConfig() ENHostConfig {
+	return ENHostConfig{
+		Common: config.FBConfig{
+			LogLevel:           "info",
+			MetricsEnabled:     true,
+			TracingEnabled:     true,
+			TraceSamplingRatio: 0.1,
+			CircuitBreaker: config.CircuitBreakerConfig{
+				ErrorThresholdPercentage: 50,
+				OpenStateSeconds:         30,
+				HalfOpenRequestThreshold: 5,
+			},
+		},
+		Enabled:          true,
+		CacheTTL:         defaultCacheTTL.String(),
+		NegativeCacheTTL: DefaultNegativeTTL.String(),
+		StaticAttributes: map[string]string{},
+	}
+}
@@ -0,0 +1,218 @@
+package fb
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorCode represents an error code for standardized error handling. Codes
+// are sent upstream as strings in MetricBatchResponse, so their values must
+// not change.
+type ErrorCode string
+
+// Common error codes. Every code must have an entry in errorCodeInfo.
+const (
+	ErrorCodeUnknown            ErrorCode = "ERR_UNKNOWN"
+	ErrorCodeInvalidInput       ErrorCode = "ERR_INVALID_INPUT"
+	ErrorCodeInvalidConfig      ErrorCode = "ERR_INVALID_CONFIG"
+	ErrorCodeProcessingFailed   ErrorCode = "ERR_PROCESSING_FAILED"
+	ErrorCodeForwardingFailed   ErrorCode = "ERR_FORWARDING_FAILED"
+	ErrorCodeCircuitBreakerOpen ErrorCode = "ERR_CIRCUIT_BREAKER_OPEN"
+	ErrorCodeDLQSendFailed      ErrorCode = "ERR_DLQ_SEND_FAILED"
+	ErrorCodePoisonBatch        ErrorCode = "ERR_POISON_BATCH"
+	ErrorCodePIILeak            ErrorCode = "ERR_PII_LEAK"
+	ErrorCodeThrottled          ErrorCode = "ERR_THROTTLED"
+	ErrorCodeServiceUnavailable ErrorCode = "ERR_SERVICE_UNAVAILABLE"
+	ErrorCodeTimeout            ErrorCode = "ERR_TIMEOUT"
+	ErrorCodeMetadataTooLarge   ErrorCode = "ERR_METADATA_TOO_LARGE"
+	ErrorCodeDuplicateBatch     ErrorCode = "ERR_DUPLICATE_BATCH"
+)
+
+// ErrorCodeInfo describes how an error code should be handled
+type ErrorCodeInfo struct {
+	// Retriable is true if sending the same batch again may succeed
+	Retriable bool
+
+	// GRPCCode is the gRPC status code the error maps to
+	GRPCCode codes.Code
+
+	// Description explains what the code means
+	Description string
+}
+
+// errorCodeInfo is the authoritative list of error codes
+var errorCodeInfo = map[ErrorCode]ErrorCodeInfo{
+	ErrorCodeUnknown: {
+		Retriable:   false,
+		GRPCCode:    codes.Unknown,
+		Description: "The cause of the failure is unknown",
+	},
+	ErrorCodeInvalidInput: {
+		Retriable:   false,
+		GRPCCode:    codes.InvalidArgument,
+		Description: "The batch could not be decoded or failed schema validation",
+	},
+	ErrorCodeInvalidConfig: {
+		Retriable:   false,
+		GRPCCode:    codes.FailedPrecondition,
+		Description: "The function block configuration is invalid",
+	},
+	ErrorCodeProcessingFailed: {
+		Retriable:   false,
+		GRPCCode:    codes.Internal,
+		Description: "The function block failed to process the batch",
+	},
+	ErrorCodeForwardingFailed: {
+		Retriable:   true,
+		GRPCCode:    codes.Unavailable,
+		Description: "The batch could not be forwarded to the next function block",
+	},
+	ErrorCodeCircuitBreakerOpen: {
+		Retriable:   true,
+		GRPCCode:    codes.Unavailable,
+		Description: "The circuit breaker to the next function block is open",
+	},
+	ErrorCodeDLQSendFailed: {
+		Retriable:   true,
+		GRPCCode:    codes.Unavailable,
+		Description: "The batch could not be sent to the dead letter queue",
+	},
+	ErrorCodePoisonBatch: {
+		Retriable:   false,
+		GRPCCode:    codes.InvalidArgument,
+		Description: "The batch failed repeatedly and will not be retried",
+	},
+	ErrorCodePIILeak: {
+		Retriable:   false,
+		GRPCCode:    codes.InvalidArgument,
+		Description: "The batch contains fields configured as PII",
+	},
+	ErrorCodeThrottled: {
+		Retriable:   true,
+		GRPCCode:    codes.ResourceExhausted,
+		Description: "The sender exceeded its rate limit",
+	},
+	ErrorCodeServiceUnavailable: {
+		Retriable:   true,
+		GRPCCode:    codes.Unavailable,
+		Description: "The function block is not accepting batches yet or is shutting down",
+	},
+	ErrorCodeTimeout: {
+		Retriable:   true,
+		GRPCCode:    codes.DeadlineExceeded,
+		Description: "Processing the batch timed out",
+	},
+	ErrorCodeMetadataTooLarge: {
+		Retriable:   false,
+		GRPCCode:    codes.InvalidArgument,
+		Description: "The batch metadata or internal labels exceed the configured limits",
+	},
+	ErrorCodeDuplicateBatch: {
+		Retriable:   false,
+		GRPCCode:    codes.AlreadyExists,
+		Description: "A batch with the same id and data was already received",
+	},
+}
+
+// ErrorCodes returns every defined error code
+func ErrorCodes() []ErrorCode {
+	return []ErrorCode{
+		ErrorCodeUnknown,
+		ErrorCodeInvalidInput,
+		ErrorCodeInvalidConfig,
+		ErrorCodeProcessingFailed,
+		ErrorCodeForwardingFailed,
+		ErrorCodeCircuitBreakerOpen,
+		ErrorCodeDLQSendFailed,
+		ErrorCodePoisonBatch,
+		ErrorCodePIILeak,
+		ErrorCodeThrottled,
+		ErrorCodeServiceUnavailable,
+		ErrorCodeTimeout,
+		ErrorCodeMetadataTooLarge,
+		ErrorCodeDuplicateBatch,
+	}
+}
+
+// Info returns the code's metadata. Undefined codes, e.g. from a newer
+// function block, are treated as ErrorCodeUnknown.
+func (c ErrorCode) Info() ErrorCodeInfo {
+	if info, ok := errorCodeInfo[c]; ok {
+		return info
+	}
+	return errorCodeInfo[ErrorCodeUnknown]
+}
+
+// Retriable returns whether a batch failing with this code may be retried
+func (c ErrorCode) Retriable() bool {
+	return c.Info().Retriable
+}
+
+// GRPCCode returns the gRPC status code for this error code
+func (c ErrorCode) GRPCCode() codes.Code {
+	return c.Info().GRPCCode
+}
+
+// GRPCError returns err as a gRPC status error with the code's status
+func (c ErrorCode) GRPCError(err error) error {
+	return status.Error(c.GRPCCode(), err.Error())
+}
+
+// NextFBError is returned when a next FB responds to a batch with a failure
+// status, carrying the error code it responded with
+type NextFBError struct {
+	// Addr is the address of the next FB, if it's one of several
+	Addr string
+
+	Code    ErrorCode
+	Message string
+}
+
+// NewNextFBError returns the error of a failure response from a next FB
+func NewNextFBError(addr string, res *MetricBatchResponse) *NextFBError {
+	return &NextFBError{Addr: addr, Code: ErrorCode(res.ErrorCode), Message: res.ErrorMessage}
+}
+
+// Error implements error
+func (e *NextFBError) Error() string {
+	if e.Addr != "" {
+		return fmt.Sprintf("next FB %s returned error: %s (code: %s)", e.Addr, e.Message, e.Code)
+	}
+	return fmt.Sprintf("next FB returned error: %s (code: %s)", e.Message, e.Code)
+}
+
+// Terminal reports whether the next FB failed the batch for good. Only
+// known non-retriable codes are terminal: a response without a code, or with
+// one this block doesn't know, is treated as a transient forwarding failure,
+// as all failures were before codes were inspected.
+func (e *NextFBError) Terminal() bool {
+	if e.Code == ErrorCodeUnknown {
+		return false
+	}
+	info, ok := errorCodeInfo[e.Code]
+	return ok && !info.Retriable
+}
+
+// Rejected implements resilience.Rejection. A next FB failing a batch with
+// a terminal code is healthy; sending the batch again wouldn't help, so it
+// doesn't count toward opening the circuit breaker.
+func (e *NextFBError) Rejected() bool {
+	return e.Terminal()
+}
+
+// ForwardingErrorCode returns the error code of a batch that couldn't be
+// forwarded: the next FB's own code if it failed the batch with a terminal
+// one, ErrorCodeForwardingFailed otherwise. Function blocks
+// pass it to SendFailedBatch, so that batches the next FB rejected go to the
+// quarantine rather than the DLQ, and report it upstream so they aren't
+// retried.
+func ForwardingErrorCode(err error) ErrorCode {
+	var nextFBErr *NextFBError
+	if errors.As(err, &nextFBErr) && nextFBErr.Terminal() {
+		return nextFBErr.Code
+	}
+	return ErrorCodeForwardingFailed
+}
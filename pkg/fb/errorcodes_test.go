@@ -0,0 +1,65 @@
+package fb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorCodes_HaveCompleteMetadata(t *testing.T) {
+	assert.Len(t, ErrorCodes(), len(errorCodeInfo), "ErrorCodes and errorCodeInfo list different codes")
+
+	for _, code := range ErrorCodes() {
+		info, ok := errorCodeInfo[code]
+		if !assert.True(t, ok, "%s has no metadata", code) {
+			continue
+		}
+		assert.NotEmpty(t, info.Description, "%s has no description", code)
+		assert.NotEqual(t, codes.OK, info.GRPCCode, "%s maps to gRPC OK", code)
+	}
+}
+
+func TestErrorCode_UndefinedIsUnknown(t *testing.T) {
+	code := ErrorCode("ERR_FROM_THE_FUTURE")
+	assert.Equal(t, ErrorCodeUnknown.Info(), code.Info())
+	assert.False(t, code.Retriable())
+}
+
+func TestErrorCode_GRPCError(t *testing.T) {
+	err := ErrorCodeThrottled.GRPCError(errors.New("slow down"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, "slow down", status.Convert(err).Message())
+}
+
+func TestNewErrorResult_DerivesRetriable(t *testing.T) {
+	err := errors.New("boom")
+
+	assert.True(t, NewCodeErrorResult("b", ErrorCodeForwardingFailed, err).Retriable)
+	assert.False(t, NewCodeErrorResult("b", ErrorCodeInvalidInput, err).Retriable)
+
+	// Batches parked in the DLQ are not retried by the sender
+	result := NewErrorResult("b", ErrorCodeForwardingFailed, err, true)
+	assert.False(t, result.Retriable)
+	assert.True(t, result.SentToDLQ)
+}
+
+func TestForwardingErrorCode(t *testing.T) {
+	terminal := NewNextFBError("", &MetricBatchResponse{Status: StatusError, ErrorCode: string(ErrorCodePIILeak), ErrorMessage: "leak"})
+	assert.Equal(t, "next FB returned error: leak (code: ERR_PII_LEAK)", terminal.Error())
+	assert.True(t, terminal.Rejected())
+
+	// The code survives the wrapping of the forwarding path
+	assert.Equal(t, ErrorCodePIILeak, ForwardingErrorCode(fmt.Errorf("forwarding: %w", terminal)))
+
+	// Retriable codes, and responses without a known code, fail the forwarding
+	for _, code := range []string{string(ErrorCodeThrottled), "", string(ErrorCodeUnknown), "ERR_FROM_THE_FUTURE"} {
+		err := NewNextFBError("fb-next:5000", &MetricBatchResponse{Status: StatusError, ErrorCode: code})
+		assert.False(t, err.Rejected(), code)
+		assert.Equal(t, ErrorCodeForwardingFailed, ForwardingErrorCode(err), code)
+	}
+	assert.Equal(t, ErrorCodeForwardingFailed, ForwardingErrorCode(errors.New("connection refused")))
+}
@@ -0,0 +1,327 @@
+package fb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"eidc-tfk8s/internal/common/resilience"
+	"eidc-tfk8s/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Fanout modes, from FBConfig.NextFBMode
+const (
+	// FanoutModeAll copies each batch to every next FB
+	FanoutModeAll = "all"
+
+	// FanoutModeWeighted forwards each batch to one next FB picked by weight
+	FanoutModeWeighted = "weighted"
+)
+
+// LabelFanoutTarget is the internal label naming the next FB a batch sent
+// to the DLQ by a fanout failed to reach
+const LabelFanoutTarget = "fanout_target"
+
+// Outcomes of forwarding a batch to a fanout target
+const (
+	fanoutOutcomeForwarded = "forwarded"
+	fanoutOutcomeDLQ       = "dlq"
+	fanoutOutcomeFailed    = "failed"
+)
+
+var (
+	fanoutBatchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_fanout_batches_total",
+		Help: "Total number of batches forwarded to each fanout target, by outcome (forwarded, dlq, failed)",
+	}, []string{"fb_name", "target", "outcome"})
+
+	fanoutLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fb_fanout_forward_duration_seconds",
+		Help:    "Time taken to forward a batch to each fanout target",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"fb_name", "target"})
+)
+
+// fanoutTarget is a connection to one of the next FBs of a fanout, behind
+// its own circuit breaker
+type fanoutTarget struct {
+	addr    string
+	weight  int
+	conn    *grpc.ClientConn
+	client  ChainPushServiceClient
+	breaker *resilience.CircuitBreaker
+}
+
+// fanout is a set of next FBs, from FBConfig.NextFBs
+type fanout struct {
+	mode        string
+	targets     []*fanoutTarget
+	totalWeight int
+
+	// config is what the fanout was created from
+	config []config.NextFBTarget
+}
+
+// newFanout creates a fanout over targets, without connections
+func newFanout(fbName string, common config.FBConfig) *fanout {
+	f := &fanout{mode: common.NextFBMode, config: common.NextFBs}
+	if f.mode == "" {
+		f.mode = FanoutModeAll
+	}
+
+	breakerConfig := common.NextFBCircuitBreakerConfig()
+	for _, t := range common.NextFBs {
+		f.targets = append(f.targets, &fanoutTarget{
+			addr:    t.Address,
+			weight:  t.Weight,
+			breaker: resilience.NewCircuitBreaker(fbName, t.Address, breakerConfig),
+		})
+		f.totalWeight += t.Weight
+	}
+	return f
+}
+
+// same returns whether the fanout was created from the same targets and
+// mode as common's
+func (f *fanout) same(common config.FBConfig) bool {
+	mode := common.NextFBMode
+	if mode == "" {
+		mode = FanoutModeAll
+	}
+	if f.mode != mode || len(f.config) != len(common.NextFBs) {
+		return false
+	}
+	for i, t := range common.NextFBs {
+		if f.config[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+// connected returns whether every target has a connection
+func (f *fanout) connected() bool {
+	for _, t := range f.targets {
+		if t.client == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// close closes the connections to the targets not taken over by next,
+// which may be nil
+func (f *fanout) close(next *fanout) {
+	kept := make(map[*fanoutTarget]bool)
+	if next != nil {
+		for _, t := range next.targets {
+			kept[t] = true
+		}
+	}
+	for _, t := range f.targets {
+		if t.conn != nil && !kept[t] {
+			t.conn.Close()
+		}
+	}
+}
+
+// ConnectNextFBs connects the function block to the next FBs batches fan
+// out to, replacing any previous ones. Without NextFBs in the config it
+// disconnects them, and the block forwards to its single next FB again.
+// Unchanged targets are left connected. Targets that fail to connect fail
+// the batches forwarded to them until a later call connects them; the error
+// returned is that of the first.
+func (b *BaseFunctionBlock) ConnectNextFBs(ctx context.Context, common config.FBConfig, opts ...grpc.DialOption) error {
+	if len(common.NextFBs) == 0 {
+		b.CloseNextFBs()
+		return nil
+	}
+	current := b.fanout.Load()
+	unchanged := current != nil && current.same(common)
+	if unchanged && current.connected() {
+		return nil
+	}
+
+	next := newFanout(b.name, common)
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+	}, opts...)
+
+	var firstErr error
+	for i, t := range next.targets {
+		// Keep the connections of unchanged targets, with their breakers,
+		// and dial only those that failed to connect
+		if unchanged && current.targets[i].client != nil {
+			next.targets[i] = current.targets[i]
+			continue
+		}
+
+		conn, err := b.DialContext(ctx, t.addr, opts...)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to connect to next FB %s: %w", t.addr, err)
+			}
+			continue
+		}
+		t.conn = conn
+		t.client = NewChainPushServiceClient(conn)
+	}
+
+	if previous := b.fanout.Swap(next); previous != nil {
+		previous.close(next)
+	}
+	return firstErr
+}
+
+// CloseNextFBs closes the connections to the next FBs batches fan out to,
+// if any
+func (b *BaseFunctionBlock) CloseNextFBs() {
+	if previous := b.fanout.Swap(nil); previous != nil {
+		previous.close(nil)
+	}
+}
+
+// SetNextFBClientsForTesting fans out to the next FBs of common, with the
+// given clients by address, for testing purposes
+func (b *BaseFunctionBlock) SetNextFBClientsForTesting(common config.FBConfig, clients map[string]ChainPushServiceClient) {
+	next := newFanout(b.name, common)
+	for _, t := range next.targets {
+		t.client = clients[t.addr]
+	}
+	b.fanout.Store(next)
+}
+
+// FansOut returns whether the block forwards to several next FBs, connected
+// with ConnectNextFBs, rather than to a single one
+func (b *BaseFunctionBlock) FansOut() bool {
+	return b.fanout.Load() != nil
+}
+
+// ForwardToNextFBs forwards a batch to the next FBs connected with
+// ConnectNextFBs. In weighted mode the batch goes to one target, picked by
+// its id so that retries go to the same one, and a failure fails the batch
+// like a failure of a single next FB. In all mode the batch is copied to
+// every target at once: a target that fails gets its copy sent to the DLQ
+// with sendToDLQ without holding up the others, and the batch fails only if
+// a copy can't be sent to the DLQ either. Retrying it then forwards it again
+// to the targets that succeeded.
+func (b *BaseFunctionBlock) ForwardToNextFBs(ctx context.Context, batch *MetricBatch, sendToDLQ DLQFunc) (*ProcessResult, error) {
+	f := b.fanout.Load()
+	if f == nil {
+		err := fmt.Errorf("no next FBs connected")
+		return NewCodeErrorResult(batch.BatchID, ErrorCodeForwardingFailed, err), err
+	}
+
+	if f.mode == FanoutModeWeighted {
+		t := f.pick(batch.BatchID)
+		if err := b.forwardToTarget(ctx, t, batch); err != nil {
+			fanoutBatchesTotal.WithLabelValues(b.name, t.addr, fanoutOutcomeFailed).Inc()
+			if errors.Is(err, resilience.ErrCircuitOpen) {
+				return NewCodeErrorResult(batch.BatchID, ErrorCodeCircuitBreakerOpen, err), err
+			}
+			return NewCodeErrorResult(batch.BatchID, ErrorCodeForwardingFailed, err), err
+		}
+		fanoutBatchesTotal.WithLabelValues(b.name, t.addr, fanoutOutcomeForwarded).Inc()
+		return NewSuccessResult(batch.BatchID), nil
+	}
+
+	errs := make([]error, len(f.targets))
+	var wg sync.WaitGroup
+	for i, t := range f.targets {
+		wg.Add(1)
+		go func(i int, t *fanoutTarget) {
+			defer wg.Done()
+			errs[i] = b.copyToTarget(ctx, t, batch, sendToDLQ)
+		}(i, t)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return NewCodeErrorResult(batch.BatchID, ErrorCodeForwardingFailed, err), err
+		}
+	}
+	return NewSuccessResult(batch.BatchID), nil
+}
+
+// copyToTarget forwards a copy of a batch to a target of an all mode
+// fanout, sending the copy out of the chain with SendFailedBatch if that
+// fails. It returns an error only if the copy reached neither.
+func (b *BaseFunctionBlock) copyToTarget(ctx context.Context, t *fanoutTarget, batch *MetricBatch, sendToDLQ DLQFunc) error {
+	// The DLQ sender adds its error labels to the copy, not the batch the
+	// other targets are reading
+	labels := make(map[string]string, len(batch.InternalLabels)+1)
+	for k, v := range batch.InternalLabels {
+		labels[k] = v
+	}
+	copied := *batch
+	copied.InternalLabels = labels
+
+	err := b.forwardToTarget(ctx, t, &copied)
+	if err == nil {
+		fanoutBatchesTotal.WithLabelValues(b.name, t.addr, fanoutOutcomeForwarded).Inc()
+		return nil
+	}
+
+	labels[LabelFanoutTarget] = t.addr
+	if dlqErr := b.SendFailedBatch(ctx, &copied, ForwardingErrorCode(err), err, sendToDLQ); dlqErr != nil {
+		fanoutBatchesTotal.WithLabelValues(b.name, t.addr, fanoutOutcomeFailed).Inc()
+		return err
+	}
+	fanoutBatchesTotal.WithLabelValues(b.name, t.addr, fanoutOutcomeDLQ).Inc()
+	return nil
+}
+
+// forwardToTarget pushes a batch to a target through its circuit breaker
+func (b *BaseFunctionBlock) forwardToTarget(ctx context.Context, t *fanoutTarget, batch *MetricBatch) error {
+	start := time.Now()
+	defer func() {
+		fanoutLatency.WithLabelValues(b.name, t.addr).Observe(time.Since(start).Seconds())
+	}()
+
+	return t.breaker.Execute(ctx, func(ctx context.Context) error {
+		if t.client == nil {
+			return fmt.Errorf("no connection to next FB: %s", t.addr)
+		}
+
+		res, err := t.client.PushMetrics(ctx, &MetricBatchRequest{
+			BatchId:          batch.BatchID,
+			Data:             batch.Data,
+			Format:           batch.Format,
+			Replay:           batch.Replay,
+			ConfigGeneration: batch.ConfigGeneration,
+			Metadata:         batch.Metadata,
+			InternalLabels:   batch.InternalLabels,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to next FB %s: %w", t.addr, err)
+		}
+		if res.Status != StatusSuccess {
+			return NewNextFBError(t.addr, res)
+		}
+		return nil
+	})
+}
+
+// pick returns the target of a weighted fanout a batch goes to
+func (f *fanout) pick(batchID string) *fanoutTarget {
+	h := fnv.New32a()
+	h.Write([]byte(batchID))
+	n := int(h.Sum32() % uint32(f.totalWeight))
+
+	for _, t := range f.targets {
+		if n < t.weight {
+			return t
+		}
+		n -= t.weight
+	}
+	return f.targets[len(f.targets)-1]
+}
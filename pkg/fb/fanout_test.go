@@ -0,0 +1,184 @@
+package fb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eidc-tfk8s/internal/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// recordingClient records the batches pushed to it, failing them if fail is set
+type recordingClient struct {
+	mu      sync.Mutex
+	fail    bool
+	batches []*MetricBatchRequest
+}
+
+func (c *recordingClient) client() ChainPushServiceClient {
+	return &MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *MetricBatchRequest, opts ...grpc.CallOption) (*MetricBatchResponse, error) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.fail {
+				return nil, errors.New("unavailable")
+			}
+			c.batches = append(c.batches, in)
+			return &MetricBatchResponse{Status: StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	}
+}
+
+func TestForwardToNextFBs_AllMode(t *testing.T) {
+	block := NewBaseFunctionBlock("fb-fanout-all-test")
+	storage, alerting := &recordingClient{}, &recordingClient{}
+	block.SetNextFBClientsForTesting(config.FBConfig{
+		NextFBs: []config.NextFBTarget{{Address: "storage:5000"}, {Address: "alerting:5000"}},
+	}, map[string]ChainPushServiceClient{
+		"storage:5000":  storage.client(),
+		"alerting:5000": alerting.client(),
+	})
+	require.True(t, block.FansOut())
+
+	var dlqBatches []*MetricBatch
+	var dlqMu sync.Mutex
+	sendToDLQ := func(ctx context.Context, batch *MetricBatch, err error) error {
+		dlqMu.Lock()
+		defer dlqMu.Unlock()
+		batch.InternalLabels["error"] = err.Error()
+		dlqBatches = append(dlqBatches, batch)
+		return nil
+	}
+
+	// Every target gets a copy
+	batch := &MetricBatch{BatchID: "batch-1", Data: []byte(`[]`), InternalLabels: map[string]string{"tenant_id": "acme"}}
+	result, err := block.ForwardToNextFBs(context.Background(), batch, sendToDLQ)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSuccess, result.Status)
+	assert.Len(t, storage.batches, 1)
+	assert.Len(t, alerting.batches, 1)
+
+	// A failing target gets its copy sent to the DLQ without blocking the other
+	alerting.fail = true
+	batch = &MetricBatch{BatchID: "batch-2", Data: []byte(`[]`), InternalLabels: map[string]string{"tenant_id": "acme"}}
+	result, err = block.ForwardToNextFBs(context.Background(), batch, sendToDLQ)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSuccess, result.Status)
+	require.Len(t, storage.batches, 2)
+	assert.NotContains(t, storage.batches[1].InternalLabels, "error")
+	require.Len(t, dlqBatches, 1)
+	assert.Equal(t, "batch-2", dlqBatches[0].BatchID)
+	assert.Equal(t, "alerting:5000", dlqBatches[0].InternalLabels[LabelFanoutTarget])
+	assert.Equal(t, "acme", dlqBatches[0].InternalLabels["tenant_id"])
+	assert.NotContains(t, batch.InternalLabels, "error")
+	assert.Equal(t, 1.0, testutil.ToFloat64(fanoutBatchesTotal.WithLabelValues("fb-fanout-all-test", "alerting:5000", fanoutOutcomeDLQ)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(fanoutBatchesTotal.WithLabelValues("fb-fanout-all-test", "storage:5000", fanoutOutcomeForwarded)))
+
+	// Without a DLQ the batch fails, for the sender to retry
+	result, err = block.ForwardToNextFBs(context.Background(), batch, func(ctx context.Context, batch *MetricBatch, err error) error {
+		return ErrNoDLQ
+	})
+	require.Error(t, err)
+	assert.Equal(t, ErrorCodeForwardingFailed, result.ErrorCode)
+	assert.Len(t, storage.batches, 3)
+
+	block.CloseNextFBs()
+	assert.False(t, block.FansOut())
+}
+
+func TestForwardToNextFBs_WeightedMode(t *testing.T) {
+	block := NewBaseFunctionBlock("fb-fanout-weighted-test")
+	heavy, light := &recordingClient{}, &recordingClient{}
+	common := config.FBConfig{
+		NextFBMode: FanoutModeWeighted,
+		NextFBs: []config.NextFBTarget{
+			{Address: "heavy:5000", Weight: 3},
+			{Address: "light:5000", Weight: 1},
+		},
+	}
+	block.SetNextFBClientsForTesting(common, map[string]ChainPushServiceClient{
+		"heavy:5000": heavy.client(),
+		"light:5000": light.client(),
+	})
+
+	noDLQ := func(ctx context.Context, batch *MetricBatch, err error) error {
+		t.Fatal("weighted mode failures are left to the caller")
+		return nil
+	}
+
+	// Each batch goes to exactly one target, in proportion to the weights
+	const batches = 2000
+	for i := 0; i < batches; i++ {
+		_, err := block.ForwardToNextFBs(context.Background(), &MetricBatch{BatchID: fmt.Sprintf("batch-%d", i)}, noDLQ)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, batches, len(heavy.batches)+len(light.batches))
+	assert.InDelta(t, 0.75, float64(len(heavy.batches))/batches, 0.05)
+
+	// Retries of a batch go to the same target
+	heavyBefore := len(heavy.batches)
+	for i := 0; i < 2; i++ {
+		_, err := block.ForwardToNextFBs(context.Background(), &MetricBatch{BatchID: "batch-retried"}, noDLQ)
+		require.NoError(t, err)
+	}
+	assert.Contains(t, []int{0, 2}, len(heavy.batches)-heavyBefore)
+
+	// A failure of the picked target fails the batch
+	heavy.fail, light.fail = true, true
+	result, err := block.ForwardToNextFBs(context.Background(), &MetricBatch{BatchID: "batch-failed"}, noDLQ)
+	require.Error(t, err)
+	assert.Equal(t, ErrorCodeForwardingFailed, result.ErrorCode)
+}
+
+func TestConnectNextFBs_RedialsUnconnectedTargets(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	go server.Serve(lis)
+	defer server.Stop()
+
+	var down atomic.Bool
+	down.Store(true)
+	dialer := grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		if addr == "alerting:5000" && down.Load() {
+			return nil, errors.New("connection refused")
+		}
+		return lis.DialContext(ctx)
+	})
+	block := NewBaseFunctionBlock("fb-fanout-redial-test")
+	defer block.CloseNextFBs()
+	common := config.FBConfig{
+		NextFBs: []config.NextFBTarget{{Address: "storage:5000"}, {Address: "alerting:5000"}},
+	}
+	connect := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		return block.ConnectNextFBs(ctx, common, dialer)
+	}
+
+	// The target that fails to connect is left without a client
+	require.Error(t, connect())
+	first := block.fanout.Load()
+	require.NotNil(t, first.targets[0].client)
+	require.Nil(t, first.targets[1].client)
+
+	// The same config connects it once it is up, keeping the other connection
+	down.Store(false)
+	require.NoError(t, connect())
+	second := block.fanout.Load()
+	assert.Same(t, first.targets[0], second.targets[0])
+	assert.NotNil(t, second.targets[1].client)
+
+	// Once every target is connected the same config changes nothing
+	require.NoError(t, connect())
+	assert.Same(t, second, block.fanout.Load())
+}
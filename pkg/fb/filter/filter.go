@@ -0,0 +1,470 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/common/metrics"
+	"eidc-tfk8s/internal/common/tracing"
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reasons a metric is dropped by the filter
+const (
+	// ReasonDenied is a metric whose name is on the deny list
+	ReasonDenied = "denied"
+
+	// ReasonNotAllowed is a metric whose name isn't on a non-empty allow list
+	ReasonNotAllowed = "not_allowed"
+)
+
+var (
+	metricsDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_filter_dropped_total",
+		Help: "Total number of metrics dropped by the allow and deny lists, by reason",
+	}, []string{"reason"})
+
+	emptyBatchesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_filter_empty_batches_total",
+		Help: "Total number of batches not forwarded because every metric was dropped",
+	})
+)
+
+// NameList matches metric names exactly or by regular expression
+type NameList struct {
+	// Names are matched exactly
+	Names []string `json:"names,omitempty"`
+
+	// Patterns are regular expressions matched against the whole name
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+// FilterConfig contains configuration for the Filter function block
+type FilterConfig struct {
+	// Common configuration
+	Common config.FBConfig `json:"common"`
+
+	// Allow lists the metric names to keep; empty keeps every name not denied
+	Allow NameList `json:"allow"`
+
+	// Deny lists the metric names to drop. Deny takes precedence over allow.
+	Deny NameList `json:"deny"`
+}
+
+// nameMatcher is a compiled NameList
+type nameMatcher struct {
+	names    map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+// newNameMatcher compiles a name list. Patterns are anchored, as relabel
+// rule regexes are.
+func newNameMatcher(list NameList) (*nameMatcher, error) {
+	m := &nameMatcher{names: make(map[string]struct{}, len(list.Names))}
+	for _, name := range list.Names {
+		m.names[name] = struct{}{}
+	}
+	for _, pattern := range list.Patterns {
+		regex, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		m.patterns = append(m.patterns, regex)
+	}
+	return m, nil
+}
+
+// empty reports whether the list matches no names
+func (m *nameMatcher) empty() bool {
+	return len(m.names) == 0 && len(m.patterns) == 0
+}
+
+// match reports whether name is on the list
+func (m *nameMatcher) match(name string) bool {
+	if _, ok := m.names[name]; ok {
+		return true
+	}
+	for _, regex := range m.patterns {
+		if regex.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// lists are the compiled allow and deny lists
+type lists struct {
+	allow *nameMatcher
+	deny  *nameMatcher
+}
+
+// newLists compiles the allow and deny lists of a config
+func newLists(config *FilterConfig) (*lists, error) {
+	allow, err := newNameMatcher(config.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow list: %w", err)
+	}
+	deny, err := newNameMatcher(config.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny list: %w", err)
+	}
+	return &lists{allow: allow, deny: deny}, nil
+}
+
+// dropReason returns why a metric name is dropped, or "" if it is kept
+func (l *lists) dropReason(name string) string {
+	if l.deny.match(name) {
+		return ReasonDenied
+	}
+	if !l.allow.empty() && !l.allow.match(name) {
+		return ReasonNotAllowed
+	}
+	return ""
+}
+
+// Filter implements the FB-Filter function block, which drops metrics by
+// name before they reach the costlier stages of the chain. Its connections
+// and lifecycle are the BaseFunctionBlock's.
+type Filter struct {
+	fb.BaseFunctionBlock
+	logger   *logging.Logger
+	metrics  *metrics.FBMetrics
+	tracer   *tracing.Tracer
+	config   *FilterConfig
+	lists    *lists
+	configMu sync.RWMutex
+}
+
+// NewFilter creates a new Filter function block
+func NewFilter() *Filter {
+	return &Filter{
+		BaseFunctionBlock: fb.NewBaseFunctionBlock("fb-filter"),
+		logger:            logging.NewLogger("fb-filter"),
+		metrics:           metrics.NewFBMetrics("fb-filter"),
+		tracer:            tracing.NewTracer("fb-filter"),
+	}
+}
+
+// Initialize initializes the Filter function block
+func (f *Filter) Initialize(ctx context.Context) error {
+	f.logger.Info("Initializing FB-Filter", nil)
+
+	return f.InitializeBlock()
+}
+
+// ProcessBatch drops the metrics the lists filter out and forwards the rest.
+// Batches left empty are acknowledged without being forwarded.
+func (f *Filter) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	// Create child span for the batch processing
+	ctx, span := f.tracer.StartSpan(ctx, "process-batch")
+	defer span.End()
+
+	// Record metric
+	f.metrics.RecordBatchReceived()
+	f.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeReceived)
+
+	// Reject batches until config has been applied, and while shutting down
+	done, result, err := f.BeginBatch(batch.BatchID)
+	if err != nil {
+		return result, err
+	}
+	defer done()
+
+	// Send batches whose data was corrupted in transit to the DLQ
+	if result, err := f.VerifyChecksum(ctx, batch, f.sendToDLQ); err != nil {
+		return result, err
+	}
+
+	// Forward unchanged if this FB is disabled in the pipeline config
+	if !f.Enabled() {
+		return f.BypassBatch(ctx, batch, f.forwardToNextFB)
+	}
+
+	// Capture the batch as received if the debug tap samples it
+	f.CaptureBatch(ctx, fb.DebugStageIn, batch)
+
+	startTime := time.Now()
+
+	// Process batch
+	kept, processingErr := f.processBatch(ctx, batch)
+	if processingErr != nil {
+		f.metrics.RecordProcessingError()
+
+		// Batches this FB can't decode won't succeed on retry
+		if errors.Is(processingErr, codec.ErrUnknownFormat) {
+			if dlqErr := f.SendFailedBatch(ctx, batch, fb.ErrorCodeInvalidInput, processingErr, f.sendToDLQ); dlqErr != nil {
+				if errors.Is(dlqErr, fb.ErrNoDLQ) {
+					return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeInvalidInput, processingErr), processingErr
+				}
+				f.logger.Error("Failed to send undecodable batch out of the chain", dlqErr, map[string]interface{}{
+					"batch_id": batch.BatchID,
+					"format":   batch.Format,
+				})
+				return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
+			}
+			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeInvalidInput, processingErr, true), processingErr
+		}
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeProcessingFailed, processingErr), processingErr
+	}
+
+	// Record processing metrics
+	f.metrics.RecordBatchProcessed(time.Since(startTime).Seconds())
+
+	// Nothing is left to forward
+	if kept == 0 {
+		emptyBatchesTotal.Inc()
+		f.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeDropped)
+		f.logger.Debug("Dropped empty batch", map[string]interface{}{
+			"batch_id": batch.BatchID,
+		})
+		return fb.NewSuccessResult(batch.BatchID), nil
+	}
+
+	// Capture the batch as forwarded if the debug tap samples it
+	f.CaptureBatch(ctx, fb.DebugStageOut, batch)
+
+	// Forward to next FB
+	forwardingResult, forwardingErr := f.forwardToNextFB(ctx, batch)
+	if forwardingErr != nil {
+		// Batches the next FB rejected for good go to the quarantine, if
+		// any, and are reported upstream with its code so they aren't retried
+		code := fb.ForwardingErrorCode(forwardingErr)
+		dlqErr := f.SendFailedBatch(ctx, batch, code, forwardingErr, f.sendToDLQ)
+		if dlqErr != nil {
+			if errors.Is(dlqErr, fb.ErrNoDLQ) {
+				return fb.NewCodeErrorResult(batch.BatchID, code, forwardingErr), forwardingErr
+			}
+			f.logger.ErrorCtx(ctx, "Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
+				"batch_id": batch.BatchID,
+			})
+			return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
+		}
+
+		// Return error with DLQ status
+		return fb.NewErrorResult(batch.BatchID, code, forwardingErr, true), forwardingErr
+	}
+
+	return forwardingResult, nil
+}
+
+// processBatch drops the metrics the lists filter out of the batch and
+// returns how many are left. Batches are left untouched when nothing is
+// dropped, and metrics without a name are kept.
+func (f *Filter) processBatch(ctx context.Context, batch *fb.MetricBatch) (int, error) {
+	// Create child span for filtering
+	ctx, span := f.tracer.StartSpan(ctx, "filter")
+	defer span.End()
+
+	f.configMu.RLock()
+	compiled := f.lists
+	f.configMu.RUnlock()
+
+	// Deserialize the batch data
+	input, err := codec.Decode(batch)
+	if err != nil {
+		return 0, err
+	}
+
+	if compiled == nil || (compiled.allow.empty() && compiled.deny.empty()) {
+		return len(input), nil
+	}
+
+	output := make([]codec.Metric, 0, len(input))
+	dropped := make(map[string]int)
+	for _, metric := range input {
+		if name, ok := metric["name"].(string); ok && name != "" {
+			if reason := compiled.dropReason(name); reason != "" {
+				dropped[reason]++
+				continue
+			}
+		}
+		output = append(output, metric)
+	}
+
+	if len(dropped) == 0 {
+		return len(output), nil
+	}
+
+	for reason, count := range dropped {
+		metricsDroppedTotal.WithLabelValues(reason).Add(float64(count))
+	}
+	f.logger.Debug("Dropped metrics", map[string]interface{}{
+		"batch_id": batch.BatchID,
+		"count":    len(input) - len(output),
+	})
+
+	if len(output) == 0 {
+		return 0, nil
+	}
+	return len(output), codec.Encode(batch, output)
+}
+
+// forwardToNextFB forwards the batch to the next function block
+func (f *Filter) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	return f.ForwardToNextFB(ctx, batch, f.sendToDLQ)
+}
+
+// sendToDLQ sends a batch to the Dead Letter Queue
+func (f *Filter) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr error) error {
+	err := f.SendToDLQ(ctx, batch, originalErr)
+	if errors.Is(err, fb.ErrPoisonBatch) {
+		f.logger.Warn("Dropping poison batch", map[string]interface{}{
+			"batch_id":     batch.BatchID,
+			"replay_count": batch.InternalLabels[fb.LabelReplayCount],
+			"error":        originalErr.Error(),
+		})
+	}
+	return err
+}
+
+// UpdateConfig updates the Filter function block's configuration
+func (f *Filter) UpdateConfig(ctx context.Context, configBytes []byte, generation int64) error {
+	// Create child span for config update
+	ctx, span := f.tracer.StartSpan(ctx, "update-config")
+	defer span.End()
+
+	// Parse and validate configuration
+	newConfig, err := f.parseConfig(configBytes)
+	if err != nil {
+		return err
+	}
+
+	// Refuse a generation older than the one applied, unless rolling back
+	if err := f.CheckConfigGeneration(generation, newConfig.Common.Rollback); err != nil {
+		f.logger.Error("Refused configuration", err, map[string]interface{}{
+			"generation":         generation,
+			"applied_generation": f.GetConfigGeneration(),
+		})
+		return err
+	}
+
+	// Compile the lists; validateConfig has already checked them
+	compiled, err := newLists(newConfig)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	// Apply configuration
+	f.configMu.Lock()
+	oldConfig := f.config
+	f.config = newConfig
+	f.lists = compiled
+	f.ApplyCommonConfig(newConfig.Common, generation)
+	if level, err := logging.ParseLevel(newConfig.Common.LogLevel); err == nil {
+		f.logger.SetLevel(level)
+	}
+	if newConfig.Common.TracingEnabled {
+		f.tracer.SetSamplingRatio(newConfig.Common.TraceSamplingRatio)
+	}
+	seed, _ := newConfig.Common.DeterministicSeed()
+	f.tracer.SetSeed(seed)
+	f.configMu.Unlock()
+
+	// Connect to the next FB and DLQ. Don't fail the config update on a
+	// connection error - batches fail until the next update connects them.
+	if err := f.ConnectChain(ctx, newConfig.Common, metrics.GRPCDialOption(f.Name())); err != nil {
+		f.logger.Error("Failed to connect to the chain", err, map[string]interface{}{
+			"next_fb":  newConfig.Common.NextFB,
+			"next_fbs": len(newConfig.Common.NextFBs),
+			"dlq":      newConfig.Common.DLQ,
+		})
+	}
+
+	if err := f.ConfigureDebugTap(ctx, newConfig.Common.DebugTap, codec.Decode); err != nil {
+		f.logger.Error("Failed to configure debug tap", err, nil)
+		// Don't fail config update on a debug tap error - batches just aren't captured
+	}
+
+	// Start processing now that config is applied
+	if err := f.ConfigApplied(); err != nil {
+		return err
+	}
+
+	// Update metrics
+	f.metrics.SetConfigGeneration(generation)
+	f.metrics.SetReady(true)
+
+	// Report what the update changed, for rollout audits
+	changes, _ := config.DiffConfig(oldConfig, newConfig)
+
+	f.logger.Info("Config updated", map[string]interface{}{
+		"generation": generation,
+		"allow":      len(newConfig.Allow.Names) + len(newConfig.Allow.Patterns),
+		"deny":       len(newConfig.Deny.Names) + len(newConfig.Deny.Patterns),
+		"changes":    changes,
+	})
+
+	return nil
+}
+
+// ValidateConfig parses and validates a configuration without applying it
+func (f *Filter) ValidateConfig(configBytes []byte) error {
+	_, err := f.parseConfig(configBytes)
+	return err
+}
+
+// parseConfig parses and validates a configuration
+func (f *Filter) parseConfig(configBytes []byte) (*FilterConfig, error) {
+	// Merge a patch onto the applied config
+	f.configMu.RLock()
+	applied := f.config
+	f.configMu.RUnlock()
+	configBytes, err := config.ResolveMergePatch(configBytes, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	var newConfig FilterConfig
+	if err := config.LoadConfigFromBytes(configBytes, &newConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if err := f.validateConfig(&newConfig); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &newConfig, nil
+}
+
+// validateConfig validates the Filter function block's configuration
+func (f *Filter) validateConfig(config *FilterConfig) error {
+	// Validate common settings
+	if err := config.Common.Validate(); err != nil {
+		return err
+	}
+
+	// Check if next FB is configured
+	if !config.Common.HasNextFB() {
+		return fmt.Errorf("next FB not configured")
+	}
+
+	// Validate the allow and deny lists
+	if _, err := newLists(config); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Shutdown shuts down the Filter function block
+func (f *Filter) Shutdown(ctx context.Context) error {
+	f.logger.Info("Shutting down FB-Filter", nil)
+
+	// Let batches in flight finish before closing the connections
+	err := f.ShutdownBlock(ctx)
+	if errors.Is(err, fb.ErrShutdownTimeout) {
+		f.logger.Warn("Shut down with batches still in flight", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	return err
+}
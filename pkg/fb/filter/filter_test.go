@@ -0,0 +1,198 @@
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// newTestFilter creates a running filter with the given lists, forwarding
+// into forwarded
+func newTestFilter(t *testing.T, allow, deny NameList, forwarded *[]*fb.MetricBatchRequest) *Filter {
+	f := NewFilter()
+	require.NoError(t, f.Initialize(context.Background()))
+
+	f.config = &FilterConfig{Allow: allow, Deny: deny}
+	compiled, err := newLists(f.config)
+	require.NoError(t, err)
+	f.lists = compiled
+	require.NoError(t, f.ConfigApplied())
+
+	f.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			*forwarded = append(*forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	return f
+}
+
+// forwardedNames returns the metric names of a forwarded batch
+func forwardedNames(t *testing.T, req *fb.MetricBatchRequest) []string {
+	var output []map[string]interface{}
+	require.NoError(t, json.Unmarshal(req.Data, &output))
+
+	names := make([]string, 0, len(output))
+	for _, metric := range output {
+		names = append(names, metric["name"].(string))
+	}
+	return names
+}
+
+const testBatchData = `[
+	{"name":"http_requests_total","value":1},
+	{"name":"http_request_duration_seconds","value":0.2},
+	{"name":"go_goroutines","value":12},
+	{"name":"node_cpu_seconds","value":1.5}
+]`
+
+func TestFilter_ProcessBatch_AllowOnly(t *testing.T) {
+	var forwarded []*fb.MetricBatchRequest
+	f := newTestFilter(t, NameList{Names: []string{"go_goroutines"}, Patterns: []string{"http_.*"}}, NameList{}, &forwarded)
+
+	notAllowed := testutil.ToFloat64(metricsDroppedTotal.WithLabelValues(ReasonNotAllowed))
+
+	result, err := f.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: "batch-1", Data: []byte(testBatchData), Format: "json"})
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+
+	require.Len(t, forwarded, 1)
+	assert.Equal(t, []string{"http_requests_total", "http_request_duration_seconds", "go_goroutines"}, forwardedNames(t, forwarded[0]))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metricsDroppedTotal.WithLabelValues(ReasonNotAllowed))-notAllowed)
+}
+
+func TestFilter_ProcessBatch_DenyOnly(t *testing.T) {
+	var forwarded []*fb.MetricBatchRequest
+	f := newTestFilter(t, NameList{}, NameList{Names: []string{"node_cpu_seconds"}, Patterns: []string{"go_.*"}}, &forwarded)
+
+	denied := testutil.ToFloat64(metricsDroppedTotal.WithLabelValues(ReasonDenied))
+
+	_, err := f.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: "batch-1", Data: []byte(testBatchData), Format: "json"})
+	require.NoError(t, err)
+
+	require.Len(t, forwarded, 1)
+	assert.Equal(t, []string{"http_requests_total", "http_request_duration_seconds"}, forwardedNames(t, forwarded[0]))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metricsDroppedTotal.WithLabelValues(ReasonDenied))-denied)
+}
+
+func TestFilter_ProcessBatch_DenyTakesPrecedenceOverAllow(t *testing.T) {
+	var forwarded []*fb.MetricBatchRequest
+	f := newTestFilter(t,
+		NameList{Patterns: []string{"http_.*", "go_.*"}},
+		NameList{Names: []string{"http_request_duration_seconds"}},
+		&forwarded)
+
+	denied := testutil.ToFloat64(metricsDroppedTotal.WithLabelValues(ReasonDenied))
+	notAllowed := testutil.ToFloat64(metricsDroppedTotal.WithLabelValues(ReasonNotAllowed))
+
+	_, err := f.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: "batch-1", Data: []byte(testBatchData), Format: "json"})
+	require.NoError(t, err)
+
+	// Allowed by pattern but denied by name
+	require.Len(t, forwarded, 1)
+	assert.Equal(t, []string{"http_requests_total", "go_goroutines"}, forwardedNames(t, forwarded[0]))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metricsDroppedTotal.WithLabelValues(ReasonDenied))-denied)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metricsDroppedTotal.WithLabelValues(ReasonNotAllowed))-notAllowed)
+}
+
+func TestFilter_ProcessBatch_EmptyBatchIsNotForwarded(t *testing.T) {
+	var forwarded []*fb.MetricBatchRequest
+	f := newTestFilter(t, NameList{}, NameList{Patterns: []string{".*"}}, &forwarded)
+
+	emptyBatches := testutil.ToFloat64(emptyBatchesTotal)
+
+	result, err := f.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: "batch-1", Data: []byte(testBatchData), Format: "json"})
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+
+	assert.Empty(t, forwarded)
+	assert.Equal(t, float64(1), testutil.ToFloat64(emptyBatchesTotal)-emptyBatches)
+}
+
+func TestFilter_ValidateConfig(t *testing.T) {
+	f := NewFilter()
+
+	config := &FilterConfig{Deny: NameList{Patterns: []string{"("}}}
+	config.Common.NextFB = "fb-next:5000"
+
+	err := f.validateConfig(config)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid deny list")
+}
+
+func TestDefaultConfig_MatchesParametersSchema(t *testing.T) {
+	configBytes, err := json.Marshal(DefaultConfig())
+	require.NoError(t, err)
+	assert.NoError(t, config.ValidateParameters("fb-filter", configBytes))
+}
+
+func TestFilter_UpdateConfig_RefusesGenerationRegression(t *testing.T) {
+	var forwarded []*fb.MetricBatchRequest
+	f := newTestFilter(t, NameList{}, NameList{}, &forwarded)
+
+	update := func(generation int64, rollback bool) error {
+		filterConfig := DefaultConfig()
+		filterConfig.Common.NextFB = "fb-next:5000"
+		filterConfig.Common.Rollback = rollback
+		configBytes, err := json.Marshal(filterConfig)
+		require.NoError(t, err)
+		return f.UpdateConfig(context.Background(), configBytes, generation)
+	}
+
+	require.NoError(t, update(5, false))
+
+	// A stale push of a lower generation is refused
+	err := update(4, false)
+	assert.ErrorIs(t, err, fb.ErrConfigGenerationRegression)
+	assert.Equal(t, int64(5), f.GetConfigGeneration())
+
+	// The same generation may be applied again
+	require.NoError(t, update(5, false))
+
+	// A flagged rollback is applied
+	require.NoError(t, update(3, true))
+	assert.Equal(t, int64(3), f.GetConfigGeneration())
+}
+
+func TestFilter_UpdateConfig_YAMLMatchesJSON(t *testing.T) {
+	jsonConfig := []byte(`{
+		"common": {"next_fb": "fb-next:5000", "log_level": "debug"},
+		"allow": {"names": ["go_goroutines"], "patterns": ["http_.*"]},
+		"deny": {"names": ["http_request_duration_seconds"]}
+	}`)
+	yamlConfig := []byte(`
+common:
+  next_fb: fb-next:5000
+  log_level: debug
+allow:
+  names: [go_goroutines]
+  patterns:
+    - http_.*
+deny:
+  names:
+    - http_request_duration_seconds
+`)
+
+	applied := make([]*FilterConfig, 0, 2)
+	for _, configBytes := range [][]byte{jsonConfig, yamlConfig} {
+		var forwarded []*fb.MetricBatchRequest
+		f := newTestFilter(t, NameList{}, NameList{}, &forwarded)
+		require.NoError(t, f.UpdateConfig(context.Background(), configBytes, 1))
+		applied = append(applied, f.config)
+
+		// Both apply the same lists
+		_, err := f.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: "batch-1", Data: []byte(testBatchData), Format: "json"})
+		require.NoError(t, err)
+		require.Len(t, forwarded, 1)
+		assert.Equal(t, []string{"http_requests_total", "go_goroutines"}, forwardedNames(t, forwarded[0]))
+	}
+	assert.Equal(t, applied[0], applied[1])
+}
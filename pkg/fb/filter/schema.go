@@ -0,0 +1,60 @@
+package filter
+
+import (
+	"eidc-tfk8s/internal/config"
+)
+
+// parametersSchema is the JSON Schema of FilterConfig
+const parametersSchema = `{
+	"type": "object",
+	"properties": {
+		"allow": {
+			"type": "object",
+			"properties": {
+				"names": {
+					"type": "array",
+					"items": {"type": "string"}
+				},
+				"patterns": {
+					"type": "array",
+					"items": {"type": "string"}
+				}
+			}
+		},
+		"deny": {
+			"type": "object",
+			"properties": {
+				"names": {
+					"type": "array",
+					"items": {"type": "string"}
+				},
+				"patterns": {
+					"type": "array",
+					"items": {"type": "string"}
+				}
+			}
+		}
+	}
+}`
+
+func init() {
+	config.RegisterParametersSchema("fb-filter", parametersSchema)
+}
+
+// DefaultConfig returns the Filter configuration with empty lists, which
+// passes metrics through unchanged
+func DefaultConfig() FilterConfig {
+	return FilterConfig{
+		Common: config.FBConfig{
+			LogLevel:           "info",
+			MetricsEnabled:     true,
+			TracingEnabled:     true,
+			TraceSamplingRatio: 0.1,
+			CircuitBreaker: config.CircuitBreakerConfig{
+				ErrorThresholdPercentage: 50,
+				OpenStateSeconds:         30,
+				HalfOpenRequestThreshold: 5,
+			},
+		},
+	}
+}
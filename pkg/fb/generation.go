@@ -0,0 +1,32 @@
+package fb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var configGenerationRegressionTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_config_generation_regression_total",
+	Help: "Total number of configs refused because their generation was lower than the one applied",
+}, []string{"fb_name"})
+
+// ErrConfigGenerationRegression is returned for a config with a lower
+// generation than the one the function block applied, e.g. broadcast by a
+// controller with a rollback bug or in a split-brain
+var ErrConfigGenerationRegression = errors.New("config generation regression")
+
+// CheckConfigGeneration refuses a config generation lower than the one the
+// function block applied, counting the regression, unless the config is
+// flagged as a rollback (FBConfig.Rollback). The same generation may be
+// applied again.
+func (b *BaseFunctionBlock) CheckConfigGeneration(generation int64, rollback bool) error {
+	applied := b.GetConfigGeneration()
+	if generation >= applied || rollback {
+		return nil
+	}
+	configGenerationRegressionTotal.WithLabelValues(b.Name()).Inc()
+	return fmt.Errorf("%w: generation %d is lower than applied generation %d", ErrConfigGenerationRegression, generation, applied)
+}
@@ -0,0 +1,24 @@
+package fb
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfigGeneration(t *testing.T) {
+	b := NewBaseFunctionBlock("fb-generation-test")
+	b.SetConfigGeneration(5)
+	regressions := configGenerationRegressionTotal.WithLabelValues("fb-generation-test")
+
+	// Newer and re-applied generations pass
+	assert.NoError(t, b.CheckConfigGeneration(6, false))
+	assert.NoError(t, b.CheckConfigGeneration(5, false))
+
+	// A lower generation is refused and counted, unless it is a rollback
+	assert.ErrorIs(t, b.CheckConfigGeneration(4, false), ErrConfigGenerationRegression)
+	assert.Equal(t, float64(1), testutil.ToFloat64(regressions))
+	assert.NoError(t, b.CheckConfigGeneration(4, true))
+	assert.Equal(t, float64(1), testutil.ToFloat64(regressions))
+}
@@ -2,12 +2,41 @@ package fb
 
 import (
 	"context"
+	"encoding/json"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/status"
 )
 
+// chainCodecName is the content subtype ChainPushService messages are sent
+// with. MetricBatchRequest and MetricBatchResponse are plain structs rather
+// than generated protobuf messages, so they are marshalled as JSON.
+const chainCodecName = "json"
+
+// chainCodec marshals ChainPushService messages as JSON
+type chainCodec struct{}
+
+// Marshal implements encoding.Codec
+func (chainCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements encoding.Codec
+func (chainCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Name implements encoding.Codec
+func (chainCodec) Name() string {
+	return chainCodecName
+}
+
+func init() {
+	encoding.RegisterCodec(chainCodec{})
+}
+
 // ChainPushServiceServer is the server API for ChainPushService.
 type ChainPushServiceServer interface {
 	// PushMetrics processes a batch of metrics
@@ -64,6 +93,10 @@ func _ChainPushService_PushMetrics_Handler(srv interface{}, ctx context.Context,
 // ChainPushServiceHandler implements ChainPushServiceServer by delegating to a FunctionBlock
 type ChainPushServiceHandler struct {
 	fb FunctionBlock
+
+	// inFlight counts the batches being processed, capped by the block's
+	// MaxConcurrentBatches
+	inFlight semaphore
 }
 
 // NewChainPushServiceHandler creates a new ChainPushServiceHandler
@@ -73,6 +106,24 @@ func NewChainPushServiceHandler(fb FunctionBlock) *ChainPushServiceHandler {
 
 // PushMetrics implements ChainPushServiceServer.PushMetrics
 func (h *ChainPushServiceHandler) PushMetrics(ctx context.Context, req *MetricBatchRequest) (*MetricBatchResponse, error) {
+	// Bound the metadata maps before they are copied into the batch and
+	// forwarded down the chain
+	if err := h.limitMetadata(req); err != nil {
+		return &MetricBatchResponse{
+			Status:       StatusError,
+			ErrorMessage: err.Error(),
+			ErrorCode:    string(ErrorCodeMetadataTooLarge),
+			BatchId:      req.BatchId,
+		}, nil
+	}
+
+	// Reject batches over the concurrency limit before decoding them
+	done, err := h.admit(req.BatchId)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
 	// Convert request to MetricBatch
 	batch := &MetricBatch{
 		BatchID:          req.BatchId,
@@ -92,13 +143,16 @@ func (h *ChainPushServiceHandler) PushMetrics(ctx context.Context, req *MetricBa
 			Status:       result.Status,
 			ErrorMessage: result.ErrorMessage,
 			ErrorCode:    string(result.ErrorCode),
-			BatchId:      req.BatchId,
+			BatchId:      batch.BatchID,
+			RetryAfterMs: result.RetryAfter.Milliseconds(),
 		}, nil
 	}
 
 	// Return success response
+	// Reply with the batch id the function block settled on, e.g. one FB-RX
+	// generated for a batch sent without
 	return &MetricBatchResponse{
 		Status:  result.Status,
-		BatchId: req.BatchId,
+		BatchId: batch.BatchID,
 	}, nil
 }
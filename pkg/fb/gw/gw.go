@@ -3,6 +3,7 @@ package gw
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -13,12 +14,24 @@ import (
 	"eidc-tfk8s/internal/common/tracing"
 	"eidc-tfk8s/internal/config"
 	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.opentelemetry.io/otel/codes"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// pipelineE2ELatency tracks the time from RX ingest to export at the gateway
+var pipelineE2ELatency = promauto.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    "pipeline_e2e_latency_seconds",
+		Help:    "Time from when a batch was first received by FB-RX until it was exported by FB-GW",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	},
+)
+
 // GWConfig represents the configuration for the Gateway function block
 type GWConfig struct {
 	// Common configuration for all function blocks
@@ -35,6 +48,25 @@ type GWConfig struct {
 
 	// Whether to enable PII detection
 	EnablePiiDetection bool `json:"enable_pii_detection"`
+
+	// Detection mode of each PII field: enforce, report or off. It overrides
+	// the mode of a field in PiiFields, which are enforced when
+	// EnablePiiDetection is set. Fields in report mode are logged and counted
+	// but don't fail the batch, so detection can be rolled out per field.
+	PiiFieldModes map[string]PIIMode `json:"pii_field_modes,omitempty"`
+
+	// Skipping of batches that were already exported
+	Idempotency IdempotencyConfig `json:"idempotency"`
+
+	// Export to a Prometheus remote-write endpoint
+	RemoteWrite RemoteWriteConfig `json:"remote_write"`
+
+	// Labels exported with metrics and batches. With an allowlist only its
+	// labels are exported; without one, every label but the internal ones FBs
+	// attach for bookkeeping, such as fb_sender and error, is. Denylisted
+	// labels are never exported. DLQ copies keep every label.
+	ExportLabelAllowlist []string `json:"export_label_allowlist,omitempty"`
+	ExportLabelDenylist  []string `json:"export_label_denylist,omitempty"`
 }
 
 // GW is the Gateway function block for exporting metrics
@@ -49,8 +81,22 @@ type GW struct {
 	nextFBConn      *grpc.ClientConn
 	dlqClient       fb.ChainPushServiceClient
 	dlqConn         *grpc.ClientConn
-	circuitBreaker  *resilience.CircuitBreaker
+	nextFBBreaker   *resilience.CircuitBreaker
+	dlqBreaker      *resilience.CircuitBreaker
 	schemaValidator schema.SchemaValidator
+
+	// idempotencyStore records exported batches; nil when idempotency is disabled
+	idempotencyStore IdempotencyStore
+
+	// remoteWriter exports batches via Prometheus remote-write; nil when
+	// remote-write is disabled
+	remoteWriter *remoteWriter
+
+	// exportLabels strips the labels that aren't exported
+	exportLabels *exportLabelFilter
+
+	// piiDetector finds PII fields in the clear; nil when no field is checked
+	piiDetector *piiDetector
 }
 
 // NewGW creates a new Gateway function block
@@ -66,8 +112,10 @@ func NewGW() *GW {
 // Initialize initializes the Gateway function block
 func (g *GW) Initialize(ctx context.Context) error {
 	// Set the name and ready state
-	baseFB := fb.NewBaseFunctionBlock("fb-gw")
-	g.BaseFunctionBlock = baseFB
+	g.BaseFunctionBlock = fb.NewBaseFunctionBlock("fb-gw")
+	if err := g.Transition(fb.StateInitialized); err != nil {
+		return err
+	}
 	g.logger.Info("Initializing Gateway function block", map[string]interface{}{})
 	g.SetReady(false)
 	g.metrics.SetReady(0)
@@ -75,6 +123,10 @@ func (g *GW) Initialize(ctx context.Context) error {
 	// Initialize schema validator with default settings
 	g.schemaValidator = schema.NewDefaultValidator()
 
+	// Initialize circuit breakers with default config
+	g.nextFBBreaker = resilience.NewCircuitBreaker("fb-gw", resilience.DownstreamNextFB, resilience.DefaultCircuitBreakerConfig())
+	g.dlqBreaker = resilience.NewCircuitBreaker("fb-gw", resilience.DownstreamDLQ, resilience.DefaultCircuitBreakerConfig())
+
 	// Success
 	g.logger.Info("Gateway function block initialized", map[string]interface{}{})
 	g.SetReady(true)
@@ -91,6 +143,24 @@ func (g *GW) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.Proce
 	defer span.End()
 	
 	g.metrics.RecordBatchReceived()
+	g.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeReceived)
+	
+	// Reject batches until config has been applied, and while shutting down
+	done, result, err := g.BeginBatch(batch.BatchID)
+	if err != nil {
+		return result, err
+	}
+	defer done()
+	
+	// Send batches whose data was corrupted in transit to the DLQ
+	if result, err := g.VerifyChecksum(ctx, batch, g.sendInvalidToDLQ); err != nil {
+		return result, err
+	}
+	
+	// Forward unchanged if this FB is disabled in the pipeline config
+	if !g.Enabled() {
+		return g.BypassBatch(ctx, batch, g.forwardBatch)
+	}
 	
 	g.logger.Info("Processing batch", map[string]interface{}{
 		"batch_id": batch.BatchID,
@@ -98,29 +168,85 @@ func (g *GW) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.Proce
 		"replay":   batch.Replay,
 	})
 	
-	// Validate schema if enabled
-	if g.config.SchemaEnforce {
-		if err := g.validateSchema(ctx, batch); err != nil {
-			// Schema validation failed, send to DLQ
-			g.metrics.RecordBatchRejected()
-			g.tracer.SetStatus(ctx, codes.Error, "Schema validation failed")
-			
-			// Send to DLQ if possible
-			dlqResult, dlqErr := g.sendToDLQ(ctx, batch, fb.ErrorCodeInvalidInput, err)
-			
-			// Return error with info about DLQ
-			return fb.NewErrorResult(
-				batch.BatchID,
-				fb.ErrorCodeInvalidInput,
-				err,
-				dlqResult != nil && dlqErr == nil,
-			), err
+	// Claim the batch, skipping it if it was already exported, e.g. a replay
+	// from the DLQ. The claim is released unless the batch is exported, so
+	// that it can be retried.
+	var exportSucceeded bool
+	if store := g.idempotencyStore; store != nil {
+		idempotencyKey := IdempotencyKey(batch)
+		claimed, err := store.Claim(idempotencyKey, g.config.Idempotency.ttl())
+		if err != nil {
+			g.logger.Warn("Failed to claim idempotency key, exporting batch", map[string]interface{}{
+				"batch_id": batch.BatchID,
+				"error":    err.Error(),
+			})
+		} else if !claimed {
+			idempotentSkipsTotal.Inc()
+			g.logger.Info("Skipping already exported batch", map[string]interface{}{
+				"batch_id": batch.BatchID,
+				"replay":   batch.Replay,
+			})
+			return fb.NewSuccessResult(batch.BatchID), nil
+		} else {
+			defer func() {
+				if exportSucceeded {
+					return
+				}
+				if err := store.Release(idempotencyKey); err != nil {
+					g.logger.Warn("Failed to release idempotency key", map[string]interface{}{
+						"batch_id": batch.BatchID,
+						"error":    err.Error(),
+					})
+				}
+			}()
+		}
+	}
+	
+	// Batches leave the chain as JSON, whatever format the FBs exchanged them
+	// in; then validate schema if enabled, check for PII, and strip the
+	// labels that aren't exported from the copy that is
+	err = exportAsJSON(batch)
+	if err == nil && g.config.SchemaEnforce {
+		err = g.validateSchema(ctx, batch)
+	}
+	if err == nil {
+		err = g.checkPII(ctx, batch)
+	}
+	var exported *fb.MetricBatch
+	if err == nil {
+		exported, err = g.exportLabels.exportBatch(batch)
+	}
+	if err != nil {
+		// Conversion, schema validation or PII detection failed, quarantine
+		// the batch
+		g.metrics.RecordBatchRejected()
+		g.tracer.SetStatus(ctx, codes.Error, "Schema validation failed")
+		
+		// Send to the quarantine, or the DLQ without one, if possible
+		dlqErr := g.SendFailedBatch(ctx, batch, fb.ErrorCodeInvalidInput, err, g.sendInvalidToDLQ)
+		if errors.Is(dlqErr, fb.ErrPoisonBatch) {
+			return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
 		}
+		
+		// Return error with info about DLQ
+		return fb.NewErrorResult(
+			batch.BatchID,
+			fb.ErrorCodeInvalidInput,
+			err,
+			dlqErr == nil,
+		), err
 	}
 	
 	// Process the batch
 	g.metrics.RecordBatchProcessed(time.Since(startTime).Seconds())
 	
+	// Export to the remote-write endpoint (if configured)
+	if g.remoteWriter != nil {
+		if result, err := g.exportRemoteWrite(ctx, batch, exported); err != nil {
+			return result, err
+		}
+	}
+	
 	// Forward to next FB (if configured)
 	if g.config.Common.NextFB != "" {
 		// Start span for forwarding
@@ -128,18 +254,26 @@ func (g *GW) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.Proce
 		defer forwardSpan.End()
 		
 		forwardStartTime := time.Now()
-		result, err := g.forwardBatch(forwardCtx, batch)
+		result, err := g.pushToNextFB(forwardCtx, batch, exported, g.exportLabels)
 		g.metrics.RecordBatchForwarded(time.Since(forwardStartTime).Seconds())
 		
 		if err != nil {
+			g.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeFailed)
 			g.logger.Error("Failed to forward batch", err, map[string]interface{}{
 				"batch_id": batch.BatchID,
 			})
 			g.tracer.SetStatus(ctx, codes.Error, "Failed to forward batch")
 			return result, err
 		}
+		g.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeForwarded)
 	}
 	
+	// Keep the claim, so a replay isn't exported again
+	exportSucceeded = true
+	
+	// Record end-to-end pipeline latency
+	g.recordE2ELatency(batch)
+
 	// Success
 	g.logger.Info("Batch processed successfully", map[string]interface{}{
 		"batch_id": batch.BatchID,
@@ -149,6 +283,31 @@ func (g *GW) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.Proce
 	return fb.NewSuccessResult(batch.BatchID), nil
 }
 
+// recordE2ELatency observes the time since the batch was ingested by FB-RX.
+// Batches that entered the chain after RX have no ingest time and are skipped.
+func (g *GW) recordE2ELatency(batch *fb.MetricBatch) bool {
+	ingestTime, ok := fb.IngestTime(batch)
+	if !ok {
+		return false
+	}
+	pipelineE2ELatency.Observe(time.Since(ingestTime).Seconds())
+	return true
+}
+
+// exportAsJSON re-encodes a batch the FBs exchanged in internal protobuf as
+// JSON, the format the GW validates and exports
+func exportAsJSON(batch *fb.MetricBatch) error {
+	if batch.Format != codec.FormatInternalProtobuf {
+		return nil
+	}
+
+	metrics, err := codec.Decode(batch)
+	if err != nil {
+		return err
+	}
+	return codec.EncodeAs(batch, metrics, codec.FormatJSON)
+}
+
 // validateSchema validates the schema of a batch
 func (g *GW) validateSchema(ctx context.Context, batch *fb.MetricBatch) error {
 	ctx, span := g.tracer.StartSpan(ctx, "GW.ValidateSchema")
@@ -184,34 +343,45 @@ func (g *GW) validateSchema(ctx context.Context, batch *fb.MetricBatch) error {
 
 // forwardBatch forwards a batch to the next function block
 func (g *GW) forwardBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	return g.pushToNextFB(ctx, batch, batch, nil)
+}
+
+// pushToNextFB pushes the exported copy of a batch to the next function
+// block. The labels that labels doesn't export, if set, are stripped from
+// the request, the sender label included. Batches that fail go to the DLQ as
+// they were received.
+func (g *GW) pushToNextFB(ctx context.Context, batch, exported *fb.MetricBatch, labels *exportLabelFilter) (*fb.ProcessResult, error) {
 	ctx, span := g.tracer.StartSpan(ctx, "GW.ForwardBatch")
 	defer span.End()
 	
 	// Connect to next FB if not already connected
 	if g.nextFBClient == nil {
 		if err := g.connectToNextFB(ctx); err != nil {
-			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err, false), err
+			return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err), err
 		}
 	}
 	
 	// Use circuit breaker to protect against cascading failures
-	err := g.circuitBreaker.Execute(ctx, func(execCtx context.Context) error {
+	err := g.nextFBBreaker.Execute(ctx, func(execCtx context.Context) error {
 		// Create request
 		req := &fb.MetricBatchRequest{
-			BatchId:          batch.BatchID,
-			Data:             batch.Data,
-			Format:           batch.Format,
-			Replay:           batch.Replay,
-			ConfigGeneration: batch.ConfigGeneration,
-			Metadata:         batch.Metadata,
-			InternalLabels:   batch.InternalLabels,
+			BatchId:          exported.BatchID,
+			Data:             exported.Data,
+			Format:           exported.Format,
+			Replay:           exported.Replay,
+			ConfigGeneration: exported.ConfigGeneration,
+			Metadata:         exported.Metadata,
+			InternalLabels:   exported.InternalLabels,
 		}
 		
 		// Add sender label
 		if req.InternalLabels == nil {
 			req.InternalLabels = make(map[string]string)
 		}
-		req.InternalLabels["fb_sender"] = g.Name()
+		req.InternalLabels["fb_sender"] = g.Sender()
+		if labels != nil {
+			req.InternalLabels = labels.filterLabels(req.InternalLabels)
+		}
 		
 		// Forward to next FB
 		res, err := g.nextFBClient.PushMetrics(execCtx, req)
@@ -221,7 +391,7 @@ func (g *GW) forwardBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.Proce
 		
 		// Check response status
 		if res.Status != fb.StatusSuccess {
-			return fmt.Errorf("next FB returned error: %s - %s", res.ErrorCode, res.ErrorMessage)
+			return fb.NewNextFBError("", res)
 		}
 		
 		return nil
@@ -234,20 +404,25 @@ func (g *GW) forwardBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.Proce
 			g.logger.Warn("Circuit breaker is open", map[string]interface{}{
 				"batch_id": batch.BatchID,
 			})
-			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err, false), err
+			return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err), err
 		}
 		
-		// Other error, try to send to DLQ
+		// Other error, try to send to DLQ. Batches the next FB rejected for
+		// good keep its code, so they aren't retried.
 		g.logger.Error("Failed to forward batch", err, map[string]interface{}{
 			"batch_id": batch.BatchID,
 		})
 		
-		dlqResult, dlqErr := g.sendToDLQ(ctx, batch, fb.ErrorCodeForwardingFailed, err)
+		code := fb.ForwardingErrorCode(err)
+		dlqResult, dlqErr := g.sendToDLQ(ctx, batch, code, err)
+		if errors.Is(dlqErr, fb.ErrPoisonBatch) {
+			return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
+		}
 		
 		// Return error with info about DLQ
 		return fb.NewErrorResult(
 			batch.BatchID,
-			fb.ErrorCodeForwardingFailed,
+			code,
 			err,
 			dlqResult != nil && dlqErr == nil,
 		), err
@@ -263,7 +438,10 @@ func (g *GW) connectToNextFB(ctx context.Context) error {
 	})
 	
 	// Create connection
-	conn, err := grpc.Dial(g.config.Common.NextFB, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := g.DialContext(ctx, g.config.Common.NextFB,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		metrics.GRPCDialOption(g.Name()),
+	)
 	if err != nil {
 		return fmt.Errorf("failed to connect to next FB: %w", err)
 	}
@@ -272,16 +450,6 @@ func (g *GW) connectToNextFB(ctx context.Context) error {
 	g.nextFBConn = conn
 	g.nextFBClient = fb.NewChainPushServiceClient(conn)
 	
-	// Create circuit breaker
-	g.circuitBreaker = resilience.NewCircuitBreaker(
-		"next-fb",
-		resilience.CircuitBreakerConfig{
-			ErrorThresholdPercentage: g.config.Common.CircuitBreaker.ErrorThresholdPercentage,
-			OpenStateSeconds:         g.config.Common.CircuitBreaker.OpenStateSeconds,
-			HalfOpenRequestThreshold: g.config.Common.CircuitBreaker.HalfOpenRequestThreshold,
-		},
-	)
-	
 	return nil
 }
 
@@ -292,7 +460,7 @@ func (g *GW) connectToDLQ(ctx context.Context) error {
 	})
 	
 	// Create connection
-	conn, err := grpc.Dial(g.config.Common.DLQ, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := g.DialContext(ctx, g.config.Common.DLQ, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		return fmt.Errorf("failed to connect to DLQ: %w", err)
 	}
@@ -304,8 +472,21 @@ func (g *GW) connectToDLQ(ctx context.Context) error {
 	return nil
 }
 
+// sendInvalidToDLQ sends a batch failing with ErrorCodeInvalidInput to the
+// DLQ
+func (g *GW) sendInvalidToDLQ(ctx context.Context, batch *fb.MetricBatch, err error) error {
+	_, dlqErr := g.sendToDLQ(ctx, batch, fb.ErrorCodeInvalidInput, err)
+	return dlqErr
+}
+
 // sendToDLQ sends a batch to the DLQ
 func (g *GW) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, errorCode fb.ErrorCode, err error) (*fb.MetricBatchResponse, error) {
+	// Without a DLQ, e.g. when exporting directly, the batch is failed back
+	// to the caller
+	if !g.HasDLQ() {
+		return nil, fb.ErrNoDLQ
+	}
+	
 	// Connect to DLQ if not already connected
 	if g.dlqClient == nil {
 		if dlqErr := g.connectToDLQ(ctx); dlqErr != nil {
@@ -337,16 +518,34 @@ func (g *GW) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, errorCode fb.
 	if err != nil {
 		req.InternalLabels["error"] = err.Error()
 	}
-	req.InternalLabels["fb_sender"] = g.Name()
+	req.InternalLabels["fb_sender"] = g.Sender()
 	req.InternalLabels["dlq_timestamp"] = fmt.Sprintf("%d", time.Now().Unix())
 	
+	// Drop poison batches rather than cycle them through the DLQ forever
+	if g.RecordDLQHop(req.InternalLabels) {
+		g.logger.Warn("Dropping poison batch", map[string]interface{}{
+			"batch_id":     batch.BatchID,
+			"replay_count": req.InternalLabels[fb.LabelReplayCount],
+			"error_code":   errorCode,
+		})
+		return nil, fb.ErrPoisonBatch
+	}
+	
 	// Send to DLQ
-	res, err := g.dlqClient.PushMetrics(ctx, req)
-	if err != nil {
-		g.logger.Error("Failed to send batch to DLQ", err, map[string]interface{}{
+	var res *fb.MetricBatchResponse
+	dlqErr := g.dlqBreaker.Execute(ctx, func(execCtx context.Context) error {
+		var pushErr error
+		res, pushErr = g.dlqClient.PushMetrics(execCtx, req)
+		if pushErr == nil && res.Status != fb.StatusSuccess {
+			pushErr = fmt.Errorf("DLQ returned error: %s - %s", res.ErrorCode, res.ErrorMessage)
+		}
+		return pushErr
+	})
+	if dlqErr != nil && res == nil {
+		g.logger.Error("Failed to send batch to DLQ", dlqErr, map[string]interface{}{
 			"batch_id": batch.BatchID,
 		})
-		return nil, fmt.Errorf("failed to send batch to DLQ: %w", err)
+		return nil, fmt.Errorf("failed to send batch to DLQ: %w", dlqErr)
 	}
 	
 	// Check response status
@@ -361,6 +560,7 @@ func (g *GW) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, errorCode fb.
 	
 	// Success
 	g.metrics.RecordBatchDLQ()
+	g.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeDropped)
 	g.logger.Info("Batch sent to DLQ", map[string]interface{}{
 		"batch_id": batch.BatchID,
 	})
@@ -368,39 +568,99 @@ func (g *GW) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, errorCode fb.
 	return res, nil
 }
 
+// ValidateConfig parses and validates a configuration without applying it
+func (g *GW) ValidateConfig(configBytes []byte) error {
+	_, err := g.parseConfig(configBytes)
+	return err
+}
+
+// parseConfig parses and validates a configuration
+func (g *GW) parseConfig(configBytes []byte) (GWConfig, error) {
+	// Merge a patch onto the applied config
+	configBytes, err := config.ResolveMergePatch(configBytes, g.config)
+	if err != nil {
+		return GWConfig{}, err
+	}
+
+	var newConfig GWConfig
+	if err := config.LoadConfigFromBytes(configBytes, &newConfig); err != nil {
+		return GWConfig{}, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+
+	if newConfig.ExportEndpoint == "" {
+		return GWConfig{}, fmt.Errorf("export endpoint not configured")
+	}
+	if err := newConfig.Common.Validate(); err != nil {
+		return GWConfig{}, fmt.Errorf("invalid config: %w", err)
+	}
+	if err := newConfig.Idempotency.validate(); err != nil {
+		return GWConfig{}, fmt.Errorf("invalid config: %w", err)
+	}
+	if err := newConfig.RemoteWrite.validate(); err != nil {
+		return GWConfig{}, fmt.Errorf("invalid config: %w", err)
+	}
+	for field, mode := range newConfig.PiiFieldModes {
+		if field == "" {
+			return GWConfig{}, fmt.Errorf("invalid config: empty PII field")
+		}
+		if err := mode.validate(); err != nil {
+			return GWConfig{}, fmt.Errorf("invalid config: PII field %q: %w", field, err)
+		}
+	}
+
+	return newConfig, nil
+}
+
 // UpdateConfig updates the Gateway function block's configuration
 func (g *GW) UpdateConfig(ctx context.Context, configBytes []byte, generation int64) error {
 	g.logger.Info("Updating configuration", map[string]interface{}{
 		"generation": generation,
 	})
 	
-	// Parse config
-	var newConfig GWConfig
-	if err := config.LoadConfigFromBytes(configBytes, &newConfig); err != nil {
-		g.logger.Error("Failed to parse configuration", err, map[string]interface{}{
+	// Parse and validate config
+	newConfig, err := g.parseConfig(configBytes)
+	if err != nil {
+		g.logger.Error("Invalid configuration", err, map[string]interface{}{
 			"generation": generation,
 		})
-		return fmt.Errorf("failed to parse configuration: %w", err)
+		return err
 	}
-	
-	// Validate config
-	if newConfig.ExportEndpoint == "" {
-		return fmt.Errorf("export endpoint not configured")
+
+	// Refuse a generation older than the one applied, unless rolling back
+	if err := g.CheckConfigGeneration(generation, newConfig.Common.Rollback); err != nil {
+		g.logger.Error("Refused configuration", err, map[string]interface{}{
+			"generation":         generation,
+			"applied_generation": g.GetConfigGeneration(),
+		})
+		return err
 	}
 	
 	// Store config
 	oldConfig := g.config
 	g.config = newConfig
 	g.SetConfigGeneration(generation)
+	g.SetEnabled(newConfig.Common.IsEnabled())
+	g.SetMaxConcurrentBatches(newConfig.Common.MaxConcurrentBatches)
+	g.SetMaxReplayCount(newConfig.Common.MaxReplayCount)
+	g.SetVerifyChecksum(newConfig.Common.VerifyChecksum)
+	g.SetHasDLQ(newConfig.Common.DLQ != "")
+	g.SetMetadataLimits(fb.MetadataLimits{
+		MaxEntries: newConfig.Common.MaxMetadataEntries,
+		MaxBytes:   newConfig.Common.MaxMetadataBytes,
+		Action:     fb.MetadataLimitAction(newConfig.Common.MetadataLimitAction),
+	})
+	if level, err := logging.ParseLevel(newConfig.Common.LogLevel); err == nil {
+		g.logger.SetLevel(level)
+	}
+	if newConfig.Common.TracingEnabled {
+		g.tracer.SetSamplingRatio(newConfig.Common.TraceSamplingRatio)
+	}
+	seed, _ := newConfig.Common.DeterministicSeed()
+	g.tracer.SetSeed(seed)
 	g.metrics.SetConfigGeneration(generation)
 	
-	// Update schema validator if PII settings changed
-	if !slicesEqual(oldConfig.PiiFields, newConfig.PiiFields) || oldConfig.EnablePiiDetection != newConfig.EnablePiiDetection {
-		g.schemaValidator = schema.NewDefaultValidator()
-		if newConfig.EnablePiiDetection {
-			g.schemaValidator.SetPIIFields(newConfig.PiiFields)
-		}
-	}
+	// Update PII detection for the new fields and modes
+	g.piiDetector = newPIIDetector(newConfig)
 	
 	// Check if next FB changed
 	if oldConfig.Common.NextFB != newConfig.Common.NextFB && g.nextFBConn != nil {
@@ -418,8 +678,46 @@ func (g *GW) UpdateConfig(ctx context.Context, configBytes []byte, generation in
 		g.dlqClient = nil
 	}
 	
+	// Update circuit breaker configuration
+	g.nextFBBreaker = resilience.NewCircuitBreaker("fb-gw", resilience.DownstreamNextFB, newConfig.Common.NextFBCircuitBreakerConfig())
+	g.dlqBreaker = resilience.NewCircuitBreaker("fb-gw", resilience.DownstreamDLQ, newConfig.Common.DLQCircuitBreakerConfig())
+	
+	// Recreate the idempotency store if its backend changed. A TTL change
+	// applies to keys recorded from now on.
+	if g.idempotencyStore == nil || idempotencyStoreChanged(oldConfig.Idempotency, newConfig.Idempotency) {
+		if g.idempotencyStore != nil {
+			g.idempotencyStore.Close()
+			g.idempotencyStore = nil
+		}
+		if newConfig.Idempotency.Enabled {
+			g.idempotencyStore = newIdempotencyStore(newConfig.Idempotency)
+		}
+	}
+	
+	// Recreate the remote writer for the new endpoint, auth and limits
+	g.remoteWriter = nil
+	if newConfig.RemoteWrite.Enabled {
+		g.remoteWriter = newRemoteWriter(newConfig.RemoteWrite)
+	}
+	g.exportLabels = newExportLabelFilter(newConfig.ExportLabelAllowlist, newConfig.ExportLabelDenylist)
+	
+	if err := g.ConnectQuarantine(ctx, newConfig.Common.Quarantine); err != nil {
+		g.logger.Error("Failed to connect to quarantine", err, map[string]interface{}{
+			"quarantine": newConfig.Common.Quarantine,
+		})
+		// Don't fail config update on connection error - we'll retry on next update
+	}
+	
+	// Start processing now that config is applied
+	if err := g.ConfigApplied(); err != nil {
+		return err
+	}
+	
+	// Report what the update changed, for rollout audits
+	changes, _ := config.DiffConfig(oldConfig, newConfig)
 	g.logger.Info("Configuration updated", map[string]interface{}{
 		"generation": generation,
+		"changes":    changes,
 	})
 	
 	return nil
@@ -428,39 +726,45 @@ func (g *GW) UpdateConfig(ctx context.Context, configBytes []byte, generation in
 // Shutdown shuts down the Gateway function block
 func (g *GW) Shutdown(ctx context.Context) error {
 	g.logger.Info("Shutting down Gateway function block", map[string]interface{}{})
+	
+	// Stop accepting batches
+	if err := g.Transition(fb.StateDraining); err != nil {
+		return err
+	}
 	g.SetReady(false)
 	g.metrics.SetReady(0)
-	
-	// Close connections
-	if g.nextFBConn != nil {
-		g.nextFBConn.Close()
+
+	// Let batches in flight finish, including their DLQ sends
+	drainErr := g.DrainBatches(ctx)
+	if drainErr != nil {
+		g.logger.Warn("Shutting down with batches still in flight", map[string]interface{}{
+			"error": drainErr.Error(),
+		})
 	}
-	
-	if g.dlqConn != nil {
-		g.dlqConn.Close()
+
+	if g.idempotencyStore != nil {
+		g.idempotencyStore.Close()
 	}
-	
+
+	// Close connections, the DLQ and quarantine last
 	if g.exportClient != nil {
 		g.exportClient.Close()
 	}
-	
-	g.logger.Info("Gateway function block shut down", map[string]interface{}{})
-	return nil
-}
 
-// slicesEqual checks if two string slices are equal
-func slicesEqual(a, b []string) bool {
-	if len(a) != len(b) {
-		return false
+	if g.nextFBConn != nil {
+		g.nextFBConn.Close()
 	}
-	
-	for i, v := range a {
-		if v != b[i] {
-			return false
-		}
+
+	if g.dlqConn != nil {
+		g.dlqConn.Close()
 	}
-	
-	return true
+	g.CloseQuarantine()
+
+	g.logger.Info("Gateway function block shut down", map[string]interface{}{})
+	if err := g.Transition(fb.StateStopped); err != nil {
+		return err
+	}
+	return drainErr
 }
 
 // For testing
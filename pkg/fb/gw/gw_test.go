@@ -1,17 +1,39 @@
 package gw
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"eidc-tfk8s/internal/common/resilience"
 	"eidc-tfk8s/internal/common/schema"
 	"eidc-tfk8s/internal/config"
 	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"eidc-tfk8s/pkg/fb/rx"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 // MockSchemaValidator is a mock schema validator for testing
@@ -332,3 +354,846 @@ func TestGW_Shutdown(t *testing.T) {
 	assert.False(t, g.Ready())
 }
 
+
+// histogramSamples returns the sample count and sum of a histogram
+func histogramSamples(t *testing.T, histogram prometheus.Histogram) (uint64, float64) {
+	var metric dto.Metric
+	require.NoError(t, histogram.Write(&metric))
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func TestGW_RecordE2ELatency(t *testing.T) {
+	g := NewGW()
+	err := g.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, testutil.CollectAndCount(pipelineE2ELatency, "pipeline_e2e_latency_seconds"))
+	count, sum := histogramSamples(t, pipelineE2ELatency)
+
+	// Batch stamped by RX is observed
+	stamped := &fb.MetricBatch{BatchID: "stamped-batch"}
+	fb.StampIngestTime(stamped, time.Now().Add(-2*time.Second))
+	ingestTime, ok := fb.IngestTime(stamped)
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(-2*time.Second), ingestTime, time.Second)
+	assert.True(t, g.recordE2ELatency(stamped))
+
+	observedCount, observedSum := histogramSamples(t, pipelineE2ELatency)
+	assert.Equal(t, count+1, observedCount)
+	assert.InDelta(t, 2, observedSum-sum, 1)
+
+	// Batch that entered mid-chain has no ingest time and is skipped
+	unstamped := &fb.MetricBatch{
+		BatchID:        "unstamped-batch",
+		InternalLabels: map[string]string{"fb_sender": "fb-cl"},
+	}
+	assert.False(t, g.recordE2ELatency(unstamped))
+
+	// Malformed ingest time is skipped
+	malformed := &fb.MetricBatch{
+		BatchID:        "malformed-batch",
+		InternalLabels: map[string]string{fb.LabelIngestTimestamp: "not-a-timestamp"},
+	}
+	assert.False(t, g.recordE2ELatency(malformed))
+
+	skippedCount, skippedSum := histogramSamples(t, pipelineE2ELatency)
+	assert.Equal(t, observedCount, skippedCount)
+	assert.Equal(t, observedSum, skippedSum)
+}
+
+func TestGW_TenantIDSurvivesRXToGW(t *testing.T) {
+	// RX forwards to a client that captures the request
+	r := rx.NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	var forwarded *fb.MetricBatchRequest
+	mockNextFB := new(MockDLQClient)
+	mockNextFB.On("PushMetrics", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		forwarded = args.Get(1).(*fb.MetricBatchRequest)
+	}).Return(&fb.MetricBatchResponse{Status: fb.StatusSuccess}, nil)
+	r.SetNextFBClientForTesting(mockNextFB)
+	r.SetDLQClientForTesting(new(MockDLQClient))
+
+	rxConfig, err := json.Marshal(rx.RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-gw:5000",
+			DLQ:    "fb-dlq:5000",
+		},
+		Endpoints: []rx.Endpoint{
+			{Protocol: "otlp/grpc", Port: 4317, Enabled: true},
+		},
+	})
+	assert.NoError(t, err)
+
+	// Don't wait for the unreachable next FB and DLQs; the mocks replace them
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, r.UpdateConfig(ctx, rxConfig, 1))
+
+	_, err = r.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID:  "tenant-batch",
+		Data:     []byte(`{"resource_metrics":[]}`),
+		Format:   "otlp",
+		Metadata: map[string]string{fb.LabelTenantID: "acme"},
+	})
+	assert.NoError(t, err)
+	if !assert.NotNil(t, forwarded) {
+		return
+	}
+	assert.Equal(t, "acme", forwarded.InternalLabels[fb.LabelTenantID])
+
+	// GW receives the forwarded request, fails validation and sends it to the DLQ
+	g := NewGW()
+	err = g.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	mockValidator := new(MockSchemaValidator)
+	g.schemaValidator = mockValidator
+	mockValidator.On("Validate", mock.Anything).Return(&schema.ValidationResult{
+		Valid: false,
+		Error: errors.New("invalid batch"),
+	})
+
+	var dlqEntry *fb.MetricBatchRequest
+	mockDLQClient := new(MockDLQClient)
+	mockDLQClient.On("PushMetrics", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		dlqEntry = args.Get(1).(*fb.MetricBatchRequest)
+	}).Return(&fb.MetricBatchResponse{Status: fb.StatusSuccess}, nil)
+	g.dlqClient = mockDLQClient
+
+	gwConfig, err := json.Marshal(GWConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+			DLQ:    "fb-dlq:5000",
+		},
+		SchemaEnforce:  true,
+		ExportEndpoint: "https://metrics-api.example.com",
+	})
+	assert.NoError(t, err)
+	g.UpdateConfig(ctx, gwConfig, 1)
+
+	_, err = fb.NewChainPushServiceHandler(g).PushMetrics(context.Background(), forwarded)
+	assert.NoError(t, err)
+
+	if !assert.NotNil(t, dlqEntry) {
+		return
+	}
+	assert.Equal(t, "acme", dlqEntry.InternalLabels[fb.LabelTenantID])
+	assert.Equal(t, "fb-gw", dlqEntry.InternalLabels["fb_sender"])
+}
+
+func TestGW_ReplayedBatchIsNotReExported(t *testing.T) {
+	g := NewGW()
+	err := g.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	configBytes, err := json.Marshal(GWConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+			DLQ:    "fb-dlq:5000",
+		},
+		ExportEndpoint: "https://metrics-api.example.com",
+		Idempotency: IdempotencyConfig{
+			Enabled: true,
+			Store:   IdempotencyStoreMemory,
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, g.UpdateConfig(context.Background(), configBytes, 1))
+
+	var exported []string
+	failExport := false
+	g.nextFBClient = &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			if failExport {
+				return nil, errors.New("backend unavailable")
+			}
+			exported = append(exported, in.BatchId)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	}
+	g.nextFBBreaker = resilience.NewCircuitBreaker("fb-gw", resilience.DownstreamNextFB, resilience.DefaultCircuitBreakerConfig())
+
+	batch := &fb.MetricBatch{
+		BatchID: "batch-1",
+		Data:    []byte(`[{"name":"cpu","value":1}]`),
+		Format:  "json",
+	}
+	result, err := g.ProcessBatch(context.Background(), batch)
+	assert.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+
+	// The DLQ replays the same batch
+	replay := *batch
+	replay.Replay = true
+	result, err = g.ProcessBatch(context.Background(), &replay)
+	assert.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+	assert.Equal(t, []string{"batch-1"}, exported)
+
+	// A reused batch id with different data is still exported
+	_, err = g.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID: "batch-1",
+		Data:    []byte(`[{"name":"cpu","value":2}]`),
+		Format:  "json",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"batch-1", "batch-1"}, exported)
+
+	// A batch that failed to export isn't skipped when retried
+	failed := &fb.MetricBatch{
+		BatchID: "batch-2",
+		Data:    []byte(`[{"name":"cpu","value":3}]`),
+		Format:  "json",
+	}
+	failExport = true
+	_, err = g.ProcessBatch(context.Background(), failed)
+	assert.Error(t, err)
+	failExport = false
+	_, err = g.ProcessBatch(context.Background(), failed)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"batch-1", "batch-1", "batch-2"}, exported)
+}
+
+func TestGW_ExportStripsInternalLabels(t *testing.T) {
+	g := NewGW()
+	require.NoError(t, g.Initialize(context.Background()))
+
+	configBytes, err := json.Marshal(GWConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+			DLQ:    "fb-dlq:5000",
+		},
+		ExportEndpoint:      "https://metrics-api.example.com",
+		ExportLabelDenylist: []string{"debug_id"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, g.UpdateConfig(context.Background(), configBytes, 1))
+
+	var forwarded []*fb.MetricBatchRequest
+	failForward := false
+	g.nextFBClient = &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			if failForward {
+				return nil, errors.New("backend unavailable")
+			}
+			forwarded = append(forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	}
+	var dlqEntries []*fb.MetricBatchRequest
+	g.dlqClient = &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqEntries = append(dlqEntries, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	}
+
+	data := `[{"name":"cpu","value":1,"labels":{"host":"web-1","fb_sender":"fb-cl","debug_id":"d-1"}}]`
+	newBatch := func() *fb.MetricBatch {
+		return &fb.MetricBatch{
+			BatchID: "batch-1",
+			Data:    []byte(data),
+			Format:  "json",
+			InternalLabels: map[string]string{
+				fb.LabelTenantID:        "acme",
+				fb.LabelIngestTimestamp: "1700000000000000000",
+				"error":                 "retried",
+			},
+		}
+	}
+
+	// Internal and denylisted labels don't reach the backend
+	_, err = g.ProcessBatch(context.Background(), newBatch())
+	require.NoError(t, err)
+	require.Len(t, forwarded, 1)
+	assert.JSONEq(t, `[{"name":"cpu","value":1,"labels":{"host":"web-1"}}]`, string(forwarded[0].Data))
+	assert.Equal(t, map[string]string{fb.LabelTenantID: "acme"}, forwarded[0].InternalLabels)
+
+	// The DLQ copy of a batch that fails to export keeps them
+	failForward = true
+	_, err = g.ProcessBatch(context.Background(), newBatch())
+	require.Error(t, err)
+	require.Len(t, dlqEntries, 1)
+	assert.JSONEq(t, data, string(dlqEntries[0].Data))
+	assert.Equal(t, "acme", dlqEntries[0].InternalLabels[fb.LabelTenantID])
+	assert.Equal(t, "1700000000000000000", dlqEntries[0].InternalLabels[fb.LabelIngestTimestamp])
+	assert.Equal(t, "fb-gw", dlqEntries[0].InternalLabels["fb_sender"])
+}
+
+func TestExportLabelFilter_Allowlist(t *testing.T) {
+	f := newExportLabelFilter([]string{"host", "fb_sender"}, []string{"host"})
+
+	// Allowlisted internal labels are exported, but the denylist wins
+	assert.True(t, f.exported("fb_sender"))
+	assert.False(t, f.exported("host"))
+	assert.False(t, f.exported("region"))
+	assert.False(t, f.exported("error"))
+}
+
+func TestGW_PIIFieldModes(t *testing.T) {
+	g := NewGW()
+	require.NoError(t, g.Initialize(context.Background()))
+
+	configBytes, err := json.Marshal(GWConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+			DLQ:    "fb-dlq:5000",
+		},
+		ExportEndpoint:     "https://metrics-api.example.com",
+		PiiFields:          []string{"ssn", "phone"},
+		EnablePiiDetection: true,
+		PiiFieldModes: map[string]PIIMode{
+			"email": PIIModeReport,
+			"phone": PIIModeOff,
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, g.UpdateConfig(context.Background(), configBytes, 1))
+
+	var forwarded []*fb.MetricBatchRequest
+	g.nextFBClient = &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			forwarded = append(forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	}
+	var dlqEntries []*fb.MetricBatchRequest
+	g.dlqClient = &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqEntries = append(dlqEntries, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	}
+
+	reported := piiViolationsTotal.WithLabelValues("email", string(PIIModeReport))
+	enforced := piiViolationsTotal.WithLabelValues("ssn", string(PIIModeEnforce))
+	reportedBefore := testutil.ToFloat64(reported)
+	enforcedBefore := testutil.ToFloat64(enforced)
+
+	// A field in report mode is counted, but the batch is exported; fields
+	// that are off aren't checked
+	result, err := g.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID: "batch-report",
+		Data:    []byte(`[{"name":"logins","value":1,"labels":{"email":"jane@example.com","phone":"555-0100"}}]`),
+		Format:  "json",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+	assert.Len(t, forwarded, 1)
+	assert.Equal(t, reportedBefore+1, testutil.ToFloat64(reported))
+	assert.Empty(t, dlqEntries)
+
+	// A field in enforce mode fails the batch, unless it's hashed
+	result, err = g.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID: "batch-enforce",
+		Data:    []byte(`[{"name":"logins","value":1,"labels":{"ssn":"123-45-6789"}}]`),
+		Format:  "json",
+	})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, schema.ErrPIIDetected)
+	assert.Equal(t, fb.ErrorCodeInvalidInput, result.ErrorCode)
+	assert.Len(t, forwarded, 1)
+	assert.Len(t, dlqEntries, 1)
+	assert.Equal(t, enforcedBefore+1, testutil.ToFloat64(enforced))
+
+	hashed := sha256.Sum256([]byte("123-45-6789"))
+	_, err = g.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID: "batch-hashed",
+		Data:    []byte(`[{"name":"logins","value":1,"labels":{"ssn":"` + hex.EncodeToString(hashed[:]) + `"}}]`),
+		Format:  "json",
+	})
+	require.NoError(t, err)
+	assert.Len(t, forwarded, 2)
+}
+
+func TestParseConfig_RejectsUnknownPIIMode(t *testing.T) {
+	g := NewGW()
+	_, err := g.parseConfig([]byte(`{"export_endpoint":"https://metrics-api.example.com","pii_field_modes":{"email":"audit"}}`))
+	assert.Error(t, err)
+}
+
+func TestExportAsJSON(t *testing.T) {
+	metrics := []codec.Metric{{"name": "cpu_usage", "value": json.Number("0.75"), "labels": map[string]interface{}{"host": "node-1"}}}
+	batch := &fb.MetricBatch{BatchID: "batch-1"}
+	require.NoError(t, codec.EncodeAs(batch, metrics, codec.FormatInternalProtobuf))
+
+	// Batches in the internal protobuf format are exported as JSON
+	require.NoError(t, exportAsJSON(batch))
+	assert.Equal(t, codec.FormatJSON, batch.Format)
+	assert.JSONEq(t, `[{"name":"cpu_usage","value":0.75,"labels":{"host":"node-1"}}]`, string(batch.Data))
+
+	// Other formats are exported as received
+	otlp := &fb.MetricBatch{BatchID: "batch-2", Data: []byte{0x0a, 0xff}, Format: codec.FormatOTLPProtobuf}
+	require.NoError(t, exportAsJSON(otlp))
+	assert.Equal(t, []byte{0x0a, 0xff}, otlp.Data)
+
+	assert.Error(t, exportAsJSON(&fb.MetricBatch{Data: []byte{0x0a, 0xff}, Format: codec.FormatInternalProtobuf}))
+}
+
+func TestMemoryIdempotencyStore_Expiry(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryIdempotencyStore()
+	store.now = func() time.Time { return now }
+
+	claimed, err := store.Claim("key", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	claimed, err = store.Claim("key", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+
+	now = now.Add(2 * time.Minute)
+	claimed, err = store.Claim("key", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// Expired keys are pruned when new keys are claimed
+	now = now.Add(2 * time.Minute)
+	_, err = store.Claim("other", time.Minute)
+	assert.NoError(t, err)
+	assert.NotContains(t, store.entries, "key")
+
+	// A released key can be claimed again
+	assert.NoError(t, store.Release("other"))
+	claimed, err = store.Claim("other", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+// fakeRedis is a redis server that knows SET, with NX and PX, and DEL
+type fakeRedis struct {
+	listener net.Listener
+
+	mu   sync.Mutex
+	keys map[string]time.Time
+}
+
+// startFakeRedis serves a fakeRedis on a local port until the test ends
+func startFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	r := &fakeRedis{listener: listener, keys: make(map[string]time.Time)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readRESPArray(reader)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write([]byte(r.execute(args))); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs a command and returns its RESP reply
+func (r *fakeRedis) execute(args []string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "SET":
+		key, nx, ttl := args[1], false, time.Duration(0)
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				ms, _ := strconv.Atoi(args[i+1])
+				ttl = time.Duration(ms) * time.Millisecond
+				i++
+			}
+		}
+		if expiry, ok := r.keys[key]; nx && ok && time.Now().Before(expiry) {
+			return "$-1\r\n"
+		}
+		r.keys[key] = time.Now().Add(ttl)
+		return "+OK\r\n"
+	case "DEL":
+		_, ok := r.keys[args[1]]
+		delete(r.keys, args[1])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+// has reports whether key is set
+func (r *fakeRedis) has(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.keys[key]
+	return ok
+}
+
+// readRESPArray reads a command sent as a RESP array of bulk strings
+func readRESPArray(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func TestRedisIdempotencyStore_Claim(t *testing.T) {
+	server := startFakeRedis(t)
+	store := NewRedisIdempotencyStore(server.listener.Addr().String(), "")
+	defer store.Close()
+
+	claimed, err := store.Claim("key", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	assert.True(t, server.has("fb-gw:idempotency:key"))
+
+	claimed, err = store.Claim("key", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, claimed)
+
+	// A released key can be claimed again
+	require.NoError(t, store.Release("key"))
+	claimed, err = store.Claim("key", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	// Claims expire after their TTL
+	claimed, err = store.Claim("short", 20*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	time.Sleep(50 * time.Millisecond)
+	claimed, err = store.Claim("short", 20*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+func TestRedisIdempotencyStore_ConcurrentClaims(t *testing.T) {
+	server := startFakeRedis(t)
+	store := NewRedisIdempotencyStore(server.listener.Addr().String(), "")
+	defer store.Close()
+
+	// Of the replicas claiming the same batch at once, only one exports it
+	var claims atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed, err := store.Claim("key", time.Minute)
+			assert.NoError(t, err)
+			if claimed {
+				claims.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), claims.Load())
+	assert.LessOrEqual(t, len(store.idle), redisMaxIdleConns)
+}
+
+func TestRedisIdempotencyStore_ReconnectsAfterError(t *testing.T) {
+	server := startFakeRedis(t)
+	store := NewRedisIdempotencyStore(server.listener.Addr().String(), "")
+	defer store.Close()
+
+	_, err := store.Claim("key", time.Minute)
+	require.NoError(t, err)
+
+	// A dropped connection fails the command, and the next one redials
+	require.Len(t, store.idle, 1)
+	store.idle[0].conn.Close()
+	_, err = store.Claim("other", time.Minute)
+	assert.Error(t, err)
+	claimed, err := store.Claim("other", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	// A closed store fails commands
+	require.NoError(t, store.Close())
+	_, err = store.Claim("third", time.Minute)
+	assert.Error(t, err)
+}
+
+func TestDefaultConfig_MatchesParametersSchema(t *testing.T) {
+	configBytes, err := json.Marshal(DefaultConfig())
+	require.NoError(t, err)
+	assert.NoError(t, config.ValidateParameters("fb-gw", configBytes))
+}
+
+// writeRequest is a decoded remote-write WriteRequest
+type writeRequest struct {
+	series   []writeRequestSeries
+	metadata []writeRequestMetadata
+}
+
+type writeRequestSeries struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+type writeRequestMetadata struct {
+	metricType int
+	family     string
+	help       string
+	unit       string
+}
+
+// decodeWriteRequest decodes a snappy-compressed remote-write request body
+func decodeWriteRequest(t *testing.T, body []byte) writeRequest {
+	data, err := snappy.Decode(nil, body)
+	require.NoError(t, err)
+
+	var request writeRequest
+	for _, field := range protoFields(t, data) {
+		switch field.num {
+		case writeRequestTimeseriesField:
+			series := writeRequestSeries{labels: make(map[string]string)}
+			for _, tsField := range protoFields(t, field.bytes) {
+				switch tsField.num {
+				case timeSeriesLabelsField:
+					var name, value string
+					for _, labelField := range protoFields(t, tsField.bytes) {
+						if labelField.num == labelNameField {
+							name = string(labelField.bytes)
+						} else {
+							value = string(labelField.bytes)
+						}
+					}
+					series.labels[name] = value
+				case timeSeriesSamplesField:
+					for _, sampleField := range protoFields(t, tsField.bytes) {
+						if sampleField.num == sampleValueField {
+							series.value = math.Float64frombits(sampleField.varint)
+						} else {
+							series.timestamp = int64(sampleField.varint)
+						}
+					}
+				}
+			}
+			request.series = append(request.series, series)
+		case writeRequestMetadataField:
+			var metadata writeRequestMetadata
+			for _, metaField := range protoFields(t, field.bytes) {
+				switch metaField.num {
+				case metadataTypeField:
+					metadata.metricType = int(metaField.varint)
+				case metadataFamilyNameField:
+					metadata.family = string(metaField.bytes)
+				case metadataHelpField:
+					metadata.help = string(metaField.bytes)
+				case metadataUnitField:
+					metadata.unit = string(metaField.bytes)
+				}
+			}
+			request.metadata = append(request.metadata, metadata)
+		}
+	}
+	return request
+}
+
+// protoField is a field of a protobuf message; varint holds the value of
+// varint and fixed64 fields
+type protoField struct {
+	num    protowire.Number
+	bytes  []byte
+	varint uint64
+}
+
+// protoFields splits a protobuf message into its fields
+func protoFields(t *testing.T, data []byte) []protoField {
+	var fields []protoField
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		require.GreaterOrEqual(t, n, 0)
+		data = data[n:]
+
+		field := protoField{num: num}
+		switch typ {
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			field.varint, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			field.varint, n = protowire.ConsumeFixed64(data)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		require.GreaterOrEqual(t, n, 0)
+		data = data[n:]
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// newRemoteWriteGW creates a running GW exporting to a remote-write
+// receiver at endpoint
+func newRemoteWriteGW(t *testing.T, endpoint string, batchSize int) *GW {
+	g := NewGW()
+	require.NoError(t, g.Initialize(context.Background()))
+
+	configBytes, err := json.Marshal(GWConfig{
+		ExportEndpoint: endpoint,
+		RemoteWrite: RemoteWriteConfig{
+			Enabled:     true,
+			Endpoint:    endpoint,
+			BearerToken: "secret-token",
+			BatchSize:   batchSize,
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, g.UpdateConfig(context.Background(), configBytes, 1))
+	return g
+}
+
+func TestGW_RemoteWriteExportsSeriesWithMetadata(t *testing.T) {
+	var requests []writeRequest
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		assert.Equal(t, "Bearer secret-token", r.Header.Get("Authorization"))
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests = append(requests, decodeWriteRequest(t, body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	g := newRemoteWriteGW(t, receiver.URL, 3)
+
+	// A described gauge, and a histogram as FB-AGG flushes it
+	batch := &fb.MetricBatch{
+		BatchID: "batch-1",
+		Data: []byte(`[
+			{"name":"system.memory.usage","value":1024,"timestamp":1700000000.5,"type":"gauge","help":"Memory in use","unit":"bytes","labels":{"host.name":"node-1"}},
+			{"name":"latency_bucket","value":2,"timestamp":1700000000,"labels":{"le":"1","path":"/"}},
+			{"name":"latency_bucket","value":4,"timestamp":1700000000,"labels":{"le":"5","path":"/"}},
+			{"name":"latency_bucket","value":5,"timestamp":1700000000,"labels":{"le":"+Inf","path":"/"}}
+		]`),
+		Format: "json",
+	}
+	result, err := g.ProcessBatch(context.Background(), batch)
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+
+	// Four series in requests of at most three, each with the metadata of
+	// its families
+	require.Len(t, requests, 2)
+	require.Len(t, requests[0].series, 3)
+	require.Len(t, requests[1].series, 1)
+
+	assert.Equal(t, writeRequestSeries{
+		labels:    map[string]string{"__name__": "system_memory_usage", "host_name": "node-1"},
+		value:     1024,
+		timestamp: 1700000000500,
+	}, requests[0].series[0])
+	assert.Equal(t, writeRequestSeries{
+		labels:    map[string]string{"__name__": "latency_bucket", "le": "+Inf", "path": "/"},
+		value:     5,
+		timestamp: 1700000000000,
+	}, requests[1].series[0])
+
+	assert.Equal(t, []writeRequestMetadata{
+		{metricType: metricTypeHistogram, family: "latency"},
+		{metricType: metricTypeGauge, family: "system_memory_usage", help: "Memory in use", unit: "bytes"},
+	}, requests[0].metadata)
+	assert.Equal(t, []writeRequestMetadata{
+		{metricType: metricTypeHistogram, family: "latency"},
+	}, requests[1].metadata)
+}
+
+func TestRemoteWriter_RetriesThrottledRequests(t *testing.T) {
+	var attempts int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch attempts {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer receiver.Close()
+
+	metrics := []codec.Metric{{"name": "cpu", "value": json.Number("1")}}
+	writer := newRemoteWriter(RemoteWriteConfig{Enabled: true, Endpoint: receiver.URL, MaxRetries: 2})
+	require.NoError(t, writer.write(context.Background(), metrics))
+	assert.Equal(t, 3, attempts)
+
+	// Requests still failing once retries run out fail the batch
+	attempts = 0
+	writer = newRemoteWriter(RemoteWriteConfig{Enabled: true, Endpoint: receiver.URL, MaxRetries: 1})
+	err := writer.write(context.Background(), metrics)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, errRemoteWriteRejected)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestGW_RemoteWriteRejectedBatchGoesToDLQ(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+
+	g := newRemoteWriteGW(t, receiver.URL, 0)
+	g.SetHasDLQ(true)
+	var dlqBatches []*fb.MetricBatchRequest
+	g.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqBatches = append(dlqBatches, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	// The receiver's 400 isn't retried; the batch is failed as invalid
+	result, err := g.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID: "batch-1",
+		Data:    []byte(`[{"name":"cpu","value":1}]`),
+		Format:  "json",
+	})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errRemoteWriteRejected)
+	assert.Equal(t, fb.ErrorCodeInvalidInput, result.ErrorCode)
+	assert.True(t, result.SentToDLQ)
+	require.Len(t, dlqBatches, 1)
+	assert.Equal(t, string(fb.ErrorCodeInvalidInput), dlqBatches[0].InternalLabels["error_code"])
+}
@@ -0,0 +1,349 @@
+package gw
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"eidc-tfk8s/pkg/fb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var idempotentSkipsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "fb_gw_idempotent_skips_total",
+	Help: "Total number of batches not exported because they had already been exported",
+})
+
+// Supported idempotency store backends
+const (
+	IdempotencyStoreMemory = "memory"
+	IdempotencyStoreRedis  = "redis"
+)
+
+// defaultIdempotencyTTL is how long exported keys are remembered when the
+// config doesn't say
+const defaultIdempotencyTTL = 10 * time.Minute
+
+// IdempotencyConfig configures skipping batches that were already exported,
+// e.g. when dlq-replay re-injects a batch that partially succeeded
+type IdempotencyConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Store is the backend that records exported keys: memory or redis
+	Store string `json:"store"`
+
+	// TTLSeconds is how long an exported key is remembered
+	TTLSeconds int `json:"ttl_seconds"`
+
+	// RedisAddress is the host:port of the redis server for the redis store
+	RedisAddress string `json:"redis_address"`
+
+	// RedisKeyPrefix namespaces the keys in redis
+	RedisKeyPrefix string `json:"redis_key_prefix"`
+}
+
+// validate checks the idempotency configuration
+func (c IdempotencyConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.TTLSeconds < 0 {
+		return fmt.Errorf("idempotency ttl_seconds must not be negative")
+	}
+	switch c.Store {
+	case "", IdempotencyStoreMemory:
+	case IdempotencyStoreRedis:
+		if c.RedisAddress == "" {
+			return fmt.Errorf("idempotency redis store requires redis_address")
+		}
+	default:
+		return fmt.Errorf("invalid idempotency store: %s, must be 'memory' or 'redis'", c.Store)
+	}
+	return nil
+}
+
+// ttl returns the configured TTL or the default
+func (c IdempotencyConfig) ttl() time.Duration {
+	if c.TTLSeconds == 0 {
+		return defaultIdempotencyTTL
+	}
+	return time.Duration(c.TTLSeconds) * time.Second
+}
+
+// idempotencyStoreChanged reports whether a config change needs a new store
+func idempotencyStoreChanged(old, new IdempotencyConfig) bool {
+	return old.Enabled != new.Enabled ||
+		old.Store != new.Store ||
+		old.RedisAddress != new.RedisAddress ||
+		old.RedisKeyPrefix != new.RedisKeyPrefix
+}
+
+// IdempotencyKey identifies a batch by its id and content, so a replayed
+// batch maps to the same key while a reused id with new data does not
+func IdempotencyKey(batch *fb.MetricBatch) string {
+	hash := sha256.New()
+	hash.Write([]byte(batch.BatchID))
+	hash.Write([]byte{0})
+	hash.Write(batch.Data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// IdempotencyStore records the keys of exported batches for a limited time
+type IdempotencyStore interface {
+	// Claim records key for ttl unless it is already recorded and hasn't
+	// expired, and reports whether it did. Claims are atomic, so of the GW
+	// replicas exporting the same batch at once only one claims it.
+	Claim(key string, ttl time.Duration) (bool, error)
+
+	// Release forgets a claimed key, so a batch that failed to export can be
+	// exported again
+	Release(key string) error
+
+	// Close releases the store's resources
+	Close() error
+}
+
+// newIdempotencyStore creates the store backend named in the config
+func newIdempotencyStore(config IdempotencyConfig) IdempotencyStore {
+	if config.Store == IdempotencyStoreRedis {
+		return NewRedisIdempotencyStore(config.RedisAddress, config.RedisKeyPrefix)
+	}
+	return NewMemoryIdempotencyStore()
+}
+
+// MemoryIdempotencyStore keeps exported keys in memory. Expired keys are
+// pruned as new ones are claimed.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]time.Time
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// memoryPruneInterval is the minimum time between prunes of expired keys
+const memoryPruneInterval = time.Minute
+
+// NewMemoryIdempotencyStore creates an empty in-memory store
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		entries: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Claim implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Claim(key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastPrune) >= memoryPruneInterval {
+		for k, expiry := range s.entries {
+			if !now.Before(expiry) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	if expiry, ok := s.entries[key]; ok && now.Before(expiry) {
+		return false, nil
+	}
+	s.entries[key] = now.Add(ttl)
+	return true, nil
+}
+
+// Release implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// Close implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Close() error {
+	return nil
+}
+
+// redisMaxIdleConns is how many connections the redis store keeps open
+// between commands
+const redisMaxIdleConns = 4
+
+// RedisIdempotencyStore keeps exported keys in redis so they are shared
+// between GW replicas and survive restarts. It speaks just enough of the
+// redis protocol for SET NX and DEL, over lazily dialled connections that
+// are kept for reuse.
+type RedisIdempotencyStore struct {
+	address string
+	prefix  string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+// redisConn is a connection to the redis server
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisIdempotencyStore creates a store backed by the redis server at address
+func NewRedisIdempotencyStore(address, prefix string) *RedisIdempotencyStore {
+	if prefix == "" {
+		prefix = "fb-gw:idempotency:"
+	}
+	return &RedisIdempotencyStore{
+		address: address,
+		prefix:  prefix,
+		timeout: 2 * time.Second,
+	}
+}
+
+// Claim implements IdempotencyStore
+func (s *RedisIdempotencyStore) Claim(key string, ttl time.Duration) (bool, error) {
+	reply, err := s.do("SET", s.prefix+key, "1", "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+
+	// SET NX replies OK if it set the key, and nil if the key exists
+	return reply == "OK", nil
+}
+
+// Release implements IdempotencyStore
+func (s *RedisIdempotencyStore) Release(key string) error {
+	_, err := s.do("DEL", s.prefix+key)
+	return err
+}
+
+// Close implements IdempotencyStore. Connections in use are closed as
+// their commands complete.
+func (s *RedisIdempotencyStore) Close() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.closed = true
+	s.mu.Unlock()
+
+	var errs []error
+	for _, c := range idle {
+		if err := c.conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// do sends a command and returns its simple string, integer or bulk string
+// reply. The lock is only held to take and return a connection, so commands
+// don't wait on each other's round trips. A connection is dropped on any
+// error.
+func (s *RedisIdempotencyStore) do(args ...string) (string, error) {
+	c, err := s.get()
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := c.roundTrip(args, s.timeout)
+	if err != nil {
+		c.conn.Close()
+		return "", fmt.Errorf("redis %s failed: %w", args[0], err)
+	}
+	s.put(c)
+	return reply, nil
+}
+
+// get returns an idle connection, or dials a new one
+func (s *RedisIdempotencyStore) get() (*redisConn, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("redis idempotency store is closed")
+	}
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+
+	conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	return &redisConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// put returns a connection for reuse, closing it if the store is closed or
+// already keeps enough
+func (s *RedisIdempotencyStore) put(c *redisConn) {
+	s.mu.Lock()
+	if !s.closed && len(s.idle) < redisMaxIdleConns {
+		s.idle = append(s.idle, c)
+		c = nil
+	}
+	s.mu.Unlock()
+
+	if c != nil {
+		c.conn.Close()
+	}
+}
+
+// roundTrip writes a command as a RESP array and reads a single reply
+func (c *redisConn) roundTrip(args []string, timeout time.Duration) (string, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+
+	command := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		command += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	if _, err := c.conn.Write([]byte(command)); err != nil {
+		return "", err
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 {
+		return "", fmt.Errorf("malformed reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", fmt.Errorf("%s", line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("malformed reply %q", line)
+		}
+		if size < 0 {
+			return "", nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:size]), nil
+	default:
+		return "", fmt.Errorf("unexpected reply %q", line)
+	}
+}
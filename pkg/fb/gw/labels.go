@@ -0,0 +1,108 @@
+package gw
+
+import (
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+)
+
+// defaultDeniedExportLabels are the internal labels FBs attach to batches for
+// their own bookkeeping and for triaging failures. They are stripped from
+// exports unless allowlisted.
+var defaultDeniedExportLabels = map[string]struct{}{
+	"fb_sender":             {},
+	"error":                 {},
+	"error_code":            {},
+	"dlq_timestamp":         {},
+	fb.LabelReplayCount:     {},
+	fb.LabelChecksum:        {},
+	fb.LabelFanoutTarget:    {},
+	fb.LabelIngestTimestamp: {},
+}
+
+// exportLabelFilter strips the labels that mustn't reach the export backend,
+// from GWConfig.ExportLabelAllowlist and ExportLabelDenylist
+type exportLabelFilter struct {
+	// allow is the allowlist, nil when every label not denied is exported
+	allow map[string]struct{}
+	deny  map[string]struct{}
+}
+
+// newExportLabelFilter creates a filter for an allowlist and denylist
+func newExportLabelFilter(allowlist, denylist []string) *exportLabelFilter {
+	f := &exportLabelFilter{deny: make(map[string]struct{}, len(denylist))}
+	if len(allowlist) > 0 {
+		f.allow = make(map[string]struct{}, len(allowlist))
+		for _, label := range allowlist {
+			f.allow[label] = struct{}{}
+		}
+	}
+	for _, label := range denylist {
+		f.deny[label] = struct{}{}
+	}
+	return f
+}
+
+// exported reports whether a label is exported. Denylisted labels never are.
+// With an allowlist only allowlisted labels are, internal ones included;
+// without one every label but the internal ones is.
+func (f *exportLabelFilter) exported(label string) bool {
+	if _, ok := f.deny[label]; ok {
+		return false
+	}
+	if f.allow != nil {
+		_, ok := f.allow[label]
+		return ok
+	}
+	_, ok := defaultDeniedExportLabels[label]
+	return !ok
+}
+
+// filterLabels returns the labels that are exported, as a new map
+func (f *exportLabelFilter) filterLabels(labels map[string]string) map[string]string {
+	filtered := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if f.exported(k) {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+// filterMetrics strips the labels that aren't exported from the metrics in
+// place, reporting whether there were any
+func (f *exportLabelFilter) filterMetrics(metrics []codec.Metric) bool {
+	stripped := false
+	for _, metric := range metrics {
+		labels, _ := metric["labels"].(map[string]interface{})
+		for k := range labels {
+			if !f.exported(k) {
+				delete(labels, k)
+				stripped = true
+			}
+		}
+	}
+	return stripped
+}
+
+// exportBatch returns a copy of a batch with the labels that aren't exported
+// stripped from its internal labels and, for JSON batches, its metrics.
+// Batches in other formats are exported as received. The batch itself is
+// left as it is, so DLQ copies keep every label for triage.
+func (f *exportLabelFilter) exportBatch(batch *fb.MetricBatch) (*fb.MetricBatch, error) {
+	exported := *batch
+	exported.InternalLabels = f.filterLabels(batch.InternalLabels)
+	if batch.Format != codec.FormatJSON {
+		return &exported, nil
+	}
+
+	metrics, err := codec.Decode(batch)
+	if err != nil {
+		return nil, err
+	}
+	if f.filterMetrics(metrics) {
+		if err := codec.EncodeAs(&exported, metrics, codec.FormatJSON); err != nil {
+			return nil, err
+		}
+	}
+	return &exported, nil
+}
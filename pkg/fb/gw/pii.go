@@ -0,0 +1,177 @@
+package gw
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"eidc-tfk8s/internal/common/schema"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// PIIMode is how the GW handles a PII field found in the clear
+type PIIMode string
+
+// Supported PII detection modes
+const (
+	// PIIModeEnforce fails batches with the field in the clear
+	PIIModeEnforce PIIMode = "enforce"
+	// PIIModeReport logs and counts the would-be violation but exports the
+	// batch, to roll detection out for a field safely
+	PIIModeReport PIIMode = "report"
+	// PIIModeOff doesn't check the field
+	PIIModeOff PIIMode = "off"
+)
+
+// piiViolationsTotal counts PII fields found in the clear, by field and mode
+var piiViolationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_gw_pii_violations_total",
+	Help: "Total number of PII fields found unhashed in exported batches, by field and detection mode",
+}, []string{"field", "mode"})
+
+// validate checks that a mode is supported
+func (m PIIMode) validate() error {
+	switch m {
+	case PIIModeEnforce, PIIModeReport, PIIModeOff:
+		return nil
+	default:
+		return fmt.Errorf("unsupported PII mode %q", m)
+	}
+}
+
+// piiViolation is a PII field found in the clear
+type piiViolation struct {
+	field string
+	path  string
+	mode  PIIMode
+}
+
+// piiDetector finds PII fields in the clear in batches. A nil detector
+// finds none.
+type piiDetector struct {
+	// fields maps each checked field to its mode; fields that are off are left out
+	fields map[string]PIIMode
+}
+
+// newPIIDetector creates a detector for a config, nil when no field is
+// checked. PiiFieldModes overrides the mode of a field in PiiFields, which
+// are enforced when EnablePiiDetection is set.
+func newPIIDetector(cfg GWConfig) *piiDetector {
+	fields := make(map[string]PIIMode)
+	if cfg.EnablePiiDetection {
+		for _, field := range cfg.PiiFields {
+			fields[field] = PIIModeEnforce
+		}
+	}
+	for field, mode := range cfg.PiiFieldModes {
+		fields[field] = mode
+	}
+	for field, mode := range fields {
+		if mode == PIIModeOff {
+			delete(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return &piiDetector{fields: fields}
+}
+
+// detect returns the PII fields in the clear in decoded metrics, in a
+// stable order. A field matches a value whose dotted path is the field or
+// ends with it, e.g. "email" matches "labels.email".
+func (d *piiDetector) detect(metrics []codec.Metric) []piiViolation {
+	if d == nil {
+		return nil
+	}
+	var violations []piiViolation
+	for _, metric := range metrics {
+		d.walk(metric, "", &violations)
+	}
+	return violations
+}
+
+// walk descends into objects and arrays, recording the violations under path
+func (d *piiDetector) walk(value interface{}, path string, violations *[]piiViolation) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fieldPath := k
+			if path != "" {
+				fieldPath = path + "." + k
+			}
+			if field, mode, ok := d.match(fieldPath); ok && !schema.IsHashedOrEncoded(v[k]) {
+				*violations = append(*violations, piiViolation{field: field, path: fieldPath, mode: mode})
+				continue
+			}
+			d.walk(v[k], fieldPath, violations)
+		}
+	case []interface{}:
+		for _, item := range v {
+			d.walk(item, path, violations)
+		}
+	}
+}
+
+// match returns the checked field a path matches and its mode, the longest
+// when several do
+func (d *piiDetector) match(path string) (string, PIIMode, bool) {
+	if mode, ok := d.fields[path]; ok {
+		return path, mode, true
+	}
+	matched := ""
+	for field := range d.fields {
+		if len(field) > len(matched) && strings.HasSuffix(path, "."+field) {
+			matched = field
+		}
+	}
+	if matched == "" {
+		return "", "", false
+	}
+	return matched, d.fields[matched], true
+}
+
+// checkPII looks for PII fields in the clear in a batch. Violations of fields
+// in report mode are logged and counted; the first in enforce mode fails the
+// batch. Data that can't be decoded fails it too, rather than export PII
+// unchecked.
+func (g *GW) checkPII(ctx context.Context, batch *fb.MetricBatch) error {
+	if g.piiDetector == nil {
+		return nil
+	}
+	_, span := g.tracer.StartSpan(ctx, "GW.CheckPII")
+	defer span.End()
+
+	metrics, err := codec.Decode(batch)
+	if err != nil {
+		return fmt.Errorf("failed to decode batch for PII detection: %w", err)
+	}
+
+	var enforced error
+	for _, violation := range g.piiDetector.detect(metrics) {
+		piiViolationsTotal.WithLabelValues(violation.field, string(violation.mode)).Inc()
+		if violation.mode == PIIModeReport {
+			g.logger.Warn("PII field detected without hashing", map[string]interface{}{
+				"batch_id": batch.BatchID,
+				"field":    violation.field,
+				"path":     violation.path,
+				"mode":     string(violation.mode),
+			})
+			continue
+		}
+		if enforced == nil {
+			enforced = fmt.Errorf("%w: %s", schema.ErrPIIDetected, violation.path)
+		}
+	}
+	return enforced
+}
@@ -0,0 +1,579 @@
+package gw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Remote-write limits used when the config doesn't set them
+const (
+	defaultRemoteWriteBatchSize  = 500
+	defaultRemoteWriteTimeout    = 30 * time.Second
+	defaultRemoteWriteMaxRetries = 3
+)
+
+// Backoff between retries of a request the receiver didn't give a
+// Retry-After for, doubling from the minimum. Retry-After is capped at the
+// maximum too, so a receiver can't stall the chain.
+const (
+	remoteWriteMinBackoff = 100 * time.Millisecond
+	remoteWriteMaxBackoff = 30 * time.Second
+)
+
+// Field numbers of the Prometheus remote-write messages (prompb): a
+// WriteRequest holds TimeSeries of Labels and Samples, and MetricMetadata
+const (
+	writeRequestTimeseriesField = 1
+	writeRequestMetadataField   = 3
+
+	timeSeriesLabelsField  = 1
+	timeSeriesSamplesField = 2
+
+	labelNameField  = 1
+	labelValueField = 2
+
+	sampleValueField     = 1
+	sampleTimestampField = 2
+
+	metadataTypeField       = 1
+	metadataFamilyNameField = 2
+	metadataHelpField       = 4
+	metadataUnitField       = 5
+)
+
+// Values of MetricMetadata.MetricType
+const (
+	metricTypeUnknown        = 0
+	metricTypeCounter        = 1
+	metricTypeGauge          = 2
+	metricTypeHistogram      = 3
+	metricTypeGaugeHistogram = 4
+	metricTypeSummary        = 5
+	metricTypeInfo           = 6
+	metricTypeStateset       = 7
+)
+
+// metricTypes maps the "type" field of internal metrics to remote-write
+// metric types
+var metricTypes = map[string]int{
+	"counter":        metricTypeCounter,
+	"sum":            metricTypeCounter,
+	"gauge":          metricTypeGauge,
+	"histogram":      metricTypeHistogram,
+	"gaugehistogram": metricTypeGaugeHistogram,
+	"summary":        metricTypeSummary,
+	"info":           metricTypeInfo,
+	"stateset":       metricTypeStateset,
+}
+
+// errRemoteWriteRejected is returned for batches the remote-write receiver
+// rejects as invalid, or that can't be converted to remote-write series.
+// Sending them again can't succeed.
+var errRemoteWriteRejected = errors.New("remote-write rejected")
+
+var (
+	remoteWriteRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_gw_remote_write_requests_total",
+		Help: "Total number of remote-write requests, by outcome",
+	}, []string{"outcome"})
+
+	remoteWriteSamplesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_gw_remote_write_samples_total",
+		Help: "Total number of samples written to the remote-write endpoint",
+	})
+)
+
+// RemoteWriteConfig configures exporting batches to a Prometheus
+// remote-write endpoint
+type RemoteWriteConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Endpoint is the URL of the remote-write receiver
+	Endpoint string `json:"endpoint"`
+
+	// BearerToken, or BasicAuthUsername and BasicAuthPassword, authenticate
+	// requests
+	BearerToken       string `json:"bearer_token"`
+	BasicAuthUsername string `json:"basic_auth_username"`
+	BasicAuthPassword string `json:"basic_auth_password"`
+
+	// BatchSize is the maximum number of series per request. Larger batches
+	// are written in several requests.
+	BatchSize int `json:"batch_size"`
+
+	// TimeoutSeconds bounds each request
+	TimeoutSeconds int `json:"timeout_seconds"`
+
+	// MaxRetries is how many times a request failing with a 429, a 5xx or a
+	// network error is retried before the batch goes to the DLQ
+	MaxRetries int `json:"max_retries"`
+}
+
+// validate checks the remote-write configuration
+func (c RemoteWriteConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Endpoint == "" {
+		return fmt.Errorf("remote_write requires endpoint")
+	}
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return fmt.Errorf("invalid remote_write endpoint: %s, must be an http or https URL", c.Endpoint)
+	}
+	if c.BearerToken != "" && c.BasicAuthUsername != "" {
+		return fmt.Errorf("remote_write bearer_token and basic auth are mutually exclusive")
+	}
+	if c.BatchSize < 0 {
+		return fmt.Errorf("remote_write batch_size must not be negative")
+	}
+	if c.TimeoutSeconds < 0 {
+		return fmt.Errorf("remote_write timeout_seconds must not be negative")
+	}
+	if c.MaxRetries < 0 {
+		return fmt.Errorf("remote_write max_retries must not be negative")
+	}
+	return nil
+}
+
+func (c RemoteWriteConfig) batchSize() int {
+	if c.BatchSize == 0 {
+		return defaultRemoteWriteBatchSize
+	}
+	return c.BatchSize
+}
+
+func (c RemoteWriteConfig) timeout() time.Duration {
+	if c.TimeoutSeconds == 0 {
+		return defaultRemoteWriteTimeout
+	}
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+func (c RemoteWriteConfig) maxRetries() int {
+	if c.MaxRetries == 0 {
+		return defaultRemoteWriteMaxRetries
+	}
+	return c.MaxRetries
+}
+
+// remoteWriteLabel is a label of a remote-write series
+type remoteWriteLabel struct {
+	name  string
+	value string
+}
+
+// remoteWriteSeries is a remote-write series with a single sample
+type remoteWriteSeries struct {
+	family    string
+	labels    []remoteWriteLabel
+	value     float64
+	timestamp int64
+}
+
+// remoteWriteMetadata is the metadata of a metric family
+type remoteWriteMetadata struct {
+	metricType int
+	help       string
+	unit       string
+}
+
+// toRemoteWrite converts internal metrics to remote-write series, one per
+// metric, and the metadata of their families. Metrics without a timestamp
+// are stamped with now.
+//
+// The family of a metric is its name, except for the "_bucket", "_sum" and
+// "_count" series of histograms and summaries: FB-AGG emits histograms as
+// cumulative "<name>_bucket" metrics with an "le" label, which are taken as
+// a histogram named <name> even without a "type".
+func toRemoteWrite(metrics []codec.Metric, now time.Time) ([]remoteWriteSeries, map[string]remoteWriteMetadata, error) {
+	series := make([]remoteWriteSeries, 0, len(metrics))
+	metadata := make(map[string]remoteWriteMetadata)
+	for i, metric := range metrics {
+		name, _ := metric["name"].(string)
+		if name == "" {
+			return nil, nil, fmt.Errorf("metric %d has no name", i)
+		}
+		name = sanitizeName(name, true)
+
+		value, err := toFloat(metric["value"])
+		if err != nil {
+			return nil, nil, fmt.Errorf("metric %d (%s): value: %w", i, name, err)
+		}
+
+		timestamp := now.UnixMilli()
+		if ts, ok := metric["timestamp"]; ok {
+			seconds, err := toFloat(ts)
+			if err != nil {
+				return nil, nil, fmt.Errorf("metric %d (%s): timestamp: %w", i, name, err)
+			}
+			timestamp = int64(math.Round(seconds * 1000))
+		}
+
+		metricLabels, _ := metric["labels"].(map[string]interface{})
+		_, hasLE := metricLabels["le"]
+		labels := []remoteWriteLabel{{name: "__name__", value: name}}
+		for k, v := range metricLabels {
+			k = sanitizeName(k, false)
+			if k == "__name__" {
+				continue
+			}
+			labels = append(labels, remoteWriteLabel{name: k, value: labelValue(v)})
+		}
+		// Receivers require labels sorted by name
+		sort.Slice(labels, func(a, b int) bool { return labels[a].name < labels[b].name })
+
+		metricType, _ := metric["type"].(string)
+		family, familyType := metricFamily(name, strings.ToLower(metricType), hasLE)
+		series = append(series, remoteWriteSeries{
+			family:    family,
+			labels:    labels,
+			value:     value,
+			timestamp: timestamp,
+		})
+
+		// The first metric of a family with a type, help or unit describes it
+		meta := metadata[family]
+		if meta.metricType == metricTypeUnknown {
+			meta.metricType = familyType
+		}
+		if meta.help == "" {
+			meta.help = metricHelp(metric)
+		}
+		if meta.unit == "" {
+			meta.unit, _ = metric["unit"].(string)
+		}
+		metadata[family] = meta
+	}
+	return series, metadata, nil
+}
+
+// metricFamily returns the family of a metric and its type
+func metricFamily(name, metricType string, hasLE bool) (string, int) {
+	familyType, ok := metricTypes[metricType]
+	if !ok && hasLE && strings.HasSuffix(name, "_bucket") {
+		familyType = metricTypeHistogram
+	}
+
+	switch familyType {
+	case metricTypeHistogram, metricTypeGaugeHistogram, metricTypeSummary:
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if strings.HasSuffix(name, suffix) {
+				return strings.TrimSuffix(name, suffix), familyType
+			}
+		}
+	}
+	return name, familyType
+}
+
+// metricHelp returns the description of an internal metric, from its "help"
+// or, as OTLP calls it, "description" field
+func metricHelp(metric codec.Metric) string {
+	if help, ok := metric["help"].(string); ok && help != "" {
+		return help
+	}
+	description, _ := metric["description"].(string)
+	return description
+}
+
+// labelValue renders a label value as a string
+func labelValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// toFloat converts a decoded number to a float64
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return strconv.ParseFloat(string(v), 64)
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("not a number: %T", v)
+	}
+}
+
+// sanitizeName replaces the characters Prometheus doesn't allow in metric
+// names, or in label names if metricName is false, with underscores, e.g.
+// the dots of OTLP names
+func sanitizeName(name string, metricName bool) string {
+	var b strings.Builder
+	b.Grow(len(name))
+	for i, r := range name {
+		valid := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(i > 0 && r >= '0' && r <= '9') || (metricName && r == ':')
+		if valid {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// encodeWriteRequest encodes series and the metadata of their families as a
+// remote-write WriteRequest. Metadata is sorted by family, so the same
+// series always encode to the same bytes.
+func encodeWriteRequest(series []remoteWriteSeries, metadata map[string]remoteWriteMetadata) []byte {
+	var b []byte
+	families := make(map[string]bool)
+	for _, s := range series {
+		var ts []byte
+		for _, label := range s.labels {
+			var l []byte
+			l = protowire.AppendTag(l, labelNameField, protowire.BytesType)
+			l = protowire.AppendString(l, label.name)
+			l = protowire.AppendTag(l, labelValueField, protowire.BytesType)
+			l = protowire.AppendString(l, label.value)
+			ts = appendMessage(ts, timeSeriesLabelsField, l)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, sampleValueField, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, sampleTimestampField, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+		ts = appendMessage(ts, timeSeriesSamplesField, sample)
+
+		b = appendMessage(b, writeRequestTimeseriesField, ts)
+		families[s.family] = true
+	}
+
+	names := make([]string, 0, len(families))
+	for family := range families {
+		names = append(names, family)
+	}
+	sort.Strings(names)
+	for _, family := range names {
+		meta := metadata[family]
+		var m []byte
+		if meta.metricType != metricTypeUnknown {
+			m = protowire.AppendTag(m, metadataTypeField, protowire.VarintType)
+			m = protowire.AppendVarint(m, uint64(meta.metricType))
+		}
+		m = protowire.AppendTag(m, metadataFamilyNameField, protowire.BytesType)
+		m = protowire.AppendString(m, family)
+		if meta.help != "" {
+			m = protowire.AppendTag(m, metadataHelpField, protowire.BytesType)
+			m = protowire.AppendString(m, meta.help)
+		}
+		if meta.unit != "" {
+			m = protowire.AppendTag(m, metadataUnitField, protowire.BytesType)
+			m = protowire.AppendString(m, meta.unit)
+		}
+		b = appendMessage(b, writeRequestMetadataField, m)
+	}
+	return b
+}
+
+// appendMessage appends an embedded message field
+func appendMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+// remoteWriter writes metrics to a Prometheus remote-write endpoint
+type remoteWriter struct {
+	config RemoteWriteConfig
+	client *http.Client
+}
+
+// newRemoteWriter creates a remote writer for config
+func newRemoteWriter(config RemoteWriteConfig) *remoteWriter {
+	return &remoteWriter{
+		config: config,
+		client: &http.Client{Timeout: config.timeout()},
+	}
+}
+
+// write converts metrics to remote-write series and writes them in requests
+// of at most the configured batch size. If a request fails, the requests
+// before it were written already; writing them again when the batch is
+// replayed is harmless, as receivers accept duplicate samples.
+func (w *remoteWriter) write(ctx context.Context, metrics []codec.Metric) error {
+	series, metadata, err := toRemoteWrite(metrics, time.Now())
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRemoteWriteRejected, err)
+	}
+
+	batchSize := w.config.batchSize()
+	for start := 0; start < len(series); start += batchSize {
+		end := start + batchSize
+		if end > len(series) {
+			end = len(series)
+		}
+		body := snappy.Encode(nil, encodeWriteRequest(series[start:end], metadata))
+		if err := w.send(ctx, body); err != nil {
+			return err
+		}
+		remoteWriteSamplesTotal.Add(float64(end - start))
+	}
+	return nil
+}
+
+// send posts a request, retrying it while the receiver fails with a 429 or
+// a 5xx. Retries wait for the Retry-After the receiver asks for, or back
+// off exponentially without one.
+func (w *remoteWriter) send(ctx context.Context, body []byte) error {
+	backoff := remoteWriteMinBackoff
+	for attempt := 0; ; attempt++ {
+		retry, retryAfter, err := w.post(ctx, body)
+		if err == nil {
+			remoteWriteRequestsTotal.WithLabelValues("success").Inc()
+			return nil
+		}
+		if !retry || attempt >= w.config.maxRetries() {
+			remoteWriteRequestsTotal.WithLabelValues("failed").Inc()
+			return err
+		}
+		remoteWriteRequestsTotal.WithLabelValues("retried").Inc()
+
+		wait := backoff
+		if retryAfter >= 0 {
+			wait = retryAfter
+		}
+		if wait > remoteWriteMaxBackoff {
+			wait = remoteWriteMaxBackoff
+		}
+		backoff *= 2
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("remote-write retry cancelled: %w (last error: %v)", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// post posts a request once. It returns whether a failed request may be
+// retried, and how long the receiver asked to wait first, or -1 if it
+// didn't say.
+func (w *remoteWriter) post(ctx context.Context, body []byte) (bool, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, -1, fmt.Errorf("failed to create remote-write request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "eidc-tfk8s-fb-gw")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case w.config.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+w.config.BearerToken)
+	case w.config.BasicAuthUsername != "":
+		req.SetBasicAuth(w.config.BasicAuthUsername, w.config.BasicAuthPassword)
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		// Network errors are retried, unless the batch's request is gone
+		return ctx.Err() == nil, -1, fmt.Errorf("remote-write request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	// Keep the start of the receiver's message for the error
+	message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	io.Copy(io.Discard, res.Body)
+
+	switch {
+	case res.StatusCode/100 == 2:
+		return false, -1, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode/100 == 5:
+		err := fmt.Errorf("remote-write receiver returned %s: %s", res.Status, bytes.TrimSpace(message))
+		return true, parseRetryAfter(res.Header.Get("Retry-After"), time.Now()), err
+	default:
+		return false, -1, fmt.Errorf("%w: receiver returned %s: %s", errRemoteWriteRejected, res.Status, bytes.TrimSpace(message))
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as an HTTP
+// date, returning -1 if it is missing or invalid
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return -1
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return -1
+}
+
+// exportRemoteWrite writes the exported copy of a batch to the remote-write
+// endpoint. Batches the receiver rejects go to the quarantine, or the DLQ
+// without one, and batches still failing after retries to the DLQ, as they
+// were received.
+func (g *GW) exportRemoteWrite(ctx context.Context, batch, exported *fb.MetricBatch) (*fb.ProcessResult, error) {
+	ctx, span := g.tracer.StartSpan(ctx, "GW.RemoteWrite")
+	defer span.End()
+
+	metrics, err := codec.Decode(exported)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", errRemoteWriteRejected, err)
+	} else {
+		err = g.remoteWriter.write(ctx, metrics)
+	}
+	if err == nil {
+		return nil, nil
+	}
+
+	code := fb.ErrorCodeForwardingFailed
+	if errors.Is(err, errRemoteWriteRejected) {
+		code = fb.ErrorCodeInvalidInput
+	}
+	g.logger.Error("Failed to export batch via remote-write", err, map[string]interface{}{
+		"batch_id":   batch.BatchID,
+		"error_code": code,
+	})
+	g.tracer.SetStatus(ctx, codes.Error, "Remote-write export failed")
+
+	dlqErr := g.SendFailedBatch(ctx, batch, code, err, func(ctx context.Context, batch *fb.MetricBatch, err error) error {
+		_, dlqErr := g.sendToDLQ(ctx, batch, code, err)
+		return dlqErr
+	})
+	if errors.Is(dlqErr, fb.ErrPoisonBatch) {
+		return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
+	}
+	return fb.NewErrorResult(batch.BatchID, code, err, dlqErr == nil), err
+}
@@ -0,0 +1,82 @@
+package gw
+
+import (
+	"eidc-tfk8s/internal/config"
+)
+
+// parametersSchema is the JSON Schema of GWConfig
+const parametersSchema = `{
+	"type": "object",
+	"required": ["export_endpoint"],
+	"properties": {
+		"schema_enforce": {"type": "boolean"},
+		"export_endpoint": {"type": "string"},
+		"pii_fields": {
+			"type": "array",
+			"items": {"type": "string"}
+		},
+		"enable_pii_detection": {"type": "boolean"},
+		"pii_field_modes": {"type": "object"},
+		"idempotency": {
+			"type": "object",
+			"properties": {
+				"enabled": {"type": "boolean"},
+				"store": {"type": "string", "enum": ["", "memory", "redis"]},
+				"ttl_seconds": {"type": "integer"},
+				"redis_address": {"type": "string"},
+				"redis_key_prefix": {"type": "string"}
+			}
+		},
+		"remote_write": {
+			"type": "object",
+			"properties": {
+				"enabled": {"type": "boolean"},
+				"endpoint": {"type": "string"},
+				"bearer_token": {"type": "string"},
+				"basic_auth_username": {"type": "string"},
+				"basic_auth_password": {"type": "string"},
+				"batch_size": {"type": "integer"},
+				"timeout_seconds": {"type": "integer"},
+				"max_retries": {"type": "integer"}
+			}
+		},
+		"export_label_allowlist": {
+			"type": "array",
+			"items": {"type": "string"}
+		},
+		"export_label_denylist": {
+			"type": "array",
+			"items": {"type": "string"}
+		}
+	}
+}`
+
+func init() {
+	config.RegisterParametersSchema("fb-gw", parametersSchema)
+}
+
+// DefaultConfig returns the GW configuration enforcing the schema of exported
+// metrics
+func DefaultConfig() GWConfig {
+	return GWConfig{
+		Common: config.FBConfig{
+			LogLevel:           "info",
+			MetricsEnabled:     true,
+			TracingEnabled:     true,
+			TraceSamplingRatio: 0.1,
+			CircuitBreaker: config.CircuitBreakerConfig{
+				ErrorThresholdPercentage: 50,
+				OpenStateSeconds:         30,
+				HalfOpenRequestThreshold: 5,
+			},
+		},
+		SchemaEnforce:      true,
+		ExportEndpoint:     "https://metric-api.newrelic.com/metric/v1",
+		PiiFields:          []string{},
+		EnablePiiDetection: false,
+		Idempotency: IdempotencyConfig{
+			Store:      IdempotencyStoreMemory,
+			TTLSeconds: int(defaultIdempotencyTTL.Seconds()),
+		},
+	}
+}
@@ -0,0 +1,46 @@
+package fb
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// RegisterHealthServer registers the standard grpc.health.v1.Health service on
+// server. The status is reported both for the empty service name and the
+// function block's name, and follows readiness from then on. Call it before
+// the server starts serving.
+func (b *BaseFunctionBlock) RegisterHealthServer(server *grpc.Server) {
+	if b.health == nil {
+		b.health = health.NewServer()
+	}
+	healthpb.RegisterHealthServer(server, b.health)
+	b.updateHealth()
+}
+
+// servingStatus returns the health status for the current readiness and
+// lifecycle state. Function blocks that don't use the lifecycle stay in
+// StateCreated and are serving whenever they are ready.
+func (b *BaseFunctionBlock) servingStatus() healthpb.HealthCheckResponse_ServingStatus {
+	if !b.ready {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	switch b.State() {
+	case StateCreated, StateRunning:
+		return healthpb.HealthCheckResponse_SERVING
+	default:
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+}
+
+// updateHealth publishes the current serving status to the health server
+func (b *BaseFunctionBlock) updateHealth() {
+	if b.health == nil {
+		return
+	}
+
+	status := b.servingStatus()
+	b.health.SetServingStatus("", status)
+	b.health.SetServingStatus(b.name, status)
+}
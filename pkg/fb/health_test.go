@@ -0,0 +1,74 @@
+package fb
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startHealthServer serves b's health service over an in-memory listener and returns a client
+func startHealthServer(t *testing.T, b *BaseFunctionBlock) healthpb.HealthClient {
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	b.RegisterHealthServer(server)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func checkHealth(t *testing.T, client healthpb.HealthClient, service string) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	require.NoError(t, err)
+	return resp.Status
+}
+
+func TestBaseFunctionBlock_HealthFollowsLifecycle(t *testing.T) {
+	b := NewBaseFunctionBlock("fb-test")
+	client := startHealthServer(t, &b)
+
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, checkHealth(t, client, ""))
+
+	// Initialized but not yet configured
+	b.SetReady(true)
+	require.NoError(t, b.Transition(StateInitialized))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, checkHealth(t, client, ""))
+
+	// Serving once config is applied
+	require.NoError(t, b.ConfigApplied())
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, checkHealth(t, client, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, checkHealth(t, client, "fb-test"))
+
+	// Not serving while draining
+	require.NoError(t, b.Transition(StateDraining))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, checkHealth(t, client, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, checkHealth(t, client, "fb-test"))
+}
+
+func TestBaseFunctionBlock_HealthFollowsReadiness(t *testing.T) {
+	// Function blocks without a lifecycle are serving whenever they are ready
+	b := NewBaseFunctionBlock("fb-test")
+	client := startHealthServer(t, &b)
+
+	b.SetReady(true)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, checkHealth(t, client, ""))
+
+	b.SetReady(false)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, checkHealth(t, client, ""))
+}
@@ -3,6 +3,17 @@ package fb
 import (
 	"context"
 	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eidc-tfk8s/internal/common/instance"
+	"eidc-tfk8s/internal/common/resilience"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
 )
 
 // Common errors
@@ -13,25 +24,18 @@ var (
 	ErrCircuitBreakerOpen = errors.New("circuit breaker is open")
 	ErrDLQSendFailed      = errors.New("failed to send to DLQ")
 	ErrShutdownTimeout    = errors.New("shutdown timed out")
-)
 
-// ErrorCode represents an error code for standardized error handling
-type ErrorCode string
+	// ErrNoDLQ is returned by function blocks' DLQ senders when the FB is
+	// deployed without a DLQ. The batch should be failed back to the caller.
+	ErrNoDLQ = errors.New("no DLQ configured")
+)
 
-// Common error codes
-const (
-	ErrorCodeUnknown              ErrorCode = "ERR_UNKNOWN"
-	ErrorCodeInvalidInput         ErrorCode = "ERR_INVALID_INPUT"
-	ErrorCodeInvalidConfig        ErrorCode = "ERR_INVALID_CONFIG"
-	ErrorCodeProcessingFailed     ErrorCode = "ERR_PROCESSING_FAILED"
-	ErrorCodeForwardingFailed     ErrorCode = "ERR_FORWARDING_FAILED"
-	ErrorCodeCircuitBreakerOpen   ErrorCode = "ERR_CIRCUIT_BREAKER_OPEN"
-	ErrorCodeDLQSendFailed        ErrorCode = "ERR_DLQ_SEND_FAILED"
-	ErrorCodePoisonBatch          ErrorCode = "ERR_POISON_BATCH"
-	ErrorCodePIILeak              ErrorCode = "ERR_PII_LEAK"
-	ErrorCodeThrottled            ErrorCode = "ERR_THROTTLED"
-	ErrorCodeServiceUnavailable   ErrorCode = "ERR_SERVICE_UNAVAILABLE"
-	ErrorCodeTimeout              ErrorCode = "ERR_TIMEOUT"
+// Metrics shared by all function blocks through BaseFunctionBlock
+var (
+	bypassedBatchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_bypassed_batches_total",
+		Help: "Total number of batches forwarded unchanged because the function block is disabled",
+	}, []string{"fb_name"})
 )
 
 // FunctionBlock defines the interface that all function blocks must implement
@@ -48,6 +52,10 @@ type FunctionBlock interface {
 	// UpdateConfig updates the function block's configuration
 	UpdateConfig(ctx context.Context, configBytes []byte, generation int64) error
 
+	// ValidateConfig parses and validates a configuration without applying
+	// it, so a generation can be checked before it is rolled out
+	ValidateConfig(configBytes []byte) error
+
 	// Ready returns whether the function block is ready to process data
 	Ready() bool
 
@@ -55,6 +63,47 @@ type FunctionBlock interface {
 	Shutdown(ctx context.Context) error
 }
 
+// Reinitializer is implemented by function blocks with subsystems that can't
+// be hot-swapped, such as storage backends
+type Reinitializer interface {
+	// Reinitialize applies a configuration that requires a restart, tearing
+	// down and rebuilding the affected subsystems in place
+	Reinitialize(ctx context.Context, configBytes []byte, generation int64) error
+}
+
+// ApplyConfig applies a configuration update to a function block. Updates
+// that require a restart go through Reinitialize when the block supports it.
+func ApplyConfig(ctx context.Context, block FunctionBlock, configBytes []byte, generation int64, requiresRestart bool) error {
+	if requiresRestart {
+		if reinitializer, ok := block.(Reinitializer); ok {
+			return reinitializer.Reinitialize(ctx, configBytes, generation)
+		}
+	}
+	return block.UpdateConfig(ctx, configBytes, generation)
+}
+
+// ConfigSource delivers configuration updates, as config.ConfigClient does
+type ConfigSource interface {
+	// RegisterCallback registers a callback for regular updates
+	RegisterCallback(callback func([]byte, int64) error)
+
+	// RegisterRestartCallback registers a callback for updates that
+	// require a restart
+	RegisterRestartCallback(callback func([]byte, int64) error)
+}
+
+// WatchConfig applies the updates delivered by source to block through
+// ApplyConfig, reinitializing the block for updates that require a restart.
+// It must be called before the source is started.
+func WatchConfig(ctx context.Context, source ConfigSource, block FunctionBlock) {
+	source.RegisterCallback(func(configBytes []byte, generation int64) error {
+		return ApplyConfig(ctx, block, configBytes, generation, false)
+	})
+	source.RegisterRestartCallback(func(configBytes []byte, generation int64) error {
+		return ApplyConfig(ctx, block, configBytes, generation, true)
+	})
+}
+
 // MetricBatch represents a batch of metrics being processed
 type MetricBatch struct {
 	// Unique identifier for this batch
@@ -63,7 +112,8 @@ type MetricBatch struct {
 	// The serialized metric batch data
 	Data []byte
 
-	// Format of the data (e.g., "otlp", "prometheus")
+	// Format of the data, used to pick the codec that decodes it (e.g.,
+	// "json", "otlp/protobuf"). Untagged data is detected from its content.
 	Format string
 
 	// Whether this is a replay from DLQ
@@ -79,6 +129,35 @@ type MetricBatch struct {
 	InternalLabels map[string]string
 }
 
+// LabelIngestTimestamp is the internal label holding the time, in Unix
+// nanoseconds, at which the batch first entered the pipeline
+const LabelIngestTimestamp = "ingest_ts"
+
+// StampIngestTime records the ingest time on the batch unless it already
+// carries one (e.g. a DLQ replay keeps its original ingest time)
+func StampIngestTime(batch *MetricBatch, t time.Time) {
+	if batch.InternalLabels == nil {
+		batch.InternalLabels = make(map[string]string)
+	}
+	if _, ok := batch.InternalLabels[LabelIngestTimestamp]; ok {
+		return
+	}
+	batch.InternalLabels[LabelIngestTimestamp] = strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// IngestTime returns the ingest time stamped on the batch, if any
+func IngestTime(batch *MetricBatch) (time.Time, bool) {
+	value, ok := batch.InternalLabels[LabelIngestTimestamp]
+	if !ok {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
 // ProcessResult represents the result of processing a batch
 type ProcessResult struct {
 	// Status of the operation
@@ -90,11 +169,24 @@ type ProcessResult struct {
 	// Error code, if any
 	ErrorCode ErrorCode
 
+	// Whether the sender may retry the batch. Derived from ErrorCode, and
+	// false once the batch has been sent to the DLQ.
+	Retriable bool
+
 	// Batch ID echo
 	BatchID string
 
-	// Whether the batch was sent to DLQ
+	// Whether the batch was sent to DLQ, or to the quarantine for
+	// non-retriable errors
 	SentToDLQ bool
+
+	// How long a throttled sender should wait before retrying; zero leaves
+	// it to the sender
+	RetryAfter time.Duration
+
+	// Number of data points dropped from a batch that was otherwise
+	// accepted, e.g. parts RX couldn't decode and sent to the DLQ
+	RejectedDataPoints int
 }
 
 // Status represents the status of a processing operation
@@ -128,18 +220,96 @@ func (s Status) String() string {
 	}
 }
 
+// ForwardFunc forwards a batch to the next function block in the chain
+type ForwardFunc func(ctx context.Context, batch *MetricBatch) (*ProcessResult, error)
+
 // BaseFunctionBlock provides common functionality for all function blocks
 type BaseFunctionBlock struct {
-	name              string
-	ready             bool
-	configGeneration  int64
+	name string
+
+	// sender identifies the replica in the fb_sender label of batches it
+	// sends to the DLQ, e.g. fb-cl/<pod>
+	sender           string
+	ready            bool
+	configGeneration int64
+
+	// disabled is set when the pipeline config has enabled:false for this FB.
+	// The zero value keeps the FB enabled.
+	disabled atomic.Bool
+
+	// noDLQ is set when the pipeline config has no DLQ address for this FB.
+	// The zero value assumes a DLQ.
+	noDLQ atomic.Bool
+
+	// quarantine receives batches failing with non-retriable errors, set by
+	// ConnectQuarantine. Nil sends them to the DLQ.
+	quarantine atomic.Pointer[quarantine]
+
+	// nextFB and dlq are the connections to the next FB and the DLQ, and
+	// nextFBBreaker and dlqBreaker their circuit breakers, set by
+	// ConnectChain. Nil links fail the batches sent to them.
+	nextFB        atomic.Pointer[chainLink]
+	dlq           atomic.Pointer[chainLink]
+	nextFBBreaker atomic.Pointer[resilience.CircuitBreaker]
+	dlqBreaker    atomic.Pointer[resilience.CircuitBreaker]
+
+	// fanout is the set of next FBs batches fan out to, set by
+	// ConnectNextFBs. Nil forwards to the FB's single next FB.
+	fanout atomic.Pointer[fanout]
+
+	// debugTap captures sampled batches for debugging, set by
+	// ConfigureDebugTap. Nil captures nothing.
+	debugTap atomic.Pointer[debugTap]
+
+	// state is the lifecycle State, accessed atomically
+	state int32
+
+	// batches counts the batches started with BeginBatch and not yet done,
+	// guarded by batchesMu. batchesDone is closed when it drops to 0 while
+	// DrainBatches waits.
+	batchesMu   sync.Mutex
+	batches     int
+	batchesDone chan struct{}
+
+	// health is the gRPC health server, set by RegisterHealthServer
+	health *health.Server
+
+	// reflection is whether RegisterReflectionServer registers the gRPC
+	// reflection service, set by SetReflectionEnabled
+	reflection bool
+
+	// dialOptions are added to the options of every connection the
+	// function block opens, set by SetDialOptions
+	dialOptions []grpc.DialOption
+
+	// maxConcurrentBatches caps the batches processed at once, accessed
+	// atomically. 0 means no cap.
+	maxConcurrentBatches int64
+
+	// maxReplayCount caps a batch's hops through the DLQ, accessed
+	// atomically. 0 means no cap.
+	maxReplayCount int64
+
+	// maxMetadataEntries and maxMetadataBytes cap each of a batch's
+	// metadata maps, accessed atomically. 0 means no cap.
+	maxMetadataEntries int64
+	maxMetadataBytes   int64
+
+	// truncateMetadata is 1 when over-limit metadata maps are truncated
+	// rather than rejected, accessed atomically
+	truncateMetadata int32
+
+	// verifyChecksum is 1 when incoming batches are checked against their
+	// data checksum, accessed atomically
+	verifyChecksum int32
 }
 
 // NewBaseFunctionBlock creates a new BaseFunctionBlock with the given name
 func NewBaseFunctionBlock(name string) BaseFunctionBlock {
 	return BaseFunctionBlock{
-		name:  name,
-		ready: false,
+		name:   name,
+		sender: instance.FromEnv().Sender(name),
+		ready:  false,
 	}
 }
 
@@ -148,9 +318,17 @@ func (b *BaseFunctionBlock) Name() string {
 	return b.name
 }
 
+// Sender returns the name of the function block qualified with the pod it
+// runs as, from the Downward API, for the fb_sender label of batches sent to
+// the DLQ. It is the bare name outside Kubernetes.
+func (b *BaseFunctionBlock) Sender() string {
+	return b.sender
+}
+
 // SetReady sets the ready state of the function block
 func (b *BaseFunctionBlock) SetReady(ready bool) {
 	b.ready = ready
+	b.updateHealth()
 }
 
 // Ready returns whether the function block is ready to process data
@@ -168,7 +346,38 @@ func (b *BaseFunctionBlock) GetConfigGeneration() int64 {
 	return b.configGeneration
 }
 
-// NewErrorResult creates a new error processing result
+// SetEnabled sets whether the function block should process batches
+func (b *BaseFunctionBlock) SetEnabled(enabled bool) {
+	b.disabled.Store(!enabled)
+}
+
+// Enabled returns whether the function block should process batches
+func (b *BaseFunctionBlock) Enabled() bool {
+	return !b.disabled.Load()
+}
+
+// SetHasDLQ sets whether the function block has a DLQ to send failed
+// batches to, from FBConfig.DLQ
+func (b *BaseFunctionBlock) SetHasDLQ(hasDLQ bool) {
+	b.noDLQ.Store(!hasDLQ)
+}
+
+// HasDLQ returns whether the function block has a DLQ. Without one, DLQ
+// senders return ErrNoDLQ and failed batches are returned to the caller, so
+// that backpressure propagates upstream.
+func (b *BaseFunctionBlock) HasDLQ() bool {
+	return !b.noDLQ.Load()
+}
+
+// BypassBatch skips processing and forwards the batch unchanged using forward.
+// Function blocks call this from ProcessBatch when Enabled returns false.
+func (b *BaseFunctionBlock) BypassBatch(ctx context.Context, batch *MetricBatch, forward ForwardFunc) (*ProcessResult, error) {
+	bypassedBatchesTotal.WithLabelValues(b.name).Inc()
+	return forward(ctx, batch)
+}
+
+// NewErrorResult creates a new error processing result. Use it where the
+// batch may have been sent to the DLQ; otherwise use NewCodeErrorResult.
 func NewErrorResult(batchID string, errCode ErrorCode, err error, sentToDLQ bool) *ProcessResult {
 	var errMsg string
 	if err != nil {
@@ -179,11 +388,18 @@ func NewErrorResult(batchID string, errCode ErrorCode, err error, sentToDLQ bool
 		Status:       StatusError,
 		ErrorMessage: errMsg,
 		ErrorCode:    errCode,
+		Retriable:    errCode.Retriable() && !sentToDLQ,
 		BatchID:      batchID,
 		SentToDLQ:    sentToDLQ,
 	}
 }
 
+// NewCodeErrorResult creates an error processing result for a batch that was
+// not sent to the DLQ, with retriability derived from the error code
+func NewCodeErrorResult(batchID string, errCode ErrorCode, err error) *ProcessResult {
+	return NewErrorResult(batchID, errCode, err, false)
+}
+
 // NewSuccessResult creates a new success processing result
 func NewSuccessResult(batchID string) *ProcessResult {
 	return &ProcessResult{
@@ -195,7 +411,9 @@ func NewSuccessResult(batchID string) *ProcessResult {
 // NewThrottledResult creates a new throttled processing result
 func NewThrottledResult(batchID string) *ProcessResult {
 	return &ProcessResult{
-		Status:  StatusThrottled,
-		BatchID: batchID,
+		Status:    StatusThrottled,
+		ErrorCode: ErrorCodeThrottled,
+		Retriable: ErrorCodeThrottled.Retriable(),
+		BatchID:   batchID,
 	}
 }
\ No newline at end of file
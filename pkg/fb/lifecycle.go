@@ -0,0 +1,178 @@
+package fb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// State represents the lifecycle state of a function block
+type State int32
+
+const (
+	// StateCreated means the function block has been constructed
+	StateCreated State = iota
+
+	// StateInitialized means Initialize has completed
+	StateInitialized
+
+	// StateConfigured means a configuration has been applied
+	StateConfigured
+
+	// StateRunning means the function block is processing batches
+	StateRunning
+
+	// StateDraining means the function block is shutting down
+	StateDraining
+
+	// StateStopped means the function block has shut down
+	StateStopped
+)
+
+// String returns the string representation of the state
+func (s State) String() string {
+	switch s {
+	case StateCreated:
+		return "CREATED"
+	case StateInitialized:
+		return "INITIALIZED"
+	case StateConfigured:
+		return "CONFIGURED"
+	case StateRunning:
+		return "RUNNING"
+	case StateDraining:
+		return "DRAINING"
+	case StateStopped:
+		return "STOPPED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Lifecycle errors
+var (
+	ErrIllegalTransition = errors.New("illegal lifecycle transition")
+	ErrNotRunning        = errors.New("function block is not running")
+)
+
+// validTransitions lists the states reachable from each state
+var validTransitions = map[State][]State{
+	StateCreated:     {StateInitialized, StateDraining},
+	StateInitialized: {StateConfigured, StateDraining},
+	StateConfigured:  {StateRunning, StateDraining},
+	StateRunning:     {StateDraining},
+	StateDraining:    {StateStopped},
+	StateStopped:     {},
+}
+
+var lifecycleState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "fb_lifecycle_state",
+	Help: "Lifecycle state of the function block (0=created, 1=initialized, 2=configured, 3=running, 4=draining, 5=stopped)",
+}, []string{"fb_name"})
+
+// canTransition returns whether to is reachable from from
+func canTransition(from, to State) bool {
+	for _, next := range validTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// State returns the current lifecycle state
+func (b *BaseFunctionBlock) State() State {
+	return State(atomic.LoadInt32(&b.state))
+}
+
+// Transition moves the function block to the given state, failing with
+// ErrIllegalTransition if it isn't reachable from the current state
+func (b *BaseFunctionBlock) Transition(to State) error {
+	for {
+		from := b.State()
+		if !canTransition(from, to) {
+			return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+		}
+		if atomic.CompareAndSwapInt32(&b.state, int32(from), int32(to)) {
+			lifecycleState.WithLabelValues(b.name).Set(float64(to))
+			b.updateHealth()
+			return nil
+		}
+	}
+}
+
+// ConfigApplied moves the function block to Running once a configuration has
+// been applied. Reconfiguring a running function block keeps it Running.
+func (b *BaseFunctionBlock) ConfigApplied() error {
+	if b.State() == StateRunning {
+		return nil
+	}
+	if err := b.Transition(StateConfigured); err != nil {
+		return err
+	}
+	return b.Transition(StateRunning)
+}
+
+// CheckRunning returns a retryable error result if the function block is not
+// Running
+func (b *BaseFunctionBlock) CheckRunning(batchID string) (*ProcessResult, error) {
+	if state := b.State(); state != StateRunning {
+		err := fmt.Errorf("%w: state is %s", ErrNotRunning, state)
+		return NewCodeErrorResult(batchID, ErrorCodeServiceUnavailable, err), err
+	}
+	return nil, nil
+}
+
+// BeginBatch checks that the function block is Running like CheckRunning and
+// counts the batch as in flight until the returned function is called, so
+// that DrainBatches waits for it. Function blocks call this at the start of
+// ProcessBatch instead of CheckRunning.
+func (b *BaseFunctionBlock) BeginBatch(batchID string) (func(), *ProcessResult, error) {
+	b.batchesMu.Lock()
+	defer b.batchesMu.Unlock()
+
+	if result, err := b.CheckRunning(batchID); err != nil {
+		return nil, result, err
+	}
+	b.batches++
+	return b.endBatch, nil, nil
+}
+
+// endBatch marks a batch started with BeginBatch as done
+func (b *BaseFunctionBlock) endBatch() {
+	b.batchesMu.Lock()
+	defer b.batchesMu.Unlock()
+
+	b.batches--
+	if b.batches == 0 && b.batchesDone != nil {
+		close(b.batchesDone)
+		b.batchesDone = nil
+	}
+}
+
+// DrainBatches waits until the batches started with BeginBatch are done, or
+// ctx is done. Function blocks call it in Shutdown once Draining, before
+// closing the connections, including the DLQ, those batches may still use.
+func (b *BaseFunctionBlock) DrainBatches(ctx context.Context) error {
+	b.batchesMu.Lock()
+	if b.batches == 0 {
+		b.batchesMu.Unlock()
+		return nil
+	}
+	if b.batchesDone == nil {
+		b.batchesDone = make(chan struct{})
+	}
+	done, pending := b.batchesDone, b.batches
+	b.batchesMu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %d batches still in flight: %v", ErrShutdownTimeout, pending, ctx.Err())
+	}
+}
@@ -0,0 +1,103 @@
+package fb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaseFunctionBlock_LifecycleHappyPath(t *testing.T) {
+	b := NewBaseFunctionBlock("fb-test")
+	assert.Equal(t, StateCreated, b.State())
+
+	assert.NoError(t, b.Transition(StateInitialized))
+	assert.NoError(t, b.ConfigApplied())
+	assert.Equal(t, StateRunning, b.State())
+
+	// Reconfiguring while running keeps the block running
+	assert.NoError(t, b.ConfigApplied())
+	assert.Equal(t, StateRunning, b.State())
+
+	assert.NoError(t, b.Transition(StateDraining))
+	assert.NoError(t, b.Transition(StateStopped))
+	assert.Equal(t, StateStopped, b.State())
+}
+
+func TestBaseFunctionBlock_IllegalTransitions(t *testing.T) {
+	tests := []struct {
+		name string
+		path []State
+		to   State
+	}{
+		{"created to running", nil, StateRunning},
+		{"created to configured", nil, StateConfigured},
+		{"initialized to running", []State{StateInitialized}, StateRunning},
+		{"running to initialized", []State{StateInitialized, StateConfigured, StateRunning}, StateInitialized},
+		{"draining to running", []State{StateDraining}, StateRunning},
+		{"stopped to running", []State{StateDraining, StateStopped}, StateRunning},
+		{"stopped to draining", []State{StateDraining, StateStopped}, StateDraining},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBaseFunctionBlock("fb-test")
+			for _, state := range tt.path {
+				assert.NoError(t, b.Transition(state))
+			}
+			from := b.State()
+
+			err := b.Transition(tt.to)
+			assert.True(t, errors.Is(err, ErrIllegalTransition))
+			assert.Equal(t, from, b.State())
+		})
+	}
+}
+
+func TestBaseFunctionBlock_ConfigAppliedBeforeInitialize(t *testing.T) {
+	b := NewBaseFunctionBlock("fb-test")
+
+	err := b.ConfigApplied()
+	assert.True(t, errors.Is(err, ErrIllegalTransition))
+	assert.Equal(t, StateCreated, b.State())
+}
+
+func TestBaseFunctionBlock_CheckRunning(t *testing.T) {
+	b := NewBaseFunctionBlock("fb-test")
+	assert.NoError(t, b.Transition(StateInitialized))
+
+	result, err := b.CheckRunning("batch-1")
+	assert.True(t, errors.Is(err, ErrNotRunning))
+	assert.Equal(t, StatusError, result.Status)
+	assert.Equal(t, ErrorCodeServiceUnavailable, result.ErrorCode)
+
+	assert.NoError(t, b.ConfigApplied())
+	result, err = b.CheckRunning("batch-1")
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+func TestBaseFunctionBlock_DrainBatches(t *testing.T) {
+	b := NewBaseFunctionBlock("fb-test")
+	assert.NoError(t, b.Transition(StateInitialized))
+	assert.NoError(t, b.ConfigApplied())
+
+	done, result, err := b.BeginBatch("batch-1")
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+
+	// Draining rejects new batches and waits for the one in flight
+	assert.NoError(t, b.Transition(StateDraining))
+	_, result, err = b.BeginBatch("batch-2")
+	assert.True(t, errors.Is(err, ErrNotRunning))
+	assert.Equal(t, ErrorCodeServiceUnavailable, result.ErrorCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.True(t, errors.Is(b.DrainBatches(ctx), ErrShutdownTimeout))
+
+	done()
+	assert.NoError(t, b.DrainBatches(context.Background()))
+}
@@ -0,0 +1,170 @@
+package fb
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// LabelSender is the internal label naming the function block instance that
+// sent a batch out of the chain
+const LabelSender = "fb_sender"
+
+// reservedLabels are the entries the chain relies on, which truncation keeps
+// even past the limits: dropping them would reset a batch's replay count,
+// skip its checksum verification, or lose its tenant or sender
+var reservedLabels = map[string]struct{}{
+	LabelReplayCount: {},
+	LabelChecksum:    {},
+	LabelTenantID:    {},
+	LabelSender:      {},
+}
+
+var metadataTruncatedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_metadata_truncated_total",
+	Help: "Total number of batch metadata or internal label maps over the function block's limits, by whether they were truncated or rejected",
+}, []string{"fb_name", "field", "action"})
+
+// MetadataLimitAction is what happens to a batch whose metadata maps exceed
+// the limits
+type MetadataLimitAction string
+
+// Metadata limit actions
+const (
+	// MetadataLimitReject rejects the batch with ErrorCodeMetadataTooLarge.
+	// It is the default.
+	MetadataLimitReject MetadataLimitAction = "reject"
+
+	// MetadataLimitTruncate drops entries until the map is within the
+	// limits, keeping the reserved labels and then those with the lowest
+	// keys, and processes the batch
+	MetadataLimitTruncate MetadataLimitAction = "truncate"
+)
+
+// MetadataLimits caps the Metadata and InternalLabels maps of the batches a
+// function block accepts. Each map is checked on its own.
+type MetadataLimits struct {
+	// MaxEntries is the maximum number of entries of a map. 0 means no limit.
+	MaxEntries int
+
+	// MaxBytes is the maximum total size of the keys and values of a map.
+	// 0 means no limit.
+	MaxBytes int
+
+	// Action is what happens to over-limit maps
+	Action MetadataLimitAction
+}
+
+// MetadataLimiter is implemented by function blocks that cap the metadata
+// maps of the batches they accept. BaseFunctionBlock implements it.
+type MetadataLimiter interface {
+	// MetadataLimits returns the limits
+	MetadataLimits() MetadataLimits
+}
+
+// SetMetadataLimits sets the limits on the metadata maps of the batches the
+// function block accepts, from FBConfig
+func (b *BaseFunctionBlock) SetMetadataLimits(limits MetadataLimits) {
+	atomic.StoreInt64(&b.maxMetadataEntries, int64(limits.MaxEntries))
+	atomic.StoreInt64(&b.maxMetadataBytes, int64(limits.MaxBytes))
+
+	var truncate int32
+	if limits.Action == MetadataLimitTruncate {
+		truncate = 1
+	}
+	atomic.StoreInt32(&b.truncateMetadata, truncate)
+}
+
+// MetadataLimits implements MetadataLimiter
+func (b *BaseFunctionBlock) MetadataLimits() MetadataLimits {
+	limits := MetadataLimits{
+		MaxEntries: int(atomic.LoadInt64(&b.maxMetadataEntries)),
+		MaxBytes:   int(atomic.LoadInt64(&b.maxMetadataBytes)),
+		Action:     MetadataLimitReject,
+	}
+	if atomic.LoadInt32(&b.truncateMetadata) == 1 {
+		limits.Action = MetadataLimitTruncate
+	}
+	return limits
+}
+
+// check returns why a map is over the limits, or nil if it isn't
+func (l MetadataLimits) check(m map[string]string) error {
+	if l.MaxEntries > 0 && len(m) > l.MaxEntries {
+		return fmt.Errorf("%d entries, limit is %d", len(m), l.MaxEntries)
+	}
+	if l.MaxBytes > 0 {
+		size := 0
+		for k, v := range m {
+			size += len(k) + len(v)
+		}
+		if size > l.MaxBytes {
+			return fmt.Errorf("%d bytes, limit is %d", size, l.MaxBytes)
+		}
+	}
+	return nil
+}
+
+// truncate drops entries from a map until it is within the limits. The
+// reserved labels are always kept and count towards the limits; of the other
+// entries those with the lowest keys are kept, so the result is deterministic.
+func (l MetadataLimits) truncate(m map[string]string) {
+	entries, size := 0, 0
+	keys := make([]string, 0, len(m))
+	for k, v := range m {
+		if _, ok := reservedLabels[k]; ok {
+			entries++
+			size += len(k) + len(v)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		entrySize := len(k) + len(m[k])
+		if (l.MaxEntries > 0 && entries >= l.MaxEntries) || (l.MaxBytes > 0 && size+entrySize > l.MaxBytes) {
+			delete(m, k)
+			continue
+		}
+		entries++
+		size += entrySize
+	}
+}
+
+// limitMetadata enforces the function block's metadata limits on a request,
+// truncating over-limit maps in place or returning an error if the request
+// is to be rejected
+func (h *ChainPushServiceHandler) limitMetadata(req *MetricBatchRequest) error {
+	limiter, ok := h.fb.(MetadataLimiter)
+	if !ok {
+		return nil
+	}
+	limits := limiter.MetadataLimits()
+	if limits.MaxEntries <= 0 && limits.MaxBytes <= 0 {
+		return nil
+	}
+
+	for _, field := range []struct {
+		name string
+		m    map[string]string
+	}{
+		{"metadata", req.Metadata},
+		{"internal_labels", req.InternalLabels},
+	} {
+		err := limits.check(field.m)
+		if err == nil {
+			continue
+		}
+
+		metadataTruncatedTotal.WithLabelValues(h.fb.Name(), field.name, string(limits.Action)).Inc()
+		if limits.Action != MetadataLimitTruncate {
+			return fmt.Errorf("batch %s %s too large: %w", req.BatchId, field.name, err)
+		}
+		limits.truncate(field.m)
+	}
+	return nil
+}
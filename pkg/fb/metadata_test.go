@@ -0,0 +1,128 @@
+package fb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingFB is a function block that records the batches it processes
+type recordingFB struct {
+	BaseFunctionBlock
+	batches []*MetricBatch
+}
+
+func (r *recordingFB) Initialize(ctx context.Context) error { return nil }
+
+func (r *recordingFB) ProcessBatch(ctx context.Context, batch *MetricBatch) (*ProcessResult, error) {
+	r.batches = append(r.batches, batch)
+	return NewSuccessResult(batch.BatchID), nil
+}
+
+func (r *recordingFB) UpdateConfig(ctx context.Context, configBytes []byte, generation int64) error {
+	return nil
+}
+
+func (r *recordingFB) ValidateConfig(configBytes []byte) error { return nil }
+
+func (r *recordingFB) Shutdown(ctx context.Context) error { return nil }
+
+// oversizedLabels returns n internal labels
+func oversizedLabels(n int) map[string]string {
+	labels := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		labels[fmt.Sprintf("label-%03d", i)] = "value"
+	}
+	return labels
+}
+
+func TestChainPushServiceHandler_RejectsOversizedMetadata(t *testing.T) {
+	block := &recordingFB{BaseFunctionBlock: NewBaseFunctionBlock("fb-metadata-reject-test")}
+	block.SetMetadataLimits(MetadataLimits{MaxEntries: 10})
+	handler := NewChainPushServiceHandler(block)
+
+	rejected := metadataTruncatedTotal.WithLabelValues("fb-metadata-reject-test", "internal_labels", "reject")
+
+	res, err := handler.PushMetrics(context.Background(), &MetricBatchRequest{
+		BatchId:        "batch-1",
+		InternalLabels: oversizedLabels(1000),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatusError, res.Status)
+	assert.Equal(t, string(ErrorCodeMetadataTooLarge), res.ErrorCode)
+	assert.Contains(t, res.ErrorMessage, "internal_labels")
+	assert.Empty(t, block.batches)
+	assert.Equal(t, float64(1), testutil.ToFloat64(rejected))
+
+	// Batches within the limits are processed
+	res, err = handler.PushMetrics(context.Background(), &MetricBatchRequest{
+		BatchId:        "batch-2",
+		InternalLabels: oversizedLabels(10),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatusSuccess, res.Status)
+	require.Len(t, block.batches, 1)
+}
+
+func TestChainPushServiceHandler_TruncatesOversizedMetadata(t *testing.T) {
+	block := &recordingFB{BaseFunctionBlock: NewBaseFunctionBlock("fb-metadata-truncate-test")}
+	block.SetMetadataLimits(MetadataLimits{MaxEntries: 10, MaxBytes: 100, Action: MetadataLimitTruncate})
+	handler := NewChainPushServiceHandler(block)
+
+	truncated := metadataTruncatedTotal.WithLabelValues("fb-metadata-truncate-test", "internal_labels", "truncate")
+
+	res, err := handler.PushMetrics(context.Background(), &MetricBatchRequest{
+		BatchId:        "batch-1",
+		Metadata:       map[string]string{"source": "test"},
+		InternalLabels: oversizedLabels(1000),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatusSuccess, res.Status)
+	assert.Equal(t, float64(1), testutil.ToFloat64(truncated))
+
+	// The labels with the lowest keys are kept, up to 100 bytes
+	require.Len(t, block.batches, 1)
+	assert.Equal(t, map[string]string{
+		"label-000": "value",
+		"label-001": "value",
+		"label-002": "value",
+		"label-003": "value",
+		"label-004": "value",
+		"label-005": "value",
+		"label-006": "value",
+	}, block.batches[0].InternalLabels)
+	assert.Equal(t, map[string]string{"source": "test"}, block.batches[0].Metadata)
+}
+
+func TestMetadataLimits_TruncateKeepsReservedLabels(t *testing.T) {
+	labels := oversizedLabels(20)
+	labels[LabelReplayCount] = "2"
+	labels[LabelChecksum] = "abc123"
+	labels[LabelTenantID] = "acme"
+	labels[LabelSender] = "fb-cl-0"
+
+	// The reserved labels are kept whatever their keys, and count towards
+	// the limits
+	MetadataLimits{MaxEntries: 6, Action: MetadataLimitTruncate}.truncate(labels)
+	assert.Equal(t, map[string]string{
+		"label-000":      "value",
+		"label-001":      "value",
+		LabelReplayCount: "2",
+		LabelChecksum:    "abc123",
+		LabelTenantID:    "acme",
+		LabelSender:      "fb-cl-0",
+	}, labels)
+
+	// They are kept even when they alone are over the limits
+	MetadataLimits{MaxEntries: 1, MaxBytes: 10, Action: MetadataLimitTruncate}.truncate(labels)
+	assert.Equal(t, map[string]string{
+		LabelReplayCount: "2",
+		LabelChecksum:    "abc123",
+		LabelTenantID:    "acme",
+		LabelSender:      "fb-cl-0",
+	}, labels)
+}
@@ -49,6 +49,9 @@ type MetricBatchResponse struct {
 	
 	// Batch ID echo
 	BatchId string `json:"batch_id"`
+	
+	// How long to wait before retrying a throttled batch, in milliseconds
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
 }
 
 // chainPushServiceClient is an implementation of ChainPushServiceClient.
@@ -64,6 +67,7 @@ func NewChainPushServiceClient(cc *grpc.ClientConn) ChainPushServiceClient {
 // PushMetrics pushes a batch of metrics to the next FB in the chain
 func (c *chainPushServiceClient) PushMetrics(ctx context.Context, in *MetricBatchRequest, opts ...grpc.CallOption) (*MetricBatchResponse, error) {
 	out := new(MetricBatchResponse)
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(chainCodecName)}, opts...)
 	err := c.cc.Invoke(ctx, "/nrdot.api.v1.ChainPushService/PushMetrics", in, out, opts...)
 	if err != nil {
 		return nil, err
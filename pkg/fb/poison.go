@@ -0,0 +1,75 @@
+package fb
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// LabelReplayCount is the internal label counting a batch's hops through the
+// DLQ. It is incremented each time an FB sends the batch to the DLQ and each
+// time the batch is replayed from it.
+const LabelReplayCount = "replay_count"
+
+// ErrPoisonBatch is returned by function blocks' DLQ senders for batches
+// dropped by RecordDLQHop instead of being sent to the DLQ
+var ErrPoisonBatch = errors.New("poison batch dropped: maximum replay count exceeded")
+
+var poisonBatchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_poison_batches_total",
+	Help: "Total number of batches dropped instead of sent to the DLQ because they exceeded the maximum replay count",
+}, []string{"fb_name"})
+
+// ReplayCount returns the replay count in a batch's internal labels, or 0 if
+// there is none or it isn't a number
+func ReplayCount(labels map[string]string) int {
+	count, err := strconv.Atoi(labels[LabelReplayCount])
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+// IncrementReplayCount increments the replay count in labels, which must not
+// be nil, and returns the new count
+func IncrementReplayCount(labels map[string]string) int {
+	count := ReplayCount(labels) + 1
+	labels[LabelReplayCount] = strconv.Itoa(count)
+	return count
+}
+
+// SetMaxReplayCount sets how many hops through the DLQ a batch may make, from
+// FBConfig.MaxReplayCount. 0 removes the limit.
+func (b *BaseFunctionBlock) SetMaxReplayCount(max int) {
+	atomic.StoreInt64(&b.maxReplayCount, int64(max))
+}
+
+// RecordDLQHop increments the replay count in the internal labels, which
+// must not be nil, of a batch about to be sent to the DLQ, and reports
+// whether the count now exceeds the maximum. Such a batch is a poison pill:
+// the FB drops it instead of sending it to the DLQ, so it can't cycle
+// through replays forever.
+func (b *BaseFunctionBlock) RecordDLQHop(labels map[string]string) bool {
+	count := IncrementReplayCount(labels)
+
+	max := atomic.LoadInt64(&b.maxReplayCount)
+	if max <= 0 || int64(count) <= max {
+		return false
+	}
+	poisonBatchesTotal.WithLabelValues(b.name).Inc()
+	return true
+}
+
+// NewDLQErrorResult creates the result of a batch the function block failed to
+// send to the DLQ with dlqErr. Poison batches get ErrorCodePoisonBatch rather
+// than ErrorCodeDLQSendFailed, so upstream knows the batch was dropped and
+// doesn't retry it.
+func NewDLQErrorResult(batchID string, dlqErr error) *ProcessResult {
+	if errors.Is(dlqErr, ErrPoisonBatch) {
+		return NewCodeErrorResult(batchID, ErrorCodePoisonBatch, dlqErr)
+	}
+	return NewCodeErrorResult(batchID, ErrorCodeDLQSendFailed, dlqErr)
+}
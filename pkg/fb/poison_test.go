@@ -0,0 +1,52 @@
+package fb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordDLQHop_DropsBatchesOverMaxReplayCount(t *testing.T) {
+	block := NewBaseFunctionBlock("fb-poison-test")
+	poisoned := poisonBatchesTotal.WithLabelValues("fb-poison-test")
+
+	// Without a limit batches always go to the DLQ
+	labels := map[string]string{LabelReplayCount: "100"}
+	assert.False(t, block.RecordDLQHop(labels))
+	assert.Equal(t, 101, ReplayCount(labels))
+
+	block.SetMaxReplayCount(3)
+
+	// Each hop is counted, from 0 for a batch that never went through the DLQ
+	labels = map[string]string{}
+	for hop := 1; hop <= 3; hop++ {
+		assert.False(t, block.RecordDLQHop(labels))
+		assert.Equal(t, hop, ReplayCount(labels))
+	}
+	assert.Equal(t, float64(0), testutil.ToFloat64(poisoned))
+
+	// The hop over the limit makes the batch a poison pill
+	assert.True(t, block.RecordDLQHop(labels))
+	assert.Equal(t, "4", labels[LabelReplayCount])
+	assert.Equal(t, float64(1), testutil.ToFloat64(poisoned))
+
+	// A malformed count starts over
+	labels = map[string]string{LabelReplayCount: "many"}
+	assert.False(t, block.RecordDLQHop(labels))
+	assert.Equal(t, 1, ReplayCount(labels))
+}
+
+func TestNewDLQErrorResult(t *testing.T) {
+	result := NewDLQErrorResult("batch-1", fmt.Errorf("batch batch-1: %w", ErrPoisonBatch))
+	assert.Equal(t, ErrorCodePoisonBatch, result.ErrorCode)
+	assert.False(t, result.SentToDLQ)
+	assert.False(t, result.Retriable)
+
+	result = NewDLQErrorResult("batch-2", errors.New("no connection to DLQ"))
+	assert.Equal(t, ErrorCodeDLQSendFailed, result.ErrorCode)
+	assert.False(t, result.SentToDLQ)
+	assert.True(t, result.Retriable)
+}
@@ -0,0 +1,103 @@
+package fb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var quarantinedBatchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_quarantined_batches_total",
+	Help: "Total number of batches sent to the quarantine instead of the DLQ because they failed with a non-retriable error",
+}, []string{"fb_name", "error_code"})
+
+// quarantine is a connection to the quarantine destination, from
+// FBConfig.Quarantine
+type quarantine struct {
+	addr   string
+	conn   *grpc.ClientConn
+	client ChainPushServiceClient
+}
+
+// ConnectQuarantine connects the function block to the quarantine at addr,
+// replacing any previous connection. An empty addr disconnects it, and
+// batches failing with non-retriable errors go to the DLQ again.
+func (b *BaseFunctionBlock) ConnectQuarantine(ctx context.Context, addr string) error {
+	if current := b.quarantine.Load(); current != nil && current.addr == addr {
+		return nil
+	}
+
+	var next *quarantine
+	if addr != "" {
+		conn, err := b.DialContext(ctx, addr,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithBlock(),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to connect to quarantine: %w", err)
+		}
+		next = &quarantine{addr: addr, conn: conn, client: NewChainPushServiceClient(conn)}
+	}
+
+	if previous := b.quarantine.Swap(next); previous != nil && previous.conn != nil {
+		previous.conn.Close()
+	}
+	return nil
+}
+
+// CloseQuarantine closes the connection to the quarantine, if any
+func (b *BaseFunctionBlock) CloseQuarantine() {
+	if previous := b.quarantine.Swap(nil); previous != nil && previous.conn != nil {
+		previous.conn.Close()
+	}
+}
+
+// SetQuarantineClientForTesting sets the quarantine client for testing
+// purposes
+func (b *BaseFunctionBlock) SetQuarantineClientForTesting(client ChainPushServiceClient) {
+	b.quarantine.Store(&quarantine{addr: "testing", client: client})
+}
+
+// SendFailedBatch sends a batch that failed with code out of the chain.
+// Replaying a batch that failed with a non-retriable code, such as
+// ErrorCodeInvalidInput or ErrorCodePIILeak, can't fix it, so such batches
+// go to the quarantine when the block has one, keeping the DLQ to failures
+// replay can fix. Other batches, and all batches of a block without a
+// quarantine, are sent to the DLQ with sendToDLQ.
+func (b *BaseFunctionBlock) SendFailedBatch(ctx context.Context, batch *MetricBatch, code ErrorCode, err error, sendToDLQ DLQFunc) error {
+	q := b.quarantine.Load()
+	if q == nil || code.Retriable() {
+		return sendToDLQ(ctx, batch, err)
+	}
+
+	labels := make(map[string]string, len(batch.InternalLabels)+3)
+	for k, v := range batch.InternalLabels {
+		labels[k] = v
+	}
+	labels["error"] = err.Error()
+	labels["error_code"] = string(code)
+	labels[LabelSender] = b.sender
+
+	res, pushErr := q.client.PushMetrics(ctx, &MetricBatchRequest{
+		BatchId:          batch.BatchID,
+		Data:             batch.Data,
+		Format:           batch.Format,
+		Replay:           batch.Replay,
+		ConfigGeneration: batch.ConfigGeneration,
+		Metadata:         batch.Metadata,
+		InternalLabels:   labels,
+	})
+	if pushErr != nil {
+		return fmt.Errorf("failed to push metrics to quarantine: %w", pushErr)
+	}
+	if res.Status != StatusSuccess {
+		return fmt.Errorf("quarantine returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+	}
+
+	quarantinedBatchesTotal.WithLabelValues(b.name, string(code)).Inc()
+	return nil
+}
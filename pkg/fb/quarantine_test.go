@@ -0,0 +1,57 @@
+package fb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestSendFailedBatch(t *testing.T) {
+	block := NewBaseFunctionBlock("fb-quarantine-test")
+	batch := &MetricBatch{BatchID: "batch-1", Data: []byte(`[]`), InternalLabels: map[string]string{"tenant": "a"}}
+
+	var dlqErrs []error
+	sendToDLQ := func(ctx context.Context, batch *MetricBatch, err error) error {
+		dlqErrs = append(dlqErrs, err)
+		return nil
+	}
+
+	// Without a quarantine every failure goes to the DLQ
+	require.NoError(t, block.SendFailedBatch(context.Background(), batch, ErrorCodeInvalidInput, errors.New("bad input"), sendToDLQ))
+	assert.Len(t, dlqErrs, 1)
+
+	var quarantined []*MetricBatchRequest
+	block.SetQuarantineClientForTesting(&MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *MetricBatchRequest, opts ...grpc.CallOption) (*MetricBatchResponse, error) {
+			quarantined = append(quarantined, in)
+			return &MetricBatchResponse{Status: StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	// Non-retriable failures go to the quarantine, labelled with their code
+	require.NoError(t, block.SendFailedBatch(context.Background(), batch, ErrorCodePIILeak, errors.New("leak"), sendToDLQ))
+	assert.Len(t, dlqErrs, 1)
+	require.Len(t, quarantined, 1)
+	assert.Equal(t, string(ErrorCodePIILeak), quarantined[0].InternalLabels["error_code"])
+	assert.Equal(t, "leak", quarantined[0].InternalLabels["error"])
+	assert.Equal(t, "fb-quarantine-test", quarantined[0].InternalLabels["fb_sender"])
+	assert.Equal(t, "a", quarantined[0].InternalLabels["tenant"])
+	assert.NotContains(t, batch.InternalLabels, "error_code")
+	assert.Equal(t, float64(1), testutil.ToFloat64(quarantinedBatchesTotal.WithLabelValues("fb-quarantine-test", string(ErrorCodePIILeak))))
+
+	// Retriable failures still go to the DLQ
+	require.NoError(t, block.SendFailedBatch(context.Background(), batch, ErrorCodeForwardingFailed, errors.New("unavailable"), sendToDLQ))
+	assert.Len(t, dlqErrs, 2)
+	assert.Len(t, quarantined, 1)
+
+	// Disconnecting sends non-retriable failures to the DLQ again
+	require.NoError(t, block.ConnectQuarantine(context.Background(), ""))
+	require.NoError(t, block.SendFailedBatch(context.Background(), batch, ErrorCodeInvalidInput, errors.New("bad input"), sendToDLQ))
+	assert.Len(t, dlqErrs, 3)
+	assert.Len(t, quarantined, 1)
+}
@@ -0,0 +1,285 @@
+// Package recording captures the requests an FB receives to a file, so a
+// production issue can be reproduced by replaying them against a local
+// pipeline. A recording is a sequence of records, each framed by its length
+// and CRC like the FB-RX WAL, holding a request and the time it arrived.
+// Recordings rotate: the current file is moved aside to a numbered one once
+// it reaches its size limit, and the oldest files are removed.
+//
+// Recordings hold requests exactly as received, before FB-CL hashes or drops
+// PII, so they contain raw personal data and must be handled accordingly.
+package recording
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"eidc-tfk8s/pkg/fb"
+)
+
+// Defaults of the recording limits
+const (
+	DefaultMaxBytes = 64 << 20
+	DefaultMaxFiles = 5
+)
+
+// headerSize is the size of a record's length and CRC
+const headerSize = 8
+
+// MaxRecordSize bounds the size of a record. It is well above the requests
+// FBs accept, so a larger length in a header can only be corruption, and is
+// rejected before its payload is allocated.
+const MaxRecordSize = 64 << 20
+
+// ErrCorrupt is returned when a record fails its CRC or doesn't decode
+var ErrCorrupt = errors.New("corrupt recording")
+
+// Record is a request as it was received
+type Record struct {
+	// Time is when the request arrived
+	Time time.Time `json:"time"`
+
+	Request *fb.MetricBatchRequest `json:"request"`
+}
+
+// Writer appends records to a recording, rotating its file once it reaches
+// MaxBytes and keeping at most MaxFiles files
+type Writer struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// NewWriter opens the recording at path, appending to it if it exists. Zero
+// limits take their defaults.
+func NewWriter(path string, maxBytes int64, maxFiles int) (*Writer, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxFiles
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+
+	w := &Writer{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Path returns the path of the current file of the recording
+func (w *Writer) Path() string {
+	return w.path
+}
+
+// open opens the current file for appending. w.mu must be held, or w not
+// yet shared.
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open recording: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open recording: %w", err)
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// Write records a request that arrived at a time. Records aren't synced to
+// disk: a crash loses at most the last ones.
+func (w *Writer) Write(req *fb.MetricBatchRequest, at time.Time) error {
+	payload, err := json.Marshal(&Record{Time: at, Request: req})
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	if len(payload) > MaxRecordSize {
+		return fmt.Errorf("record of %d bytes exceeds the %d byte limit", len(payload), MaxRecordSize)
+	}
+	frame := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[headerSize:], payload)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return fmt.Errorf("recording %s is closed", w.path)
+	}
+	if w.size > 0 && w.size+int64(len(frame)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(frame)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write recording: %w", err)
+	}
+	return nil
+}
+
+// rotate moves the current file aside as path.1, shifting the older files
+// up and removing those beyond MaxFiles, and opens a new current file.
+// w.mu must be held.
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to rotate recording: %w", err)
+	}
+	w.file = nil
+
+	os.Remove(rotatedPath(w.path, w.maxFiles-1))
+	for i := w.maxFiles - 2; i >= 1; i-- {
+		if err := os.Rename(rotatedPath(w.path, i), rotatedPath(w.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate recording: %w", err)
+		}
+	}
+	if w.maxFiles > 1 {
+		if err := os.Rename(w.path, rotatedPath(w.path, 1)); err != nil {
+			return fmt.Errorf("failed to rotate recording: %w", err)
+		}
+	} else if err := os.Remove(w.path); err != nil {
+		return fmt.Errorf("failed to rotate recording: %w", err)
+	}
+	return w.open()
+}
+
+// Close closes the recording
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// rotatedPath returns the path of the file rotated n times
+func rotatedPath(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// Files returns the files of the recording at path, oldest first: the
+// rotated files, then the current one
+func Files(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := make(map[int]string)
+	var order []int
+	for _, match := range matches {
+		n, err := strconv.Atoi(strings.TrimPrefix(match, path+"."))
+		if err != nil || n < 1 {
+			continue
+		}
+		rotated[n] = match
+		order = append(order, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(order)))
+
+	files := make([]string, 0, len(order)+1)
+	for _, n := range order {
+		files = append(files, rotated[n])
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recording at %s", path)
+	}
+	return files, nil
+}
+
+// Reader reads the records of a recording file
+type Reader struct {
+	r io.Reader
+}
+
+// NewReader returns a reader of the records in r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Next returns the next record, or io.EOF at the end of the recording. A
+// record torn by a crash ends it too. A length over MaxRecordSize is
+// reported as ErrCorrupt.
+func (r *Reader) Next() (*Record, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		return nil, io.EOF
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > MaxRecordSize {
+		return nil, ErrCorrupt
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return nil, io.EOF
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, ErrCorrupt
+	}
+
+	var rec Record
+	if err := json.Unmarshal(payload, &rec); err != nil || rec.Request == nil {
+		return nil, ErrCorrupt
+	}
+	return &rec, nil
+}
+
+// ReadAll returns the records of the recording at path, across its rotated
+// files, oldest first
+func ReadAll(path string) ([]*Record, error) {
+	files, err := Files(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		r := NewReader(file)
+		for {
+			rec, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			records = append(records, rec)
+		}
+		file.Close()
+	}
+	return records, nil
+}
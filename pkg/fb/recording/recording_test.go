@@ -0,0 +1,78 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eidc-tfk8s/pkg/fb"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter_RotatesAndKeepsMaxFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rx.rec")
+	w, err := NewWriter(path, 300, 3)
+	require.NoError(t, err)
+
+	start := time.Unix(1700000000, 0).UTC()
+	for i := 0; i < 10; i++ {
+		req := &fb.MetricBatchRequest{BatchId: fmt.Sprintf("batch-%d", i), Data: []byte(`[{"name":"cpu","value":1}]`), Format: "json"}
+		require.NoError(t, w.Write(req, start.Add(time.Duration(i)*time.Second)))
+	}
+	require.NoError(t, w.Close())
+
+	// Only the newest files are kept, and none is over the limit
+	files, err := Files(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{path + ".2", path + ".1", path}, files)
+	for _, name := range files {
+		info, err := os.Stat(name)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(300))
+	}
+
+	// The records kept read back in order, ending with the last written
+	records, err := ReadAll(path)
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	assert.Less(t, len(records), 10)
+	for i, rec := range records {
+		n := 10 - len(records) + i
+		assert.Equal(t, fmt.Sprintf("batch-%d", n), rec.Request.BatchId)
+		assert.True(t, start.Add(time.Duration(n)*time.Second).Equal(rec.Time))
+	}
+}
+
+func TestReadAll_StopsAtTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rx.rec")
+	w, err := NewWriter(path, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(&fb.MetricBatchRequest{BatchId: "batch-1"}, time.Now()))
+	require.NoError(t, w.Write(&fb.MetricBatchRequest{BatchId: "batch-2"}, time.Now()))
+	require.NoError(t, w.Close())
+
+	// A crash cut the last record short
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-5))
+
+	records, err := ReadAll(path)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "batch-1", records[0].Request.BatchId)
+}
+
+func TestReader_RejectsOversizedRecord(t *testing.T) {
+	// A corrupt header claiming a 4 GiB record
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[:4], 0xFFFFFFFF)
+
+	_, err := NewReader(bytes.NewReader(header)).Next()
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
@@ -0,0 +1,30 @@
+package fb
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+// RegisterReflection registers the gRPC server reflection service on server
+// if enabled, so operators can list and call its services with tools like
+// grpcurl without the proto files on hand. Reflection exposes the server's
+// whole API, so it is meant for debugging and is off in production. Call it
+// after registering the server's other services and before it starts
+// serving.
+func RegisterReflection(server *grpc.Server, enabled bool) {
+	if enabled {
+		reflection.Register(server)
+	}
+}
+
+// SetReflectionEnabled sets whether RegisterReflectionServer registers the
+// gRPC reflection service
+func (b *BaseFunctionBlock) SetReflectionEnabled(enabled bool) {
+	b.reflection = enabled
+}
+
+// RegisterReflectionServer registers the gRPC reflection service on server if
+// enabled with SetReflectionEnabled
+func (b *BaseFunctionBlock) RegisterReflectionServer(server *grpc.Server) {
+	RegisterReflection(server, b.reflection)
+}
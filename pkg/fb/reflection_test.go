@@ -0,0 +1,71 @@
+package fb
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// listServices lists the services of a server serving b's ChainPushService
+// over an in-memory listener, the way grpcurl does
+func listServices(t *testing.T, b *BaseFunctionBlock) ([]string, error) {
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	RegisterChainPushServiceServer(server, &UnimplementedChainPushServiceServer{})
+	b.RegisterReflectionServer(server)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	require.NoError(t, err)
+	if err := stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}); err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	var services []string
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		services = append(services, service.GetName())
+	}
+	return services, nil
+}
+
+func TestRegisterReflectionServer_Enabled(t *testing.T) {
+	b := NewBaseFunctionBlock("fb-test")
+	b.SetReflectionEnabled(true)
+
+	services, err := listServices(t, &b)
+	require.NoError(t, err)
+	assert.Contains(t, services, "nrdot.api.v1.ChainPushService")
+	assert.Contains(t, services, "grpc.reflection.v1.ServerReflection")
+}
+
+func TestRegisterReflectionServer_OffByDefault(t *testing.T) {
+	b := NewBaseFunctionBlock("fb-test")
+
+	_, err := listServices(t, &b)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
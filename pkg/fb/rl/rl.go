@@ -0,0 +1,375 @@
+package rl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/common/metrics"
+	"eidc-tfk8s/internal/common/relabel"
+	"eidc-tfk8s/internal/common/tracing"
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var metricsDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "fb_rl_metrics_dropped_total",
+	Help: "Total number of metrics dropped by keep/drop relabel rules",
+})
+
+// RLConfig contains configuration for the Relabel function block
+type RLConfig struct {
+	// Common configuration
+	Common config.FBConfig `json:"common"`
+
+	// RL-specific configuration
+	Rules []relabel.Rule `json:"rules"`
+}
+
+// Metric is the internal metric representation the relabel stage operates on.
+// Fields other than name and labels are passed through unchanged.
+type Metric = codec.Metric
+
+// RL implements the FB-RL (Relabel) function block. Its connections and
+// lifecycle are the BaseFunctionBlock's.
+type RL struct {
+	fb.BaseFunctionBlock
+	logger    *logging.Logger
+	metrics   *metrics.FBMetrics
+	tracer    *tracing.Tracer
+	config    *RLConfig
+	relabeler *relabel.Relabeler
+	configMu  sync.RWMutex
+}
+
+// NewRL creates a new Relabel function block
+func NewRL() *RL {
+	return &RL{
+		BaseFunctionBlock: fb.NewBaseFunctionBlock("fb-rl"),
+		logger:            logging.NewLogger("fb-rl"),
+		metrics:           metrics.NewFBMetrics("fb-rl"),
+		tracer:            tracing.NewTracer("fb-rl"),
+	}
+}
+
+// Initialize initializes the Relabel function block
+func (r *RL) Initialize(ctx context.Context) error {
+	r.logger.Info("Initializing FB-RL", nil)
+
+	return r.InitializeBlock()
+}
+
+// ProcessBatch processes a batch of metrics
+func (r *RL) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	// Create child span for the batch processing
+	ctx, span := r.tracer.StartSpan(ctx, "process-batch")
+	defer span.End()
+
+	// Record metric
+	r.metrics.RecordBatchReceived()
+	r.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeReceived)
+
+	// Reject batches until config has been applied, and while shutting down
+	done, result, err := r.BeginBatch(batch.BatchID)
+	if err != nil {
+		return result, err
+	}
+	defer done()
+
+	// Send batches whose data was corrupted in transit to the DLQ
+	if result, err := r.VerifyChecksum(ctx, batch, r.sendToDLQ); err != nil {
+		return result, err
+	}
+
+	// Forward unchanged if this FB is disabled in the pipeline config
+	if !r.Enabled() {
+		return r.BypassBatch(ctx, batch, r.forwardToNextFB)
+	}
+
+	// Capture the batch as received if the debug tap samples it
+	r.CaptureBatch(ctx, fb.DebugStageIn, batch)
+
+	startTime := time.Now()
+
+	// Process batch
+	processingErr := r.processBatch(ctx, batch)
+	if processingErr != nil {
+		r.metrics.RecordProcessingError()
+
+		// Batches this FB can't decode won't succeed on retry
+		if errors.Is(processingErr, codec.ErrUnknownFormat) {
+			if dlqErr := r.SendFailedBatch(ctx, batch, fb.ErrorCodeInvalidInput, processingErr, r.sendToDLQ); dlqErr != nil {
+				if errors.Is(dlqErr, fb.ErrNoDLQ) {
+					return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeInvalidInput, processingErr), processingErr
+				}
+				r.logger.Error("Failed to send undecodable batch out of the chain", dlqErr, map[string]interface{}{
+					"batch_id": batch.BatchID,
+					"format":   batch.Format,
+				})
+				return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
+			}
+			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeInvalidInput, processingErr, true), processingErr
+		}
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeProcessingFailed, processingErr), processingErr
+	}
+
+	// Record processing metrics
+	r.metrics.RecordBatchProcessed(time.Since(startTime).Seconds())
+
+	// Capture the batch as forwarded if the debug tap samples it
+	r.CaptureBatch(ctx, fb.DebugStageOut, batch)
+
+	// Forward to next FB
+	forwardingResult, forwardingErr := r.forwardToNextFB(ctx, batch)
+	if forwardingErr != nil {
+		// Batches the next FB rejected for good go to the quarantine, if
+		// any, and are reported upstream with its code so they aren't retried
+		code := fb.ForwardingErrorCode(forwardingErr)
+		dlqErr := r.SendFailedBatch(ctx, batch, code, forwardingErr, r.sendToDLQ)
+		if dlqErr != nil {
+			if errors.Is(dlqErr, fb.ErrNoDLQ) {
+				return fb.NewCodeErrorResult(batch.BatchID, code, forwardingErr), forwardingErr
+			}
+			r.logger.ErrorCtx(ctx, "Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
+				"batch_id": batch.BatchID,
+			})
+			return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
+		}
+
+		// Return error with DLQ status
+		return fb.NewErrorResult(batch.BatchID, code, forwardingErr, true), forwardingErr
+	}
+
+	return forwardingResult, nil
+}
+
+// processBatch applies the relabel rules to every metric in the batch
+func (r *RL) processBatch(ctx context.Context, batch *fb.MetricBatch) error {
+	// Create child span for relabeling
+	ctx, span := r.tracer.StartSpan(ctx, "relabel")
+	defer span.End()
+
+	r.configMu.RLock()
+	relabeler := r.relabeler
+	r.configMu.RUnlock()
+
+	if relabeler == nil {
+		return nil
+	}
+
+	// Deserialize the batch data
+	input, err := codec.Decode(batch)
+	if err != nil {
+		return err
+	}
+
+	output := make([]Metric, 0, len(input))
+	for _, metric := range input {
+		if relabelMetric(relabeler, metric) {
+			output = append(output, metric)
+		}
+	}
+
+	if dropped := len(input) - len(output); dropped > 0 {
+		metricsDroppedTotal.Add(float64(dropped))
+		r.logger.Debug("Dropped metrics", map[string]interface{}{
+			"batch_id": batch.BatchID,
+			"count":    dropped,
+		})
+	}
+
+	return codec.Encode(batch, output)
+}
+
+// relabelMetric applies the rules to a metric's name and labels in place and
+// returns false if the metric should be dropped
+func relabelMetric(relabeler *relabel.Relabeler, metric Metric) bool {
+	labels := make(map[string]string)
+	if raw, ok := metric["labels"].(map[string]interface{}); ok {
+		for k, v := range raw {
+			labels[k] = fmt.Sprint(v)
+		}
+	}
+	if name, ok := metric["name"].(string); ok {
+		labels[relabel.MetricNameLabel] = name
+	}
+
+	result, keep := relabeler.Process(labels)
+	if !keep {
+		return false
+	}
+
+	if name, ok := result[relabel.MetricNameLabel]; ok {
+		metric["name"] = name
+		delete(result, relabel.MetricNameLabel)
+	}
+	metric["labels"] = result
+
+	return true
+}
+
+// forwardToNextFB forwards the batch to the next function block
+func (r *RL) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	return r.ForwardToNextFB(ctx, batch, r.sendToDLQ)
+}
+
+// sendToDLQ sends a batch to the Dead Letter Queue
+func (r *RL) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr error) error {
+	err := r.SendToDLQ(ctx, batch, originalErr)
+	if errors.Is(err, fb.ErrPoisonBatch) {
+		r.logger.Warn("Dropping poison batch", map[string]interface{}{
+			"batch_id":     batch.BatchID,
+			"replay_count": batch.InternalLabels[fb.LabelReplayCount],
+			"error":        originalErr.Error(),
+		})
+	}
+	return err
+}
+
+// UpdateConfig updates the Relabel function block's configuration
+func (r *RL) UpdateConfig(ctx context.Context, configBytes []byte, generation int64) error {
+	// Create child span for config update
+	ctx, span := r.tracer.StartSpan(ctx, "update-config")
+	defer span.End()
+
+	// Parse and validate configuration
+	newConfig, err := r.parseConfig(configBytes)
+	if err != nil {
+		return err
+	}
+
+	// Refuse a generation older than the one applied, unless rolling back
+	if err := r.CheckConfigGeneration(generation, newConfig.Common.Rollback); err != nil {
+		r.logger.Error("Refused configuration", err, map[string]interface{}{
+			"generation":         generation,
+			"applied_generation": r.GetConfigGeneration(),
+		})
+		return err
+	}
+
+	// Compile the rules; validateConfig has already checked them
+	relabeler, err := relabel.New(newConfig.Rules)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	// Apply configuration
+	r.configMu.Lock()
+	oldConfig := r.config
+	r.config = newConfig
+	r.relabeler = relabeler
+	r.ApplyCommonConfig(newConfig.Common, generation)
+	if level, err := logging.ParseLevel(newConfig.Common.LogLevel); err == nil {
+		r.logger.SetLevel(level)
+	}
+	if newConfig.Common.TracingEnabled {
+		r.tracer.SetSamplingRatio(newConfig.Common.TraceSamplingRatio)
+	}
+	seed, _ := newConfig.Common.DeterministicSeed()
+	r.tracer.SetSeed(seed)
+	r.configMu.Unlock()
+
+	// Connect to the next FB and DLQ. Don't fail the config update on a
+	// connection error - batches fail until the next update connects them.
+	if err := r.ConnectChain(ctx, newConfig.Common, metrics.GRPCDialOption(r.Name())); err != nil {
+		r.logger.Error("Failed to connect to the chain", err, map[string]interface{}{
+			"next_fb":  newConfig.Common.NextFB,
+			"next_fbs": len(newConfig.Common.NextFBs),
+			"dlq":      newConfig.Common.DLQ,
+		})
+	}
+
+	if err := r.ConfigureDebugTap(ctx, newConfig.Common.DebugTap, codec.Decode); err != nil {
+		r.logger.Error("Failed to configure debug tap", err, nil)
+		// Don't fail config update on a debug tap error - batches just aren't captured
+	}
+
+	// Start processing now that config is applied
+	if err := r.ConfigApplied(); err != nil {
+		return err
+	}
+
+	// Update metrics
+	r.metrics.SetConfigGeneration(generation)
+	r.metrics.SetReady(true)
+
+	// Report what the update changed, for rollout audits
+	changes, _ := config.DiffConfig(oldConfig, newConfig)
+
+	r.logger.Info("Config updated", map[string]interface{}{
+		"generation": generation,
+		"rules":      len(newConfig.Rules),
+		"changes":    changes,
+	})
+
+	return nil
+}
+
+// ValidateConfig parses and validates a configuration without applying it
+func (r *RL) ValidateConfig(configBytes []byte) error {
+	_, err := r.parseConfig(configBytes)
+	return err
+}
+
+// parseConfig parses and validates a configuration
+func (r *RL) parseConfig(configBytes []byte) (*RLConfig, error) {
+	// Merge a patch onto the applied config
+	r.configMu.RLock()
+	applied := r.config
+	r.configMu.RUnlock()
+	configBytes, err := config.ResolveMergePatch(configBytes, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	var newConfig RLConfig
+	if err := config.LoadConfigFromBytes(configBytes, &newConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if err := r.validateConfig(&newConfig); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &newConfig, nil
+}
+
+// validateConfig validates the Relabel function block's configuration
+func (r *RL) validateConfig(config *RLConfig) error {
+	// Validate common settings
+	if err := config.Common.Validate(); err != nil {
+		return err
+	}
+
+	// Check if next FB is configured
+	if !config.Common.HasNextFB() {
+		return fmt.Errorf("next FB not configured")
+	}
+
+	// Validate relabel rules
+	if _, err := relabel.New(config.Rules); err != nil {
+		return fmt.Errorf("invalid relabel rules: %w", err)
+	}
+
+	return nil
+}
+
+// Shutdown shuts down the Relabel function block
+func (r *RL) Shutdown(ctx context.Context) error {
+	r.logger.Info("Shutting down FB-RL", nil)
+
+	// Let batches in flight finish before closing the connections
+	err := r.ShutdownBlock(ctx)
+	if errors.Is(err, fb.ErrShutdownTimeout) {
+		r.logger.Warn("Shut down with batches still in flight", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	return err
+}
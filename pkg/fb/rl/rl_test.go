@@ -0,0 +1,66 @@
+package rl
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"eidc-tfk8s/internal/common/relabel"
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRL_ProcessBatch_RelabelsMetrics(t *testing.T) {
+	r := NewRL()
+	relabeler, err := relabel.New([]relabel.Rule{
+		{Action: relabel.Drop, SourceLabels: []string{relabel.MetricNameLabel}, Regex: "go_.*"},
+		{SourceLabels: []string{relabel.MetricNameLabel}, Regex: "node_(.*)", TargetLabel: relabel.MetricNameLabel, Replacement: "host_$1"},
+		{SourceLabels: []string{"instance"}, Regex: `([^:]+):\d+`, TargetLabel: "host"},
+	})
+	require.NoError(t, err)
+	r.relabeler = relabeler
+
+	batch := &fb.MetricBatch{
+		BatchID: "test-batch-id",
+		Data: []byte(`[
+			{"name":"node_cpu_seconds","value":1.5,"labels":{"instance":"node-1:9100"}},
+			{"name":"go_goroutines","value":12,"labels":{}}
+		]`),
+	}
+
+	err = r.processBatch(context.Background(), batch)
+	require.NoError(t, err)
+
+	var output []map[string]interface{}
+	require.NoError(t, json.Unmarshal(batch.Data, &output))
+	require.Len(t, output, 1)
+
+	assert.Equal(t, "host_cpu_seconds", output[0]["name"])
+	assert.Equal(t, 1.5, output[0]["value"])
+	assert.Equal(t, map[string]interface{}{
+		"instance": "node-1:9100",
+		"host":     "node-1",
+	}, output[0]["labels"])
+}
+
+func TestRL_ValidateConfig(t *testing.T) {
+	r := NewRL()
+
+	config := &RLConfig{
+		Rules: []relabel.Rule{{Action: relabel.Keep, SourceLabels: []string{"env"}, Regex: "("}},
+	}
+	config.Common.NextFB = "fb-next:5000"
+	config.Common.DLQ = "fb-dlq:5000"
+
+	err := r.validateConfig(config)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid relabel rules")
+}
+
+func TestDefaultConfig_MatchesParametersSchema(t *testing.T) {
+	configBytes, err := json.Marshal(DefaultConfig())
+	require.NoError(t, err)
+	assert.NoError(t, config.ValidateParameters("fb-rl", configBytes))
+}
@@ -0,0 +1,53 @@
+package rl
+
+import (
+	"eidc-tfk8s/internal/common/relabel"
+	"eidc-tfk8s/internal/config"
+)
+
+// parametersSchema is the JSON Schema of RLConfig
+const parametersSchema = `{
+	"type": "object",
+	"properties": {
+		"rules": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"sourceLabels": {
+						"type": "array",
+						"items": {"type": "string"}
+					},
+					"separator": {"type": "string"},
+					"regex": {"type": "string"},
+					"targetLabel": {"type": "string"},
+					"replacement": {"type": "string"},
+					"action": {"type": "string", "enum": ["", "replace", "keep", "drop", "labelmap"]}
+				}
+			}
+		}
+	}
+}`
+
+func init() {
+	config.RegisterParametersSchema("fb-rl", parametersSchema)
+}
+
+// DefaultConfig returns the RL configuration with no rules, which passes
+// metrics through unchanged
+func DefaultConfig() RLConfig {
+	return RLConfig{
+		Common: config.FBConfig{
+			LogLevel:           "info",
+			MetricsEnabled:     true,
+			TracingEnabled:     true,
+			TraceSamplingRatio: 0.1,
+			CircuitBreaker: config.CircuitBreakerConfig{
+				ErrorThresholdPercentage: 50,
+				OpenStateSeconds:         30,
+				HalfOpenRequestThreshold: 5,
+			},
+		},
+		Rules: []relabel.Rule{},
+	}
+}
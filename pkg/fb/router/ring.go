@@ -0,0 +1,72 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// defaultVirtualNodes is how many points each downstream gets on the hash
+// ring. More points spread keys more evenly across downstreams.
+const defaultVirtualNodes = 128
+
+// hashRing maps keys to downstreams by consistent hashing, so that a change
+// of membership only moves the keys owned by the downstreams that changed
+type hashRing struct {
+	points []uint64
+	owners map[uint64]string
+}
+
+// newHashRing creates a ring placing each downstream at virtualNodes points.
+// The ring depends only on the set of downstreams, not on their order.
+func newHashRing(downstreams []string, virtualNodes int) *hashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	ring := &hashRing{
+		points: make([]uint64, 0, len(downstreams)*virtualNodes),
+		owners: make(map[uint64]string, len(downstreams)*virtualNodes),
+	}
+	for _, downstream := range downstreams {
+		for i := 0; i < virtualNodes; i++ {
+			point := hashKey(downstream + "#" + strconv.Itoa(i))
+
+			// On the rare collision, the lowest address owns the point so
+			// the ring stays independent of the order of downstreams
+			if owner, ok := ring.owners[point]; ok {
+				if downstream < owner {
+					ring.owners[point] = downstream
+				}
+				continue
+			}
+			ring.owners[point] = downstream
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+
+	return ring
+}
+
+// get returns the downstream owning key: the first point on the ring at or
+// after the key's hash. It returns false if the ring is empty.
+func (r *hashRing) get(key string) (string, bool) {
+	if len(r.points) == 0 {
+		return "", false
+	}
+
+	hash := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]], true
+}
+
+// hashKey hashes a key or ring point to its position on the ring
+func hashKey(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
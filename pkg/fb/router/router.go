@@ -0,0 +1,678 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/common/metrics"
+	"eidc-tfk8s/internal/common/resilience"
+	"eidc-tfk8s/internal/common/tracing"
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Metrics of the routing of batches to downstreams
+var (
+	batchesRoutedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_router_batches_routed_total",
+		Help: "Total number of batches forwarded to each downstream",
+	}, []string{"downstream"})
+
+	unkeyedBatchesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_router_unkeyed_batches_total",
+		Help: "Total number of batches without a routing key, routed by batch ID instead",
+	})
+
+	downstreamsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fb_router_downstreams",
+		Help: "Number of downstreams batches are sharded across",
+	})
+)
+
+// Sources of the routing key of a batch
+const (
+	// KeySourceInternalLabel takes the key from an internal label of the batch
+	KeySourceInternalLabel = "internal_label"
+	// KeySourceMetadata takes the key from a metadata entry of the batch
+	KeySourceMetadata = "metadata"
+	// KeySourceBatchID takes the batch ID as the key
+	KeySourceBatchID = "batch_id"
+)
+
+// KeyConfig selects the value batches are routed by
+type KeyConfig struct {
+	// Source is where the key is taken from
+	Source string `json:"source"`
+
+	// Name is the internal label or metadata entry holding the key
+	Name string `json:"name,omitempty"`
+}
+
+// RouterConfig contains configuration for the Router function block
+type RouterConfig struct {
+	// Common configuration
+	Common config.FBConfig `json:"common"`
+
+	// Downstreams are the addresses of the replicas batches are sharded across
+	Downstreams []string `json:"downstreams"`
+
+	// Key selects the value batches are routed by
+	Key KeyConfig `json:"key"`
+
+	// VirtualNodes is how many points each downstream gets on the hash ring
+	VirtualNodes int `json:"virtual_nodes,omitempty"`
+}
+
+// downstream is a replica batches are routed to, with its own circuit
+// breaker so that one failing replica doesn't stop traffic to the others
+type downstream struct {
+	addr    string
+	client  fb.ChainPushServiceClient
+	conn    *grpc.ClientConn
+	breaker *resilience.CircuitBreaker
+}
+
+// Router implements the FB-Router function block, which consistent-hashes
+// each batch to one of several replicas of a stateful downstream FB, so that
+// batches with the same key always reach the same replica
+type Router struct {
+	fb.BaseFunctionBlock
+	logger      *logging.Logger
+	metrics     *metrics.FBMetrics
+	tracer      *tracing.Tracer
+	config      *RouterConfig
+	configMu    sync.RWMutex
+	ring        *hashRing
+	downstreams map[string]*downstream
+	dlqClient   fb.ChainPushServiceClient
+	dlqConn     *grpc.ClientConn
+	dlqBreaker  *resilience.CircuitBreaker
+}
+
+// NewRouter creates a new Router function block
+func NewRouter() *Router {
+	return &Router{
+		BaseFunctionBlock: fb.NewBaseFunctionBlock("fb-router"),
+		logger:            logging.NewLogger("fb-router"),
+		metrics:           metrics.NewFBMetrics("fb-router"),
+		tracer:            tracing.NewTracer("fb-router"),
+		ring:              newHashRing(nil, defaultVirtualNodes),
+		downstreams:       make(map[string]*downstream),
+	}
+}
+
+// Initialize initializes the Router function block
+func (r *Router) Initialize(ctx context.Context) error {
+	r.logger.Info("Initializing FB-Router", nil)
+
+	if err := r.Transition(fb.StateInitialized); err != nil {
+		return err
+	}
+
+	// Initialize the DLQ circuit breaker with default config; downstream
+	// breakers are created with the downstreams
+	r.dlqBreaker = resilience.NewCircuitBreaker("fb-router", resilience.DownstreamDLQ, resilience.DefaultCircuitBreakerConfig())
+
+	r.SetReady(true)
+
+	return nil
+}
+
+// ProcessBatch routes a batch to the downstream owning its key
+func (r *Router) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	// Create child span for the batch processing
+	ctx, span := r.tracer.StartSpan(ctx, "process-batch")
+	defer span.End()
+
+	// Record metric
+	r.metrics.RecordBatchReceived()
+	r.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeReceived)
+
+	// Reject batches until config has been applied, and while shutting down
+	done, result, err := r.BeginBatch(batch.BatchID)
+	if err != nil {
+		return result, err
+	}
+	defer done()
+
+	// Send batches whose data was corrupted in transit to the DLQ
+	if result, err := r.VerifyChecksum(ctx, batch, r.sendToDLQ); err != nil {
+		return result, err
+	}
+
+	// Routing is all this FB does, so a disabled router still routes
+	forwardingResult, forwardingErr := r.forwardToDownstream(ctx, batch)
+	if forwardingErr != nil {
+		// The batch can't reach the replica owning its key; sending it to
+		// another replica would split the state of the key, so DLQ it.
+		// Batches the replica rejected for good go to the quarantine, if
+		// any, and are reported upstream with its code so they aren't retried
+		code := fb.ForwardingErrorCode(forwardingErr)
+		dlqErr := r.SendFailedBatch(ctx, batch, code, forwardingErr, r.sendToDLQ)
+		if dlqErr != nil {
+			if errors.Is(dlqErr, fb.ErrNoDLQ) {
+				return fb.NewCodeErrorResult(batch.BatchID, code, forwardingErr), forwardingErr
+			}
+			r.logger.ErrorCtx(ctx, "Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
+				"batch_id": batch.BatchID,
+			})
+			return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
+		}
+
+		// Return error with DLQ status
+		return fb.NewErrorResult(batch.BatchID, code, forwardingErr, true), forwardingErr
+	}
+
+	return forwardingResult, nil
+}
+
+// routingKey returns the key a batch is routed by, falling back to the batch
+// ID for batches without one
+func routingKey(key KeyConfig, batch *fb.MetricBatch) string {
+	var value string
+	switch key.Source {
+	case KeySourceInternalLabel:
+		value = batch.InternalLabels[key.Name]
+	case KeySourceMetadata:
+		value = batch.Metadata[key.Name]
+	case KeySourceBatchID:
+		return batch.BatchID
+	}
+
+	if value == "" {
+		unkeyedBatchesTotal.Inc()
+		return batch.BatchID
+	}
+	return value
+}
+
+// route returns the downstream owning the batch's key
+func (r *Router) route(batch *fb.MetricBatch) (*downstream, error) {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+
+	var key KeyConfig
+	if r.config != nil {
+		key = r.config.Key
+	}
+
+	addr, ok := r.ring.get(routingKey(key, batch))
+	if !ok {
+		return nil, fmt.Errorf("no downstreams configured")
+	}
+	return r.downstreams[addr], nil
+}
+
+// forwardToDownstream forwards the batch to the downstream owning its key
+func (r *Router) forwardToDownstream(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	target, err := r.route(batch)
+	if err != nil {
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err), err
+	}
+
+	startTime := time.Now()
+
+	// Use the downstream's circuit breaker to protect against its failures
+	err = target.breaker.Execute(ctx, func(ctx context.Context) error {
+		// Ensure we have a connection to the downstream
+		if target.client == nil {
+			return fmt.Errorf("no connection to downstream %s", target.addr)
+		}
+
+		// Convert to ChainPushService request
+		req := &fb.MetricBatchRequest{
+			BatchId:          batch.BatchID,
+			Data:             batch.Data,
+			Format:           batch.Format,
+			Replay:           batch.Replay,
+			ConfigGeneration: batch.ConfigGeneration,
+			Metadata:         batch.Metadata,
+			InternalLabels:   batch.InternalLabels,
+		}
+
+		// Forward to downstream
+		res, err := target.client.PushMetrics(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to downstream %s: %w", target.addr, err)
+		}
+
+		// Check response
+		if res.Status != fb.StatusSuccess {
+			return fb.NewNextFBError(target.addr, res)
+		}
+
+		return nil
+	})
+
+	// Record metrics
+	r.metrics.RecordBatchForwarded(time.Since(startTime).Seconds())
+
+	if err != nil {
+		r.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeFailed)
+		if err == resilience.ErrCircuitOpen {
+			return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err), err
+		}
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err), err
+	}
+
+	r.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeForwarded)
+	batchesRoutedTotal.WithLabelValues(target.addr).Inc()
+
+	return fb.NewSuccessResult(batch.BatchID), nil
+}
+
+// sendToDLQ sends a batch to the Dead Letter Queue
+func (r *Router) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr error) error {
+	// Without a DLQ the batch is failed back to the caller
+	if !r.HasDLQ() {
+		return fb.ErrNoDLQ
+	}
+
+	// Ensure we have a connection to the DLQ
+	if r.dlqClient == nil {
+		return fmt.Errorf("no connection to DLQ")
+	}
+
+	// Add error info to internal labels
+	if batch.InternalLabels == nil {
+		batch.InternalLabels = make(map[string]string)
+	}
+	batch.InternalLabels["error"] = originalErr.Error()
+	batch.InternalLabels["fb_sender"] = r.Sender()
+
+	// Drop poison batches rather than cycle them through the DLQ forever
+	if r.RecordDLQHop(batch.InternalLabels) {
+		r.logger.Warn("Dropping poison batch", map[string]interface{}{
+			"batch_id":     batch.BatchID,
+			"replay_count": batch.InternalLabels[fb.LabelReplayCount],
+			"error":        originalErr.Error(),
+		})
+		return fb.ErrPoisonBatch
+	}
+
+	// Convert to ChainPushService request
+	req := &fb.MetricBatchRequest{
+		BatchId:          batch.BatchID,
+		Data:             batch.Data,
+		Format:           batch.Format,
+		Replay:           batch.Replay,
+		ConfigGeneration: batch.ConfigGeneration,
+		Metadata:         batch.Metadata,
+		InternalLabels:   batch.InternalLabels,
+	}
+
+	// Send to DLQ
+	r.configMu.RLock()
+	dlqBreaker := r.dlqBreaker
+	r.configMu.RUnlock()
+	err := dlqBreaker.Execute(ctx, func(ctx context.Context) error {
+		res, err := r.dlqClient.PushMetrics(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to DLQ: %w", err)
+		}
+
+		// Check response
+		if res.Status != fb.StatusSuccess {
+			return fmt.Errorf("DLQ returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Record metric
+	r.metrics.RecordBatchDLQ()
+	r.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeDropped)
+
+	return nil
+}
+
+// UpdateConfig updates the Router function block's configuration. A change
+// of downstreams rebalances the ring: downstreams that stay keep their
+// connections and circuit breakers, new ones are connected and removed ones
+// are closed.
+func (r *Router) UpdateConfig(ctx context.Context, configBytes []byte, generation int64) error {
+	// Create child span for config update
+	ctx, span := r.tracer.StartSpan(ctx, "update-config")
+	defer span.End()
+
+	// Parse and validate configuration
+	newConfig, err := r.parseConfig(configBytes)
+	if err != nil {
+		return err
+	}
+
+	// Refuse a generation older than the one applied, unless rolling back
+	if err := r.CheckConfigGeneration(generation, newConfig.Common.Rollback); err != nil {
+		r.logger.Error("Refused configuration", err, map[string]interface{}{
+			"generation":         generation,
+			"applied_generation": r.GetConfigGeneration(),
+		})
+		return err
+	}
+	seed, err := newConfig.Common.DeterministicSeed()
+	if err != nil {
+		return fb.ErrorCodeInvalidConfig.GRPCError(fmt.Errorf("invalid config: %w", err))
+	}
+
+	// Build the downstreams, reusing the connections of those that stay
+	r.configMu.RLock()
+	current := r.downstreams
+	var currentBreakerConfig resilience.CircuitBreakerConfig
+	if r.config != nil {
+		currentBreakerConfig = r.config.Common.NextFBCircuitBreakerConfig()
+	}
+	r.configMu.RUnlock()
+
+	breakerConfig := newConfig.Common.NextFBCircuitBreakerConfig()
+	downstreams := make(map[string]*downstream, len(newConfig.Downstreams))
+	var added []string
+	for _, addr := range newConfig.Downstreams {
+		if existing, ok := current[addr]; ok {
+			// Keep the breaker's open or half-open state unless its
+			// settings changed
+			d := &downstream{
+				addr:    addr,
+				client:  existing.client,
+				conn:    existing.conn,
+				breaker: existing.breaker,
+			}
+			if breakerConfig != currentBreakerConfig {
+				d.breaker = resilience.NewCircuitBreaker("fb-router", addr, breakerConfig)
+			}
+			downstreams[addr] = d
+			continue
+		}
+
+		d := &downstream{
+			addr:    addr,
+			breaker: resilience.NewCircuitBreaker("fb-router", addr, breakerConfig),
+		}
+		added = append(added, addr)
+		if err := r.connectToDownstream(ctx, d); err != nil {
+			r.logger.Error("Failed to connect to downstream", err, map[string]interface{}{
+				"downstream": addr,
+			})
+			// Don't fail config update on connection error; batches
+			// owned by the downstream go to the DLQ
+		}
+		downstreams[addr] = d
+	}
+
+	var removed []*downstream
+	for addr, d := range current {
+		if _, ok := downstreams[addr]; !ok {
+			removed = append(removed, d)
+		}
+	}
+
+	// Apply configuration
+	r.configMu.Lock()
+	oldConfig := r.config
+	r.config = newConfig
+	r.ring = newHashRing(newConfig.Downstreams, newConfig.VirtualNodes)
+	r.downstreams = downstreams
+	r.SetConfigGeneration(generation)
+	r.SetEnabled(newConfig.Common.IsEnabled())
+	r.SetMaxConcurrentBatches(newConfig.Common.MaxConcurrentBatches)
+	r.SetMaxReplayCount(newConfig.Common.MaxReplayCount)
+	r.SetVerifyChecksum(newConfig.Common.VerifyChecksum)
+	r.SetHasDLQ(newConfig.Common.DLQ != "")
+	r.SetMetadataLimits(fb.MetadataLimits{
+		MaxEntries: newConfig.Common.MaxMetadataEntries,
+		MaxBytes:   newConfig.Common.MaxMetadataBytes,
+		Action:     fb.MetadataLimitAction(newConfig.Common.MetadataLimitAction),
+	})
+	if level, err := logging.ParseLevel(newConfig.Common.LogLevel); err == nil {
+		r.logger.SetLevel(level)
+	}
+	if newConfig.Common.TracingEnabled {
+		r.tracer.SetSamplingRatio(newConfig.Common.TraceSamplingRatio)
+	}
+	r.tracer.SetSeed(seed)
+
+	// Update DLQ circuit breaker configuration
+	r.dlqBreaker = resilience.NewCircuitBreaker("fb-router", resilience.DownstreamDLQ, newConfig.Common.DLQCircuitBreakerConfig())
+	r.configMu.Unlock()
+
+	// Close the connections of removed downstreams once they're off the ring
+	removedAddrs := make([]string, 0, len(removed))
+	for _, d := range removed {
+		if d.conn != nil {
+			d.conn.Close()
+		}
+		removedAddrs = append(removedAddrs, d.addr)
+	}
+	sort.Strings(removedAddrs)
+	downstreamsGauge.Set(float64(len(downstreams)))
+
+	if len(added) > 0 || len(removed) > 0 {
+		r.logger.Info("Downstreams rebalanced", map[string]interface{}{
+			"added":       added,
+			"removed":     removedAddrs,
+			"downstreams": len(downstreams),
+		})
+	}
+
+	if r.dlqClient == nil && newConfig.Common.DLQ != "" {
+		if err := r.connectToDLQ(ctx, newConfig.Common.DLQ); err != nil {
+			r.logger.Error("Failed to connect to DLQ", err, map[string]interface{}{
+				"dlq": newConfig.Common.DLQ,
+			})
+			// Don't fail config update on connection error - we'll retry when needed
+		}
+	}
+
+	if err := r.ConnectQuarantine(ctx, newConfig.Common.Quarantine); err != nil {
+		r.logger.Error("Failed to connect to quarantine", err, map[string]interface{}{
+			"quarantine": newConfig.Common.Quarantine,
+		})
+		// Don't fail config update on connection error - we'll retry on next update
+	}
+
+	// Start processing now that config is applied
+	if err := r.ConfigApplied(); err != nil {
+		return err
+	}
+
+	// Update metrics
+	r.metrics.SetConfigGeneration(generation)
+	r.metrics.SetReady(true)
+
+	// Report what the update changed, for rollout audits
+	changes, _ := config.DiffConfig(oldConfig, newConfig)
+
+	r.logger.Info("Config updated", map[string]interface{}{
+		"generation":  generation,
+		"downstreams": len(newConfig.Downstreams),
+		"changes":     changes,
+	})
+
+	return nil
+}
+
+// ValidateConfig parses and validates a configuration without applying it
+func (r *Router) ValidateConfig(configBytes []byte) error {
+	_, err := r.parseConfig(configBytes)
+	return err
+}
+
+// parseConfig parses and validates a configuration
+func (r *Router) parseConfig(configBytes []byte) (*RouterConfig, error) {
+	// Merge a patch onto the applied config
+	r.configMu.RLock()
+	applied := r.config
+	r.configMu.RUnlock()
+	configBytes, err := config.ResolveMergePatch(configBytes, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	var newConfig RouterConfig
+	if err := config.LoadConfigFromBytes(configBytes, &newConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if err := r.validateConfig(&newConfig); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &newConfig, nil
+}
+
+// validateConfig validates the Router function block's configuration
+func (r *Router) validateConfig(config *RouterConfig) error {
+	// Validate common settings
+	if err := config.Common.Validate(); err != nil {
+		return err
+	}
+
+	// Check if downstreams are configured
+	if len(config.Downstreams) == 0 {
+		return fmt.Errorf("no downstreams configured")
+	}
+	seen := make(map[string]bool, len(config.Downstreams))
+	for _, addr := range config.Downstreams {
+		if addr == "" {
+			return fmt.Errorf("empty downstream address")
+		}
+		if seen[addr] {
+			return fmt.Errorf("duplicate downstream: %s", addr)
+		}
+		seen[addr] = true
+	}
+
+	// Validate the key extractor
+	switch config.Key.Source {
+	case KeySourceInternalLabel, KeySourceMetadata:
+		if config.Key.Name == "" {
+			return fmt.Errorf("key source %s requires a name", config.Key.Source)
+		}
+	case KeySourceBatchID:
+	default:
+		return fmt.Errorf("unknown key source: %q", config.Key.Source)
+	}
+
+	if config.VirtualNodes < 0 {
+		return fmt.Errorf("virtual_nodes must not be negative")
+	}
+
+	return nil
+}
+
+// connectToDownstream establishes a connection to a downstream
+func (r *Router) connectToDownstream(ctx context.Context, d *downstream) error {
+	conn, err := r.DialContext(ctx, d.addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+		metrics.GRPCDialOption(r.Name()),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to downstream %s: %w", d.addr, err)
+	}
+
+	d.conn = conn
+	d.client = fb.NewChainPushServiceClient(conn)
+
+	return nil
+}
+
+// connectToDLQ establishes a connection to the DLQ function block
+func (r *Router) connectToDLQ(ctx context.Context, dlqAddr string) error {
+	// Close existing connection if any
+	if r.dlqConn != nil {
+		r.dlqConn.Close()
+		r.dlqConn = nil
+		r.dlqClient = nil
+	}
+
+	// Create new connection
+	conn, err := r.DialContext(ctx, dlqAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to DLQ: %w", err)
+	}
+
+	r.dlqConn = conn
+	r.dlqClient = fb.NewChainPushServiceClient(conn)
+
+	return nil
+}
+
+// Shutdown shuts down the Router function block
+func (r *Router) Shutdown(ctx context.Context) error {
+	r.logger.Info("Shutting down FB-Router", nil)
+
+	// Stop accepting batches
+	if err := r.Transition(fb.StateDraining); err != nil {
+		return err
+	}
+
+	// Let batches in flight finish, including their DLQ sends
+	drainErr := r.DrainBatches(ctx)
+	if drainErr != nil {
+		r.logger.Warn("Shutting down with batches still in flight", map[string]interface{}{
+			"error": drainErr.Error(),
+		})
+	}
+
+	// Close connections, the DLQ and quarantine last
+	r.configMu.Lock()
+	for _, d := range r.downstreams {
+		if d.conn != nil {
+			d.conn.Close()
+		}
+	}
+	r.downstreams = make(map[string]*downstream)
+	r.ring = newHashRing(nil, defaultVirtualNodes)
+	r.configMu.Unlock()
+	downstreamsGauge.Set(0)
+
+	if r.dlqConn != nil {
+		r.dlqConn.Close()
+		r.dlqConn = nil
+		r.dlqClient = nil
+	}
+
+	r.CloseQuarantine()
+
+	// Mark as not ready
+	r.SetReady(false)
+	r.metrics.SetReady(false)
+
+	if err := r.Transition(fb.StateStopped); err != nil {
+		return err
+	}
+	return drainErr
+}
+
+// Testing helpers
+
+// SetDownstreamClientForTesting sets the client of a configured downstream
+// for testing purposes
+func (r *Router) SetDownstreamClientForTesting(addr string, client fb.ChainPushServiceClient) {
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+
+	if d, ok := r.downstreams[addr]; ok {
+		d.client = client
+	}
+}
+
+// SetDLQClientForTesting sets the DLQ client for testing purposes
+func (r *Router) SetDLQClientForTesting(client fb.ChainPushServiceClient) {
+	r.dlqClient = client
+}
@@ -0,0 +1,211 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"eidc-tfk8s/internal/common/resilience"
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("dedup-key-%d", i)
+	}
+	return keys
+}
+
+func TestHashRing_StableMapping(t *testing.T) {
+	ring := newHashRing([]string{"dp-0:5000", "dp-1:5000", "dp-2:5000"}, 0)
+	reordered := newHashRing([]string{"dp-2:5000", "dp-0:5000", "dp-1:5000"}, 0)
+
+	owned := make(map[string]int)
+	for _, key := range testKeys(10000) {
+		owner, ok := ring.get(key)
+		require.True(t, ok)
+		owned[owner]++
+
+		// The same key always maps to the same downstream, whatever the
+		// order the downstreams are configured in
+		again, _ := ring.get(key)
+		assert.Equal(t, owner, again)
+		other, _ := reordered.get(key)
+		assert.Equal(t, owner, other)
+	}
+
+	// Keys are spread across all downstreams
+	require.Len(t, owned, 3)
+	for owner, count := range owned {
+		assert.InDelta(t, 10000/3, count, 1000, "downstream %s", owner)
+	}
+}
+
+func TestHashRing_AddingNodeMovesFewKeys(t *testing.T) {
+	before := newHashRing([]string{"dp-0:5000", "dp-1:5000", "dp-2:5000"}, 0)
+	after := newHashRing([]string{"dp-0:5000", "dp-1:5000", "dp-2:5000", "dp-3:5000"}, 0)
+
+	keys := testKeys(10000)
+	moved := 0
+	for _, key := range keys {
+		oldOwner, _ := before.get(key)
+		newOwner, _ := after.get(key)
+		if oldOwner == newOwner {
+			continue
+		}
+
+		// Keys only move to the new downstream, never between old ones
+		assert.Equal(t, "dp-3:5000", newOwner, "key %s", key)
+		moved++
+	}
+
+	// Ideally a quarter of the keys move to the new downstream
+	assert.InDelta(t, 0.25, float64(moved)/float64(len(keys)), 0.08)
+}
+
+// newTestRouter creates a running router sharding by the "dedup_key"
+// internal label across the given downstreams
+func newTestRouter(t *testing.T, downstreams []string) *Router {
+	r := NewRouter()
+	require.NoError(t, r.Initialize(context.Background()))
+
+	r.config = &RouterConfig{
+		Downstreams: downstreams,
+		Key:         KeyConfig{Source: KeySourceInternalLabel, Name: "dedup_key"},
+	}
+	r.ring = newHashRing(downstreams, 0)
+	for _, addr := range downstreams {
+		r.downstreams[addr] = &downstream{
+			addr:    addr,
+			breaker: resilience.NewCircuitBreaker("fb-router", addr, resilience.DefaultCircuitBreakerConfig()),
+		}
+	}
+	require.NoError(t, r.ConfigApplied())
+
+	return r
+}
+
+func TestRouter_ProcessBatch_RoutesByKey(t *testing.T) {
+	downstreams := []string{"dp-0:5000", "dp-1:5000", "dp-2:5000"}
+	r := newTestRouter(t, downstreams)
+
+	received := make(map[string][]string)
+	for _, addr := range downstreams {
+		addr := addr
+		r.SetDownstreamClientForTesting(addr, &fb.MockChainPushServiceClient{
+			PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+				received[addr] = append(received[addr], in.InternalLabels["dedup_key"])
+				return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+			},
+		})
+	}
+	r.SetDLQClientForTesting(&fb.MockChainPushServiceClient{})
+
+	for i := 0; i < 2; i++ {
+		for _, key := range testKeys(50) {
+			result, err := r.ProcessBatch(context.Background(), &fb.MetricBatch{
+				BatchID:        fmt.Sprintf("batch-%s-%d", key, i),
+				Data:           []byte(`[]`),
+				InternalLabels: map[string]string{"dedup_key": key},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, fb.StatusSuccess, result.Status)
+		}
+	}
+
+	// Both batches of a key reach the downstream owning it
+	total := 0
+	for addr, keys := range received {
+		total += len(keys)
+		for _, key := range keys {
+			owner, _ := r.ring.get(key)
+			assert.Equal(t, owner, addr)
+		}
+	}
+	assert.Equal(t, 100, total)
+}
+
+func TestRouter_ProcessBatch_DownstreamFailureGoesToDLQ(t *testing.T) {
+	r := newTestRouter(t, []string{"dp-0:5000"})
+
+	r.SetDownstreamClientForTesting("dp-0:5000", &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			return nil, fmt.Errorf("connection refused")
+		},
+	})
+	var dlqBatches []*fb.MetricBatchRequest
+	r.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqBatches = append(dlqBatches, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	result, err := r.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID:        "batch-1",
+		Data:           []byte(`[]`),
+		InternalLabels: map[string]string{"dedup_key": "key-1"},
+	})
+	require.Error(t, err)
+	assert.Equal(t, fb.ErrorCodeForwardingFailed, result.ErrorCode)
+	require.Len(t, dlqBatches, 1)
+	assert.Equal(t, "batch-1", dlqBatches[0].BatchId)
+	assert.Equal(t, "fb-router", dlqBatches[0].InternalLabels["fb_sender"])
+}
+
+func TestRouter_UpdateConfig_KeepsBreakersOfRemainingDownstreams(t *testing.T) {
+	r := newTestRouter(t, []string{"dp-0:5000", "dp-1:5000"})
+	r.SetDLQClientForTesting(&fb.MockChainPushServiceClient{})
+
+	routerConfig := func(downstreams ...string) []byte {
+		config := RouterConfig{
+			Downstreams: downstreams,
+			Key:         KeyConfig{Source: KeySourceBatchID},
+		}
+		config.Common.DLQ = "fb-dlq:5000"
+		configBytes, err := json.Marshal(config)
+		require.NoError(t, err)
+		return configBytes
+	}
+
+	// The first config sets the breaker settings, so the breakers are rebuilt
+	require.NoError(t, r.UpdateConfig(context.Background(), routerConfig("dp-0:5000", "dp-1:5000"), 1))
+	breaker := r.downstreams["dp-0:5000"].breaker
+
+	// Removing a downstream keeps the breaker, and so the state, of the
+	// downstreams that stay
+	require.NoError(t, r.UpdateConfig(context.Background(), routerConfig("dp-0:5000"), 2))
+	require.Len(t, r.downstreams, 1)
+	assert.Same(t, breaker, r.downstreams["dp-0:5000"].breaker)
+}
+
+func TestRouter_ValidateConfig(t *testing.T) {
+	r := NewRouter()
+
+	config := &RouterConfig{
+		Downstreams: []string{"dp-0:5000", "dp-0:5000"},
+		Key:         KeyConfig{Source: KeySourceBatchID},
+	}
+	config.Common.DLQ = "fb-dlq:5000"
+	err := r.validateConfig(config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate downstream")
+
+	config.Downstreams = []string{"dp-0:5000", "dp-1:5000"}
+	config.Key = KeyConfig{Source: KeySourceInternalLabel}
+	err = r.validateConfig(config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires a name")
+}
+
+func TestDefaultConfig_MatchesParametersSchema(t *testing.T) {
+	configBytes, err := json.Marshal(DefaultConfig())
+	require.NoError(t, err)
+	assert.NoError(t, config.ValidateParameters("fb-router", configBytes))
+}
@@ -0,0 +1,49 @@
+package router
+
+import (
+	"eidc-tfk8s/internal/config"
+)
+
+// parametersSchema is the JSON Schema of RouterConfig
+const parametersSchema = `{
+	"type": "object",
+	"properties": {
+		"downstreams": {
+			"type": "array",
+			"items": {"type": "string"}
+		},
+		"key": {
+			"type": "object",
+			"properties": {
+				"source": {"type": "string", "enum": ["internal_label", "metadata", "batch_id"]},
+				"name": {"type": "string"}
+			}
+		},
+		"virtual_nodes": {"type": "integer"}
+	}
+}`
+
+func init() {
+	config.RegisterParametersSchema("fb-router", parametersSchema)
+}
+
+// DefaultConfig returns the Router configuration routing by batch ID. The
+// downstreams have to be set.
+func DefaultConfig() RouterConfig {
+	return RouterConfig{
+		Common: config.FBConfig{
+			LogLevel:           "info",
+			MetricsEnabled:     true,
+			TracingEnabled:     true,
+			TraceSamplingRatio: 0.1,
+			CircuitBreaker: config.CircuitBreakerConfig{
+				ErrorThresholdPercentage: 50,
+				OpenStateSeconds:         30,
+				HalfOpenRequestThreshold: 5,
+			},
+		},
+		Downstreams:  []string{},
+		Key:          KeyConfig{Source: KeySourceBatchID},
+		VirtualNodes: defaultVirtualNodes,
+	}
+}
@@ -0,0 +1,136 @@
+package rx
+
+import (
+	"fmt"
+
+	"eidc-tfk8s/pkg/fb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var adaptiveDropsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "fb_rx_adaptive_drops_total",
+	Help: "Total number of low-priority batches dropped because RX was saturated",
+})
+
+// Defaults of adaptive sampling
+const (
+	defaultPriorityLabel       = "priority"
+	defaultLowPriorityValue    = "low"
+	defaultSaturationThreshold = 0.8
+)
+
+// AdaptiveSamplingConfig configures the shedding of low-priority batches
+// under overload. Saturation is the number of batches in flight over
+// MaxInFlight. Past Threshold, low-priority batches are dropped with a
+// probability growing linearly with saturation, up to MaxDropRatio at full
+// saturation. Other batches are always processed, as are replays, which the
+// replay high-water mark pushes back instead.
+type AdaptiveSamplingConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// PriorityLabel is the InternalLabels key of a batch's priority, and
+	// LowPriorityValue the value that marks it low priority (defaults
+	// "priority" and "low")
+	PriorityLabel    string `json:"priorityLabel"`
+	LowPriorityValue string `json:"lowPriorityValue"`
+
+	// MaxInFlight is the number of batches in flight at which RX is fully
+	// saturated. 0 uses the common max_concurrent_batches.
+	MaxInFlight int `json:"maxInFlight"`
+
+	// Threshold is the saturation, from 0 to 1, past which low-priority
+	// batches are dropped (default 0.8)
+	Threshold float64 `json:"threshold"`
+
+	// MaxDropRatio is the share of low-priority batches dropped at full
+	// saturation (default 1)
+	MaxDropRatio float64 `json:"maxDropRatio"`
+}
+
+// validateAdaptiveSampling checks the adaptive sampling configuration
+func (c *RXConfig) validateAdaptiveSampling() error {
+	sampling := c.AdaptiveSampling
+	if sampling.MaxInFlight < 0 {
+		return fmt.Errorf("adaptive sampling maxInFlight must not be negative")
+	}
+	if sampling.Threshold < 0 || sampling.Threshold >= 1 {
+		return fmt.Errorf("adaptive sampling threshold must be at least 0 and below 1")
+	}
+	if sampling.MaxDropRatio < 0 || sampling.MaxDropRatio > 1 {
+		return fmt.Errorf("adaptive sampling maxDropRatio must be between 0 and 1")
+	}
+	if sampling.Enabled && sampling.MaxInFlight == 0 && c.Common.MaxConcurrentBatches == 0 {
+		return fmt.Errorf("adaptive sampling requires maxInFlight or max_concurrent_batches")
+	}
+	return nil
+}
+
+// dropRatio returns the share of low-priority batches to drop at a
+// saturation
+func (c AdaptiveSamplingConfig) dropRatio(saturation float64) float64 {
+	threshold := c.Threshold
+	if threshold == 0 {
+		threshold = defaultSaturationThreshold
+	}
+	maxDropRatio := c.MaxDropRatio
+	if maxDropRatio == 0 {
+		maxDropRatio = 1
+	}
+
+	if saturation <= threshold {
+		return 0
+	}
+	excess := (saturation - threshold) / (1 - threshold)
+	if excess > 1 {
+		excess = 1
+	}
+	return excess * maxDropRatio
+}
+
+// lowPriority reports whether a batch is tagged low priority
+func (c AdaptiveSamplingConfig) lowPriority(batch *fb.MetricBatch) bool {
+	label, value := c.PriorityLabel, c.LowPriorityValue
+	if label == "" {
+		label = defaultPriorityLabel
+	}
+	if value == "" {
+		value = defaultLowPriorityValue
+	}
+	return batch.InternalLabels[label] == value
+}
+
+// shedBatch reports whether to drop a batch to relieve saturation, with
+// inFlight batches being processed, this one included
+func (r *RX) shedBatch(batch *fb.MetricBatch, inFlight int64) bool {
+	if batch.Replay {
+		return false
+	}
+
+	r.configMu.RLock()
+	var sampling AdaptiveSamplingConfig
+	var maxConcurrent int
+	if r.config != nil {
+		sampling = r.config.AdaptiveSampling
+		maxConcurrent = r.config.Common.MaxConcurrentBatches
+	}
+	r.configMu.RUnlock()
+
+	if !sampling.Enabled || !sampling.lowPriority(batch) {
+		return false
+	}
+
+	capacity := sampling.MaxInFlight
+	if capacity == 0 {
+		capacity = maxConcurrent
+	}
+	if capacity == 0 {
+		return false
+	}
+
+	ratio := sampling.dropRatio(float64(inFlight) / float64(capacity))
+
+	// Drop by batch ID, so that the same batches are dropped on every replay
+	// and run with the same load
+	return fb.SampledBatch(batch.BatchID, ratio)
+}
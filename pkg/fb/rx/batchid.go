@@ -0,0 +1,197 @@
+package rx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"eidc-tfk8s/pkg/fb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Actions taken on duplicate batches
+const (
+	// DuplicateActionMerge acknowledges a duplicate as the batch already
+	// forwarded, without forwarding it again
+	DuplicateActionMerge = "merge"
+
+	// DuplicateActionReject fails a duplicate with ErrorCodeDuplicateBatch
+	DuplicateActionReject = "reject"
+)
+
+// defaultDedupeWindow is how long forwarded batches are remembered when the
+// config doesn't say
+const defaultDedupeWindow = 30 * time.Second
+
+// dedupePruneInterval is the minimum time between prunes of expired batches
+const dedupePruneInterval = time.Second
+
+var (
+	generatedBatchIDsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_rx_generated_batch_ids_total",
+		Help: "Total number of incoming batches without an id that were given one derived from their content",
+	})
+
+	duplicateBatchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_rx_duplicate_batches_total",
+		Help: "Total number of incoming batches duplicating a batch already forwarded, by action taken",
+	}, []string{"action"})
+)
+
+// BatchIDConfig configures the deduplication of incoming batches. A batch
+// with the id and data of a batch forwarded within the window is a client
+// retry, which would be counted twice if forwarded again.
+type BatchIDConfig struct {
+	Dedupe bool `json:"dedupe"`
+
+	// DedupeWindowMs is how long forwarded batches are remembered
+	DedupeWindowMs int `json:"dedupeWindowMs"`
+
+	// DuplicateAction is "merge", the default, or "reject"
+	DuplicateAction string `json:"duplicateAction"`
+}
+
+// validate checks the batch id configuration
+func (c BatchIDConfig) validate() error {
+	if c.DedupeWindowMs < 0 {
+		return fmt.Errorf("batch id dedupeWindowMs must not be negative")
+	}
+	switch c.DuplicateAction {
+	case "", DuplicateActionMerge, DuplicateActionReject:
+	default:
+		return fmt.Errorf("invalid batch id duplicateAction: %s, must be 'merge' or 'reject'", c.DuplicateAction)
+	}
+	return nil
+}
+
+func (c BatchIDConfig) window() time.Duration {
+	if c.DedupeWindowMs == 0 {
+		return defaultDedupeWindow
+	}
+	return time.Duration(c.DedupeWindowMs) * time.Millisecond
+}
+
+func (c BatchIDConfig) action() string {
+	if c.DuplicateAction == "" {
+		return DuplicateActionMerge
+	}
+	return c.DuplicateAction
+}
+
+// assignBatchID gives a batch without an id one derived from its tenant,
+// format and data, so a client retrying such a batch sends the same id
+// again. It returns whether an id was assigned.
+func assignBatchID(batch *fb.MetricBatch) bool {
+	if batch.BatchID != "" {
+		return false
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(fb.TenantID(batch)))
+	hash.Write([]byte{0})
+	hash.Write([]byte(batch.Format))
+	hash.Write([]byte{0})
+	hash.Write(batch.Data)
+	batch.BatchID = "rx-" + hex.EncodeToString(hash.Sum(nil)[:16])
+
+	generatedBatchIDsTotal.Inc()
+	return true
+}
+
+// dedupeKey identifies a batch by its id and data. A reused id with new
+// data is a different batch.
+func dedupeKey(batch *fb.MetricBatch) string {
+	checksum, ok := batch.InternalLabels[fb.LabelChecksum]
+	if !ok {
+		checksum = fb.Checksum(batch.Data)
+	}
+	return batch.BatchID + "\x00" + checksum
+}
+
+// batchDeduper remembers the batches forwarded within the dedupe window.
+// Batches are recorded once forwarded, so retries of batches that failed
+// still go through.
+type batchDeduper struct {
+	mu        sync.Mutex
+	config    BatchIDConfig
+	expiries  map[string]time.Time
+	lastPrune time.Time
+}
+
+// newBatchDeduper creates a deduper with the given configuration
+func newBatchDeduper(config BatchIDConfig) *batchDeduper {
+	return &batchDeduper{
+		config:   config,
+		expiries: make(map[string]time.Time),
+	}
+}
+
+// update applies a new configuration. Disabling deduplication forgets the
+// batches recorded; a new window applies to batches recorded from now on.
+func (d *batchDeduper) update(config BatchIDConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.config = config
+	if !config.Dedupe {
+		d.expiries = make(map[string]time.Time)
+	}
+}
+
+// duplicate returns whether a batch duplicates one forwarded within the
+// window, and the action to take on it. Replays from the DLQ are never
+// duplicates.
+func (d *batchDeduper) duplicate(batch *fb.MetricBatch, now time.Time) (bool, string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.config.Dedupe || batch.Replay {
+		return false, ""
+	}
+	expiry, ok := d.expiries[dedupeKey(batch)]
+	return ok && now.Before(expiry), d.config.action()
+}
+
+// record remembers a forwarded batch for the window
+func (d *batchDeduper) record(batch *fb.MetricBatch, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.config.Dedupe || batch.Replay {
+		return
+	}
+	d.expiries[dedupeKey(batch)] = now.Add(d.config.window())
+
+	if now.Sub(d.lastPrune) >= dedupePruneInterval {
+		for key, expiry := range d.expiries {
+			if !now.Before(expiry) {
+				delete(d.expiries, key)
+			}
+		}
+		d.lastPrune = now
+	}
+}
+
+// checkDuplicate acknowledges or rejects a batch duplicating one forwarded
+// within the dedupe window. It returns a nil result for other batches.
+func (r *RX) checkDuplicate(batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	duplicate, action := r.deduper.duplicate(batch, time.Now())
+	if !duplicate {
+		return nil, nil
+	}
+
+	duplicateBatchesTotal.WithLabelValues(action).Inc()
+	r.logger.Info("Received duplicate batch", map[string]interface{}{
+		"batch_id": batch.BatchID,
+		"action":   action,
+	})
+
+	if action == DuplicateActionReject {
+		err := fmt.Errorf("batch %s was already received", batch.BatchID)
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeDuplicateBatch, err), err
+	}
+	return fb.NewSuccessResult(batch.BatchID), nil
+}
@@ -0,0 +1,345 @@
+package rx
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Coalescing limits used when the config doesn't set them
+const (
+	defaultCoalesceMaxBatches = 100
+	defaultCoalesceMaxBytes   = 1 << 20
+	defaultCoalesceMaxWait    = 50 * time.Millisecond
+)
+
+// Reasons a coalesced batch is flushed
+const (
+	flushReasonBatches = "batches"
+	flushReasonBytes   = "bytes"
+	flushReasonWait    = "wait"
+	flushReasonStop    = "stop"
+)
+
+var (
+	coalescedBatchesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_rx_coalesced_batches_total",
+		Help: "Total number of incoming batches forwarded merged with others",
+	})
+
+	coalesceFlushesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_rx_coalesce_flushes_total",
+		Help: "Total number of merged batches forwarded, by the limit that flushed them",
+	}, []string{"reason"})
+)
+
+// CoalesceConfig configures the merging of incoming batches into fewer,
+// larger batches, trading a little latency for fewer RPCs down the chain
+type CoalesceConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// MaxBatches and MaxBytes flush a merged batch once it holds that many
+	// batches or that much data. Batches of MaxBytes or more are forwarded
+	// on their own.
+	MaxBatches int `json:"maxBatches"`
+	MaxBytes   int `json:"maxBytes"`
+
+	// MaxWaitMs is how long the first batch of a merged batch waits for
+	// others before it is flushed
+	MaxWaitMs int `json:"maxWaitMs"`
+}
+
+// validate checks the coalescing configuration
+func (c CoalesceConfig) validate() error {
+	if c.MaxBatches < 0 {
+		return fmt.Errorf("coalesce maxBatches must not be negative")
+	}
+	if c.MaxBytes < 0 {
+		return fmt.Errorf("coalesce maxBytes must not be negative")
+	}
+	if c.MaxWaitMs < 0 {
+		return fmt.Errorf("coalesce maxWaitMs must not be negative")
+	}
+	return nil
+}
+
+func (c CoalesceConfig) maxBatches() int {
+	if c.MaxBatches == 0 {
+		return defaultCoalesceMaxBatches
+	}
+	return c.MaxBatches
+}
+
+func (c CoalesceConfig) maxBytes() int {
+	if c.MaxBytes == 0 {
+		return defaultCoalesceMaxBytes
+	}
+	return c.MaxBytes
+}
+
+func (c CoalesceConfig) maxWait() time.Duration {
+	if c.MaxWaitMs == 0 {
+		return defaultCoalesceMaxWait
+	}
+	return time.Duration(c.MaxWaitMs) * time.Millisecond
+}
+
+// coalesceGroup is a merged batch being filled, and the result of
+// forwarding it once flushed
+type coalesceGroup struct {
+	tenant  string
+	ctx     context.Context
+	batches []*fb.MetricBatch
+	metrics []codec.Metric
+	bytes   int
+	timer   *time.Timer
+
+	// done is closed once result and err are set
+	done   chan struct{}
+	result *fb.ProcessResult
+	err    error
+}
+
+// coalescer merges the batches of each tenant arriving within a short
+// window. Each batch waits for its merged batch to be forwarded and gets
+// its result, so failures and backpressure still reach every sender.
+type coalescer struct {
+	forward func(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error)
+
+	mu     sync.Mutex
+	config CoalesceConfig
+	groups map[string]*coalesceGroup
+}
+
+// newCoalescer creates a coalescer forwarding batches with forward
+func newCoalescer(forward func(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error)) *coalescer {
+	return &coalescer{
+		forward: forward,
+		groups:  make(map[string]*coalesceGroup),
+	}
+}
+
+// update applies a new configuration. Disabling coalescing flushes the
+// batches waiting.
+func (c *coalescer) update(config CoalesceConfig) {
+	c.mu.Lock()
+	c.config = config
+	c.mu.Unlock()
+
+	if !config.Enabled {
+		c.flushAll()
+	}
+}
+
+// flushAll forwards all batches waiting, e.g. at shutdown
+func (c *coalescer) flushAll() {
+	c.mu.Lock()
+	groups := make([]*coalesceGroup, 0, len(c.groups))
+	for _, group := range c.groups {
+		c.detach(group)
+		groups = append(groups, group)
+	}
+	c.mu.Unlock()
+
+	for _, group := range groups {
+		c.flush(group, flushReasonStop)
+	}
+}
+
+// process forwards a batch merged with others of its tenant, if coalescing
+// is enabled, and returns the result of forwarding the merged batch.
+// Replays, batches too large to merge and batches that don't decode are
+// forwarded on their own.
+func (c *coalescer) process(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	c.mu.Lock()
+	config := c.config
+	c.mu.Unlock()
+
+	if !config.Enabled || batch.Replay || len(batch.Data) >= config.maxBytes() {
+		return c.forward(ctx, batch)
+	}
+	metrics, err := codec.Decode(batch)
+	if err != nil {
+		return c.forward(ctx, batch)
+	}
+
+	tenant := fb.TenantID(batch)
+
+	c.mu.Lock()
+	group := c.groups[tenant]
+
+	// Flush what's waiting if this batch would take it over the byte limit
+	var full *coalesceGroup
+	if group != nil && group.bytes+len(batch.Data) > config.maxBytes() {
+		c.detach(group)
+		full, group = group, nil
+	}
+
+	if group == nil {
+		opened := &coalesceGroup{
+			tenant: tenant,
+			ctx:    ctx,
+			done:   make(chan struct{}),
+		}
+		opened.timer = time.AfterFunc(config.maxWait(), func() {
+			c.flushWaiting(opened)
+		})
+		c.groups[tenant] = opened
+		group = opened
+	}
+	group.batches = append(group.batches, batch)
+	group.metrics = append(group.metrics, metrics...)
+	group.bytes += len(batch.Data)
+
+	var reason string
+	switch {
+	case len(group.batches) >= config.maxBatches():
+		reason = flushReasonBatches
+	case group.bytes >= config.maxBytes():
+		reason = flushReasonBytes
+	}
+	if reason != "" {
+		c.detach(group)
+	}
+	c.mu.Unlock()
+
+	if full != nil {
+		go c.flush(full, flushReasonBytes)
+	}
+	if reason != "" {
+		c.flush(group, reason)
+	}
+
+	<-group.done
+	return group.resultFor(batch)
+}
+
+// detach removes a group from the groups being filled. c.mu must be held.
+func (c *coalescer) detach(group *coalesceGroup) {
+	group.timer.Stop()
+	if c.groups[group.tenant] == group {
+		delete(c.groups, group.tenant)
+	}
+}
+
+// flushWaiting flushes a group once its first batch has waited long enough,
+// unless a limit has flushed it already
+func (c *coalescer) flushWaiting(group *coalesceGroup) {
+	c.mu.Lock()
+	if c.groups[group.tenant] != group {
+		c.mu.Unlock()
+		return
+	}
+	c.detach(group)
+	c.mu.Unlock()
+
+	c.flush(group, flushReasonWait)
+}
+
+// flush forwards a group's batches as one merged batch, then releases the
+// batches waiting for the result
+func (c *coalescer) flush(group *coalesceGroup, reason string) {
+	defer close(group.done)
+
+	coalesceFlushesTotal.WithLabelValues(reason).Inc()
+	coalescedBatchesTotal.Add(float64(len(group.batches)))
+
+	merged := mergeBatches(group.batches)
+	if err := codec.Encode(merged, group.metrics); err != nil {
+		group.result, group.err = fb.NewCodeErrorResult(merged.BatchID, fb.ErrorCodeProcessingFailed, err), err
+		return
+	}
+	fb.StampChecksum(merged)
+
+	// The merged batch is forwarded whether or not the request of the batch
+	// that opened it is still around
+	group.result, group.err = c.forward(context.WithoutCancel(group.ctx), merged)
+}
+
+// resultFor returns the result of the merged batch for one of its batches
+func (g *coalesceGroup) resultFor(batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	if g.result == nil {
+		return nil, g.err
+	}
+	result := *g.result
+	result.BatchID = batch.BatchID
+	return &result, g.err
+}
+
+// mergeBatches returns a batch carrying the labels of batches, without
+// data. It takes the format of the first batch, which codec.Encode keeps if
+// FBs exchange batches in it.
+func mergeBatches(batches []*fb.MetricBatch) *fb.MetricBatch {
+	first := batches[0]
+	merged := &fb.MetricBatch{
+		BatchID: fmt.Sprintf("%s-coalesced-%d", first.BatchID, len(batches)),
+		Format:  first.Format,
+	}
+
+	metadata := make([]map[string]string, len(batches))
+	internalLabels := make([]map[string]string, len(batches))
+	for i, batch := range batches {
+		if batch.ConfigGeneration > merged.ConfigGeneration {
+			merged.ConfigGeneration = batch.ConfigGeneration
+		}
+		metadata[i] = batch.Metadata
+		internalLabels[i] = batch.InternalLabels
+	}
+	merged.Metadata = mergeLabels(metadata)
+	merged.InternalLabels = mergeLabels(internalLabels)
+	if merged.InternalLabels == nil {
+		merged.InternalLabels = make(map[string]string)
+	}
+	return merged
+}
+
+// mergeLabels returns the union of label sets. A key whose value differs
+// between sets is dropped, as no value holds for the whole merged batch,
+// except the ingest timestamp, which keeps the earliest so that latency is
+// measured from the first batch in. The checksum is left out, to be
+// stamped on the merged data.
+func mergeLabels(sets []map[string]string) map[string]string {
+	var merged map[string]string
+	conflicting := make(map[string]bool)
+	for _, labels := range sets {
+		for k, v := range labels {
+			if k == fb.LabelChecksum || conflicting[k] {
+				continue
+			}
+			if merged == nil {
+				merged = make(map[string]string)
+			}
+
+			existing, ok := merged[k]
+			switch {
+			case !ok || existing == v:
+				merged[k] = v
+			case k == fb.LabelIngestTimestamp:
+				if earlier(v, existing) {
+					merged[k] = v
+				}
+			default:
+				conflicting[k] = true
+				delete(merged, k)
+			}
+		}
+	}
+	return merged
+}
+
+// earlier returns whether ingest timestamp a is before b
+func earlier(a, b string) bool {
+	aNanos, aErr := strconv.ParseInt(a, 10, 64)
+	bNanos, bErr := strconv.ParseInt(b, 10, 64)
+	if aErr != nil || bErr != nil {
+		return false
+	}
+	return aNanos < bNanos
+}
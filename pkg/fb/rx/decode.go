@@ -0,0 +1,112 @@
+package rx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	partialDecodeTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_rx_partial_decode_total",
+		Help: "Total number of OTLP batches forwarded without the fragments that failed to decode",
+	})
+
+	droppedFragmentsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_rx_dropped_fragments_total",
+		Help: "Total number of OTLP fragments sent to the DLQ because they failed to decode",
+	})
+
+	unconvertedBatchesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_rx_unconverted_batches_total",
+		Help: "Total number of batches forwarded in their ingest format because they failed to decode",
+	})
+)
+
+// decodePartial decodes an OTLP batch one resource and scope at a time. If
+// some fragments fail, the batch is replaced with the decoded metrics and
+// each failed fragment is sent to the DLQ as a batch of its own. Batches
+// that decode in full, or not at all, are left unchanged. Returns the number
+// of data points dropped with the failed fragments.
+func (r *RX) decodePartial(ctx context.Context, batch *fb.MetricBatch) (int, error) {
+	format := batch.Format
+	if format == "" {
+		format = codec.DetectFormat(batch.Data)
+	}
+	if format != codec.FormatOTLPProtobuf {
+		return 0, nil
+	}
+
+	// Batches with nothing to salvage are forwarded unchanged, as in strict
+	// mode
+	metrics, fragments, err := codec.DecodeOTLPPartial(batch.Data)
+	if err != nil || len(fragments) == 0 || len(metrics) == 0 {
+		return 0, nil
+	}
+
+	rejected := 0
+	for i, fragment := range fragments {
+		fragmentBatch := &fb.MetricBatch{
+			BatchID:          fmt.Sprintf("%s-fragment-%d", batch.BatchID, i),
+			Data:             fragment.Data,
+			Format:           codec.FormatOTLPProtobuf,
+			Replay:           batch.Replay,
+			ConfigGeneration: batch.ConfigGeneration,
+			Metadata:         batch.Metadata,
+			InternalLabels:   make(map[string]string, len(batch.InternalLabels)+3),
+		}
+		for k, v := range batch.InternalLabels {
+			fragmentBatch.InternalLabels[k] = v
+		}
+		fragmentBatch.InternalLabels["fragment_of"] = batch.BatchID
+		fragmentBatch.InternalLabels["fragment_resource_index"] = strconv.Itoa(fragment.ResourceIndex)
+		fragmentBatch.InternalLabels["fragment_scope_index"] = strconv.Itoa(fragment.ScopeIndex)
+		fb.RefreshChecksum(fragmentBatch)
+
+		// Poison fragments are dropped, which rejects their data points all
+		// the same
+		if err := r.sendToDLQ(ctx, fragmentBatch, fragment.Err); err != nil && !errors.Is(err, fb.ErrPoisonBatch) {
+			return 0, fmt.Errorf("failed to send fragment %d to DLQ: %w", i, err)
+		}
+		droppedFragmentsTotal.Inc()
+		rejected += fragment.DataPoints
+	}
+
+	r.logger.Warn("Forwarding partially decoded batch", map[string]interface{}{
+		"batch_id":             batch.BatchID,
+		"metrics":              len(metrics),
+		"fragments":            len(fragments),
+		"rejected_data_points": rejected,
+	})
+	partialDecodeTotal.Inc()
+
+	return rejected, codec.Encode(batch, metrics)
+}
+
+// convertFormat re-encodes a batch in the internal format the chain runs on.
+// Batches that don't decode are forwarded unchanged and fail downstream, as
+// they would without conversion.
+func (r *RX) convertFormat(batch *fb.MetricBatch, format string) {
+	if batch.Format == format {
+		return
+	}
+
+	metrics, err := codec.Decode(batch)
+	if err == nil {
+		err = codec.EncodeAs(batch, metrics, format)
+	}
+	if err != nil {
+		unconvertedBatchesTotal.Inc()
+		r.logger.Debug("Forwarding batch in its ingest format", map[string]interface{}{
+			"batch_id": batch.BatchID,
+			"format":   batch.Format,
+			"error":    err.Error(),
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package rx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"eidc-tfk8s/internal/common/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+)
+
+// endpointDrainTimeout bounds how long a disabled endpoint waits for its
+// in-flight exports before its listener is stopped forcibly
+const endpointDrainTimeout = 30 * time.Second
+
+var endpointUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "fb_rx_endpoint_up",
+	Help: "Whether an ingestion endpoint is listening (1) or not (0)",
+}, []string{"protocol", "port"})
+
+// receiver serves an ingestion protocol on an endpoint's listener
+type receiver interface {
+	// serve accepts connections on lis until the receiver is stopped
+	serve(lis net.Listener) error
+
+	// stop stops accepting connections and waits for in-flight exports,
+	// cutting them off once ctx is done
+	stop(ctx context.Context)
+}
+
+// grpcReceiver serves OTLP/gRPC
+type grpcReceiver struct {
+	server *grpc.Server
+}
+
+func (g *grpcReceiver) serve(lis net.Listener) error {
+	return g.server.Serve(lis)
+}
+
+func (g *grpcReceiver) stop(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		g.server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		g.server.Stop()
+		<-done
+	}
+}
+
+// endpointListener is a running ingestion endpoint
+type endpointListener struct {
+	endpoint Endpoint
+	receiver receiver
+	done     chan struct{}
+
+	// Whether the ReplayService is served alongside the protocol
+	replay bool
+}
+
+// key identifies the endpoint across config updates
+func (e Endpoint) key() string {
+	return e.Protocol + ":" + strconv.Itoa(e.Port)
+}
+
+// setEndpointUp records whether the endpoint is listening
+func setEndpointUp(endpoint Endpoint, up bool) {
+	value := 0.0
+	if up {
+		value = 1
+	}
+	endpointUp.WithLabelValues(endpoint.Protocol, strconv.Itoa(endpoint.Port)).Set(value)
+}
+
+// newReceiver creates the receiver for an endpoint's protocol. gRPC
+// receivers also serve the standard gRPC health service, and the
+// ReplayService if replay is set.
+func (r *RX) newReceiver(protocol string, replay bool) (receiver, error) {
+	switch protocol {
+	case "otlp/grpc":
+		server := grpc.NewServer(metrics.GRPCServerOptions(r.Name())...)
+		RegisterOTLPMetricsServiceServer(server, r)
+		if replay {
+			RegisterReplayServiceServer(server, r)
+		}
+		r.RegisterHealthServer(server)
+		r.RegisterReflectionServer(server)
+		return &grpcReceiver{server: server}, nil
+	default:
+		return nil, fmt.Errorf("unsupported endpoint protocol: %s", protocol)
+	}
+}
+
+// updateEndpoints starts listening on newly enabled endpoints and stops the
+// endpoints that were disabled or removed, letting their in-flight exports
+// finish. Endpoints that are already listening are left running, unless
+// replay was enabled or disabled, which restarts them to add or remove the
+// ReplayService.
+func (r *RX) updateEndpoints(endpoints []Endpoint, replay bool) {
+	r.listenersMu.Lock()
+	defer r.listenersMu.Unlock()
+
+	enabled := make(map[string]Endpoint)
+	for _, endpoint := range endpoints {
+		if endpoint.Enabled {
+			enabled[endpoint.key()] = endpoint
+		} else {
+			setEndpointUp(endpoint, false)
+		}
+	}
+
+	for key, listener := range r.listeners {
+		if _, ok := enabled[key]; !ok || listener.replay != replay {
+			r.stopListener(listener)
+			delete(r.listeners, key)
+		}
+	}
+
+	for key, endpoint := range enabled {
+		if _, ok := r.listeners[key]; ok {
+			continue
+		}
+
+		listener, err := r.startListener(endpoint, replay)
+		if err != nil {
+			r.logger.Warn("Failed to start endpoint", map[string]interface{}{
+				"protocol": endpoint.Protocol,
+				"port":     endpoint.Port,
+				"error":    err.Error(),
+			})
+			setEndpointUp(endpoint, false)
+			continue
+		}
+
+		if r.listeners == nil {
+			r.listeners = make(map[string]*endpointListener)
+		}
+		r.listeners[key] = listener
+	}
+}
+
+// startListener listens on the endpoint's port and serves its protocol
+func (r *RX) startListener(endpoint Endpoint, replay bool) (*endpointListener, error) {
+	recv, err := r.newReceiver(endpoint.Protocol, replay)
+	if err != nil {
+		return nil, err
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", endpoint.Port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on port %d: %w", endpoint.Port, err)
+	}
+
+	listener := &endpointListener{
+		endpoint: endpoint,
+		receiver: recv,
+		done:     make(chan struct{}),
+		replay:   replay,
+	}
+	go func() {
+		defer close(listener.done)
+		if err := recv.serve(lis); err != nil {
+			r.logger.Error("Endpoint stopped serving", err, map[string]interface{}{
+				"protocol": endpoint.Protocol,
+				"port":     endpoint.Port,
+			})
+			setEndpointUp(endpoint, false)
+		}
+	}()
+
+	setEndpointUp(endpoint, true)
+	r.logger.Info("Endpoint listening", map[string]interface{}{
+		"protocol": endpoint.Protocol,
+		"port":     endpoint.Port,
+	})
+
+	return listener, nil
+}
+
+// stopListener stops an endpoint once its in-flight exports have finished,
+// or endpointDrainTimeout has passed
+func (r *RX) stopListener(listener *endpointListener) {
+	ctx, cancel := context.WithTimeout(context.Background(), endpointDrainTimeout)
+	defer cancel()
+
+	listener.receiver.stop(ctx)
+	<-listener.done
+
+	setEndpointUp(listener.endpoint, false)
+	r.logger.Info("Endpoint stopped", map[string]interface{}{
+		"protocol": listener.endpoint.Protocol,
+		"port":     listener.endpoint.Port,
+	})
+}
@@ -0,0 +1,142 @@
+package rx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"eidc-tfk8s/pkg/fb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// mirrorTimeout bounds each mirrored send, which runs detached from the
+// request of the batch it copies
+const mirrorTimeout = 10 * time.Second
+
+var mirroredBatchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_rx_mirrored_batches_total",
+	Help: "Total number of batches mirrored to the secondary next FB, by outcome",
+}, []string{"outcome"})
+
+// validateMirror checks the mirror settings
+func (c *RXConfig) validateMirror() error {
+	if c.MirrorSampleRatio < 0 || c.MirrorSampleRatio > 1 {
+		return fmt.Errorf("invalid mirror sample ratio: %v, must be between 0 and 1", c.MirrorSampleRatio)
+	}
+	if c.MirrorNextFB != "" && c.MirrorNextFB == c.Common.NextFB {
+		return fmt.Errorf("mirror next FB must differ from the next FB: %s", c.MirrorNextFB)
+	}
+	return nil
+}
+
+// mirrorBatch sends a copy of a sampled fraction of batches to the mirror
+// next FB in the background. The primary path stays authoritative: mirrored
+// sends are fire-and-forget, never sent to the DLQ, and their failures are
+// only logged and counted.
+func (r *RX) mirrorBatch(ctx context.Context, batch *fb.MetricBatch) {
+	r.configMu.RLock()
+	ratio := r.config.MirrorSampleRatio
+	r.configMu.RUnlock()
+
+	r.mirrorMu.RLock()
+	client := r.mirrorClient
+	r.mirrorMu.RUnlock()
+
+	if client == nil || !fb.SampledBatch(batch.BatchID, ratio) {
+		return
+	}
+
+	// Copy what the primary path may still modify, e.g. the DLQ sender's
+	// error labels
+	req := &fb.MetricBatchRequest{
+		BatchId:          batch.BatchID,
+		Data:             batch.Data,
+		Format:           batch.Format,
+		Replay:           batch.Replay,
+		ConfigGeneration: batch.ConfigGeneration,
+		Metadata:         copyLabels(batch.Metadata),
+		InternalLabels:   copyLabels(batch.InternalLabels),
+	}
+
+	r.mirrorWG.Add(1)
+	go func() {
+		defer r.mirrorWG.Done()
+
+		// The batch's request may complete before the mirror does
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mirrorTimeout)
+		defer cancel()
+
+		res, err := client.PushMetrics(ctx, req)
+		if err == nil && res.Status != fb.StatusSuccess {
+			err = fmt.Errorf("mirror next FB returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		}
+		if err != nil {
+			mirroredBatchesTotal.WithLabelValues("failed").Inc()
+			r.logger.WarnCtx(ctx, "Failed to mirror batch", map[string]interface{}{
+				"batch_id": req.BatchId,
+				"error":    err.Error(),
+			})
+			return
+		}
+		mirroredBatchesTotal.WithLabelValues("mirrored").Inc()
+	}()
+}
+
+// copyLabels returns a copy of a label map
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
+
+// connectToMirror connects to the mirror next FB, or disconnects if addr
+// is empty
+func (r *RX) connectToMirror(ctx context.Context, addr string) error {
+	r.closeMirror()
+	if addr == "" {
+		return nil
+	}
+
+	conn, err := r.DialContext(ctx, addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to mirror next FB: %w", err)
+	}
+
+	r.mirrorMu.Lock()
+	r.mirrorConn = conn
+	r.mirrorClient = fb.NewChainPushServiceClient(conn)
+	r.mirrorMu.Unlock()
+
+	return nil
+}
+
+// closeMirror closes the connection to the mirror next FB, if any
+func (r *RX) closeMirror() {
+	r.mirrorMu.Lock()
+	defer r.mirrorMu.Unlock()
+
+	if r.mirrorConn != nil {
+		r.mirrorConn.Close()
+	}
+	r.mirrorConn = nil
+	r.mirrorClient = nil
+}
+
+// SetMirrorClientForTesting sets the mirror next FB client for testing
+// purposes
+func (r *RX) SetMirrorClientForTesting(client fb.ChainPushServiceClient) {
+	r.mirrorMu.Lock()
+	r.mirrorClient = client
+	r.mirrorMu.Unlock()
+}
@@ -0,0 +1,60 @@
+package rx
+
+import (
+	"context"
+	"fmt"
+
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpMetricsServer is the OTLP/gRPC metrics receiver of FB-RX. Each export
+// is processed as one batch.
+type otlpMetricsServer struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	rx *RX
+}
+
+// RegisterOTLPMetricsServiceServer registers RX as the OTLP MetricsService
+// with the given grpc.Server
+func RegisterOTLPMetricsServiceServer(s *grpc.Server, r *RX) {
+	colmetricspb.RegisterMetricsServiceServer(s, &otlpMetricsServer{rx: r})
+}
+
+// Export implements colmetricspb.MetricsServiceServer. Exports that are
+// accepted with some data points dropped get a partial success reporting how
+// many, as the OTLP spec requires.
+func (s *otlpMetricsServer) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return nil, fb.ErrorCodeInvalidInput.GRPCError(fmt.Errorf("failed to encode export: %w", err))
+	}
+
+	// The batch gets an id derived from its content, the same when the
+	// exporter retries it
+	batch := &fb.MetricBatch{
+		Data:   data,
+		Format: codec.FormatOTLPProtobuf,
+	}
+
+	result, err := s.rx.ProcessBatch(ctx, batch)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, result.ErrorCode.GRPCError(err)
+	}
+
+	res := &colmetricspb.ExportMetricsServiceResponse{}
+	if result.RejectedDataPoints > 0 {
+		res.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: int64(result.RejectedDataPoints),
+			ErrorMessage:       fmt.Sprintf("%d data points failed to decode and were sent to the DLQ", result.RejectedDataPoints),
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,205 @@
+package rx
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"eidc-tfk8s/internal/common/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var tenantThrottledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_rx_tenant_throttled_total",
+	Help: "Total number of batches rejected because the tenant exceeded its rate limit",
+}, []string{"tenant"})
+
+const (
+	// tenantBucketSweepInterval is how often the buckets of idle tenants are
+	// evicted
+	tenantBucketSweepInterval = time.Minute
+
+	// defaultMaxTenantBuckets caps the number of tenants with a bucket. Past
+	// it, the bucket of the tenant seen least recently is evicted.
+	defaultMaxTenantBuckets = 10000
+)
+
+// TenantRateLimitConfig configures per-tenant rate limiting of incoming batches
+type TenantRateLimitConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// DefaultRate is the number of batches per second allowed for tenants
+	// without an override
+	DefaultRate float64 `json:"defaultRate"`
+
+	// DefaultBurst is the bucket size for tenants without an override. It
+	// defaults to the rate rounded up.
+	DefaultBurst int `json:"defaultBurst"`
+
+	// Overrides sets the limit for specific tenants
+	Overrides map[string]TenantLimit `json:"overrides"`
+}
+
+// TenantLimit is the rate limit for a single tenant
+type TenantLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// validate checks the rate limit configuration
+func (c TenantRateLimitConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.DefaultRate <= 0 {
+		return fmt.Errorf("tenant rate limit defaultRate must be positive")
+	}
+	if c.DefaultBurst < 0 {
+		return fmt.Errorf("tenant rate limit defaultBurst must not be negative")
+	}
+	for tenant, limit := range c.Overrides {
+		if limit.Rate <= 0 {
+			return fmt.Errorf("tenant rate limit for %s must have a positive rate", tenant)
+		}
+		if limit.Burst < 0 {
+			return fmt.Errorf("tenant rate limit burst for %s must not be negative", tenant)
+		}
+	}
+	return nil
+}
+
+// limitFor returns the rate and burst for a tenant
+func (c TenantRateLimitConfig) limitFor(tenant string) (float64, float64) {
+	limit, ok := c.Overrides[tenant]
+	if !ok {
+		limit = TenantLimit{Rate: c.DefaultRate, Burst: c.DefaultBurst}
+	}
+
+	burst := float64(limit.Burst)
+	if burst == 0 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+	return limit.Rate, burst
+}
+
+// tokenBucket is a token bucket refilled at rate tokens per second up to burst
+type tokenBucket struct {
+	rate       float64
+	burst      float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+// allow takes a token if one is available
+func (b *tokenBucket) allow(now time.Time) bool {
+	if elapsed := now.Sub(b.lastRefill).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.lastRefill = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full reports whether the bucket has refilled to its burst by now, making it
+// the same as a new bucket
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.lastRefill).Seconds()*b.rate >= b.burst
+}
+
+// tenantRateLimiter keeps a token bucket per tenant. Buckets that refilled
+// are evicted, as a new bucket has the same tokens, and at most maxBuckets
+// are kept.
+type tenantRateLimiter struct {
+	mu         sync.Mutex
+	config     TenantRateLimitConfig
+	buckets    map[string]*tokenBucket
+	maxBuckets int
+	lastSweep  time.Time
+}
+
+// newTenantRateLimiter creates a rate limiter with the given configuration
+func newTenantRateLimiter(config TenantRateLimitConfig) *tenantRateLimiter {
+	return &tenantRateLimiter{
+		config:     config,
+		buckets:    make(map[string]*tokenBucket),
+		maxBuckets: defaultMaxTenantBuckets,
+	}
+}
+
+// update applies a new configuration. Existing buckets keep their tokens,
+// capped at the new burst, so a config push doesn't reset tenants' limits.
+func (l *tenantRateLimiter) update(config TenantRateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.config = config
+	for tenant, bucket := range l.buckets {
+		bucket.rate, bucket.burst = config.limitFor(tenant)
+		bucket.tokens = math.Min(bucket.tokens, bucket.burst)
+	}
+}
+
+// allow reports whether a batch from tenant may be processed now
+func (l *tenantRateLimiter) allow(tenant string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.config.Enabled {
+		return true
+	}
+
+	if now.Sub(l.lastSweep) >= tenantBucketSweepInterval {
+		l.evictFull(now)
+	}
+
+	bucket, ok := l.buckets[tenant]
+	if !ok {
+		if len(l.buckets) >= l.maxBuckets {
+			l.evictLeastRecent()
+		}
+		rate, burst := l.config.limitFor(tenant)
+		bucket = &tokenBucket{
+			rate:       rate,
+			burst:      burst,
+			tokens:     burst,
+			lastRefill: now,
+		}
+		l.buckets[tenant] = bucket
+	}
+
+	if bucket.allow(now) {
+		return true
+	}
+
+	tenantThrottledTotal.WithLabelValues(metrics.TenantLabel("fb-rx", tenant)).Inc()
+	return false
+}
+
+// evictFull removes the buckets that refilled since they were last used
+func (l *tenantRateLimiter) evictFull(now time.Time) {
+	for tenant, bucket := range l.buckets {
+		if bucket.full(now) {
+			delete(l.buckets, tenant)
+		}
+	}
+	l.lastSweep = now
+}
+
+// evictLeastRecent removes the bucket of the tenant seen least recently,
+// resetting its limit, to make room for a new tenant
+func (l *tenantRateLimiter) evictLeastRecent() {
+	var oldestTenant string
+	var oldest time.Time
+	for tenant, bucket := range l.buckets {
+		if oldestTenant == "" || bucket.lastRefill.Before(oldest) {
+			oldestTenant, oldest = tenant, bucket.lastRefill
+		}
+	}
+	delete(l.buckets, oldestTenant)
+}
@@ -0,0 +1,101 @@
+package rx
+
+import (
+	"fmt"
+	"time"
+
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/recording"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// recordedRequestsTotal counts the requests written to the recording, by outcome
+var recordedRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "fb_rx_recorded_requests_total",
+	Help: "Total number of incoming requests written to the recording, by outcome",
+}, []string{"outcome"})
+
+// RecordConfig configures record mode: every incoming request is written to
+// a recording, as received, to be replayed against a local pipeline with
+// request-replay. Recording never fails or delays a batch.
+//
+// RX records payloads before FB-CL runs, so recordings hold unhashed PII.
+// Write them only to storage restricted like the source data, enable record
+// mode only while reproducing an issue, and delete the files afterwards.
+type RecordConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Path is the current file of the recording, created if missing
+	Path string `json:"path"`
+
+	// MaxBytes is the size at which the file is rotated, and MaxFiles the
+	// number of files kept, the current one included
+	MaxBytes int64 `json:"maxBytes"`
+	MaxFiles int   `json:"maxFiles"`
+}
+
+// validate checks the record mode configuration
+func (c RecordConfig) validate() error {
+	if c.MaxBytes < 0 {
+		return fmt.Errorf("record maxBytes must not be negative")
+	}
+	if c.MaxFiles < 0 {
+		return fmt.Errorf("record maxFiles must not be negative")
+	}
+	if c.Enabled && c.Path == "" {
+		return fmt.Errorf("record requires a path")
+	}
+	return nil
+}
+
+// updateRecorder opens, reopens or closes the recording as configured
+func (r *RX) updateRecorder(old, config RecordConfig) error {
+	if config == old && (r.recorder.Load() != nil) == config.Enabled {
+		return nil
+	}
+
+	var next *recording.Writer
+	if config.Enabled {
+		var err error
+		if next, err = recording.NewWriter(config.Path, config.MaxBytes, config.MaxFiles); err != nil {
+			return err
+		}
+		r.logger.Info("Recording incoming requests", map[string]interface{}{
+			"path": config.Path,
+		})
+	}
+
+	if previous := r.recorder.Swap(next); previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// recordBatch writes an incoming batch to the recording, if record mode is
+// enabled
+func (r *RX) recordBatch(batch *fb.MetricBatch, at time.Time) {
+	w := r.recorder.Load()
+	if w == nil {
+		return
+	}
+
+	req := &fb.MetricBatchRequest{
+		BatchId:          batch.BatchID,
+		Data:             batch.Data,
+		Format:           batch.Format,
+		Replay:           batch.Replay,
+		ConfigGeneration: batch.ConfigGeneration,
+		Metadata:         batch.Metadata,
+		InternalLabels:   batch.InternalLabels,
+	}
+	if err := w.Write(req, at); err != nil {
+		recordedRequestsTotal.WithLabelValues("error").Inc()
+		r.logger.Warn("Failed to record request", map[string]interface{}{
+			"batch_id": batch.BatchID,
+			"error":    err.Error(),
+		})
+		return
+	}
+	recordedRequestsTotal.WithLabelValues("recorded").Inc()
+}
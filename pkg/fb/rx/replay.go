@@ -0,0 +1,239 @@
+package rx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "eidc-tfk8s/pkg/api/protobuf"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/dlq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var replayBackpressureTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "fb_rx_replay_backpressure_total",
+	Help: "Total number of replayed batches pushed back because too many batches were in flight",
+})
+
+// defaultReplayMaxBatches caps a single replay call when the config doesn't
+const defaultReplayMaxBatches = 1000
+
+// defaultReplayRetryAfter is the retry hint given to pushed back replays
+// when the config doesn't set one
+const defaultReplayRetryAfter = time.Second
+
+// ReplayConfig configures the replay API on FB-RX
+type ReplayConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// MaxBatches caps the number of DLQ entries a single call replays
+	MaxBatches int `json:"maxBatches"`
+
+	// HighWaterMark is the number of batches in flight above which replayed
+	// batches are pushed back with a retry hint instead of processed, so a
+	// bulk replay can't overload RX and land back in the DLQ. 0 disables it.
+	HighWaterMark int `json:"highWaterMark"`
+
+	// RetryAfterMs is the retry hint given to pushed back replays
+	RetryAfterMs int `json:"retryAfterMs"`
+}
+
+// validate checks the replay configuration
+func (c ReplayConfig) validate() error {
+	if c.MaxBatches < 0 {
+		return fmt.Errorf("replay maxBatches must not be negative")
+	}
+	if c.HighWaterMark < 0 {
+		return fmt.Errorf("replay highWaterMark must not be negative")
+	}
+	if c.RetryAfterMs < 0 {
+		return fmt.Errorf("replay retryAfterMs must not be negative")
+	}
+	return nil
+}
+
+// checkReplayBackpressure pushes back a replayed batch when more than the
+// high-water mark of batches, including this one, are in flight. It returns
+// a throttled result carrying the retry hint, or nil to process the batch.
+func (r *RX) checkReplayBackpressure(batch *fb.MetricBatch, inFlight int64) (*fb.ProcessResult, error) {
+	if !batch.Replay {
+		return nil, nil
+	}
+
+	r.configMu.RLock()
+	var replayConfig ReplayConfig
+	if r.config != nil {
+		replayConfig = r.config.Replay
+	}
+	r.configMu.RUnlock()
+
+	if replayConfig.HighWaterMark == 0 || inFlight <= int64(replayConfig.HighWaterMark) {
+		return nil, nil
+	}
+
+	retryAfter := time.Duration(replayConfig.RetryAfterMs) * time.Millisecond
+	if retryAfter == 0 {
+		retryAfter = defaultReplayRetryAfter
+	}
+
+	replayBackpressureTotal.Inc()
+	err := fb.ErrorCodeThrottled.GRPCError(fmt.Errorf("%d batches in flight, above the replay high-water mark of %d", inFlight, replayConfig.HighWaterMark))
+	result := fb.NewThrottledResult(batch.BatchID)
+	result.ErrorMessage = err.Error()
+	result.RetryAfter = retryAfter
+	return result, err
+}
+
+// SetDLQQuerier sets where replay requests read DLQ entries from
+func (r *RX) SetDLQQuerier(querier dlq.Querier) {
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+	r.dlqQuerier = querier
+}
+
+// replayServer is the ReplayService of FB-RX, served on its OTLP/gRPC
+// endpoints when replay is enabled
+type replayServer struct {
+	pb.UnimplementedReplayServiceServer
+	rx *RX
+}
+
+// RegisterReplayServiceServer registers RX as the ReplayService with the
+// given grpc.Server
+func RegisterReplayServiceServer(s *grpc.Server, r *RX) {
+	pb.RegisterReplayServiceServer(s, &replayServer{rx: r})
+}
+
+// ReplayBatch implements pb.ReplayServiceServer
+func (s *replayServer) ReplayBatch(req *pb.ReplayBatchRequest, stream pb.ReplayService_ReplayBatchServer) error {
+	if len(req.BatchIds) == 0 {
+		return status.Error(codes.InvalidArgument, "no batch ids given")
+	}
+	return s.rx.replay(stream.Context(), dlq.Filter{BatchIDs: req.BatchIds}, stream.Send)
+}
+
+// ReplayRange implements pb.ReplayServiceServer
+func (s *replayServer) ReplayRange(req *pb.ReplayRangeRequest, stream pb.ReplayService_ReplayRangeServer) error {
+	filter := dlq.Filter{
+		ErrorCode: req.ErrorCode,
+		FBSender:  req.FbSender,
+	}
+	if req.SinceUnix > 0 {
+		filter.Since = time.Unix(req.SinceUnix, 0)
+	}
+	if req.UntilUnix > 0 {
+		filter.Until = time.Unix(req.UntilUnix, 0)
+	}
+	return s.rx.replay(stream.Context(), filter, stream.Send)
+}
+
+// replay re-injects the DLQ entries matching filter through ProcessBatch,
+// reporting progress after each batch and once more when done
+func (r *RX) replay(ctx context.Context, filter dlq.Filter, send func(*pb.ReplayProgress) error) error {
+	r.configMu.RLock()
+	var replayConfig ReplayConfig
+	if r.config != nil {
+		replayConfig = r.config.Replay
+	}
+	querier := r.dlqQuerier
+	r.configMu.RUnlock()
+
+	if !replayConfig.Enabled {
+		return status.Error(codes.FailedPrecondition, "replay is disabled")
+	}
+	if querier == nil {
+		return status.Error(codes.FailedPrecondition, "no DLQ configured for replay")
+	}
+
+	filter.Limit = replayConfig.MaxBatches
+	if filter.Limit == 0 {
+		filter.Limit = defaultReplayMaxBatches
+	}
+
+	messages, err := querier.Query(ctx, filter)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to query DLQ: %v", err)
+	}
+
+	r.logger.Info("Replaying batches from DLQ", map[string]interface{}{
+		"matched": len(messages),
+	})
+
+	matched := int64(len(messages))
+	var replayed, failed int64
+	for _, message := range messages {
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+
+		result, err := r.ProcessBatch(ctx, replayBatch(message, time.Now()))
+
+		progress := &pb.ReplayProgress{
+			BatchId: message.BatchID,
+			Status:  pb.Status_STATUS_SUCCESS,
+			Matched: matched,
+		}
+		if err != nil {
+			failed++
+			progress.Status = pb.Status_STATUS_ERROR
+			progress.ErrorMessage = err.Error()
+			if result != nil {
+				progress.Status = pbStatus(result.Status)
+				progress.ErrorCode = string(result.ErrorCode)
+			}
+		} else {
+			replayed++
+		}
+		progress.Replayed = replayed
+		progress.Failed = failed
+
+		if err := send(progress); err != nil {
+			return err
+		}
+	}
+
+	r.logger.Info("Replay complete", map[string]interface{}{
+		"matched":  matched,
+		"replayed": replayed,
+		"failed":   failed,
+	})
+
+	return send(&pb.ReplayProgress{
+		Matched:  matched,
+		Replayed: replayed,
+		Failed:   failed,
+		Done:     true,
+	})
+}
+
+// pbStatus converts a processing status to its protobuf enum, which has the
+// same values
+func pbStatus(s fb.Status) pb.Status {
+	return pb.Status(s)
+}
+
+// replayBatch builds the batch to re-inject for a DLQ entry, labelled the
+// same way dlq-replay labels it
+func replayBatch(message dlq.Message, now time.Time) *fb.MetricBatch {
+	labels := make(map[string]string, len(message.InternalLabels)+3)
+	for k, v := range message.InternalLabels {
+		labels[k] = v
+	}
+	labels["replay"] = "true"
+	labels["replay_timestamp"] = now.Format(time.RFC3339)
+	fb.IncrementReplayCount(labels)
+
+	return &fb.MetricBatch{
+		BatchID:        message.BatchID,
+		Data:           message.Data,
+		Format:         message.Format,
+		Replay:         true,
+		Metadata:       message.Metadata,
+		InternalLabels: labels,
+	}
+}
@@ -2,9 +2,10 @@ package rx
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"eidc-tfk8s/internal/common/logging"
@@ -13,6 +14,8 @@ import (
 	"eidc-tfk8s/internal/common/tracing"
 	"eidc-tfk8s/internal/config"
 	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/dlq"
+	"eidc-tfk8s/pkg/fb/recording"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -24,6 +27,38 @@ type RXConfig struct {
 
 	// RX-specific configuration
 	Endpoints []Endpoint `json:"endpoints"`
+
+	// Per-tenant rate limiting of incoming batches
+	TenantRateLimit TenantRateLimitConfig `json:"tenantRateLimit"`
+
+	// Replay of DLQ entries through the ReplayService API
+	Replay ReplayConfig `json:"replay"`
+
+	// PartialDecode forwards the parts of an OTLP payload that decode and
+	// sends only the malformed ones to the DLQ. By default batches are
+	// forwarded unchanged and fail as a whole downstream.
+	PartialDecode bool `json:"partialDecode"`
+
+	// MirrorNextFB is a secondary next FB, e.g. a new pipeline version under
+	// test, that MirrorSampleRatio of batches are copied to. The next FB
+	// stays authoritative: mirroring never fails or delays a batch.
+	MirrorNextFB      string  `json:"mirrorNextFB"`
+	MirrorSampleRatio float64 `json:"mirrorSampleRatio"`
+
+	// Coalesce merges small incoming batches into fewer, larger ones
+	Coalesce CoalesceConfig `json:"coalesce"`
+
+	// BatchID configures the deduplication of client retries
+	BatchID BatchIDConfig `json:"batchId"`
+
+	// WAL logs accepted batches to disk until they are forwarded
+	WAL WALConfig `json:"wal"`
+
+	// Record writes incoming requests to a recording, to reproduce issues
+	Record RecordConfig `json:"record"`
+
+	// AdaptiveSampling drops low-priority batches under overload
+	AdaptiveSampling AdaptiveSamplingConfig `json:"adaptiveSampling"`
 }
 
 // Endpoint represents a telemetry ingestion endpoint
@@ -36,40 +71,71 @@ type Endpoint struct {
 // RX implements the FB-RX function block
 type RX struct {
 	fb.BaseFunctionBlock
-	logger          *logging.Logger
-	metrics         *metrics.FBMetrics
-	tracer          *tracing.Tracer
-	config          *RXConfig
-	configMu        sync.RWMutex
-	nextFBClient    fb.ChainPushServiceClient
-	nextFBConn      *grpc.ClientConn
-	dlqClient       fb.ChainPushServiceClient
-	dlqConn         *grpc.ClientConn
-	circuitBreaker  *resilience.CircuitBreaker
+	logger        *logging.Logger
+	metrics       *metrics.FBMetrics
+	tracer        *tracing.Tracer
+	config        *RXConfig
+	configMu      sync.RWMutex
+	nextFBClient  fb.ChainPushServiceClient
+	nextFBConn    *grpc.ClientConn
+	dlqClient     fb.ChainPushServiceClient
+	dlqConn       *grpc.ClientConn
+	nextFBBreaker *resilience.CircuitBreaker
+	dlqBreaker    *resilience.CircuitBreaker
+	rateLimiter   *tenantRateLimiter
+	dlqQuerier    dlq.Querier
+	inFlight      int64
+
+	// Connection to the mirror next FB, and the mirrored sends in flight
+	mirrorClient fb.ChainPushServiceClient
+	mirrorConn   *grpc.ClientConn
+	mirrorMu     sync.RWMutex
+	mirrorWG     sync.WaitGroup
+
+	// Merges incoming batches before forwarding
+	coalescer *coalescer
+
+	// Remembers forwarded batches to detect client retries
+	deduper *batchDeduper
+
+	// Write-ahead log of the batches being forwarded, if enabled
+	wal atomic.Pointer[writeAheadLog]
+
+	// Recording of the incoming requests, if record mode is enabled
+	recorder atomic.Pointer[recording.Writer]
+
+	// Listeners of the enabled endpoints, by Endpoint.key
+	listeners   map[string]*endpointListener
+	listenersMu sync.Mutex
 }
 
 // NewRX creates a new RX function block
 func NewRX() *RX {
-	return &RX{
-		BaseFunctionBlock: fb.BaseFunctionBlock{
-			name:  "fb-rx",
-			ready: false,
-		},
-		logger:  logging.NewLogger("fb-rx"),
-		metrics: metrics.NewFBMetrics("fb-rx"),
-		tracer:  tracing.NewTracer("fb-rx"),
+	r := &RX{
+		BaseFunctionBlock: fb.NewBaseFunctionBlock("fb-rx"),
+		logger:            logging.NewLogger("fb-rx"),
+		metrics:           metrics.NewFBMetrics("fb-rx"),
+		tracer:            tracing.NewTracer("fb-rx"),
+		rateLimiter:       newTenantRateLimiter(TenantRateLimitConfig{}),
+		deduper:           newBatchDeduper(BatchIDConfig{}),
 	}
+	r.coalescer = newCoalescer(r.forwardBatch)
+	return r
 }
 
 // Initialize initializes the RX function block
 func (r *RX) Initialize(ctx context.Context) error {
 	// Set the name and ready state
-	baseFB := fb.NewBaseFunctionBlock("fb-rx")
-	r.BaseFunctionBlock = baseFB
+	r.BaseFunctionBlock = fb.NewBaseFunctionBlock("fb-rx")
 	r.logger.Info("Initializing FB-RX", nil)
 
-	// Initialize circuit breaker
-	r.circuitBreaker = resilience.NewCircuitBreaker("fb-rx", resilience.DefaultCircuitBreakerConfig())
+	// Initialize circuit breakers
+	r.nextFBBreaker = resilience.NewCircuitBreaker("fb-rx", resilience.DownstreamNextFB, resilience.DefaultCircuitBreakerConfig())
+	r.dlqBreaker = resilience.NewCircuitBreaker("fb-rx", resilience.DownstreamDLQ, resilience.DefaultCircuitBreakerConfig())
+
+	if err := r.Transition(fb.StateInitialized); err != nil {
+		return err
+	}
 
 	// Mark as ready (full readiness will be set after config is loaded)
 	r.SetReady(true)
@@ -86,50 +152,171 @@ func (r *RX) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.Proce
 	// Record metric
 	r.metrics.RecordBatchReceived()
 
+	// Reject batches until config has been applied, and while shutting down
+	done, result, err := r.BeginBatch(batch.BatchID)
+	if err != nil {
+		return result, err
+	}
+	defer done()
+
+	// Push back replays while too many batches are in flight
+	inFlight := atomic.AddInt64(&r.inFlight, 1)
+	defer atomic.AddInt64(&r.inFlight, -1)
+	if result, err := r.checkReplayBackpressure(batch, inFlight); err != nil {
+		return result, err
+	}
+
+	// Record the request as received, before RX stamps it
+	receivedAt := time.Now()
+	r.recordBatch(batch, receivedAt)
+
+	// Stamp the pipeline ingest time for end-to-end latency tracking
+	fb.StampIngestTime(batch, receivedAt)
+
+	// Checksum the data so the FBs down the chain can detect corruption
+	fb.StampChecksum(batch)
+
+	// Carry the tenant id with the batch through the rest of the chain
+	fb.PropagateTenantID(batch)
+	r.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeReceived)
+
+	// Shed low-priority batches while saturated. They are acknowledged, so
+	// senders don't add to the load by retrying them.
+	if r.shedBatch(batch, inFlight) {
+		adaptiveDropsTotal.Inc()
+		r.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeDropped)
+		r.logger.Debug("Dropped low-priority batch, RX is saturated", map[string]interface{}{
+			"batch_id":  batch.BatchID,
+			"in_flight": inFlight,
+		})
+		return fb.NewSuccessResult(batch.BatchID), nil
+	}
+
+	// Give batches without an id one derived from their content, so traces
+	// and idempotency keys have an id to work with
+	if assignBatchID(batch) {
+		r.logger.Debug("Generated batch id", map[string]interface{}{
+			"batch_id": batch.BatchID,
+		})
+	}
+
+	// Acknowledge or reject client retries of batches already forwarded
+	if result, err := r.checkDuplicate(batch); result != nil {
+		return result, err
+	}
+
+	// Reject batches from tenants over their rate limit; the sender should retry
+	if !r.rateLimiter.allow(fb.TenantID(batch), time.Now()) {
+		err := fb.ErrorCodeThrottled.GRPCError(fmt.Errorf("tenant %q exceeded its rate limit", fb.TenantID(batch)))
+		result := fb.NewThrottledResult(batch.BatchID)
+		result.ErrorMessage = err.Error()
+		return result, err
+	}
+
+	// Forward unchanged if this FB is disabled in the pipeline config
+	if !r.Enabled() {
+		return r.BypassBatch(ctx, batch, r.forwardToNextFB)
+	}
+
 	startTime := time.Now()
 
 	// Process batch
-	processingErr := r.processBatch(ctx, batch)
+	rejected, processingErr := r.processBatch(ctx, batch)
 	if processingErr != nil {
 		r.metrics.RecordProcessingError()
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeProcessingFailed, processingErr, false), processingErr
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeProcessingFailed, processingErr), processingErr
 	}
 
 	// Record processing metrics
 	r.metrics.RecordBatchProcessed(time.Since(startTime).Seconds())
 
+	// Log the batch so that it is forwarded again if RX crashes before
+	// forwarding it
+	seq, err := r.logBatch(batch)
+	if err != nil {
+		r.logger.ErrorCtx(ctx, "Failed to log batch in WAL", err, map[string]interface{}{
+			"batch_id": batch.BatchID,
+		})
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeServiceUnavailable, err), err
+	}
+
+	// Forward, merged with other batches if coalescing is enabled
+	forwardingResult, forwardingErr := r.coalescer.process(ctx, batch)
+	r.completeBatch(seq, forwardingResult, forwardingErr)
+	if forwardingErr != nil {
+		return forwardingResult, forwardingErr
+	}
+	r.deduper.record(batch, time.Now())
+
+	forwardingResult.RejectedDataPoints = rejected
+	return forwardingResult, nil
+}
+
+// forwardBatch forwards a processed batch to the next FB, and to the mirror
+// next FB if it is sampled, sending it to the DLQ if forwarding fails
+func (r *RX) forwardBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	// Copy sampled batches to the mirror next FB, if any
+	r.mirrorBatch(ctx, batch)
+
 	// Forward to next FB
 	forwardingResult, forwardingErr := r.forwardToNextFB(ctx, batch)
 	if forwardingErr != nil {
-		// If forwarding fails but processing succeeded, attempt to send to DLQ
-		dlqErr := r.sendToDLQ(ctx, batch, forwardingErr)
+		// Batches the next FB rejected for good go to the quarantine, if
+		// any, and are reported upstream with its code so they aren't retried
+		code := fb.ForwardingErrorCode(forwardingErr)
+		dlqErr := r.SendFailedBatch(ctx, batch, code, forwardingErr, r.sendToDLQ)
 		if dlqErr != nil {
-			r.logger.Error("Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
+			if errors.Is(dlqErr, fb.ErrNoDLQ) {
+				return fb.NewCodeErrorResult(batch.BatchID, code, forwardingErr), forwardingErr
+			}
+			r.logger.ErrorCtx(ctx, "Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
 				"batch_id": batch.BatchID,
 			})
-			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeDLQSendFailed, dlqErr, false), dlqErr
+			return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
 		}
 		
 		// Return error with DLQ status
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, forwardingErr, true), forwardingErr
+		return fb.NewErrorResult(batch.BatchID, code, forwardingErr, true), forwardingErr
 	}
 
 	return forwardingResult, nil
 }
 
-// processBatch performs the actual batch processing
-func (r *RX) processBatch(ctx context.Context, batch *fb.MetricBatch) error {
+// processBatch performs the actual batch processing, returning the number of
+// data points dropped from the batch
+func (r *RX) processBatch(ctx context.Context, batch *fb.MetricBatch) (int, error) {
 	// RX doesn't do much processing, it mostly forwards to the next FB
 	// Here we'd implement telemetry parsing, normalization, etc.
-	return nil
+	r.configMu.RLock()
+	partialDecode := r.config.PartialDecode
+	internalFormat := r.config.Common.InternalFormat
+	r.configMu.RUnlock()
+
+	rejected := 0
+	if partialDecode {
+		var err error
+		if rejected, err = r.decodePartial(ctx, batch); err != nil {
+			return 0, err
+		}
+	}
+
+	if internalFormat != "" {
+		r.convertFormat(batch, internalFormat)
+	}
+	return rejected, nil
 }
 
 // forwardToNextFB forwards the batch to the next function block
 func (r *RX) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	// Fan out instead if several next FBs are configured
+	if r.FansOut() {
+		return r.ForwardToNextFBs(ctx, batch, r.sendToDLQ)
+	}
+
 	startTime := time.Now()
 
 	// Use circuit breaker to protect against downstream failures
-	err := r.circuitBreaker.Execute(ctx, func(ctx context.Context) error {
+	err := r.nextFBBreaker.Execute(ctx, func(ctx context.Context) error {
 		// Get the current config
 		r.configMu.RLock()
 		nextFB := r.config.Common.NextFB
@@ -163,7 +350,7 @@ func (r *RX) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*fb.Pr
 
 		// Check response
 		if res.Status != fb.StatusSuccess {
-			return fmt.Errorf("next FB returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+			return fb.NewNextFBError("", res)
 		}
 
 		return nil
@@ -173,17 +360,24 @@ func (r *RX) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*fb.Pr
 	r.metrics.RecordBatchForwarded(time.Since(startTime).Seconds())
 
 	if err != nil {
+		r.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeFailed)
 		if err == resilience.ErrCircuitOpen {
-			return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err, false), err
+			return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err), err
 		}
-		return fb.NewErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err, false), err
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err), err
 	}
 
+	r.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeForwarded)
 	return fb.NewSuccessResult(batch.BatchID), nil
 }
 
 // sendToDLQ sends a batch to the Dead Letter Queue
 func (r *RX) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr error) error {
+	// Without a DLQ the batch is failed back to the caller
+	if !r.HasDLQ() {
+		return fb.ErrNoDLQ
+	}
+
 	// Create child span for DLQ
 	ctx, span := r.tracer.StartSpan(ctx, "send-to-dlq", nil)
 	defer span.End()
@@ -198,7 +392,17 @@ func (r *RX) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr e
 		batch.InternalLabels = make(map[string]string)
 	}
 	batch.InternalLabels["error"] = originalErr.Error()
-	batch.InternalLabels["fb_sender"] = r.Name()
+	batch.InternalLabels["fb_sender"] = r.Sender()
+
+	// Drop poison batches rather than cycle them through the DLQ forever
+	if r.RecordDLQHop(batch.InternalLabels) {
+		r.logger.Warn("Dropping poison batch", map[string]interface{}{
+			"batch_id":     batch.BatchID,
+			"replay_count": batch.InternalLabels[fb.LabelReplayCount],
+			"error":        originalErr.Error(),
+		})
+		return fb.ErrPoisonBatch
+	}
 
 	// Convert to ChainPushService request
 	req := &fb.MetricBatchRequest{
@@ -211,19 +415,28 @@ func (r *RX) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr e
 		InternalLabels:   batch.InternalLabels,
 	}
 
-	// Send to DLQ
-	res, err := r.dlqClient.PushMetrics(ctx, req)
-	if err != nil {
-		return fmt.Errorf("failed to push metrics to DLQ: %w", err)
-	}
+	// Send to DLQ, behind its own circuit breaker so its health is
+	// tracked apart from the next FB's
+	err := r.dlqBreaker.Execute(ctx, func(ctx context.Context) error {
+		res, err := r.dlqClient.PushMetrics(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to DLQ: %w", err)
+		}
 
-	// Check response
-	if res.Status != fb.StatusSuccess {
-		return fmt.Errorf("DLQ returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		// Check response
+		if res.Status != fb.StatusSuccess {
+			return fmt.Errorf("DLQ returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Record metric
 	r.metrics.RecordBatchDLQ()
+	r.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeDropped)
 
 	return nil
 }
@@ -234,63 +447,233 @@ func (r *RX) UpdateConfig(ctx context.Context, configBytes []byte, generation in
 	ctx, span := r.tracer.StartSpan(ctx, "update-config", nil)
 	defer span.End()
 
-	// Parse configuration
-	var newConfig RXConfig
-	if err := json.Unmarshal(configBytes, &newConfig); err != nil {
-		return fmt.Errorf("failed to parse config: %w", err)
+	// Parse and validate configuration
+	newConfig, err := r.parseConfig(configBytes)
+	if err != nil {
+		return err
 	}
 
-	// Validate configuration
-	if err := r.validateConfig(&newConfig); err != nil {
-		return fmt.Errorf("invalid config: %w", err)
+	// Refuse a generation older than the one applied, unless rolling back
+	if err := r.CheckConfigGeneration(generation, newConfig.Common.Rollback); err != nil {
+		r.logger.Error("Refused configuration", err, map[string]interface{}{
+			"generation":         generation,
+			"applied_generation": r.GetConfigGeneration(),
+		})
+		return err
+	}
+
+	// Open the WAL first, recovering the batches of the previous run
+	if err := r.updateWAL(newConfig.WAL); err != nil {
+		return err
+	}
+
+	// Open the recording if record mode is enabled
+	r.configMu.RLock()
+	var oldRecord RecordConfig
+	if r.config != nil {
+		oldRecord = r.config.Record
+	}
+	r.configMu.RUnlock()
+	if err := r.updateRecorder(oldRecord, newConfig.Record); err != nil {
+		return err
 	}
 
 	// Apply configuration
 	r.configMu.Lock()
-	r.config = &newConfig
-	r.SetConfigGeneration( generation
+	oldConfig := r.config
+	var oldMirrorNextFB string
+	if r.config != nil {
+		oldMirrorNextFB = r.config.MirrorNextFB
+	}
+	r.config = newConfig
+	r.SetConfigGeneration(generation)
+	r.SetEnabled(newConfig.Common.IsEnabled())
+	r.SetMaxConcurrentBatches(newConfig.Common.MaxConcurrentBatches)
+	r.SetMaxReplayCount(newConfig.Common.MaxReplayCount)
+	r.SetHasDLQ(newConfig.Common.DLQ != "")
+	r.SetMetadataLimits(fb.MetadataLimits{
+		MaxEntries: newConfig.Common.MaxMetadataEntries,
+		MaxBytes:   newConfig.Common.MaxMetadataBytes,
+		Action:     fb.MetadataLimitAction(newConfig.Common.MetadataLimitAction),
+	})
+	if level, err := logging.ParseLevel(newConfig.Common.LogLevel); err == nil {
+		r.logger.SetLevel(level)
+	}
+	if newConfig.Common.TracingEnabled {
+		r.tracer.SetSamplingRatio(newConfig.Common.TraceSamplingRatio)
+	}
+	seed, _ := newConfig.Common.DeterministicSeed()
+	r.tracer.SetSeed(seed)
 	r.configMu.Unlock()
 
+	// Update rate limits, keeping existing tenant buckets
+	r.rateLimiter.update(newConfig.TenantRateLimit)
+
+	// Update coalescing limits, flushing waiting batches if disabled
+	r.coalescer.update(newConfig.Coalesce)
+
+	// Update deduplication, forgetting forwarded batches if disabled
+	r.deduper.update(newConfig.BatchID)
+
 	// Update circuit breaker configuration
-	r.circuitBreaker = resilience.NewCircuitBreaker("fb-rx", resilience.CircuitBreakerConfig{
-		ErrorThresholdPercentage: newConfig.Common.CircuitBreaker.ErrorThresholdPercentage,
-		OpenStateSeconds:         newConfig.Common.CircuitBreaker.OpenStateSeconds,
-		HalfOpenRequestThreshold: newConfig.Common.CircuitBreaker.HalfOpenRequestThreshold,
-	})
+	r.nextFBBreaker = resilience.NewCircuitBreaker("fb-rx", resilience.DownstreamNextFB, newConfig.Common.NextFBCircuitBreakerConfig())
+	r.dlqBreaker = resilience.NewCircuitBreaker("fb-rx", resilience.DownstreamDLQ, newConfig.Common.DLQCircuitBreakerConfig())
 
 	// Connect to next FB and DLQ
-	if err := r.connectToNextFB(ctx, newConfig.Common.NextFB); err != nil {
-		r.logger.Error("Failed to connect to next FB", err, map[string]interface{}{
-			"next_fb": newConfig.Common.NextFB,
+	if newConfig.Common.NextFB != "" {
+		if err := r.connectToNextFB(ctx, newConfig.Common.NextFB); err != nil {
+			r.logger.Error("Failed to connect to next FB", err, map[string]interface{}{
+				"next_fb": newConfig.Common.NextFB,
+			})
+			// Don't fail config update on connection error - we'll retry on next batch
+		}
+	}
+
+	if err := r.ConnectNextFBs(ctx, newConfig.Common, metrics.GRPCDialOption(r.Name())); err != nil {
+		r.logger.Error("Failed to connect to next FBs", err, map[string]interface{}{
+			"next_fbs": len(newConfig.Common.NextFBs),
 		})
-		// Don't fail config update on connection error - we'll retry on next batch
+		// Don't fail config update on connection error - batches to the
+		// targets not connected fail until the next update
+	}
+
+	if r.dlqClient == nil && newConfig.Common.DLQ != "" {
+		if err := r.connectToDLQ(ctx, newConfig.Common.DLQ); err != nil {
+			r.logger.Error("Failed to connect to DLQ", err, map[string]interface{}{
+				"dlq": newConfig.Common.DLQ,
+			})
+			// Don't fail config update on connection error - we'll retry when needed
+		}
 	}
 
+	r.mirrorMu.RLock()
+	mirrorConnected := r.mirrorClient != nil
+	r.mirrorMu.RUnlock()
+	if newConfig.MirrorNextFB != oldMirrorNextFB || (!mirrorConnected && newConfig.MirrorNextFB != "") {
+		if err := r.connectToMirror(ctx, newConfig.MirrorNextFB); err != nil {
+			r.logger.Error("Failed to connect to mirror next FB", err, map[string]interface{}{
+				"mirror_next_fb": newConfig.MirrorNextFB,
+			})
+			// Don't fail config update on connection error - batches aren't
+			// mirrored until the next update connects
+		}
+	}
+
+	// Start processing now that config is applied
+	if err := r.ConfigApplied(); err != nil {
+		return err
+	}
+
+	// Forward the batches recovered from the WAL ahead of new ones
+	r.replayWAL(ctx)
+
+	// Start and stop listeners for endpoints enabled or disabled by the update
+	r.updateEndpoints(newConfig.Endpoints, newConfig.Replay.Enabled)
+
 	// Update metrics
 	r.metrics.SetConfigGeneration(generation)
 	r.metrics.SetReady(true)
 
+	// Report what the update changed, for rollout audits
+	changes, _ := config.DiffConfig(oldConfig, newConfig)
+
 	r.logger.Info("Config updated", map[string]interface{}{
 		"generation": generation,
 		"next_fb":    newConfig.Common.NextFB,
 		"endpoints":  len(newConfig.Endpoints),
+		"enabled":    newConfig.Common.IsEnabled(),
+		"changes":    changes,
 	})
 
 	return nil
 }
 
+// ValidateConfig parses and validates a configuration without applying it
+func (r *RX) ValidateConfig(configBytes []byte) error {
+	_, err := r.parseConfig(configBytes)
+	return err
+}
+
+// parseConfig parses and validates a configuration
+func (r *RX) parseConfig(configBytes []byte) (*RXConfig, error) {
+	// Merge a patch onto the applied config
+	r.configMu.RLock()
+	applied := r.config
+	r.configMu.RUnlock()
+	configBytes, err := config.ResolveMergePatch(configBytes, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	var newConfig RXConfig
+	if err := config.LoadConfigFromBytes(configBytes, &newConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if err := r.validateConfig(&newConfig); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &newConfig, nil
+}
+
 // validateConfig validates the RX function block's configuration
 func (r *RX) validateConfig(config *RXConfig) error {
+	// Validate common settings
+	if err := config.Common.Validate(); err != nil {
+		return err
+	}
+
 	// Check if at least one endpoint is configured
 	if len(config.Endpoints) == 0 {
 		return fmt.Errorf("no endpoints configured")
 	}
 
 	// Check if next FB is configured
-	if config.Common.NextFB == "" {
+	if !config.Common.HasNextFB() {
 		return fmt.Errorf("next FB not configured")
 	}
 
+	// Validate tenant rate limits
+	if err := config.TenantRateLimit.validate(); err != nil {
+		return err
+	}
+
+	// Validate replay settings
+	if err := config.Replay.validate(); err != nil {
+		return err
+	}
+
+	// Validate mirror settings
+	if err := config.validateMirror(); err != nil {
+		return err
+	}
+
+	// Validate adaptive sampling settings
+	if err := config.validateAdaptiveSampling(); err != nil {
+		return err
+	}
+
+	// Validate coalescing settings
+	if err := config.Coalesce.validate(); err != nil {
+		return err
+	}
+
+	// Validate batch id settings
+	if err := config.BatchID.validate(); err != nil {
+		return err
+	}
+
+	// Validate WAL settings
+	if err := config.WAL.validate(); err != nil {
+		return err
+	}
+
+	// Validate record mode settings
+	if err := config.Record.validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -304,9 +687,10 @@ func (r *RX) connectToNextFB(ctx context.Context, nextFB string) error {
 	}
 
 	// Create new connection
-	conn, err := grpc.DialContext(ctx, nextFB,
+	conn, err := r.DialContext(ctx, nextFB,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithBlock(),
+		metrics.GRPCDialOption(r.Name()),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to connect to next FB: %w", err)
@@ -328,7 +712,7 @@ func (r *RX) connectToDLQ(ctx context.Context, dlqAddr string) error {
 	}
 
 	// Create new connection
-	conn, err := grpc.DialContext(ctx, dlqAddr,
+	conn, err := r.DialContext(ctx, dlqAddr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithBlock(),
 	)
@@ -346,12 +730,47 @@ func (r *RX) connectToDLQ(ctx context.Context, dlqAddr string) error {
 func (r *RX) Shutdown(ctx context.Context) error {
 	r.logger.Info("Shutting down FB-RX", nil)
 
-	// Close connections
+	// Stop accepting batches
+	if err := r.Transition(fb.StateDraining); err != nil {
+		return err
+	}
+
+	// Stop all endpoints, letting in-flight exports finish
+	r.updateEndpoints(nil, false)
+
+	// Let batches in flight finish, including their DLQ sends
+	drainErr := r.DrainBatches(ctx)
+	if drainErr != nil {
+		r.logger.Warn("Shutting down with batches still in flight", map[string]interface{}{
+			"error": drainErr.Error(),
+		})
+	}
+
+	// Forward batches waiting to be merged while still connected
+	r.coalescer.flushAll()
+
+	// Close the WAL, keeping the batches not forwarded for the next start
+	if w := r.wal.Swap(nil); w != nil {
+		w.close()
+	}
+
+	// Close the recording
+	if w := r.recorder.Swap(nil); w != nil {
+		w.Close()
+	}
+
+	// Close connections, the DLQ last as failures forwarding above may
+	// still need it
 	if r.nextFBConn != nil {
 		r.nextFBConn.Close()
 		r.nextFBConn = nil
 		r.nextFBClient = nil
 	}
+	r.CloseNextFBs()
+
+	// Let mirrored sends in flight finish before closing their connection
+	r.mirrorWG.Wait()
+	r.closeMirror()
 
 	if r.dlqConn != nil {
 		r.dlqConn.Close()
@@ -360,9 +779,12 @@ func (r *RX) Shutdown(ctx context.Context) error {
 	}
 
 	// Mark as not ready
-	r.BaseFunctionBlock.ready = false
+	r.SetReady(false)
 
-	return nil
+	if err := r.Transition(fb.StateStopped); err != nil {
+		return err
+	}
+	return drainErr
 }
 
 // SetNextFBClientForTesting sets the next FB client for testing purposes
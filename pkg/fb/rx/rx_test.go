@@ -1,17 +1,46 @@
 package rx
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/common/metrics"
 	"eidc-tfk8s/internal/common/resilience"
+	"eidc-tfk8s/internal/common/tracing"
 	"eidc-tfk8s/internal/config"
+	pb "eidc-tfk8s/pkg/api/protobuf"
 	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/benchmark"
+	"eidc-tfk8s/pkg/fb/codec"
+	"eidc-tfk8s/pkg/fb/dlq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
 )
 
 // MockChainPushServiceClient is a mock client for the ChainPushService
@@ -101,6 +130,53 @@ func TestRX_UpdateConfig(t *testing.T) {
 	assert.Contains(t, err.Error(), "no endpoints configured")
 }
 
+func TestRX_ValidateConfig_DoesNotApply(t *testing.T) {
+	r := NewRX()
+	require.NoError(t, r.Initialize(context.Background()))
+
+	validConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+			},
+		},
+	}
+	configBytes, err := json.Marshal(validConfig)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+	current := r.config
+
+	// An invalid config fails validation
+	invalidConfig := validConfig
+	invalidConfig.Endpoints = nil
+	configBytes, err = json.Marshal(invalidConfig)
+	require.NoError(t, err)
+
+	err = r.ValidateConfig(configBytes)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no endpoints configured")
+
+	assert.Error(t, r.ValidateConfig([]byte(`{"endpoints":`)))
+
+	// A valid config passes validation without being applied
+	otherConfig := validConfig
+	otherConfig.Common.NextFB = "fb-other:5000"
+	configBytes, err = json.Marshal(otherConfig)
+	require.NoError(t, err)
+	require.NoError(t, r.ValidateConfig(configBytes))
+
+	assert.Same(t, current, r.config)
+	assert.Equal(t, "fb-next:5000", r.config.Common.NextFB)
+	assert.Equal(t, int64(1), r.GetConfigGeneration())
+}
+
 func TestRX_ProcessBatch_Success(t *testing.T) {
 	r := NewRX()
 	err := r.Initialize(context.Background())
@@ -230,6 +306,81 @@ func TestRX_ProcessBatch_NextFBFailure(t *testing.T) {
 	mockDLQ.AssertExpectations(t)
 }
 
+// tenantBatches returns the fb_tenant_batches_total count of an FB, tenant
+// and outcome
+func tenantBatches(t *testing.T, fbName, tenant, outcome string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "fb_tenant_batches_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["fb_name"] == fbName && labels["tenant"] == tenant && labels["outcome"] == outcome {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestRX_ProcessBatch_RecordsTenantOutcome(t *testing.T) {
+	r := NewRX()
+	require.NoError(t, r.Initialize(context.Background()))
+
+	configBytes, err := json.Marshal(RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+			DLQ:    "fb-dlq:5000",
+		},
+		Endpoints: []Endpoint{
+			{Protocol: "otlp/grpc", Port: 4317},
+		},
+	})
+	require.NoError(t, err)
+
+	// Don't wait for the unreachable next FB and DLQ; the mocks replace them
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	fail := true
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			if fail {
+				return nil, errors.New("next FB unavailable")
+			}
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	r.SetDLQClientForTesting(&fb.MockChainPushServiceClient{})
+
+	newBatch := func(batchID string) *fb.MetricBatch {
+		return &fb.MetricBatch{
+			BatchID:  batchID,
+			Data:     []byte(`[]`),
+			Format:   "json",
+			Metadata: map[string]string{fb.LabelTenantID: "outcome-tenant"},
+		}
+	}
+
+	// A batch the next FB fails isn't counted as forwarded
+	_, err = r.ProcessBatch(context.Background(), newBatch("batch-1"))
+	assert.Error(t, err)
+	assert.Equal(t, 0.0, tenantBatches(t, "fb-rx", "outcome-tenant", metrics.TenantOutcomeForwarded))
+	assert.Equal(t, 1.0, tenantBatches(t, "fb-rx", "outcome-tenant", metrics.TenantOutcomeFailed))
+
+	fail = false
+	_, err = r.ProcessBatch(context.Background(), newBatch("batch-2"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, tenantBatches(t, "fb-rx", "outcome-tenant", metrics.TenantOutcomeForwarded))
+	assert.Equal(t, 1.0, tenantBatches(t, "fb-rx", "outcome-tenant", metrics.TenantOutcomeFailed))
+}
+
 func TestRX_ProcessBatch_CircuitBreakerOpen(t *testing.T) {
 	r := NewRX()
 	err := r.Initialize(context.Background())
@@ -262,7 +413,7 @@ func TestRX_ProcessBatch_CircuitBreakerOpen(t *testing.T) {
 
 	// Replace circuit breaker with a mock that always returns open
 	mockCB := new(MockCircuitBreaker)
-	r.circuitBreaker = mockCB
+	r.nextFBBreaker = mockCB
 
 	// Mock the circuit breaker to return ErrCircuitOpen
 	mockCB.On("Execute", mock.Anything, mock.Anything).Return(resilience.ErrCircuitOpen)
@@ -295,4 +446,1743 @@ func TestRX_Shutdown(t *testing.T) {
 	assert.False(t, r.Ready())
 }
 
+func TestRX_ProcessBatch_DisabledBypassesProcessing(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	// Set up mock next FB client
+	mockNextFB := new(MockChainPushServiceClient)
+	r.nextFBClient = mockNextFB
+
+	// Configure with enabled:false
+	enabled := false
+	disabledConfig := RXConfig{
+		Common: config.FBConfig{
+			Enabled: &enabled,
+			NextFB:  "fb-next:5000",
+			DLQ:     "fb-dlq:5000",
+			CircuitBreaker: config.CircuitBreakerConfig{
+				ErrorThresholdPercentage: 50,
+				OpenStateSeconds:         5,
+				HalfOpenRequestThreshold: 3,
+			},
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+	}
+
+	configBytes, err := json.Marshal(disabledConfig)
+	assert.NoError(t, err)
+
+	// Don't wait for the unreachable next FB; the mock replaces it
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = r.UpdateConfig(ctx, configBytes, 1)
+	assert.NoError(t, err)
+	assert.False(t, r.Enabled())
+
+	data := []byte(`{"resource_metrics":[{"resource":{"attributes":{"service.name":"test-service"}}}]}`)
+
+	// The next FB must receive the batch data untouched
+	mockNextFB.On("PushMetrics", mock.Anything, mock.MatchedBy(func(req *fb.MetricBatchRequest) bool {
+		return req.BatchId == "test-batch-id" && string(req.Data) == string(data)
+	})).Return(&fb.MetricBatchResponse{
+		Status:  fb.StatusSuccess,
+		BatchId: "test-batch-id",
+	}, nil)
+
+	batch := &fb.MetricBatch{
+		BatchID: "test-batch-id",
+		Data:    data,
+		Format:  "otlp",
+	}
+
+	result, err := r.ProcessBatch(context.Background(), batch)
+	assert.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+	mockNextFB.AssertExpectations(t)
+}
+
+func TestRX_UpdateConfig_LogLevel(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	r.logger.WithWriter(&buf)
+
+	r.nextFBClient = new(MockChainPushServiceClient)
+	r.dlqClient = new(MockChainPushServiceClient)
+
+	errorConfig := RXConfig{
+		Common: config.FBConfig{
+			LogLevel: "error",
+			NextFB:   "fb-next:5000",
+			DLQ:      "fb-dlq:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+	}
+
+	configBytes, err := json.Marshal(errorConfig)
+	assert.NoError(t, err)
+
+	// Don't wait for the unreachable next FB and DLQ; the mocks replace them
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = r.UpdateConfig(ctx, configBytes, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, logging.Error, r.logger.GetLevel())
+
+	// Info and Debug output must be suppressed
+	buf.Reset()
+	r.logger.Info("info message", nil)
+	r.logger.Debug("debug message", nil)
+	assert.Empty(t, buf.String())
+
+	// Error output must still be written
+	r.logger.Error("error message", errors.New("boom"), nil)
+	assert.Contains(t, buf.String(), "error message")
+
+	// Invalid log levels are rejected
+	errorConfig.Common.LogLevel = "verbose"
+	configBytes, err = json.Marshal(errorConfig)
+	assert.NoError(t, err)
+
+	err = r.UpdateConfig(ctx, configBytes, 2)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid log level")
+}
+
+func TestRX_UpdateConfig_TraceSamplingRatio(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	r.nextFBClient = new(MockChainPushServiceClient)
+	r.dlqClient = new(MockChainPushServiceClient)
+
+	tracingConfig := RXConfig{
+		Common: config.FBConfig{
+			TracingEnabled:     true,
+			TraceSamplingRatio: 1.0,
+			NextFB:             "fb-next:5000",
+			DLQ:                "fb-dlq:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+	}
+
+	configBytes, err := json.Marshal(tracingConfig)
+	assert.NoError(t, err)
+
+	// Don't wait for the unreachable next FB and DLQ; the mocks replace them
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = r.UpdateConfig(ctx, configBytes, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, tracing.SamplingRatio())
+
+	// Lower the ratio and verify the sampler is swapped
+	tracingConfig.Common.TraceSamplingRatio = 0.25
+	configBytes, err = json.Marshal(tracingConfig)
+	assert.NoError(t, err)
+
+	err = r.UpdateConfig(ctx, configBytes, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.25, tracing.SamplingRatio())
+
+	// Out of range ratios are rejected and leave the sampler unchanged
+	tracingConfig.Common.TraceSamplingRatio = 1.5
+	configBytes, err = json.Marshal(tracingConfig)
+	assert.NoError(t, err)
+
+	err = r.UpdateConfig(ctx, configBytes, 3)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid trace sampling ratio")
+	assert.Equal(t, 0.25, tracing.SamplingRatio())
+}
+
+func TestRX_ProcessBatch_RejectedBeforeConfig(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, fb.StateInitialized, r.State())
+
+	batch := &fb.MetricBatch{
+		BatchID: "test-batch-id",
+		Data:    []byte(`{}`),
+		Format:  "otlp",
+	}
+
+	// Batches are rejected with a retryable error until config is applied
+	result, err := r.ProcessBatch(context.Background(), batch)
+	assert.ErrorIs(t, err, fb.ErrNotRunning)
+	assert.Equal(t, fb.ErrorCodeServiceUnavailable, result.ErrorCode)
+	assert.False(t, result.SentToDLQ)
+
+	// Shutdown moves through Draining to Stopped
+	err = r.Shutdown(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, fb.StateStopped, r.State())
+
+	// A stopped FB can't be shut down again
+	err = r.Shutdown(context.Background())
+	assert.ErrorIs(t, err, fb.ErrIllegalTransition)
+}
+
+func TestTenantRateLimiter_DefaultAndOverride(t *testing.T) {
+	limiter := newTenantRateLimiter(TenantRateLimitConfig{
+		Enabled:      true,
+		DefaultRate:  1,
+		DefaultBurst: 2,
+		Overrides: map[string]TenantLimit{
+			"big-tenant": {Rate: 10, Burst: 5},
+		},
+	})
+	now := time.Now()
+
+	// Default tenants get the default burst
+	assert.True(t, limiter.allow("acme", now))
+	assert.True(t, limiter.allow("acme", now))
+	assert.False(t, limiter.allow("acme", now))
+
+	// Tenants have independent buckets, and overrides take precedence
+	for i := 0; i < 5; i++ {
+		assert.True(t, limiter.allow("big-tenant", now))
+	}
+	assert.False(t, limiter.allow("big-tenant", now))
+
+	// Buckets refill at the configured rate
+	assert.True(t, limiter.allow("acme", now.Add(time.Second)))
+	assert.False(t, limiter.allow("acme", now.Add(time.Second)))
+	assert.True(t, limiter.allow("big-tenant", now.Add(100*time.Millisecond)))
+}
+
+func TestTenantRateLimiter_UpdateKeepsBuckets(t *testing.T) {
+	limiter := newTenantRateLimiter(TenantRateLimitConfig{
+		Enabled:     true,
+		DefaultRate: 1,
+	})
+	now := time.Now()
+
+	assert.True(t, limiter.allow("acme", now))
+	assert.False(t, limiter.allow("acme", now))
+
+	// A config update with a higher burst doesn't refill the existing bucket
+	limiter.update(TenantRateLimitConfig{
+		Enabled:      true,
+		DefaultRate:  1,
+		DefaultBurst: 10,
+	})
+	assert.False(t, limiter.allow("acme", now))
+
+	// Disabling the limiter lets everything through
+	limiter.update(TenantRateLimitConfig{})
+	assert.True(t, limiter.allow("acme", now))
+}
+
+func TestTenantRateLimiter_EvictsIdleBuckets(t *testing.T) {
+	limiter := newTenantRateLimiter(TenantRateLimitConfig{
+		Enabled:     true,
+		DefaultRate: 1,
+	})
+	now := time.Now()
+
+	assert.True(t, limiter.allow("idle", now))
+	assert.True(t, limiter.allow("busy", now.Add(tenantBucketSweepInterval-500*time.Millisecond)))
+	assert.False(t, limiter.allow("busy", now.Add(tenantBucketSweepInterval-500*time.Millisecond)))
+	assert.Len(t, limiter.buckets, 2)
+
+	// The next sweep evicts the refilled bucket and keeps the one still
+	// refilling
+	assert.True(t, limiter.allow("other", now.Add(tenantBucketSweepInterval)))
+	assert.Len(t, limiter.buckets, 2)
+	assert.NotContains(t, limiter.buckets, "idle")
+	assert.Contains(t, limiter.buckets, "busy")
+
+	// Past the cap, the least recently seen tenant makes room
+	limiter.maxBuckets = 2
+	assert.True(t, limiter.allow("new", now.Add(tenantBucketSweepInterval)))
+	assert.Len(t, limiter.buckets, 2)
+	assert.NotContains(t, limiter.buckets, "busy")
+}
+
+func TestRX_ProcessBatch_TenantThrottled(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	mockNextFB := new(MockChainPushServiceClient)
+	r.nextFBClient = mockNextFB
+	r.dlqClient = new(MockChainPushServiceClient)
+
+	rateLimitedConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+			DLQ:    "fb-dlq:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+		TenantRateLimit: TenantRateLimitConfig{
+			Enabled:      true,
+			DefaultRate:  0.001,
+			DefaultBurst: 1,
+		},
+	}
+
+	configBytes, err := json.Marshal(rateLimitedConfig)
+	assert.NoError(t, err)
+
+	// Don't wait for the unreachable next FB and DLQ; the mocks replace them
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = r.UpdateConfig(ctx, configBytes, 1)
+	assert.NoError(t, err)
+
+	mockNextFB.On("PushMetrics", mock.Anything, mock.Anything).Return(&fb.MetricBatchResponse{
+		Status: fb.StatusSuccess,
+	}, nil).Once()
+
+	newBatch := func(batchID string) *fb.MetricBatch {
+		return &fb.MetricBatch{
+			BatchID:  batchID,
+			Data:     []byte(`{"resource_metrics":[]}`),
+			Format:   "otlp",
+			Metadata: map[string]string{fb.LabelTenantID: "noisy"},
+		}
+	}
+
+	result, err := r.ProcessBatch(context.Background(), newBatch("batch-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+
+	// The second batch is over the limit and is not forwarded
+	result, err = r.ProcessBatch(context.Background(), newBatch("batch-2"))
+	assert.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, fb.StatusThrottled, result.Status)
+	assert.Equal(t, fb.ErrorCodeThrottled, result.ErrorCode)
+	assert.True(t, result.Retriable)
+	mockNextFB.AssertNumberOfCalls(t, "PushMetrics", 1)
+
+	// Invalid limits are rejected
+	rateLimitedConfig.TenantRateLimit.DefaultRate = 0
+	configBytes, err = json.Marshal(rateLimitedConfig)
+	assert.NoError(t, err)
+	assert.Error(t, r.UpdateConfig(ctx, configBytes, 2))
+}
+
+// fakeDLQ serves DLQ entries from memory
+type fakeDLQ struct {
+	messages []dlq.Message
+}
+
+func (f *fakeDLQ) Query(ctx context.Context, filter dlq.Filter) ([]dlq.Message, error) {
+	var matched []dlq.Message
+	for _, message := range f.messages {
+		if filter.Matches(message) {
+			matched = append(matched, message)
+		}
+	}
+	return matched, nil
+}
+
+// startReplayClient serves RX's gRPC receiver over an in-memory listener and
+// returns a ReplayService client of it
+func startReplayClient(t *testing.T, r *RX, replay bool) pb.ReplayServiceClient {
+	return pb.NewReplayServiceClient(dialReceiver(t, r, replay))
+}
+
+// dialReceiver serves RX's gRPC receiver over an in-memory listener and
+// returns a connection to it
+func dialReceiver(t *testing.T, r *RX, replay bool) *grpc.ClientConn {
+	recv, err := r.newReceiver("otlp/grpc", replay)
+	require.NoError(t, err)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go recv.serve(lis)
+	t.Cleanup(func() { recv.stop(context.Background()) })
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestRX_GRPCReceiver_ServesHealth(t *testing.T) {
+	r := NewRX()
+	require.NoError(t, r.Initialize(context.Background()))
+
+	client := healthpb.NewHealthClient(dialReceiver(t, r, false))
+
+	// Not serving until config has been applied
+	res, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "fb-rx"})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, res.Status)
+
+	require.NoError(t, r.ConfigApplied())
+	res, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+}
+
+// replayProgress reads a replay stream to its end
+func replayProgress(stream grpc.ClientStream) ([]*pb.ReplayProgress, error) {
+	var progress []*pb.ReplayProgress
+	for {
+		update := &pb.ReplayProgress{}
+		if err := stream.RecvMsg(update); err != nil {
+			if errors.Is(err, io.EOF) {
+				return progress, nil
+			}
+			return progress, err
+		}
+		progress = append(progress, update)
+	}
+}
+
+func TestRX_Replay(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
 
+	replayConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+			DLQ:    "fb-dlq:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+		Replay: ReplayConfig{Enabled: true},
+	}
+
+	configBytes, err := json.Marshal(replayConfig)
+	assert.NoError(t, err)
+
+	// Don't wait for the unreachable next FB; the mock replaces it
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	var forwarded []*fb.MetricBatchRequest
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			forwarded = append(forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	now := time.Now()
+	r.SetDLQQuerier(&fakeDLQ{messages: []dlq.Message{
+		{BatchID: "old", Data: []byte(`[]`), Format: "json", Timestamp: now.Add(-2 * time.Hour), ErrorCode: string(fb.ErrorCodeForwardingFailed), FBSender: "fb-gw"},
+		{BatchID: "recent-gw", Data: []byte(`[]`), Format: "json", Timestamp: now.Add(-time.Minute), ErrorCode: string(fb.ErrorCodeForwardingFailed), FBSender: "fb-gw"},
+		{BatchID: "recent-dp", Data: []byte(`[]`), Format: "json", Timestamp: now.Add(-time.Minute), ErrorCode: string(fb.ErrorCodeInvalidInput), FBSender: "fb-dp"},
+	}})
+
+	client := startReplayClient(t, r, true)
+
+	// Replay a range filtered by time and sender
+	rangeStream, err := client.ReplayRange(context.Background(), &pb.ReplayRangeRequest{
+		SinceUnix: now.Add(-time.Hour).Unix(),
+		FbSender:  "fb-gw",
+	})
+	require.NoError(t, err)
+	progress, err := replayProgress(rangeStream)
+	assert.NoError(t, err)
+
+	if assert.Len(t, forwarded, 1) {
+		assert.Equal(t, "recent-gw", forwarded[0].BatchId)
+		assert.True(t, forwarded[0].Replay)
+		assert.Equal(t, "true", forwarded[0].InternalLabels["replay"])
+		assert.NotEmpty(t, forwarded[0].InternalLabels["replay_timestamp"])
+		assert.Equal(t, "1", forwarded[0].InternalLabels[fb.LabelReplayCount])
+	}
+	if assert.Len(t, progress, 2) {
+		assert.Equal(t, "recent-gw", progress[0].BatchId)
+		assert.Equal(t, pb.Status_STATUS_SUCCESS, progress[0].Status)
+		assert.True(t, proto.Equal(&pb.ReplayProgress{Matched: 1, Replayed: 1, Done: true}, progress[1]))
+	}
+
+	// Replay specific batches
+	forwarded = nil
+	batchStream, err := client.ReplayBatch(context.Background(), &pb.ReplayBatchRequest{BatchIds: []string{"old", "recent-dp"}})
+	require.NoError(t, err)
+	_, err = replayProgress(batchStream)
+	assert.NoError(t, err)
+	if assert.Len(t, forwarded, 2) {
+		assert.Equal(t, "old", forwarded[0].BatchId)
+		assert.Equal(t, "recent-dp", forwarded[1].BatchId)
+	}
+}
+
+func TestRX_ReplayDisabled(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+	r.SetDLQQuerier(&fakeDLQ{})
+
+	// Without replay enabled the receiver doesn't serve the ReplayService
+	stream, err := startReplayClient(t, r, false).ReplayBatch(context.Background(), &pb.ReplayBatchRequest{BatchIds: []string{"batch-1"}})
+	require.NoError(t, err)
+	_, err = replayProgress(stream)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	// Nor does RX replay while its config disables it
+	stream, err = startReplayClient(t, r, true).ReplayBatch(context.Background(), &pb.ReplayBatchRequest{BatchIds: []string{"batch-1"}})
+	require.NoError(t, err)
+	_, err = replayProgress(stream)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestRX_ProcessBatch_ReplayBackpressure(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	backpressureConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+			DLQ:    "fb-dlq:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+		Replay: ReplayConfig{HighWaterMark: 1, RetryAfterMs: 250},
+	}
+
+	configBytes, err := json.Marshal(backpressureConfig)
+	assert.NoError(t, err)
+
+	// Don't wait for the unreachable next FB; the mock replaces it
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{})
+
+	// Another batch is already in flight, so this one is over the mark
+	r.inFlight = 1
+
+	result, err := r.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID: "replayed",
+		Data:    []byte(`[]`),
+		Format:  "json",
+		Replay:  true,
+	})
+	assert.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, fb.StatusThrottled, result.Status)
+	assert.Equal(t, fb.ErrorCodeThrottled, result.ErrorCode)
+	assert.True(t, result.Retriable)
+	assert.Equal(t, 250*time.Millisecond, result.RetryAfter)
+
+	// Live traffic isn't pushed back
+	result, err = r.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID: "live",
+		Data:    []byte(`[]`),
+		Format:  "json",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+	assert.Equal(t, int64(1), r.inFlight)
+}
+
+func TestRX_ProcessBatch_AdaptiveSampling(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	samplingConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+		AdaptiveSampling: AdaptiveSamplingConfig{
+			Enabled:     true,
+			MaxInFlight: 10,
+			Threshold:   0.5,
+		},
+	}
+
+	configBytes, err := json.Marshal(samplingConfig)
+	assert.NoError(t, err)
+
+	// Don't wait for the unreachable next FB; the mock replaces it
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	var mu sync.Mutex
+	forwarded := map[string]int{}
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			forwarded[in.InternalLabels["priority"]]++
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	const batches = 200
+	send := func(saturation int, priority string) {
+		mu.Lock()
+		forwarded = map[string]int{}
+		mu.Unlock()
+
+		// The batch being processed makes up the last in flight
+		r.inFlight = int64(saturation - 1)
+		for i := 0; i < batches; i++ {
+			result, err := r.ProcessBatch(context.Background(), &fb.MetricBatch{
+				BatchID:        fmt.Sprintf("%s-%d-%d", priority, saturation, i),
+				Data:           []byte(`[]`),
+				Format:         "json",
+				InternalLabels: map[string]string{"priority": priority},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, fb.StatusSuccess, result.Status)
+		}
+	}
+
+	dropsBefore := testutil.ToFloat64(adaptiveDropsTotal)
+	lastDropped := -1
+	for _, saturation := range []int{5, 7, 9, 10} {
+		send(saturation, "low")
+		mu.Lock()
+		dropped := batches - forwarded["low"]
+		mu.Unlock()
+
+		if saturation == 5 {
+			// At the threshold, nothing is dropped
+			assert.Equal(t, 0, dropped)
+		} else {
+			assert.Greater(t, dropped, lastDropped, "saturation %d", saturation)
+		}
+		lastDropped = dropped
+
+		// High-priority batches always pass
+		send(saturation, "high")
+		mu.Lock()
+		assert.Equal(t, batches, forwarded["high"], "saturation %d", saturation)
+		mu.Unlock()
+	}
+
+	// Full saturation drops every low-priority batch
+	assert.Equal(t, batches, lastDropped)
+	assert.Greater(t, testutil.ToFloat64(adaptiveDropsTotal), dropsBefore)
+}
+
+func TestRX_ProcessBatch_PartialDecode(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	partialConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+			DLQ:    "fb-dlq:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+		PartialDecode: true,
+	}
+
+	configBytes, err := json.Marshal(partialConfig)
+	assert.NoError(t, err)
+
+	// Don't wait for the unreachable next FB; the mock replaces it
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	var forwarded, dlqd []*fb.MetricBatchRequest
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			forwarded = append(forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	r.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqd = append(dlqd, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	// A valid resource, a resource with an unsupported histogram, and a
+	// malformed resource
+	data, err := proto.Marshal(&metricspb.MetricsData{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{{
+					Name: "cpu_usage",
+					Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+						DataPoints: []*metricspb.NumberDataPoint{{
+							TimeUnixNano: 1700000000e9,
+							Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 0.75},
+						}},
+					}},
+				}},
+			}}},
+			{ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{{
+					Name: "request_duration_seconds",
+					Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{}},
+				}},
+			}}},
+		},
+	})
+	require.NoError(t, err)
+	data = append(data, 0x0a, 0x02, 0xff, 0xff)
+
+	partialBefore := testutil.ToFloat64(partialDecodeTotal)
+	droppedBefore := testutil.ToFloat64(droppedFragmentsTotal)
+
+	result, err := r.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID: "mixed",
+		Data:    data,
+		Format:  codec.FormatOTLPProtobuf,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+
+	// The decoded metrics are forwarded
+	require.Len(t, forwarded, 1)
+	assert.Equal(t, "mixed", forwarded[0].BatchId)
+	assert.Equal(t, codec.FormatJSON, forwarded[0].Format)
+	metrics, err := codec.Decode(&fb.MetricBatch{Data: forwarded[0].Data, Format: forwarded[0].Format})
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "cpu_usage", metrics[0]["name"])
+
+	// Only the failed fragments go to the DLQ
+	require.Len(t, dlqd, 2)
+	assert.Equal(t, "mixed-fragment-0", dlqd[0].BatchId)
+	assert.Equal(t, codec.FormatOTLPProtobuf, dlqd[0].Format)
+	assert.Equal(t, "mixed", dlqd[0].InternalLabels["fragment_of"])
+	assert.Equal(t, "1", dlqd[0].InternalLabels["fragment_resource_index"])
+	assert.Equal(t, "0", dlqd[0].InternalLabels["fragment_scope_index"])
+	assert.Contains(t, dlqd[0].InternalLabels["error"], "unsupported OTLP metric type")
+	assert.Equal(t, "2", dlqd[1].InternalLabels["fragment_resource_index"])
+	assert.Equal(t, "-1", dlqd[1].InternalLabels["fragment_scope_index"])
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(partialDecodeTotal)-partialBefore)
+	assert.Equal(t, float64(2), testutil.ToFloat64(droppedFragmentsTotal)-droppedBefore)
+}
+
+func TestRX_ProcessBatch_ConvertsToInternalFormat(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	binaryConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB:         "fb-next:5000",
+			InternalFormat: codec.FormatInternalProtobuf,
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+	}
+
+	configBytes, err := json.Marshal(binaryConfig)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	var forwarded []*fb.MetricBatchRequest
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			forwarded = append(forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	data, err := proto.Marshal(&metricspb.MetricsData{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{{
+					Name: "cpu_usage",
+					Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+						DataPoints: []*metricspb.NumberDataPoint{{
+							TimeUnixNano: 1700000000e9,
+							Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 0.75},
+						}},
+					}},
+				}},
+			}},
+		}},
+	})
+	require.NoError(t, err)
+	want, err := codec.Decode(&fb.MetricBatch{Data: data, Format: codec.FormatOTLPProtobuf})
+	require.NoError(t, err)
+
+	unconvertedBefore := testutil.ToFloat64(unconvertedBatchesTotal)
+
+	for _, batch := range []*fb.MetricBatch{
+		{BatchID: "otlp", Data: data, Format: codec.FormatOTLPProtobuf},
+		{BatchID: "malformed", Data: []byte{0x0a, 0xff}, Format: codec.FormatOTLPProtobuf},
+	} {
+		result, err := r.ProcessBatch(context.Background(), batch)
+		require.NoError(t, err)
+		assert.Equal(t, fb.StatusSuccess, result.Status)
+	}
+
+	// The ingested batch travels the chain in the internal format, with a
+	// checksum of the converted data
+	require.Len(t, forwarded, 2)
+	assert.Equal(t, codec.FormatInternalProtobuf, forwarded[0].Format)
+	assert.Equal(t, fb.Checksum(forwarded[0].Data), forwarded[0].InternalLabels[fb.LabelChecksum])
+	metrics, err := codec.Decode(&fb.MetricBatch{Data: forwarded[0].Data, Format: forwarded[0].Format})
+	require.NoError(t, err)
+	assert.Equal(t, want, metrics)
+
+	// Batches that don't decode are forwarded as ingested
+	assert.Equal(t, codec.FormatOTLPProtobuf, forwarded[1].Format)
+	assert.Equal(t, []byte{0x0a, 0xff}, forwarded[1].Data)
+	assert.Equal(t, float64(1), testutil.ToFloat64(unconvertedBatchesTotal)-unconvertedBefore)
+}
+
+func TestOTLPMetricsServer_Export_ReportsRejectedDataPoints(t *testing.T) {
+	r := NewRX()
+	err := r.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	partialConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+			DLQ:    "fb-dlq:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+		PartialDecode: true,
+	}
+
+	configBytes, err := json.Marshal(partialConfig)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	var dlqd []*fb.MetricBatchRequest
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	r.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqd = append(dlqd, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	gauge := &metricspb.Metric{
+		Name: "cpu_usage",
+		Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{{
+				TimeUnixNano: 1700000000e9,
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 0.75},
+			}},
+		}},
+	}
+	histogram := &metricspb.Metric{
+		Name: "request_duration_seconds",
+		Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			DataPoints: []*metricspb.HistogramDataPoint{
+				{TimeUnixNano: 1700000000e9, Count: 3},
+				{TimeUnixNano: 1700000001e9, Count: 5},
+				{TimeUnixNano: 1700000002e9, Count: 8},
+			},
+		}},
+	}
+	server := &otlpMetricsServer{rx: r}
+
+	// A fully decoded export is a plain success
+	res, err := server.Export(context.Background(), &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{gauge}}}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, res.PartialSuccess)
+
+	// The unsupported histogram is dropped, and its data points reported
+	res, err = server.Export(context.Background(), &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{gauge}}}},
+			{ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{histogram}}}},
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, res.PartialSuccess)
+	require.Len(t, dlqd, 1)
+
+	var dropped metricspb.MetricsData
+	require.NoError(t, proto.Unmarshal(dlqd[0].Data, &dropped))
+	droppedPoints := 0
+	for _, resourceMetrics := range dropped.ResourceMetrics {
+		for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+			for _, metric := range scopeMetrics.Metrics {
+				droppedPoints += len(metric.GetHistogram().GetDataPoints())
+			}
+		}
+	}
+	assert.Equal(t, 3, droppedPoints)
+	assert.Equal(t, int64(droppedPoints), res.PartialSuccess.RejectedDataPoints)
+	assert.Contains(t, res.PartialSuccess.ErrorMessage, "3 data points")
+}
+
+func TestRX_TrippedNextFBBreakerStillSendsToDLQ(t *testing.T) {
+	r := NewRX()
+	require.NoError(t, r.Initialize(context.Background()))
+
+	breakerConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+			DLQ:    "fb-dlq:5000",
+			CircuitBreaker: config.CircuitBreakerConfig{
+				ErrorThresholdPercentage: 50,
+				OpenStateSeconds:         60,
+				HalfOpenRequestThreshold: 1,
+			},
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+	}
+
+	configBytes, err := json.Marshal(breakerConfig)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	nextFBCalls := 0
+	var dlqd []*fb.MetricBatchRequest
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			nextFBCalls++
+			return nil, status.Error(codes.Unavailable, "next FB down")
+		},
+	})
+	r.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqd = append(dlqd, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	// The failed forward trips the next FB breaker
+	result, err := r.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: "batch-1", Data: []byte(`{}`)})
+	require.Error(t, err)
+	assert.True(t, result.SentToDLQ)
+	assert.Equal(t, resilience.StateOpen, r.nextFBBreaker.GetState())
+
+	// With the next FB breaker open, batches still reach the DLQ
+	result, err = r.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: "batch-2", Data: []byte(`{}`)})
+	require.ErrorIs(t, err, resilience.ErrCircuitOpen)
+	assert.True(t, result.SentToDLQ)
+	assert.Equal(t, 1, nextFBCalls)
+
+	require.Len(t, dlqd, 2)
+	assert.Equal(t, "batch-1", dlqd[0].BatchId)
+	assert.Equal(t, "batch-2", dlqd[1].BatchId)
+	assert.Equal(t, resilience.StateClosed, r.dlqBreaker.GetState())
+}
+
+// freePort returns a TCP port nothing is listening on
+func freePort(t *testing.T) int {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestRX_UpdateConfig_TogglesEndpoint(t *testing.T) {
+	r := NewRX()
+	require.NoError(t, r.Initialize(context.Background()))
+
+	port := freePort(t)
+	endpointConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     port,
+				Enabled:  true,
+			},
+		},
+	}
+	up := endpointUp.WithLabelValues("otlp/grpc", strconv.Itoa(port))
+
+	updateConfig := func(generation int64) {
+		configBytes, err := json.Marshal(endpointConfig)
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		require.NoError(t, r.UpdateConfig(ctx, configBytes, generation))
+	}
+
+	// Enabling the endpoint starts its listener
+	updateConfig(1)
+	assert.Equal(t, float64(1), testutil.ToFloat64(up))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			close(started)
+			<-release
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	conn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := colmetricspb.NewMetricsServiceClient(conn)
+
+	exported := make(chan error, 1)
+	go func() {
+		_, err := client.Export(context.Background(), &colmetricspb.ExportMetricsServiceRequest{})
+		exported <- err
+	}()
+	<-started
+
+	// Disabling the endpoint waits for the in-flight export
+	endpointConfig.Endpoints[0].Enabled = false
+	updated := make(chan struct{})
+	go func() {
+		defer close(updated)
+		updateConfig(2)
+	}()
+
+	select {
+	case <-updated:
+		t.Fatal("endpoint stopped before its in-flight export finished")
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+	require.NoError(t, <-exported)
+	<-updated
+	assert.Equal(t, float64(0), testutil.ToFloat64(up))
+
+	// The stopped endpoint no longer accepts exports
+	_, err = client.Export(context.Background(), &colmetricspb.ExportMetricsServiceRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestRX_ProcessBatch_DropsPoisonBatch(t *testing.T) {
+	r := NewRX()
+	require.NoError(t, r.Initialize(context.Background()))
+
+	poisonConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB:         "fb-next:5000",
+			DLQ:            "fb-dlq:5000",
+			MaxReplayCount: 2,
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+			},
+		},
+	}
+
+	configBytes, err := json.Marshal(poisonConfig)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	var dlqd []*fb.MetricBatchRequest
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			return nil, status.Error(codes.InvalidArgument, "rejected")
+		},
+	})
+	r.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqd = append(dlqd, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	// A batch within the limit goes to the DLQ with its count incremented
+	_, err = r.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID:        "replayed-once",
+		Data:           []byte(`{}`),
+		Replay:         true,
+		InternalLabels: map[string]string{fb.LabelReplayCount: "1"},
+	})
+	require.Error(t, err)
+	require.Len(t, dlqd, 1)
+	assert.Equal(t, "2", dlqd[0].InternalLabels[fb.LabelReplayCount])
+
+	// A batch exceeding the limit is dropped instead, and not retried
+	result, err := r.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID:        "replayed-twice",
+		Data:           []byte(`{}`),
+		Replay:         true,
+		InternalLabels: map[string]string{fb.LabelReplayCount: "2"},
+	})
+	require.ErrorIs(t, err, fb.ErrPoisonBatch)
+	assert.Equal(t, fb.ErrorCodePoisonBatch, result.ErrorCode)
+	assert.False(t, result.SentToDLQ)
+	assert.False(t, result.Retriable)
+	assert.Len(t, dlqd, 1)
+}
+
+func TestDefaultConfig_MatchesParametersSchema(t *testing.T) {
+	configBytes, err := json.Marshal(DefaultConfig())
+	require.NoError(t, err)
+	assert.NoError(t, config.ValidateParameters("fb-rx", configBytes))
+
+	// Mistyped parameters are rejected
+	assert.Error(t, config.ValidateParameters("fb-rx", []byte(`{"endpoints":[{"protocol":"otlp/grpc","port":"4317"}]}`)))
+	assert.Error(t, config.ValidateParameters("fb-rx", []byte(`{"replay":{"maxBatches":1.5}}`)))
+}
+
+func TestRX_ProcessBatch_MirrorsSampledBatches(t *testing.T) {
+	r := NewRX()
+	require.NoError(t, r.Initialize(context.Background()))
+
+	mirrorConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+		MirrorNextFB:      "fb-next-v2:5000",
+		MirrorSampleRatio: 0.25,
+	}
+
+	configBytes, err := json.Marshal(mirrorConfig)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{})
+
+	var mirroredMu sync.Mutex
+	mirrored := make(map[string]bool)
+	var mirrorErr error
+	r.SetMirrorClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			mirroredMu.Lock()
+			defer mirroredMu.Unlock()
+			mirrored[in.BatchId] = true
+			if mirrorErr != nil {
+				return nil, mirrorErr
+			}
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	processBatches := func(prefix string) int {
+		sampled := 0
+		for i := 0; i < 400; i++ {
+			batchID := fmt.Sprintf("%s-%d", prefix, i)
+			if fb.SampledBatch(batchID, 0.25) {
+				sampled++
+			}
+			result, err := r.ProcessBatch(context.Background(), &fb.MetricBatch{
+				BatchID: batchID,
+				Data:    []byte(`[{"name":"cpu","value":1}]`),
+				Format:  "json",
+			})
+			require.NoError(t, err)
+			assert.Equal(t, fb.StatusSuccess, result.Status)
+		}
+		r.mirrorWG.Wait()
+		return sampled
+	}
+
+	// The sampled fraction of batches is mirrored
+	mirroredTotal := mirroredBatchesTotal.WithLabelValues("mirrored")
+	before := testutil.ToFloat64(mirroredTotal)
+	sampled := processBatches("batch")
+	assert.InDelta(t, 100, sampled, 30)
+	assert.Len(t, mirrored, sampled)
+	for batchID := range mirrored {
+		assert.True(t, fb.SampledBatch(batchID, 0.25), batchID)
+	}
+	assert.Equal(t, float64(sampled), testutil.ToFloat64(mirroredTotal)-before)
+
+	// Mirror failures don't affect the primary response
+	mirroredMu.Lock()
+	mirrorErr = errors.New("mirror unavailable")
+	mirrored = make(map[string]bool)
+	mirroredMu.Unlock()
+	failedTotal := mirroredBatchesTotal.WithLabelValues("failed")
+	before = testutil.ToFloat64(failedTotal)
+	sampled = processBatches("failing")
+	assert.Len(t, mirrored, sampled)
+	assert.Equal(t, float64(sampled), testutil.ToFloat64(failedTotal)-before)
+}
+
+func TestRX_ValidateConfig_Mirror(t *testing.T) {
+	r := NewRX()
+
+	rxConfig := DefaultConfig()
+	rxConfig.Common.NextFB = "fb-next:5000"
+	rxConfig.MirrorNextFB = "fb-next-v2:5000"
+	rxConfig.MirrorSampleRatio = 1.5
+	configBytes, err := json.Marshal(rxConfig)
+	require.NoError(t, err)
+	assert.ErrorContains(t, r.ValidateConfig(configBytes), "mirror sample ratio")
+
+	rxConfig.MirrorSampleRatio = 0.5
+	rxConfig.MirrorNextFB = rxConfig.Common.NextFB
+	configBytes, err = json.Marshal(rxConfig)
+	require.NoError(t, err)
+	assert.ErrorContains(t, r.ValidateConfig(configBytes), "must differ")
+}
+
+func TestRX_ProcessBatch_CoalescesSmallBatches(t *testing.T) {
+	r := NewRX()
+	require.NoError(t, r.Initialize(context.Background()))
+
+	coalesceConfig := RXConfig{
+		Common: config.FBConfig{
+			NextFB: "fb-next:5000",
+		},
+		Endpoints: []Endpoint{
+			{
+				Protocol: "otlp/grpc",
+				Port:     4317,
+				Enabled:  true,
+			},
+		},
+		Coalesce: CoalesceConfig{
+			Enabled:    true,
+			MaxBatches: 5,
+			MaxWaitMs:  10000,
+		},
+	}
+
+	configBytes, err := json.Marshal(coalesceConfig)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	var forwardedMu sync.Mutex
+	var forwarded []*fb.MetricBatchRequest
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			forwardedMu.Lock()
+			defer forwardedMu.Unlock()
+			forwarded = append(forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	// Five batches arriving within the window are forwarded as one
+	var wg sync.WaitGroup
+	results := make([]*fb.ProcessResult, 5)
+	errs := make([]error, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = r.ProcessBatch(context.Background(), &fb.MetricBatch{
+				BatchID:  fmt.Sprintf("batch-%d", i),
+				Data:     []byte(fmt.Sprintf(`[{"name":"cpu","value":%d}]`, i)),
+				Format:   codec.FormatJSON,
+				Metadata: map[string]string{"tenant_id": "acme", "source": fmt.Sprintf("host-%d", i)},
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		require.NoError(t, errs[i])
+		assert.Equal(t, fb.StatusSuccess, result.Status)
+		assert.Equal(t, fmt.Sprintf("batch-%d", i), result.BatchID)
+	}
+
+	require.Len(t, forwarded, 1)
+	merged := forwarded[0]
+	metrics, err := codec.Decode(&fb.MetricBatch{Data: merged.Data, Format: merged.Format})
+	require.NoError(t, err)
+	assert.Len(t, metrics, 5)
+
+	// Labels all batches agree on are kept, conflicting ones dropped, and the
+	// checksum matches the merged data
+	assert.Equal(t, "acme", merged.InternalLabels[fb.LabelTenantID])
+	assert.Equal(t, "acme", merged.Metadata["tenant_id"])
+	assert.NotContains(t, merged.Metadata, "source")
+	assert.Equal(t, fb.Checksum(merged.Data), merged.InternalLabels[fb.LabelChecksum])
+	assert.Contains(t, merged.InternalLabels, fb.LabelIngestTimestamp)
+
+	// Batches are flushed after the wait even below the limits
+	coalesceConfig.Coalesce = CoalesceConfig{Enabled: true, MaxWaitMs: 20}
+	configBytes, err = json.Marshal(coalesceConfig)
+	require.NoError(t, err)
+	require.NoError(t, r.UpdateConfig(ctx, configBytes, 2))
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			forwardedMu.Lock()
+			defer forwardedMu.Unlock()
+			forwarded = append(forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := r.ProcessBatch(context.Background(), &fb.MetricBatch{
+				BatchID: fmt.Sprintf("late-%d", i),
+				Data:    []byte(`[{"name":"mem","value":1}]`),
+				Format:  codec.FormatJSON,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, fb.StatusSuccess, result.Status)
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, forwarded, 2)
+}
+
+func TestMergeLabels(t *testing.T) {
+	merged := mergeLabels([]map[string]string{
+		{"tenant_id": "acme", "host": "a", fb.LabelIngestTimestamp: "200", fb.LabelChecksum: "1"},
+		nil,
+		{"tenant_id": "acme", "host": "b", "region": "eu", fb.LabelIngestTimestamp: "100", fb.LabelChecksum: "2"},
+		{"host": "a", fb.LabelIngestTimestamp: "300"},
+	})
+	assert.Equal(t, map[string]string{
+		"tenant_id":             "acme",
+		"region":                "eu",
+		fb.LabelIngestTimestamp: "100",
+	}, merged)
+
+	assert.Nil(t, mergeLabels([]map[string]string{nil, nil}))
+}
+
+// newBatchIDTestRX creates a running RX with the given batch id config,
+// forwarding to a mock next FB that fails while failForwards is set
+func newBatchIDTestRX(t *testing.T, batchID BatchIDConfig, forwarded *[]*fb.MetricBatchRequest, failForwards *bool) *RX {
+	r := NewRX()
+	require.NoError(t, r.Initialize(context.Background()))
+
+	rxConfig := DefaultConfig()
+	rxConfig.Common.NextFB = "fb-next:5000"
+	rxConfig.BatchID = batchID
+	configBytes, err := json.Marshal(rxConfig)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			if *failForwards {
+				return nil, errors.New("next FB unavailable")
+			}
+			*forwarded = append(*forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	return r
+}
+
+func TestRX_ProcessBatch_GeneratesContentDerivedBatchID(t *testing.T) {
+	var forwarded []*fb.MetricBatchRequest
+	failForwards := false
+	r := newBatchIDTestRX(t, BatchIDConfig{}, &forwarded, &failForwards)
+
+	newBatch := func(tenant, data string) *fb.MetricBatch {
+		return &fb.MetricBatch{
+			Data:     []byte(data),
+			Format:   codec.FormatJSON,
+			Metadata: map[string]string{"tenant_id": tenant},
+		}
+	}
+
+	result, err := r.ProcessBatch(context.Background(), newBatch("acme", `[{"name":"cpu","value":1}]`))
+	require.NoError(t, err)
+	require.Len(t, forwarded, 1)
+	generated := forwarded[0].BatchId
+	assert.Regexp(t, `^rx-[0-9a-f]{32}$`, generated)
+	assert.Equal(t, generated, result.BatchID)
+
+	// The same content gets the same id; other data or tenants don't
+	_, err = r.ProcessBatch(context.Background(), newBatch("acme", `[{"name":"cpu","value":1}]`))
+	require.NoError(t, err)
+	_, err = r.ProcessBatch(context.Background(), newBatch("acme", `[{"name":"cpu","value":2}]`))
+	require.NoError(t, err)
+	_, err = r.ProcessBatch(context.Background(), newBatch("globex", `[{"name":"cpu","value":1}]`))
+	require.NoError(t, err)
+	require.Len(t, forwarded, 4)
+	assert.Equal(t, generated, forwarded[1].BatchId)
+	assert.NotEqual(t, generated, forwarded[2].BatchId)
+	assert.NotEqual(t, generated, forwarded[3].BatchId)
+
+	// Ids sent by clients are kept
+	_, err = r.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: "client-id", Data: []byte(`[]`), Format: codec.FormatJSON})
+	require.NoError(t, err)
+	assert.Equal(t, "client-id", forwarded[4].BatchId)
+}
+
+func TestRX_ProcessBatch_DeduplicatesClientRetries(t *testing.T) {
+	var forwarded []*fb.MetricBatchRequest
+	failForwards := false
+	r := newBatchIDTestRX(t, BatchIDConfig{Dedupe: true, DedupeWindowMs: 60000}, &forwarded, &failForwards)
+
+	newBatch := func(id, data string) *fb.MetricBatch {
+		return &fb.MetricBatch{BatchID: id, Data: []byte(data), Format: codec.FormatJSON}
+	}
+
+	// A retry of a forwarded batch is acknowledged without forwarding it again
+	mergedBefore := testutil.ToFloat64(duplicateBatchesTotal.WithLabelValues(DuplicateActionMerge))
+	result, err := r.ProcessBatch(context.Background(), newBatch("batch-1", `[{"name":"cpu","value":1}]`))
+	require.NoError(t, err)
+	result, err = r.ProcessBatch(context.Background(), newBatch("batch-1", `[{"name":"cpu","value":1}]`))
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+	assert.Len(t, forwarded, 1)
+	assert.Equal(t, float64(1), testutil.ToFloat64(duplicateBatchesTotal.WithLabelValues(DuplicateActionMerge))-mergedBefore)
+
+	// A reused id with new data, and a replay from the DLQ, are forwarded
+	_, err = r.ProcessBatch(context.Background(), newBatch("batch-1", `[{"name":"cpu","value":2}]`))
+	require.NoError(t, err)
+	replay := newBatch("batch-1", `[{"name":"cpu","value":1}]`)
+	replay.Replay = true
+	_, err = r.ProcessBatch(context.Background(), replay)
+	require.NoError(t, err)
+	assert.Len(t, forwarded, 3)
+
+	// A batch that failed to forward isn't remembered, so its retry goes
+	// through
+	failForwards = true
+	_, err = r.ProcessBatch(context.Background(), newBatch("batch-2", `[{"name":"mem","value":1}]`))
+	require.Error(t, err)
+	failForwards = false
+	r.nextFBBreaker = resilience.NewCircuitBreaker("fb-rx", resilience.DownstreamNextFB, resilience.DefaultCircuitBreakerConfig())
+	_, err = r.ProcessBatch(context.Background(), newBatch("batch-2", `[{"name":"mem","value":1}]`))
+	require.NoError(t, err)
+	assert.Len(t, forwarded, 4)
+
+	// Duplicates are rejected if configured
+	rxConfig := DefaultConfig()
+	rxConfig.Common.NextFB = "fb-next:5000"
+	rxConfig.BatchID = BatchIDConfig{Dedupe: true, DuplicateAction: DuplicateActionReject}
+	configBytes, err := json.Marshal(rxConfig)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, r.UpdateConfig(ctx, configBytes, 2))
+
+	result, err = r.ProcessBatch(context.Background(), newBatch("batch-2", `[{"name":"mem","value":1}]`))
+	require.Error(t, err)
+	assert.Equal(t, fb.ErrorCodeDuplicateBatch, result.ErrorCode)
+	assert.False(t, result.Retriable)
+	assert.Len(t, forwarded, 4)
+}
+
+func TestBatchDeduper_WindowExpiry(t *testing.T) {
+	d := newBatchDeduper(BatchIDConfig{Dedupe: true, DedupeWindowMs: 1000})
+	batch := &fb.MetricBatch{BatchID: "batch-1", Data: []byte(`[]`)}
+	now := time.Now()
+
+	d.record(batch, now)
+	duplicate, _ := d.duplicate(batch, now.Add(500*time.Millisecond))
+	assert.True(t, duplicate)
+	duplicate, _ = d.duplicate(batch, now.Add(time.Second))
+	assert.False(t, duplicate)
+
+	// Expired batches are pruned when new ones are recorded
+	d.record(&fb.MetricBatch{BatchID: "batch-2"}, now.Add(2*time.Second))
+	assert.Len(t, d.expiries, 1)
+
+	assert.Error(t, BatchIDConfig{DuplicateAction: "drop"}.validate())
+}
+
+func newWALTestRX(t *testing.T, path string, nextFB fb.ChainPushServiceClient) *RX {
+	r := NewRX()
+	require.NoError(t, r.Initialize(context.Background()))
+
+	// Set before the config is applied, so batches recovered from the WAL
+	// are replayed to it
+	r.SetNextFBClientForTesting(nextFB)
+
+	rxConfig := DefaultConfig()
+	rxConfig.Common.NextFB = "fb-next:5000"
+	rxConfig.WAL = WALConfig{Enabled: true, Path: path}
+	configBytes, err := json.Marshal(rxConfig)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, r.UpdateConfig(ctx, configBytes, 1))
+	return r
+}
+
+func TestRX_WAL_ReplaysPendingBatchAfterCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rx.wal")
+
+	failForwards := false
+	var forwarded []*fb.MetricBatchRequest
+	r := newWALTestRX(t, path, &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			if failForwards {
+				return nil, errors.New("next FB unavailable")
+			}
+			forwarded = append(forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	// A forwarded batch is marked done
+	_, err := r.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID: "batch-forwarded",
+		Data:    []byte(`[{"name":"cpu","value":1}]`),
+		Format:  codec.FormatJSON,
+	})
+	require.NoError(t, err)
+	require.Len(t, forwarded, 1)
+
+	// A batch that fails without a DLQ stays pending
+	failForwards = true
+	_, err = r.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID:  "batch-pending",
+		Data:     []byte(`[{"name":"mem","value":2}]`),
+		Format:   codec.FormatJSON,
+		Metadata: map[string]string{"tenant_id": "acme"},
+	})
+	require.Error(t, err)
+
+	// Crash mid-write of the next record, without shutting down
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 1, 0, 'x'})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	// The restarted RX replays only the pending batch
+	var replayed []*fb.MetricBatchRequest
+	restarted := newWALTestRX(t, path, &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			replayed = append(replayed, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	require.Len(t, replayed, 1)
+	assert.Equal(t, "batch-pending", replayed[0].BatchId)
+	assert.Equal(t, `[{"name":"mem","value":2}]`, string(replayed[0].Data))
+	assert.Equal(t, "acme", replayed[0].InternalLabels[fb.LabelTenantID])
+
+	// Once replayed, it isn't replayed again
+	require.NoError(t, restarted.Shutdown(context.Background()))
+	replayed = nil
+	again := newWALTestRX(t, path, &fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			replayed = append(replayed, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	defer again.Shutdown(context.Background())
+	assert.Empty(t, replayed)
+}
+
+func TestRX_WAL_RejectsBatchesWhenFull(t *testing.T) {
+	w, err := openWAL(filepath.Join(t.TempDir(), "rx.wal"), 200)
+	require.NoError(t, err)
+	defer w.close()
+
+	seq, err := w.append(&fb.MetricBatch{BatchID: "batch-1", Data: bytes.Repeat([]byte("x"), 60)})
+	require.NoError(t, err)
+
+	// The pending batch leaves no room for another
+	batch := &fb.MetricBatch{BatchID: "batch-2", Data: bytes.Repeat([]byte("x"), 60)}
+	_, err = w.append(batch)
+	require.ErrorIs(t, err, errWALFull)
+
+	// Compaction makes room once it is done
+	require.NoError(t, w.done(seq))
+	_, err = w.append(batch)
+	require.NoError(t, err)
+}
+
+func TestRX_WAL_RetrySupersedesPendingBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rx.wal")
+	w, err := openWAL(path, 200)
+	require.NoError(t, err)
+
+	// A batch that failed to forward and to reach the DLQ stays pending, and
+	// its retries must not fill the WAL
+	batch := &fb.MetricBatch{BatchID: "batch", Data: bytes.Repeat([]byte("x"), 60)}
+	first, err := w.append(batch)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = w.append(batch)
+		require.NoError(t, err)
+	}
+	assert.Len(t, w.pending, 1)
+	assert.NotContains(t, w.pending, first)
+	require.NoError(t, w.close())
+
+	// Only the latest attempt is replayed after a restart
+	w, err = openWAL(path, 200)
+	require.NoError(t, err)
+	defer w.close()
+	require.Len(t, w.pending, 1)
+	for _, rec := range w.pending {
+		assert.Equal(t, "batch", rec.BatchID)
+	}
+}
+
+func TestRX_WAL_RecoverRejectsOversizedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rx.wal")
+	w, err := openWAL(path, 200)
+	require.NoError(t, err)
+	_, err = w.append(&fb.MetricBatch{BatchID: "batch", Data: []byte("data")})
+	require.NoError(t, err)
+	require.NoError(t, w.close())
+
+	// A torn header claiming a record larger than the WAL must not be
+	// allocated, and ends the log
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	header := make([]byte, walHeaderSize)
+	binary.BigEndian.PutUint32(header[:4], math.MaxUint32)
+	_, err = f.Write(header)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	w, err = openWAL(path, 200)
+	require.NoError(t, err)
+	defer w.close()
+	assert.Len(t, w.pending, 1)
+}
+
+// newBenchmarkRX returns an RX forwarding to a next FB that accepts every
+// batch, converting batches to internalFormat if set
+func newBenchmarkRX(b *testing.B, internalFormat string) *RX {
+	r := NewRX()
+	require.NoError(b, r.Initialize(context.Background()))
+
+	rxConfig := DefaultConfig()
+	rxConfig.Common.NextFB = "fb-next:5000"
+	rxConfig.Common.TracingEnabled = false
+	rxConfig.Common.InternalFormat = internalFormat
+	for i := range rxConfig.Endpoints {
+		rxConfig.Endpoints[i].Enabled = false
+	}
+	configBytes, err := json.Marshal(rxConfig)
+	require.NoError(b, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(b, r.UpdateConfig(ctx, configBytes, 1))
+
+	// Set after the config is applied, which fails to connect to the next FB
+	r.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	b.Cleanup(func() { r.Shutdown(context.Background()) })
+	return r
+}
+
+func BenchmarkRX_ProcessBatch(b *testing.B) {
+	for _, internalFormat := range []string{"", codec.FormatInternalProtobuf} {
+		name := internalFormat
+		if name == "" {
+			name = "passthrough"
+		}
+		b.Run(name, func(b *testing.B) {
+			r := newBenchmarkRX(b, internalFormat)
+			batch := benchmark.Batch(b, 500, codec.FormatJSON)
+			b.SetBytes(int64(len(batch.Data)))
+
+			benchmark.Loop(b, func() error {
+				_, err := r.ProcessBatch(context.Background(), benchmark.Copy(batch))
+				return err
+			})
+		})
+	}
+}
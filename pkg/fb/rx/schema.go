@@ -0,0 +1,122 @@
+package rx
+
+import (
+	"eidc-tfk8s/internal/config"
+)
+
+// parametersSchema is the JSON Schema of RXConfig
+const parametersSchema = `{
+	"type": "object",
+	"required": ["endpoints"],
+	"properties": {
+		"endpoints": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["protocol", "port"],
+				"properties": {
+					"protocol": {"type": "string"},
+					"port": {"type": "integer"},
+					"enabled": {"type": "boolean"}
+				}
+			}
+		},
+		"tenantRateLimit": {
+			"type": "object",
+			"properties": {
+				"enabled": {"type": "boolean"},
+				"defaultRate": {"type": "number"},
+				"defaultBurst": {"type": "integer"},
+				"overrides": {"type": "object"}
+			}
+		},
+		"replay": {
+			"type": "object",
+			"properties": {
+				"enabled": {"type": "boolean"},
+				"maxBatches": {"type": "integer"},
+				"highWaterMark": {"type": "integer"},
+				"retryAfterMs": {"type": "integer"}
+			}
+		},
+		"partialDecode": {"type": "boolean"},
+		"mirrorNextFB": {"type": "string"},
+		"mirrorSampleRatio": {"type": "number"},
+		"coalesce": {
+			"type": "object",
+			"properties": {
+				"enabled": {"type": "boolean"},
+				"maxBatches": {"type": "integer"},
+				"maxBytes": {"type": "integer"},
+				"maxWaitMs": {"type": "integer"}
+			}
+		},
+		"batchId": {
+			"type": "object",
+			"properties": {
+				"dedupe": {"type": "boolean"},
+				"dedupeWindowMs": {"type": "integer"},
+				"duplicateAction": {"type": "string", "enum": ["", "merge", "reject"]}
+			}
+		},
+		"wal": {
+			"type": "object",
+			"properties": {
+				"enabled": {"type": "boolean"},
+				"path": {"type": "string"},
+				"maxBytes": {"type": "integer"}
+			}
+		},
+		"record": {
+			"type": "object",
+			"properties": {
+				"enabled": {"type": "boolean"},
+				"path": {"type": "string"},
+				"maxBytes": {"type": "integer"},
+				"maxFiles": {"type": "integer"}
+			}
+		},
+		"adaptiveSampling": {
+			"type": "object",
+			"properties": {
+				"enabled": {"type": "boolean"},
+				"priorityLabel": {"type": "string"},
+				"lowPriorityValue": {"type": "string"},
+				"maxInFlight": {"type": "integer"},
+				"threshold": {"type": "number"},
+				"maxDropRatio": {"type": "number"}
+			}
+		}
+	}
+}`
+
+func init() {
+	config.RegisterParametersSchema("fb-rx", parametersSchema)
+}
+
+// DefaultConfig returns the RX configuration with the default endpoints
+func DefaultConfig() RXConfig {
+	return RXConfig{
+		Common: config.FBConfig{
+			LogLevel:           "info",
+			MetricsEnabled:     true,
+			TracingEnabled:     true,
+			TraceSamplingRatio: 0.1,
+			CircuitBreaker: config.CircuitBreakerConfig{
+				ErrorThresholdPercentage: 50,
+				OpenStateSeconds:         30,
+				HalfOpenRequestThreshold: 5,
+			},
+		},
+		Endpoints: []Endpoint{
+			{Protocol: "otlp/grpc", Port: 4317, Enabled: true},
+			{Protocol: "otlp/http", Port: 4318, Enabled: true},
+		},
+		TenantRateLimit: TenantRateLimitConfig{
+			Overrides: map[string]TenantLimit{},
+		},
+		Replay: ReplayConfig{
+			MaxBatches: defaultReplayMaxBatches,
+		},
+	}
+}
@@ -0,0 +1,497 @@
+package rx
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"eidc-tfk8s/pkg/fb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// defaultWALMaxBytes bounds the WAL file when the config doesn't say
+const defaultWALMaxBytes = 64 << 20
+
+// walHeaderSize is the size of a record's length and CRC
+const walHeaderSize = 8
+
+// errWALFull is returned when the batches pending in the WAL leave no room
+// for another. Senders should retry once forwarding catches up.
+var errWALFull = errors.New("WAL is full")
+
+var (
+	walPendingBatches = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fb_rx_wal_pending_batches",
+		Help: "Number of batches logged in the WAL that have not been forwarded or sent to the DLQ",
+	})
+
+	walReplayedBatchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_rx_wal_replayed_batches_total",
+		Help: "Total number of batches recovered from the WAL at startup and forwarded again, by outcome",
+	}, []string{"outcome"})
+)
+
+// WALConfig configures the write-ahead log of accepted batches. A batch is
+// logged before it is forwarded and marked done once it is forwarded or sent
+// to the DLQ, so the batches in flight when RX crashes are forwarded again
+// at the next start: delivery is at-least-once.
+type WALConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Path is the WAL file, created if missing
+	Path string `json:"path"`
+
+	// MaxBytes bounds the WAL file. Batches that don't fit once done
+	// entries are compacted away are rejected as retryable.
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// validate checks the WAL configuration
+func (c WALConfig) validate() error {
+	if c.MaxBytes < 0 {
+		return fmt.Errorf("wal maxBytes must not be negative")
+	}
+	if c.Enabled && c.Path == "" {
+		return fmt.Errorf("wal requires a path")
+	}
+	return nil
+}
+
+func (c WALConfig) maxBytes() int64 {
+	if c.MaxBytes == 0 {
+		return defaultWALMaxBytes
+	}
+	return c.MaxBytes
+}
+
+// walRecord is a record of the WAL: a batch logged under Seq, or the mark
+// that the batch logged under Seq is done
+type walRecord struct {
+	Seq              uint64            `json:"seq"`
+	Done             bool              `json:"done,omitempty"`
+	BatchID          string            `json:"batchId,omitempty"`
+	Data             []byte            `json:"data,omitempty"`
+	Format           string            `json:"format,omitempty"`
+	ConfigGeneration int64             `json:"configGeneration,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	InternalLabels   map[string]string `json:"internalLabels,omitempty"`
+}
+
+// batch returns the batch a record logged
+func (rec *walRecord) batch() *fb.MetricBatch {
+	return &fb.MetricBatch{
+		BatchID:          rec.BatchID,
+		Data:             rec.Data,
+		Format:           rec.Format,
+		ConfigGeneration: rec.ConfigGeneration,
+		Metadata:         copyLabels(rec.Metadata),
+		InternalLabels:   copyLabels(rec.InternalLabels),
+	}
+}
+
+// writeAheadLog is an append-only file of length-prefixed, checksummed
+// records. Batch records are synced before forwarding; done marks aren't,
+// as losing one only forwards a batch again.
+type writeAheadLog struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+	nextSeq  uint64
+	pending  map[uint64]*walRecord
+
+	// byBatchID maps the id of each pending batch to its sequence number
+	byBatchID map[string]uint64
+
+	// recovered are the sequence numbers of the batches found pending when
+	// the WAL was opened, left to replay
+	recovered []uint64
+}
+
+// openWAL opens the WAL at path, recovering the batches left pending by the
+// previous run. A record torn by a crash ends the log and is truncated.
+func openWAL(path string, maxBytes int64) (*writeAheadLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create WAL directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL: %w", err)
+	}
+
+	w := &writeAheadLog{
+		path:     path,
+		maxBytes: maxBytes,
+		file:     file,
+		nextSeq:  1,
+		pending:  make(map[uint64]*walRecord),
+
+		byBatchID: make(map[string]uint64),
+	}
+	w.recover()
+
+	// Start from a file holding only the pending batches
+	if err := w.compact(); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	for seq := range w.pending {
+		w.recovered = append(w.recovered, seq)
+	}
+	sort.Slice(w.recovered, func(i, j int) bool { return w.recovered[i] < w.recovered[j] })
+	walPendingBatches.Set(float64(len(w.pending)))
+	return w, nil
+}
+
+// recover reads the records of the file into the pending batches. A record
+// longer than the WAL can hold is taken for a torn header.
+func (w *writeAheadLog) recover() {
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err := io.ReadFull(w.file, header); err != nil {
+			break
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		if int64(length) > w.maxBytes {
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(w.file, payload); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			break
+		}
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			break
+		}
+
+		if rec.Done {
+			w.forget(rec.Seq)
+		} else {
+			if seq, ok := w.byBatchID[rec.BatchID]; ok && rec.BatchID != "" {
+				w.forget(seq)
+			}
+			w.track(&rec)
+		}
+		if rec.Seq >= w.nextSeq {
+			w.nextSeq = rec.Seq + 1
+		}
+	}
+}
+
+// encodeWALRecord returns a record framed by its length and CRC
+func encodeWALRecord(rec *walRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode WAL record: %w", err)
+	}
+	frame := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[walHeaderSize:], payload)
+	return frame, nil
+}
+
+// append logs a batch and syncs it to disk, returning its sequence number
+func (w *writeAheadLog) append(batch *fb.MetricBatch) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rec := &walRecord{
+		Seq:              w.nextSeq,
+		BatchID:          batch.BatchID,
+		Data:             batch.Data,
+		Format:           batch.Format,
+		ConfigGeneration: batch.ConfigGeneration,
+		Metadata:         copyLabels(batch.Metadata),
+		InternalLabels:   copyLabels(batch.InternalLabels),
+	}
+	frame, err := encodeWALRecord(rec)
+	if err != nil {
+		return 0, err
+	}
+
+	// A retry supersedes the attempt still pending under the same batch id,
+	// which failed to forward and to reach the DLQ and would otherwise stay
+	// pending until the next start
+	if seq, ok := w.byBatchID[batch.BatchID]; ok && batch.BatchID != "" {
+		if err := w.markDone(seq); err != nil {
+			return 0, err
+		}
+	}
+
+	// Make room by dropping the batches already done
+	if w.size+int64(len(frame)) > w.maxBytes {
+		if err := w.compact(); err != nil {
+			return 0, err
+		}
+		if w.size+int64(len(frame)) > w.maxBytes {
+			return 0, errWALFull
+		}
+	}
+
+	if err := w.write(frame); err != nil {
+		return 0, err
+	}
+	if err := w.file.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync WAL: %w", err)
+	}
+
+	w.nextSeq++
+	w.track(rec)
+	walPendingBatches.Set(float64(len(w.pending)))
+	return rec.Seq, nil
+}
+
+// track adds a batch record to the pending batches. w.mu must be held.
+func (w *writeAheadLog) track(rec *walRecord) {
+	w.pending[rec.Seq] = rec
+	if rec.BatchID != "" {
+		w.byBatchID[rec.BatchID] = rec.Seq
+	}
+}
+
+// forget removes a batch from the pending batches. w.mu must be held.
+func (w *writeAheadLog) forget(seq uint64) {
+	rec, ok := w.pending[seq]
+	if !ok {
+		return
+	}
+	delete(w.pending, seq)
+	if w.byBatchID[rec.BatchID] == seq {
+		delete(w.byBatchID, rec.BatchID)
+	}
+}
+
+// done marks the batch logged under seq as forwarded or sent to the DLQ
+func (w *writeAheadLog) done(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.markDone(seq)
+}
+
+// markDone logs that the batch logged under seq is done. w.mu must be held.
+func (w *writeAheadLog) markDone(seq uint64) error {
+	if _, ok := w.pending[seq]; !ok {
+		return nil
+	}
+	frame, err := encodeWALRecord(&walRecord{Seq: seq, Done: true})
+	if err != nil {
+		return err
+	}
+	if err := w.write(frame); err != nil {
+		return err
+	}
+
+	w.forget(seq)
+	walPendingBatches.Set(float64(len(w.pending)))
+	return nil
+}
+
+// write appends a frame to the file. A partly written frame is truncated
+// away, as recovery would stop at it and lose the records appended after
+// it. w.mu must be held.
+func (w *writeAheadLog) write(frame []byte) error {
+	n, err := w.file.Write(frame)
+	if err != nil {
+		if n > 0 {
+			if truncErr := w.file.Truncate(w.size); truncErr != nil {
+				w.size += int64(n)
+			} else if _, seekErr := w.file.Seek(w.size, io.SeekStart); seekErr != nil {
+				w.size += int64(n)
+			}
+		}
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+	w.size += int64(n)
+	return nil
+}
+
+// compact rewrites the file with only the pending batches, replacing it
+// atomically. w.mu must be held, or w not yet shared.
+func (w *writeAheadLog) compact() error {
+	seqs := make([]uint64, 0, len(w.pending))
+	for seq := range w.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	tmpPath := w.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to compact WAL: %w", err)
+	}
+
+	var size int64
+	for _, seq := range seqs {
+		frame, err := encodeWALRecord(w.pending[seq])
+		if err == nil {
+			_, err = tmp.Write(frame)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to compact WAL: %w", err)
+		}
+		size += int64(len(frame))
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact WAL: %w", err)
+	}
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact WAL: %w", err)
+	}
+
+	w.file.Close()
+	w.file = tmp
+	w.size = size
+	return nil
+}
+
+// takeRecovered returns the batches recovered at open that are still
+// pending, to be replayed, and forgets them as recovered
+func (w *writeAheadLog) takeRecovered() []*walRecord {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var recs []*walRecord
+	for _, seq := range w.recovered {
+		if rec, ok := w.pending[seq]; ok {
+			recs = append(recs, rec)
+		}
+	}
+	w.recovered = nil
+	return recs
+}
+
+// restoreRecovered returns batches that failed to replay to the recovered
+// batches, to be replayed again at the next config update
+func (w *writeAheadLog) restoreRecovered(recs []*walRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, rec := range recs {
+		w.recovered = append(w.recovered, rec.Seq)
+	}
+}
+
+// setMaxBytes applies a new size bound
+func (w *writeAheadLog) setMaxBytes(maxBytes int64) {
+	w.mu.Lock()
+	w.maxBytes = maxBytes
+	w.mu.Unlock()
+}
+
+// close closes the WAL file
+func (w *writeAheadLog) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
+
+// updateWAL opens, reopens or closes the WAL as configured. It runs before
+// the rest of the config is applied, so a WAL that fails to open fails the
+// update rather than leave RX accepting batches without it.
+func (r *RX) updateWAL(config WALConfig) error {
+	current := r.wal.Load()
+	if current != nil && config.Enabled && current.path == config.Path {
+		current.setMaxBytes(config.maxBytes())
+		return nil
+	}
+
+	var next *writeAheadLog
+	if config.Enabled {
+		var err error
+		if next, err = openWAL(config.Path, config.maxBytes()); err != nil {
+			return err
+		}
+		if n := len(next.recovered); n > 0 {
+			r.logger.Info("Recovered pending batches from WAL", map[string]interface{}{
+				"path":    config.Path,
+				"batches": n,
+			})
+		}
+	}
+
+	if previous := r.wal.Swap(next); previous != nil {
+		previous.close()
+	}
+	return nil
+}
+
+// logBatch logs a batch in the WAL before it is forwarded, returning its
+// sequence number, or 0 without a WAL
+func (r *RX) logBatch(batch *fb.MetricBatch) (uint64, error) {
+	w := r.wal.Load()
+	if w == nil {
+		return 0, nil
+	}
+	return w.append(batch)
+}
+
+// completeBatch marks a logged batch done if it was forwarded or sent to
+// the DLQ. Other batches stay pending until the client's retry of the batch
+// supersedes them, or are replayed at the next start.
+func (r *RX) completeBatch(seq uint64, result *fb.ProcessResult, err error) {
+	w := r.wal.Load()
+	if w == nil || seq == 0 {
+		return
+	}
+	if err != nil && (result == nil || !result.SentToDLQ) {
+		return
+	}
+	if err := w.done(seq); err != nil {
+		r.logger.Warn("Failed to mark batch done in WAL", map[string]interface{}{
+			"seq":   seq,
+			"error": err.Error(),
+		})
+	}
+}
+
+// replayWAL forwards the batches recovered from the WAL, which the previous
+// run accepted but may not have forwarded. Batches that fail to forward are
+// replayed again at the next config update.
+func (r *RX) replayWAL(ctx context.Context) {
+	w := r.wal.Load()
+	if w == nil {
+		return
+	}
+
+	recs := w.takeRecovered()
+	var failed []*walRecord
+	for _, rec := range recs {
+		result, err := r.forwardBatch(ctx, rec.batch())
+		if err != nil && (result == nil || !result.SentToDLQ) {
+			walReplayedBatchesTotal.WithLabelValues("failed").Inc()
+			r.logger.Warn("Failed to replay batch from WAL", map[string]interface{}{
+				"batch_id": rec.BatchID,
+				"error":    err.Error(),
+			})
+			failed = append(failed, rec)
+			continue
+		}
+
+		walReplayedBatchesTotal.WithLabelValues("replayed").Inc()
+		r.completeBatch(rec.Seq, result, err)
+	}
+	w.restoreRecovered(failed)
+}
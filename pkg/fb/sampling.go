@@ -0,0 +1,22 @@
+package fb
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// SampledBatch returns whether a batch falls in a sample of ratio of all
+// batches. Sampling by batch ID rather than at random means a batch
+// replayed from the DLQ is sampled the same way it was the first time.
+func SampledBatch(batchID string, ratio float64) bool {
+	if ratio <= 0 {
+		return false
+	}
+	if ratio >= 1 {
+		return true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(batchID))
+	return float64(h.Sum32()) < ratio*float64(math.MaxUint32)
+}
@@ -0,0 +1,24 @@
+package fb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampledBatch(t *testing.T) {
+	sampledCount := 0
+	for i := 0; i < 10000; i++ {
+		batchID := fmt.Sprintf("batch-%d", i)
+		if SampledBatch(batchID, 0.1) {
+			sampledCount++
+		}
+
+		// A batch is sampled the same way every time
+		assert.Equal(t, SampledBatch(batchID, 0.1), SampledBatch(batchID, 0.1))
+		assert.False(t, SampledBatch(batchID, 0))
+		assert.True(t, SampledBatch(batchID, 1))
+	}
+	assert.InDelta(t, 1000, sampledCount, 150)
+}
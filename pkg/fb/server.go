@@ -0,0 +1,34 @@
+package fb
+
+import (
+	"fmt"
+	"net"
+
+	"eidc-tfk8s/internal/common/metrics"
+	"google.golang.org/grpc"
+)
+
+// ServeGRPC starts a gRPC server on port serving the ChainPushService of
+// block, the function block embedding b, along with the standard gRPC
+// health service and, if enabled, reflection. The server serves in the
+// background; onError is called with the error if it stops serving.
+func (b *BaseFunctionBlock) ServeGRPC(block FunctionBlock, port int, onError func(error)) (*grpc.Server, error) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on port %d: %w", port, err)
+	}
+
+	// Record RPC metrics for the function block
+	server := grpc.NewServer(metrics.GRPCServerOptions(b.name)...)
+	RegisterChainPushServiceServer(server, NewChainPushServiceHandler(block))
+	b.RegisterHealthServer(server)
+	b.RegisterReflectionServer(server)
+
+	go func() {
+		if err := server.Serve(lis); err != nil && onError != nil {
+			onError(err)
+		}
+	}()
+
+	return server, nil
+}
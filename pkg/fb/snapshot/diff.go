@@ -0,0 +1,174 @@
+package snapshot
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+
+	"eidc-tfk8s/pkg/fb/codec"
+)
+
+// Options tunes a comparison
+type Options struct {
+	// Ignore lists the attribute paths whose differences are intended, such
+	// as "labels.host" or "labels.k8s_*". Nested fields are joined with "."
+	// and patterns use path.Match syntax.
+	Ignore []string
+}
+
+// ignored reports whether differences at an attribute path are intended
+func (o Options) ignored(attribute string) bool {
+	for _, pattern := range o.Ignore {
+		if matched, _ := path.Match(pattern, attribute); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Change is an attribute whose value differs between two captures
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// MetricDiff lists the attribute differences of a metric present in both
+// captures
+type MetricDiff struct {
+	// ID identifies the metric by name, timestamp and position among metrics
+	// sharing both
+	ID string `json:"id"`
+
+	Added   map[string]interface{} `json:"added,omitempty"`
+	Removed map[string]interface{} `json:"removed,omitempty"`
+	Changed map[string]Change      `json:"changed,omitempty"`
+}
+
+// Diff is the structured difference between two captures of a batch
+type Diff struct {
+	BatchID string `json:"batch_id,omitempty"`
+
+	// Metrics only present in the later or earlier capture, by ID
+	AddedMetrics   []string `json:"added_metrics,omitempty"`
+	RemovedMetrics []string `json:"removed_metrics,omitempty"`
+
+	// Metrics present in both captures whose attributes differ
+	Metrics []MetricDiff `json:"metrics,omitempty"`
+}
+
+// Empty reports whether the captures match
+func (d *Diff) Empty() bool {
+	return len(d.AddedMetrics) == 0 && len(d.RemovedMetrics) == 0 && len(d.Metrics) == 0
+}
+
+// Compare diffs two captures of the same batch. Metrics are matched by name
+// and timestamp, which transformations along the pipeline keep, and their
+// attributes, including nested labels, are compared by path.
+func Compare(before, after []codec.Metric, opts Options) *Diff {
+	diff := &Diff{}
+
+	beforeIDs, beforeByID := indexMetrics(before)
+	afterIDs, afterByID := indexMetrics(after)
+
+	for _, id := range beforeIDs {
+		afterMetric, ok := afterByID[id]
+		if !ok {
+			diff.RemovedMetrics = append(diff.RemovedMetrics, id)
+			continue
+		}
+		if metricDiff, changed := compareMetric(id, beforeByID[id], afterMetric, opts); changed {
+			diff.Metrics = append(diff.Metrics, metricDiff)
+		}
+	}
+
+	for _, id := range afterIDs {
+		if _, ok := beforeByID[id]; !ok {
+			diff.AddedMetrics = append(diff.AddedMetrics, id)
+		}
+	}
+
+	return diff
+}
+
+// indexMetrics assigns each metric its ID, returning the IDs in batch order
+func indexMetrics(metrics []codec.Metric) ([]string, map[string]codec.Metric) {
+	ids := make([]string, 0, len(metrics))
+	byID := make(map[string]codec.Metric, len(metrics))
+	seen := make(map[string]int)
+
+	for _, metric := range metrics {
+		// Decoded numbers are json.Number, which print as their JSON text,
+		// so timestamps never render in exponent notation
+		base := fmt.Sprintf("%v@%v", metric["name"], metric["timestamp"])
+		id := fmt.Sprintf("%s#%d", base, seen[base])
+		seen[base]++
+
+		ids = append(ids, id)
+		byID[id] = metric
+	}
+	return ids, byID
+}
+
+// compareMetric diffs the attributes of a metric present in both captures
+func compareMetric(id string, before, after codec.Metric, opts Options) (MetricDiff, bool) {
+	metricDiff := MetricDiff{ID: id}
+	beforeAttributes := flatten("", before, map[string]interface{}{})
+	afterAttributes := flatten("", after, map[string]interface{}{})
+
+	for attribute, beforeValue := range beforeAttributes {
+		if opts.ignored(attribute) {
+			continue
+		}
+		afterValue, ok := afterAttributes[attribute]
+		if !ok {
+			if metricDiff.Removed == nil {
+				metricDiff.Removed = make(map[string]interface{})
+			}
+			metricDiff.Removed[attribute] = beforeValue
+			continue
+		}
+		if !reflect.DeepEqual(beforeValue, afterValue) {
+			if metricDiff.Changed == nil {
+				metricDiff.Changed = make(map[string]Change)
+			}
+			metricDiff.Changed[attribute] = Change{Before: beforeValue, After: afterValue}
+		}
+	}
+
+	for attribute, afterValue := range afterAttributes {
+		if opts.ignored(attribute) {
+			continue
+		}
+		if _, ok := beforeAttributes[attribute]; !ok {
+			if metricDiff.Added == nil {
+				metricDiff.Added = make(map[string]interface{})
+			}
+			metricDiff.Added[attribute] = afterValue
+		}
+	}
+
+	changed := len(metricDiff.Added) > 0 || len(metricDiff.Removed) > 0 || len(metricDiff.Changed) > 0
+	return metricDiff, changed
+}
+
+// flatten collects the leaf attributes of a metric by "."-joined path
+func flatten(prefix string, fields map[string]interface{}, into map[string]interface{}) map[string]interface{} {
+	for key, value := range fields {
+		attribute := key
+		if prefix != "" {
+			attribute = prefix + "." + key
+		}
+
+		switch nested := value.(type) {
+		case map[string]interface{}:
+			flatten(attribute, nested, into)
+		case map[string]string:
+			for nestedKey, nestedValue := range nested {
+				into[attribute+"."+nestedKey] = nestedValue
+			}
+		default:
+			into[attribute] = value
+		}
+	}
+	return into
+}
@@ -0,0 +1,131 @@
+// Package snapshot captures the metrics of a batch at points along the
+// pipeline and diffs them, so tests can assert that what left FB-GW is what
+// entered FB-RX apart from the intended transformations.
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"google.golang.org/grpc"
+)
+
+// Points in the pipeline batches are captured at
+const (
+	// StageRXIn is a batch as pushed to FB-RX
+	StageRXIn = "rx-in"
+
+	// StageGWOut is a batch as pushed out of FB-GW
+	StageGWOut = "gw-out"
+)
+
+// Recorder keeps the decoded metrics of each batch per stage. It is safe for
+// concurrent use.
+type Recorder struct {
+	mu        sync.RWMutex
+	snapshots map[string]map[string][]codec.Metric
+}
+
+// NewRecorder creates an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{
+		snapshots: make(map[string]map[string][]codec.Metric),
+	}
+}
+
+// Record decodes a batch pushed with the given request and keeps its metrics
+// for the stage, replacing any earlier capture of the same batch there
+func (r *Recorder) Record(stage string, req *fb.MetricBatchRequest) error {
+	metrics, err := codec.Decode(&fb.MetricBatch{
+		BatchID: req.BatchId,
+		Data:    req.Data,
+		Format:  req.Format,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to capture batch %s at %s: %w", req.BatchId, stage, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stages, ok := r.snapshots[req.BatchId]
+	if !ok {
+		stages = make(map[string][]codec.Metric)
+		r.snapshots[req.BatchId] = stages
+	}
+	stages[stage] = metrics
+	return nil
+}
+
+// Snapshot returns the metrics captured for a batch at a stage
+func (r *Recorder) Snapshot(stage, batchID string) ([]codec.Metric, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	metrics, ok := r.snapshots[batchID][stage]
+	return metrics, ok
+}
+
+// Diff compares a batch as it entered FB-RX with the same batch leaving FB-GW
+func (r *Recorder) Diff(batchID string, opts Options) (*Diff, error) {
+	return r.DiffStages(batchID, StageRXIn, StageGWOut, opts)
+}
+
+// DiffStages compares the captures of a batch at two stages
+func (r *Recorder) DiffStages(batchID, beforeStage, afterStage string, opts Options) (*Diff, error) {
+	before, ok := r.Snapshot(beforeStage, batchID)
+	if !ok {
+		return nil, fmt.Errorf("batch %s was not captured at %s", batchID, beforeStage)
+	}
+	after, ok := r.Snapshot(afterStage, batchID)
+	if !ok {
+		return nil, fmt.Errorf("batch %s was not captured at %s", batchID, afterStage)
+	}
+
+	diff := Compare(before, after, opts)
+	diff.BatchID = batchID
+	return diff, nil
+}
+
+// RecordingClient records every batch it pushes before passing it on. Wrap
+// FB-GW's next FB client with it to capture StageGWOut.
+type RecordingClient struct {
+	next     fb.ChainPushServiceClient
+	recorder *Recorder
+	stage    string
+}
+
+// NewRecordingClient wraps next, recording pushed batches at stage
+func NewRecordingClient(next fb.ChainPushServiceClient, recorder *Recorder, stage string) *RecordingClient {
+	return &RecordingClient{next: next, recorder: recorder, stage: stage}
+}
+
+// PushMetrics implements fb.ChainPushServiceClient. Batches that can't be
+// decoded are passed on uncaptured.
+func (c *RecordingClient) PushMetrics(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+	c.recorder.Record(c.stage, in)
+	return c.next.PushMetrics(ctx, in, opts...)
+}
+
+// RecordingServer records every batch it receives before handling it. Wrap
+// FB-RX's handler with it to capture StageRXIn.
+type RecordingServer struct {
+	next     fb.ChainPushServiceServer
+	recorder *Recorder
+	stage    string
+}
+
+// NewRecordingServer wraps next, recording received batches at stage
+func NewRecordingServer(next fb.ChainPushServiceServer, recorder *Recorder, stage string) *RecordingServer {
+	return &RecordingServer{next: next, recorder: recorder, stage: stage}
+}
+
+// PushMetrics implements fb.ChainPushServiceServer. Batches that can't be
+// decoded are handled uncaptured.
+func (s *RecordingServer) PushMetrics(ctx context.Context, in *fb.MetricBatchRequest) (*fb.MetricBatchResponse, error) {
+	s.recorder.Record(s.stage, in)
+	return s.next.PushMetrics(ctx, in)
+}
@@ -0,0 +1,73 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder_DiffsRXInAgainstGWOut(t *testing.T) {
+	recorder := NewRecorder()
+	rx := NewRecordingServer(&fb.MockChainPushServiceServer{}, recorder, StageRXIn)
+	gw := NewRecordingClient(&fb.MockChainPushServiceClient{}, recorder, StageGWOut)
+
+	_, err := rx.PushMetrics(context.Background(), &fb.MetricBatchRequest{
+		BatchId: "batch-1",
+		Format:  codec.FormatJSON,
+		Data: []byte(`[
+			{"name":"cpu","value":0.5,"timestamp":1700000000,"labels":{"host":"a"}},
+			{"name":"mem","value":1024,"timestamp":1700000000,"labels":{"host":"a","pod":"p-1"}},
+			{"name":"disk","value":10,"timestamp":1700000000,"labels":{"host":"a"}}
+		]`),
+	})
+	require.NoError(t, err)
+
+	_, err = gw.PushMetrics(context.Background(), &fb.MetricBatchRequest{
+		BatchId: "batch-1",
+		Format:  codec.FormatJSON,
+		Data: []byte(`[
+			{"name":"cpu","value":0.5,"timestamp":1700000000,"labels":{"host":"a","k8s_node":"n-1"}},
+			{"name":"mem","value":2048,"timestamp":1700000000,"labels":{"host":"a","cluster":"c-1"}},
+			{"name":"net","value":1,"timestamp":1700000000}
+		]`),
+	})
+	require.NoError(t, err)
+
+	// Enrichment with k8s_ labels is intended
+	diff, err := recorder.Diff("batch-1", Options{Ignore: []string{"labels.k8s_*"}})
+	require.NoError(t, err)
+	assert.False(t, diff.Empty())
+
+	assert.Equal(t, "batch-1", diff.BatchID)
+	assert.Equal(t, []string{"disk@1700000000#0"}, diff.RemovedMetrics)
+	assert.Equal(t, []string{"net@1700000000#0"}, diff.AddedMetrics)
+	assert.Equal(t, []MetricDiff{{
+		ID:      "mem@1700000000#0",
+		Added:   map[string]interface{}{"labels.cluster": "c-1"},
+		Removed: map[string]interface{}{"labels.pod": "p-1"},
+		Changed: map[string]Change{"value": {Before: json.Number("1024"), After: json.Number("2048")}},
+	}}, diff.Metrics)
+}
+
+func TestRecorder_UnchangedBatch(t *testing.T) {
+	recorder := NewRecorder()
+	req := &fb.MetricBatchRequest{
+		BatchId: "batch-1",
+		Format:  codec.FormatJSON,
+		Data:    []byte(`[{"name":"cpu","value":0.5,"timestamp":1700000000},{"name":"cpu","value":0.7,"timestamp":1700000000}]`),
+	}
+	require.NoError(t, recorder.Record(StageRXIn, req))
+	require.NoError(t, recorder.Record(StageGWOut, req))
+
+	diff, err := recorder.Diff("batch-1", Options{})
+	require.NoError(t, err)
+	assert.True(t, diff.Empty())
+
+	_, err = recorder.Diff("batch-2", Options{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,38 @@
+package tap
+
+import (
+	"eidc-tfk8s/internal/config"
+)
+
+// parametersSchema is the JSON Schema of TapConfig
+const parametersSchema = `{
+	"type": "object",
+	"properties": {
+		"sample_ratio": {"type": "number"},
+		"top_k": {"type": "integer"}
+	}
+}`
+
+func init() {
+	config.RegisterParametersSchema("fb-tap", parametersSchema)
+}
+
+// DefaultConfig returns the Tap configuration sampling the metric names of
+// one batch in ten and reporting the 20 most frequent
+func DefaultConfig() TapConfig {
+	return TapConfig{
+		Common: config.FBConfig{
+			LogLevel:           "info",
+			MetricsEnabled:     true,
+			TracingEnabled:     true,
+			TraceSamplingRatio: 0.1,
+			CircuitBreaker: config.CircuitBreakerConfig{
+				ErrorThresholdPercentage: 50,
+				OpenStateSeconds:         30,
+				HalfOpenRequestThreshold: 5,
+			},
+		},
+		SampleRatio: 0.1,
+		TopK:        20,
+	}
+}
@@ -0,0 +1,630 @@
+package tap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/common/metrics"
+	"eidc-tfk8s/internal/common/resilience"
+	"eidc-tfk8s/internal/common/tracing"
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Metrics of the traffic passing through the tap
+var (
+	batchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fb_tap_batches_total",
+		Help: "Total number of batches passing through the tap, by format",
+	}, []string{"format"})
+
+	batchBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "fb_tap_batch_bytes",
+		Help:    "Size of the data of batches passing through the tap",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+	})
+
+	sampledBatchesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fb_tap_sampled_batches_total",
+		Help: "Total number of batches decoded to sample their metric names",
+	})
+
+	batchMetrics = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "fb_tap_batch_metrics",
+		Help:    "Number of metrics in sampled batches",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	})
+
+	topMetricNames = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fb_tap_top_metric_names",
+		Help: "Estimated number of occurrences in sampled batches of the most frequent metric names",
+	}, []string{"name"})
+)
+
+// topKCapacityFactor is how many more names than it reports the top-K
+// tracker keeps, which bounds the error of the reported counts
+const topKCapacityFactor = 10
+
+// TapConfig contains configuration for the Tap function block
+type TapConfig struct {
+	// Common configuration
+	Common config.FBConfig `json:"common"`
+
+	// SampleRatio is the fraction of batches decoded to sample their metric
+	// names; 0 records only batch-level metrics
+	SampleRatio float64 `json:"sample_ratio"`
+
+	// TopK is how many of the most frequent sampled metric names are
+	// reported; 0 disables metric name tracking
+	TopK int `json:"top_k"`
+}
+
+// Tap implements the FB-Tap function block, which records metrics about the
+// traffic passing through it and forwards batches unchanged, so it can be
+// inserted anywhere in the chain to observe it
+type Tap struct {
+	fb.BaseFunctionBlock
+	logger        *logging.Logger
+	metrics       *metrics.FBMetrics
+	tracer        *tracing.Tracer
+	config        *TapConfig
+	configMu      sync.RWMutex
+	names         *topK
+	namesMu       sync.Mutex
+	nextFBClient  fb.ChainPushServiceClient
+	nextFBConn    *grpc.ClientConn
+	dlqClient     fb.ChainPushServiceClient
+	dlqConn       *grpc.ClientConn
+	nextFBBreaker *resilience.CircuitBreaker
+	dlqBreaker    *resilience.CircuitBreaker
+}
+
+// NewTap creates a new Tap function block
+func NewTap() *Tap {
+	return &Tap{
+		BaseFunctionBlock: fb.NewBaseFunctionBlock("fb-tap"),
+		logger:            logging.NewLogger("fb-tap"),
+		metrics:           metrics.NewFBMetrics("fb-tap"),
+		tracer:            tracing.NewTracer("fb-tap"),
+	}
+}
+
+// Initialize initializes the Tap function block
+func (t *Tap) Initialize(ctx context.Context) error {
+	t.logger.Info("Initializing FB-Tap", nil)
+
+	if err := t.Transition(fb.StateInitialized); err != nil {
+		return err
+	}
+
+	// Initialize circuit breakers with default config
+	t.nextFBBreaker = resilience.NewCircuitBreaker("fb-tap", resilience.DownstreamNextFB, resilience.DefaultCircuitBreakerConfig())
+	t.dlqBreaker = resilience.NewCircuitBreaker("fb-tap", resilience.DownstreamDLQ, resilience.DefaultCircuitBreakerConfig())
+
+	t.SetReady(true)
+
+	return nil
+}
+
+// ProcessBatch records metrics about a batch and forwards it unchanged
+func (t *Tap) ProcessBatch(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	// Create child span for the batch processing
+	ctx, span := t.tracer.StartSpan(ctx, "process-batch")
+	defer span.End()
+
+	// Record metric
+	t.metrics.RecordBatchReceived()
+	t.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeReceived)
+
+	// Reject batches until config has been applied, and while shutting down
+	done, result, err := t.BeginBatch(batch.BatchID)
+	if err != nil {
+		return result, err
+	}
+	defer done()
+
+	// Send batches whose data was corrupted in transit to the DLQ
+	if result, err := t.VerifyChecksum(ctx, batch, t.sendToDLQ); err != nil {
+		return result, err
+	}
+
+	// Forward unchanged if this FB is disabled in the pipeline config
+	if !t.Enabled() {
+		return t.BypassBatch(ctx, batch, t.forwardToNextFB)
+	}
+
+	startTime := time.Now()
+
+	// Recording never fails the batch, which is forwarded as received
+	t.record(ctx, batch)
+
+	// Record processing metrics
+	t.metrics.RecordBatchProcessed(time.Since(startTime).Seconds())
+
+	// Forward to next FB
+	forwardingResult, forwardingErr := t.forwardToNextFB(ctx, batch)
+	if forwardingErr != nil {
+		// Batches the next FB rejected for good go to the quarantine, if
+		// any, and are reported upstream with its code so they aren't retried
+		code := fb.ForwardingErrorCode(forwardingErr)
+		dlqErr := t.SendFailedBatch(ctx, batch, code, forwardingErr, t.sendToDLQ)
+		if dlqErr != nil {
+			if errors.Is(dlqErr, fb.ErrNoDLQ) {
+				return fb.NewCodeErrorResult(batch.BatchID, code, forwardingErr), forwardingErr
+			}
+			t.logger.ErrorCtx(ctx, "Failed to send to DLQ after forwarding failure", dlqErr, map[string]interface{}{
+				"batch_id": batch.BatchID,
+			})
+			return fb.NewDLQErrorResult(batch.BatchID, dlqErr), dlqErr
+		}
+
+		// Return error with DLQ status
+		return fb.NewErrorResult(batch.BatchID, code, forwardingErr, true), forwardingErr
+	}
+
+	return forwardingResult, nil
+}
+
+// record records the metrics of a batch, decoding it to sample its metric
+// names if it is sampled
+func (t *Tap) record(ctx context.Context, batch *fb.MetricBatch) {
+	// Create child span for recording
+	_, span := t.tracer.StartSpan(ctx, "record")
+	defer span.End()
+
+	format := batch.Format
+	if format == "" {
+		format = codec.DetectFormat(batch.Data)
+	}
+	batchesTotal.WithLabelValues(format).Inc()
+	batchBytes.Observe(float64(len(batch.Data)))
+
+	t.configMu.RLock()
+	var sampleRatio float64
+	var k int
+	if t.config != nil {
+		sampleRatio = t.config.SampleRatio
+		k = t.config.TopK
+	}
+	t.configMu.RUnlock()
+
+	if !fb.SampledBatch(batch.BatchID, sampleRatio) {
+		return
+	}
+
+	input, err := codec.Decode(batch)
+	if err != nil {
+		t.logger.Debug("Failed to decode sampled batch", map[string]interface{}{
+			"batch_id": batch.BatchID,
+			"format":   format,
+			"error":    err.Error(),
+		})
+		return
+	}
+	sampledBatchesTotal.Inc()
+	batchMetrics.Observe(float64(len(input)))
+
+	if k <= 0 {
+		return
+	}
+
+	t.namesMu.Lock()
+	defer t.namesMu.Unlock()
+	if t.names == nil {
+		return
+	}
+	for _, metric := range input {
+		if name, ok := metric["name"].(string); ok {
+			t.names.add(name)
+		}
+	}
+
+	// Names that dropped out of the top K are removed from the gauge
+	topMetricNames.Reset()
+	for _, entry := range t.names.top(k) {
+		topMetricNames.WithLabelValues(entry.name).Set(float64(entry.count))
+	}
+}
+
+// forwardToNextFB forwards the batch to the next function block
+func (t *Tap) forwardToNextFB(ctx context.Context, batch *fb.MetricBatch) (*fb.ProcessResult, error) {
+	// Fan out instead if several next FBs are configured
+	if t.FansOut() {
+		return t.ForwardToNextFBs(ctx, batch, t.sendToDLQ)
+	}
+
+	startTime := time.Now()
+
+	// Use circuit breaker to protect against downstream failures
+	err := t.nextFBBreaker.Execute(ctx, func(ctx context.Context) error {
+		// Ensure we have a connection to the next FB
+		if t.nextFBClient == nil {
+			return fmt.Errorf("no connection to next FB")
+		}
+
+		// Convert to ChainPushService request
+		req := &fb.MetricBatchRequest{
+			BatchId:          batch.BatchID,
+			Data:             batch.Data,
+			Format:           batch.Format,
+			Replay:           batch.Replay,
+			ConfigGeneration: batch.ConfigGeneration,
+			Metadata:         batch.Metadata,
+			InternalLabels:   batch.InternalLabels,
+		}
+
+		// Forward to next FB
+		res, err := t.nextFBClient.PushMetrics(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to next FB: %w", err)
+		}
+
+		// Check response
+		if res.Status != fb.StatusSuccess {
+			return fb.NewNextFBError("", res)
+		}
+
+		return nil
+	})
+
+	// Record metrics
+	t.metrics.RecordBatchForwarded(time.Since(startTime).Seconds())
+
+	if err != nil {
+		t.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeFailed)
+		if err == resilience.ErrCircuitOpen {
+			return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeCircuitBreakerOpen, err), err
+		}
+		return fb.NewCodeErrorResult(batch.BatchID, fb.ErrorCodeForwardingFailed, err), err
+	}
+
+	t.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeForwarded)
+	return fb.NewSuccessResult(batch.BatchID), nil
+}
+
+// sendToDLQ sends a batch to the Dead Letter Queue
+func (t *Tap) sendToDLQ(ctx context.Context, batch *fb.MetricBatch, originalErr error) error {
+	// Without a DLQ the batch is failed back to the caller
+	if !t.HasDLQ() {
+		return fb.ErrNoDLQ
+	}
+
+	// Ensure we have a connection to the DLQ
+	if t.dlqClient == nil {
+		return fmt.Errorf("no connection to DLQ")
+	}
+
+	// Add error info to internal labels
+	if batch.InternalLabels == nil {
+		batch.InternalLabels = make(map[string]string)
+	}
+	batch.InternalLabels["error"] = originalErr.Error()
+	batch.InternalLabels["fb_sender"] = t.Sender()
+
+	// Drop poison batches rather than cycle them through the DLQ forever
+	if t.RecordDLQHop(batch.InternalLabels) {
+		t.logger.Warn("Dropping poison batch", map[string]interface{}{
+			"batch_id":     batch.BatchID,
+			"replay_count": batch.InternalLabels[fb.LabelReplayCount],
+			"error":        originalErr.Error(),
+		})
+		return fb.ErrPoisonBatch
+	}
+
+	// Convert to ChainPushService request
+	req := &fb.MetricBatchRequest{
+		BatchId:          batch.BatchID,
+		Data:             batch.Data,
+		Format:           batch.Format,
+		Replay:           batch.Replay,
+		ConfigGeneration: batch.ConfigGeneration,
+		Metadata:         batch.Metadata,
+		InternalLabels:   batch.InternalLabels,
+	}
+
+	// Send to DLQ
+	err := t.dlqBreaker.Execute(ctx, func(ctx context.Context) error {
+		res, err := t.dlqClient.PushMetrics(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to DLQ: %w", err)
+		}
+
+		// Check response
+		if res.Status != fb.StatusSuccess {
+			return fmt.Errorf("DLQ returned error: %s (code: %s)", res.ErrorMessage, res.ErrorCode)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Record metric
+	t.metrics.RecordBatchDLQ()
+	t.metrics.RecordTenantBatch(fb.TenantID(batch), metrics.TenantOutcomeDropped)
+
+	return nil
+}
+
+// UpdateConfig updates the Tap function block's configuration
+func (t *Tap) UpdateConfig(ctx context.Context, configBytes []byte, generation int64) error {
+	// Create child span for config update
+	ctx, span := t.tracer.StartSpan(ctx, "update-config")
+	defer span.End()
+
+	// Parse and validate configuration
+	newConfig, err := t.parseConfig(configBytes)
+	if err != nil {
+		return err
+	}
+
+	// Refuse a generation older than the one applied, unless rolling back
+	if err := t.CheckConfigGeneration(generation, newConfig.Common.Rollback); err != nil {
+		t.logger.Error("Refused configuration", err, map[string]interface{}{
+			"generation":         generation,
+			"applied_generation": t.GetConfigGeneration(),
+		})
+		return err
+	}
+
+	// Apply configuration
+	t.configMu.Lock()
+	oldConfig := t.config
+	t.config = newConfig
+	t.SetConfigGeneration(generation)
+	t.SetEnabled(newConfig.Common.IsEnabled())
+	t.SetMaxConcurrentBatches(newConfig.Common.MaxConcurrentBatches)
+	t.SetMaxReplayCount(newConfig.Common.MaxReplayCount)
+	t.SetVerifyChecksum(newConfig.Common.VerifyChecksum)
+	t.SetHasDLQ(newConfig.Common.DLQ != "")
+	t.SetMetadataLimits(fb.MetadataLimits{
+		MaxEntries: newConfig.Common.MaxMetadataEntries,
+		MaxBytes:   newConfig.Common.MaxMetadataBytes,
+		Action:     fb.MetadataLimitAction(newConfig.Common.MetadataLimitAction),
+	})
+	if level, err := logging.ParseLevel(newConfig.Common.LogLevel); err == nil {
+		t.logger.SetLevel(level)
+	}
+	if newConfig.Common.TracingEnabled {
+		t.tracer.SetSamplingRatio(newConfig.Common.TraceSamplingRatio)
+	}
+	seed, _ := newConfig.Common.DeterministicSeed()
+	t.tracer.SetSeed(seed)
+	t.configMu.Unlock()
+
+	// Keep the counts of sampled names unless the top K size changes
+	t.namesMu.Lock()
+	capacity := newConfig.TopK * topKCapacityFactor
+	if capacity == 0 {
+		t.names = nil
+		topMetricNames.Reset()
+	} else if t.names == nil || t.names.capacity != capacity {
+		t.names = newTopK(capacity)
+		topMetricNames.Reset()
+	}
+	t.namesMu.Unlock()
+
+	// Update circuit breaker configuration
+	t.nextFBBreaker = resilience.NewCircuitBreaker("fb-tap", resilience.DownstreamNextFB, newConfig.Common.NextFBCircuitBreakerConfig())
+	t.dlqBreaker = resilience.NewCircuitBreaker("fb-tap", resilience.DownstreamDLQ, newConfig.Common.DLQCircuitBreakerConfig())
+
+	// Connect to next FB and DLQ if not already connected
+	if t.nextFBClient == nil && newConfig.Common.NextFB != "" {
+		if err := t.connectToNextFB(ctx, newConfig.Common.NextFB); err != nil {
+			t.logger.Error("Failed to connect to next FB", err, map[string]interface{}{
+				"next_fb": newConfig.Common.NextFB,
+			})
+			// Don't fail config update on connection error - we'll retry on next batch
+		}
+	}
+
+	if err := t.ConnectNextFBs(ctx, newConfig.Common, metrics.GRPCDialOption(t.Name())); err != nil {
+		t.logger.Error("Failed to connect to next FBs", err, map[string]interface{}{
+			"next_fbs": len(newConfig.Common.NextFBs),
+		})
+		// Don't fail config update on connection error - batches to the
+		// targets not connected fail until the next update
+	}
+
+	if t.dlqClient == nil && newConfig.Common.DLQ != "" {
+		if err := t.connectToDLQ(ctx, newConfig.Common.DLQ); err != nil {
+			t.logger.Error("Failed to connect to DLQ", err, map[string]interface{}{
+				"dlq": newConfig.Common.DLQ,
+			})
+			// Don't fail config update on connection error - we'll retry when needed
+		}
+	}
+
+	if err := t.ConnectQuarantine(ctx, newConfig.Common.Quarantine); err != nil {
+		t.logger.Error("Failed to connect to quarantine", err, map[string]interface{}{
+			"quarantine": newConfig.Common.Quarantine,
+		})
+		// Don't fail config update on connection error - we'll retry on next update
+	}
+
+	// Start processing now that config is applied
+	if err := t.ConfigApplied(); err != nil {
+		return err
+	}
+
+	// Update metrics
+	t.metrics.SetConfigGeneration(generation)
+	t.metrics.SetReady(true)
+
+	// Report what the update changed, for rollout audits
+	changes, _ := config.DiffConfig(oldConfig, newConfig)
+
+	t.logger.Info("Config updated", map[string]interface{}{
+		"generation":   generation,
+		"sample_ratio": newConfig.SampleRatio,
+		"top_k":        newConfig.TopK,
+		"changes":      changes,
+	})
+
+	return nil
+}
+
+// ValidateConfig parses and validates a configuration without applying it
+func (t *Tap) ValidateConfig(configBytes []byte) error {
+	_, err := t.parseConfig(configBytes)
+	return err
+}
+
+// parseConfig parses and validates a configuration
+func (t *Tap) parseConfig(configBytes []byte) (*TapConfig, error) {
+	// Merge a patch onto the applied config
+	t.configMu.RLock()
+	applied := t.config
+	t.configMu.RUnlock()
+	configBytes, err := config.ResolveMergePatch(configBytes, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	var newConfig TapConfig
+	if err := config.LoadConfigFromBytes(configBytes, &newConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if err := t.validateConfig(&newConfig); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &newConfig, nil
+}
+
+// validateConfig validates the Tap function block's configuration
+func (t *Tap) validateConfig(config *TapConfig) error {
+	// Validate common settings
+	if err := config.Common.Validate(); err != nil {
+		return err
+	}
+
+	// Check if next FB is configured
+	if !config.Common.HasNextFB() {
+		return fmt.Errorf("next FB not configured")
+	}
+
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		return fmt.Errorf("sample_ratio must be between 0 and 1, got %v", config.SampleRatio)
+	}
+
+	if config.TopK < 0 {
+		return fmt.Errorf("top_k must not be negative, got %d", config.TopK)
+	}
+
+	return nil
+}
+
+// connectToNextFB establishes a connection to the next function block
+func (t *Tap) connectToNextFB(ctx context.Context, nextFB string) error {
+	// Close existing connection if any
+	if t.nextFBConn != nil {
+		t.nextFBConn.Close()
+		t.nextFBConn = nil
+		t.nextFBClient = nil
+	}
+
+	// Create new connection
+	conn, err := t.DialContext(ctx, nextFB,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+		metrics.GRPCDialOption(t.Name()),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to next FB: %w", err)
+	}
+
+	t.nextFBConn = conn
+	t.nextFBClient = fb.NewChainPushServiceClient(conn)
+
+	return nil
+}
+
+// connectToDLQ establishes a connection to the DLQ function block
+func (t *Tap) connectToDLQ(ctx context.Context, dlqAddr string) error {
+	// Close existing connection if any
+	if t.dlqConn != nil {
+		t.dlqConn.Close()
+		t.dlqConn = nil
+		t.dlqClient = nil
+	}
+
+	// Create new connection
+	conn, err := t.DialContext(ctx, dlqAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to DLQ: %w", err)
+	}
+
+	t.dlqConn = conn
+	t.dlqClient = fb.NewChainPushServiceClient(conn)
+
+	return nil
+}
+
+// Shutdown shuts down the Tap function block
+func (t *Tap) Shutdown(ctx context.Context) error {
+	t.logger.Info("Shutting down FB-Tap", nil)
+
+	// Stop accepting batches
+	if err := t.Transition(fb.StateDraining); err != nil {
+		return err
+	}
+
+	// Let batches in flight finish, including their DLQ sends
+	drainErr := t.DrainBatches(ctx)
+	if drainErr != nil {
+		t.logger.Warn("Shutting down with batches still in flight", map[string]interface{}{
+			"error": drainErr.Error(),
+		})
+	}
+
+	// Close connections, the DLQ and quarantine last
+	if t.nextFBConn != nil {
+		t.nextFBConn.Close()
+		t.nextFBConn = nil
+		t.nextFBClient = nil
+	}
+	t.CloseNextFBs()
+
+	if t.dlqConn != nil {
+		t.dlqConn.Close()
+		t.dlqConn = nil
+		t.dlqClient = nil
+	}
+	t.CloseQuarantine()
+
+	// Mark as not ready
+	t.SetReady(false)
+	t.metrics.SetReady(false)
+
+	if err := t.Transition(fb.StateStopped); err != nil {
+		return err
+	}
+	return drainErr
+}
+
+// Testing helpers
+
+// SetNextFBClientForTesting sets the next FB client for testing purposes
+func (t *Tap) SetNextFBClientForTesting(client fb.ChainPushServiceClient) {
+	t.nextFBClient = client
+}
+
+// SetDLQClientForTesting sets the DLQ client for testing purposes
+func (t *Tap) SetDLQClientForTesting(client fb.ChainPushServiceClient) {
+	t.dlqClient = client
+}
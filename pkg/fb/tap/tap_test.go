@@ -0,0 +1,280 @@
+package tap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"eidc-tfk8s/internal/common/instance"
+	"eidc-tfk8s/internal/common/resilience"
+	"eidc-tfk8s/internal/config"
+	"eidc-tfk8s/pkg/fb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// newTestTap creates a running tap with the given sampling config
+func newTestTap(t *testing.T, sampleRatio float64, k int) *Tap {
+	tap := NewTap()
+	require.NoError(t, tap.Initialize(context.Background()))
+
+	tap.config = &TapConfig{SampleRatio: sampleRatio, TopK: k}
+	tap.names = newTopK(k * topKCapacityFactor)
+	require.NoError(t, tap.ConfigApplied())
+
+	return tap
+}
+
+func TestTap_ProcessBatch_ForwardsUnchangedAndRecordsMetrics(t *testing.T) {
+	tap := newTestTap(t, 1, 2)
+
+	var forwarded []*fb.MetricBatchRequest
+	tap.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			forwarded = append(forwarded, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	jsonBatches := testutil.ToFloat64(batchesTotal.WithLabelValues("json"))
+	sampledBatches := testutil.ToFloat64(sampledBatchesTotal)
+
+	batches := []*fb.MetricBatch{
+		{
+			BatchID:        "batch-1",
+			Data:           []byte(`[{"name":"cpu","value":1},{"name":"mem","value":2},{"name":"cpu","value":3}]`),
+			Format:         "json",
+			Metadata:       map[string]string{"tenant_id": "tenant-a"},
+			InternalLabels: map[string]string{"dedup_key": "key-1"},
+		},
+		{
+			// Untagged batches are counted under their detected format
+			BatchID: "batch-2",
+			Data:    []byte(`[{"name":"cpu","value":4},{"name":"disk","value":5}]`),
+		},
+	}
+
+	for _, batch := range batches {
+		data := append([]byte(nil), batch.Data...)
+		result, err := tap.ProcessBatch(context.Background(), batch)
+		require.NoError(t, err)
+		assert.Equal(t, fb.StatusSuccess, result.Status)
+
+		// The batch is forwarded as received
+		forwardedBatch := forwarded[len(forwarded)-1]
+		assert.Equal(t, batch.BatchID, forwardedBatch.BatchId)
+		assert.Equal(t, data, forwardedBatch.Data)
+		assert.Equal(t, batch.Format, forwardedBatch.Format)
+		assert.Equal(t, batch.Metadata, forwardedBatch.Metadata)
+		assert.Equal(t, batch.InternalLabels, forwardedBatch.InternalLabels)
+	}
+
+	assert.Equal(t, jsonBatches+2, testutil.ToFloat64(batchesTotal.WithLabelValues("json")))
+	assert.Equal(t, sampledBatches+2, testutil.ToFloat64(sampledBatchesTotal))
+
+	// Only the two most frequent names are reported
+	assert.Equal(t, 2, testutil.CollectAndCount(topMetricNames))
+	assert.Equal(t, float64(3), testutil.ToFloat64(topMetricNames.WithLabelValues("cpu")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(topMetricNames.WithLabelValues("disk")))
+}
+
+func TestTap_ProcessBatch_UnsampledBatchesAreNotDecoded(t *testing.T) {
+	tap := newTestTap(t, 0, 5)
+	tap.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{})
+
+	sampledBatches := testutil.ToFloat64(sampledBatchesTotal)
+
+	// Undecodable data is forwarded all the same
+	result, err := tap.ProcessBatch(context.Background(), &fb.MetricBatch{
+		BatchID: "batch-1",
+		Data:    []byte(`not a batch`),
+		Format:  "json",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, result.Status)
+	assert.Equal(t, sampledBatches, testutil.ToFloat64(sampledBatchesTotal))
+}
+
+func TestTap_Shutdown_DrainsBatchesBeforeClosingDLQ(t *testing.T) {
+	tap := newTestTap(t, 0, 5)
+
+	// The next FB holds the batch until released, then fails it
+	entered, release := make(chan struct{}), make(chan struct{})
+	tap.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			close(entered)
+			<-release
+			return nil, errors.New("unavailable")
+		},
+	})
+	var dlqBatches []*fb.MetricBatchRequest
+	tap.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqBatches = append(dlqBatches, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	type processed struct {
+		result *fb.ProcessResult
+		err    error
+	}
+	processedCh := make(chan processed, 1)
+	go func() {
+		result, err := tap.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: "batch-1", Data: []byte(`[]`)})
+		processedCh <- processed{result, err}
+	}()
+	<-entered
+
+	shutdownCh := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownCh <- tap.Shutdown(ctx)
+	}()
+
+	// Shutdown waits for the batch in flight, rejecting new ones meanwhile
+	assert.Eventually(t, func() bool { return tap.State() == fb.StateDraining }, time.Second, time.Millisecond)
+	result, err := tap.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: "batch-2"})
+	require.Error(t, err)
+	assert.Equal(t, fb.ErrorCodeServiceUnavailable, result.ErrorCode)
+	select {
+	case err := <-shutdownCh:
+		t.Fatalf("shutdown returned with a batch in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The forwarding failure still reaches the DLQ
+	close(release)
+	p := <-processedCh
+	require.Error(t, p.err)
+	assert.True(t, p.result.SentToDLQ)
+	require.NoError(t, <-shutdownCh)
+	require.Len(t, dlqBatches, 1)
+	assert.Equal(t, "batch-1", dlqBatches[0].BatchId)
+	assert.Equal(t, fb.StateStopped, tap.State())
+}
+
+func TestTap_DLQSenderIncludesInstanceID(t *testing.T) {
+	t.Setenv(instance.EnvPodName, "fb-tap-5c7d-x2k9q")
+	tap := newTestTap(t, 0, 5)
+
+	tap.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			return nil, errors.New("unavailable")
+		},
+	})
+	var dlqBatches []*fb.MetricBatchRequest
+	tap.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqBatches = append(dlqBatches, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	_, err := tap.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: "batch-1", Data: []byte(`[]`)})
+	require.Error(t, err)
+	require.Len(t, dlqBatches, 1)
+	assert.Equal(t, "fb-tap/fb-tap-5c7d-x2k9q", dlqBatches[0].InternalLabels["fb_sender"])
+}
+
+func TestTopK(t *testing.T) {
+	names := newTopK(3)
+	for _, name := range []string{"a", "a", "a", "b", "b", "c", "d"} {
+		names.add(name)
+	}
+
+	// "d" evicts the least frequent name and inherits its count
+	assert.Len(t, names.counts, 3)
+	assert.Equal(t, []nameCount{{name: "a", count: 3}, {name: "b", count: 2}}, names.top(2))
+	assert.Equal(t, int64(2), names.counts["d"])
+}
+
+func TestTap_ValidateConfig(t *testing.T) {
+	tap := NewTap()
+
+	config := &TapConfig{SampleRatio: 1.5}
+	config.Common.NextFB = "fb-next:5000"
+	err := tap.validateConfig(config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sample_ratio")
+
+	config.SampleRatio = 0.5
+	config.TopK = -1
+	err = tap.validateConfig(config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "top_k")
+}
+
+func TestDefaultConfig_MatchesParametersSchema(t *testing.T) {
+	configBytes, err := json.Marshal(DefaultConfig())
+	require.NoError(t, err)
+	assert.NoError(t, config.ValidateParameters("fb-tap", configBytes))
+}
+
+func TestTap_ProcessBatch_TerminalNextFBErrorDoesNotTripBreaker(t *testing.T) {
+	tap := newTestTap(t, 0, 5)
+
+	// The next FB fails every batch with a code retrying can't fix
+	tap.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			return &fb.MetricBatchResponse{Status: fb.StatusError, BatchId: in.BatchId, ErrorCode: string(fb.ErrorCodeInvalidInput), ErrorMessage: "bad data"}, nil
+		},
+	})
+	var dlqBatches, quarantined []*fb.MetricBatchRequest
+	tap.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			dlqBatches = append(dlqBatches, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+	tap.SetQuarantineClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			quarantined = append(quarantined, in)
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	// More failures than the breaker needs to open on retriable errors
+	batches := 2 * resilience.DefaultCircuitBreakerConfig().MinimumRequestCount
+	for i := 0; i < batches; i++ {
+		result, err := tap.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: fmt.Sprintf("batch-%d", i), Data: []byte(`[]`)})
+		require.Error(t, err)
+		assert.Equal(t, fb.ErrorCodeInvalidInput, result.ErrorCode)
+		assert.False(t, result.Retriable)
+	}
+
+	assert.Equal(t, resilience.StateClosed, tap.nextFBBreaker.GetState())
+	assert.Len(t, quarantined, batches)
+	assert.Equal(t, string(fb.ErrorCodeInvalidInput), quarantined[0].InternalLabels["error_code"])
+	assert.Empty(t, dlqBatches)
+}
+
+func TestTap_ProcessBatch_RetriableNextFBErrorTripsBreaker(t *testing.T) {
+	tap := newTestTap(t, 0, 5)
+
+	tap.SetNextFBClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			return &fb.MetricBatchResponse{Status: fb.StatusError, BatchId: in.BatchId, ErrorCode: string(fb.ErrorCodeServiceUnavailable)}, nil
+		},
+	})
+	tap.SetDLQClientForTesting(&fb.MockChainPushServiceClient{
+		PushMetricsFunc: func(ctx context.Context, in *fb.MetricBatchRequest, opts ...grpc.CallOption) (*fb.MetricBatchResponse, error) {
+			return &fb.MetricBatchResponse{Status: fb.StatusSuccess, BatchId: in.BatchId}, nil
+		},
+	})
+
+	for i := 0; i < resilience.DefaultCircuitBreakerConfig().MinimumRequestCount; i++ {
+		result, err := tap.ProcessBatch(context.Background(), &fb.MetricBatch{BatchID: fmt.Sprintf("batch-%d", i), Data: []byte(`[]`)})
+		require.Error(t, err)
+		assert.Equal(t, fb.ErrorCodeForwardingFailed, result.ErrorCode)
+		assert.True(t, result.SentToDLQ)
+	}
+
+	assert.Equal(t, resilience.StateOpen, tap.nextFBBreaker.GetState())
+}
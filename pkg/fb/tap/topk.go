@@ -0,0 +1,66 @@
+package tap
+
+import "sort"
+
+// nameCount is a metric name with its estimated number of occurrences
+type nameCount struct {
+	name  string
+	count int64
+}
+
+// topK estimates the most frequent metric names with the Space-Saving
+// algorithm, tracking at most capacity names however many distinct names the
+// traffic carries. Counts are overestimated by at most the count of the name
+// evicted to make room for them.
+type topK struct {
+	capacity int
+	counts   map[string]int64
+}
+
+// newTopK creates a tracker of at most capacity names
+func newTopK(capacity int) *topK {
+	return &topK{
+		capacity: capacity,
+		counts:   make(map[string]int64, capacity),
+	}
+}
+
+// add records an occurrence of name
+func (t *topK) add(name string) {
+	if _, ok := t.counts[name]; ok || len(t.counts) < t.capacity {
+		t.counts[name]++
+		return
+	}
+
+	// Replace the least frequent name, which the new one inherits the count
+	// of, as it may have occurred while untracked
+	var minName string
+	var minCount int64 = -1
+	for tracked, count := range t.counts {
+		if minCount < 0 || count < minCount || (count == minCount && tracked < minName) {
+			minName, minCount = tracked, count
+		}
+	}
+	delete(t.counts, minName)
+	t.counts[name] = minCount + 1
+}
+
+// top returns the k most frequent names, most frequent first, with ties
+// broken by name
+func (t *topK) top(k int) []nameCount {
+	names := make([]nameCount, 0, len(t.counts))
+	for name, count := range t.counts {
+		names = append(names, nameCount{name: name, count: count})
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].count != names[j].count {
+			return names[i].count > names[j].count
+		}
+		return names[i].name < names[j].name
+	})
+
+	if len(names) > k {
+		names = names[:k]
+	}
+	return names
+}
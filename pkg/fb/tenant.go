@@ -0,0 +1,32 @@
+package fb
+
+// LabelTenantID is the internal label holding the tenant a batch belongs to.
+// It travels with the batch through every FB's forward and DLQ paths.
+const LabelTenantID = "tenant_id"
+
+// TenantID returns the tenant stamped on the batch, or "" if there is none
+func TenantID(batch *MetricBatch) string {
+	return batch.InternalLabels[LabelTenantID]
+}
+
+// SetTenantID stamps the tenant on the batch
+func SetTenantID(batch *MetricBatch, tenantID string) {
+	if batch.InternalLabels == nil {
+		batch.InternalLabels = make(map[string]string)
+	}
+	batch.InternalLabels[LabelTenantID] = tenantID
+}
+
+// PropagateTenantID copies a tenant id supplied in the batch metadata (e.g.
+// from a request header at ingest) to the internal labels, unless the batch
+// already carries one. It returns the batch's tenant id.
+func PropagateTenantID(batch *MetricBatch) string {
+	if tenantID := TenantID(batch); tenantID != "" {
+		return tenantID
+	}
+	tenantID := batch.Metadata[LabelTenantID]
+	if tenantID != "" {
+		SetTenantID(batch, tenantID)
+	}
+	return tenantID
+}
@@ -0,0 +1,263 @@
+// Package harness chains real function blocks in process, connected over
+// bufconn, so tests can push a batch through the pipeline and assert what
+// each hop received. Any fb.FunctionBlock can be a stage, e.g.
+// RX→CL→DP→EN-HOST→AGG→GW; the last stage forwards to an "out" sink and
+// every stage dead-letters to a "dlq" sink.
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/codec"
+	"eidc-tfk8s/pkg/fb/snapshot"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Sinks terminating the chain, reported by Received like any stage
+const (
+	// SinkOut receives what the last stage forwards
+	SinkOut = "out"
+
+	// SinkDLQ receives what any stage dead-letters
+	SinkDLQ = "dlq"
+)
+
+// setupTimeout bounds initializing and configuring the stages
+const setupTimeout = 5 * time.Second
+
+// Stage is a function block in the chain and its parameters
+type Stage struct {
+	// Block is the function block under test. It is initialized and
+	// configured by the harness and shut down when the test ends. It must
+	// implement fb.Dialer, as function blocks embedding
+	// fb.BaseFunctionBlock do, so that it connects to the next stage in
+	// process.
+	Block fb.FunctionBlock
+
+	// Config is the JSON parameters of the block. The harness points
+	// common.next_fb and common.dlq at the next stage and the DLQ sink.
+	Config []byte
+}
+
+// Pipeline is a chain of function blocks served in process
+type Pipeline struct {
+	stages   []string
+	entry    fb.ChainPushServiceClient
+	recorder *snapshot.Recorder
+
+	// listeners serve the stages and sinks by address. It is filled before
+	// any stage is configured and only read afterwards.
+	listeners map[string]*bufconn.Listener
+
+	mu       sync.Mutex
+	received map[string][]*fb.MetricBatchRequest
+}
+
+// New serves the stages in process, chains them in order and applies their
+// config. The pipeline is torn down when the test ends.
+func New(t testing.TB, stages ...Stage) *Pipeline {
+	t.Helper()
+
+	if len(stages) == 0 {
+		t.Fatal("harness: no stages")
+	}
+
+	p := &Pipeline{
+		recorder:  snapshot.NewRecorder(),
+		listeners: make(map[string]*bufconn.Listener),
+		received:  make(map[string][]*fb.MetricBatchRequest),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+
+	// Serve every stage and sink before configuring any, so each stage can
+	// connect to the next one
+	seen := map[string]bool{SinkOut: true, SinkDLQ: true}
+	for _, stage := range stages {
+		if err := stage.Block.Initialize(ctx); err != nil {
+			t.Fatalf("harness: failed to initialize %s: %v", stage.Block.Name(), err)
+		}
+
+		name := stage.Block.Name()
+		if seen[name] {
+			t.Fatalf("harness: duplicate stage %s", name)
+		}
+		seen[name] = true
+
+		// Connect the stage to the others in process
+		dialer, ok := stage.Block.(fb.Dialer)
+		if !ok {
+			t.Fatalf("harness: %s doesn't implement fb.Dialer", name)
+		}
+		dialer.SetDialOptions(p.dialer())
+
+		p.stages = append(p.stages, name)
+		p.serve(t, name, fb.NewChainPushServiceHandler(stage.Block))
+
+		block := stage.Block
+		t.Cleanup(func() { block.Shutdown(context.Background()) })
+	}
+	p.serve(t, SinkOut, &fb.MockChainPushServiceServer{})
+	p.serve(t, SinkDLQ, &fb.MockChainPushServiceServer{})
+
+	for i, stage := range stages {
+		next := SinkOut
+		if i+1 < len(stages) {
+			next = p.stages[i+1]
+		}
+
+		configBytes, err := chainConfig(stage.Config, address(next), address(SinkDLQ))
+		if err != nil {
+			t.Fatalf("harness: invalid config for %s: %v", p.stages[i], err)
+		}
+		if err := stage.Block.UpdateConfig(ctx, configBytes, 1); err != nil {
+			t.Fatalf("harness: failed to configure %s: %v", p.stages[i], err)
+		}
+	}
+
+	conn, err := grpc.DialContext(ctx, address(p.stages[0]),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		p.dialer(),
+	)
+	if err != nil {
+		t.Fatalf("harness: failed to connect to %s: %v", p.stages[0], err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	p.entry = fb.NewChainPushServiceClient(conn)
+
+	return p
+}
+
+// serve serves a stage over an in-memory listener, recording what it
+// receives, at its address
+func (p *Pipeline) serve(t testing.TB, name string, handler fb.ChainPushServiceServer) {
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	fb.RegisterChainPushServiceServer(server, &recordingServer{next: handler, pipeline: p, stage: name})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	p.listeners[address(name)] = lis
+}
+
+// dialer returns the dial option connecting to the stages and sinks in
+// process, by address
+func (p *Pipeline) dialer() grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		lis, ok := p.listeners[addr]
+		if !ok {
+			return nil, fmt.Errorf("harness: nothing served at %s", addr)
+		}
+		return lis.DialContext(ctx)
+	})
+}
+
+// address is the in-process address a stage is served at
+func address(name string) string {
+	return "harness-" + name
+}
+
+// chainConfig points the common next_fb and dlq of an FB's JSON parameters
+// at the given addresses
+func chainConfig(configBytes []byte, nextFB, dlq string) ([]byte, error) {
+	parameters := make(map[string]interface{})
+	if len(configBytes) > 0 {
+		if err := json.Unmarshal(configBytes, &parameters); err != nil {
+			return nil, err
+		}
+	}
+
+	common, ok := parameters["common"].(map[string]interface{})
+	if !ok {
+		common = make(map[string]interface{})
+		parameters["common"] = common
+	}
+	common["next_fb"] = nextFB
+	common["dlq"] = dlq
+
+	return json.Marshal(parameters)
+}
+
+// Push sends a batch to the first stage
+func (p *Pipeline) Push(ctx context.Context, req *fb.MetricBatchRequest) (*fb.MetricBatchResponse, error) {
+	return p.entry.PushMetrics(ctx, req)
+}
+
+// Stages returns the names of the stages in chain order
+func (p *Pipeline) Stages() []string {
+	return append([]string(nil), p.stages...)
+}
+
+// Received returns the batches a stage or sink received, in order
+func (p *Pipeline) Received(stage string) []*fb.MetricBatchRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*fb.MetricBatchRequest(nil), p.received[stage]...)
+}
+
+// Metrics returns the decoded metrics of a batch as a stage or sink last
+// received it
+func (p *Pipeline) Metrics(stage, batchID string) ([]codec.Metric, bool) {
+	return p.recorder.Snapshot(stage, batchID)
+}
+
+// Diff compares a batch as two stages or sinks received it, so tests can
+// assert the transformation made by the hops in between
+func (p *Pipeline) Diff(batchID, beforeStage, afterStage string, opts snapshot.Options) (*snapshot.Diff, error) {
+	return p.recorder.DiffStages(batchID, beforeStage, afterStage, opts)
+}
+
+// record keeps a batch received by a stage
+func (p *Pipeline) record(stage string, req *fb.MetricBatchRequest) {
+	// Batches that can't be decoded are only kept as received
+	p.recorder.Record(stage, req)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.received[stage] = append(p.received[stage], cloneRequest(req))
+}
+
+// cloneRequest copies a request, so labels a stage adds to the batch after
+// receiving it don't show up in what it received
+func cloneRequest(req *fb.MetricBatchRequest) *fb.MetricBatchRequest {
+	clone := *req
+	clone.Data = append([]byte(nil), req.Data...)
+	clone.Metadata = cloneLabels(req.Metadata)
+	clone.InternalLabels = cloneLabels(req.InternalLabels)
+	return &clone
+}
+
+// cloneLabels copies a label map, keeping nil as nil
+func cloneLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	clone := make(map[string]string, len(labels))
+	for key, value := range labels {
+		clone[key] = value
+	}
+	return clone
+}
+
+// recordingServer records every batch a stage receives before handling it
+type recordingServer struct {
+	next     fb.ChainPushServiceServer
+	pipeline *Pipeline
+	stage    string
+}
+
+// PushMetrics implements fb.ChainPushServiceServer
+func (s *recordingServer) PushMetrics(ctx context.Context, in *fb.MetricBatchRequest) (*fb.MetricBatchResponse, error) {
+	s.pipeline.record(s.stage, in)
+	return s.next.PushMetrics(ctx, in)
+}
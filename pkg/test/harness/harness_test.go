@@ -0,0 +1,136 @@
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"eidc-tfk8s/internal/common/logging"
+	"eidc-tfk8s/internal/common/metrics"
+	"eidc-tfk8s/internal/common/tracing"
+	"eidc-tfk8s/pkg/fb"
+	"eidc-tfk8s/pkg/fb/cl"
+	"eidc-tfk8s/pkg/fb/codec"
+	"eidc-tfk8s/pkg/fb/dp"
+	enhost "eidc-tfk8s/pkg/fb/en-host"
+	"eidc-tfk8s/pkg/fb/gw"
+	"eidc-tfk8s/pkg/fb/rx"
+	"eidc-tfk8s/pkg/fb/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stageConfig(t *testing.T, config interface{}) []byte {
+	configBytes, err := json.Marshal(config)
+	require.NoError(t, err)
+	return configBytes
+}
+
+// rxStage returns an RX stage. Batches are pushed in memory, so RX needn't
+// listen on its endpoints.
+func rxStage(t *testing.T) Stage {
+	rxConfig := rx.DefaultConfig()
+	for i := range rxConfig.Endpoints {
+		rxConfig.Endpoints[i].Enabled = false
+	}
+	return Stage{Block: rx.NewRX(), Config: stageConfig(t, rxConfig)}
+}
+
+func TestPipeline_RXToDP(t *testing.T) {
+	pipeline := New(t,
+		rxStage(t),
+		Stage{Block: dp.NewDP(), Config: stageConfig(t, dp.DefaultConfig())},
+	)
+	assert.Equal(t, []string{"fb-rx", "fb-dp"}, pipeline.Stages())
+
+	// Duplicate metrics are dropped by FB-DP
+	res, err := pipeline.Push(context.Background(), &fb.MetricBatchRequest{
+		BatchId: "batch-1",
+		Format:  codec.FormatJSON,
+		Data: []byte(`[
+			{"name":"cpu","value":0.5,"timestamp":1700000000},
+			{"name":"cpu","value":0.5,"timestamp":1700000000},
+			{"name":"mem","value":1024,"timestamp":1700000000}
+		]`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, res.Status)
+
+	require.Len(t, pipeline.Received("fb-rx"), 1)
+	require.Len(t, pipeline.Received("fb-dp"), 1)
+	require.Len(t, pipeline.Received(SinkOut), 1)
+	assert.Empty(t, pipeline.Received(SinkDLQ))
+
+	// FB-RX stamps the ingest time before forwarding
+	assert.NotContains(t, pipeline.Received("fb-rx")[0].InternalLabels, fb.LabelIngestTimestamp)
+	assert.Contains(t, pipeline.Received("fb-dp")[0].InternalLabels, fb.LabelIngestTimestamp)
+
+	diff, err := pipeline.Diff("batch-1", "fb-rx", "fb-dp", snapshot.Options{})
+	require.NoError(t, err)
+	assert.True(t, diff.Empty())
+
+	diff, err = pipeline.Diff("batch-1", "fb-dp", SinkOut, snapshot.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cpu@1700000000#1"}, diff.RemovedMetrics)
+	assert.Empty(t, diff.AddedMetrics)
+	assert.Empty(t, diff.Metrics)
+
+	// Batches FB-DP can't decode are dead-lettered rather than forwarded
+	_, err = pipeline.Push(context.Background(), &fb.MetricBatchRequest{
+		BatchId: "batch-2",
+		Format:  "prometheus",
+		Data:    []byte(`cpu 0.5`),
+	})
+	require.NoError(t, err)
+
+	require.Len(t, pipeline.Received(SinkOut), 1)
+	dead := pipeline.Received(SinkDLQ)
+	require.NotEmpty(t, dead)
+	assert.Equal(t, "batch-2", dead[0].BatchId)
+	assert.Equal(t, "fb-dp", dead[0].InternalLabels["fb_sender"])
+}
+
+func TestPipeline_WholeChain(t *testing.T) {
+	classifier := cl.NewClassifier(logging.NewLogger("fb-cl"), metrics.NewFBMetrics("fb-cl"), tracing.NewTracer("fb-cl"), "test-salt-secret", "salt")
+	classifier.SetSaltLoader(func(ctx context.Context, secretName, secretKey string) (string, error) {
+		return "test salt", nil
+	})
+
+	clConfig := cl.DefaultConfig()
+	clConfig.SaltSecretName = "test-salt-secret"
+	clConfig.SaltSecretKey = "salt"
+
+	gwConfig := gw.DefaultConfig()
+	gwConfig.SchemaEnforce = false
+
+	pipeline := New(t,
+		rxStage(t),
+		Stage{Block: enhost.NewENHost(), Config: stageConfig(t, enhost.DefaultConfig())},
+		Stage{Block: classifier, Config: stageConfig(t, clConfig)},
+		Stage{Block: dp.NewDP(), Config: stageConfig(t, dp.DefaultConfig())},
+		Stage{Block: gw.NewGW(), Config: stageConfig(t, gwConfig)},
+	)
+	assert.Equal(t, []string{"fb-rx", "fb-en-host", "fb-cl", "fb-dp", "fb-gw"}, pipeline.Stages())
+
+	res, err := pipeline.Push(context.Background(), &fb.MetricBatchRequest{
+		BatchId: "batch-1",
+		Format:  codec.FormatJSON,
+		Data: []byte(`[
+			{"name":"cpu","value":0.5,"timestamp":1700000000,"labels":{"user_name":"alice"}},
+			{"name":"mem","value":1024,"timestamp":1700000000}
+		]`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, fb.StatusSuccess, res.Status)
+
+	// Every stage is connected to the next in process
+	for _, stage := range append(pipeline.Stages(), SinkOut) {
+		assert.Len(t, pipeline.Received(stage), 1, stage)
+	}
+	assert.Empty(t, pipeline.Received(SinkDLQ))
+
+	// FB-CL hashes the PII before it leaves the chain
+	out := pipeline.Received(SinkOut)
+	require.Len(t, out, 1)
+	assert.NotContains(t, string(out[0].Data), "alice")
+}